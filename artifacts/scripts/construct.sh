@@ -81,7 +81,14 @@ source "${SCRIPT_DIR}"/util.sh
 echo "Running garbage collection."
 git gc --auto
 echo "Fetching from origin."
-git fetch origin --no-tags
+if [ "${PUBLISHER_BOT_FETCH_SINGLE_BRANCH:-}" = "true" ]; then
+    # a new branch does not exist in origin yet
+    if git ls-remote --exit-code --heads origin "${DST_BRANCH}" >/dev/null; then
+        git fetch origin --no-tags "+refs/heads/${DST_BRANCH}:refs/remotes/origin/${DST_BRANCH}"
+    fi
+else
+    git fetch origin --no-tags
+fi
 echo "Cleaning up checkout."
 git rebase --abort >/dev/null || true
 git reset -q --hard
@@ -89,7 +96,13 @@ git clean -q -f -f -d
 git checkout -q $(git rev-parse HEAD) || true
 git branch -D "${DST_BRANCH}" >/dev/null || true
 git remote set-head origin -d >/dev/null # this let's filter-branch fail
-if git rev-parse origin/"${DST_BRANCH}" &>/dev/null; then
+if [ "${PUBLISHER_BOT_REBUILD_BRANCH:-}" = "true" ]; then
+    # the source branch was force-pushed and the published history is backed up,
+    # or verify-rewrite constructs the published history again
+    echo "Rebuilding ${DST_BRANCH} from scratch. Creating orphan ${DST_BRANCH} branch."
+    git checkout -q --orphan "${DST_BRANCH}"
+    git rm -q --ignore-unmatch -rf .
+elif git rev-parse origin/"${DST_BRANCH}" &>/dev/null; then
     echo "Switching to origin/${DST_BRANCH}."
     git branch -f "${DST_BRANCH}" origin/"${DST_BRANCH}" >/dev/null
     git checkout -q "${DST_BRANCH}"
@@ -100,19 +113,54 @@ else
     git rm -q --ignore-unmatch -rf .
 fi
 
+# source commits dropped by skip lists or trailers, for the run result
+export PUBLISHER_BOT_SKIPPED_COMMITS_FILE=$(pwd)/../skipped-commits-${REPO}-${DST_BRANCH}.txt
+: > "${PUBLISHER_BOT_SKIPPED_COMMITS_FILE}"
+
 # sync_repo cherry-picks the commits that change
 # k8s.io/kubernetes/staging/src/k8s.io/${REPO} to the ${DST_BRANCH}
 sync_repo "${SOURCE_REPO_ORG}" "${SOURCE_REPO_NAME}" "${SUBDIR}" "${SRC_BRANCH}" "${DST_BRANCH}" "${SOURCE_REMOTE}" "${DEPS}" "${REQUIRED}" "${BASE_PACKAGE}" "${IS_LIBRARY}" "${RECURSIVE_DELETE_PATTERN}"
 
 # add tags.
+if [ -n "${PUBLISHER_BOT_SIGNOFF:-}" ]; then
+    signoff-new-commits "${DST_BRANCH}"
+fi
+
 LAST_BRANCH=$(git rev-parse --abbrev-ref HEAD)
 LAST_HEAD=$(git rev-parse HEAD)
 EXTRA_ARGS=()
 PUSH_SCRIPT=../push-tags-${REPO}-${DST_BRANCH}.sh
 echo "#!/bin/bash" > ${PUSH_SCRIPT}
 chmod +x ${PUSH_SCRIPT}
+if [ -n "${PUBLISHER_BOT_TAG_CLASSES:-}" ]; then
+    EXTRA_ARGS+=(--classes "${PUBLISHER_BOT_TAG_CLASSES}")
+fi
+if [ -n "${PUBLISHER_BOT_TAG_SEMVER_MAJOR:-}" ]; then
+    EXTRA_ARGS+=(--semver-major "${PUBLISHER_BOT_TAG_SEMVER_MAJOR}")
+fi
+if [ -n "${PUBLISHER_BOT_TAG_INCLUDE:-}" ]; then
+    EXTRA_ARGS+=(--include "${PUBLISHER_BOT_TAG_INCLUDE}")
+fi
+if [ -n "${PUBLISHER_BOT_TAG_EXCLUDE:-}" ]; then
+    EXTRA_ARGS+=(--exclude "${PUBLISHER_BOT_TAG_EXCLUDE}")
+fi
+if [ -n "${PUBLISHER_BOT_TAG_TYPE:-}" ]; then
+    EXTRA_ARGS+=(--tag-type "${PUBLISHER_BOT_TAG_TYPE}")
+fi
+if [ -n "${PUBLISHER_BOT_TAG_MESSAGE:-}" ]; then
+    EXTRA_ARGS+=(--tag-message "${PUBLISHER_BOT_TAG_MESSAGE}")
+fi
+if [ -n "${PUBLISHER_BOT_TAGGER:-}" ]; then
+    EXTRA_ARGS+=(--tagger "${PUBLISHER_BOT_TAGGER}")
+fi
+if [ -n "${PUBLISHER_BOT_RELEASE_NOTES_URL:-}" ]; then
+    EXTRA_ARGS+=(--release-notes-url "${PUBLISHER_BOT_RELEASE_NOTES_URL}")
+fi
+if [ -n "${PUBLISHER_BOT_SIGNOFF:-}" ]; then
+    EXTRA_ARGS+=(--signoff "${PUBLISHER_BOT_SIGNOFF}")
+fi
 
-if [[ -z "${SKIP_TAGS}}" ]]; then
+if [[ -z "${SKIP_TAGS}" ]]; then
     /sync-tags --prefix "$(echo ${SOURCE_REPO_NAME})-" \
                --commit-message-tag $(echo ${SOURCE_REPO_NAME} | sed 's/^./\L\u&/')-commit \
                --source-remote upstream --source-branch "${SRC_BRANCH}" \
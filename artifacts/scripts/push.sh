@@ -14,29 +14,82 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-# This script sets up the .netrc file with the supplied token, then pushes to
-# the remote repo.
+# This script pushes to the remote repo (origin by default, or the given remote,
+# e.g. a push target). When run by the bot, git gets the token from the
+# credential helper of the bot, configured via GIT_CONFIG_PARAMETERS. Otherwise,
+# it sets up the .netrc file with the token in the given file. If PUSH_USERNAME
+# is set, it is used as login with the token as password.
+# PUSH_BRANCH_ALIASES is a space separated list of additional branch names the
+# branch is pushed to.
+# If PUSH_FORCE is true, branches and tags are force-pushed, e.g. to canary repos.
+# PUSH_NOTES_REF is a notes ref pushed with the branch if it exists. Origin gets
+# it fast-forward only, other remotes mirror it.
+# If PUSH_SKIP_TAGS is true, the tags are not pushed.
+# If PUSH_BRANCH_PREFIX is set, the branch and its aliases are force-pushed to
+# the prefixed names instead, e.g. to open pull requests from them. Branches
+# which do not exist in the remote yet are pushed directly.
 # The script assumes that the working directory is the root of the repo.
 
 set -o errexit
 set -o nounset
 set -o pipefail
 
-if [ ! $# -eq 2 ]; then
-    echo "usage: $0 token branch"
+if [ $# -lt 2 ] || [ $# -gt 3 ]; then
+    echo "usage: $0 token branch [remote]"
     exit 1
 fi
 
-TOKEN="$(cat ${1})"
 BRANCH="${2}"
-readonly TOKEN BRANCH
+REMOTE="${3:-origin}"
+readonly BRANCH REMOTE
 
-# set up github token in /netrc/.netrc
-echo "machine github.com login ${TOKEN}" > /netrc/.netrc
-cleanup_github_token() {
-    rm -rf /netrc/.netrc
+if [ -z "${PUBLISHER_BOT_CREDENTIAL_REF:-}" ]; then
+    # the host of the remote, e.g. github.com for https://github.com/kubernetes/client-go
+    # or for the scp-like git@github.com:kubernetes/client-go
+    HOST="$(git remote get-url "${REMOTE}" | sed -E -e 's#^[a-z+]+://([^@/]*@)?([^/:]+).*#\2#;t' -e 's#^([^@/:]*@)?([^/:]+):.*#\2#')"
+    readonly HOST
+
+    # set up the token in /netrc/.netrc
+    if [ -n "${PUSH_USERNAME:-}" ]; then
+        echo "machine ${HOST} login ${PUSH_USERNAME} password $(cat ${1})" > /netrc/.netrc
+    else
+        echo "machine ${HOST} login $(cat ${1})" > /netrc/.netrc
+    fi
+    cleanup_github_token() {
+        rm -rf /netrc/.netrc
+    }
+    trap cleanup_github_token EXIT SIGINT
+    export HOME=/netrc
+fi
+
+FORCE=""
+if [ "${PUSH_FORCE:-}" = "true" ]; then
+    FORCE="--force"
+fi
+readonly FORCE
+
+push_branch() {
+    local dst="${1}"
+    if [ -n "${PUSH_BRANCH_PREFIX:-}" ] && git ls-remote --exit-code --heads "${REMOTE}" "refs/heads/${dst}" >/dev/null; then
+        git push --force "${REMOTE}" "${BRANCH}:refs/heads/${PUSH_BRANCH_PREFIX}${dst}" --no-tags
+    else
+        git push ${FORCE} "${REMOTE}" "${BRANCH}:refs/heads/${dst}" --no-tags
+    fi
 }
-trap cleanup_github_token EXIT SIGINT
 
-HOME=/netrc git push origin "${BRANCH}" --no-tags
-HOME=/netrc ../push-tags-$(basename "${PWD}")-${BRANCH}.sh
+push_branch "${BRANCH}"
+for alias in ${PUSH_BRANCH_ALIASES:-}; do
+    push_branch "${alias}"
+done
+if [ -n "${PUSH_NOTES_REF:-}" ] && git rev-parse --verify -q "${PUSH_NOTES_REF}" >/dev/null; then
+    if [ "${REMOTE}" = "origin" ]; then
+        git push "${REMOTE}" "${PUSH_NOTES_REF}"
+    else
+        git push "${REMOTE}" "+${PUSH_NOTES_REF}"
+    fi
+fi
+if [ "${PUSH_SKIP_TAGS:-}" = "true" ]; then
+    echo "Skipping the push of the tags of ${BRANCH}."
+else
+    ../push-tags-$(basename "${PWD}")-${BRANCH}.sh "${REMOTE}"
+fi
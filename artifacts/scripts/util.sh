@@ -21,6 +21,21 @@ set -o nounset
 set -o pipefail
 set -o xtrace
 
+# the mainline branches of the source repository and of the published repository,
+# e.g. a source "main" branch might be published as "master".
+SOURCE_MAINLINE_BRANCH="${PUBLISHER_BOT_SOURCE_MAINLINE_BRANCH:-master}"
+MAINLINE_BRANCH="${PUBLISHER_BOT_MAINLINE_BRANCH:-master}"
+
+# how to publish commits which are empty after filtering (drop, keep or keep-with-marker)
+# and source merge commits (preserve or linearize).
+EMPTY_COMMITS="${PUBLISHER_BOT_EMPTY_COMMITS:-keep}"
+MERGE_COMMITS="${PUBLISHER_BOT_MERGE_COMMITS:-preserve}"
+
+# bump to invalidate cached filter results when the filter-branch call changes.
+# New versions of the filter binaries invalidate them automatically.
+FILTER_CACHE_VERSION=1
+FILTER_CACHE_MAX_AGE_DAYS=30
+
 # sync_repo() cherry picks the latest changes in k8s.io/kubernetes/<repo> to the
 # local copy of the repository to be published.
 #
@@ -160,6 +175,11 @@ sync_repo() {
     git remote rm upstream >/dev/null || true
     git remote add upstream "${kubernetes_remote}" >/dev/null
     git fetch -q upstream --no-tags
+    if [ -n "${PUBLISHER_BOT_SOURCE_PIN:-}" ]; then
+        # pretend that upstream ends at the pinned commit. The next fetch restores it.
+        echo "Publishing ${src_branch} only up to pinned source commit ${PUBLISHER_BOT_SOURCE_PIN}."
+        git update-ref refs/remotes/upstream/"${src_branch}" "${PUBLISHER_BOT_SOURCE_PIN}"
+    fi
     git branch -D filtered-branch >/dev/null || true
     git branch -f upstream-branch upstream/"${src_branch}"
     echo "Checked out source commit $(git rev-parse upstream-branch)."
@@ -172,7 +192,7 @@ sync_repo() {
     # Then select all new mainline commits on filtered-branch as ${f_mainline_commits}
     # to loop through them later.
     local f_mainline_commits=""
-    if [ "${new_branch}" = "true" ] && [ "${src_branch}" = master ]; then
+    if [ "${new_branch}" = "true" ] && [ "${src_branch}" = "${SOURCE_MAINLINE_BRANCH}" ]; then
         # new master branch
         filter-branch "${commit_msg_tag}" "${subdirectory}" "${recursive_delete_pattern}" ${src_branch} filtered-branch
 
@@ -193,9 +213,9 @@ sync_repo() {
         # - old branch which continue with the last old commit.
         if [ "${new_branch}" = "true" ]; then
             # new non-master branch
-            local k_branch_point_commit=$(git-fork-point upstream/${src_branch} upstream/master)
+            local k_branch_point_commit=$(git-fork-point upstream/${src_branch} upstream/${SOURCE_MAINLINE_BRANCH})
             if [ -z "${k_branch_point_commit}" ]; then
-                echo "Couldn't find a branch point of upstream/${src_branch} and upstream/master."
+                echo "Couldn't find a branch point of upstream/${src_branch} and upstream/${SOURCE_MAINLINE_BRANCH}."
                 return 1
             fi
             echo "Using branch point ${k_branch_point_commit} as new starting point for new branch ${dst_branch}."
@@ -207,9 +227,9 @@ sync_repo() {
             # for a new branch that is not master: map filtered-branch-base to our ${dst_branch} as ${dst_branch_point_commit}
             local k_branch_point_commit=$(kube-commit ${commit_msg_tag} filtered-branch-base) # k_branch_point_commit will probably different thanthe k_branch_point_commit
                                                                             # above because filtered drops commits and maps to ancestors if necessary
-            local dst_branch_point_commit=$(branch-commit ${commit_msg_tag} ${k_branch_point_commit} master)
+            local dst_branch_point_commit=$(branch-commit ${commit_msg_tag} ${k_branch_point_commit} ${MAINLINE_BRANCH})
             if [ -z "${dst_branch_point_commit}" ]; then
-                echo "Couldn't find a corresponding branch point commit for ${k_branch_point_commit} as ascendent of origin/master."
+                echo "Couldn't find a corresponding branch point commit for ${k_branch_point_commit} as ascendent of origin/${MAINLINE_BRANCH}."
                 return 1
             fi
 
@@ -275,7 +295,7 @@ sync_repo() {
                 # it's on the mainline itself, no merge above it
                 k_new_pending_merge_commit=""
             fi
-            if [ ${dst_branch} != master ] && is-merge-with-master "${k_mainline_commit}"; then
+            if [ ${dst_branch} != ${MAINLINE_BRANCH} ] && is-merge-with-master "${k_mainline_commit}"; then
                 # merges with master on non-master branches we always handle as pending merge commit.
                 k_new_pending_merge_commit=${k_mainline_commit}
             fi
@@ -287,10 +307,10 @@ sync_repo() {
             #    (ii) it's dropped on the filtered-branch, i.e. fast-forward
             # b) it's another merge
             local dst_parent2="HEAD"
-            if [ ${dst_branch} != master ] && is-merge-with-master "${k_pending_merge_commit}"; then
+            if [ ${dst_branch} != ${MAINLINE_BRANCH} ] && is-merge-with-master "${k_pending_merge_commit}"; then
                 # it's a merge with master. Recreate this merge on ${dst_branch} with ${dst_parent2} as second parent on the master branch
                 local k_parent2="$(git rev-parse ${k_pending_merge_commit}^2)"
-                read k_parent2 dst_parent2 <<<$(look -b ${k_parent2} ../kube-commits-$(basename "${PWD}")-master)
+                read k_parent2 dst_parent2 <<<$(look -b ${k_parent2} ../kube-commits-$(basename "${PWD}")-${MAINLINE_BRANCH})
                 if [ -z "${dst_parent2}" ]; then
                     echo "Corresponding $(dirname ${PWD}) master branch commit not found for upstream master merge ${k_pending_merge_commit}. Odd."
                     return 1
@@ -334,11 +354,13 @@ sync_repo() {
         fi
 
         # is it a merge or a single commit on the mainline to apply?
-        if [ ${dst_branch} != master ] && is-merge-with-master ${k_mainline_commit}; then
+        if [ ${dst_branch} != ${MAINLINE_BRANCH} ] && is-merge-with-master ${k_mainline_commit}; then
             echo "Deferring master merge commit ${k_mainline_commit}: $(commit-subject ${f_mainline_commit})."
-        elif [ ${dst_branch} != master ] && [ -n "${k_pending_merge_commit}" ] && is-merge-with-master "${k_pending_merge_commit}"; then
+        elif [ ${dst_branch} != ${MAINLINE_BRANCH} ] && [ -n "${k_pending_merge_commit}" ] && is-merge-with-master "${k_pending_merge_commit}"; then
             echo "Skipping master commit ${k_mainline_commit}: $(commit-subject ${f_mainline_commit}). Master merge commit ${k_pending_merge_commit} is pending."
-        elif ! is-merge ${f_mainline_commit} || pick-merge-as-single-commit ${k_mainline_commit}; then
+        elif ! is-merge ${f_mainline_commit} && is-skipped-source-commit ${k_mainline_commit}; then
+            echo "Dropping k8s.io/kubernetes single-commit ${k_mainline_commit} because it is on the skip list: $(commit-subject ${f_mainline_commit})."
+        elif ! is-merge ${f_mainline_commit} || pick-merge-as-single-commit ${k_mainline_commit} || [ "${MERGE_COMMITS}" = linearize ]; then
             local pick_args=""
             if is-merge ${f_mainline_commit}; then
                 pick_args="-m 1"
@@ -364,6 +386,7 @@ sync_repo() {
 
             # potentially squash godep reset commit
             squash ${squash_commits}
+            apply-empty-commit-policy
 
             # if there is no pending merge commit, update Godeps.json because this could be a target of tag
             if [ -z "${k_pending_merge_commit}" ]; then
@@ -428,6 +451,11 @@ sync_repo() {
                 f_first_pick_base=${f_latest_merge_commit}
             fi
             for f_commit in $(git log --format='%H' --reverse ${f_first_pick_base}..${f_mainline_commit}^2); do
+                if is-skipped-source-commit $(kube-commit ${commit_msg_tag} ${f_commit}); then
+                    echo "Dropping k8s.io/kubernetes branch-commit $(kube-commit ${commit_msg_tag} ${f_commit}) because it is on the skip list: $(commit-subject ${f_commit})."
+                    continue
+                fi
+
                 # reset Godeps.json?
                 local squash_commits=1
                 if godep-changes ${f_commit}; then
@@ -446,6 +474,7 @@ sync_repo() {
 
                 # potentially squash godep reset commit
                 squash ${squash_commits}
+                apply-empty-commit-policy
             done
 
             # commit empty PR merge. This will carry the actual SHA1 from the upstream commit. It will match tags as well.
@@ -491,7 +520,7 @@ sync_repo() {
 
     # create look-up file for collapsed upstream commits
     local repo=$(basename ${PWD})
-    if [ -n "$(git log --oneline --first-parent --merges | head -n 1)" ]; then
+    if [ -n "$(git log --oneline --first-parent --merges | head -n 1)" ] || [ "${MERGE_COMMITS}" = linearize ]; then
         echo "Writing k8s.io/kubernetes commit lookup table to ../kube-commits-${repo}-${dst_branch}"
         /collapsed-kube-commit-mapper --commit-message-tag $(echo ${source_repo_name} | sed 's/^./\L\u&/')-commit --source-branch refs/heads/upstream-branch > ../kube-commits-${repo}-${dst_branch}
     else
@@ -507,6 +536,69 @@ function pick-merge-as-single-commit() {
 EOF
 }
 
+# is-skipped-source-commit succeeds if the given source commit must not be published, i.e.
+# - if it is (a prefix of) one of the space separated PUBLISHER_BOT_SKIP_SOURCE_COMMITS,
+# - if it has a "Publishing-bot: skip-all" trailer or a "Publishing-bot-skip: <repo>, ..." trailer
+#   naming the destination repo ${REPO},
+# - or if its message matches one of the PUBLISHER_BOT_SKIP_SOURCE_COMMIT_PATTERNS (extended regexps,
+#   one per line), unless it has a "Publishing-bot-force: <repo>, ..." trailer naming ${REPO}.
+# The reason is recorded via record-skipped-source-commit.
+function is-skipped-source-commit() {
+    local k_commit="${1}"
+    local c=""
+    for c in ${PUBLISHER_BOT_SKIP_SOURCE_COMMITS:-}; do
+        if [[ "${k_commit}" == "${c}"* ]]; then
+            record-skipped-source-commit ${k_commit} "skip-source-commits"
+            return 0
+        fi
+    done
+    if [ "$(commit-trailer ${k_commit} Publishing-bot)" = "skip-all" ]; then
+        record-skipped-source-commit ${k_commit} "trailer Publishing-bot: skip-all"
+        return 0
+    fi
+    if trailer-names-repo ${k_commit} Publishing-bot-skip; then
+        record-skipped-source-commit ${k_commit} "trailer Publishing-bot-skip: ${REPO}"
+        return 0
+    fi
+    if [ -n "${PUBLISHER_BOT_SKIP_SOURCE_COMMIT_PATTERNS:-}" ] &&
+       commit-message ${k_commit} | grep -E -q -f <(echo "${PUBLISHER_BOT_SKIP_SOURCE_COMMIT_PATTERNS}"); then
+        if trailer-names-repo ${k_commit} Publishing-bot-force; then
+            echo "Publishing ${k_commit} matching skip-source-commit-patterns because of its Publishing-bot-force trailer."
+            return 1
+        fi
+        record-skipped-source-commit ${k_commit} "skip-source-commit-patterns"
+        return 0
+    fi
+    return 1
+}
+
+# commit-trailer prints the values of the given trailer of a commit, one per line. Without
+# git interpret-trailers in old git versions, any line of the last paragraph starting with
+# the trailer key counts. A message consisting of the subject only has no trailers.
+function commit-trailer() {
+    commit-message ${1} | awk 'BEGIN { RS = "" } { last = $0 } END { if (NR > 1) print last }' | sed -n "s/^${2}: *//p"
+}
+
+# trailer-names-repo succeeds if the comma separated values of the given trailer of a commit
+# include the destination repo ${REPO}.
+function trailer-names-repo() {
+    local r=""
+    for r in $(commit-trailer ${1} ${2} | tr ',' ' '); do
+        if [ "${r}" = "${REPO:-}" ]; then
+            return 0
+        fi
+    done
+    return 1
+}
+
+# record-skipped-source-commit appends the dropped source commit with the reason to the
+# file PUBLISHER_BOT_SKIPPED_COMMITS_FILE for the run result.
+function record-skipped-source-commit() {
+    if [ -n "${PUBLISHER_BOT_SKIPPED_COMMITS_FILE:-}" ]; then
+        echo "${1} ${2}" >> "${PUBLISHER_BOT_SKIPPED_COMMITS_FILE}"
+    fi
+}
+
 # amend-godeps-at checks out the Godeps.json at the given commit and amend it to the previous commit.
 function amend-godeps-at() {
     if [ -f Godeps/Godeps.json ]; then
@@ -555,7 +647,115 @@ function filter-branch() {
             index_filter+=" '${p}'"
         done
     fi
-    git filter-branch -f --index-filter "${index_filter}" --msg-filter 'awk 1 && echo && echo "'"${commit_msg_tag}"': ${GIT_COMMIT}"' --subdirectory-filter "${subdirectory}" -- ${4} ${5} >/dev/null
+    if [ -n "${PUBLISHER_BOT_IMPORT_REWRITES:-}" ]; then
+        # runs before the subdirectory filter, i.e. on the source paths
+        index_filter+="${index_filter:+ && }/rewrite-imports --prefix '${subdirectory}' --rules \"\${PUBLISHER_BOT_IMPORT_REWRITES}\"${PUBLISHER_BOT_IMPORT_REWRITE_FORMAT:+ --format '${PUBLISHER_BOT_IMPORT_REWRITE_FORMAT}'}"
+    fi
+    if [ -n "${PUBLISHER_BOT_FILTERS:-}" ] || [ -n "${PUBLISHER_BOT_SUBMODULES:-}" ] || [ -n "${PUBLISHER_BOT_SYMLINKS:-}" ]; then
+        # submodules, symlinks and custom filters of the rules, in order after the import rewrites
+        index_filter+="${index_filter:+ && }/filter-tree --prefix '${subdirectory}'"
+    fi
+    local msg_filter='awk 1 && echo && echo "'"${commit_msg_tag}"': ${GIT_COMMIT}"'
+
+    # filtering is deterministic. Hence, the result is cached keyed by the source
+    # commits and everything the filter depends on, such that reruns, e.g. after
+    # transient push failures, skip identical rewrites.
+    local cache_dir="$(git rev-parse --git-dir)/filter-cache"
+    local cache_key=$(filter-cache-key "${index_filter}" "${msg_filter}" "${subdirectory}" ${4} ${5})
+    if filter-cache-restore "${cache_dir}/${cache_key}"; then
+        echo "Reusing cached filter result ${cache_key}."
+        return 0
+    fi
+
+    git filter-branch -f --index-filter "${index_filter}" --msg-filter "${msg_filter}" --subdirectory-filter "${subdirectory}" -- ${4} ${5} >/dev/null
+
+    local ref sha entries=""
+    for ref in ${4} ${5}; do
+        sha=$(git rev-parse -q --verify "${ref}" || true)
+        if [ -z "${sha}" ]; then
+            return 0 # deleted by filter-branch because all commits were dropped
+        fi
+        entries+="${ref} ${sha}"$'\n'
+    done
+    mkdir -p "${cache_dir}"
+    find "${cache_dir}" -type f -mtime +${FILTER_CACHE_MAX_AGE_DAYS} -delete
+    printf '%s' "${entries}" > "${cache_dir}/${cache_key}.tmp"
+    mv "${cache_dir}/${cache_key}.tmp" "${cache_dir}/${cache_key}"
+}
+
+# filter-cache-key prints the cache key of a filter-branch call with the given
+# index filter, message filter and subdirectory filter on the given refs. It
+# covers the source commits, the settings of the filter binaries and their
+# versions, i.e. the checksums of the binaries called by the index filter and of
+# the formatter of rewrite-imports.
+function filter-cache-key() {
+    local index_filter="${1}"
+    local msg_filter="${2}"
+    local subdirectory="${3}"
+    shift 3
+
+    local words=() word tools=""
+    IFS=" " read -ra words <<<"${index_filter}"
+    for word in "${words[@]-}"; do
+        if [[ "${word}" == /* ]] && [ -f "${word}" ]; then
+            tools+="$(sha1sum "${word}")"$'\n'
+        fi
+    done
+    local format="${PUBLISHER_BOT_IMPORT_REWRITE_FORMAT:-}"
+    if [ -n "${format}" ]; then
+        # gofmt comes with Go, goimports is a separate binary
+        tools+="$(go version) $(sha1sum "$(command -v "${format}")" 2>/dev/null || true)"
+    fi
+
+    printf '%s\n' "${FILTER_CACHE_VERSION}" "${index_filter}" "${msg_filter}" "${subdirectory}" \
+        "${PUBLISHER_BOT_IMPORT_REWRITES:-}" "${format}" "${PUBLISHER_BOT_FILTERS:-}" "${PUBLISHER_BOT_SUBMODULES:-}" \
+        "${PUBLISHER_BOT_SUBMODULE_URLS:-}" "${PUBLISHER_BOT_SYMLINKS:-}" "${tools}" "$(git rev-parse "$@")" | sha1sum | cut -d' ' -f1
+}
+
+# signoff-new-commits adds a Signed-off-by trailer of PUBLISHER_BOT_SIGNOFF to
+# the commits of the given branch which are not on origin, unless they have it
+# already. Identities and dates are kept, such that constructing the branch
+# again gives the same commits.
+function signoff-new-commits() {
+    local branch="${1}"
+    local range="${branch}"
+    if git rev-parse -q --verify "origin/${branch}" >/dev/null; then
+        range="origin/${branch}..${branch}"
+    fi
+    if [ -z "$(git rev-list -n 1 ${range})" ]; then
+        return 0
+    fi
+    echo "Signing off the new commits of ${branch} as ${PUBLISHER_BOT_SIGNOFF}."
+    # without git interpret-trailers --if-exists in old git versions, the trailer is added
+    # to the last paragraph if it consists of trailers only, and as new paragraph otherwise.
+    # The filter runs in the shell of filter-branch, without the functions of this file.
+    local filter=""
+    read -r -d '' filter <<'EOF' || true
+msg="$(cat)"
+trailer="Signed-off-by: ${PUBLISHER_BOT_SIGNOFF}"
+printf '%s\n' "${msg}"
+if ! printf '%s\n' "${msg}" | grep -qxF "${trailer}"; then
+    printf '%s\n' "${msg}" | awk 'BEGIN { RS = "" } { last = $0 } END { if (NR < 2) exit 1; n = split(last, l, "\n"); for (i = 1; i <= n; i++) if (l[i] !~ /^[A-Za-z0-9-]+: /) exit 1 }' || echo
+    echo "${trailer}"
+fi
+EOF
+    git filter-branch -f --msg-filter "${filter}" -- ${range} >/dev/null
+}
+
+# filter-cache-restore resets the branches of a cached filter result and the
+# working tree. It fails if the result is not cached or its commits were garbage
+# collected.
+function filter-cache-restore() {
+    local cache_file="${1}"
+    [ -f "${cache_file}" ] || return 1
+    local ref sha
+    while read ref sha; do
+        git cat-file -e "${sha}^{commit}" 2>/dev/null || return 1
+    done < "${cache_file}"
+    while read ref sha; do
+        git update-ref "refs/heads/${ref}" "${sha}"
+    done < "${cache_file}"
+    git reset -q --hard
 }
 
 function is-merge() {
@@ -565,7 +765,7 @@ function is-merge() {
 }
 
 function is-merge-with-master() {
-    if ! grep -q "^Merge remote-tracking branch 'origin/master'" <<<"$(short-commit-message ${1})"; then
+    if ! grep -q "^Merge remote-tracking branch 'origin/${SOURCE_MAINLINE_BRANCH}'" <<<"$(short-commit-message ${1})"; then
         return 1
     fi
 }
@@ -650,8 +850,17 @@ function apply-recursive-delete-pattern() {
     fi
 }
 
+# fix-godeps updates the dependency metadata of the branch with the tool in
+# PUBLISHER_BOT_DEPENDENCY_TOOL: godep (the default) for Godeps/Godeps.json and vendor/,
+# go-mod for go.mod, or none. It does nothing if PUBLISHER_BOT_SKIP_DEP_RESTORE
+# is true.
 function fix-godeps() {
-    if [ "${PUBLISHER_BOT_SKIP_GODEPS:-}" = true ]; then
+    local tool="${PUBLISHER_BOT_DEPENDENCY_TOOL:-godep}"
+    if [ "${tool}" = none ]; then
+        return 0
+    fi
+    if [ "${PUBLISHER_BOT_SKIP_DEP_RESTORE:-}" = "true" ]; then
+        echo "Skipping the dependency update."
         return 0
     fi
 
@@ -665,7 +874,10 @@ function fix-godeps() {
     local recursive_delete_pattern="${8}"
 
     local dst_old_commit=$(git rev-parse HEAD)
-    if [ "${needs_godeps_update}" = true ]; then
+    if [ "${tool}" = go-mod ]; then
+        checkout-deps-to-kube-commit "${commit_msg_tag}" "${deps}"
+        update-deps-in-gomod "${deps}" "${base_package}"
+    elif [ "${needs_godeps_update}" = true ]; then
         # run godeps restore+save
         update_full_godeps "${deps}" "${base_package}" "${is_library}" "${commit_msg_tag}"
     elif [ -f Godeps/Godeps.json ]; then
@@ -677,8 +889,8 @@ function fix-godeps() {
     fi
 
     # remove vendor/ on non-master branches for libraries
-    if [ "$(git rev-parse --abbrev-ref HEAD)" != master ] && [ -d vendor/ ] && [ "${is_library}" = "true" ]; then
-        echo "Removing vendor/ on non-master branch because this is a library"
+    if [ "$(git rev-parse --abbrev-ref HEAD)" != "${MAINLINE_BRANCH}" ] && [ -d vendor/ ] && [ "${is_library}" = "true" ]; then
+        echo "Removing vendor/ on non-${MAINLINE_BRANCH} branch because this is a library"
         git rm -q -rf vendor/
         if ! git-index-clean; then
             git commit -q -m "sync: remove vendor/"
@@ -750,6 +962,24 @@ function squash() {
     GIT_COMMITTER_DATE=$(committer-date ${head}) git commit --allow-empty -q -C ${head}
 }
 
+# apply-empty-commit-policy drops or marks the HEAD commit if it does not change anything
+# compared to its parent, depending on EMPTY_COMMITS. Root and merge commits are kept.
+function apply-empty-commit-policy() {
+    if [ "$(git rev-list --parents -n 1 HEAD | wc -w)" -ne 2 ] || [ "$(git rev-parse HEAD^{tree})" != "$(git rev-parse HEAD^^{tree})" ]; then
+        return 0
+    fi
+    case "${EMPTY_COMMITS}" in
+    drop)
+        echo "Dropping empty commit: $(commit-subject HEAD)."
+        # the index and the working dir are at the tree of the parent already
+        git update-ref HEAD HEAD^
+        ;;
+    keep-with-marker)
+        GIT_COMMITTER_DATE=$(committer-date HEAD) git commit -q --amend --allow-empty -m "$(commit-message HEAD; echo "Empty-after-filtering: true")"
+        ;;
+    esac
+}
+
 # This function updates vendor/ and Godeps/Godeps.json.
 #
 # "deps" lists the dependent k8s.io/* repos and branches. For example, if the
@@ -757,6 +987,8 @@ function squash() {
 # expected to be "apimachinery:release-1.6,client-go:release-3.0". Dependencies
 # are expected to be separated by ",", and the name of the dependent repo and
 # the branch name are expected to be separated by ":".
+# A dependency pinned to a tag or commit has it as third field, e.g.
+# "apimachinery::v0.1.0".
 #
 # "is_library" indicates if the repo being published is a library.
 #
@@ -825,8 +1057,8 @@ function update_full_godeps() {
     mv Godeps/Godeps.json.clean Godeps/Godeps.json
 
     if [ "${is_library}" = "true" ]; then
-        if [ "$(git rev-parse --abbrev-ref HEAD)" != master ]; then
-            echo "Removing complete vendor/ on non-master branch because this is a library."
+        if [ "$(git rev-parse --abbrev-ref HEAD)" != "${MAINLINE_BRANCH}" ]; then
+            echo "Removing complete vendor/ on non-${MAINLINE_BRANCH} branch because this is a library."
             rm -rf vendor/
         else
             echo "Removing k8s.io/*, gofuzz, go-openapi and glog from vendor/ because this is a library."
@@ -918,6 +1150,72 @@ update-deps-in-godep-json() {
     ensure-clean-working-dir
 }
 
+# update the go.mod requirements of the dependencies, and their replacements if any, to
+# the versions checked out. Their go.sum lines are dropped because new dependency commits
+# are not published yet when the branch is constructed.
+update-deps-in-gomod() {
+    if [ ! -f go.mod ]; then
+        return 0
+    fi
+
+    local base_package="${2}"
+    local deps_array=()
+    IFS=',' read -a deps_array <<< "${1}"
+    local dep_count=${#deps_array[@]}
+    for (( i=0; i<${dep_count}; i++ )); do
+        local dep=""
+        local branch=""
+        local pin=""
+        IFS=: read dep branch pin <<<"${deps_array[i]}"
+        local module="${base_package}/${dep}"
+
+        if ! grep -q "^[[:space:]]*\(require[[:space:]]\+\)\?${module} " go.mod; then
+            echo "Ignoring ${module} dependency because it is not required in go.mod."
+            continue
+        fi
+        local version="$(gomod-version ../${dep} "${pin}")"
+        echo "Updating ${module} dependency to ${version}."
+        go mod edit -require "${module}@${version}"
+        if grep -q "^[[:space:]]*\(replace[[:space:]]\+\)\?${module} =>" go.mod; then
+            go mod edit -replace "${module}=${module}@${version}"
+        fi
+        if [ -f go.sum ]; then
+            sed -i "\#^${module} #d" go.sum
+        fi
+    done
+
+    git add go.mod
+    if [ -f go.sum ]; then
+        git add go.sum
+    fi
+
+    # check if there are new contents
+    if git-index-clean; then
+        echo "go.mod hasn't changed!"
+    else
+        echo "Committing go.mod and go.sum."
+        git commit -q -m "sync: update go.mod"
+    fi
+
+    # nothing should be left
+    ensure-clean-working-dir
+}
+
+# gomod-version prints the module version of the commit checked out in the repository
+# $1: the pinned tag $2 if it is a semantic version, otherwise a pseudo-version.
+gomod-version() {
+    local pin="${2}"
+    if [[ "${pin}" =~ ^v[0-9]+\.[0-9]+\.[0-9]+ ]]; then
+        echo "${pin}"
+        return 0
+    fi
+    pushd ${1} >/dev/null
+        local commit=$(git rev-parse HEAD)
+        local date=$(TZ=UTC git show -s --date=format-local:%Y%m%d%H%M%S --format=%cd HEAD)
+    popd >/dev/null
+    echo "v0.0.0-${date}-${commit:0:12}"
+}
+
 # checkout the dependencies to the versions corresponding to the kube commit of HEAD
 checkout-deps-to-kube-commit() {
     local commit_msg_tag="${1}"
@@ -937,8 +1235,28 @@ checkout-deps-to-kube-commit() {
 
     local dep_count=${#deps[@]}
     for (( i=0; i<${dep_count}; i++ )); do
-        local dep="${deps[i]%%:*}"
-        local branch="${deps[i]##*:}"
+        local dep=""
+        local branch=""
+        local pin=""
+        IFS=: read dep branch pin <<<"${deps[i]}"
+
+        if [ -n "${pin}" ]; then
+            # pinned to a tag or commit of the published dependency
+            pushd ../${dep} >/dev/null
+                if ! git rev-parse -q --verify "${pin}^{commit}" >/dev/null; then
+                    git fetch -q --no-tags origin "+refs/tags/${pin}:refs/tags/${pin}" || true
+                fi
+                local pin_commit=$(git rev-parse -q --verify "${pin}^{commit}" || true)
+                if [ -z "${pin_commit}" ]; then
+                    echo "Could not find pinned revision ${pin} of k8s.io/${dep}."
+                    popd >/dev/null
+                    return 1
+                fi
+                echo "Checking out k8s.io/${dep} to pinned ${pin} (${pin_commit})"
+                git checkout -q "${pin_commit}"
+            popd >/dev/null
+            continue
+        fi
 
         echo "Looking up which commit in the ${branch} branch of k8s.io/${dep} corresponds to k8s.io/kubernetes commit ${k_last_kube_merge}."
         local k_commit=""
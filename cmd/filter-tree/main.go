@@ -0,0 +1,113 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/pkg/filter"
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, `Run the filters of the rules on the git index, e.g. as index filter of
+"git filter-branch":
+
+    git filter-branch --index-filter '%s --prefix staging/src/k8s.io/api'
+
+The filters are run in order on the files below the given prefix. They are
+given as JSON list of {"name"|"command": "...", "args": [...]}
+objects, by default in $PUBLISHER_BOT_FILTERS. The source commit is taken from
+$GIT_COMMIT.
+
+Before the filters, submodules below the prefix are dropped, preserved with a
+.gitmodules file in the prefix, or materialized as the files of the submodule
+commit, by default as given in $PUBLISHER_BOT_SUBMODULES. Submodule URLs are
+rewritten with space separated <from>=<to> URL prefixes, by default from
+$PUBLISHER_BOT_SUBMODULE_URLS. Then, symlinks below the prefix pointing outside
+of it fail the rewrite, are dropped, or are materialized as copies of their
+targets, by default as given in $PUBLISHER_BOT_SYMLINKS.
+
+Filters compiled into this binary:%s
+
+Usage: %s [--prefix <dir>] [--filters <json>]
+          [--submodules drop|preserve|materialize] [--submodule-urls <from>=<to>...]
+          [--symlinks error|drop|materialize]
+`, os.Args[0], registered(), os.Args[0])
+	flag.PrintDefaults()
+}
+
+func registered() string {
+	s := ""
+	for _, name := range filter.Registered() {
+		s += "\n    " + name
+	}
+	if s == "" {
+		return " none"
+	}
+	return s
+}
+
+func main() {
+	prefix := flag.String("prefix", "", "the directory in the index to filter, e.g. the published source directory")
+	filters := flag.String("filters", os.Getenv("PUBLISHER_BOT_FILTERS"), "the filters as JSON list")
+	submodules := flag.String("submodules", os.Getenv("PUBLISHER_BOT_SUBMODULES"), "how submodules are published: drop, preserve or materialize. Empty keeps the gitlinks only")
+	submoduleURLs := flag.String("submodule-urls", os.Getenv("PUBLISHER_BOT_SUBMODULE_URLS"), "space separated <from>=<to> URL prefix rewrites of submodules")
+	symlinks := flag.String("symlinks", os.Getenv("PUBLISHER_BOT_SYMLINKS"), "how symlinks pointing outside of the prefix are published: error, drop or materialize. Empty keeps them")
+
+	flag.Usage = Usage
+	flag.Parse()
+
+	if *submodules != "" {
+		urls, err := filter.ParseURLRewrites(*submoduleURLs)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		s := filter.Submodules{Mode: *submodules, URLs: urls}
+		if err := s.Apply(os.Getenv("GIT_COMMIT"), *prefix); err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	if *symlinks != "" {
+		s := filter.Symlinks{Policy: *symlinks}
+		if err := s.Apply(os.Getenv("GIT_COMMIT"), *prefix); err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	steps, err := filter.ParseSteps(*filters)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if len(steps) == 0 {
+		return
+	}
+	tree, err := filter.NewIndexTree(*prefix)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if err := filter.Run(steps, os.Getenv("GIT_COMMIT"), tree); err != nil {
+		glog.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		glog.Fatal(err)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/secrets"
+)
+
+// maxForeignCommits is the number of commits not created by the bot listed per
+// destination repo.
+const maxForeignCommits = 3
+
+// destinationReport is the outcome of verifying a destination repo.
+type destinationReport struct {
+	repo   string
+	status []string
+	err    error
+}
+
+// verifyDestination checks that the clone of the destination repo in repoDir
+// can be published to, before the first publishing run.
+func verifyDestination(ctx context.Context, cfg config.Config, rule config.RepositoryRule, repoDir string, identity config.GitIdentity) destinationReport {
+	r := destinationReport{repo: rule.DestinationRepository}
+	status, err := verifyHistory(repoDir, config.CommitMsgTag(cfg.SourceRepo), identity.Email)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.status = append(r.status, status)
+	if status, err = verifyPushRights(ctx, cfg, rule); err != nil {
+		r.err = err
+		return r
+	}
+	r.status = append(r.status, status)
+	return r
+}
+
+// verifyHistory checks that the branches of the destination repo in repoDir
+// were published by the bot before, or are empty, or only have commits of the
+// bot. Other commits would make the first publishing run fail or be lost.
+func verifyHistory(repoDir, msgTag, botEmail string) (string, error) {
+	cmd := exec.Command("git", "log", "--remotes=origin", "-n", "1", "--format=%H", "--grep", "^"+msgTag+": ")
+	cmd.Dir = repoDir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to look for published commits: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "" {
+		return "published before", nil
+	}
+
+	cmd = exec.Command("git", "log", "--remotes=origin", "--format=%h%x00%ce%x00%s")
+	cmd.Dir = repoDir
+	if out, err = cmd.Output(); err != nil {
+		return "", fmt.Errorf("failed to list commits: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if lines[0] == "" {
+		return "empty", nil
+	}
+	var foreign []string
+	n := 0
+	for _, l := range lines {
+		fs := strings.SplitN(l, "\x00", 3)
+		if len(fs) != 3 || fs[1] == botEmail {
+			continue
+		}
+		n++
+		if len(foreign) < maxForeignCommits {
+			foreign = append(foreign, fmt.Sprintf("%s %q by %s", fs[0], fs[2], fs[1]))
+		}
+	}
+	if n > 0 {
+		return "", fmt.Errorf("%d commits not created by the bot, e.g. %s", n, strings.Join(foreign, ", "))
+	}
+	return "only commits of the bot", nil
+}
+
+// verifyPushRights checks via the GitHub API that the push token of the
+// destination repo can push to it. Without token it fails, as the bot could not
+// push either.
+func verifyPushRights(ctx context.Context, cfg config.Config, rule config.RepositoryRule) (string, error) {
+	switch {
+	case cfg.DryRun:
+		return "push rights not checked in dry-run mode", nil
+	case cfg.GithubHost != "github.com":
+		return "push rights not checked on " + cfg.GithubHost, nil
+	case rule.Metadata.Archived:
+		return "push rights not checked for archived repository", nil
+	}
+	ref, err := cfg.PushTokenRef(rule.DestinationRepository)
+	if err != nil {
+		return "", fmt.Errorf("cannot check push rights: %v", err)
+	}
+	s, err := secrets.Parse(ref, cfg.KubernetesSecretsDir)
+	if err != nil {
+		return "", err
+	}
+	bs, err := s.Value()
+	if err != nil {
+		return "", fmt.Errorf("failed to load push token: %v", err)
+	}
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: strings.TrimSpace(string(bs))})
+	client := github.NewClient(oauth2.NewClient(ctx, ts))
+	repo, _, err := client.Repositories.Get(ctx, cfg.TargetOrg, rule.DestinationRepository)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s/%s: %v", cfg.TargetOrg, rule.DestinationRepository, err)
+	}
+	if repo.Permissions == nil || !(*repo.Permissions)["push"] {
+		return "", fmt.Errorf("the push token %s cannot push to %s/%s", s, cfg.TargetOrg, rule.DestinationRepository)
+	}
+	return "push rights", nil
+}
+
+// printDestinationReports logs the reports and fails if any destination repo
+// cannot be published to.
+func printDestinationReports(reports []destinationReport) {
+	var failed []string
+	for _, r := range reports {
+		if r.err != nil {
+			glog.Errorf("Destination repository %s: FAILED: %v", r.repo, r.err)
+			failed = append(failed, r.repo)
+			continue
+		}
+		glog.Infof("Destination repository %s: %s", r.repo, strings.Join(r.status, ", "))
+	}
+	if len(failed) > 0 {
+		glog.Fatalf("Cannot publish to %s. Fix the repositories or run with -verify-destinations=false.", strings.Join(failed, ", "))
+	}
+}
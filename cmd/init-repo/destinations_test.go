@@ -0,0 +1,91 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestVerifyHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "init-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(dir, email string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL="+email, "GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL="+email)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	const bot = "bot@example.com"
+	// clone returns a clone of a new repo with commits by the given committers
+	// and messages.
+	clone := func(name string, commits ...string) string {
+		origin := filepath.Join(dir, name+"-origin")
+		git(dir, bot, "init", "-q", origin)
+		for i := 0; i+1 < len(commits); i += 2 {
+			git(origin, commits[i], "commit", "-q", "--allow-empty", "-m", commits[i+1])
+		}
+		git(dir, bot, "clone", "-q", origin, name)
+		return filepath.Join(dir, name)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		commits  []string
+		expected string
+		err      string
+	}{
+		{"empty", nil, "empty", ""},
+		{"bot", []string{bot, "Initial commit"}, "only commits of the bot", ""},
+		{"published", []string{"dev@example.com", "Initial commit", bot, "Fix\n\nKubernetes-commit: 123"}, "published before", ""},
+		{"foreign", []string{bot, "sync: update go.mod", "dev@example.com", "Initial commit"}, "", "1 commits not created by the bot"},
+	} {
+		status, err := verifyHistory(clone(tc.name, tc.commits...), "Kubernetes-commit", bot)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil || status != tc.expected {
+			t.Errorf("%s: expected %q, got %q, %v", tc.name, tc.expected, status, err)
+		}
+	}
+}
+
+func TestVerifyPushRightsWithoutToken(t *testing.T) {
+	cfg := config.Config{GithubHost: "github.com", TargetOrg: "kubernetes"}
+	if _, err := verifyPushRights(context.Background(), cfg, config.RepositoryRule{DestinationRepository: "client-go"}); err == nil {
+		t.Errorf("expected an error without push token")
+	}
+	cfg.DryRun = true
+	if _, err := verifyPushRights(context.Background(), cfg, config.RepositoryRule{DestinationRepository: "client-go"}); err != nil {
+		t.Errorf("expected no check in dry-run mode, got: %v", err)
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"go/build"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 
 	"github.com/golang/glog"
 	yaml "gopkg.in/yaml.v2"
@@ -14,6 +17,7 @@ import (
 	"strings"
 
 	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/toolchain"
 )
 
 const (
@@ -23,13 +27,22 @@ const (
 )
 
 var (
-	SystemGoPath = os.Getenv("GOPATH")
+	SystemGoPath = systemGoPath()
 	BaseRepoPath = filepath.Join(SystemGoPath, "src", "k8s.io")
 )
 
+// systemGoPath returns the first GOPATH entry, or the default GOPATH.
+func systemGoPath() string {
+	if list := filepath.SplitList(os.Getenv("GOPATH")); len(list) > 0 {
+		return list[0]
+	}
+	return build.Default.GOPATH
+}
+
 func Usage() {
 	fmt.Fprintf(os.Stderr, `
-Usage: %s [-config <config-yaml-file>] [-source-repo <repo>] [-source-org <org>] [-rules-file <file> ] [-skip-godep|skip-dep] [-target-org <org>]
+Usage: %s [-config <config-yaml-file>] [-source-repo <repo>] [-source-org <org>] [-source-url <git-url>] [-source-seed <bundle-or-tarball>]
+          [-rules-file <file> ] [-skip-godep|skip-dep] [-target-org <org>] [-repair] [-verify-destinations=false]
 
 Command line flags override config values.
 `, os.Args[0])
@@ -43,17 +56,22 @@ func main() {
 		"otherwise github-host/target-org)")
 	repoName := flag.String("source-repo", "", "the name of the source repository (eg. kubernetes)")
 	repoOrg := flag.String("source-org", "", "the name of the source repository organization, (eg. kubernetes)")
+	sourceSeed := flag.String("source-seed", "", "a git bundle or tarball of a clone to initialize the source repository from, as local file, https://, gs:// or s3:// URL")
+	sourceURL := flag.String("source-url", "", "an arbitrary git URL of the source repository (defaults to https://<github-host>/<source-org>/<source-repo>)")
 	rulesFile := flag.String("rules-file", "", "the file with repository rules")
 	targetOrg := flag.String("target-org", "", `the target organization to publish into (e.g. "k8s-publishing-bot")`)
 	skipGodep := flag.Bool("skip-godep", false, `skip godeps installation and godeps-restore`)
 	skipDep := flag.Bool("skip-dep", false, `skip 'dep'' installation`)
+	preinstallGo := flag.Bool("preinstall-go-versions", false, "install the Go versions of all branch rules, not only the default one, e.g. to warm up the cache")
+	verifyDestinations := flag.Bool("verify-destinations", true, "fail unless each destination repo is empty, published by the bot before or only has commits of the bot, and its push token can push to it")
+	repair := flag.Bool("repair", false, "detect and fix corruption of existing clones, e.g. stale locks, interrupted fetches, wrong remotes and shallow clones, and re-clone those beyond repair")
 
 	flag.Usage = Usage
 	flag.Parse()
 
 	cfg := config.Config{}
 	if *configFilePath != "" {
-		bs, err := ioutil.ReadFile(*configFilePath)
+		bs, err := config.ReadFile(*configFilePath)
 		if err != nil {
 			glog.Fatalf("Failed to load config file from %q: %v", *configFilePath, err)
 		}
@@ -71,6 +89,12 @@ func main() {
 	if *repoOrg != "" {
 		cfg.SourceOrg = *repoOrg
 	}
+	if *sourceURL != "" {
+		cfg.SourceURL = *sourceURL
+	}
+	if *sourceSeed != "" {
+		cfg.SourceSeed = *sourceSeed
+	}
 	if *githubHost != "" {
 		cfg.GithubHost = *githubHost
 	}
@@ -78,25 +102,51 @@ func main() {
 		cfg.BasePackage = *basePackage
 	}
 
+	if err := cfg.Fetch.Validate(); err != nil {
+		glog.Fatalf("Invalid fetch configuration: %v", err)
+	}
+	if err := cfg.Network.Setup(context.Background(), glog.Warningf); err != nil {
+		glog.Fatal(err)
+	}
+	// the git and go commands get the TLS settings through the environment of
+	// the process, which serves only this config
+	tlsEnv, err := cfg.Network.TLS.Env()
+	if err != nil {
+		glog.Fatalf("Failed to apply the TLS settings: %v", err)
+	}
+	for _, kv := range tlsEnv {
+		ss := strings.SplitN(kv, "=", 2)
+		os.Setenv(ss[0], ss[1])
+	}
+
 	if cfg.GithubHost == "" {
 		cfg.GithubHost = "github.com"
 	}
-	// defaulting when base package is not specified
-	if cfg.BasePackage == "" {
-		if cfg.SourceRepo == "kubernetes" {
-			cfg.BasePackage = "k8s.io"
-		} else {
-			cfg.BasePackage = filepath.Join(cfg.GithubHost, cfg.TargetOrg)
+	cfg.BasePackage = config.DefaultBasePackage(&cfg)
+
+	// without GOPATH the repos live in the work dir and branches are built
+	// in module mode, so neither godep nor dep are needed.
+	gopathMode := cfg.WorkDir == ""
+	if !gopathMode {
+		if !filepath.IsAbs(cfg.WorkDir) {
+			glog.Fatalf("work-dir must be an absolute path, got %q", cfg.WorkDir)
 		}
+		SystemGoPath = cfg.GoPath()
 	}
-
-	BaseRepoPath = filepath.Join(SystemGoPath, "src", cfg.BasePackage)
+	BaseRepoPath = cfg.BaseRepoPath(cfg.BasePackage)
 
 	if *rulesFile != "" {
 		cfg.RulesFile = *rulesFile
 	}
 
-	if len(cfg.SourceRepo) == 0 || len(cfg.SourceOrg) == 0 {
+	if cfg.SourceURL != "" {
+		if err := config.ValidateGitURL(cfg.SourceURL); err != nil {
+			glog.Fatalf("Invalid source-url: %v", err)
+		}
+		if cfg.SourceRepo == "" {
+			cfg.SourceRepo = config.RepoNameFromURL(cfg.SourceURL)
+		}
+	} else if len(cfg.SourceRepo) == 0 || len(cfg.SourceOrg) == 0 {
 		glog.Fatalf("source-org and source-repo cannot be empty")
 	}
 
@@ -106,113 +156,137 @@ func main() {
 
 	// If RULE_FILE_PATH is detected, check if the source repository include rules files.
 	if len(os.Getenv("RULE_FILE_PATH")) > 0 {
-		cfg.RulesFile = filepath.Join(BaseRepoPath, cfg.SourceRepo, os.Getenv("RULE_FILE_PATH"))
+		cfg.RulesFile = filepath.Join(cfg.SourceDir(BaseRepoPath), os.Getenv("RULE_FILE_PATH"))
 	}
 
 	if len(cfg.RulesFile) == 0 {
 		glog.Fatalf("No rules file provided")
 	}
-	rules, err := config.LoadRules(cfg.RulesFile)
+	rules, err := config.LoadRules(cfg.RulesFile, cfg.Network.TLS)
 	if err != nil {
 		glog.Fatalf("Failed to load rules: %v", err)
 	}
 
+	// other Go versions are installed by the publisher on demand
 	goVersions := []string{DefaultGoVersion}
-	for _, rule := range rules.Rules {
-		for _, branch := range rule.Branches {
-			if branch.GoVersion != "" {
-				found := false
-				for _, v := range goVersions {
-					if v == branch.GoVersion {
-						found = true
+	if *preinstallGo {
+		for _, rule := range rules.Rules {
+			for _, branch := range rule.Branches {
+				if branch.GoVersion != "" {
+					found := false
+					for _, v := range goVersions {
+						if v == branch.GoVersion {
+							found = true
+						}
+					}
+					if !found {
+						goVersions = append(goVersions, branch.GoVersion)
 					}
-				}
-				if !found {
-					goVersions = append(goVersions, branch.GoVersion)
 				}
 			}
 		}
 	}
-	for _, v := range goVersions {
-		installGoVersion(v, filepath.Join(SystemGoPath, "go-"+v))
-	}
-	goLink, target := filepath.Join(SystemGoPath, "go"), filepath.Join(SystemGoPath, "go-"+DefaultGoVersion)
-	os.Remove(goLink)
-	if err := os.Symlink(target, goLink); err != nil {
-		glog.Fatalf("Failed to link %s to %s: %s", goLink, target, err)
+	manifest := toolchain.LoadManifest(SystemGoPath)
+	if toolchain.Supported() {
+		transport, err := cfg.Network.TLS.Transport()
+		if err != nil {
+			glog.Fatalf("Failed to apply the TLS settings: %v", err)
+		}
+		client := &http.Client{Transport: transport}
+		for _, v := range goVersions {
+			ctx, cancel := context.Background(), func() {}
+			if timeout := cfg.Timeout("toolchain"); timeout > 0 {
+				ctx, cancel = context.WithTimeout(context.Background(), timeout)
+			}
+			_, err := toolchain.InstallGo(ctx, client, manifest, SystemGoPath, v, cfg.Network.GoToolchainURL(v))
+			cancel()
+			if err != nil {
+				glog.Fatalf("Failed to install go %s: %v", v, err)
+			}
+		}
+		linkDefaultGo(filepath.Join(SystemGoPath, "go"), toolchain.GoRoot(SystemGoPath, DefaultGoVersion))
+	} else {
+		glog.Warningf("Skipping the installation of go %s: toolchains are only downloaded on linux. Using the local go installation.", strings.Join(goVersions, ", "))
 	}
 
 	if err := os.MkdirAll(BaseRepoPath, os.ModePerm); err != nil {
 		glog.Fatalf("Failed to create source repo directory %s: %v", BaseRepoPath, err)
 	}
 
-	if !*skipGodep {
-		installGodeps()
+	if !*skipGodep && gopathMode {
+		installGodeps(manifest)
 	}
-	if !*skipDep {
-		installDep()
+	if !*skipDep && gopathMode {
+		installDep(manifest)
 	}
 
-	cloneSourceRepo(cfg, *skipGodep)
+	cloneSourceRepo(cfg, *skipGodep && gopathMode, *repair)
+	var reports []destinationReport
 	for _, rule := range rules.Rules {
-		cloneForkRepo(cfg, rule.DestinationRepository)
+		identity := cfg.GitIdentityFor(rule)
+		if err := identity.Validate(); err != nil {
+			glog.Fatalf("Invalid git-identity for %s: %v", rule.DestinationRepository, err)
+		}
+		baseDir := BaseRepoPath
+		if bp := config.CleanBasePackage(rule.BasePackage); bp != "" && bp != cfg.BasePackage && gopathMode {
+			baseDir = filepath.Join(SystemGoPath, "src", filepath.FromSlash(bp))
+		}
+		cloneForkRepo(cfg, baseDir, rule.DestinationRepository, identity, *repair)
+		if *verifyDestinations && !rule.Skip {
+			reports = append(reports, verifyDestination(context.Background(), cfg, rule, filepath.Join(baseDir, rule.DestinationRepository), identity))
+		}
 	}
+	printDestinationReports(reports)
 }
 
-func installGoVersion(v string, pth string) {
-	if s, err := os.Stat(pth); err != nil && !os.IsNotExist(err) {
-		glog.Fatal(err)
-	} else if err == nil {
-		if s.IsDir() {
-			glog.Infof("Found existing go %s at %s", v, pth)
+// linkDefaultGo points the go symlink in GOPATH to the default Go version. On
+// Windows, where symlinks usually need special privileges, it falls back to
+// asking for GOROOT to be set.
+func linkDefaultGo(goLink, target string) {
+	os.Remove(goLink)
+	if err := os.Symlink(target, goLink); err != nil {
+		if runtime.GOOS == "windows" {
+			glog.Warningf("Cannot link %s to %s: %v. Set GOROOT=%s instead.", goLink, target, err, target)
 			return
 		}
-		glog.Fatalf("Expected %s to be a directory", pth)
-	}
-
-	glog.Infof("Installing go %s to %s", v, pth)
-	tmpPath, err := ioutil.TempDir(SystemGoPath, "go-tmp-")
-	if err != nil {
-		glog.Fatal(err)
-	}
-	defer os.RemoveAll(tmpPath)
-	cmd := exec.Command("/bin/bash", "-c", fmt.Sprintf("curl -SLf https://storage.googleapis.com/golang/go%s.linux-amd64.tar.gz | tar -xz --strip 1 -C %s", v, tmpPath))
-	cmd.Dir = tmpPath
-	run(cmd)
-	if err := os.Rename(tmpPath, pth); err != nil {
-		glog.Fatal(err)
+		glog.Fatalf("Failed to link %s to %s: %s", goLink, target, err)
 	}
 }
 
-func cloneForkRepo(cfg config.Config, repoName string) {
+func cloneForkRepo(cfg config.Config, baseDir, repoName string, identity config.GitIdentity, repair bool) {
 	forkRepoLocation := fmt.Sprintf("https://%s/%s/%s", cfg.GithubHost, cfg.TargetOrg, repoName)
-	repoDir := filepath.Join(BaseRepoPath, repoName)
+	repoDir := filepath.Join(baseDir, repoName)
 
+	if _, err := os.Stat(repoDir); err == nil && repair && !repairRepo(cfg, repoDir, forkRepoLocation) {
+		moveAside(repoDir)
+	}
 	if _, err := os.Stat(repoDir); err == nil {
 		glog.Infof("Fork repository %q already cloned to %s, resetting remote URL ...", repoName, repoDir)
 		setUrlCmd := exec.Command("git", "remote", "set-url", "origin", forkRepoLocation)
 		setUrlCmd.Dir = repoDir
 		run(setUrlCmd)
 		os.Remove(filepath.Join(repoDir, ".git", "index.lock"))
-		return
+	} else {
+		glog.Infof("Cloning fork repository %s ...", forkRepoLocation)
+		if err := os.MkdirAll(baseDir, 0755); err != nil {
+			glog.Fatalf("Failed to create %s: %v", baseDir, err)
+		}
+		cloneCmd := exec.Command("git", "clone", forkRepoLocation)
+		cloneCmd.Dir = baseDir
+		run(cloneCmd)
 	}
 
-	glog.Infof("Cloning fork repository %s ...", forkRepoLocation)
-	run(exec.Command("git", "clone", forkRepoLocation))
-
-	// TODO: This can be set as an env variable for the container
-	setUsernameCmd := exec.Command("git", "config", "user.name", os.Getenv("GIT_COMMITTER_NAME"))
+	setUsernameCmd := exec.Command("git", "config", "user.name", identity.Name)
 	setUsernameCmd.Dir = repoDir
 	run(setUsernameCmd)
 
-	// TODO: This can be set as an env variable for the container
-	setEmailCmd := exec.Command("git", "config", "user.email", os.Getenv("GIT_COMMITTER_EMAIL"))
+	setEmailCmd := exec.Command("git", "config", "user.email", identity.Email)
 	setEmailCmd.Dir = repoDir
 	run(setEmailCmd)
 }
 
-func installGodeps() {
-	if _, err := exec.LookPath("godep"); err == nil {
+func installGodeps(manifest *toolchain.Manifest) {
+	if manifest.UpToDate("godep", godepCommit) {
 		glog.Infof("Already installed: godep")
 		return
 	}
@@ -227,10 +301,14 @@ func installGodeps() {
 	godepInstallCmd := exec.Command("go", "install", "./...")
 	godepInstallCmd.Dir = godepDir
 	run(godepInstallCmd)
+
+	if err := manifest.Record("godep", godepCommit, filepath.Join(SystemGoPath, "bin", "godep")); err != nil {
+		glog.Fatal(err)
+	}
 }
 
-func installDep() {
-	if _, err := exec.LookPath("dep"); err == nil {
+func installDep(manifest *toolchain.Manifest) {
+	if manifest.UpToDate("dep", depCommit) {
 		glog.Infof("Already installed: dep")
 		return
 	}
@@ -246,6 +324,10 @@ func installDep() {
 	depInstallCmd := exec.Command("go", "install", "./cmd/dep")
 	depInstallCmd.Dir = depDir
 	run(depInstallCmd)
+
+	if err := manifest.Record("dep", depCommit, filepath.Join(SystemGoPath, "bin", "dep")); err != nil {
+		glog.Fatal(err)
+	}
 }
 
 // run wraps the cmd.Run() command and sets the standard output and common environment variables.
@@ -261,20 +343,49 @@ func run(c *exec.Cmd) {
 	}
 }
 
-func cloneSourceRepo(cfg config.Config, runGodepRestore bool) {
-	if _, err := os.Stat(filepath.Join(BaseRepoPath, cfg.SourceRepo)); err == nil {
-		glog.Infof("Source repository %q already cloned, skipping", cfg.SourceRepo)
+func cloneSourceRepo(cfg config.Config, runGodepRestore bool, repair bool) {
+	if cfg.SourcePath != "" {
+		glog.Infof("Using the source checkout in %s instead of a clone", cfg.SourcePath)
+		return
+	}
+	repoLocation := cfg.SourceRepoURL()
+	repoDir := filepath.Join(BaseRepoPath, cfg.SourceRepo)
+	if _, err := os.Stat(repoDir); err == nil && repair && !repairRepo(cfg, repoDir, repoLocation) {
+		moveAside(repoDir)
+	}
+	if _, err := os.Stat(repoDir); err == nil {
+		glog.Infof("Source repository %q already cloned, resetting remote URL ...", cfg.SourceRepo)
+		setUrlCmd := exec.Command("git", "remote", "set-url", "origin", repoLocation)
+		setUrlCmd.Dir = repoDir
+		run(setUrlCmd)
+		setupSourceRemotes(repoDir, cfg.Fetch.Remotes)
 		return
 	}
 
-	repoLocation := fmt.Sprintf("https://%s/%s/%s", cfg.GithubHost, cfg.SourceOrg, cfg.SourceRepo)
-	glog.Infof("Cloning source repository %s ...", repoLocation)
-	cloneCmd := exec.Command("git", "clone", repoLocation)
-	run(cloneCmd)
+	seeded := false
+	if cfg.SourceSeed != "" {
+		glog.Infof("Seeding source repository from %s ...", cfg.SourceSeed)
+		if err := seedSourceRepo(cfg, repoDir, repoLocation); err != nil {
+			glog.Warningf("Failed to seed source repository, falling back to a full clone: %v", err)
+		} else {
+			seeded = true
+		}
+	}
+	if !seeded {
+		seeded = cloneFromMirror(cfg.Fetch.BranchRemotes(), cfg.SourceRepo, repoLocation)
+	}
+	if !seeded {
+		glog.Infof("Cloning source repository %s ...", repoLocation)
+		cloneCmd := exec.Command("git", "clone", repoLocation, cfg.SourceRepo)
+		run(cloneCmd)
+	}
+	setupSourceRemotes(repoDir, cfg.Fetch.Remotes)
 
-	if runGodepRestore {
+	if runGodepRestore && runtime.GOOS == "windows" {
+		glog.Warningf("Skipping hack/godep-restore.sh which cannot be run on windows")
+	} else if runGodepRestore {
 		glog.Infof("Running hack/godep-restore.sh ...")
-		restoreCmd := exec.Command("bash", "-x", "hack/godep-restore.sh")
+		restoreCmd := exec.Command("./hack/godep-restore.sh")
 		restoreCmd.Dir = filepath.Join(BaseRepoPath, cfg.SourceRepo)
 		run(restoreCmd)
 	}
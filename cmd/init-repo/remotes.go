@@ -0,0 +1,64 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// cloneFromMirror clones the source repository from the first branches remote
+// which succeeds into dir below BaseRepoPath and points origin to the given URL.
+// It returns false if there is no such remote.
+func cloneFromMirror(remotes []config.SourceRemote, dir, originURL string) bool {
+	for _, r := range remotes {
+		glog.Infof("Cloning source repository from %s %s ...", r.Name, r.URL)
+		cloneCmd := exec.Command("git", "clone", r.URL, dir)
+		cloneCmd.Dir = BaseRepoPath
+		cloneCmd.Stdout, cloneCmd.Stderr = os.Stdout, os.Stderr
+		if err := cloneCmd.Run(); err != nil {
+			glog.Warningf("Failed to clone from %s, falling back: %v", r.Name, err)
+			os.RemoveAll(filepath.Join(BaseRepoPath, dir))
+			continue
+		}
+		setURLCmd := exec.Command("git", "remote", "set-url", "origin", originURL)
+		setURLCmd.Dir = filepath.Join(BaseRepoPath, dir)
+		run(setURLCmd)
+		return true
+	}
+	return false
+}
+
+// setupSourceRemotes adds the additional remotes to the source clone in
+// repoDir, or updates their URLs.
+func setupSourceRemotes(repoDir string, remotes []config.SourceRemote) {
+	for _, r := range remotes {
+		setURLCmd := exec.Command("git", "remote", "set-url", r.Name, r.URL)
+		setURLCmd.Dir = repoDir
+		if err := setURLCmd.Run(); err == nil {
+			continue
+		}
+		addCmd := exec.Command("git", "remote", "add", r.Name, r.URL)
+		addCmd.Dir = repoDir
+		run(addCmd)
+	}
+}
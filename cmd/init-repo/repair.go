@@ -0,0 +1,154 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// repairRepo detects and fixes common corruption of an existing clone, e.g.
+// after an interrupted fetch or a killed publisher. It returns false if the
+// clone is beyond repair and has to be cloned again. The fetch is killed after
+// the fetch timeout of the config, the other commands after the repair timeout.
+func repairRepo(cfg config.Config, repoDir, remoteURL string) bool {
+	gitDir := filepath.Join(repoDir, ".git")
+	timeout := cfg.Timeout("repair")
+	if err := tryRun(timeout, repoDir, "git", "rev-parse", "--git-dir"); err != nil {
+		glog.Warningf("%s is not a git repository: %v", repoDir, err)
+		return false
+	}
+
+	// stale locks of killed git processes
+	filepath.Walk(gitDir, func(pth string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(pth, ".lock") {
+			glog.Infof("Removing stale lock %s", pth)
+			os.Remove(pth)
+		}
+		return nil
+	})
+
+	// temporary packs of interrupted fetches
+	tmpPacks, _ := filepath.Glob(filepath.Join(gitDir, "objects", "pack", "tmp_*"))
+	for _, pth := range tmpPacks {
+		glog.Infof("Removing temporary pack %s", pth)
+		os.Remove(pth)
+	}
+
+	// operations interrupted in the middle, e.g. a cherry-pick of construct.sh
+	for _, d := range []string{"sequencer", "rebase-apply", "rebase-merge"} {
+		if _, err := os.Stat(filepath.Join(gitDir, d)); err == nil {
+			glog.Infof("Removing interrupted operation state .git/%s", d)
+			os.RemoveAll(filepath.Join(gitDir, d))
+		}
+	}
+	for _, f := range []string{"CHERRY_PICK_HEAD", "MERGE_HEAD", "REVERT_HEAD"} {
+		os.Remove(filepath.Join(gitDir, f))
+	}
+
+	// a corrupt index is rebuilt from HEAD
+	lsFiles := exec.Command("git", "ls-files")
+	lsFiles.Dir = repoDir
+	if err := runWithTimeout(lsFiles, timeout); err != nil {
+		glog.Infof("Rebuilding corrupt index of %s", repoDir)
+		os.Remove(filepath.Join(gitDir, "index"))
+	}
+	if err := tryRun(timeout, repoDir, "git", "reset", "-q", "--hard"); err != nil {
+		glog.Warningf("Failed to reset %s: %v", repoDir, err)
+		return false
+	}
+
+	// wrong remote URL or a fetch refspec of a single-branch clone
+	if err := tryRun(timeout, repoDir, "git", "remote", "set-url", "origin", remoteURL); err != nil {
+		if err := tryRun(timeout, repoDir, "git", "remote", "add", "origin", remoteURL); err != nil {
+			glog.Warningf("Failed to set up origin of %s: %v", repoDir, err)
+			return false
+		}
+	}
+	if err := tryRun(timeout, repoDir, "git", "config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+		glog.Warningf("Failed to set the fetch refspec of %s: %v", repoDir, err)
+		return false
+	}
+
+	// shallow clones miss the history needed for publishing
+	fetchArgs := []string{"fetch", "-q", "origin"}
+	if _, err := os.Stat(filepath.Join(gitDir, "shallow")); err == nil {
+		glog.Infof("Unshallowing %s", repoDir)
+		fetchArgs = append(fetchArgs, "--unshallow")
+	}
+	if err := tryRun(cfg.Timeout("fetch"), repoDir, "git", fetchArgs...); err != nil {
+		glog.Warningf("Failed to fetch %s: %v", repoDir, err)
+		return false
+	}
+
+	// objects missing after interrupted fetches or gc runs
+	if err := tryRun(timeout, repoDir, "git", "fsck", "--no-dangling", "--no-progress"); err != nil {
+		glog.Warningf("Repository %s is corrupt: %v", repoDir, err)
+		return false
+	}
+	return true
+}
+
+// moveAside renames a broken clone such that it can be cloned again, keeping the
+// old one for debugging.
+func moveAside(repoDir string) {
+	broken := fmt.Sprintf("%s.broken-%s", repoDir, time.Now().Format("20060102-150405"))
+	glog.Infof("Moving unrepairable %s to %s", repoDir, broken)
+	if err := os.Rename(repoDir, broken); err != nil {
+		glog.Fatalf("Failed to move %s to %s: %v", repoDir, broken, err)
+	}
+}
+
+// tryRun runs the command in the given directory with the output on stdout and
+// stderr, returning the error instead of failing. The command is killed after
+// the timeout unless it is zero.
+func tryRun(timeout time.Duration, dir, name string, args ...string) error {
+	c := exec.Command(name, args...)
+	c.Dir = dir
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return runWithTimeout(c, timeout)
+}
+
+// runWithTimeout runs the command and kills it after the timeout unless it is
+// zero.
+func runWithTimeout(c *exec.Cmd, timeout time.Duration) error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return c.Wait()
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		c.Process.Kill()
+		<-done
+		return fmt.Errorf("%q hung and was killed after %v", strings.Join(c.Args, " "), timeout)
+	}
+}
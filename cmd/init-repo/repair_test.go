@@ -0,0 +1,41 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunWithTimeout(t *testing.T) {
+	if err := runWithTimeout(exec.Command("true"), time.Second); err != nil {
+		t.Errorf("runWithTimeout(true) = %v", err)
+	}
+	if err := runWithTimeout(exec.Command("false"), 0); err == nil {
+		t.Errorf("runWithTimeout(false) succeeded, want an error")
+	}
+	start := time.Now()
+	err := runWithTimeout(exec.Command("sleep", "10"), 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "hung") {
+		t.Errorf("runWithTimeout(sleep) = %v, want a hung error", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("runWithTimeout(sleep) took %v, want it killed", d)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// defaultSeedTimeout limits the download of a seed if no clone timeout is
+// configured.
+const defaultSeedTimeout = time.Hour
+
+// seedURL returns the URL to download the given seed from, translating gs:// and
+// s3:// object storage locations into their public HTTPS endpoints. Local files
+// are returned unchanged.
+func seedURL(seed string) (string, error) {
+	u, err := url.Parse(seed)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "", "file":
+		return u.Path, nil
+	case "http", "https":
+		return seed, nil
+	case "gs":
+		return fmt.Sprintf("https://storage.googleapis.com/%s%s", u.Host, u.Path), nil
+	case "s3":
+		return fmt.Sprintf("https://%s.s3.amazonaws.com%s", u.Host, u.Path), nil
+	}
+	return "", fmt.Errorf("unsupported seed scheme %q", u.Scheme)
+}
+
+// seedSourceRepo initializes repoDir from the given git bundle or tarball of a
+// clone, and fetches the delta from repoLocation. The seed is a .bundle file
+// or a (compressed) tarball with the clone at its root. On failure, repoDir is
+// removed such that the caller can fall back to a full clone. The download,
+// clone and extraction are limited by the clone timeout, the fetch by the fetch
+// timeout of cfg.
+func seedSourceRepo(cfg config.Config, repoDir, repoLocation string) error {
+	seed := cfg.SourceSeed
+	transport, err := cfg.Network.TLS.Transport()
+	if err != nil {
+		return err
+	}
+	f, err := downloadSeed(seed, transport, cfg.Timeout("clone"))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f)
+
+	if err := seedFrom(cfg, f, repoDir, repoLocation); err != nil {
+		os.RemoveAll(repoDir)
+		return err
+	}
+	return nil
+}
+
+func seedFrom(cfg config.Config, f, repoDir, repoLocation string) error {
+	timeout := cfg.Timeout("clone")
+	if strings.HasSuffix(cfg.SourceSeed, ".bundle") {
+		if err := tryRun(timeout, filepath.Dir(repoDir), "git", "clone", f, repoDir); err != nil {
+			return fmt.Errorf("failed to clone bundle: %v", err)
+		}
+	} else {
+		if err := os.MkdirAll(repoDir, 0755); err != nil {
+			return err
+		}
+		if err := tryRun(timeout, repoDir, "tar", "-xf", f); err != nil {
+			return fmt.Errorf("failed to extract tarball: %v", err)
+		}
+	}
+	// the seed might come without or with another origin remote
+	tryRun(timeout, repoDir, "git", "remote", "remove", "origin")
+	if err := tryRun(timeout, repoDir, "git", "remote", "add", "origin", repoLocation); err != nil {
+		return fmt.Errorf("failed to add the origin remote: %v", err)
+	}
+	if err := tryRun(cfg.Timeout("fetch"), repoDir, "git", "fetch", "origin"); err != nil {
+		return fmt.Errorf("failed to fetch the delta since the seed: %v", err)
+	}
+	return nil
+}
+
+// downloadSeed returns a local file with the seed. Downloaded seeds are written
+// to a temporary file in BaseRepoPath via the transport. The download is
+// aborted after the timeout, or after defaultSeedTimeout if zero.
+func downloadSeed(seed string, transport http.RoundTripper, timeout time.Duration) (string, error) {
+	u, err := seedURL(seed)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		// copy such that removing it does not delete the seed
+		in, err := os.Open(u)
+		if err != nil {
+			return "", err
+		}
+		defer in.Close()
+		return writeSeed(in)
+	}
+
+	glog.Infof("Downloading seed %s ...", u)
+	if timeout <= 0 {
+		timeout = defaultSeedTimeout
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	resp, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", u, resp.Status)
+	}
+	return writeSeed(resp.Body)
+}
+
+func writeSeed(r io.Reader) (string, error) {
+	f, err := ioutil.TempFile(BaseRepoPath, "seed-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
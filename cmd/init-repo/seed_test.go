@@ -0,0 +1,90 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSeedURL(t *testing.T) {
+	tests := []struct {
+		seed    string
+		want    string
+		wantErr bool
+	}{
+		{"/seeds/kubernetes.bundle", "/seeds/kubernetes.bundle", false},
+		{"file:///seeds/kubernetes.tar.gz", "/seeds/kubernetes.tar.gz", false},
+		{"https://example.com/kubernetes.bundle", "https://example.com/kubernetes.bundle", false},
+		{"gs://k8s-seeds/kubernetes.bundle", "https://storage.googleapis.com/k8s-seeds/kubernetes.bundle", false},
+		{"s3://k8s-seeds/kubernetes.tar.gz", "https://k8s-seeds.s3.amazonaws.com/kubernetes.tar.gz", false},
+		{"ftp://example.com/kubernetes.bundle", "", true},
+	}
+	for _, tt := range tests {
+		got, err := seedURL(tt.seed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("seedURL(%q) = %q, %v; wantErr %v", tt.seed, got, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("seedURL(%q) = %q, want %q", tt.seed, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldBaseRepoPath := BaseRepoPath
+	BaseRepoPath = dir
+	defer func() { BaseRepoPath = oldBaseRepoPath }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hung.bundle" {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			// until the client gives up
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("bundle"))
+	}))
+	defer server.Close()
+
+	f, err := downloadSeed(server.URL+"/kubernetes.bundle", nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := ioutil.ReadFile(f); err != nil || string(bs) != "bundle" {
+		t.Errorf("expected the downloaded seed, got %q, %v", bs, err)
+	}
+
+	start := time.Now()
+	if _, err := downloadSeed(server.URL+"/hung.bundle", nil, 100*time.Millisecond); err == nil {
+		t.Error("expected the hung download to fail")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the hung download took %v, expected it aborted after the timeout", d)
+	}
+}
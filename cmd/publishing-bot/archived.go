@@ -0,0 +1,148 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// skipArchived returns true if the destination repo is archived on GitHub and
+// skipped because of it. With archived-repos unarchive, it is recorded to be
+// unarchived for the push instead. Repos configured to be archived are skipped
+// per branch anyway.
+func (p *PublisherMunger) skipArchived(ctx context.Context, repoRule config.RepositoryRule) bool {
+	if repoRule.Metadata.Archived || p.verify != nil || p.config.Canary.Only {
+		return false
+	}
+	tokenRef, err := p.config.PushTokenRef(repoRule.DestinationRepository)
+	if err != nil {
+		// no token, e.g. in dry-run mode
+		return false
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		p.plog.Warningf("Failed to check whether %s is archived: %v", repoRule.DestinationRepository, err)
+		return false
+	}
+	archived, err := IsArchived(ctx, token, p.config.TargetOrg, repoRule.DestinationRepository)
+	if err != nil {
+		p.plog.Warningf("Failed to check whether %s is archived: %v", repoRule.DestinationRepository, err)
+		return false
+	}
+	if !archived {
+		return false
+	}
+	if p.config.ArchivedRepos == config.ArchivedReposUnarchive {
+		p.plog.Infof("%s/%s is archived on GitHub, it is unarchived for the push", p.config.TargetOrg, repoRule.DestinationRepository)
+		p.archived[repoRule.DestinationRepository] = false
+		return false
+	}
+	for _, branchRule := range repoRule.Branches {
+		if !p.skippedBranch(branchRule.Source.Branch) {
+			p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, "the repository is archived on GitHub, but not configured to be. Unarchive it, or set archived-repos to unarchive")
+		}
+	}
+	return true
+}
+
+// unarchive unarchives the destination repo in the publisher's directory for
+// the push if it is archived on GitHub and something is pushed to it.
+func (p *PublisherMunger) unarchive(ctx context.Context, repoRule config.RepositoryRule) error {
+	repo := repoRule.DestinationRepository
+	if unarchived, found := p.archived[repo]; !found || unarchived || p.dstRepoSkipped(repoRule) {
+		return nil
+	}
+	if !p.refsToPush(ctx, repoRule) {
+		p.plog.Infof("%s/%s is archived on GitHub and up to date, it is left archived", p.config.TargetOrg, repo)
+		return nil
+	}
+	token, err := p.pushToken(repo)
+	if err != nil {
+		return err
+	}
+	if err := SetArchived(ctx, token, p.config.TargetOrg, repo, false); err != nil {
+		return fmt.Errorf("failed to unarchive %s/%s for the push: %v", p.config.TargetOrg, repo, err)
+	}
+	p.archived[repo] = true
+	p.plog.Infof("Unarchived %s/%s for the push", p.config.TargetOrg, repo)
+	return nil
+}
+
+// refsToPush returns whether the push of the destination repo in the
+// publisher's directory changes origin, i.e. whether a branch or one of its
+// aliases differs from origin, or there are new tags to push.
+func (p *PublisherMunger) refsToPush(ctx context.Context, repoRule config.RepositoryRule) bool {
+	for _, branchRule := range repoRule.Branches {
+		if p.skippedBranch(branchRule.Source.Branch) || p.dstBranchSkipped(repoRule.DestinationRepository, branchRule.Name) {
+			continue
+		}
+		head, err := p.command(ctx, "git", "rev-parse", "-q", "--verify", "refs/heads/"+branchRule.Name).Output()
+		if err != nil {
+			// not constructed, nothing to push
+			continue
+		}
+		for _, name := range append([]string{branchRule.Name}, branchRule.Aliases...) {
+			origin, err := p.command(ctx, "git", "rev-parse", "-q", "--verify", "refs/remotes/origin/"+name).Output()
+			if err != nil || string(origin) != string(head) {
+				return true
+			}
+		}
+		if !p.config.Skip.TagPush && len(newTags(p.dir, repoRule.DestinationRepository, branchRule.Name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rearchive archives the destination repos unarchived for the push again, also
+// if the push failed.
+func (p *PublisherMunger) rearchive(ctx context.Context) {
+	// the context of the run may be done already, only its client is kept
+	ctx = withHTTPClient(context.Background(), httpClient(ctx))
+	var repos []string
+	for repo, unarchived := range p.archived {
+		if unarchived {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		token, err := p.pushToken(repo)
+		if err == nil {
+			err = SetArchived(ctx, token, p.config.TargetOrg, repo, true)
+		}
+		if err != nil {
+			p.plog.Errorf("Failed to archive %s/%s again, it is left unarchived: %v", p.config.TargetOrg, repo, err)
+			continue
+		}
+		p.archived[repo] = false
+		p.plog.Infof("Archived %s/%s again", p.config.TargetOrg, repo)
+	}
+}
+
+// pushToken returns the token to update the destination repo with.
+func (p *PublisherMunger) pushToken(repo string) (string, error) {
+	tokenRef, err := p.config.PushTokenRef(repo)
+	if err != nil {
+		return "", err
+	}
+	return loadToken(p.config, tokenRef)
+}
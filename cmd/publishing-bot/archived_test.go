@@ -0,0 +1,200 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// fakeArchivedRepo serves the repository repo of the kubernetes org on the fake
+// GitHub, archived or not, and records the archived states it is edited to.
+type fakeArchivedRepo struct {
+	t        *testing.T
+	repo     string
+	archived bool
+	fail     bool
+	edits    []bool
+}
+
+func (f *fakeArchivedRepo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/repos/kubernetes/"+f.repo {
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "PATCH" {
+		if f.fail {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		var edit struct {
+			Archived bool `json:"archived"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+			f.t.Error(err)
+		}
+		f.archived = edit.Archived
+		f.edits = append(f.edits, edit.Archived)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": f.repo, "archived": f.archived})
+}
+
+// testArchivedPublisher returns a publisher of the repo in dir pushing with the
+// ARCHIVED_TEST_TOKEN and the given archived-repos mode.
+func testArchivedPublisher(dir, mode string) *PublisherMunger {
+	buf := bytes.NewBuffer(nil)
+	return &PublisherMunger{
+		config:             &config.Config{TargetOrg: "kubernetes", Token: "env:ARCHIVED_TEST_TOKEN", ArchivedRepos: mode},
+		plog:               &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+		dir:                dir,
+		archived:           map[string]bool{},
+		skippedDstBranches: map[string]string{},
+	}
+}
+
+func TestSkipArchived(t *testing.T) {
+	os.Setenv("ARCHIVED_TEST_TOKEN", "secret")
+	defer os.Unsetenv("ARCHIVED_TEST_TOKEN")
+
+	tests := []struct {
+		name           string
+		configured     bool
+		archived       bool
+		mode           string
+		wantSkipped    bool
+		wantUnarchived bool
+	}{
+		{"configured to be archived", true, true, "", false, false},
+		{"not archived", false, false, "", false, false},
+		{"archived", false, true, "", true, false},
+		{"archived with unarchive", false, true, config.ArchivedReposUnarchive, false, true},
+	}
+	for _, tt := range tests {
+		fake := &fakeArchivedRepo{t: t, repo: "client-go", archived: tt.archived}
+		ctx, stop := fakeGitHub(t, fake.ServeHTTP)
+		p := testArchivedPublisher("", tt.mode)
+		repoRule := config.RepositoryRule{
+			DestinationRepository: "client-go",
+			Branches:              []config.BranchRule{{Name: "master", Source: config.Source{Branch: "master"}}},
+			Metadata:              config.RepositoryMetadata{Archived: tt.configured},
+		}
+		if got := p.skipArchived(ctx, repoRule); got != tt.wantSkipped {
+			t.Errorf("%s: expected skipped %v, got %v", tt.name, tt.wantSkipped, got)
+		}
+		if got := p.dstBranchSkipped("client-go", "master"); got != tt.wantSkipped {
+			t.Errorf("%s: expected branch skipped %v, got %v", tt.name, tt.wantSkipped, got)
+		}
+		if _, got := p.archived["client-go"]; got != tt.wantUnarchived {
+			t.Errorf("%s: expected to be recorded for unarchiving %v, got %v", tt.name, tt.wantUnarchived, got)
+		}
+		stop()
+	}
+}
+
+func TestUnarchive(t *testing.T) {
+	os.Setenv("ARCHIVED_TEST_TOKEN", "secret")
+	defer os.Unsetenv("ARCHIVED_TEST_TOKEN")
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	old := commitFile(t, git, "a", "1", "old")
+	head := commitFile(t, git, "a", "2", "head")
+	// the destination repo is named after the directory, such that the
+	// push-tags script next to it does not collide with others
+	repo := filepath.Base(dir)
+	pushTags := filepath.Join(dir, "..", "push-tags-"+repo+"-master.sh")
+	defer os.Remove(pushTags)
+
+	tests := []struct {
+		name        string
+		origin      string
+		originAlias string
+		tags        bool
+		skipTagPush bool
+		want        bool
+	}{
+		{"up to date", head, head, false, false, false},
+		{"new commits", old, head, false, false, true},
+		{"alias behind", head, old, false, false, true},
+		{"new tags", head, head, true, false, true},
+		{"new tags not pushed", head, head, true, true, false},
+	}
+	for _, tt := range tests {
+		git("update-ref", "refs/remotes/origin/master", tt.origin)
+		git("update-ref", "refs/remotes/origin/main", tt.originAlias)
+		os.Remove(pushTags)
+		if tt.tags {
+			if err := ioutil.WriteFile(pushTags, []byte("git push \"${1:-origin}\" refs/tags/v1.0.0\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fake := &fakeArchivedRepo{t: t, repo: repo, archived: true}
+		ctx, stop := fakeGitHub(t, fake.ServeHTTP)
+		p := testArchivedPublisher(dir, config.ArchivedReposUnarchive)
+		p.config.Skip.TagPush = tt.skipTagPush
+		p.archived[repo] = false
+		repoRule := config.RepositoryRule{
+			DestinationRepository: repo,
+			Branches:              []config.BranchRule{{Name: "master", Aliases: []string{"main"}, Source: config.Source{Branch: "master"}}},
+		}
+		if err := p.unarchive(ctx, repoRule); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if got := p.archived[repo]; got != tt.want {
+			t.Errorf("%s: expected unarchived %v, got %v", tt.name, tt.want, got)
+		}
+		if got := !fake.archived; got != tt.want {
+			t.Errorf("%s: expected unarchived on GitHub %v, got %v", tt.name, tt.want, got)
+		}
+		// unarchived once per run
+		if err := p.unarchive(ctx, repoRule); err != nil || len(fake.edits) > 1 {
+			t.Errorf("%s: expected a single edit, got %v, %v", tt.name, fake.edits, err)
+		}
+		stop()
+	}
+}
+
+func TestRearchive(t *testing.T) {
+	os.Setenv("ARCHIVED_TEST_TOKEN", "secret")
+	defer os.Unsetenv("ARCHIVED_TEST_TOKEN")
+
+	for _, fail := range []bool{true, false} {
+		fake := &fakeArchivedRepo{t: t, repo: "client-go", fail: fail}
+		ctx, stop := fakeGitHub(t, fake.ServeHTTP)
+		// the run may be interrupted
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		p := testArchivedPublisher("", config.ArchivedReposUnarchive)
+		p.archived["client-go"] = true
+		p.rearchive(ctx)
+		if got := p.archived["client-go"]; got != fail {
+			t.Errorf("failing %v: expected to be left unarchived %v, got %v", fail, fail, got)
+		}
+		if !fail && (len(fake.edits) != 1 || !fake.archived) {
+			t.Errorf("expected to be archived again, got edits %v", fake.edits)
+		}
+		stop()
+	}
+}
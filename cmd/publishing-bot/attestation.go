@@ -0,0 +1,281 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// version of the bot, set at build time with -ldflags "-X main.version=<version>".
+var version = "unknown"
+
+const (
+	inTotoStatementType      = "https://in-toto.io/Statement/v1"
+	attestationPredicateType = "https://k8s.io/publishing-bot/publish/v1"
+)
+
+type digestSet map[string]string
+
+type inTotoSubject struct {
+	Name   string    `json:"name"`
+	Digest digestSet `json:"digest"`
+}
+
+// publishPredicate binds a destination ref to how it was published.
+type publishPredicate struct {
+	Source struct {
+		URI    string    `json:"uri"`
+		Ref    string    `json:"ref"`
+		Digest digestSet `json:"digest"`
+	} `json:"source"`
+	Rules struct {
+		Digest digestSet `json:"digest"`
+	} `json:"rules"`
+	Builder struct {
+		ID      string `json:"id"`
+		Version string `json:"version"`
+	} `json:"builder"`
+	PublishedOn time.Time `json:"publishedOn"`
+}
+
+type inTotoStatement struct {
+	Type          string           `json:"_type"`
+	Subject       []inTotoSubject  `json:"subject"`
+	PredicateType string           `json:"predicateType"`
+	Predicate     publishPredicate `json:"predicate"`
+}
+
+// newStatement returns the statement that the destination commit at the given
+// ref was published from the source commit with the rules of the given digest.
+func newStatement(dstRepoURL, ref, dstCommit, srcRepoURL, srcRef, srcCommit, rulesDigest string, now time.Time) inTotoStatement {
+	s := inTotoStatement{
+		Type: inTotoStatementType,
+		Subject: []inTotoSubject{{
+			Name:   fmt.Sprintf("git+%s@%s", dstRepoURL, ref),
+			Digest: digestSet{"gitCommit": dstCommit},
+		}},
+		PredicateType: attestationPredicateType,
+	}
+	s.Predicate.Source.URI = "git+" + srcRepoURL
+	s.Predicate.Source.Ref = srcRef
+	s.Predicate.Source.Digest = digestSet{"gitCommit": srcCommit}
+	s.Predicate.Rules.Digest = digestSet{"sha256": rulesDigest}
+	s.Predicate.Builder.ID = "publishing-bot"
+	s.Predicate.Builder.Version = version
+	s.Predicate.PublishedOn = now.UTC()
+	return s
+}
+
+// rulesDigest returns the hex encoded SHA-256 of the effective rules.
+func rulesDigest(rules config.RepositoryRules) (string, error) {
+	bs, err := yaml.Marshal(rules)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// attestationFileName returns the file name of the statement of the ref and
+// commit, e.g. client-go-refs_tags_v0.30.0-0123456.intoto.json.
+func attestationFileName(repo, ref, commit string) string {
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return fmt.Sprintf("%s-%s-%s.intoto.json", repo, strings.Replace(ref, "/", "_", -1), commit)
+}
+
+// attest signs statements for the pushed branch and its new tags in the
+// destination repo in the publisher's directory. They are kept in the attestations
+// directory of the base repo path. Heads attested before, i.e. with a bundle
+// for the ref and commit, are not signed again. Tags are attested with the ref
+// of their source tag.
+func (p *PublisherMunger) attest(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) error {
+	a := p.config.Attestation
+	if !a.Enabled {
+		return nil
+	}
+	refs := []string{"refs/heads/" + branchRule.Name}
+	var tags []string
+	for _, b := range p.result.Branches {
+		if b.Repository == repoRule.DestinationRepository && b.Branch == branchRule.Name {
+			tags = b.Tags
+		}
+	}
+	for _, t := range tags {
+		refs = append(refs, "refs/tags/"+t)
+	}
+
+	digest, err := rulesDigest(p.reposRules)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(p.baseRepoPath, "attestations", repoRule.DestinationRepository)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dstURL := fmt.Sprintf("https://%s/%s/%s", p.config.GithubHost, p.config.TargetOrg, repoRule.DestinationRepository)
+	for _, ref := range refs {
+		out, err := p.command(ctx, "git", "rev-parse", ref+"^{commit}").Output()
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %v", ref, err)
+		}
+		commit := strings.TrimSpace(string(out))
+		statement := filepath.Join(dir, attestationFileName(repoRule.DestinationRepository, ref, commit))
+		bundle := statement + ".bundle"
+		if _, err := os.Stat(bundle); err == nil {
+			continue
+		}
+		srcCommit := p.sourceCommitOf(ctx, ref)
+		if srcCommit == "" {
+			p.plog.Infof("Not attesting %s of %s without source commit", ref, repoRule.DestinationRepository)
+			continue
+		}
+		srcRef := "refs/heads/" + branchRule.Source.Branch
+		if tag := strings.TrimPrefix(ref, "refs/tags/"); tag != ref {
+			srcTag := p.sourceTagOf(ctx, tag, srcCommit)
+			if srcTag == "" {
+				p.plog.Infof("Not attesting %s of %s without source tag at %s", ref, repoRule.DestinationRepository, srcCommit)
+				continue
+			}
+			srcRef = "refs/tags/" + srcTag
+		}
+
+		s := newStatement(dstURL, ref, commit, p.config.SourceRepoURL(), srcRef, srcCommit, digest, time.Now())
+		bs, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(statement, bs, 0644); err != nil {
+			return err
+		}
+		if err := p.signStatement(ctx, statement, bundle); err != nil {
+			return fmt.Errorf("failed to sign the attestation of %s of %s: %v", ref, repoRule.DestinationRepository, err)
+		}
+		p.plog.Infof("Attested %s of %s at %s", ref, repoRule.DestinationRepository, commit)
+
+		if a.Releases && strings.HasPrefix(ref, "refs/tags/") {
+			if err := p.attachAttestation(ctx, repoRule, strings.TrimPrefix(ref, "refs/tags/"), statement, bundle); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sourceTagOf returns the source tag at the source commit which the
+// destination tag was created from, or empty if there is none.
+func (p *PublisherMunger) sourceTagOf(ctx context.Context, tag, srcCommit string) string {
+	cmd := p.command(ctx, "git", "tag", "--points-at", srcCommit)
+	cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+	out, err := cmd.Output()
+	if err != nil {
+		p.plog.Warningf("Failed to list the source tags at %s: %v", srcCommit, err)
+		return ""
+	}
+	return matchSourceTag(tag, strings.Fields(string(out)))
+}
+
+// matchSourceTag returns the source tag the destination tag is named after,
+// i.e. the longest one whose name without the leading v ends the destination
+// tag, e.g. v1.30.0 for kubernetes-1.30.0. The only source tag is returned for
+// destination tags named differently, e.g. semver tags like v0.30.0.
+func matchSourceTag(tag string, srcTags []string) string {
+	match := ""
+	for _, t := range srcTags {
+		if strings.HasSuffix(tag, strings.TrimPrefix(t, "v")) && len(t) > len(match) {
+			match = t
+		}
+	}
+	if match == "" && len(srcTags) == 1 {
+		match = srcTags[0]
+	}
+	return match
+}
+
+// signStatement signs the statement file with cosign or the configured command,
+// writing the signature bundle.
+func (p *PublisherMunger) signStatement(ctx context.Context, statement, bundle string) error {
+	a := p.config.Attestation
+	env := append(p.environ(), "PUBLISHER_BOT_ATTESTATION="+statement, "PUBLISHER_BOT_ATTESTATION_BUNDLE="+bundle)
+	if a.Command != "" {
+		cmd := p.command(ctx, "/bin/bash", "-c", a.Command)
+		cmd.Env = env
+		return p.plog.Run(cmd)
+	}
+
+	args := []string{"sign-blob", "--yes", "--bundle", bundle}
+	if a.Key != "" {
+		s, err := loadSecret(p.config, a.Key)
+		if err != nil {
+			return err
+		}
+		keyPath := filepath.Join(p.baseRepoPath, secretsDirName, "cosign.key")
+		if _, err := s.WriteFile(keyPath); err != nil {
+			return fmt.Errorf("failed to write cosign key: %v", err)
+		}
+		args = append(args, "--key", keyPath)
+	}
+	if a.KeyPassword != "" {
+		password, err := loadToken(p.config, a.KeyPassword)
+		if err != nil {
+			return err
+		}
+		env = append(env, "COSIGN_PASSWORD="+password)
+	}
+	if a.SkipRekor {
+		args = append(args, "--tlog-upload=false")
+	}
+	cmd := p.command(ctx, "cosign", append(args, statement)...)
+	cmd.Env = env
+	return p.plog.Run(cmd)
+}
+
+// attachAttestation attaches the statement and its bundle to the release of the tag.
+func (p *PublisherMunger) attachAttestation(ctx context.Context, repoRule config.RepositoryRule, tag, statement, bundle string) error {
+	tokenRef, err := p.config.PushTokenRef(repoRule.DestinationRepository)
+	if err != nil {
+		return err
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	for _, f := range []string{statement, bundle} {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if _, err := AttachReleaseAsset(ctx, token, p.config.TargetOrg, repoRule.DestinationRepository, tag, filepath.Base(f), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
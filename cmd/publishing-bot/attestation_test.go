@@ -0,0 +1,88 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestNewStatement(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	s := newStatement("https://github.com/kubernetes/client-go", "refs/tags/v0.30.0", "dst123",
+		"https://github.com/kubernetes/kubernetes", "refs/heads/master", "src456", "abcdef", now)
+	bs, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"_type":"https://in-toto.io/Statement/v1",` +
+		`"subject":[{"name":"git+https://github.com/kubernetes/client-go@refs/tags/v0.30.0","digest":{"gitCommit":"dst123"}}],` +
+		`"predicateType":"https://k8s.io/publishing-bot/publish/v1",` +
+		`"predicate":{"source":{"uri":"git+https://github.com/kubernetes/kubernetes","ref":"refs/heads/master","digest":{"gitCommit":"src456"}},` +
+		`"rules":{"digest":{"sha256":"abcdef"}},"builder":{"id":"publishing-bot","version":"unknown"},"publishedOn":"2018-03-01T11:00:00Z"}}`
+	if string(bs) != want {
+		t.Errorf("got\n%s\nwant\n%s", bs, want)
+	}
+}
+
+func TestRulesDigest(t *testing.T) {
+	rules := config.RepositoryRules{Rules: []config.RepositoryRule{{DestinationRepository: "client-go"}}}
+	a, err := rulesDigest(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := rulesDigest(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b || len(a) != 64 {
+		t.Errorf("expected a stable SHA-256 digest, got %q and %q", a, b)
+	}
+	rules.Rules[0].DestinationRepository = "api"
+	if c, _ := rulesDigest(rules); c == a {
+		t.Errorf("expected the digest to change with the rules")
+	}
+}
+
+func TestAttestationFileName(t *testing.T) {
+	got := attestationFileName("client-go", "refs/tags/v0.30.0", "0123456789abcdef0123")
+	if want := "client-go-refs_tags_v0.30.0-0123456789ab.intoto.json"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMatchSourceTag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		srcTags []string
+		want    string
+	}{
+		{"kubernetes-1.30.0", []string{"v1.30.0"}, "v1.30.0"},
+		{"kubernetes-1.30.0", []string{"v1.30.0-rc.1", "v1.30.0"}, "v1.30.0"},
+		{"v0.30.0", []string{"v1.30.0"}, "v1.30.0"},
+		{"v0.30.0", []string{"v1.30.0-rc.1", "v1.30.0"}, ""},
+		{"kubernetes-1.30.0", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := matchSourceTag(tt.tag, tt.srcTags); got != tt.want {
+			t.Errorf("matchSourceTag(%q, %v) = %q, want %q", tt.tag, tt.srcTags, got, tt.want)
+		}
+	}
+}
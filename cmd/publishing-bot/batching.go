@@ -0,0 +1,215 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+const batchStateFileName = "publisher-batches.json"
+
+// BatchState records when destination repos were published last, such that
+// batching intervals survive restarts.
+type BatchState struct {
+	Published map[string]time.Time `json:"published"`
+	// TagsSynced records when the tags of destination repos were synchronized
+	// last. Source tags created later are late tags.
+	TagsSynced map[string]time.Time `json:"tagsSynced,omitempty"`
+	// Unfinished are the destination repos whose construction was cut off by
+	// the cycle deadline in the last run. They are constructed first.
+	Unfinished map[string]time.Time `json:"unfinished,omitempty"`
+}
+
+// LoadBatchState reads the batch state from the state store. A missing state is
+// empty.
+func LoadBatchState(store state.Store) (*BatchState, error) {
+	s := &BatchState{Published: map[string]time.Time{}, TagsSynced: map[string]time.Time{}}
+	bs, err := store.Read(batchStateFileName)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(bs, s); err != nil {
+		return &BatchState{Published: map[string]time.Time{}, TagsSynced: map[string]time.Time{}}, err
+	}
+	if s.Published == nil {
+		s.Published = map[string]time.Time{}
+	}
+	if s.TagsSynced == nil {
+		s.TagsSynced = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+// Save writes the batch state into the state store.
+func (s *BatchState) Save(store state.Store) error {
+	bs, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Write(batchStateFileName, bs)
+}
+
+// batchingSkipReason returns why the destination repo in the publisher's directory
+// is not published in this run, or the empty string if it is.
+func (p *PublisherMunger) batchingSkipReason(ctx context.Context, repoRule config.RepositoryRule, now time.Time) string {
+	b := repoRule.Batching
+	if b.Interval > 0 {
+		if last, found := p.batches.Published[repoRule.DestinationRepository]; found && now.Sub(last) < b.Interval {
+			return fmt.Sprintf("batching: published at %s, next publish after %s", last.Format(time.RFC3339), last.Add(b.Interval).Format(time.RFC3339))
+		}
+	}
+	if b.OnlyOnChanges && !p.hasUnpublishedChanges(ctx, repoRule) {
+		return "batching: no new source commits touching its directories"
+	}
+	return ""
+}
+
+// hasUnpublishedChanges returns true if there are source commits touching the
+// source directory of a branch of the destination repo in the current
+// directory which are not published yet. In case of doubt, it returns true.
+func (p *PublisherMunger) hasUnpublishedChanges(ctx context.Context, repoRule config.RepositoryRule) bool {
+	for _, branchRule := range repoRule.Branches {
+		if p.skippedBranch(branchRule.Source.Branch) {
+			continue
+		}
+		published := p.lastPublishedSourceCommit(ctx, branchRule.Name)
+		if published == "" {
+			return true
+		}
+		args := []string{"rev-list", "-1", published + "..origin/" + branchRule.Source.Branch}
+		if dir := branchRule.Source.Dir; dir != "" && dir != "." {
+			args = append(args, "--", dir)
+		}
+		cmd := p.command(ctx, "git", args...)
+		cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+		out, err := cmd.Output()
+		if err != nil || strings.TrimSpace(string(out)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lateTags returns the annotated source tags created after the tags of the
+// destination repo were synchronized last. These tags point to commits which
+// might already be published, such that the destination repo must be
+// constructed again for sync-tags to create the corresponding destination
+// tags. Branch history is not rewritten by this.
+func (p *PublisherMunger) lateTags(ctx context.Context, repoRule config.RepositoryRule) []string {
+	if p.reposRules.SkipTags {
+		return nil
+	}
+	since, found := p.batches.TagsSynced[repoRule.DestinationRepository]
+	if !found {
+		if since, found = p.batches.Published[repoRule.DestinationRepository]; !found {
+			return nil
+		}
+	}
+	cmd := p.command(ctx, "git", "for-each-ref", "--format=%(refname:short) %(taggerdate:unix)", "refs/tags")
+	cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+	out, err := cmd.Output()
+	if err != nil {
+		p.plog.Warningf("Failed to list source tags: %v", err)
+		return nil
+	}
+	var tags []string
+	for _, tag := range tagsCreatedAfter(string(out), since) {
+		if repoRule.Tags.Publishes(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// tagsCreatedAfter returns the tags of the given "<name> <unix tagger date>"
+// lines which were created after since. Lightweight tags without tagger date
+// are ignored, as sync-tags does.
+func tagsCreatedAfter(refs string, since time.Time) []string {
+	tags := []string{}
+	for _, l := range strings.Split(refs, "\n") {
+		fs := strings.Fields(l)
+		if len(fs) != 2 {
+			continue
+		}
+		secs, err := strconv.ParseInt(fs[1], 10, 64)
+		if err != nil || !time.Unix(secs, 0).After(since) {
+			continue
+		}
+		tags = append(tags, fs[0])
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// maxCommitsPin returns the source commit the branch of the destination repo in
+// the publisher's directory is published up to in this run if more than batching
+// max-commits first-parent source commits follow the last published one, and
+// the number of the remaining ones. Otherwise, the source commit is empty.
+func (p *PublisherMunger) maxCommitsPin(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) (string, int, error) {
+	max := repoRule.Batching.MaxCommits
+	if max <= 0 {
+		return "", 0, nil
+	}
+	published := p.lastPublishedSourceCommit(ctx, branchRule.Name)
+	if published == "" {
+		return "", 0, nil
+	}
+	cmd := p.command(ctx, "git", "rev-list", "--first-parent", "--reverse", published+".."+branchRule.Source.Branch)
+	cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to list the new source commits of %s: %v", branchRule.Source.Branch, err)
+	}
+	commits := strings.Fields(string(out))
+	if len(commits) <= max {
+		return "", 0, nil
+	}
+	return commits[max-1], len(commits) - max, nil
+}
+
+// pacePush waits until push-interval passed since new commits were pushed last
+// if the branch of the destination repo in the publisher's directory has new
+// commits.
+func (p *PublisherMunger) pacePush(ctx context.Context, branch string) error {
+	if p.config.PushInterval == 0 || p.newCommits(ctx, branch) == 0 {
+		return nil
+	}
+	if !p.lastPush.IsZero() {
+		if wait := time.Until(p.lastPush.Add(p.config.PushInterval)); wait > 0 {
+			p.plog.Infof("Waiting %v before pushing branch %s", wait.Round(time.Second), branch)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+	p.lastPush = time.Now()
+	return nil
+}
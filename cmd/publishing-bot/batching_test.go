@@ -0,0 +1,156 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+func TestBatchingInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	s, err := LoadBatchState(state.Dir(dir))
+	if err != nil {
+		t.Fatalf("unexpected error loading missing state: %v", err)
+	}
+	s.Published["client-go"] = now.Add(-2 * time.Hour)
+	if err := s.Save(state.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = LoadBatchState(state.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &PublisherMunger{batches: s}
+	tests := []struct {
+		repo     string
+		interval time.Duration
+		skipped  bool
+	}{
+		{"client-go", 0, false},
+		{"client-go", time.Hour, false},
+		{"client-go", 6 * time.Hour, true},
+		{"api", 6 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		rule := config.RepositoryRule{DestinationRepository: tt.repo, Batching: config.Batching{Interval: tt.interval}}
+		reason := p.batchingSkipReason(context.Background(), rule, now)
+		if (reason != "") != tt.skipped {
+			t.Errorf("%s with interval %v: expected skipped=%v, got reason %q", tt.repo, tt.interval, tt.skipped, reason)
+		}
+		if tt.skipped && !strings.Contains(reason, now.Add(4*time.Hour).Format(time.RFC3339)) {
+			t.Errorf("%s: expected the next publish time in %q", tt.repo, reason)
+		}
+	}
+}
+
+func TestTagsCreatedAfter(t *testing.T) {
+	since := time.Unix(1500000000, 0)
+	refs := `v1.28.0 1400000000
+v1.29.0 1500000001
+v1.30.0-alpha.0 1600000000
+lightweight 
+v1.27.0 1500000000
+`
+	got := tagsCreatedAfter(refs, since)
+	want := []string{"v1.29.0", "v1.30.0-alpha.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := tagsCreatedAfter("", since); len(got) != 0 {
+		t.Errorf("expected no tags, got %v", got)
+	}
+}
+
+func TestMaxCommitsPin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maxcommits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	src, dst := filepath.Join(dir, "kubernetes"), filepath.Join(dir, "client-go")
+	for _, d := range []string{src, dst} {
+		git(dir, "init", "-q", d)
+	}
+	git(src, "commit", "-q", "--allow-empty", "-m", "a")
+	published := git(src, "rev-parse", "HEAD")
+	git(src, "branch", "-m", "master")
+	var commits []string
+	for _, msg := range []string{"b", "c", "d"} {
+		git(src, "commit", "-q", "--allow-empty", "-m", msg)
+		commits = append(commits, git(src, "rev-parse", "HEAD"))
+	}
+	git(dst, "commit", "-q", "--allow-empty", "-m", "a\n\nKubernetes-commit: "+published)
+	git(dst, "update-ref", "refs/remotes/origin/master", "HEAD")
+	if err := os.Chdir(dst); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(&config.Config{SourceRepo: "kubernetes"}, dir)
+	branchRule := config.BranchRule{Name: "master", Source: config.Source{Branch: "master"}}
+	tests := []struct {
+		maxCommits int
+		pin        string
+		remaining  int
+	}{
+		{0, "", 0},
+		{1, commits[0], 2},
+		{2, commits[1], 1},
+		{3, "", 0},
+	}
+	for _, tt := range tests {
+		repoRule := config.RepositoryRule{DestinationRepository: "client-go", Batching: config.Batching{MaxCommits: tt.maxCommits}}
+		pin, remaining, err := p.maxCommitsPin(context.Background(), repoRule, branchRule)
+		if err != nil {
+			t.Fatalf("max-commits %d: unexpected error: %v", tt.maxCommits, err)
+		}
+		if pin != tt.pin || remaining != tt.remaining {
+			t.Errorf("max-commits %d: expected %q with %d remaining, got %q with %d remaining", tt.maxCommits, tt.pin, tt.remaining, pin, remaining)
+		}
+	}
+}
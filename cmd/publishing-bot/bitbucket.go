@@ -0,0 +1,189 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// bitbucketRequest sends a request to the Bitbucket Server REST API,
+// authenticated with the token as bearer token, and returns the status code.
+func bitbucketRequest(ctx context.Context, token, method, url string, body interface{}) (int, error) {
+	buf := bytes.NewBuffer(nil)
+	if body != nil {
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, url, buf)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return resp.StatusCode, fmt.Errorf("HTTP code %d: %s", resp.StatusCode, msg)
+	}
+	return resp.StatusCode, nil
+}
+
+// BitbucketRepositoryExists returns whether the repository exists and is
+// visible with the token.
+func BitbucketRepositoryExists(ctx context.Context, token string, b *config.BitbucketServer, repo string) (bool, error) {
+	code, err := bitbucketRequest(ctx, token, "GET", b.RepositoryAPIURL(repo), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to get repository %s/%s: %v", b.Project, b.Slug(repo), err)
+	}
+	return code != http.StatusNotFound, nil
+}
+
+// CreateBitbucketRepository creates an empty git repository in the project.
+func CreateBitbucketRepository(ctx context.Context, token string, b *config.BitbucketServer, repo string) error {
+	name := repo
+	if b.Repository != "" {
+		name = b.Repository
+	}
+	code, err := bitbucketRequest(ctx, token, "POST", b.RepositoryAPIURL(""), map[string]interface{}{
+		"name":     name,
+		"scmId":    "git",
+		"forkable": true,
+	})
+	if err == nil && code == http.StatusNotFound {
+		err = fmt.Errorf("project not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create repository %s/%s: %v", b.Project, b.Slug(repo), err)
+	}
+	return nil
+}
+
+// ensureBitbucketRepo creates the Bitbucket Server repository of the push
+// target if it does not exist.
+func (p *PublisherMunger) ensureBitbucketRepo(ctx context.Context, target config.PushTarget, repo string) error {
+	tokenRef := target.TokenFile
+	if tokenRef == "" {
+		var err error
+		if tokenRef, err = p.config.PushTokenRef(repo); err != nil {
+			return err
+		}
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	b := target.BitbucketServer
+	exists, err := BitbucketRepositoryExists(ctx, token, b, repo)
+	if err != nil || exists {
+		return err
+	}
+	if err := CreateBitbucketRepository(ctx, token, b, repo); err != nil {
+		return err
+	}
+	p.plog.Infof("Created %s/%s on %s for push target %s", b.Project, b.Slug(repo), b.URL, target.Name)
+	return nil
+}
+
+// OpenBitbucketPullRequest opens a pull request from the branch with the
+// prefix of the pull request mode to the branch. It returns false without an
+// error if Bitbucket rejects it as conflict, i.e. if the pull request is
+// already open or if there are no changes to merge.
+func OpenBitbucketPullRequest(ctx context.Context, token string, b *config.BitbucketServer, repo, branch, description string) (bool, error) {
+	ref := func(name string) map[string]interface{} {
+		return map[string]interface{}{"id": "refs/heads/" + name}
+	}
+	code, err := bitbucketRequest(ctx, token, "POST", b.PullRequestsAPIURL(repo), map[string]interface{}{
+		"title":       fmt.Sprintf("Publish %s", branch),
+		"description": description,
+		"fromRef":     ref(b.BranchPrefix() + branch),
+		"toRef":       ref(branch),
+	})
+	if code == http.StatusConflict {
+		return false, nil
+	}
+	if err == nil && code == http.StatusNotFound {
+		err = fmt.Errorf("repository not found")
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open pull request for %s in %s/%s: %v", branch, b.Project, b.Slug(repo), err)
+	}
+	return true, nil
+}
+
+// openBitbucketPullRequests opens the pull requests of the push target in the
+// pull request mode for the branch and those of its aliases which were pushed
+// to their prefixed branches. env is the environment to access the target with.
+func (p *PublisherMunger) openBitbucketPullRequests(ctx context.Context, target config.PushTarget, tokenRef string, env []string, repo string, branch config.BranchRule) error {
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	b := target.BitbucketServer
+	for _, name := range append([]string{branch.Name}, branch.Aliases...) {
+		pushed, err := p.pushedToPrefixedBranch(ctx, target.Name, env, branch.Name, b.BranchPrefix()+name)
+		if err != nil {
+			return err
+		}
+		if !pushed {
+			p.plog.Infof("Not opening a pull request for %s in %s/%s, it was pushed directly", name, b.Project, b.Slug(repo))
+			continue
+		}
+		description := fmt.Sprintf("Published from %s/%s by the publishing-bot.", p.config.SourceOrg, p.config.SourceRepo)
+		opened, err := OpenBitbucketPullRequest(ctx, token, b, repo, name, description)
+		if err != nil {
+			return err
+		}
+		if opened {
+			p.plog.Infof("Opened pull request from %s%s to %s in %s/%s for push target %s", b.BranchPrefix(), name, name, b.Project, b.Slug(repo), target.Name)
+		}
+	}
+	return nil
+}
+
+// pushedToPrefixedBranch returns whether the local branch was pushed to the
+// prefixed branch in the remote. push.sh pushes branches which did not exist
+// in the remote directly instead.
+func (p *PublisherMunger) pushedToPrefixedBranch(ctx context.Context, remote string, env []string, branch, prefixed string) (bool, error) {
+	head, err := p.command(ctx, "git", "rev-parse", "refs/heads/"+branch).Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve branch %s: %v", branch, err)
+	}
+	cmd := p.command(ctx, "git", "ls-remote", "--heads", remote, "refs/heads/"+prefixed)
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to look up branch %s in %s: %v", prefixed, remote, err)
+	}
+	fields := strings.Fields(string(out))
+	return len(fields) > 0 && fields[0] == strings.TrimSpace(string(head)), nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestEnsureBitbucketRepo(t *testing.T) {
+	var created map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/bitbucket/rest/api/1.0/projects/K8S/repos/client-go" && created == nil:
+			http.NotFound(w, r)
+		case r.Method == "GET" && r.URL.Path == "/bitbucket/rest/api/1.0/projects/K8S/repos/client-go":
+			w.Write([]byte(`{"slug": "client-go"}`))
+		case r.Method == "POST" && r.URL.Path == "/bitbucket/rest/api/1.0/projects/K8S/repos":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Error(err)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "bitbucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := New(&config.Config{}, dir)
+	if p.plog, err = NewPublisherLog(bytes.NewBuffer(nil), filepath.Join(dir, "run.log")); err != nil {
+		t.Fatal(err)
+	}
+	target := config.PushTarget{
+		Name:            "bitbucket",
+		TokenFile:       "env:BITBUCKET_TEST_TOKEN",
+		BitbucketServer: &config.BitbucketServer{URL: server.URL + "/bitbucket", Project: "K8S", CreateRepos: true},
+	}
+	os.Setenv("BITBUCKET_TEST_TOKEN", "secret")
+	defer os.Unsetenv("BITBUCKET_TEST_TOKEN")
+
+	for i := 0; i < 2; i++ {
+		if err := p.ensureBitbucketRepo(context.Background(), target, "client-go"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if created["name"] != "client-go" || created["scmId"] != "git" {
+		t.Errorf("unexpected repository created: %v", created)
+	}
+}
+
+func TestOpenBitbucketPullRequests(t *testing.T) {
+	var opened []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != "POST" || r.URL.Path != "/rest/api/1.0/projects/K8S/repos/client-go/pull-requests" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			return
+		}
+		var pr map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+			t.Error(err)
+			return
+		}
+		// the pull request to master is already open
+		switch pr["toRef"].(map[string]interface{})["id"] {
+		case "refs/heads/master":
+			http.Error(w, `{"errors": [{"exceptionName": "DuplicatePullRequestException"}]}`, http.StatusConflict)
+			return
+		case "refs/heads/dev":
+			t.Errorf("unexpected pull request to dev which was pushed directly")
+		}
+		opened = append(opened, pr)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	old := commitFile(t, git, "a", "1", "old")
+	commitFile(t, git, "a", "2", "head")
+	remote, err := ioutil.TempDir("", "bitbucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	git("init", "-q", "--bare", remote)
+	git("remote", "add", "bitbucket", remote)
+	// dev did not exist in the remote and was pushed directly, next to a stale
+	// prefixed branch
+	git("push", "-q", "bitbucket", "master:refs/heads/publishing-bot/master", "master:refs/heads/publishing-bot/main", "master:refs/heads/dev", old+":refs/heads/publishing-bot/dev")
+
+	p := New(&config.Config{SourceOrg: "kubernetes", SourceRepo: "kubernetes"}, dir)
+	p.dir = dir
+	if p.plog, err = NewPublisherLog(bytes.NewBuffer(nil), filepath.Join(remote, "run.log")); err != nil {
+		t.Fatal(err)
+	}
+	target := config.PushTarget{
+		Name:            "bitbucket",
+		BitbucketServer: &config.BitbucketServer{URL: server.URL, Project: "K8S", PullRequests: true},
+	}
+	os.Setenv("BITBUCKET_TEST_TOKEN", "secret")
+	defer os.Unsetenv("BITBUCKET_TEST_TOKEN")
+
+	branch := config.BranchRule{Name: "master", Aliases: []string{"main", "dev"}}
+	if err := p.openBitbucketPullRequests(context.Background(), target, "env:BITBUCKET_TEST_TOKEN", p.environ(), "client-go", branch); err != nil {
+		t.Fatal(err)
+	}
+	if len(opened) != 1 {
+		t.Fatalf("expected one pull request, got %v", opened)
+	}
+	from := opened[0]["fromRef"].(map[string]interface{})["id"]
+	to := opened[0]["toRef"].(map[string]interface{})["id"]
+	if from != "refs/heads/publishing-bot/main" || to != "refs/heads/main" {
+		t.Errorf("unexpected pull request from %v to %v", from, to)
+	}
+	if desc := opened[0]["description"]; desc != "Published from kubernetes/kubernetes by the publishing-bot." {
+		t.Errorf("unexpected description %q", desc)
+	}
+
+	os.Setenv("BITBUCKET_TEST_TOKEN", "wrong")
+	if err := p.openBitbucketPullRequests(context.Background(), target, "env:BITBUCKET_TEST_TOKEN", p.environ(), "client-go", branch); err == nil {
+		t.Error("expected an error with an unauthorized token")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// blobChange is a file added or modified by a commit.
+type blobChange struct {
+	commit string
+	path   string
+	blob   string
+}
+
+// checkBlobSizes fails if a commit of the branch in the publisher's directory which
+// is not on origin yet adds or modifies a file larger than max.
+func (p *PublisherMunger) checkBlobSizes(ctx context.Context, branch string, max config.ByteSize) error {
+	if max <= 0 {
+		return nil
+	}
+	revs := p.unpublishedRevs(ctx, branch)
+	out, err := p.command(ctx, "git", append([]string{"log", "--format=commit %H", "--raw", "--no-abbrev", "--no-renames", "--diff-filter=AM"}, revs...)...).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list the changed files of branch %s: %v", branch, err)
+	}
+	changes := parseRawLog(string(out))
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var in bytes.Buffer
+	for _, c := range changes {
+		fmt.Fprintln(&in, c.blob)
+	}
+	cmd := p.command(ctx, "git", "cat-file", "--batch-check=%(objectsize)")
+	cmd.Stdin = &in
+	out, err = cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to get blob sizes of branch %s: %v", branch, err)
+	}
+	sizes := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(sizes) != len(changes) {
+		return fmt.Errorf("failed to get blob sizes of branch %s: expected %d sizes, got %d", branch, len(changes), len(sizes))
+	}
+
+	var oversized []string
+	for i, c := range changes {
+		size, err := strconv.ParseInt(sizes[i], 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to get size of %s in commit %s: %v", c.path, c.commit, err)
+		}
+		if config.ByteSize(size) <= max {
+			continue
+		}
+		source := p.sourceCommitOf(ctx, c.commit)
+		if source == "" {
+			source = "unknown"
+		}
+		oversized = append(oversized, fmt.Sprintf("%s (%d bytes) in commit %s, source commit %s", c.path, size, c.commit, source))
+	}
+	if len(oversized) > 0 {
+		return fmt.Errorf("branch %s contains files larger than max-blob-size %s:\n  %s", branch, max, strings.Join(oversized, "\n  "))
+	}
+	return nil
+}
+
+// parseRawLog returns the added and modified files of the output of
+// "git log --format='commit %H' --raw --no-abbrev".
+func parseRawLog(out string) []blobChange {
+	var changes []blobChange
+	commit := ""
+	for _, l := range strings.Split(out, "\n") {
+		if strings.HasPrefix(l, "commit ") {
+			commit = strings.TrimPrefix(l, "commit ")
+			continue
+		}
+		if !strings.HasPrefix(l, ":") {
+			continue
+		}
+		// :<old mode> <new mode> <old blob> <new blob> <status>\t<path>
+		ss := strings.SplitN(l, "\t", 2)
+		fields := strings.Fields(ss[0])
+		if len(ss) != 2 || len(fields) != 5 || fields[1] == "160000" {
+			continue // submodules have no blob
+		}
+		changes = append(changes, blobChange{commit: commit, path: ss[1], blob: fields[3]})
+	}
+	return changes
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestParseRawLog(t *testing.T) {
+	out := `commit 1111111111111111111111111111111111111111
+
+:000000 100644 0000000000000000000000000000000000000000 aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa A	bin/test.bin
+:100644 100644 bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb cccccccccccccccccccccccccccccccccccccccc M	docs/some file.md
+commit 2222222222222222222222222222222222222222
+
+:000000 160000 0000000000000000000000000000000000000000 dddddddddddddddddddddddddddddddddddddddd A	third_party/module
+:000000 100755 0000000000000000000000000000000000000000 eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee A	hack/run.sh
+`
+	expected := []blobChange{
+		{"1111111111111111111111111111111111111111", "bin/test.bin", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{"1111111111111111111111111111111111111111", "docs/some file.md", "cccccccccccccccccccccccccccccccccccccccc"},
+		{"2222222222222222222222222222222222222222", "hack/run.sh", "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"},
+	}
+	if got := parseRawLog(out); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestCheckBlobSizesNewBranch(t *testing.T) {
+	_, git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	newBranch(t, git, "old.bin", strings.Repeat("x", 100))
+	commitFile(t, git, "small.txt", "small", "new small file")
+
+	p := &PublisherMunger{config: &config.Config{}}
+	if err := p.checkBlobSizes(context.Background(), "release-1.0", 10); err != nil {
+		t.Errorf("expected the published large file to be ignored, got: %v", err)
+	}
+
+	commitFile(t, git, "new.bin", strings.Repeat("x", 100), "new large file")
+	err := p.checkBlobSizes(context.Background(), "release-1.0", 10)
+	if err == nil || !strings.Contains(err.Error(), "new.bin") || strings.Contains(err.Error(), "old.bin") {
+		t.Errorf("expected an error about new.bin only, got: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// ensureDestinationRepo creates the destination repo via the GitHub API if it
+// was not cloned before and does not exist. It returns true if the repo is
+// skipped because it is missing in dry-run mode.
+func (p *PublisherMunger) ensureDestinationRepo(ctx context.Context, repoRule config.RepositoryRule, dstDir string) (bool, error) {
+	if _, err := os.Stat(dstDir); err == nil {
+		return false, nil
+	}
+	repo := repoRule.DestinationRepository
+	tokenRef, err := p.config.PushTokenRef(repo)
+	if err != nil {
+		if p.config.DryRun {
+			return false, nil // cannot check, let the clone tell
+		}
+		return false, err
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return false, err
+	}
+	return p.ensureRepository(ctx, githubClient(ctx, token), repoRule)
+}
+
+// ensureRepository creates the destination repo if it does not exist. It
+// returns true if the repo is skipped because it is missing in dry-run mode.
+func (p *PublisherMunger) ensureRepository(ctx context.Context, client *github.Client, repoRule config.RepositoryRule) (bool, error) {
+	repo := repoRule.DestinationRepository
+	exists, err := repositoryExists(ctx, client, p.config.TargetOrg, repo)
+	if err != nil || exists {
+		return false, err
+	}
+	if p.config.DryRun {
+		for _, branchRule := range repoRule.Branches {
+			p.skipDstBranch(repo, branchRule.Name, "the repository does not exist and is not created in dry-run mode")
+		}
+		return true, nil
+	}
+	if err := createRepository(ctx, client, p.config.TargetOrg, repo, repoRule.Metadata.Description); err != nil {
+		return false, err
+	}
+	p.plog.Infof("Created %s/%s to bootstrap it with mode %s", p.config.TargetOrg, repo, repoRule.Bootstrap)
+	return false, nil
+}
+
+// hasRemoteBranches returns whether origin of the repository in the current
+// directory has any branch.
+func (p *PublisherMunger) hasRemoteBranches(ctx context.Context) (bool, error) {
+	out, err := p.command(ctx, "git", "for-each-ref", "--count=1", "--format=%(refname)", "refs/remotes/origin/").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to list remote branches: %v", err)
+	}
+	return len(strings.TrimSpace(string(out))) > 0, nil
+}
+
+// squashBranch replaces the history of the constructed branch in the current
+// directory by a single commit with the same tree. It points back to the
+// latest source commit such that later runs continue from there. Tags of the
+// replaced history are not pushed.
+func (p *PublisherMunger) squashBranch(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) error {
+	source := p.sourceCommitOf(ctx, branchRule.Name)
+	if source == "" {
+		return fmt.Errorf("no source commit found on branch %s", branchRule.Name)
+	}
+	tree, err := p.command(ctx, "git", "rev-parse", branchRule.Name+"^{tree}").Output()
+	if err != nil {
+		return fmt.Errorf("failed to get the tree of branch %s: %v", branchRule.Name, err)
+	}
+	msg := fmt.Sprintf("Initial commit\n\nSquashed history of %s in %s/%s.\n\n%s: %s\n",
+		branchRule.Source.Dir, p.config.SourceOrg, p.config.SourceRepo, config.CommitMsgTag(p.config.SourceRepo), source)
+	if signoff := p.signoff(repoRule); signoff != "" {
+		msg += "Signed-off-by: " + signoff + "\n"
+	}
+	commit, err := p.command(ctx, "git", "commit-tree", strings.TrimSpace(string(tree)), "-m", msg).Output()
+	if err != nil {
+		return fmt.Errorf("failed to create squashed commit of branch %s: %v", branchRule.Name, err)
+	}
+	if err := p.plog.Run(p.command(ctx, "git", "update-ref", "refs/heads/"+branchRule.Name, strings.TrimSpace(string(commit)))); err != nil {
+		return err
+	}
+	// push.sh expects the tag script of construct.sh
+	pushTags := p.path(fmt.Sprintf("../push-tags-%s-%s.sh", repoRule.DestinationRepository, branchRule.Name))
+	if err := ioutil.WriteFile(pushTags, []byte("#!/bin/bash\n"), 0755); err != nil {
+		return err
+	}
+	p.plog.Infof("Squashed branch %s of %s into %s", branchRule.Name, repoRule.DestinationRepository, strings.TrimSpace(string(commit)))
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestEnsureRepository(t *testing.T) {
+	var created []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/k8s-publishing-bot/client-go":
+			fmt.Fprint(w, `{"name": "client-go"}`)
+		case r.Method == "POST" && r.URL.Path == "/orgs/k8s-publishing-bot/repos":
+			var repo github.Repository
+			if err := json.NewDecoder(r.Body).Decode(&repo); err != nil {
+				t.Error(err)
+				return
+			}
+			created = append(created, repo.GetName()+": "+repo.GetDescription())
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	tests := []struct {
+		name    string
+		repo    string
+		dryRun  bool
+		skip    bool
+		created []string
+		skipped []string
+	}{
+		{"existing", "client-go", false, false, nil, nil},
+		{"existing in dry-run", "client-go", true, false, nil, nil},
+		{"missing", "api", false, false, []string{"api: Kubernetes API"}, nil},
+		{"missing in dry-run", "api", true, true, nil, []string{"api/master", "api/release-1.10"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created = nil
+			buf := &bytes.Buffer{}
+			p := &PublisherMunger{
+				config:             &config.Config{TargetOrg: "k8s-publishing-bot", DryRun: tt.dryRun},
+				plog:               &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+				skippedDstBranches: map[string]string{},
+			}
+			repoRule := config.RepositoryRule{
+				DestinationRepository: tt.repo,
+				Branches:              []config.BranchRule{{Name: "master"}, {Name: "release-1.10"}},
+				Metadata:              config.RepositoryMetadata{Description: "Kubernetes API"},
+				Bootstrap:             config.BootstrapSquash,
+			}
+			skip, err := p.ensureRepository(context.Background(), client, repoRule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if skip != tt.skip {
+				t.Errorf("expected skip=%v, got %v", tt.skip, skip)
+			}
+			if !reflect.DeepEqual(created, tt.created) {
+				t.Errorf("expected created %q, got %q", tt.created, created)
+			}
+			var skipped []string
+			for _, b := range repoRule.Branches {
+				if p.dstBranchSkipped(tt.repo, b.Name) {
+					skipped = append(skipped, tt.repo+"/"+b.Name)
+				}
+			}
+			if !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("expected skipped branches %q, got %q", tt.skipped, skipped)
+			}
+		})
+	}
+}
+
+func TestSquashBranch(t *testing.T) {
+	_, git, cleanup := gitRepo(t)
+	defer cleanup()
+	// push-tags scripts are written next to the repository
+	if err := os.Mkdir("client-go", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("client-go"); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "-q")
+	git("config", "user.name", "Test")
+	git("config", "user.email", "test@example.com")
+	git("config", "commit.gpgsign", "false")
+	git("checkout", "-q", "-b", "master")
+
+	commitFile(t, git, "a.go", "package a\n", "first\n\nKubernetes-commit: 1111111111111111111111111111111111111111")
+	commitFile(t, git, "b.go", "package b\n", "second\n\nKubernetes-commit: 2222222222222222222222222222222222222222")
+	tree := git("rev-parse", "master^{tree}")
+
+	buf := &bytes.Buffer{}
+	p := &PublisherMunger{
+		config: &config.Config{SourceOrg: "kubernetes", SourceRepo: "kubernetes", Signoff: true,
+			GitIdentity: config.GitIdentity{Name: "Bot", Email: "bot@example.com"}},
+		plog: &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+	}
+	repoRule := config.RepositoryRule{DestinationRepository: "client-go"}
+	branchRule := config.BranchRule{Name: "master", Source: config.Source{Branch: "master", Dir: "staging/src/k8s.io/client-go"}}
+	if err := p.squashBranch(context.Background(), repoRule, branchRule); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := git("rev-list", "--count", "master"); n != "1" {
+		t.Errorf("expected a single commit, got %s", n)
+	}
+	if got := git("rev-parse", "master^{tree}"); got != tree {
+		t.Errorf("expected tree %s, got %s", tree, got)
+	}
+	if got := p.sourceCommitOf(context.Background(), "master"); got != "2222222222222222222222222222222222222222" {
+		t.Errorf("expected the latest source commit, got %q", got)
+	}
+	msg := git("log", "-1", "--format=%B", "master")
+	for _, s := range []string{"staging/src/k8s.io/client-go in kubernetes/kubernetes", "Signed-off-by: Bot <bot@example.com>"} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("expected %q in the commit message, got %q", s, msg)
+		}
+	}
+	if _, err := ioutil.ReadFile("../push-tags-client-go-master.sh"); err != nil {
+		t.Errorf("expected a push-tags script: %v", err)
+	}
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+const chatOpsPrefix = "/publishing-bot"
+
+const chatOpsHelp = "Commands:\n" +
+	"- `/publishing-bot status`: the result of the last run\n" +
+	"- `/publishing-bot retry`: start a run of all destination repos now\n" +
+	"- `/publishing-bot pause <repo>[/<branch>] [<reason>]`: stop publishing to a destination repo or branch\n" +
+	"- `/publishing-bot resume <repo>[/<branch>]`: publish to a paused destination repo or branch again\n" +
+	"- `/publishing-bot help`: this help"
+
+// chatOps polls GitHub comments for commands of allowed users and answers them
+// in a comment.
+type chatOps struct {
+	config *config.Config
+	server *Server
+	pauses *PauseState
+	// since is the creation time of the latest handled comment
+	since time.Time
+	// handled are the IDs of the comments handled at since. Commands are
+	// handled once they are answered.
+	handled map[int64]bool
+}
+
+func newChatOps(cfg *config.Config, server *Server, pauses *PauseState) *chatOps {
+	// commands given before the start are not replayed
+	return &chatOps{config: cfg, server: server, pauses: pauses, since: time.Now(), handled: map[int64]bool{}}
+}
+
+// repository returns the org and repo whose comments are polled.
+func (c *chatOps) repository() (string, string) {
+	if c.config.ChatOps.Repository != "" {
+		ss := strings.SplitN(c.config.ChatOps.Repository, "/", 2)
+		return ss[0], ss[1]
+	}
+	return c.config.TargetOrg, c.config.SourceRepo
+}
+
+// Run polls until the context is done.
+func (c *chatOps) Run(ctx context.Context) {
+	for {
+		if err := c.poll(ctx); err != nil {
+			glog.Errorf("Failed to poll chatops commands: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.config.ChatOps.Interval()):
+		}
+	}
+}
+
+func (c *chatOps) poll(ctx context.Context) error {
+	token, err := loadToken(c.config, c.config.TokenRef())
+	if err != nil {
+		return err
+	}
+	return c.pollComments(ctx, githubClient(ctx, token))
+}
+
+// pollComments handles the comments created since the last poll.
+func (c *chatOps) pollComments(ctx context.Context, client *github.Client) error {
+	org, repo := c.repository()
+
+	myself, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to get own user: %v", err)
+	}
+	opts := &github.IssueListCommentsOptions{Sort: "created", Direction: "asc", Since: c.since, ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, org, repo, 0, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list comments of %s/%s: %v", org, repo, err)
+		}
+		for _, comment := range comments {
+			// edited comments are listed again
+			if c.handled[comment.GetID()] || comment.GetCreatedAt().Before(c.since) || comment.GetUser().GetID() == myself.GetID() {
+				continue
+			}
+			if created := comment.GetCreatedAt(); created.After(c.since) {
+				c.since, c.handled = created, map[int64]bool{}
+			}
+
+			args, found := parseChatOpsCommand(comment.GetBody())
+			issue := issueNumber(comment.GetIssueURL())
+			if !found || issue == 0 || !c.watched(issue) {
+				c.handled[comment.GetID()] = true
+				continue
+			}
+			// on errors, the comment is handled again on the next poll
+			user := comment.GetUser().GetLogin()
+			var reply string
+			if allowed, err := c.allowed(ctx, client, user); err != nil {
+				return err
+			} else if !allowed {
+				reply = fmt.Sprintf("@%s is not allowed to give commands to the publishing-bot.", user)
+			} else {
+				glog.Infof("Executing chatops command %q of %s in %s/%s#%d", strings.Join(args, " "), user, org, repo, issue)
+				reply = fmt.Sprintf("@%s %s", user, c.execute(args))
+			}
+			if _, _, err := client.Issues.CreateComment(ctx, org, repo, issue, &github.IssueComment{Body: github.String(reply)}); err != nil {
+				return fmt.Errorf("failed to reply on %s/%s#%d: %v", org, repo, issue, err)
+			}
+			c.handled[comment.GetID()] = true
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// watched returns true if commands are accepted in the issue.
+func (c *chatOps) watched(issue int) bool {
+	if len(c.config.ChatOps.Issues) == 0 {
+		return true
+	}
+	for _, i := range c.config.ChatOps.Issues {
+		if i == issue {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed returns true if the user is in the allowlist or member of an allowed team.
+func (c *chatOps) allowed(ctx context.Context, client *github.Client, user string) (bool, error) {
+	for _, u := range c.config.ChatOps.AllowedUsers {
+		if strings.EqualFold(u, user) {
+			return true, nil
+		}
+	}
+	for _, t := range c.config.ChatOps.AllowedTeams {
+		ss := strings.SplitN(t, "/", 2)
+		team, err := findTeam(ctx, client, ss[0], ss[1])
+		if err != nil {
+			return false, err
+		}
+		if team == nil {
+			continue
+		}
+		m, _, err := client.Teams.GetTeamMembership(ctx, team.GetID(), user)
+		if err == nil && m.GetState() == "active" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// findTeam returns the team of the org with the given slug, or nil.
+func findTeam(ctx context.Context, client *github.Client, org, slug string) (*github.Team, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		teams, resp, err := client.Teams.ListTeams(ctx, org, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list teams of %s: %v", org, err)
+		}
+		for _, team := range teams {
+			if team.GetSlug() == slug {
+				return team, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// execute runs the command and returns the reply.
+func (c *chatOps) execute(args []string) string {
+	if len(args) == 0 {
+		return chatOpsHelp
+	}
+	switch args[0] {
+	case "status":
+		c.server.mutex.RLock()
+		result := c.server.result
+		c.server.mutex.RUnlock()
+		if result == nil {
+			return "No run finished yet."
+		}
+		return chatOpsStatus(*result, c.pauses.List())
+	case "retry":
+		// runs cannot be limited to some destinations, and a run started
+		// for a repo which does not publish it would be misleading
+		if len(args) > 1 {
+			return "Usage: `/publishing-bot retry`. A run publishes all destination repos, it cannot be limited to some of them."
+		}
+		select {
+		case c.server.RunChan <- true:
+			return "Started a run."
+		default:
+			return "A run is queued already."
+		}
+	case "pause":
+		if len(args) < 2 {
+			return "Usage: `/publishing-bot pause <repo>[/<branch>] [<reason>]`"
+		}
+		if reply := c.checkRule(strings.SplitN(args[1], "/", 2)); reply != "" {
+			return reply
+		}
+		if err := c.pauses.Pause(args[1], strings.Join(args[2:], " ")); err != nil {
+			return fmt.Sprintf("Failed to pause %s: %v", args[1], err)
+		}
+		return fmt.Sprintf("Paused %s.", args[1])
+	case "resume":
+		if len(args) != 2 {
+			return "Usage: `/publishing-bot resume <repo>[/<branch>]`"
+		}
+		resumed, err := c.pauses.Resume(args[1])
+		if err != nil {
+			return fmt.Sprintf("Failed to resume %s: %v", args[1], err)
+		} else if !resumed {
+			return fmt.Sprintf("%s is not paused.", args[1])
+		}
+		return fmt.Sprintf("Resumed %s.", args[1])
+	case "help":
+		return chatOpsHelp
+	}
+	return fmt.Sprintf("Unknown command %q.\n\n%s", args[0], chatOpsHelp)
+}
+
+// checkRule returns a reply if the destination repo, or the branch if given,
+// is not in the rules.
+func (c *chatOps) checkRule(args []string) string {
+	rules, err := config.LoadRules(c.config.RulesFile, c.config.Network.TLS)
+	if err != nil {
+		return fmt.Sprintf("Failed to load the rules: %v", err)
+	}
+	for _, r := range rules.Rules {
+		if r.DestinationRepository != args[0] {
+			continue
+		}
+		if len(args) == 1 {
+			return ""
+		}
+		for _, b := range r.Branches {
+			if b.Name == args[1] {
+				return ""
+			}
+		}
+		return fmt.Sprintf("Branch %s of %s is not published.", args[1], args[0])
+	}
+	return fmt.Sprintf("Repository %s is not published.", args[0])
+}
+
+// parseChatOpsCommand returns the arguments of the first command line.
+func parseChatOpsCommand(body string) ([]string, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == chatOpsPrefix {
+			return fields[1:], true
+		}
+	}
+	return nil, false
+}
+
+// issueNumber returns the number at the end of an issue API URL, or 0.
+func issueNumber(url string) int {
+	n, err := strconv.Atoi(path.Base(url))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// chatOpsStatus formats the result of a run as markdown.
+func chatOpsStatus(r RunResult, paused map[string]PausedRepo) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "The last run finished at %s: %s.\n", r.End.Format(time.RFC3339), r.Outcome())
+	if r.Error != "" {
+		fmt.Fprintf(&buf, "\nError: %s\n", r.Error)
+	}
+	if len(r.Branches) > 0 {
+		fmt.Fprintf(&buf, "\n| Repository | Branch | New commits | Pushed | Skipped |\n|---|---|---|---|---|\n")
+		for _, b := range r.Branches {
+			fmt.Fprintf(&buf, "| %s | %s | %d | %v | %s |\n", b.Repository, b.Branch, b.Commits, b.Pushed, b.Skipped)
+		}
+	}
+	for _, key := range sortedPauseKeys(paused) {
+		p := paused[key]
+		fmt.Fprintf(&buf, "\n%s is paused since %s: %s\n", key, p.Since.Format(time.RFC3339), p.Reason)
+	}
+	return buf.String()
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+func TestParseChatOpsCommand(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected []string
+		found    bool
+	}{
+		{"/publishing-bot status", []string{"status"}, true},
+		{"thanks!\n/publishing-bot  retry client-go release-1.27\n", []string{"retry", "client-go", "release-1.27"}, true},
+		{"/publishing-bot", []string{}, true},
+		{"please run /publishing-bot status", nil, false},
+		{"/publishing-botstatus", nil, false},
+	}
+	for _, tt := range tests {
+		args, found := parseChatOpsCommand(tt.body)
+		if found != tt.found || (found && !reflect.DeepEqual(args, tt.expected)) {
+			t.Errorf("%q: expected %q, %v, got %q, %v", tt.body, tt.expected, tt.found, args, found)
+		}
+	}
+}
+
+func TestIssueNumber(t *testing.T) {
+	if n := issueNumber("https://api.github.com/repos/kubernetes/kubernetes/issues/56916"); n != 56916 {
+		t.Errorf("expected 56916, got %d", n)
+	}
+	if n := issueNumber(""); n != 0 {
+		t.Errorf("expected 0, got %d", n)
+	}
+}
+
+func TestChatOpsExecute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chatops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rulesFile := filepath.Join(dir, "rules.yaml")
+	err = ioutil.WriteFile(rulesFile, []byte(`
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: master
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pauses, err := LoadPauseState(state.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newChatOps(&config.Config{RulesFile: rulesFile}, &Server{RunChan: make(chan bool, 1)}, pauses)
+
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"status", "No run finished yet"},
+		{"retry client-go master", "cannot be limited"},
+		{"retry", "Started a run"},
+		{"retry", "A run is queued already"},
+		{"pause client-go broken build", "Paused client-go"},
+		{"resume client-go", "Resumed client-go"},
+		{"resume client-go", "client-go is not paused"},
+		{"pause client-go/release-1.0", "Branch release-1.0 of client-go is not published"},
+		{"pause client-go/master", "Paused client-go/master"},
+		{"resume client-go/master", "Resumed client-go/master"},
+		{"frobnicate", "Unknown command"},
+	}
+	for _, tt := range tests {
+		if got := c.execute(strings.Fields(tt.command)); !strings.Contains(got, tt.expected) {
+			t.Errorf("%q: expected reply containing %q, got %q", tt.command, tt.expected, got)
+		}
+	}
+}
+
+func TestChatOpsPollRetriesOnTeamErrors(t *testing.T) {
+	teamsUnavailable := true
+	var replies []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/user":
+			fmt.Fprint(w, `{"id": 1, "login": "bot"}`)
+		case r.Method == "GET" && r.URL.Path == "/repos/kubernetes/kubernetes/issues/comments":
+			fmt.Fprintf(w, `[{"id": 10, "body": "/publishing-bot help", "user": {"id": 2, "login": "alice"},
+				"issue_url": "%s/repos/kubernetes/kubernetes/issues/5", "created_at": %q}]`,
+				server.URL, time.Now().Add(time.Hour).Format(time.RFC3339))
+		case r.URL.Path == "/orgs/kubernetes/teams":
+			if teamsUnavailable {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			if r.URL.Query().Get("page") != "2" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s/orgs/kubernetes/teams?page=2>; rel="next"`, server.URL))
+				fmt.Fprint(w, `[{"id": 6, "slug": "other"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"id": 7, "slug": "publishers"}]`)
+		case r.URL.Path == "/teams/7/memberships/alice":
+			fmt.Fprint(w, `{"state": "active"}`)
+		case r.Method == "POST" && r.URL.Path == "/repos/kubernetes/kubernetes/issues/5/comments":
+			var c github.IssueComment
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				t.Error(err)
+				return
+			}
+			replies = append(replies, c.GetBody())
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	cfg := &config.Config{TargetOrg: "kubernetes", SourceRepo: "kubernetes", ChatOps: config.ChatOps{AllowedTeams: []string{"kubernetes/publishers"}}}
+	c := newChatOps(cfg, &Server{}, nil)
+	if err := c.pollComments(context.Background(), client); err == nil {
+		t.Fatal("expected an error while the teams are unavailable")
+	}
+	if len(replies) != 0 {
+		t.Fatalf("expected no reply, got %q", replies)
+	}
+
+	teamsUnavailable = false
+	if err := c.pollComments(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "@alice Commands:") {
+		t.Fatalf("expected the help for @alice, got %q", replies)
+	}
+
+	if err := c.pollComments(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 {
+		t.Errorf("expected the command to be handled once, got %q", replies)
+	}
+}
+
+func TestChatOpsPollRetriesFailedReplies(t *testing.T) {
+	replyFails := true
+	var replies []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/user":
+			fmt.Fprint(w, `{"id": 1, "login": "bot"}`)
+		case r.Method == "GET" && r.URL.Path == "/repos/kubernetes/kubernetes/issues/comments":
+			fmt.Fprintf(w, `[{"id": 10, "body": "/publishing-bot help", "user": {"id": 2, "login": "alice"},
+				"issue_url": "%s/repos/kubernetes/kubernetes/issues/5", "created_at": %q}]`,
+				server.URL, time.Now().Add(time.Hour).Format(time.RFC3339))
+		case r.Method == "POST" && r.URL.Path == "/repos/kubernetes/kubernetes/issues/5/comments":
+			if replyFails {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			var c github.IssueComment
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				t.Error(err)
+				return
+			}
+			replies = append(replies, c.GetBody())
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	cfg := &config.Config{TargetOrg: "kubernetes", SourceRepo: "kubernetes", ChatOps: config.ChatOps{AllowedUsers: []string{"alice"}}}
+	c := newChatOps(cfg, &Server{}, nil)
+	if err := c.pollComments(context.Background(), client); err == nil {
+		t.Fatal("expected an error while replies fail")
+	}
+
+	replyFails = false
+	for i := 0; i < 2; i++ {
+		if err := c.pollComments(context.Background(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "@alice Commands:") {
+		t.Fatalf("expected the help for @alice once, got %q", replies)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+const checkpointFileName = "publisher-checkpoint.json"
+
+// Checkpoint records how far the last publishing run got. It is written
+// whenever a run is interrupted, e.g. by SIGTERM, such that the next run can
+// tell that the workspace might be in an intermediate state.
+type Checkpoint struct {
+	Phase        string    `json:"phase"`
+	Repository   string    `json:"repository,omitempty"`
+	Branch       string    `json:"branch,omitempty"`
+	UpstreamHash string    `json:"upstreamHash,omitempty"`
+	Interrupted  bool      `json:"interrupted"`
+	Time         time.Time `json:"time"`
+}
+
+// LoadCheckpoint reads the checkpoint from the state store. It returns nil if
+// there is none.
+func LoadCheckpoint(store state.Store) (*Checkpoint, error) {
+	bs, err := store.Read(checkpointFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(bs, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// Save writes the checkpoint into the state store.
+func (cp *Checkpoint) Save(store state.Store) error {
+	cp.Time = time.Now()
+	bs, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Write(checkpointFileName, bs)
+}
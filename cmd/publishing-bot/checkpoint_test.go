@@ -0,0 +1,61 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := state.Dir(dir)
+
+	cp, err := LoadCheckpoint(store)
+	if err != nil || cp != nil {
+		t.Fatalf("expected no checkpoint in an empty store, got %v, %v", cp, err)
+	}
+
+	saved := &Checkpoint{Phase: "construct", Repository: "client-go", Branch: "master", UpstreamHash: "0123456", Interrupted: true}
+	if err := saved.Save(store); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Time.IsZero() {
+		t.Errorf("expected Save to set the time")
+	}
+	cp, err = LoadCheckpoint(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Phase != "construct" || cp.Repository != "client-go" || cp.Branch != "master" || cp.UpstreamHash != "0123456" || !cp.Interrupted || !cp.Time.Equal(saved.Time) {
+		t.Errorf("expected %+v to be loaded, got %+v", saved, cp)
+	}
+
+	if err := store.Write(checkpointFileName, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCheckpoint(store); err == nil {
+		t.Errorf("expected an error for a corrupt checkpoint")
+	}
+}
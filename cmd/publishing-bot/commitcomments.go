@@ -0,0 +1,101 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// maxCommitComments limits the comments per run, e.g. after a long outage.
+const maxCommitComments = 50
+
+// ReportCommitComments comments the destination commits on each source commit
+// published in the run, such that contributors see where their change went.
+// Merge commits of pull requests are commented on the pull request instead.
+func ReportCommitComments(ctx context.Context, cfg *config.Config, token string, result RunResult) error {
+	client := githubClient(ctx, token)
+	sources, bodies := commitComments(cfg, result)
+	if len(sources) > maxCommitComments {
+		glog.Warningf("Commenting only %d of %d published source commits", maxCommitComments, len(sources))
+		sources = sources[:maxCommitComments]
+	}
+	var errs []error
+	for _, sha := range sources {
+		commit, _, err := client.Git.GetCommit(ctx, cfg.SourceOrg, cfg.SourceRepo, sha)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get source commit %s: %v", sha, err))
+			continue
+		}
+		if pr := pullRequestOfMerge(commit.GetMessage()); pr != 0 {
+			body := fmt.Sprintf("Commit %s of this pull request was published to:\n\n%s", sha, bodies[sha])
+			if _, _, err := client.Issues.CreateComment(ctx, cfg.SourceOrg, cfg.SourceRepo, pr, &github.IssueComment{Body: github.String(body)}); err != nil {
+				errs = append(errs, fmt.Errorf("failed to comment on pull request #%d: %v", pr, err))
+			}
+			continue
+		}
+		body := "This commit was published to:\n\n" + bodies[sha]
+		if _, _, err := client.Repositories.CreateComment(ctx, cfg.SourceOrg, cfg.SourceRepo, sha, &github.RepositoryComment{Body: github.String(body)}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to comment on source commit %s: %v", sha, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// commitComments returns the source commits published to pushed branches in
+// the order of the result, and the markdown list of their destination commits.
+func commitComments(cfg *config.Config, result RunResult) ([]string, map[string]string) {
+	var sources []string
+	lists := map[string]*bytes.Buffer{}
+	for _, b := range result.Branches {
+		if !b.Pushed || b.Skipped != "" {
+			continue
+		}
+		for _, c := range b.PublishedCommits {
+			buf, found := lists[c.SourceCommit]
+			if !found {
+				buf = &bytes.Buffer{}
+				lists[c.SourceCommit] = buf
+				sources = append(sources, c.SourceCommit)
+			}
+			fmt.Fprintf(buf, "- [%s/%s@%s](https://%s/%s/%s/commit/%s) on branch %s\n",
+				cfg.TargetOrg, b.Repository, shortSHA(c.Commit), cfg.GithubHost, cfg.TargetOrg, b.Repository, c.Commit, b.Branch)
+		}
+	}
+	bodies := make(map[string]string, len(lists))
+	for sha, buf := range lists {
+		bodies[sha] = buf.String()
+	}
+	return sources, bodies
+}
+
+// shortSHA abbreviates a commit hash to 7 characters.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestCommitComments(t *testing.T) {
+	cfg := &config.Config{GithubHost: "github.com", TargetOrg: "kubernetes"}
+	result := RunResult{Branches: []BranchResult{
+		{Repository: "api", Branch: "master", Pushed: true, PublishedCommits: []PublishedCommit{
+			{Commit: "aaaaaaaaaa", SourceCommit: "s2"},
+			{Commit: "bbbbbbbbbb", SourceCommit: "s1"},
+		}},
+		{Repository: "client-go", Branch: "master", Pushed: true, PublishedCommits: []PublishedCommit{
+			{Commit: "cccccccccc", SourceCommit: "s2"},
+		}},
+		// dry-run or failed
+		{Repository: "apimachinery", Branch: "master", PublishedCommits: []PublishedCommit{
+			{Commit: "dddddddddd", SourceCommit: "s3"},
+		}},
+	}}
+
+	sources, bodies := commitComments(cfg, result)
+	if expected := []string{"s2", "s1"}; !reflect.DeepEqual(sources, expected) {
+		t.Errorf("expected source commits %v, got %v", expected, sources)
+	}
+	expected := "- [kubernetes/api@aaaaaaa](https://github.com/kubernetes/api/commit/aaaaaaaaaa) on branch master\n" +
+		"- [kubernetes/client-go@ccccccc](https://github.com/kubernetes/client-go/commit/cccccccccc) on branch master\n"
+	if bodies["s2"] != expected {
+		t.Errorf("expected comment %q, got %q", expected, bodies["s2"])
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "fmt"
+
+// Attestation configures signed in-toto statements binding the pushed
+// destination refs to their source commit, the rules and the bot version. They
+// are signed with cosign, which uploads the signature to the Rekor transparency
+// log by default.
+type Attestation struct {
+	// Enabled attests every pushed branch and new tag.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Key is a secret reference to a cosign private key. Without it, cosign
+	// signs keyless with the ambient OIDC identity, e.g. a workload identity.
+	Key string `yaml:"key,omitempty"`
+	// KeyPassword is a secret reference to the password of the key.
+	KeyPassword string `yaml:"key-password,omitempty"`
+	// SkipRekor does not upload the signatures to the transparency log.
+	SkipRekor bool `yaml:"skip-rekor,omitempty"`
+	// Releases attaches the statements and signature bundles of new tags to
+	// their GitHub releases, which are created if missing.
+	Releases bool `yaml:"releases,omitempty"`
+	// Command is a bash script replacing the cosign invocation. It signs the
+	// statement in the file PUBLISHER_BOT_ATTESTATION and writes the signature
+	// bundle to the file PUBLISHER_BOT_ATTESTATION_BUNDLE.
+	Command string `yaml:"command,omitempty"`
+}
+
+// Validate checks that the settings are only used when enabled.
+func (a Attestation) Validate() error {
+	if !a.Enabled && (a.Key != "" || a.KeyPassword != "" || a.SkipRekor || a.Releases || a.Command != "") {
+		return fmt.Errorf("enabled is required")
+	}
+	if a.KeyPassword != "" && a.Key == "" {
+		return fmt.Errorf("key-password requires key")
+	}
+	if a.Command != "" && (a.Key != "" || a.SkipRekor) {
+		return fmt.Errorf("key and skip-rekor are not used with command")
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// BitbucketServer is a repository on a Bitbucket Server or Data Center
+// instance a destination repo is mirrored to.
+type BitbucketServer struct {
+	// URL is the base URL of the instance, including the context path if any,
+	// e.g. https://bitbucket.example.com or https://example.com/bitbucket.
+	URL string `yaml:"url"`
+	// Project is the key of the project, e.g. K8S, or ~<user> for a personal
+	// repository.
+	Project string `yaml:"project"`
+	// Repository is the name of the repository. Defaults to the destination repo.
+	Repository string `yaml:"repository,omitempty"`
+	// SSHPort pushes via ssh://git@<host>:<port>/<project>/<repo>.git with the
+	// SSH key of the bot instead of via https with the token, e.g. 7999.
+	SSHPort int `yaml:"ssh-port,omitempty"`
+	// CreateRepos creates the repository in the project via the REST API if it
+	// does not exist.
+	CreateRepos bool `yaml:"create-repos,omitempty"`
+	// PullRequests pushes the branches to branches with the
+	// PullRequestBranchPrefix and opens pull requests from them to the
+	// branches instead of pushing the branches directly.
+	PullRequests bool `yaml:"pull-requests,omitempty"`
+	// PullRequestBranchPrefix is the prefix of the branches pull requests are
+	// opened from. Defaults to publishing-bot/.
+	PullRequestBranchPrefix string `yaml:"pull-request-branch-prefix,omitempty"`
+}
+
+// DefaultPullRequestBranchPrefix is the default prefix of the branches pull
+// requests are opened from.
+const DefaultPullRequestBranchPrefix = "publishing-bot/"
+
+// Validate checks the base URL and the project.
+func (b *BitbucketServer) Validate() error {
+	u, err := url.Parse(b.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url %q must be an http or https URL", b.URL)
+	}
+	if b.Project == "" {
+		return fmt.Errorf("project is required")
+	}
+	if b.SSHPort < 0 {
+		return fmt.Errorf("invalid ssh-port %d", b.SSHPort)
+	}
+	if b.PullRequestBranchPrefix != "" && !b.PullRequests {
+		return fmt.Errorf("pull-request-branch-prefix requires pull-requests")
+	}
+	if p := b.PullRequestBranchPrefix; strings.HasPrefix(p, "/") || strings.Contains(p, "..") || strings.ContainsAny(p, " ~^:?*[\\") {
+		return fmt.Errorf("invalid pull-request-branch-prefix %q", p)
+	}
+	return nil
+}
+
+// BranchPrefix returns the prefix of the branches pull requests are opened
+// from, or an empty string if the branches are pushed directly.
+func (b *BitbucketServer) BranchPrefix() string {
+	if !b.PullRequests {
+		return ""
+	}
+	if b.PullRequestBranchPrefix == "" {
+		return DefaultPullRequestBranchPrefix
+	}
+	return b.PullRequestBranchPrefix
+}
+
+// Slug returns the slug of the repository, the lower case name with dashes
+// instead of spaces, with the destination repo as default name.
+func (b *BitbucketServer) Slug(repo string) string {
+	if b.Repository != "" {
+		repo = b.Repository
+	}
+	return strings.ToLower(strings.Replace(repo, " ", "-", -1))
+}
+
+// CloneURL returns the URL to push the destination repo to.
+func (b *BitbucketServer) CloneURL(repo string) string {
+	base := strings.TrimSuffix(b.URL, "/")
+	project := strings.ToLower(b.Project)
+	if b.SSHPort != 0 {
+		u, _ := url.Parse(base)
+		return fmt.Sprintf("ssh://git@%s:%d/%s/%s.git", u.Hostname(), b.SSHPort, project, b.Slug(repo))
+	}
+	return fmt.Sprintf("%s/scm/%s/%s.git", base, project, b.Slug(repo))
+}
+
+// RepositoryAPIURL returns the REST API URL of the repository, or of the
+// repositories of the project if repo is empty.
+func (b *BitbucketServer) RepositoryAPIURL(repo string) string {
+	u := fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos", strings.TrimSuffix(b.URL, "/"), url.PathEscape(b.Project))
+	if repo != "" {
+		u += "/" + url.PathEscape(b.Slug(repo))
+	}
+	return u
+}
+
+// PullRequestsAPIURL returns the REST API URL of the pull requests of the
+// repository.
+func (b *BitbucketServer) PullRequestsAPIURL(repo string) string {
+	return b.RepositoryAPIURL(repo) + "/pull-requests"
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "fmt"
+
+// CanaryRemote is the name of the git remote of the canary repos.
+const CanaryRemote = "canary"
+
+// Canary publishes to a shadow org, e.g. to validate a new version of the bot
+// or rules changes against the real source history.
+type Canary struct {
+	// Org is the shadow org every destination repo is pushed to in addition to
+	// the target org. Canary branches and tags are force-pushed.
+	Org string `yaml:"org,omitempty"`
+	// Only pushes to the canary org instead of the target org. The destination
+	// repos are still constructed on top of the target org, but neither pushed
+	// to nor changed via the GitHub API.
+	Only bool `yaml:"only,omitempty"`
+	// Token is a secret reference to the token of the canary org. Defaults to
+	// the global token.
+	Token string `yaml:"token,omitempty"`
+	// CreateRepos creates missing canary repos via the GitHub API.
+	CreateRepos bool `yaml:"create-repos,omitempty"`
+}
+
+// Enabled returns true if there is a canary org.
+func (c Canary) Enabled() bool {
+	return c.Org != ""
+}
+
+// Validate checks that the canary org differs from the target org.
+func (c Canary) Validate(targetOrg string) error {
+	if !c.Enabled() {
+		if c.Only || c.Token != "" || c.CreateRepos {
+			return fmt.Errorf("org is required")
+		}
+		return nil
+	}
+	if c.Org == targetOrg {
+		return fmt.Errorf("org %s is the target-org", c.Org)
+	}
+	return nil
+}
+
+// PushTarget returns the push target of the canary repo of the given
+// destination repo.
+func (c Canary) PushTarget(githubHost, repo string) PushTarget {
+	return PushTarget{
+		Name:      CanaryRemote,
+		URL:       fmt.Sprintf("https://%s/%s/%s", githubHost, c.Org, repo),
+		TokenFile: c.Token,
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultChatOpsPollInterval is how often comments are polled by default.
+const DefaultChatOpsPollInterval = time.Minute
+
+// ChatOps configures commands given to the bot in GitHub issue and pull
+// request comments, like "/publishing-bot status".
+type ChatOps struct {
+	// Repository is the <org>/<repo> whose comments are polled. Defaults to
+	// <target-org>/<source-repo>, where the github-issue is.
+	Repository string `yaml:"repository,omitempty"`
+	// Issues limits the commands to these issues and pull requests.
+	Issues []int `yaml:"issues,omitempty"`
+	// AllowedUsers are the GitHub logins allowed to give commands.
+	AllowedUsers []string `yaml:"allowed-users,omitempty"`
+	// AllowedTeams are the teams, as <org>/<team-slug>, whose members are
+	// allowed to give commands.
+	AllowedTeams []string `yaml:"allowed-teams,omitempty"`
+	// PollInterval defaults to DefaultChatOpsPollInterval.
+	PollInterval time.Duration `yaml:"poll-interval,omitempty"`
+}
+
+// Enabled returns true if anybody is allowed to give commands.
+func (c ChatOps) Enabled() bool {
+	return len(c.AllowedUsers) > 0 || len(c.AllowedTeams) > 0
+}
+
+// Interval returns the poll interval.
+func (c ChatOps) Interval() time.Duration {
+	if c.PollInterval <= 0 {
+		return DefaultChatOpsPollInterval
+	}
+	return c.PollInterval
+}
+
+// Validate checks the repository and team names.
+func (c ChatOps) Validate() error {
+	if c.Repository != "" {
+		if ss := strings.Split(c.Repository, "/"); len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+			return fmt.Errorf("invalid repository %q: expected <org>/<repo>", c.Repository)
+		}
+	}
+	for _, t := range c.AllowedTeams {
+		if ss := strings.Split(t, "/"); len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+			return fmt.Errorf("invalid team %q: expected <org>/<team-slug>", t)
+		}
+	}
+	return nil
+}
@@ -16,6 +16,14 @@ limitations under the License.
 
 package config
 
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
 // Config is how we are configured to talk to github.
 type Config struct {
 	// GithubHost is the address for github.
@@ -36,19 +44,292 @@ type Config struct {
 	// the source repo org name, e.g. "kubernetes"
 	SourceOrg string `yaml:"source-org"`
 
+	// SourceURL is an arbitrary git URL to clone the source repo from, e.g. a
+	// Gerrit instance or an internal mirror (https://, ssh://, file:// or
+	// scp-like). If empty, it defaults to https://${GithubHost}/${SourceOrg}/${SourceRepo}.
+	SourceURL string `yaml:"source-url,omitempty"`
+
+	// SourceSeed is a git bundle (.bundle) or a tarball of a clone the source repo
+	// is initialized from before fetching the delta, e.g. gs://bucket/kubernetes.bundle,
+	// s3://bucket/kubernetes.tar.gz, an https:// URL or a local file. Object storage
+	// is accessed through its public HTTPS endpoint.
+	SourceSeed string `yaml:"source-seed,omitempty"`
+
+	// SourcePath is an existing local checkout of the source repo, e.g. the
+	// workspace of a CI job, published from instead of a clone. It is not
+	// fetched. Local source branches with commits not on their origin branches
+	// are reset to them, missing ones are created from them.
+	SourcePath string `yaml:"source-path,omitempty"`
+
+	// SourceForcePush is what happens to a destination branch when commits of
+	// its source branch which were published are not in its history anymore,
+	// e.g. after a force-push: halt (default) fails the run with a report,
+	// rebuild constructs the branch from scratch and keeps the old history
+	// under a backup branch.
+	SourceForcePush string `yaml:"source-force-push,omitempty"`
+
+	// ArchivedRepos is what happens to destination repos archived on GitHub,
+	// but not configured to be: skip (default) skips them with a note in the
+	// status, unarchive unarchives them for the push of new commits or tags
+	// and archives them again afterwards.
+	ArchivedRepos string `yaml:"archived-repos,omitempty"`
+
 	// the file with the clear-text github token
 	TokenFile string `yaml:"token-file,omitempty"`
 
+	// Token is a secret reference to the github token, used instead of TokenFile
+	// if set: file:<path>, env:<variable> or k8s:<secret>/<key>.
+	Token string `yaml:"token,omitempty"`
+
+	// ReadToken is a secret reference to a read-only token used to fetch the
+	// source repo and to clone the destination repos via https. If empty, they
+	// are fetched anonymously.
+	ReadToken string `yaml:"read-token,omitempty"`
+
+	// PushTokens are secret references to the tokens used to push to and to
+	// update the destination repos, keyed by <org> or <org>/<repo>. Only keys in
+	// the target org are allowed. If empty, the global token is used.
+	PushTokens map[string]string `yaml:"push-tokens,omitempty"`
+
+	// SSHKey is a secret reference to the SSH private key git uses for ssh:// remotes.
+	SSHKey string `yaml:"ssh-key,omitempty"`
+
+	// GPGKey is a secret reference to an armored GPG key imported into the
+	// keyring of the bot.
+	GPGKey string `yaml:"gpg-key,omitempty"`
+
+	// KubernetesSecretsDir is where the secrets referenced as k8s:<secret>/<key>
+	// are mounted, one directory per secret. Defaults to /etc/secrets.
+	KubernetesSecretsDir string `yaml:"kubernetes-secrets-dir,omitempty"`
+
+	// Interval is the wait between publishing runs if -interval is not given,
+	// e.g. 4h. Zero means a single run.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// PushInterval is the minimum wait between two pushes of new commits to
+	// destination branches, e.g. 1m, such that downstream CI triggered by every
+	// push is not overwhelmed after an outage.
+	PushInterval time.Duration `yaml:"push-interval,omitempty"`
+
+	// StateStore is where the checkpoint, the batch state, the paused repos,
+	// the pushed heads and the email digest are kept between runs: a directory,
+	// gs://<bucket>/<prefix>, s3://<bucket>/<prefix> or
+	// configmap://<namespace>/<name>. Defaults to the base repo path.
+	StateStore string `yaml:"state-store,omitempty"`
+
+	// Attestation signs statements about the provenance of the pushed refs.
+	Attestation Attestation `yaml:"attestation,omitempty"`
+
+	// Notes adds the source commit, run and rules digest of each published
+	// commit as git notes in refs/notes/publishing-bot of the destination repos.
+	Notes bool `yaml:"notes,omitempty"`
+
+	// Canary additionally or exclusively publishes to a shadow org.
+	Canary Canary `yaml:"canary,omitempty"`
+
 	// the file that contain the repository rules
 	RulesFile string `yaml:"rules-file"`
 
 	// If true, don't make any mutating API calls
 	DryRun bool
 
+	// Provenance records where the effective values came from.
+	Provenance Provenance `yaml:"-"`
+
 	// A github issue number to report errors
 	GithubIssue int `yaml:"github-issue,omitempty"`
 
+	// FailureIssues files an issue per failure signature instead.
+	FailureIssues FailureIssues `yaml:"failure-issues,omitempty"`
+
+	// CommitStatuses enables setting a commit status per destination repo on
+	// the published source commits. The token needs the repo:status scope for
+	// the source repo.
+	CommitStatuses bool `yaml:"commit-statuses,omitempty"`
+
+	// CommitComments enables a comment listing the destination commits on the
+	// source commits published in a run, or on their pull requests for merge
+	// commits of pull requests.
+	CommitComments bool `yaml:"commit-comments,omitempty"`
+
+	// ConflictReportDir is where a report is written when a branch cannot be
+	// constructed. It defaults to conflict-reports in the base repo path.
+	ConflictReportDir string `yaml:"conflict-report-dir,omitempty"`
+
+	// WorkDir is the directory the source and destination repos are cloned
+	// into instead of $GOPATH/src/<base-package>. The GOPATH with the Go
+	// toolchains and the module cache is <work-dir>/gopath, and branches are
+	// built in module mode, such that no GOPATH layout is needed.
+	WorkDir string `yaml:"work-dir,omitempty"`
+
 	// BasePublishScriptPath determine the base path where we will look for a
 	// publishing scripts in the source repo. It defaults to ./publishing_scripts'.
 	BasePublishScriptPath string `yaml:"base-publish-script-path,omitempty"`
+
+	// CommandTimeout is the default timeout for each command the bot runs. A command
+	// exceeding it is considered hung and is killed. Zero means no timeout.
+	CommandTimeout time.Duration `yaml:"command-timeout,omitempty"`
+
+	// CycleTimeout bounds the construction of all destination repos in a run.
+	// Each repo gets a fair share of the time left, i.e. the time left divided
+	// by the repos left. Repos exceeding it and their dependents are skipped
+	// and constructed first in the next run. Zero means no limit.
+	CycleTimeout time.Duration `yaml:"cycle-timeout,omitempty"`
+
+	// PhaseTimeouts overrides CommandTimeout per phase. Known phases are fetch,
+	// clone, construct, smoke-test, verify-generated, hook, push, toolchain for
+	// Go installations and repair for the commands of init-repo -repair.
+	PhaseTimeouts map[string]time.Duration `yaml:"phase-timeouts,omitempty"`
+
+	// CommandRetries is the number of times a hung command is retried.
+	CommandRetries int `yaml:"command-retries,omitempty"`
+
+	// RepoRetries is the number of times destination repos failing with
+	// transient network or rate limit errors are constructed again after the
+	// other repos, together with their dependents, and the number of times
+	// fetches and pushes failing with them are retried. Zero fails the run at
+	// once.
+	RepoRetries int `yaml:"repo-retries,omitempty"`
+
+	// Pins override the pin of branch rules, keyed by <destination>/<branch> or
+	// by <branch> for all destination repos. An empty revision unpins.
+	Pins map[string]string `yaml:"pins,omitempty"`
+
+	// Network configures proxies and internal mirrors for restricted networks.
+	Network NetworkConfig `yaml:"network,omitempty"`
+
+	// Fetch tunes fetching from the source and destination repos.
+	Fetch FetchConfig `yaml:"fetch,omitempty"`
+
+	// GitIdentity is the default committer identity. Empty fields default to the
+	// GIT_COMMITTER_NAME and GIT_COMMITTER_EMAIL environment variables.
+	GitIdentity GitIdentity `yaml:"git-identity,omitempty"`
+
+	// Signoff adds a Signed-off-by trailer with the git identity of the
+	// destination repo to the published commits, e.g. for orgs enforcing DCO.
+	Signoff bool `yaml:"signoff,omitempty"`
+
+	// EmailDigest configures periodic summary emails of the publishing runs.
+	EmailDigest EmailDigest `yaml:"email-digest,omitempty"`
+
+	// ControlAPIToken is a secret reference to the bearer token of the control
+	// API served at /api/v1. The API is disabled if empty.
+	ControlAPIToken string `yaml:"control-api-token,omitempty"`
+
+	// ChatOps configures commands in GitHub comments.
+	ChatOps ChatOps `yaml:"chatops,omitempty"`
+
+	// Redirect configures comments on pull requests and issues opened in the
+	// destination repos.
+	Redirect Redirect `yaml:"redirect,omitempty"`
+
+	// SecretScan configures the scan for credentials before pushing.
+	SecretScan SecretScan `yaml:"secret-scan,omitempty"`
+
+	// LatencySLO configures alerts when source commits are not published in time.
+	LatencySLO LatencySLO `yaml:"latency-slo,omitempty"`
+
+	// Events configures the structured events sent to webhooks, NATS or Kafka.
+	Events Events `yaml:"events,omitempty"`
+
+	// Lint configures the severities of the semantic checks of the config and
+	// the rules.
+	Lint LintConfig `yaml:"lint,omitempty"`
+
+	// Summary configures the machine-readable summary of each run for CI.
+	Summary Summary `yaml:"summary,omitempty"`
+
+	// Skip skips phases of the runs for debugging.
+	Skip SkipPhases `yaml:"skip,omitempty"`
+
+	// Snapshots configures the workspace snapshots of failed runs.
+	Snapshots Snapshots `yaml:"snapshots,omitempty"`
+}
+
+// GoPath returns the GOPATH of the publisher and of the constructed branches,
+// below the work dir if set.
+func (c *Config) GoPath() string {
+	if c.WorkDir != "" {
+		return filepath.Join(c.WorkDir, "gopath")
+	}
+	return os.Getenv("GOPATH")
+}
+
+// BaseRepoPath returns the directory the source and destination repos are
+// cloned into: the work dir or the base package in GOPATH.
+func (c *Config) BaseRepoPath(basePackage string) string {
+	if c.WorkDir != "" {
+		return c.WorkDir
+	}
+	return filepath.Join(os.Getenv("GOPATH"), "src", filepath.FromSlash(basePackage))
+}
+
+// SourceDir returns the checkout of the source repo: the source path or the
+// clone in the base repo path.
+func (c *Config) SourceDir(baseRepoPath string) string {
+	if c.SourcePath != "" {
+		return c.SourcePath
+	}
+	return filepath.Join(baseRepoPath, c.SourceRepo)
+}
+
+// TokenRef returns the secret reference of the github token, or the empty
+// string if there is none.
+func (c *Config) TokenRef() string {
+	if c.Token != "" {
+		return c.Token
+	}
+	return c.TokenFile
+}
+
+// BasePackageOf returns the base package of the destination repo of the rule.
+func (c *Config) BasePackageOf(r RepositoryRule) string {
+	if bp := CleanBasePackage(r.BasePackage); bp != "" {
+		return bp
+	}
+	return CleanBasePackage(c.BasePackage)
+}
+
+// CleanBasePackage normalizes the base package such that equal packages
+// compare equal, e.g. "k8s.io/" and "./k8s.io" become "k8s.io". The empty
+// package stays empty.
+func CleanBasePackage(p string) string {
+	if p == "" {
+		return ""
+	}
+	return strings.Trim(path.Clean(p), "/")
+}
+
+// DefaultBasePackage returns the clean base package of the config. If it is
+// not specified, it is k8s.io for the kubernetes source repo and
+// <github-host>/<target-org> otherwise.
+func DefaultBasePackage(cfg *Config) string {
+	if cfg.BasePackage != "" {
+		return CleanBasePackage(cfg.BasePackage)
+	}
+	if cfg.SourceRepo == "kubernetes" {
+		return "k8s.io"
+	}
+	host := cfg.GithubHost
+	if host == "" {
+		host = "github.com"
+	}
+	return CleanBasePackage(path.Join(host, cfg.TargetOrg))
+}
+
+// CommitMsgTag returns the commit message tag pointing back to source commits,
+// e.g. Kubernetes-commit for the kubernetes repo.
+func CommitMsgTag(sourceRepo string) string {
+	if sourceRepo == "" {
+		return "-commit"
+	}
+	return strings.ToUpper(sourceRepo[:1]) + sourceRepo[1:] + "-commit"
+}
+
+// Timeout returns the command timeout for the given phase.
+func (c *Config) Timeout(phase string) time.Duration {
+	if t, found := c.PhaseTimeouts[phase]; found {
+		return t
+	}
+	return c.CommandTimeout
 }
@@ -0,0 +1,180 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"k8s.io/publishing-bot/pkg/kubeapi"
+)
+
+const (
+	// CRDRulesPrefix is the rules-file prefix of rules read from custom
+	// resources, followed by the namespace or nothing for the one of the pod.
+	CRDRulesPrefix = "crd://"
+
+	// PublishingRules and PublishingTargets are the custom resources. The
+	// spec of a PublishingRule is a repository rule, the destination
+	// defaulting to the name. The spec of the PublishingTarget, at most one per
+	// namespace, holds the settings of the rules file besides the rules.
+	PublishingRules   = "publishingrules"
+	PublishingTargets = "publishingtargets"
+)
+
+// CRDPath returns the API path of the custom resources in the namespace.
+func CRDPath(ns, resource string) string {
+	return fmt.Sprintf("/apis/publishing.k8s.io/v1alpha1/namespaces/%s/%s", ns, resource)
+}
+
+type crdSpecList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec map[string]interface{} `json:"spec,omitempty"`
+	} `json:"items"`
+}
+
+// CRDNamespace returns the namespace of the custom resources given as rules
+// file, and whether the rules file refers to custom resources.
+func CRDNamespace(rulesFile string) (string, bool) {
+	if !strings.HasPrefix(rulesFile, CRDRulesPrefix) {
+		return "", false
+	}
+	return strings.Trim(strings.TrimPrefix(rulesFile, CRDRulesPrefix), "/"), true
+}
+
+// CRDClient returns the in-cluster client of the API server and the namespace
+// of the custom resources given as rules file.
+func CRDClient(rulesFile string) (*kubeapi.Client, string, error) {
+	ns, _ := CRDNamespace(rulesFile)
+	client, err := kubeapi.InCluster()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read rules from custom resources: %v", err)
+	}
+	if ns == "" {
+		ns = client.Namespace
+	}
+	return client, ns, nil
+}
+
+// LoadCRDRules returns the rules of the PublishingRules and the
+// PublishingTarget, if any, in the namespace. The rules are ordered such that
+// dependencies come first.
+func LoadCRDRules(ctx context.Context, client *kubeapi.Client, ns string) (*RepositoryRules, error) {
+	var targets, rules crdSpecList
+	if err := client.Do(ctx, "GET", CRDPath(ns, PublishingTargets), "", nil, &targets); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", PublishingTargets, err)
+	}
+	if err := client.Do(ctx, "GET", CRDPath(ns, PublishingRules), "", nil, &rules); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", PublishingRules, err)
+	}
+
+	doc := map[string]interface{}{}
+	switch len(targets.Items) {
+	case 0:
+	case 1:
+		for k, v := range targets.Items[0].Spec {
+			doc[k] = v
+		}
+	default:
+		var names []string
+		for _, t := range targets.Items {
+			names = append(names, t.Metadata.Name)
+		}
+		return nil, fmt.Errorf("expected at most one PublishingTarget in namespace %s, found %s", ns, strings.Join(names, ", "))
+	}
+	var specs []interface{}
+	for _, r := range rules.Items {
+		spec := map[string]interface{}{}
+		for k, v := range r.Spec {
+			spec[k] = v
+		}
+		if spec["destination"] == nil {
+			spec["destination"] = r.Metadata.Name
+		}
+		specs = append(specs, spec)
+	}
+	doc["rules"] = specs
+
+	// the specs use the field names of the rules file
+	bs, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := ParseRules(bs, CRDRulesPrefix+ns)
+	if err != nil {
+		return nil, err
+	}
+	if rs.Rules, err = sortByDependencies(rs.Rules); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// sortByDependencies orders the rules such that the dependencies of a
+// destination repo come before it, otherwise by name.
+func sortByDependencies(rules []RepositoryRule) ([]RepositoryRule, error) {
+	byName := map[string]RepositoryRule{}
+	var names []string
+	for _, r := range rules {
+		byName[r.DestinationRepository] = r
+		names = append(names, r.DestinationRepository)
+	}
+	sort.Strings(names)
+
+	var sorted []RepositoryRule
+	visited := map[string]bool{}
+	visiting := map[string]bool{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		r, found := byName[name]
+		if !found || visited[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("dependency cycle through %s", name)
+		}
+		visiting[name] = true
+		var deps []string
+		for _, b := range r.Branches {
+			for _, d := range b.Dependencies {
+				deps = append(deps, d.Repository)
+			}
+		}
+		sort.Strings(deps)
+		for _, d := range deps {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		visiting[name], visited[name] = false, true
+		sorted = append(sorted, r)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/publishing-bot/pkg/kubeapi"
+)
+
+func TestCRDNamespace(t *testing.T) {
+	tests := []struct {
+		rulesFile string
+		ns        string
+		ok        bool
+	}{
+		{"crd://", "", true},
+		{"crd://publisher", "publisher", true},
+		{"crd://publisher/", "publisher", true},
+		{"/etc/publisher-rules/config", "", false},
+		{"https://example.com/rules.yaml", "", false},
+	}
+	for _, tt := range tests {
+		if ns, ok := CRDNamespace(tt.rulesFile); ns != tt.ns || ok != tt.ok {
+			t.Errorf("CRDNamespace(%q) = %q, %v, expected %q, %v", tt.rulesFile, ns, ok, tt.ns, tt.ok)
+		}
+	}
+}
+
+func TestLoadCRDRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rule := func(name, spec string) string {
+		return fmt.Sprintf(`{"metadata": {"name": %q}, "spec": %s}`, name, spec)
+	}
+	branch := func(deps ...string) string {
+		s := `{"name": "master", "source": {"branch": "master", "dir": "staging/src/k8s.io/x"}, "dependencies": [`
+		for i, d := range deps {
+			if i > 0 {
+				s += ","
+			}
+			s += fmt.Sprintf(`{"repository": %q, "branch": "master"}`, d)
+		}
+		return s + "]}"
+	}
+
+	tests := []struct {
+		name    string
+		targets []string
+		rules   []string
+		want    []string
+		godeps  bool
+		wantErr bool
+	}{
+		{
+			name:  "dependencies first",
+			rules: []string{rule("client-go", `{"branches": [`+branch("apimachinery", "api")+`]}`), rule("api", `{"branches": [`+branch("apimachinery")+`]}`), rule("apimachinery", `{"branches": [`+branch()+`]}`)},
+			want:  []string{"apimachinery", "api", "client-go"},
+		},
+		{
+			name:  "explicit destination",
+			rules: []string{rule("client", `{"destination": "client-go", "branches": [`+branch()+`]}`)},
+			want:  []string{"client-go"},
+		},
+		{
+			name:    "target",
+			targets: []string{rule("kubernetes", `{"skip-godeps": true}`)},
+			rules:   []string{rule("apimachinery", `{"branches": [`+branch()+`]}`)},
+			want:    []string{"apimachinery"},
+			godeps:  true,
+		},
+		{
+			name:    "two targets",
+			targets: []string{rule("a", "{}"), rule("b", "{}")},
+			wantErr: true,
+		},
+		{
+			name:    "cycle",
+			rules:   []string{rule("a", `{"branches": [`+branch("b")+`]}`), rule("b", `{"branches": [`+branch("a")+`]}`)},
+			wantErr: true,
+		},
+		{
+			name:    "invalid target",
+			targets: []string{rule("kubernetes", `{"skip-source-commits": ["xyz"]}`)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+					t.Errorf("unexpected Authorization header %q", got)
+				}
+				items := map[string][]string{
+					CRDPath("publisher", PublishingRules):   tt.rules,
+					CRDPath("publisher", PublishingTargets): tt.targets,
+				}
+				list, found := items[r.URL.Path]
+				if !found {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprint(w, `{"items": [`)
+				for i, item := range list {
+					if i > 0 {
+						fmt.Fprint(w, ",")
+					}
+					fmt.Fprint(w, item)
+				}
+				fmt.Fprint(w, "]}")
+			}))
+			defer server.Close()
+
+			client := &kubeapi.Client{Server: server.URL, TokenFile: tokenFile, HTTP: http.DefaultClient}
+			rules, err := LoadCRDRules(context.Background(), client, "publisher")
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("expected error")
+			}
+			var got []string
+			for _, r := range rules.Rules {
+				got = append(got, r.DestinationRepository)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got rules %v, expected %v", got, tt.want)
+			}
+			if rules.SkipGodeps != tt.godeps {
+				t.Errorf("got skip-godeps %v, expected %v", rules.SkipGodeps, tt.godeps)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PushTokenRef returns the secret reference of the token to push to the given
+// destination repo in the target org with. Entries of PushTokens for
+// <org>/<repo> take precedence over those for <org>. Without any PushTokens the
+// global token is used. With PushTokens, a repo without matching entry cannot
+// be pushed to.
+func (c *Config) PushTokenRef(repo string) (string, error) {
+	if len(c.PushTokens) == 0 {
+		if c.TokenRef() == "" {
+			return "", fmt.Errorf("token cannot be empty in non-dry-run mode")
+		}
+		return c.TokenRef(), nil
+	}
+	if ref, found := c.PushTokens[c.TargetOrg+"/"+repo]; found {
+		return ref, nil
+	}
+	if ref, found := c.PushTokens[c.TargetOrg]; found {
+		return ref, nil
+	}
+	return "", fmt.Errorf("no push token configured for %s/%s", c.TargetOrg, repo)
+}
+
+// ValidateCredentials checks that the keys of PushTokens are orgs or repos in
+// the target org.
+func (c *Config) ValidateCredentials() error {
+	for key, ref := range c.PushTokens {
+		if ref == "" {
+			return fmt.Errorf("empty push token for %q", key)
+		}
+		ss := strings.Split(key, "/")
+		if len(ss) > 2 || ss[0] == "" || (len(ss) == 2 && ss[1] == "") {
+			return fmt.Errorf("invalid push-tokens key %q, must be <org> or <org>/<repo>", key)
+		}
+		if ss[0] != c.TargetOrg {
+			return fmt.Errorf("push-tokens key %q is outside of the target org %q", key, c.TargetOrg)
+		}
+	}
+	return nil
+}
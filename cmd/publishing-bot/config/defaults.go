@@ -0,0 +1,210 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// ruleTemplateData is what strings in default branch rules can refer to, e.g.
+// "staging/src/k8s.io/{{.Destination}}".
+type ruleTemplateData struct {
+	Destination string
+}
+
+// applyDefaults merges the default branch rules into the branch rules of every
+// repository rule. Rules without branches get all default branches. Otherwise,
+// fields a branch leaves empty are taken from the default branch of the same
+// name. Dependencies and other lists are only inherited if they are not given
+// at all, i.e. "dependencies: []" means none.
+func (rules *RepositoryRules) applyDefaults() error {
+	if len(rules.DefaultBranchRules) == 0 {
+		return nil
+	}
+	defaults := map[string]BranchRule{}
+	for _, d := range rules.DefaultBranchRules {
+		if d.Name == "" {
+			return fmt.Errorf("default branch rule without name")
+		}
+		if _, found := defaults[d.Name]; found {
+			return fmt.Errorf("duplicate default branch rule %q", d.Name)
+		}
+		defaults[d.Name] = d
+	}
+
+	for i := range rules.Rules {
+		r := &rules.Rules[i]
+		if len(r.Branches) == 0 {
+			for _, d := range rules.DefaultBranchRules {
+				b, err := d.expand(r.DestinationRepository)
+				if err != nil {
+					return err
+				}
+				r.Branches = append(r.Branches, b)
+			}
+			continue
+		}
+		for j := range r.Branches {
+			d, found := defaults[r.Branches[j].Name]
+			if !found {
+				continue
+			}
+			d, err := d.expand(r.DestinationRepository)
+			if err != nil {
+				return err
+			}
+			r.Branches[j].inherit(d)
+		}
+	}
+	return nil
+}
+
+// inherit sets the empty fields of the branch rule to those of the given one.
+func (b *BranchRule) inherit(d BranchRule) {
+	if b.GoVersion == "" {
+		b.GoVersion = d.GoVersion
+	}
+	if b.GoVersionFile == "" {
+		b.GoVersionFile = d.GoVersionFile
+	}
+	if b.DependencyTool == "" {
+		b.DependencyTool = d.DependencyTool
+	}
+	if b.Dependencies == nil {
+		b.Dependencies = d.Dependencies
+	}
+	if b.Source.Repository == "" {
+		b.Source.Repository = d.Source.Repository
+	}
+	if b.Source.Branch == "" {
+		b.Source.Branch = d.Source.Branch
+	}
+	if b.Source.Dir == "" {
+		b.Source.Dir = d.Source.Dir
+	}
+	if b.RequiredPackages == nil {
+		b.RequiredPackages = d.RequiredPackages
+	}
+	if b.Aliases == nil {
+		b.Aliases = d.Aliases
+	}
+	if b.Pin == "" {
+		b.Pin = d.Pin
+	}
+	if b.DeprecatedAfter == "" {
+		b.DeprecatedAfter = d.DeprecatedAfter
+	}
+	if b.StopPublishingAfter == "" {
+		b.StopPublishingAfter = d.StopPublishingAfter
+	}
+	b.FreezeNotice = b.FreezeNotice || d.FreezeNotice
+}
+
+// expand returns a copy of the default branch rule with the templates in the
+// source dir, the dependencies and the required packages executed for the given
+// destination repository.
+func (b BranchRule) expand(dst string) (BranchRule, error) {
+	data := ruleTemplateData{Destination: dst}
+	var err error
+	if b.Source.Dir, err = expandTemplate(b.Name, b.Source.Dir, data); err != nil {
+		return b, err
+	}
+	deps := make([]Dependency, len(b.Dependencies))
+	for i, d := range b.Dependencies {
+		if deps[i].Repository, err = expandTemplate(b.Name, d.Repository, data); err != nil {
+			return b, err
+		}
+		if deps[i].Branch, err = expandTemplate(b.Name, d.Branch, data); err != nil {
+			return b, err
+		}
+		deps[i].Tag, deps[i].Commit = d.Tag, d.Commit
+	}
+	if b.Dependencies != nil {
+		b.Dependencies = deps
+	}
+	pkgs := make([]string, len(b.RequiredPackages))
+	for i, p := range b.RequiredPackages {
+		if pkgs[i], err = expandTemplate(b.Name, p, data); err != nil {
+			return b, err
+		}
+	}
+	if b.RequiredPackages != nil {
+		b.RequiredPackages = pkgs
+	}
+	return b, nil
+}
+
+// sourceDirTemplateData is what source directory templates can refer to.
+type sourceDirTemplateData struct {
+	Destination string
+	BasePackage string
+}
+
+// ExpandSourceDirs sets the source directory of branches which leave it empty
+// from the source directory template of their repository rule, or the global
+// one. Without template, the repo root is published. basePackage is the global
+// base package, used for rules without their own.
+func (rules *RepositoryRules) ExpandSourceDirs(basePackage string) error {
+	for i := range rules.Rules {
+		r := &rules.Rules[i]
+		tmpl := r.SourceDirTemplate
+		if tmpl == "" {
+			tmpl = rules.SourceDirTemplate
+		}
+		if tmpl == "" {
+			continue
+		}
+		data := sourceDirTemplateData{Destination: r.DestinationRepository, BasePackage: CleanBasePackage(basePackage)}
+		if bp := CleanBasePackage(r.BasePackage); bp != "" {
+			data.BasePackage = bp
+		}
+		for j := range r.Branches {
+			b := &r.Branches[j]
+			if b.Source.Dir != "" {
+				continue
+			}
+			dir, err := executeTemplate("source-dir-template", tmpl, data)
+			if err != nil {
+				return fmt.Errorf("%s: %v", r.DestinationRepository, err)
+			}
+			b.Source.Dir = dir
+		}
+	}
+	return nil
+}
+
+func expandTemplate(branch, s string, data ruleTemplateData) (string, error) {
+	out, err := executeTemplate(branch, s, data)
+	if err != nil {
+		return "", fmt.Errorf("invalid template in default branch rule %q: %v", branch, err)
+	}
+	return out, nil
+}
+
+func executeTemplate(name, s string, data interface{}) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
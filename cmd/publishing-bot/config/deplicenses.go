@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnknownLicense is the license of dependencies whose license is not detected.
+const UnknownLicense = "unknown"
+
+// DependencyLicenses is the license gate for the dependencies of a destination
+// repo, found in vendor/ and go.mod. Published repos become the dependencies of
+// others, so their licenses matter.
+type DependencyLicenses struct {
+	// Allowed are the SPDX identifiers of the allowed licenses, e.g. Apache-2.0,
+	// or unknown for undetected licenses. Setting it enables the scan.
+	Allowed []string `yaml:"allowed,omitempty"`
+	// Exceptions are import path prefixes of dependencies allowed regardless of
+	// their license, e.g. after a legal review.
+	Exceptions []string `yaml:"exceptions,omitempty"`
+	// WarnOnly reports disallowed licenses without failing publishing.
+	WarnOnly bool `yaml:"warn-only,omitempty"`
+	// Command is a bash script run in the destination repo instead of the
+	// built-in detection. It prints one "<dependency> <SPDX identifier>" line
+	// per dependency.
+	Command string `yaml:"command,omitempty"`
+}
+
+// Enabled returns true if dependency licenses are scanned.
+func (d DependencyLicenses) Enabled() bool {
+	return len(d.Allowed) > 0
+}
+
+// Validate checks that the settings are only used with allowed licenses.
+func (d DependencyLicenses) Validate() error {
+	if !d.Enabled() && (len(d.Exceptions) > 0 || d.WarnOnly || d.Command != "") {
+		return fmt.Errorf("allowed licenses are required")
+	}
+	for _, l := range d.Allowed {
+		if l == "" || strings.ContainsAny(l, " \t\n") {
+			return fmt.Errorf("invalid license %q", l)
+		}
+	}
+	return nil
+}
+
+// Allows returns true if the dependency with the given license is allowed.
+func (d DependencyLicenses) Allows(dependency, license string) bool {
+	for _, l := range d.Allowed {
+		if l == license {
+			return true
+		}
+	}
+	for _, e := range d.Exceptions {
+		if dependency == e || strings.HasPrefix(dependency, strings.TrimSuffix(e, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// EmailDigest configures a periodic email summarizing the publishing runs.
+type EmailDigest struct {
+	// SMTPServer is the host:port of the mail server. The digest is disabled if empty.
+	SMTPServer string `yaml:"smtp-server,omitempty"`
+	// Username and PasswordFile are used for SMTP PLAIN authentication if set.
+	Username     string   `yaml:"username,omitempty"`
+	PasswordFile string   `yaml:"password-file,omitempty"`
+	From         string   `yaml:"from,omitempty"`
+	To           []string `yaml:"to,omitempty"`
+	// Interval is the time between two digests, e.g. 24h for daily or 168h for
+	// weekly digests. Defaults to 24h.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// Enabled returns true if a mail server is configured.
+func (e EmailDigest) Enabled() bool {
+	return e.SMTPServer != ""
+}
+
+// DigestInterval returns the interval with defaulting.
+func (e EmailDigest) DigestInterval() time.Duration {
+	if e.Interval <= 0 {
+		return 24 * time.Hour
+	}
+	return e.Interval
+}
+
+// Validate checks the digest settings if enabled.
+func (e EmailDigest) Validate() error {
+	if !e.Enabled() {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(e.SMTPServer); err != nil {
+		return fmt.Errorf("invalid smtp-server %q: %v", e.SMTPServer, err)
+	}
+	if e.From == "" {
+		return fmt.Errorf("from cannot be empty")
+	}
+	if len(e.To) == 0 {
+		return fmt.Errorf("to cannot be empty")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The types of the events emitted while publishing.
+const (
+	EventRunStarted    = "run-started"
+	EventRunFinished   = "run-finished"
+	EventRepoPublished = "repo-published"
+	EventRepoFailed    = "repo-failed"
+	EventTagCreated    = "tag-created"
+)
+
+var eventTypes = []string{EventRunStarted, EventRunFinished, EventRepoPublished, EventRepoFailed, EventTagCreated}
+
+// Events configures the structured events sent while publishing, such that
+// downstream automation can react to publishes.
+type Events struct {
+	// Sinks receive every event as JSON: an http(s):// webhook, a NATS subject
+	// as nats://[user:password@]host:port/<subject>, or a Kafka topic through
+	// the Kafka REST proxy as kafka+http(s)://host:port/<topic>.
+	Sinks []string `yaml:"sinks,omitempty"`
+	// Types restricts the events to these types, all by default.
+	Types []string `yaml:"types,omitempty"`
+}
+
+// Enabled returns true if there are sinks.
+func (e Events) Enabled() bool {
+	return len(e.Sinks) > 0
+}
+
+// Wants returns true if events of the type are sent.
+func (e Events) Wants(typ string) bool {
+	if !e.Enabled() {
+		return false
+	}
+	if len(e.Types) == 0 {
+		return true
+	}
+	for _, t := range e.Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the sink URLs and the event types.
+func (e Events) Validate() error {
+	for _, s := range e.Sinks {
+		if err := validateEventSink(s); err != nil {
+			return fmt.Errorf("invalid sink %q: %v", s, err)
+		}
+	}
+	for _, t := range e.Types {
+		found := false
+		for _, known := range eventTypes {
+			found = found || t == known
+		}
+		if !found {
+			return fmt.Errorf("unknown event type %q, expected one of %s", t, strings.Join(eventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+func validateEventSink(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("host missing")
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return nil
+	case "nats", "kafka+http", "kafka+https":
+		if strings.Trim(u.Path, "/") == "" {
+			return fmt.Errorf("%s sink without subject or topic", u.Scheme)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+}
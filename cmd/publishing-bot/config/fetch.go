@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "fmt"
+
+// FetchConfig tunes fetching from the source and destination repos, e.g. to
+// reduce fetch time and bandwidth against a very large source repo.
+type FetchConfig struct {
+	// ProtocolVersion is the git wire protocol version, e.g. 2. Zero keeps the
+	// default of the git version in use. It is ignored before git 2.18.
+	ProtocolVersion int `yaml:"protocol-version,omitempty"`
+	// SingleBranch fetches only the branches which are published, instead of all
+	// branches of the source repo and of the destination repos.
+	SingleBranch bool `yaml:"single-branch,omitempty"`
+	// NegotiationTips tells the server only about the last fetched commits of
+	// the published source branches, instead of all local refs. It is ignored
+	// before git 2.19.
+	NegotiationTips bool `yaml:"negotiation-tips,omitempty"`
+	// Remotes are additional remotes of the source clone. The source branches
+	// are fetched from the first branches remote which succeeds and does not
+	// lag behind origin, falling back to origin, and the tags from the tags
+	// remotes.
+	Remotes []SourceRemote `yaml:"remotes,omitempty"`
+}
+
+// What is fetched from source remotes.
+const (
+	RemoteFetchBranches = "branches"
+	RemoteFetchTags     = "tags"
+)
+
+// SourceRemote is an additional remote of the source clone.
+type SourceRemote struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Fetch is branches (the default) to fetch the source branches from the
+	// remote in preference to origin, e.g. from a fast internal mirror, or tags
+	// to fetch all tags from it, e.g. from github.com if the mirror lacks them.
+	Fetch string `yaml:"fetch,omitempty"`
+}
+
+// BranchRemotes returns the remotes the source branches are fetched from, in
+// order of preference.
+func (f FetchConfig) BranchRemotes() []SourceRemote {
+	return f.remotes(RemoteFetchBranches)
+}
+
+// TagRemotes returns the remotes the tags are fetched from.
+func (f FetchConfig) TagRemotes() []SourceRemote {
+	return f.remotes(RemoteFetchTags)
+}
+
+func (f FetchConfig) remotes(fetch string) []SourceRemote {
+	var remotes []SourceRemote
+	for _, r := range f.Remotes {
+		if r.Fetch == fetch || (r.Fetch == "" && fetch == RemoteFetchBranches) {
+			remotes = append(remotes, r)
+		}
+	}
+	return remotes
+}
+
+// Validate checks the protocol version.
+func (f FetchConfig) Validate() error {
+	switch f.ProtocolVersion {
+	case 0, 1, 2:
+	default:
+		return fmt.Errorf("unsupported protocol-version %d", f.ProtocolVersion)
+	}
+	names := map[string]bool{}
+	for _, r := range f.Remotes {
+		switch {
+		case r.Name == "":
+			return fmt.Errorf("source remote without name")
+		case r.Name == "origin" || r.Name == "upstream":
+			return fmt.Errorf("source remote name %q is reserved", r.Name)
+		case names[r.Name]:
+			return fmt.Errorf("duplicate source remote %q", r.Name)
+		}
+		names[r.Name] = true
+		if err := ValidateGitURL(r.URL); err != nil {
+			return fmt.Errorf("source remote %s: %v", r.Name, err)
+		}
+		switch r.Fetch {
+		case "", RemoteFetchBranches, RemoteFetchTags:
+		default:
+			return fmt.Errorf("source remote %s: unknown fetch %q, must be branches or tags", r.Name, r.Fetch)
+		}
+	}
+	return nil
+}
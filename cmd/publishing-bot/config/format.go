@@ -0,0 +1,174 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Formats of config and rules files. YAML is the canonical representation the
+// other formats are converted into before parsing.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// FormatOf returns the format of the config or rules file or URL by its
+// extension, YAML by default.
+func FormatOf(file string) string {
+	if u, err := url.ParseRequestURI(file); err == nil && len(u.Host) > 0 {
+		file = u.Path
+	}
+	switch strings.ToLower(path.Ext(file)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatYAML
+	}
+}
+
+// ValidateFormat checks for a known format.
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatYAML, FormatJSON, FormatTOML:
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatYAML, FormatJSON, FormatTOML)
+	}
+}
+
+// ToYAML converts the content in the given format into YAML.
+func ToYAML(content []byte, format string) ([]byte, error) {
+	var doc interface{}
+	switch format {
+	case FormatYAML:
+		return content, nil
+	case FormatJSON:
+		if err := json.Unmarshal(content, &doc); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		m := map[string]interface{}{}
+		if _, err := toml.Decode(string(content), &m); err != nil {
+			return nil, err
+		}
+		doc = m
+	default:
+		return nil, ValidateFormat(format)
+	}
+	return yaml.Marshal(doc)
+}
+
+// ReadFile reads the config or rules file and converts it into YAML.
+func ReadFile(file string) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	format := FormatOf(file)
+	if content, err = ToYAML(content, format); err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %v", format, file, err)
+	}
+	return content, nil
+}
+
+// Convert converts the content from one format into another.
+func Convert(content []byte, from, to string) ([]byte, error) {
+	if err := ValidateFormat(to); err != nil {
+		return nil, err
+	}
+	bs, err := ToYAML(content, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", from, err)
+	}
+	if to == FormatYAML {
+		return bs, nil
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(bs, &doc); err != nil {
+		return nil, err
+	}
+	doc, err = stringKeys(doc)
+	if err != nil {
+		return nil, err
+	}
+	if to == FormatJSON {
+		bs, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(bs, '\n'), nil
+	}
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("toml needs a mapping at the top, got %T", doc)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// stringKeys converts the generic YAML mappings into maps with string keys,
+// and lists of mappings into lists of maps, as JSON and TOML expect them.
+func stringKeys(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported non-string key %v", k)
+			}
+			var err error
+			if m[s], err = stringKeys(val); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		maps := make([]map[string]interface{}, 0, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = stringKeys(item); err != nil {
+				return nil, err
+			}
+			if m, ok := list[i].(map[string]interface{}); ok {
+				maps = append(maps, m)
+			}
+		}
+		if len(v) > 0 && len(maps) == len(v) {
+			return maps, nil
+		}
+		return list, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFormatOf(t *testing.T) {
+	for file, want := range map[string]string{
+		"rules.yaml":                             FormatYAML,
+		"/etc/publisher-rules/config":            FormatYAML,
+		"rules.JSON":                             FormatJSON,
+		"config.toml":                            FormatTOML,
+		"https://example.com/rules.toml?ref=abc": FormatTOML,
+	} {
+		if got := FormatOf(file); got != want {
+			t.Errorf("FormatOf(%q) = %s, expected %s", file, got, want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rules := []byte(`skip-godeps: true
+skip-source-branches: [release-1.8]
+rules:
+- destination: apimachinery
+  branches:
+  - name: master
+    go: "1.11"
+    source:
+      branch: master
+      dir: staging/src/k8s.io/apimachinery
+`)
+	want, err := ParseRules(rules, "rules.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, format := rules, FormatYAML
+	for _, to := range []string{FormatJSON, FormatTOML, FormatYAML} {
+		if content, err = Convert(content, format, to); err != nil {
+			t.Fatalf("failed to convert %s to %s: %v", format, to, err)
+		}
+		format = to
+		bs, err := ToYAML(content, format)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v\n%s", format, err, content)
+		}
+		got, err := ParseRules(bs, "rules."+format)
+		if err != nil {
+			t.Fatalf("invalid rules in %s: %v\n%s", format, err, content)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %+v, got %+v from %s:\n%s", want, got, format, content)
+		}
+	}
+
+	if _, err := Convert([]byte("[1, 2]"), FormatYAML, FormatTOML); err == nil {
+		t.Errorf("expected an error converting a list to toml")
+	}
+	if _, err := Convert(rules, FormatYAML, "xml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(file, []byte("source-repo = \"kubernetes\"\ninterval = \"1h\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(bs), "interval: 1h\nsource-repo: kubernetes\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("source-repo = "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(file); err == nil {
+		t.Errorf("expected an error for invalid toml")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GitIdentity is the name and email the bot creates commits and tags with.
+type GitIdentity struct {
+	Name  string `yaml:"name,omitempty"`
+	Email string `yaml:"email,omitempty"`
+}
+
+// EnvGitIdentity returns the identity from the GIT_COMMITTER_NAME and
+// GIT_COMMITTER_EMAIL environment variables.
+func EnvGitIdentity() GitIdentity {
+	return GitIdentity{
+		Name:  os.Getenv("GIT_COMMITTER_NAME"),
+		Email: os.Getenv("GIT_COMMITTER_EMAIL"),
+	}
+}
+
+// Or returns the identity with empty fields taken from def.
+func (g GitIdentity) Or(def GitIdentity) GitIdentity {
+	if g.Name == "" {
+		g.Name = def.Name
+	}
+	if g.Email == "" {
+		g.Email = def.Email
+	}
+	return g
+}
+
+// String returns the identity as "name <email>", e.g. for trailers.
+func (g GitIdentity) String() string {
+	return fmt.Sprintf("%s <%s>", g.Name, g.Email)
+}
+
+// Validate checks that name and email are set and well-formed.
+func (g GitIdentity) Validate() error {
+	if strings.TrimSpace(g.Name) == "" {
+		return fmt.Errorf("git identity name cannot be empty")
+	}
+	if g.Email == "" {
+		return fmt.Errorf("git identity email cannot be empty")
+	}
+	return g.validateSyntax()
+}
+
+// validateSyntax checks the fields which are set.
+func (g GitIdentity) validateSyntax() error {
+	if strings.ContainsAny(g.Name, "<>\n") {
+		return fmt.Errorf("invalid git identity name %q", g.Name)
+	}
+	if g.Email != "" && (!strings.Contains(g.Email, "@") || strings.ContainsAny(g.Email, "<> \n")) {
+		return fmt.Errorf("invalid git identity email %q", g.Email)
+	}
+	return nil
+}
+
+// DefaultGitIdentity returns the configured identity, falling back to the
+// environment for empty fields.
+func (c *Config) DefaultGitIdentity() GitIdentity {
+	return c.GitIdentity.Or(EnvGitIdentity())
+}
+
+// ResolveGitIdentity fills in the empty fields of the configured identity from
+// the environment and records the environment variables used as its origin.
+func (c *Config) ResolveGitIdentity() {
+	env := EnvGitIdentity()
+	var vars []string
+	if c.GitIdentity.Name == "" && env.Name != "" {
+		vars = append(vars, "GIT_COMMITTER_NAME")
+	}
+	if c.GitIdentity.Email == "" && env.Email != "" {
+		vars = append(vars, "GIT_COMMITTER_EMAIL")
+	}
+	if len(vars) == 0 {
+		return
+	}
+	origin := Origin{Kind: OriginEnv, Name: strings.Join(vars, ", ")}
+	if o := c.OriginOf("git-identity"); o.Kind != OriginDefault {
+		origin.Name += " and " + o.String()
+	}
+	c.GitIdentity = c.DefaultGitIdentity()
+	c.SetOrigin("git-identity", origin)
+}
+
+// GitIdentityFor returns the identity for the given destination repo, falling
+// back to the default identity for empty fields.
+func (c *Config) GitIdentityFor(r RepositoryRule) GitIdentity {
+	return r.GitIdentity.Or(c.DefaultGitIdentity())
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Failure classes deciding the labels and assignees of failure issues.
+const (
+	// FailureClassInfra are failures of the environment, e.g. the network,
+	// GitHub or timeouts, for the operators of the bot.
+	FailureClassInfra = "infra"
+	// FailureClassRules are invalid rules, for the maintainers of the rules.
+	FailureClassRules = "rules"
+	// FailureClassCode are source commits which cannot be published, e.g.
+	// conflicts or failing builds, for the authors of the source changes.
+	FailureClassCode = "code"
+)
+
+// Severities of failure issues.
+const (
+	// SeverityCritical stops publishing altogether.
+	SeverityCritical = "critical"
+	// SeverityMajor stops publishing some destination branches.
+	SeverityMajor = "major"
+	// SeverityMinor does not stop publishing, e.g. a failed report.
+	SeverityMinor = "minor"
+)
+
+// defaultSeverities are the severities of the failure classes without
+// configured severity. Broken rules and environments stop all repos, broken
+// source commits only the branches they are published to.
+var defaultSeverities = map[string]string{
+	FailureClassInfra: SeverityCritical,
+	FailureClassRules: SeverityCritical,
+	FailureClassCode:  SeverityMajor,
+}
+
+// SeverityLabelPrefix prefixes the severity in the label of failure issues.
+const SeverityLabelPrefix = "severity/"
+
+// FailureIssues configures issues filed for failed runs, one per failure
+// signature, i.e. the phase, the destination branch and the category of the
+// failure. Later failures with the same signature are appended to the open
+// issue, and the open issues are closed after a successful run.
+type FailureIssues struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Repository is the <org>/<repo> the issues are filed in. Defaults to
+	// <target-org>/<source-repo>, where the github-issue is.
+	Repository string `yaml:"repository,omitempty"`
+	// Labels and Assignees are set on all issues.
+	Labels    []string `yaml:"labels,omitempty"`
+	Assignees []string `yaml:"assignees,omitempty"`
+	// Classes sets the severity and adds labels and assignees per failure
+	// class: infra, rules or code.
+	Classes map[string]FailureClass `yaml:"classes,omitempty"`
+}
+
+// FailureClass are the severity, labels and assignees of the issues of a
+// failure class.
+type FailureClass struct {
+	// Severity is critical, major or minor. It defaults to critical for infra
+	// and rules, and to major for code.
+	Severity  string   `yaml:"severity,omitempty"`
+	Labels    []string `yaml:"labels,omitempty"`
+	Assignees []string `yaml:"assignees,omitempty"`
+}
+
+// SeverityOf returns the severity of issues of the failure class.
+func (f FailureIssues) SeverityOf(class string) string {
+	if s := f.Classes[class].Severity; s != "" {
+		return s
+	}
+	if s, found := defaultSeverities[class]; found {
+		return s
+	}
+	return SeverityCritical
+}
+
+// LabelsOf returns the labels of issues of the failure class, including the
+// severity label.
+func (f FailureIssues) LabelsOf(class string) []string {
+	labels := append(append([]string(nil), f.Labels...), f.Classes[class].Labels...)
+	return append(labels, SeverityLabelPrefix+f.SeverityOf(class))
+}
+
+// AssigneesOf returns the assignees of issues of the failure class.
+func (f FailureIssues) AssigneesOf(class string) []string {
+	return append(append([]string(nil), f.Assignees...), f.Classes[class].Assignees...)
+}
+
+// Validate checks the repository, the failure classes and their severities.
+func (f FailureIssues) Validate() error {
+	if f.Repository != "" {
+		if ss := strings.Split(f.Repository, "/"); len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+			return fmt.Errorf("invalid repository %q: expected <org>/<repo>", f.Repository)
+		}
+	}
+	for class, c := range f.Classes {
+		switch class {
+		case FailureClassInfra, FailureClassRules, FailureClassCode:
+		default:
+			return fmt.Errorf("invalid failure class %q, must be %s, %s or %s", class, FailureClassInfra, FailureClassRules, FailureClassCode)
+		}
+		switch c.Severity {
+		case "", SeverityCritical, SeverityMajor, SeverityMinor:
+		default:
+			return fmt.Errorf("invalid severity %q of failure class %s, must be %s, %s or %s", c.Severity, class, SeverityCritical, SeverityMajor, SeverityMinor)
+		}
+	}
+	return nil
+}
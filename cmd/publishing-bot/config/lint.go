@@ -0,0 +1,224 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lint checks for configurations which are valid, but likely wrong.
+const (
+	// LintTargetIsSource reports a target org equal to the source org. This is
+	// intended if the destination repos live next to the source repo.
+	LintTargetIsSource = "target-is-source"
+	// LintMissingMainline reports destination repos publishing neither master
+	// nor main nor the source mainline.
+	LintMissingMainline = "missing-mainline"
+	// LintGoVersion reports branches with a go version older than the one of a
+	// branch they depend on.
+	LintGoVersion = "go-version"
+	// LintDuplicateDestination reports destination branches published from
+	// different source directories by different rules.
+	LintDuplicateDestination = "duplicate-destination"
+	// LintGodepWithoutGOPATH reports branches using the godep dependency tool,
+	// which needs GOPATH, with a work-dir.
+	LintGodepWithoutGOPATH = "godep-without-gopath"
+)
+
+// Lint severities.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+	LintIgnore  = "ignore"
+)
+
+var defaultLintSeverities = map[string]string{
+	LintTargetIsSource:       LintWarning,
+	LintMissingMainline:      LintWarning,
+	LintGoVersion:            LintWarning,
+	LintDuplicateDestination: LintError,
+	LintGodepWithoutGOPATH:   LintError,
+}
+
+// LintConfig configures the semantic checks of the config and the rules.
+type LintConfig struct {
+	// Severities overrides the severity of checks, keyed by check name, to
+	// error, warning or ignore. Errors fail the publishing run.
+	Severities map[string]string `yaml:"severities,omitempty"`
+}
+
+// Validate checks the check names and severities.
+func (l LintConfig) Validate() error {
+	for c, s := range l.Severities {
+		if _, found := defaultLintSeverities[c]; !found {
+			return fmt.Errorf("unknown check %q", c)
+		}
+		switch s {
+		case LintError, LintWarning, LintIgnore:
+		default:
+			return fmt.Errorf("invalid severity %q of check %s", s, c)
+		}
+	}
+	return nil
+}
+
+// Severity returns the severity of the given check.
+func (l LintConfig) Severity(check string) string {
+	if s, found := l.Severities[check]; found {
+		return s
+	}
+	return defaultLintSeverities[check]
+}
+
+// LintFinding is a problem found by Lint.
+type LintFinding struct {
+	Check    string
+	Severity string
+	Message  string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s: %s [%s]", f.Severity, f.Message, f.Check)
+}
+
+// LintErrors returns the findings with error severity.
+func LintErrors(findings []LintFinding) []LintFinding {
+	var errs []LintFinding
+	for _, f := range findings {
+		if f.Severity == LintError {
+			errs = append(errs, f)
+		}
+	}
+	return errs
+}
+
+// Lint runs the semantic checks on the config and the expanded rules. Ignored
+// findings are not returned.
+func Lint(cfg *Config, rules *RepositoryRules) []LintFinding {
+	var findings []LintFinding
+	report := func(check, format string, args ...interface{}) {
+		s := cfg.Lint.Severity(check)
+		if s == LintIgnore {
+			return
+		}
+		findings = append(findings, LintFinding{Check: check, Severity: s, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.TargetOrg != "" && strings.EqualFold(cfg.TargetOrg, cfg.SourceOrg) {
+		report(LintTargetIsSource, "target-org %s is the source-org", cfg.TargetOrg)
+	}
+
+	goVersions := map[string]string{}
+	for _, r := range rules.Rules {
+		for _, b := range r.Branches {
+			goVersions[r.DestinationRepository+"/"+b.Name] = b.GoVersion
+		}
+	}
+
+	sourceDirs := map[string][]string{}
+	for _, r := range rules.Rules {
+		if r.Skip {
+			continue
+		}
+		mainline := false
+		for _, b := range r.Branches {
+			if b.Name == "master" || b.Name == "main" || b.Source.Branch == rules.SourceMainline() {
+				mainline = true
+			}
+			if cfg.WorkDir != "" && rules.DependencyTool(b) == DependencyToolGodep {
+				report(LintGodepWithoutGOPATH, "%s: branch %s uses godep, which needs GOPATH, with work-dir %s. Use dependency-tool go-mod or none.",
+					r.DestinationRepository, b.Name, cfg.WorkDir)
+			}
+			for _, d := range b.Dependencies {
+				if d.Branch == "" || b.GoVersion == "" {
+					continue
+				}
+				v := goVersions[d.Repository+"/"+d.Branch]
+				if v != "" && compareGoVersions(b.GoVersion, v) < 0 {
+					report(LintGoVersion, "%s: branch %s uses go %s, but its dependency %s branch %s requires go %s",
+						r.DestinationRepository, b.Name, b.GoVersion, d.Repository, d.Branch, v)
+				}
+			}
+			for _, name := range append([]string{b.Name}, b.Aliases...) {
+				key := r.DestinationRepository + "/" + name
+				sourceDirs[key] = append(sourceDirs[key], b.Source.Dir)
+			}
+		}
+		if len(r.Branches) > 0 && !mainline {
+			report(LintMissingMainline, "%s: neither master, main nor the source mainline %s is published", r.DestinationRepository, rules.SourceMainline())
+		}
+	}
+
+	var keys []string
+	for k := range sourceDirs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var dirs []string
+		seen := map[string]bool{}
+		for _, d := range sourceDirs[k] {
+			if !seen[d] {
+				seen[d] = true
+				dirs = append(dirs, d)
+			}
+		}
+		if len(dirs) > 1 {
+			report(LintDuplicateDestination, "%s is published from the source directories %s", k, strings.Join(dirs, ", "))
+		}
+	}
+	return findings
+}
+
+// compareGoVersions compares go versions like 1.10 or 1.10.2, returning -1,
+// 0 or 1. Pre-release suffixes like rc1 are ignored.
+func compareGoVersions(a, b string) int {
+	as, bs := goVersionParts(a), goVersionParts(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func goVersionParts(v string) []int {
+	var parts []int
+	for _, s := range strings.Split(strings.TrimPrefix(v, "go"), ".") {
+		n := 0
+		for _, c := range s {
+			if c < '0' || c > '9' {
+				break
+			}
+			n = n*10 + int(c-'0')
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestLint(t *testing.T) {
+	var rules RepositoryRules
+	err := yaml.Unmarshal([]byte(`
+rules:
+- destination: apimachinery
+  branches:
+  - name: master
+    go: 1.12.5
+    source:
+      branch: master
+      dir: staging/src/k8s.io/apimachinery
+- destination: client-go
+  branches:
+  - name: master
+    go: 1.11.3
+    source:
+      branch: master
+      dir: staging/src/k8s.io/client-go
+    dependencies:
+    - repository: apimachinery
+      branch: master
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: master
+      dir: staging/src/k8s.io/client-go-v2
+- destination: api
+  branches:
+  - name: release-1.14
+    source:
+      branch: release-1.14
+      dir: staging/src/k8s.io/api
+`), &rules)
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantChecks []string
+		wantErrors int
+	}{
+		{
+			"defaults",
+			Config{SourceOrg: "kubernetes", TargetOrg: "Kubernetes"},
+			[]string{LintTargetIsSource, LintGoVersion, LintMissingMainline, LintDuplicateDestination},
+			1,
+		},
+		{
+			"overridden",
+			Config{SourceOrg: "kubernetes", TargetOrg: "k8s-publishing-bot", Lint: LintConfig{Severities: map[string]string{
+				LintGoVersion:            LintError,
+				LintMissingMainline:      LintIgnore,
+				LintDuplicateDestination: LintWarning,
+			}}},
+			[]string{LintGoVersion, LintDuplicateDestination},
+			1,
+		},
+		{
+			"work dir",
+			Config{SourceOrg: "kubernetes", TargetOrg: "k8s-publishing-bot", WorkDir: "/work", Lint: LintConfig{Severities: map[string]string{
+				LintMissingMainline: LintIgnore,
+			}}},
+			[]string{LintGodepWithoutGOPATH, LintGodepWithoutGOPATH, LintGoVersion, LintGodepWithoutGOPATH, LintGodepWithoutGOPATH, LintDuplicateDestination},
+			5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := Lint(&tt.cfg, &rules)
+			var checks []string
+			for _, f := range findings {
+				checks = append(checks, f.Check)
+			}
+			if !reflect.DeepEqual(checks, tt.wantChecks) {
+				t.Errorf("got checks %v, want %v", checks, tt.wantChecks)
+			}
+			if got := len(LintErrors(findings)); got != tt.wantErrors {
+				t.Errorf("got %d errors, want %d", got, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestLintConfigValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		severities map[string]string
+		wantErr    bool
+	}{
+		{"empty", nil, false},
+		{"valid", map[string]string{LintGoVersion: LintError, LintTargetIsSource: LintIgnore}, false},
+		{"unknown check", map[string]string{"typo": LintError}, true},
+		{"invalid severity", map[string]string{LintGoVersion: "fatal"}, true},
+	}
+	for _, tt := range tests {
+		if err := (LintConfig{Severities: tt.severities}).Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCompareGoVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.10.2", "1.9", 1},
+		{"1.9", "1.9.0", 0},
+		{"1.12", "1.12.1", -1},
+		{"go1.21rc1", "1.20.5", 1},
+	}
+	for _, tt := range tests {
+		if got := compareGoVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareGoVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
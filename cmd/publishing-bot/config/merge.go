@@ -0,0 +1,183 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RulesFileSeparator separates several rules files in a rules-file value.
+const RulesFileSeparator = ","
+
+// RulesFiles splits a rules-file value into its rules files or URLs. A
+// directory stands for its *.yaml, *.json and *.toml files, sorted by name.
+func RulesFiles(ruleFile string) ([]string, error) {
+	var files []string
+	for _, f := range strings.Split(ruleFile, RulesFileSeparator) {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if u, err := url.ParseRequestURI(f); err == nil && len(u.Host) > 0 {
+			files = append(files, f)
+			continue
+		}
+		if info, err := os.Stat(f); err != nil || !info.IsDir() {
+			files = append(files, f)
+			continue
+		}
+		var matches []string
+		for _, ext := range []string{"yaml", "json", "toml"} {
+			m, err := filepath.Glob(filepath.Join(f, "*."+ext))
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, m...)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no *.yaml, *.json or *.toml rules files found in %s", f)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no rules file in %q", ruleFile)
+	}
+	return files, nil
+}
+
+// MergeRules merges the rules read from the given sources into one set of
+// rules:
+//
+//   - the repository rules are concatenated and ordered such that the
+//     dependencies of a destination repo come first, otherwise by name. A
+//     destination repo must be defined in one file only.
+//   - the default branch rules are concatenated. A name must be defined in one
+//     file only.
+//   - other lists, e.g. skip-source-branches, are joined without duplicates.
+//   - other values, e.g. source-mainline-branch, must be equal in all files
+//     setting them.
+//
+// The merged rules are defaulted and validated as a whole.
+func MergeRules(sources []string, contents [][]byte) (*RepositoryRules, error) {
+	merged := yaml.MapSlice{}
+	index := map[string]int{}
+	// the source of every top-level value, destination repo and default branch rule
+	defined := map[string]string{}
+	for i, content := range contents {
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", sources[i], err)
+		}
+		for _, item := range doc {
+			key := fmt.Sprint(item.Key)
+			j, found := index[key]
+			if !found {
+				index[key] = len(merged)
+				merged = append(merged, yaml.MapItem{Key: key})
+				j = index[key]
+			}
+			switch key {
+			case "rules", "default-branch-rules":
+				field := "destination"
+				if key == "default-branch-rules" {
+					field = "name"
+				}
+				items, _ := item.Value.([]interface{})
+				for _, r := range items {
+					value := mapValue(r, field)
+					if value == nil {
+						continue // left to the validation
+					}
+					name := fmt.Sprintf("%s %v", key, value)
+					if other, found := defined[name]; found {
+						return nil, fmt.Errorf("conflicting rules files: %s %s %v defined in %s and %s", key, field, value, other, sources[i])
+					}
+					defined[name] = sources[i]
+				}
+				existing, _ := merged[j].Value.([]interface{})
+				merged[j].Value = append(existing, items...)
+			default:
+				other, found := defined[key]
+				if !found {
+					defined[key] = sources[i]
+					merged[j].Value = item.Value
+					continue
+				}
+				items, isList := item.Value.([]interface{})
+				existing, wasList := merged[j].Value.([]interface{})
+				switch {
+				case isList && (wasList || merged[j].Value == nil):
+					merged[j].Value = appendMissing(existing, items)
+				case !reflect.DeepEqual(merged[j].Value, item.Value):
+					return nil, fmt.Errorf("conflicting rules files: %s is %v in %s, but %v in %s", key, merged[j].Value, other, item.Value, sources[i])
+				}
+			}
+		}
+	}
+
+	bs, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ParseRules(bs, strings.Join(sources, RulesFileSeparator))
+	if err != nil {
+		return nil, err
+	}
+	if rules.Rules, err = sortByDependencies(rules.Rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// mapValue returns the value of the key in a yaml mapping, nested mappings of a
+// yaml.MapSlice being yaml.MapSlices as well.
+func mapValue(m interface{}, key string) interface{} {
+	if s, ok := m.(yaml.MapSlice); ok {
+		for _, item := range s {
+			if item.Key == key {
+				return item.Value
+			}
+		}
+	}
+	return nil
+}
+
+// appendMissing appends the items not in the list yet.
+func appendMissing(list, items []interface{}) []interface{} {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if reflect.DeepEqual(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
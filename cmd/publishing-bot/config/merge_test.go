@@ -0,0 +1,136 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeRules(t *testing.T) {
+	rule := func(name string, deps ...string) string {
+		s := fmt.Sprintf("- destination: %s\n  branches:\n  - name: master\n    source:\n      branch: master\n      dir: staging/src/k8s.io/%s\n", name, name)
+		if len(deps) > 0 {
+			s += "    dependencies:\n"
+			for _, d := range deps {
+				s += fmt.Sprintf("    - repository: %s\n      branch: master\n", d)
+			}
+		}
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		contents []string
+		want     []string
+		skipped  []string
+		wantErr  string
+	}{
+		{
+			name: "dependencies first",
+			contents: []string{
+				"skip-source-branches: [release-1.8]\nrules:\n" + rule("client-go", "api", "apimachinery"),
+				"skip-godeps: true\nskip-source-branches: [release-1.8, release-1.9]\nrules:\n" + rule("apimachinery") + rule("api", "apimachinery"),
+			},
+			want:    []string{"apimachinery", "api", "client-go"},
+			skipped: []string{"release-1.8", "release-1.9"},
+		},
+		{
+			name:     "equal values",
+			contents: []string{"skip-godeps: true\nrules:\n" + rule("api"), "skip-godeps: true\nrules:\n" + rule("apimachinery")},
+			want:     []string{"api", "apimachinery"},
+		},
+		{
+			name:     "duplicate destination",
+			contents: []string{"rules:\n" + rule("api"), "rules:\n" + rule("api")},
+			wantErr:  "destination api defined in a.yaml and b.yaml",
+		},
+		{
+			name:     "duplicate default branch rule",
+			contents: []string{"default-branch-rules:\n- name: master\n", "default-branch-rules:\n- name: master\n"},
+			wantErr:  "default-branch-rules name master defined in a.yaml and b.yaml",
+		},
+		{
+			name:     "conflicting values",
+			contents: []string{"source-mainline-branch: main\nrules:\n" + rule("api"), "source-mainline-branch: master\n"},
+			wantErr:  "source-mainline-branch is main in a.yaml, but master in b.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := []string{"a.yaml", "b.yaml"}
+			var contents [][]byte
+			for _, c := range tt.contents {
+				contents = append(contents, []byte(c))
+			}
+			rules, err := MergeRules(sources, contents)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range rules.Rules {
+				got = append(got, r.DestinationRepository)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected rules %v, got %v", tt.want, got)
+			}
+			if !reflect.DeepEqual(rules.SkippedSourceBranches, tt.skipped) {
+				t.Errorf("expected skipped source branches %v, got %v", tt.skipped, rules.SkippedSourceBranches)
+			}
+		})
+	}
+}
+
+func TestRulesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"b.yaml", "a.yaml", "README.md"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.Mkdir(empty, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := RulesFiles("/etc/rules.yaml, " + dir + ",https://example.com/rules.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/etc/rules.yaml", filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml"), "https://example.com/rules.yaml"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("expected %v, got %v", want, files)
+	}
+	if _, err := RulesFiles(empty); err == nil {
+		t.Errorf("expected an error for a directory without rules files")
+	}
+}
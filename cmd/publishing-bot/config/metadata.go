@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// RepositoryMetadata is reconciled onto the destination repository via the
+// GitHub API in every run. Empty fields are not managed.
+type RepositoryMetadata struct {
+	Description string `yaml:"description,omitempty"`
+	Homepage    string `yaml:"homepage,omitempty"`
+	// Topics replace all topics of the repository if set.
+	Topics []string `yaml:"topics,omitempty"`
+	// Archived archives the repository and stops publishing to it. Unarchiving
+	// is left to humans.
+	Archived bool `yaml:"archived,omitempty"`
+}
+
+// How destination repos archived on GitHub, but not configured to be, are
+// handled.
+const (
+	ArchivedReposSkip      = "skip"
+	ArchivedReposUnarchive = "unarchive"
+)
+
+// ValidateArchivedRepos checks the handling of archived destination repos.
+// Empty means skip.
+func ValidateArchivedRepos(mode string) error {
+	switch mode {
+	case "", ArchivedReposSkip, ArchivedReposUnarchive:
+		return nil
+	}
+	return fmt.Errorf("invalid archived-repos %q, must be %s or %s", mode, ArchivedReposSkip, ArchivedReposUnarchive)
+}
+
+// IsZero returns true if no metadata is managed.
+func (m RepositoryMetadata) IsZero() bool {
+	return m.Description == "" && m.Homepage == "" && m.Topics == nil && !m.Archived
+}
+
+// githubTopic matches the topics GitHub accepts.
+var githubTopic = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+// Validate checks the homepage URL and the topics.
+func (m RepositoryMetadata) Validate() error {
+	if m.Homepage != "" {
+		u, err := url.Parse(m.Homepage)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid homepage %q: expected an http(s) URL", m.Homepage)
+		}
+	}
+	for _, t := range m.Topics {
+		if !githubTopic.MatchString(t) {
+			return fmt.Errorf("invalid topic %q: expected up to 50 lowercase letters, numbers and hyphens", t)
+		}
+	}
+	return nil
+}
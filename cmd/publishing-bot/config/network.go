@@ -0,0 +1,212 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultGoToolchainMirror is where Go releases are downloaded from by default.
+const DefaultGoToolchainMirror = "https://storage.googleapis.com/golang"
+
+// NetworkConfig describes how the bot reaches the network, e.g. through a proxy
+// in a restricted corporate network.
+type NetworkConfig struct {
+	HTTPProxy  string `yaml:"http-proxy,omitempty"`
+	HTTPSProxy string `yaml:"https-proxy,omitempty"`
+	NoProxy    string `yaml:"no-proxy,omitempty"`
+
+	// GoProxy, GoSumDB and GoNoSumDB are passed as GOPROXY, GOSUMDB and GONOSUMDB
+	// to the dependency restoration, e.g. pointing to an internal module proxy.
+	GoProxy   string `yaml:"goproxy,omitempty"`
+	GoSumDB   string `yaml:"gosumdb,omitempty"`
+	GoNoSumDB string `yaml:"gonosumdb,omitempty"`
+
+	// GoToolchainMirror is the base URL Go releases are downloaded from, i.e.
+	// <mirror>/go<version>.linux-amd64.tar.gz. Defaults to DefaultGoToolchainMirror.
+	GoToolchainMirror string `yaml:"go-toolchain-mirror,omitempty"`
+
+	// AirGapped requires the Go toolchain mirror and the module proxy to be
+	// internal, i.e. to be configured explicitly and to be reachable at startup.
+	AirGapped bool `yaml:"air-gapped,omitempty"`
+
+	// TLS configures custom CA certificates and TLS versions of all HTTPS
+	// connections: toolchain downloads, git over HTTPS and the GitHub API.
+	TLS TLSConfig `yaml:"tls,omitempty"`
+}
+
+// Env returns the environment variables for the network settings.
+func (n NetworkConfig) Env() []string {
+	var env []string
+	add := func(v string, keys ...string) {
+		if v == "" {
+			return
+		}
+		for _, k := range keys {
+			env = append(env, k+"="+v)
+		}
+	}
+	add(n.HTTPProxy, "HTTP_PROXY", "http_proxy")
+	add(n.HTTPSProxy, "HTTPS_PROXY", "https_proxy")
+	add(n.NoProxy, "NO_PROXY", "no_proxy")
+	add(n.GoProxy, "GOPROXY")
+	add(n.GoSumDB, "GOSUMDB")
+	add(n.GoNoSumDB, "GONOSUMDB")
+	return env
+}
+
+// Apply sets the environment variables of the network settings for this
+// process and for all commands started by it. It must be called before the
+// first HTTP request because the proxy environment is only read once. The TLS
+// settings are not applied to the process, see TLSConfig.Transport and
+// TLSConfig.Env.
+func (n NetworkConfig) Apply() error {
+	for _, kv := range n.Env() {
+		ss := strings.SplitN(kv, "=", 2)
+		if err := os.Setenv(ss[0], ss[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Setup validates and applies the network settings and checks that the
+// mirrors are reachable. An unreachable mirror is an error in air-gapped mode
+// and passed to warnf otherwise.
+func (n NetworkConfig) Setup(ctx context.Context, warnf func(format string, args ...interface{})) error {
+	if err := n.Validate(); err != nil {
+		return fmt.Errorf("invalid network configuration: %v", err)
+	}
+	if err := n.Apply(); err != nil {
+		return fmt.Errorf("failed to apply network configuration: %v", err)
+	}
+	if err := n.CheckReachable(ctx); err != nil {
+		if n.AirGapped {
+			return fmt.Errorf("network check failed in air-gapped mode: %v", err)
+		}
+		warnf("Network check failed: %v", err)
+	}
+	return nil
+}
+
+// GoToolchainURL returns the download URL of the given Go release.
+func (n NetworkConfig) GoToolchainURL(version string) string {
+	mirror := n.GoToolchainMirror
+	if mirror == "" {
+		mirror = DefaultGoToolchainMirror
+	}
+	return fmt.Sprintf("%s/go%s.linux-amd64.tar.gz", strings.TrimSuffix(mirror, "/"), version)
+}
+
+// Validate checks the syntax of the network settings.
+func (n NetworkConfig) Validate() error {
+	for name, u := range map[string]string{
+		"http-proxy":          n.HTTPProxy,
+		"https-proxy":         n.HTTPSProxy,
+		"go-toolchain-mirror": n.GoToolchainMirror,
+	} {
+		if u == "" {
+			continue
+		}
+		if err := validateURL(u); err != nil {
+			return fmt.Errorf("invalid %s %q: %v", name, u, err)
+		}
+	}
+	for _, p := range n.goProxyURLs() {
+		if err := validateURL(p); err != nil {
+			return fmt.Errorf("invalid goproxy entry %q: %v", p, err)
+		}
+	}
+	if err := n.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid tls configuration: %v", err)
+	}
+	if n.AirGapped {
+		if n.GoToolchainMirror == "" {
+			return fmt.Errorf("go-toolchain-mirror must be set in air-gapped mode")
+		}
+		if len(n.goProxyURLs()) == 0 {
+			return fmt.Errorf("goproxy must point to an internal module proxy in air-gapped mode")
+		}
+	}
+	return nil
+}
+
+// CheckReachable verifies that the Go toolchain mirror and the module proxies
+// answer HTTP requests.
+func (n NetworkConfig) CheckReachable(ctx context.Context) error {
+	urls := n.goProxyURLs()
+	if n.GoToolchainMirror != "" {
+		urls = append(urls, n.GoToolchainMirror)
+	}
+	transport, err := n.TLS.Transport()
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport}
+	for _, u := range urls {
+		ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		req, err := http.NewRequest("HEAD", u, nil)
+		if err != nil {
+			cancel()
+			return err
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s is not reachable: %v", u, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s is not reachable: HTTP code %d", u, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// goProxyURLs returns the URLs in GoProxy, without keywords like "direct".
+func (n NetworkConfig) goProxyURLs() []string {
+	var urls []string
+	for _, p := range strings.FieldsFunc(n.GoProxy, func(r rune) bool { return r == ',' || r == '|' }) {
+		if p == "direct" || p == "off" {
+			continue
+		}
+		urls = append(urls, p)
+	}
+	return urls
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("host missing")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return nil
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "fmt"
+
+// BranchProtection is reconciled onto the branches of the destination
+// repository via the GitHub API in every run. It replaces other protection
+// settings of these branches, e.g. required status checks. The bot must be an
+// admin of the repository to push despite the protection.
+type BranchProtection struct {
+	// Branches are the protected destination branches. Empty means all
+	// published branches, including aliases.
+	Branches []string `yaml:"branches,omitempty"`
+	// RequireSignedCommits rejects pushes of unsigned commits by non-admins.
+	RequireSignedCommits bool `yaml:"require-signed-commits,omitempty"`
+	// EnforceAdmins applies the protection to admins as well.
+	EnforceAdmins bool `yaml:"enforce-admins,omitempty"`
+	// RestrictPushes allows only the bot, PushUsers and PushTeams to push. This
+	// is only supported for repositories owned by an organization.
+	RestrictPushes bool `yaml:"restrict-pushes,omitempty"`
+	// PushUsers and PushTeams (slugs) may push besides the bot.
+	PushUsers []string `yaml:"push-users,omitempty"`
+	PushTeams []string `yaml:"push-teams,omitempty"`
+}
+
+// IsZero returns true if branch protection is not managed.
+func (p BranchProtection) IsZero() bool {
+	return p.Branches == nil && !p.RequireSignedCommits && !p.EnforceAdmins && !p.RestrictPushes && p.PushUsers == nil && p.PushTeams == nil
+}
+
+// ProtectedBranches returns the destination branches of the rule which are
+// protected.
+func (r RepositoryRule) ProtectedBranches() []string {
+	if r.BranchProtection.IsZero() {
+		return nil
+	}
+	if len(r.BranchProtection.Branches) > 0 {
+		return r.BranchProtection.Branches
+	}
+	var branches []string
+	for _, b := range r.Branches {
+		branches = append(branches, b.Name)
+		branches = append(branches, b.Aliases...)
+	}
+	return branches
+}
+
+// Validate checks that push users and teams are only given with restricted
+// pushes.
+func (p BranchProtection) Validate() error {
+	if !p.RestrictPushes && (len(p.PushUsers) > 0 || len(p.PushTeams) > 0) {
+		return fmt.Errorf("push-users and push-teams require restrict-pushes")
+	}
+	return nil
+}
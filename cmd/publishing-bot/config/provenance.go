@@ -0,0 +1,81 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The kinds of origins of effective config values, in increasing precedence:
+// a flag overrides an environment variable, which overrides the config file,
+// which overrides the defaults.
+const (
+	OriginDefault = "default"
+	OriginFile    = "file"
+	OriginEnv     = "env"
+	OriginFlag    = "flag"
+)
+
+// Origin tells where an effective config value came from.
+type Origin struct {
+	Kind string
+	// Name is the config file, the environment variable or the flag.
+	Name string
+}
+
+func (o Origin) String() string {
+	if o.Name == "" {
+		return o.Kind
+	}
+	return o.Kind + " " + o.Name
+}
+
+// Provenance maps top-level config keys to the origin of their effective value.
+type Provenance map[string]Origin
+
+// SetOrigin records the origin of the value of the given top-level key.
+func (c *Config) SetOrigin(key string, o Origin) {
+	if c.Provenance == nil {
+		c.Provenance = Provenance{}
+	}
+	c.Provenance[key] = o
+}
+
+// OriginOf returns the origin of the value of the given top-level key. Keys
+// without recorded origin have their default value.
+func (c *Config) OriginOf(key string) Origin {
+	if o, found := c.Provenance[key]; found {
+		return o
+	}
+	return Origin{Kind: OriginDefault}
+}
+
+// FileKeys returns the sorted top-level keys set in the given yaml config.
+func FileKeys(bs []byte) ([]string, error) {
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(bs, &m); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
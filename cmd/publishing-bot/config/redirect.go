@@ -0,0 +1,124 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"text/template"
+	"time"
+)
+
+// DefaultRedirectPollInterval is how often the destination repos are scanned
+// by default.
+const DefaultRedirectPollInterval = time.Hour
+
+// DefaultRedirectLabel is the default label of pull requests and issues which
+// were commented on.
+const DefaultRedirectLabel = "redirected-to-source"
+
+// DefaultRedirectMessage is the default template of the comment on pull
+// requests and issues opened in destination repos.
+const DefaultRedirectMessage = "Thanks for your {{.Kind}}, @{{.Author}}! This repository is published automatically from `{{.SourceDir}}` in " +
+	"[{{.SourceRepository}}]({{.SourceURL}}) by the publishing-bot, hence changes cannot be merged here. " +
+	"Please open your {{.Kind}} there instead.{{if .CloseAfter}} This pull request is closed in {{.CloseAfter}}.{{end}}"
+
+// Redirect configures comments on pull requests and issues opened by humans in
+// the destination repos, directing contributors to the source repo.
+type Redirect struct {
+	// PullRequests enables comments on pull requests.
+	PullRequests bool `yaml:"pull-requests,omitempty"`
+	// Issues enables comments on issues.
+	Issues bool `yaml:"issues,omitempty"`
+	// Message is a template of the comment with .Kind (pull request or
+	// issue), .Author, .Repository, .SourceRepository, .SourceURL, .SourceDir
+	// and .CloseAfter. Defaults to DefaultRedirectMessage.
+	Message string `yaml:"message,omitempty"`
+	// ClosePullRequestsAfter is the grace period after the comment after which
+	// pull requests are closed. Zero leaves them open.
+	ClosePullRequestsAfter time.Duration `yaml:"close-pull-requests-after,omitempty"`
+	// ExemptUsers are GitHub logins whose pull requests and issues are left
+	// alone. Bot accounts are always exempt.
+	ExemptUsers []string `yaml:"exempt-users,omitempty"`
+	// ExemptLabels are labels of pull requests and issues which are left alone.
+	ExemptLabels []string `yaml:"exempt-labels,omitempty"`
+	// CreatedAfter is a date like 2018-06-30. Only pull requests and issues
+	// created after it are commented on, such that enabling the redirector
+	// leaves older ones alone. Empty means all.
+	CreatedAfter string `yaml:"created-after,omitempty"`
+	// Label marks the pull requests and issues which were commented on.
+	// Defaults to DefaultRedirectLabel.
+	Label string `yaml:"label,omitempty"`
+	// PollInterval defaults to DefaultRedirectPollInterval.
+	PollInterval time.Duration `yaml:"poll-interval,omitempty"`
+}
+
+// Enabled returns true if pull requests or issues are commented on.
+func (r Redirect) Enabled() bool {
+	return r.PullRequests || r.Issues
+}
+
+// Interval returns the poll interval.
+func (r Redirect) Interval() time.Duration {
+	if r.PollInterval <= 0 {
+		return DefaultRedirectPollInterval
+	}
+	return r.PollInterval
+}
+
+// Cutoff returns the end of the created-after day in UTC, or the zero time.
+func (r Redirect) Cutoff() time.Time {
+	t, _ := parseSunsetDate(r.CreatedAfter)
+	return t
+}
+
+// HandledLabel returns the label of pull requests and issues which were
+// commented on.
+func (r Redirect) HandledLabel() string {
+	if r.Label == "" {
+		return DefaultRedirectLabel
+	}
+	return r.Label
+}
+
+// Template returns the parsed comment template.
+func (r Redirect) Template() (*template.Template, error) {
+	msg := r.Message
+	if msg == "" {
+		msg = DefaultRedirectMessage
+	}
+	return template.New("redirect").Option("missingkey=error").Parse(msg)
+}
+
+// Validate checks the comment template, the grace period and the cutoff date.
+func (r Redirect) Validate() error {
+	if r.ClosePullRequestsAfter < 0 {
+		return fmt.Errorf("invalid negative close-pull-requests-after %v", r.ClosePullRequestsAfter)
+	}
+	if _, err := parseSunsetDate(r.CreatedAfter); err != nil {
+		return fmt.Errorf("invalid created-after: %v", err)
+	}
+	tmpl, err := r.Template()
+	if err != nil {
+		return fmt.Errorf("invalid message template: %v", err)
+	}
+	data := map[string]string{"Kind": "", "Author": "", "Repository": "", "SourceRepository": "", "SourceURL": "", "SourceDir": "", "CloseAfter": ""}
+	if err := tmpl.Execute(ioutil.Discard, data); err != nil {
+		return fmt.Errorf("invalid message template: %v", err)
+	}
+	return nil
+}
@@ -2,20 +2,28 @@ package config
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
 	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
-// Dependency of a piece of code
+// Dependency of a piece of code. By default, the dependency is the commit of
+// the branch which corresponds to the published source commit. Tag or Commit
+// pin it to a fixed revision of the published dependency instead.
 type Dependency struct {
 	Repository string `yaml:"repository"`
-	Branch     string `yaml:"branch"`
+	Branch     string `yaml:"branch,omitempty"`
+	Tag        string `yaml:"tag,omitempty"`
+	Commit     string `yaml:"commit,omitempty"`
 }
 
 func (c Dependency) String() string {
@@ -23,9 +31,45 @@ func (c Dependency) String() string {
 	if len(repo) == 0 {
 		repo = "<source>"
 	}
+	switch {
+	case c.Tag != "":
+		return fmt.Sprintf("[repository %s, tag %s]", repo, c.Tag)
+	case c.Commit != "":
+		return fmt.Sprintf("[repository %s, commit %s]", repo, c.Commit)
+	}
 	return fmt.Sprintf("[repository %s, branch %s]", repo, c.Branch)
 }
 
+// Pin returns the tag or commit the dependency is pinned to, or the empty
+// string if it follows its branch.
+func (c Dependency) Pin() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return c.Commit
+}
+
+// Validate checks that the dependency follows exactly one of a branch, a tag
+// or a commit.
+func (c Dependency) Validate() error {
+	n := 0
+	for _, s := range []string{c.Branch, c.Tag, c.Commit} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("dependency %s must have exactly one of branch, tag or commit", c.Repository)
+	}
+	if c.Commit != "" && !skippedSourceCommitSHA.MatchString(c.Commit) {
+		return fmt.Errorf("dependency %s: invalid commit %q, expected at least 7 hex digits", c.Repository, c.Commit)
+	}
+	if strings.ContainsAny(c.Branch+c.Tag, ":, ") {
+		return fmt.Errorf("dependency %s: invalid branch or tag", c.Repository)
+	}
+	return nil
+}
+
 // Source of a piece of code
 type Source struct {
 	Repository string `yaml:"repository"`
@@ -44,12 +88,113 @@ func (c Source) String() string {
 
 type BranchRule struct {
 	Name string `yaml:"name"`
-	// a (full) version string like 1.10.2.
+	// a (full) version string like 1.10.2. It is installed on demand when the
+	// branch is published.
 	GoVersion string `yaml:"go"`
+	// a file in the source branch with the Go version, either the version
+	// alone like .go-version or build/dependencies.yaml of Kubernetes. It
+	// overrides go, which is used if the file does not exist.
+	GoVersionFile string `yaml:"go-version-file,omitempty"`
+	// how the dependency metadata of the branch is updated: godep, go-mod or
+	// none. It defaults to godep, or none with skip-godeps.
+	DependencyTool string `yaml:"dependency-tool,omitempty"`
 	// k8s.io/* repos the branch rule depends on
 	Dependencies     []Dependency `yaml:"dependencies,omitempty"`
 	Source           Source       `yaml:"source"`
 	RequiredPackages []string     `yaml:"required-packages,omitempty"`
+	// additional destination branch names the branch is pushed to, e.g. main
+	// while renaming master to main.
+	Aliases []string `yaml:"aliases,omitempty"`
+	// a source commit or tag the branch is published up to, e.g. to freeze it
+	// at a known-good point. Remove it to resume publishing.
+	Pin string `yaml:"pin,omitempty"`
+	// when publishing of the branch ends. It overrides the repository rule.
+	Sunset `yaml:",inline"`
+}
+
+// ImportRewrite replaces the import path prefix From with To.
+type ImportRewrite struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// FilterStep is a custom transformation of the tree of every published commit,
+// run by filter-tree. Exactly one of Name and Command is set.
+type FilterStep struct {
+	// Name is a filter compiled into filter-tree.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Command is a bash script run in a directory with the files of each commit.
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	// Args are passed to the filter.
+	Args []string `yaml:"args,omitempty" json:"args,omitempty"`
+}
+
+// Submodule modes.
+const (
+	SubmodulesDrop        = "drop"
+	SubmodulesPreserve    = "preserve"
+	SubmodulesMaterialize = "materialize"
+)
+
+// Policies of symlinks pointing outside of the published directories.
+const (
+	SymlinksError       = "error"
+	SymlinksDrop        = "drop"
+	SymlinksMaterialize = "materialize"
+)
+
+// Submodules controls how git submodules below the published directories are
+// published by filter-tree.
+type Submodules struct {
+	// Mode is drop to remove them, preserve to keep them with a .gitmodules
+	// file in the published directory, or materialize to publish the files of
+	// the submodule commits instead.
+	Mode string `yaml:"mode,omitempty"`
+	// URLs rewrites submodule URL prefixes, e.g. of an internal host, in the
+	// published .gitmodules and for fetching materialized submodules.
+	URLs map[string]string `yaml:"urls,omitempty"`
+}
+
+// URLRewrites returns the URL rewrites in the format of filter-tree, sorted.
+func (s Submodules) URLRewrites() string {
+	var rs []string
+	for from, to := range s.URLs {
+		rs = append(rs, from+"="+to)
+	}
+	sort.Strings(rs)
+	return strings.Join(rs, " ")
+}
+
+// Validate checks the mode and the URL rewrites.
+func (s Submodules) Validate() error {
+	switch s.Mode {
+	case "", SubmodulesDrop, SubmodulesPreserve, SubmodulesMaterialize:
+	default:
+		return fmt.Errorf("invalid mode %q, must be %s, %s or %s", s.Mode, SubmodulesDrop, SubmodulesPreserve, SubmodulesMaterialize)
+	}
+	for from, to := range s.URLs {
+		if from == "" || strings.ContainsAny(from+to, " \t\n=") {
+			return fmt.Errorf("invalid URL rewrite from %q to %q", from, to)
+		}
+	}
+	return nil
+}
+
+// PushTarget is an additional remote a destination repo is mirrored to, e.g. an
+// internal GitLab instance. It receives the same branches and tags as origin.
+type PushTarget struct {
+	// Name is the name of the git remote, e.g. "gitlab".
+	Name string `yaml:"name"`
+	// URL is the git URL of the mirror repository.
+	URL string `yaml:"url"`
+	// TokenFile is the file with the token to push with, or a secret reference like
+	// env:GITLAB_TOKEN. Defaults to the global token.
+	TokenFile string `yaml:"token-file,omitempty"`
+	// Username is sent together with the token, e.g. "oauth2" for GitLab.
+	Username string `yaml:"username,omitempty"`
+	// BitbucketServer is the repository on a Bitbucket Server instance, which
+	// determines the URL if it is empty.
+	BitbucketServer *BitbucketServer `yaml:"bitbucket-server,omitempty"`
 }
 
 // a collection of publishing rules for a single destination repo
@@ -58,49 +203,726 @@ type RepositoryRule struct {
 	Branches              []BranchRule `yaml:"branches"`
 	SmokeTest             string       `yaml:"smoke-test,omitempty"` // a multiline bash script
 	Library               bool         `yaml:"library,omitempty"`
+	// bash scripts regenerating generated files, e.g. go generate ./..., run on
+	// new constructed branches. Publishing fails if they change any file.
+	VerifyGenerated []string `yaml:"verify-generated,omitempty"`
 	// not updated when true
 	Skip bool `yaml:"skipped,omitempty"`
+	// additional remotes to push the same refs to
+	PushTargets []PushTarget `yaml:"push-targets,omitempty"`
+	// the default branch of the destination repo. It is set via the GitHub API
+	// if it differs.
+	DefaultBranch string `yaml:"default-branch,omitempty"`
+	// how to handle commits which become empty after filtering: drop, keep
+	// (the default) or keep-with-marker.
+	EmptyCommits string `yaml:"empty-commits,omitempty"`
+	// how to handle source merge commits: preserve (the default) or linearize.
+	MergeCommits string `yaml:"merge-commits,omitempty"`
+	// how source commits are published: one destination commit per source
+	// commit (mirror, the default) or all changes since the last publish as a
+	// single synthetic commit listing the included source commits (squash).
+	Commits string `yaml:"commits,omitempty"`
+	// files generated for code review routing in the destination repo
+	Owners OwnersSync `yaml:"owners,omitempty"`
+	// the license files of the destination repo
+	License LicenseSync `yaml:"license,omitempty"`
+	// scripts run per destination branch before and after pushing
+	Hooks Hooks `yaml:"hooks,omitempty"`
+	// the committer identity in the destination repo. Empty fields default to
+	// the global git-identity.
+	GitIdentity GitIdentity `yaml:"git-identity,omitempty"`
+	// when publishing of all branches ends
+	Sunset `yaml:",inline"`
+	// description, homepage, topics and archived state of the destination repo
+	Metadata RepositoryMetadata `yaml:"metadata,omitempty"`
+	// how often the destination repo is published
+	Batching Batching `yaml:"batching,omitempty"`
+	// how a new destination repo is initialized: with the full filtered history
+	// of the source directory (history) or with one commit per branch (squash).
+	// If set, a missing destination repo is created via the GitHub API.
+	Bootstrap string `yaml:"bootstrap,omitempty"`
+	// the base package of the destination repo, e.g. example.com/project,
+	// overriding the global base-package. The repo is checked out below it in
+	// GOPATH and its dependencies in other destination repos are rewritten with
+	// it. Dependencies must have the same base package.
+	BasePackage string `yaml:"base-package,omitempty"`
+	// import paths rewritten in the Go files of the published history, e.g.
+	// from internal package paths of the source repo to the module path of the
+	// destination repo. Vendored copies of the packages are moved accordingly.
+	ImportRewrites []ImportRewrite `yaml:"import-rewrites,omitempty"`
+	// normalizes the Go files changed by the import rewrites with gofmt or
+	// goimports of the Go version of the branch. Without goimports in the
+	// PATH, gofmt is used.
+	ImportRewriteFormat string `yaml:"import-rewrite-format,omitempty"`
+	// custom transformations of the published files of every commit, run in
+	// order after the import rewrites.
+	Filters []FilterStep `yaml:"filters,omitempty"`
+	// how git submodules below the published directories are published. By
+	// default, their gitlinks are kept without .gitmodules.
+	Submodules Submodules `yaml:"submodules,omitempty"`
+	// how symlinks below the published directories pointing outside of them
+	// are published: error, drop or materialize as copies of their targets. By
+	// default, they are kept and dangle.
+	Symlinks string `yaml:"symlinks,omitempty"`
+	// the source directory of branches which leave it empty, overriding the
+	// global source-dir-template.
+	SourceDirTemplate string `yaml:"source-dir-template,omitempty"`
+	// the maximal size of files in new commits, e.g. 10Mi. Zero means no limit.
+	MaxBlobSize ByteSize `yaml:"max-blob-size,omitempty"`
+	// which source tags are published and how they are named
+	Tags TagPolicy `yaml:"tags,omitempty"`
+	// protection of the destination branches, reconciled via the GitHub API
+	BranchProtection BranchProtection `yaml:"branch-protection,omitempty"`
+	// the licenses allowed in the dependencies of the destination branches
+	DependencyLicenses DependencyLicenses `yaml:"dependency-licenses,omitempty"`
+	// software bills of materials of the destination branches and tags
+	SBOM SBOM `yaml:"sbom,omitempty"`
+	// repos with a higher priority and their dependencies are constructed and
+	// pushed first in every cycle, e.g. such that the most consumed repos are
+	// fresh even if the cycle deadline hits. Defaults to 0.
+	Priority int `yaml:"priority,omitempty"`
 }
 
+// Tag classes of source release tags.
+const (
+	TagClassAlpha = "alpha"
+	TagClassBeta  = "beta"
+	TagClassRC    = "rc"
+	TagClassFinal = "final"
+)
+
+// Types and taggers of published tags.
+const (
+	TagTypeAnnotated   = "annotated"
+	TagTypeLightweight = "lightweight"
+	TaggerBot          = "bot"
+	TaggerSource       = "source"
+)
+
+// TagPolicy controls which source tags are published to a destination repo. By
+// default, all tags are published with the source repo name as prefix, e.g.
+// kubernetes-1.30.0 for v1.30.0.
+type TagPolicy struct {
+	// Classes are the classes of source tags to publish: alpha, beta, rc and
+	// final, e.g. only final for stable-only consumers. Empty means all tags.
+	// Tags which are no release version are only published if it is empty.
+	Classes []string `yaml:"classes,omitempty"`
+	// SemverMajor, if set, additionally publishes release tags as semver tags
+	// with this major version, e.g. v0.30.0-alpha.1 for v1.30.0-alpha.1 with 0.
+	SemverMajor *int `yaml:"semver-major,omitempty"`
+	// Include are regular expressions of the source tags to publish, e.g.
+	// ^v1\.2\d\., matched against the source tag names before they are
+	// translated. Empty means all tags.
+	Include []string `yaml:"include,omitempty"`
+	// Exclude are regular expressions of source tags never to publish, e.g.
+	// ^test-. They take precedence over Include.
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Type is annotated (default) or lightweight. Lightweight tags have no
+	// message and no tagger.
+	Type string `yaml:"type,omitempty"`
+	// Message is the text/template of the message of annotated tags with .Tag,
+	// .SourceTag, .SourceCommit and .ReleaseNotesURL. Defaults to a Kubernetes
+	// release message.
+	Message string `yaml:"message,omitempty"`
+	// Tagger is bot (default) to create annotated tags with the git identity
+	// of the bot, or source to keep the tagger of the source tag. They are
+	// dated like the source tag, such that tags created again are identical.
+	Tagger string `yaml:"tagger,omitempty"`
+}
+
+// Validate checks the tag classes and the semver major version.
+func (t TagPolicy) Validate() error {
+	for _, c := range t.Classes {
+		switch c {
+		case TagClassAlpha, TagClassBeta, TagClassRC, TagClassFinal:
+		default:
+			return fmt.Errorf("invalid tag class %q", c)
+		}
+	}
+	if t.SemverMajor != nil && *t.SemverMajor < 0 {
+		return fmt.Errorf("negative semver-major %d", *t.SemverMajor)
+	}
+	for _, p := range append(append([]string(nil), t.Include...), t.Exclude...) {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid tag pattern %q: %v", p, err)
+		}
+	}
+	switch t.Type {
+	case "", TagTypeAnnotated, TagTypeLightweight:
+	default:
+		return fmt.Errorf("invalid tag type %q, must be %s or %s", t.Type, TagTypeAnnotated, TagTypeLightweight)
+	}
+	switch t.Tagger {
+	case "", TaggerBot, TaggerSource:
+	default:
+		return fmt.Errorf("invalid tagger %q, must be %s or %s", t.Tagger, TaggerBot, TaggerSource)
+	}
+	if t.Message != "" {
+		tmpl, err := template.New("message").Option("missingkey=error").Parse(t.Message)
+		if err != nil {
+			return fmt.Errorf("invalid tag message template: %v", err)
+		}
+		data := map[string]string{"Tag": "", "SourceTag": "", "SourceCommit": "", "ReleaseNotesURL": ""}
+		if err := tmpl.Execute(ioutil.Discard, data); err != nil {
+			return fmt.Errorf("invalid tag message template: %v", err)
+		}
+	}
+	return nil
+}
+
+// IncludePattern returns a regular expression matching the source tags of any
+// of the include patterns, or the empty string if there are none.
+func (t TagPolicy) IncludePattern() string {
+	return anyOf(t.Include)
+}
+
+// ExcludePattern returns a regular expression matching the source tags of any
+// of the exclude patterns, or the empty string if there are none.
+func (t TagPolicy) ExcludePattern() string {
+	return anyOf(t.Exclude)
+}
+
+// Publishes returns whether the source tag passes the include and exclude
+// patterns, which must be valid.
+func (t TagPolicy) Publishes(name string) bool {
+	if p := t.IncludePattern(); p != "" && !regexp.MustCompile(p).MatchString(name) {
+		return false
+	}
+	if p := t.ExcludePattern(); p != "" && regexp.MustCompile(p).MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// anyOf returns a regular expression matching any of the given ones.
+func anyOf(patterns []string) string {
+	var ps []string
+	for _, p := range patterns {
+		ps = append(ps, "(?:"+p+")")
+	}
+	return strings.Join(ps, "|")
+}
+
+// Batching limits how often a destination repo is published. By default, it is
+// published in every run.
+type Batching struct {
+	// Interval publishes the repo at most every interval, e.g. 6h.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// OnlyOnChanges skips the repo in runs in which no new source commit touches
+	// the source directories of its branches. New tags are published with the next
+	// change then.
+	OnlyOnChanges bool `yaml:"only-on-changes,omitempty"`
+	// MaxCommits limits the new first-parent source commits published per
+	// branch and run. The remaining ones are published in the next runs. New
+	// branches and branches rebuilt after a force-push are published in full.
+	MaxCommits int `yaml:"max-commits,omitempty"`
+}
+
+// Hooks are run in the destination repo with the branch checked out. They get
+// the source commit, the destination repo and branch as PUBLISHER_BOT_*
+// environment variables.
+type Hooks struct {
+	// PrePush hooks run after the branch is constructed, e.g. for code
+	// generation. Their commits are published. A failing hook stops publishing.
+	PrePush []Hook `yaml:"pre-push,omitempty"`
+	// PostPush hooks run after the branch was pushed to origin, e.g. to
+	// trigger downstream jobs. Failures are reported, but do not stop publishing.
+	PostPush []Hook `yaml:"post-push,omitempty"`
+}
+
+// Hook is a bash script, run locally or in a container image.
+type Hook struct {
+	Name string `yaml:"name"`
+	// a multiline bash script. With an image, it is run in the container, or the
+	// image's default command if the script is empty.
+	Script string `yaml:"script,omitempty"`
+	// a container image the hook is run in via docker, with the destination repo
+	// mounted at /workspace.
+	Image string `yaml:"image,omitempty"`
+}
+
+// OwnersSync configures the files the bot generates from the published OWNERS
+// files. They are committed on top of the published branches.
+type OwnersSync struct {
+	// Aliases writes those aliases of the source repository's OWNERS_ALIASES
+	// into OWNERS_ALIASES which are used by the published OWNERS files.
+	Aliases bool `yaml:"aliases,omitempty"`
+	// CodeOwners writes .github/CODEOWNERS with the approvers of each OWNERS
+	// file, aliases expanded.
+	CodeOwners bool `yaml:"codeowners,omitempty"`
+}
+
+// LicenseSync configures the license files of the destination branches. The
+// license file is written and committed on top of the published branches if it
+// differs, and publishing fails if it is missing.
+type LicenseSync struct {
+	// File is the license file name. Defaults to LICENSE.
+	File string `yaml:"file,omitempty"`
+	// CopyFrom is the path of the license file in the source repository.
+	CopyFrom string `yaml:"copy-from,omitempty"`
+	// Content is the license text, a template which can refer to
+	// {{.Destination}} and {{.Year}}, the year of the latest source commit.
+	Content string `yaml:"content,omitempty"`
+	// Notice generates a NOTICE file with the license files found in vendor/.
+	Notice bool `yaml:"notice,omitempty"`
+	// VerifyCommits requires every newly published commit to contain the
+	// license file, with the configured content if any.
+	VerifyCommits bool `yaml:"verify-commits,omitempty"`
+}
+
+// Enabled returns true if license files are managed.
+func (l LicenseSync) Enabled() bool {
+	return l.File != "" || l.CopyFrom != "" || l.Content != "" || l.Notice || l.VerifyCommits
+}
+
+// FileName returns the name of the license file.
+func (l LicenseSync) FileName() string {
+	if l.File == "" {
+		return "LICENSE"
+	}
+	return l.File
+}
+
+// Policies for commits which become empty after filtering.
+const (
+	EmptyCommitsDrop           = "drop"
+	EmptyCommitsKeep           = "keep"
+	EmptyCommitsKeepWithMarker = "keep-with-marker"
+)
+
+// Tools updating the dependencies on published dependency repos.
+const (
+	// DependencyToolGodep updates Godeps/Godeps.json and vendor/.
+	DependencyToolGodep = "godep"
+	// DependencyToolGoMod updates the requirements in go.mod.
+	DependencyToolGoMod = "go-mod"
+	// DependencyToolNone leaves the dependency metadata alone.
+	DependencyToolNone = "none"
+)
+
+// Policies for source merge commits.
+const (
+	// MergeCommitsPreserve publishes each pull request as a branch merged into
+	// the mainline.
+	MergeCommitsPreserve = "preserve"
+	// MergeCommitsLinearize publishes each pull request as a single commit.
+	MergeCommitsLinearize = "linearize"
+)
+
+// Modes to publish source commits.
+const (
+	// CommitsMirror publishes each source commit as a destination commit.
+	CommitsMirror = "mirror"
+	// CommitsSquash publishes the changes of a run as a single commit.
+	CommitsSquash = "squash"
+)
+
+// Modes to initialize new destination repos.
+const (
+	// BootstrapHistory publishes the full filtered history of the source directory.
+	BootstrapHistory = "history"
+	// BootstrapSquash publishes each branch as a single commit.
+	BootstrapSquash = "squash"
+)
+
 type RepositoryRules struct {
-	SkippedSourceBranches []string         `yaml:"skip-source-branches"`
-	SkipGodeps            bool             `yaml:"skip-godeps"`
-	SkipTags              bool             `yaml:"skip-tags"`
-	Rules                 []RepositoryRule `yaml:"rules"`
+	// the mainline branch of the source repo other branches are forked from.
+	// Defaults to master. It is published as the destination branch of the
+	// branch rule with this source branch, e.g. main as master.
+	SourceMainlineBranch string `yaml:"source-mainline-branch,omitempty"`
+
+	SkippedSourceBranches []string `yaml:"skip-source-branches"`
+	// source commits (full or abbreviated SHAs) which are dropped when
+	// publishing, e.g. a commit which accidentally added a huge binary. Note that
+	// later commits depending on their changes must be dropped as well.
+	SkippedSourceCommits []string `yaml:"skip-source-commits,omitempty"`
+	// extended regular expressions matched against source commit messages to
+	// drop commits when publishing. They are matched line by line with grep -E,
+	// hence only the POSIX ERE syntax Go understands as well is allowed.
+	SkippedSourceCommitPatterns []string         `yaml:"skip-source-commit-patterns,omitempty"`
+	SkipGodeps                  bool             `yaml:"skip-godeps"`
+	SkipTags                    bool             `yaml:"skip-tags"`
+	Rules                       []RepositoryRule `yaml:"rules"`
+	// branch rules inherited by every repository rule, matched by name. Strings
+	// can refer to the destination repository as {{.Destination}}.
+	DefaultBranchRules []BranchRule `yaml:"default-branch-rules,omitempty"`
+	// the source directory of branches which leave it empty, e.g.
+	// "staging/src/{{.BasePackage}}/{{.Destination}}" or "libs/{{.Destination}}".
+	// Expanded by ExpandSourceDirs.
+	SourceDirTemplate string `yaml:"source-dir-template,omitempty"`
 
 	// ls-files patterns like: */BUILD *.ext pkg/foo.go Makefile
 	RecursiveDeletePatterns []string `yaml:"recursive-delete-patterns"`
 }
 
-// LoadRules loads the repository rules either from the remote HTTP location or
-// a local file path.
-func LoadRules(ruleFile string) (*RepositoryRules, error) {
-	var (
-		content []byte
-		err     error
-	)
-	if ruleUrl, err := url.ParseRequestURI(ruleFile); err == nil && len(ruleUrl.Host) > 0 {
-		content, err = readFromUrl(ruleUrl)
-	} else {
-		content, err = ioutil.ReadFile(ruleFile)
+// LoadRules loads the repository rules either from the remote HTTP location, a
+// local file path or, for crd://[<namespace>], from custom resources. Several
+// comma separated files, URLs or directories are merged by MergeRules. URLs are
+// read with the TLS settings.
+func LoadRules(ruleFile string, t TLSConfig) (*RepositoryRules, error) {
+	if _, ok := CRDNamespace(ruleFile); ok {
+		client, ns, err := CRDClient(ruleFile)
 		if err != nil {
 			return nil, err
 		}
+		return LoadCRDRules(context.Background(), client, ns)
+	}
+
+	files, err := RulesFiles(ruleFile)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([][]byte, len(files))
+	for i, f := range files {
+		if contents[i], err = readRulesFile(f, t); err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 1 {
+		return ParseRules(contents[0], files[0])
+	}
+	return MergeRules(files, contents)
+}
 
+// readRulesFile reads the rules from the remote HTTP location or the local
+// file, converted into YAML.
+func readRulesFile(ruleFile string, t TLSConfig) ([]byte, error) {
+	ruleUrl, urlErr := url.ParseRequestURI(ruleFile)
+	if urlErr != nil || len(ruleUrl.Host) == 0 {
+		return ReadFile(ruleFile)
+	}
+	content, err := readFromUrl(ruleUrl, t)
+	if err != nil {
+		return nil, err
 	}
+	format := FormatOf(ruleFile)
+	if content, err = ToYAML(content, format); err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %v", format, ruleFile, err)
+	}
+	return content, nil
+}
 
+// ParseRules parses, defaults and validates the repository rules in YAML read
+// from the given source.
+func ParseRules(content []byte, source string) (*RepositoryRules, error) {
 	var rules RepositoryRules
-	if err = yaml.Unmarshal(content, &rules); err != nil {
+	if err := yaml.Unmarshal(content, &rules); err != nil {
 		return nil, err
 	}
+	if err := rules.applyDefaults(); err != nil {
+		return nil, fmt.Errorf("invalid default branch rules in %s: %v", source, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules in %s: %v", source, err)
+	}
 	return &rules, nil
 }
 
+// publishesBranch returns true if one of the branch rules publishes to the given
+// destination branch, directly or as alias.
+func (r RepositoryRule) publishesBranch(name string) bool {
+	for _, b := range r.Branches {
+		if b.Name == name {
+			return true
+		}
+		for _, a := range b.Aliases {
+			if a == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SourceMainline returns the mainline branch of the source repo.
+func (rules *RepositoryRules) SourceMainline() string {
+	if rules.SourceMainlineBranch == "" {
+		return "master"
+	}
+	return rules.SourceMainlineBranch
+}
+
+// Mainline returns the destination branch the source mainline branch is
+// published to. It defaults to the source mainline branch name.
+func (rules *RepositoryRules) Mainline(r RepositoryRule) string {
+	for _, b := range r.Branches {
+		if b.Source.Branch == rules.SourceMainline() {
+			return b.Name
+		}
+	}
+	return rules.SourceMainline()
+}
+
+// DependencyTool returns the tool updating the dependency metadata of the
+// branch.
+func (rules *RepositoryRules) DependencyTool(b BranchRule) string {
+	switch {
+	case b.DependencyTool != "":
+		return b.DependencyTool
+	case rules.SkipGodeps:
+		return DependencyToolNone
+	default:
+		return DependencyToolGodep
+	}
+}
+
+// skippedSourceCommitSHA matches a full or abbreviated commit SHA.
+var skippedSourceCommitSHA = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// validateERE checks that p means the same to grep -E as to Go, i.e. that it is
+// POSIX ERE syntax without escape sequences like \n, which Go interprets, and
+// without newlines, which grep -f splits patterns at.
+func validateERE(p string) error {
+	if strings.Contains(p, "\n") {
+		return fmt.Errorf("patterns must be a single line")
+	}
+	for i := 0; i < len(p)-1; i++ {
+		if p[i] != '\\' {
+			continue
+		}
+		if c := p[i+1]; 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			return fmt.Errorf("escape sequence \\%c is not portable to grep -E", c)
+		}
+		i++
+	}
+	_, err := regexp.CompilePOSIX(p)
+	return err
+}
+
+// SkipsSourceBranch returns true if the source branch is in skip-source-branches.
+func (rules *RepositoryRules) SkipsSourceBranch(b string) bool {
+	for _, skipped := range rules.SkippedSourceBranches {
+		if b == skipped {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipsSourceCommit returns true if the source commit with the given message is
+// dropped because of skip-source-commits or skip-source-commit-patterns. Like
+// grep -E, the patterns are matched against every line of the message.
+func (rules *RepositoryRules) SkipsSourceCommit(sha, message string) bool {
+	for _, c := range rules.SkippedSourceCommits {
+		if strings.HasPrefix(sha, c) {
+			return true
+		}
+	}
+	for _, p := range rules.SkippedSourceCommitPatterns {
+		re, err := regexp.CompilePOSIX(p)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(message, "\n") {
+			if re.MatchString(line) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks the rules for consistency.
+func (rules *RepositoryRules) Validate() error {
+	for _, c := range rules.SkippedSourceCommits {
+		if !skippedSourceCommitSHA.MatchString(c) {
+			return fmt.Errorf("invalid skipped source commit %q: expected at least 7 hex digits", c)
+		}
+	}
+	for _, p := range rules.SkippedSourceCommitPatterns {
+		if err := validateERE(p); err != nil {
+			return fmt.Errorf("invalid skipped source commit pattern %q: %v", p, err)
+		}
+	}
+	if _, err := executeTemplate("source-dir-template", rules.SourceDirTemplate, sourceDirTemplateData{}); err != nil {
+		return fmt.Errorf("invalid source-dir-template: %v", err)
+	}
+	basePackages := map[string]string{}
+	for _, r := range rules.Rules {
+		basePackages[r.DestinationRepository] = CleanBasePackage(r.BasePackage)
+	}
+	for _, r := range rules.Rules {
+		for _, b := range r.Branches {
+			if path.IsAbs(b.GoVersionFile) || strings.HasPrefix(path.Clean(b.GoVersionFile), "..") {
+				return fmt.Errorf("%s: branch %s: go-version-file %q must be relative to the source repository", r.DestinationRepository, b.Name, b.GoVersionFile)
+			}
+			switch b.DependencyTool {
+			case "", DependencyToolGodep, DependencyToolGoMod, DependencyToolNone:
+			default:
+				return fmt.Errorf("%s: branch %s: invalid dependency-tool %q", r.DestinationRepository, b.Name, b.DependencyTool)
+			}
+			for _, d := range b.Dependencies {
+				if err := d.Validate(); err != nil {
+					return fmt.Errorf("%s: branch %s: %v", r.DestinationRepository, b.Name, err)
+				}
+				if bp, found := basePackages[d.Repository]; found && bp != CleanBasePackage(r.BasePackage) {
+					return fmt.Errorf("%s: dependency %s has a different base-package", r.DestinationRepository, d.Repository)
+				}
+			}
+		}
+		if _, err := executeTemplate("source-dir-template", r.SourceDirTemplate, sourceDirTemplateData{}); err != nil {
+			return fmt.Errorf("%s: invalid source-dir-template: %v", r.DestinationRepository, err)
+		}
+		dstBranches := map[string]string{}
+		for _, b := range r.Branches {
+			for _, name := range append([]string{b.Name}, b.Aliases...) {
+				if src, found := dstBranches[name]; found {
+					return fmt.Errorf("%s: source branches %s and %s are both published to branch %s", r.DestinationRepository, src, b.Source.Branch, name)
+				}
+				dstBranches[name] = b.Source.Branch
+			}
+		}
+		switch r.EmptyCommits {
+		case "", EmptyCommitsDrop, EmptyCommitsKeep, EmptyCommitsKeepWithMarker:
+		default:
+			return fmt.Errorf("%s: invalid empty-commits policy %q", r.DestinationRepository, r.EmptyCommits)
+		}
+		for _, ir := range r.ImportRewrites {
+			if ir.From == "" || ir.To == "" || strings.ContainsAny(ir.From+ir.To, " \t\n=") {
+				return fmt.Errorf("%s: invalid import rewrite from %q to %q", r.DestinationRepository, ir.From, ir.To)
+			}
+		}
+		switch r.ImportRewriteFormat {
+		case "", "gofmt", "goimports":
+		default:
+			return fmt.Errorf("%s: invalid import-rewrite-format %q, must be gofmt or goimports", r.DestinationRepository, r.ImportRewriteFormat)
+		}
+		for i, f := range r.Filters {
+			set := 0
+			for _, s := range []string{f.Name, f.Command} {
+				if s != "" {
+					set++
+				}
+			}
+			if set != 1 {
+				return fmt.Errorf("%s: filter %d must have exactly one of name and command", r.DestinationRepository, i+1)
+			}
+		}
+		if err := r.Submodules.Validate(); err != nil {
+			return fmt.Errorf("%s: submodules: %v", r.DestinationRepository, err)
+		}
+		switch r.Symlinks {
+		case "", SymlinksError, SymlinksDrop, SymlinksMaterialize:
+		default:
+			return fmt.Errorf("%s: invalid symlink policy %q, must be %s, %s or %s", r.DestinationRepository, r.Symlinks, SymlinksError, SymlinksDrop, SymlinksMaterialize)
+		}
+		switch r.Bootstrap {
+		case "", BootstrapHistory, BootstrapSquash:
+		default:
+			return fmt.Errorf("%s: invalid bootstrap mode %q", r.DestinationRepository, r.Bootstrap)
+		}
+		switch r.MergeCommits {
+		case "", MergeCommitsPreserve, MergeCommitsLinearize:
+		default:
+			return fmt.Errorf("%s: invalid merge-commits policy %q", r.DestinationRepository, r.MergeCommits)
+		}
+		switch r.Commits {
+		case "", CommitsMirror, CommitsSquash:
+		default:
+			return fmt.Errorf("%s: invalid commits mode %q, must be %s or %s", r.DestinationRepository, r.Commits, CommitsMirror, CommitsSquash)
+		}
+		if r.DefaultBranch != "" && !r.publishesBranch(r.DefaultBranch) {
+			return fmt.Errorf("%s: default branch %q is not published", r.DestinationRepository, r.DefaultBranch)
+		}
+		if r.License.CopyFrom != "" && r.License.Content != "" {
+			return fmt.Errorf("%s: license copy-from and content are mutually exclusive", r.DestinationRepository)
+		}
+		if r.License.Content != "" {
+			if _, err := template.New("license").Parse(r.License.Content); err != nil {
+				return fmt.Errorf("%s: invalid license content template: %v", r.DestinationRepository, err)
+			}
+		}
+		for i, script := range r.VerifyGenerated {
+			if strings.TrimSpace(script) == "" {
+				return fmt.Errorf("%s: empty verify-generated command %d", r.DestinationRepository, i+1)
+			}
+		}
+		if r.Batching.Interval < 0 {
+			return fmt.Errorf("%s: negative batching interval %v", r.DestinationRepository, r.Batching.Interval)
+		}
+		if r.Batching.MaxCommits < 0 {
+			return fmt.Errorf("%s: negative batching max-commits %d", r.DestinationRepository, r.Batching.MaxCommits)
+		}
+		if err := r.Metadata.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", r.DestinationRepository, err)
+		}
+		if err := r.Tags.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", r.DestinationRepository, err)
+		}
+		if err := r.DependencyLicenses.Validate(); err != nil {
+			return fmt.Errorf("%s: dependency-licenses: %v", r.DestinationRepository, err)
+		}
+		if err := r.SBOM.Validate(); err != nil {
+			return fmt.Errorf("%s: sbom: %v", r.DestinationRepository, err)
+		}
+		if err := r.BranchProtection.Validate(); err != nil {
+			return fmt.Errorf("%s: branch-protection: %v", r.DestinationRepository, err)
+		}
+		for _, b := range r.BranchProtection.Branches {
+			if !r.publishesBranch(b) {
+				return fmt.Errorf("%s: protected branch %q is not published", r.DestinationRepository, b)
+			}
+		}
+		if err := r.Sunset.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", r.DestinationRepository, err)
+		}
+		for _, b := range r.Branches {
+			s := r.SunsetOf(b)
+			if err := s.Validate(); err != nil {
+				return fmt.Errorf("%s: branch %s: %v", r.DestinationRepository, b.Name, err)
+			}
+			if s.FreezeNotice && s.StopPublishingAfter == "" {
+				return fmt.Errorf("%s: branch %s: freeze-notice requires stop-publishing-after", r.DestinationRepository, b.Name)
+			}
+		}
+		if err := r.GitIdentity.validateSyntax(); err != nil {
+			return fmt.Errorf("%s: %v", r.DestinationRepository, err)
+		}
+		for _, h := range append(append([]Hook(nil), r.Hooks.PrePush...), r.Hooks.PostPush...) {
+			if h.Name == "" {
+				return fmt.Errorf("%s: hook without name", r.DestinationRepository)
+			}
+			if h.Script == "" && h.Image == "" {
+				return fmt.Errorf("%s: hook %q needs a script or an image", r.DestinationRepository, h.Name)
+			}
+		}
+		names := map[string]bool{}
+		for _, t := range r.PushTargets {
+			switch {
+			case t.Name == "":
+				return fmt.Errorf("%s: push target without name", r.DestinationRepository)
+			case t.Name == "origin" || t.Name == "upstream" || t.Name == CanaryRemote:
+				return fmt.Errorf("%s: push target name %q is reserved", r.DestinationRepository, t.Name)
+			case names[t.Name]:
+				return fmt.Errorf("%s: duplicate push target %q", r.DestinationRepository, t.Name)
+			}
+			names[t.Name] = true
+			if t.BitbucketServer != nil {
+				if err := t.BitbucketServer.Validate(); err != nil {
+					return fmt.Errorf("%s: push target %q: bitbucket-server: %v", r.DestinationRepository, t.Name, err)
+				}
+				if t.BitbucketServer.SSHPort == 0 && t.Username == "" {
+					return fmt.Errorf("%s: push target %q: bitbucket-server needs a username to push via https", r.DestinationRepository, t.Name)
+				}
+				if t.URL == "" {
+					continue
+				}
+			}
+			if err := ValidateGitURL(t.URL); err != nil {
+				return fmt.Errorf("%s: invalid URL of push target %q: %v", r.DestinationRepository, t.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
 // readFromUrl reads the rule file from provided URL.
-func readFromUrl(u *url.URL) ([]byte, error) {
-	client := &http.Client{Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}}
+func readFromUrl(u *url.URL, t TLSConfig) ([]byte, error) {
+	transport, err := t.Transport()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: transport}
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,684 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestMainline(t *testing.T) {
+	var rules RepositoryRules
+	err := yaml.Unmarshal([]byte(`
+source-mainline-branch: main
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: main
+  - name: v1.28
+    source:
+      branch: release-1.28
+- destination: api
+  branches:
+  - name: release-1.28
+    source:
+      branch: release-1.28
+`), &rules)
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if got := rules.Mainline(rules.Rules[0]); got != "master" {
+		t.Errorf("expected mainline master for client-go, got %q", got)
+	}
+	if got := rules.Mainline(rules.Rules[1]); got != "main" {
+		t.Errorf("expected mainline main for api, got %q", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr bool
+	}{
+		{"two sources to one branch", `
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: master
+  - name: master
+    source:
+      branch: main
+`, true},
+		{"go-version-file outside of the source repo", `
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    go-version-file: ../.go-version
+    source:
+      branch: master
+`, true},
+		{"invalid dependency-tool", `
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    dependency-tool: dep
+    source:
+      branch: master
+`, true},
+		{"alias clashing with branch", `
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    aliases: [main]
+    source:
+      branch: master
+  - name: main
+    source:
+      branch: main
+`, true},
+		{"default branch not published", `
+rules:
+- destination: client-go
+  default-branch: main
+  branches:
+  - name: master
+    source:
+      branch: master
+`, true},
+		{"default branch alias", `
+rules:
+- destination: client-go
+  default-branch: main
+  branches:
+  - name: master
+    aliases: [main]
+    source:
+      branch: master
+`, false},
+		{"reserved push target", `
+rules:
+- destination: client-go
+  push-targets:
+  - name: origin
+    url: https://gitlab.example.com/client-go.git
+`, true},
+		{"bitbucket server push target", `
+rules:
+- destination: client-go
+  push-targets:
+  - name: bitbucket
+    username: publisher
+    bitbucket-server:
+      url: https://bitbucket.example.com
+      project: K8S
+`, false},
+		{"bitbucket server push target without username", `
+rules:
+- destination: client-go
+  push-targets:
+  - name: bitbucket
+    bitbucket-server:
+      url: https://bitbucket.example.com
+      project: K8S
+`, true},
+		{"bitbucket server push target with pull requests", `
+rules:
+- destination: client-go
+  push-targets:
+  - name: bitbucket
+    username: publisher
+    bitbucket-server:
+      url: https://bitbucket.example.com
+      project: K8S
+      pull-requests: true
+      pull-request-branch-prefix: bot/
+`, false},
+		{"bitbucket server push target with branch prefix without pull requests", `
+rules:
+- destination: client-go
+  push-targets:
+  - name: bitbucket
+    username: publisher
+    bitbucket-server:
+      url: https://bitbucket.example.com
+      project: K8S
+      pull-request-branch-prefix: bot/
+`, true},
+		{"bitbucket server push target without project", `
+rules:
+- destination: client-go
+  push-targets:
+  - name: bitbucket
+    bitbucket-server:
+      url: https://bitbucket.example.com
+      ssh-port: 7999
+`, true},
+		{"dependency licenses", `
+rules:
+- destination: client-go
+  dependency-licenses:
+    allowed: [Apache-2.0, MIT, BSD-3-Clause]
+    exceptions: [github.com/example/reviewed]
+    warn-only: true
+`, false},
+		{"dependency license exceptions without allowed licenses", `
+rules:
+- destination: client-go
+  dependency-licenses:
+    exceptions: [github.com/example/reviewed]
+`, true},
+		{"sbom", `
+rules:
+- destination: client-go
+  sbom:
+    format: cyclonedx
+    commit: true
+    releases: true
+`, false},
+		{"invalid sbom format", `
+rules:
+- destination: client-go
+  sbom:
+    format: swid
+    commit: true
+`, true},
+		{"skipped source commits", `
+skip-source-commits: [0123456789abcdef]
+skip-source-commit-patterns: ["^Add .*binary"]
+`, false},
+		{"abbreviated skipped source commit too short", `
+skip-source-commits: [01234]
+`, true},
+		{"commit policies", `
+rules:
+- destination: client-go
+  empty-commits: keep-with-marker
+  merge-commits: linearize
+  bootstrap: squash
+`, false},
+		{"dependency with different base package", `
+rules:
+- destination: apimachinery
+  base-package: example.com/project
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: master
+    dependencies:
+    - repository: apimachinery
+      branch: master
+`, true},
+		{"dependency with the same base package spelled differently", `
+rules:
+- destination: apimachinery
+  base-package: example.com/project/
+- destination: client-go
+  base-package: ./example.com/project
+  branches:
+  - name: master
+    source:
+      branch: master
+    dependencies:
+    - repository: apimachinery
+      branch: master
+`, false},
+		{"pinned dependencies", `
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: master
+    dependencies:
+    - repository: apimachinery
+      branch: master
+    - repository: api
+      tag: v0.1.0
+    - repository: utils
+      commit: 0123456789abcdef
+`, false},
+		{"dependency with branch and tag", `
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: master
+    dependencies:
+    - repository: api
+      branch: master
+      tag: v0.1.0
+`, true},
+		{"dependency without revision", `
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: master
+    dependencies:
+    - repository: api
+`, true},
+		{"dependency with invalid commit", `
+rules:
+- destination: client-go
+  branches:
+  - name: master
+    source:
+      branch: master
+    dependencies:
+    - repository: api
+      commit: v0.1.0
+`, true},
+		{"invalid bootstrap mode", `
+rules:
+- destination: client-go
+  bootstrap: seed
+`, true},
+		{"invalid empty commit policy", `
+rules:
+- destination: client-go
+  empty-commits: squash
+`, true},
+		{"hook without script and image", `
+rules:
+- destination: client-go
+  hooks:
+    pre-push:
+    - name: codegen
+`, true},
+		{"invalid skipped source commit pattern", `
+skip-source-commit-patterns: ["(unclosed"]
+`, true},
+		{"Perl syntax in skipped source commit pattern", `
+skip-source-commit-patterns: ["(?i)^add binaries"]
+`, true},
+		{"escape sequence in skipped source commit pattern", `
+skip-source-commit-patterns: ["binary\\tadded"]
+`, true},
+		{"POSIX class in skipped source commit pattern", `
+skip-source-commit-patterns: ["^Add [[:digit:]]+ binar(y|ies)\\."]
+`, false},
+		{"branch stopping before the rule is deprecated", `
+rules:
+- destination: client-go
+  deprecated-after: 2019-06-30
+  branches:
+  - name: release-1.9
+    stop-publishing-after: 2019-03-31
+    source:
+      branch: release-1.9
+`, true},
+		{"freeze notice without stop date", `
+rules:
+- destination: client-go
+  freeze-notice: true
+  branches:
+  - name: master
+    source:
+      branch: master
+`, true},
+		{"invalid topic", `
+rules:
+- destination: client-go
+  metadata:
+    topics: [Kubernetes]
+`, true},
+		{"sunset", `
+rules:
+- destination: client-go
+  deprecated-after: 2019-06-30
+  freeze-notice: true
+  branches:
+  - name: release-1.9
+    stop-publishing-after: 2019-12-31
+    source:
+      branch: release-1.9
+`, false},
+		{"tag policy", `
+rules:
+- destination: client-go
+  tags:
+    classes: [rc, final]
+    semver-major: 0
+`, false},
+		{"unknown tag class", `
+rules:
+- destination: client-go
+  tags:
+    classes: [stable]
+`, true},
+		{"negative batching max-commits", `
+rules:
+- destination: client-go
+  batching:
+    max-commits: -1
+`, true},
+		{"invalid tag pattern", `
+rules:
+- destination: client-go
+  tags:
+    exclude: ['test-(']
+`, true},
+		{"tag message", `
+rules:
+- destination: client-go
+  tags:
+    type: annotated
+    tagger: source
+    message: 'Release {{.SourceTag}}, see {{.ReleaseNotesURL}}'
+`, false},
+		{"unknown tag message field", `
+rules:
+- destination: client-go
+  tags:
+    message: '{{.Version}}'
+`, true},
+		{"invalid tag type", `
+rules:
+- destination: client-go
+  tags:
+    type: signed
+`, true},
+		{"submodules", `
+rules:
+- destination: client-go
+  submodules:
+    mode: materialize
+    urls:
+      https://git.internal/: https://github.com/example/
+`, false},
+		{"invalid submodule mode", `
+rules:
+- destination: client-go
+  submodules:
+    mode: flatten
+`, true},
+		{"invalid import rewrite format", `
+rules:
+- destination: client-go
+  import-rewrite-format: clang-format
+`, true},
+		{"invalid symlink policy", `
+rules:
+- destination: client-go
+  symlinks: follow
+`, true},
+		{"empty verify-generated command", `
+rules:
+- destination: client-go
+  verify-generated: ["go generate ./...", ""]
+`, true},
+		{"negative semver major", `
+rules:
+- destination: client-go
+  tags:
+    semver-major: -1
+`, true},
+		{"branch protection", `
+rules:
+- destination: client-go
+  branch-protection:
+    branches: [master]
+    restrict-pushes: true
+    push-teams: [release-managers]
+  branches:
+  - name: master
+    source:
+      branch: master
+`, false},
+		{"push users without restricted pushes", `
+rules:
+- destination: client-go
+  branch-protection:
+    push-users: [alice]
+`, true},
+		{"protected branch not published", `
+rules:
+- destination: client-go
+  branch-protection:
+    branches: [main]
+  branches:
+  - name: master
+    source:
+      branch: master
+`, true},
+		{"filters", `
+rules:
+- destination: client-go
+  filters:
+  - name: strip-build-tags
+  - command: rm -f OWNERS
+`, false},
+		{"filter with command and name", `
+rules:
+- destination: client-go
+  filters:
+  - command: rm -f OWNERS
+    name: strip-build-tags
+`, true},
+	}
+	for _, tt := range tests {
+		var rules RepositoryRules
+		if err := yaml.Unmarshal([]byte(tt.rules), &rules); err != nil {
+			t.Fatalf("%s: failed to parse rules: %v", tt.name, err)
+		}
+		if err := rules.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	var rules RepositoryRules
+	err := yaml.Unmarshal([]byte(`
+default-branch-rules:
+- name: master
+  source:
+    branch: master
+    dir: staging/src/k8s.io/{{.Destination}}
+  dependencies:
+  - repository: apimachinery
+    branch: master
+- name: release-1.11
+  go: 1.10.2
+  source:
+    branch: release-1.11
+    dir: staging/src/k8s.io/{{.Destination}}
+rules:
+- destination: api
+- destination: client-go
+  branches:
+  - name: master
+    go: 1.11.1
+  - name: release-1.11
+    dependencies: []
+    source:
+      dir: staging/src/k8s.io/client-go/v2
+`), &rules)
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+	if err := rules.applyDefaults(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	api := rules.Rules[0].Branches
+	if len(api) != 2 {
+		t.Fatalf("expected api to inherit 2 branches, got %d", len(api))
+	}
+	if api[0].Source.Dir != "staging/src/k8s.io/api" || api[1].GoVersion != "1.10.2" {
+		t.Errorf("unexpected api branches: %+v", api)
+	}
+	if len(api[0].Dependencies) != 1 || api[0].Dependencies[0].Repository != "apimachinery" {
+		t.Errorf("expected api master to depend on apimachinery, got %v", api[0].Dependencies)
+	}
+
+	clientGo := rules.Rules[1].Branches
+	if got := clientGo[0]; got.GoVersion != "1.11.1" || got.Source.Branch != "master" || got.Source.Dir != "staging/src/k8s.io/client-go" || len(got.Dependencies) != 1 {
+		t.Errorf("unexpected client-go master: %+v", got)
+	}
+	if got := clientGo[1]; got.GoVersion != "1.10.2" || got.Source.Dir != "staging/src/k8s.io/client-go/v2" || len(got.Dependencies) != 0 {
+		t.Errorf("unexpected client-go release-1.11: %+v", got)
+	}
+
+	rules = RepositoryRules{
+		DefaultBranchRules: []BranchRule{{Name: "master", Source: Source{Dir: "{{.Repo}}"}}},
+		Rules:              []RepositoryRule{{DestinationRepository: "api"}},
+	}
+	if err := rules.applyDefaults(); err == nil {
+		t.Errorf("expected error for unknown template field")
+	}
+}
+
+func TestExpandSourceDirs(t *testing.T) {
+	var rules RepositoryRules
+	err := yaml.Unmarshal([]byte(`
+source-dir-template: staging/src/{{.BasePackage}}/{{.Destination}}
+rules:
+- destination: api
+  branches:
+  - name: master
+    source:
+      branch: master
+  - name: release-1.0
+    source:
+      branch: release-1.0
+      dir: api
+- destination: widgets
+  source-dir-template: libs/{{.Destination}}
+  branches:
+  - name: master
+    source:
+      branch: master
+- destination: gadgets
+  base-package: example.org/gadgets/
+  branches:
+  - name: master
+    source:
+      branch: master
+`), &rules)
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if err := rules.ExpandSourceDirs("example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tt := range []struct {
+		rule, branch int
+		want         string
+	}{
+		{0, 0, "staging/src/example.com/api"},
+		{0, 1, "api"},
+		{1, 0, "libs/widgets"},
+		{2, 0, "staging/src/example.org/gadgets/gadgets"},
+	} {
+		if got := rules.Rules[tt.rule].Branches[tt.branch].Source.Dir; got != tt.want {
+			t.Errorf("rule %d, branch %d: expected %q, got %q", tt.rule, tt.branch, tt.want, got)
+		}
+	}
+
+	rules = RepositoryRules{SourceDirTemplate: "modules/{{.Repo}}"}
+	if err := rules.Validate(); err == nil {
+		t.Errorf("expected error for unknown template field")
+	}
+}
+
+func TestBitbucketServerCloneURL(t *testing.T) {
+	tests := []struct {
+		b    BitbucketServer
+		want string
+	}{
+		{BitbucketServer{URL: "https://bitbucket.example.com/", Project: "K8S"}, "https://bitbucket.example.com/scm/k8s/client-go.git"},
+		{BitbucketServer{URL: "https://example.com/bitbucket", Project: "~jdoe", Repository: "Client Go"}, "https://example.com/bitbucket/scm/~jdoe/client-go.git"},
+		{BitbucketServer{URL: "https://bitbucket.example.com:8443", Project: "K8S", SSHPort: 7999}, "ssh://git@bitbucket.example.com:7999/k8s/client-go.git"},
+	}
+	for _, tt := range tests {
+		if got := tt.b.CloneURL("client-go"); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestTagPolicyPublishes(t *testing.T) {
+	p := TagPolicy{Include: []string{`^v1\.2\d\.`, `^v2\.`}, Exclude: []string{`^test-`, `-alpha\.`}}
+	for name, want := range map[string]bool{"v1.28.0": true, "v2.0.0": true, "v1.29.0-alpha.1": false, "v1.30.0": false, "test-v1.28.0": false} {
+		if got := p.Publishes(name); got != want {
+			t.Errorf("Publishes(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if !(TagPolicy{}).Publishes("test-1") {
+		t.Errorf("expected the empty policy to publish all tags")
+	}
+}
+
+func TestSkipsSourceCommit(t *testing.T) {
+	rules := RepositoryRules{
+		SkippedSourceCommits:        []string{"0123456"},
+		SkippedSourceCommitPatterns: []string{"^Add [[:alpha:]]+ binary$"},
+	}
+	for _, tt := range []struct {
+		sha, message string
+		want         bool
+	}{
+		{"0123456789abcdef", "Fix typo", true},
+		{"fedcba9876543210", "Fix typo", false},
+		{"fedcba9876543210", "Add test binary", true},
+		{"fedcba9876543210", "Fix typo\n\nAdd test binary\n", true},
+		{"fedcba9876543210", "Add test binary files", false},
+	} {
+		if got := rules.SkipsSourceCommit(tt.sha, tt.message); got != tt.want {
+			t.Errorf("SkipsSourceCommit(%q, %q) = %v, want %v", tt.sha, tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestDependencyTool(t *testing.T) {
+	tests := []struct {
+		name       string
+		skipGodeps bool
+		tool       string
+		want       string
+	}{
+		{"default", false, "", DependencyToolGodep},
+		{"skip-godeps", true, "", DependencyToolNone},
+		{"branch", false, DependencyToolGoMod, DependencyToolGoMod},
+		{"branch overrides skip-godeps", true, DependencyToolGodep, DependencyToolGodep},
+	}
+	for _, tt := range tests {
+		rules := RepositoryRules{SkipGodeps: tt.skipGodeps}
+		if got := rules.DependencyTool(BranchRule{DependencyTool: tt.tool}); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SBOM formats.
+const (
+	SBOMFormatSPDX      = "spdx"
+	SBOMFormatCycloneDX = "cyclonedx"
+)
+
+// SBOM configures software bills of materials of the published branches and
+// tags, listing the dependencies with versions, hashes and licenses.
+type SBOM struct {
+	// Format is spdx (SPDX 2.3 JSON, the default) or cyclonedx (CycloneDX 1.4
+	// JSON).
+	Format string `yaml:"format,omitempty"`
+	// Commit writes the SBOM of each branch into File and commits it on top of
+	// the published branch if it changed.
+	Commit bool `yaml:"commit,omitempty"`
+	// File is the file name of the SBOM in the branch and of the release asset.
+	// Defaults to sbom.spdx.json or sbom.cdx.json.
+	File string `yaml:"file,omitempty"`
+	// Releases attaches the SBOM of each new tag to its GitHub release, which
+	// is created if missing.
+	Releases bool `yaml:"releases,omitempty"`
+}
+
+// Enabled returns true if SBOMs are generated.
+func (s SBOM) Enabled() bool {
+	return s.Commit || s.Releases
+}
+
+// FileName returns the file name of the SBOM.
+func (s SBOM) FileName() string {
+	switch {
+	case s.File != "":
+		return s.File
+	case s.Format == SBOMFormatCycloneDX:
+		return "sbom.cdx.json"
+	}
+	return "sbom.spdx.json"
+}
+
+// Validate checks the format and the file name.
+func (s SBOM) Validate() error {
+	switch s.Format {
+	case "", SBOMFormatSPDX, SBOMFormatCycloneDX:
+	default:
+		return fmt.Errorf("invalid format %q", s.Format)
+	}
+	if strings.ContainsAny(s.File, "/\\") {
+		return fmt.Errorf("invalid file %q, must be a file name", s.File)
+	}
+	return nil
+}
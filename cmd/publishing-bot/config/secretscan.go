@@ -0,0 +1,62 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// SecretScan configures the scan of the commits about to be pushed for likely
+// credentials and private keys. A branch with findings is not pushed.
+type SecretScan struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Patterns are additional regular expressions keyed by rule name.
+	Patterns map[string]string `yaml:"patterns,omitempty"`
+	// EntropyThreshold is the Shannon entropy in bits per character of strings
+	// assigned to secret-like names which is reported. Defaults to 4.0, negative
+	// disables the entropy check.
+	EntropyThreshold float64 `yaml:"entropy-threshold,omitempty"`
+	// AllowPaths are regular expressions of file paths which are not scanned,
+	// e.g. test fixtures with dummy keys.
+	AllowPaths []string `yaml:"allow-paths,omitempty"`
+	// Command is an additional bash script which gets the diff on stdin. A
+	// non-zero exit code blocks the push, with the output as findings.
+	Command string `yaml:"command,omitempty"`
+	// Webhook is the URL findings are posted to as JSON.
+	Webhook string `yaml:"webhook,omitempty"`
+}
+
+// Validate checks the regular expressions and the webhook URL.
+func (s SecretScan) Validate() error {
+	for name, p := range s.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", name, err)
+		}
+	}
+	for _, p := range s.AllowPaths {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid allow-paths entry %q: %v", p, err)
+		}
+	}
+	if s.Webhook != "" {
+		if err := validateURL(s.Webhook); err != nil {
+			return fmt.Errorf("invalid webhook %q: %v", s.Webhook, err)
+		}
+	}
+	return nil
+}
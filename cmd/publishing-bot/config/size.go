@@ -0,0 +1,81 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes, written in YAML as a plain number or with a
+// binary unit like 512Ki, 10Mi or 1Gi.
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"Gi", 1 << 30},
+	{"Mi", 1 << 20},
+	{"Ki", 1 << 10},
+}
+
+// ParseByteSize parses a number of bytes with an optional binary unit.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes with an optional unit Ki, Mi or Gi", s)
+	}
+	return ByteSize(n * factor), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	return b.String(), nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range byteSizeUnits {
+		if b > 0 && int64(b)%u.factor == 0 {
+			return fmt.Sprintf("%d%s", int64(b)/u.factor, u.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
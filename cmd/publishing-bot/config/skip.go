@@ -0,0 +1,52 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+// SkipPhases skips phases of a run, e.g. for operators debugging a later
+// phase on a prepared workspace. Branches constructed this way may be broken
+// and should not be pushed, i.e. combine it with dry-run.
+type SkipPhases struct {
+	// Fetch uses the source repo as it is instead of fetching it.
+	Fetch bool `yaml:"fetch,omitempty"`
+	// DepRestore leaves the dependency metadata of the constructed branches,
+	// e.g. Godeps/Godeps.json or go.mod, untouched.
+	DepRestore bool `yaml:"dep-restore,omitempty"`
+	// TagPush pushes the destination branches without their tags.
+	TagPush bool `yaml:"tag-push,omitempty"`
+	// BuildCheck skips the smoke tests and the verification of the generated
+	// files.
+	BuildCheck bool `yaml:"build-check,omitempty"`
+}
+
+// Phases returns the names of the skipped phases.
+func (s SkipPhases) Phases() []string {
+	var phases []string
+	for _, p := range []struct {
+		name    string
+		skipped bool
+	}{
+		{"fetch", s.Fetch},
+		{"dep-restore", s.DepRestore},
+		{"tag-push", s.TagPush},
+		{"build-check", s.BuildCheck},
+	} {
+		if p.skipped {
+			phases = append(phases, p.name)
+		}
+	}
+	return phases
+}
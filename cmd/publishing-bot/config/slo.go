@@ -0,0 +1,49 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// LatencySLO is the objective for how long source commits may wait until they
+// are published.
+type LatencySLO struct {
+	// Target is the maximal age of the newest unpublished source commit of a
+	// destination branch, e.g. 4h. Zero disables alerting.
+	Target time.Duration `yaml:"target,omitempty"`
+	// Webhook is the URL alerts are posted to as JSON when the target is
+	// exceeded, and when the branch is back within the target.
+	Webhook string `yaml:"webhook,omitempty"`
+}
+
+// Validate checks the target and the webhook URL.
+func (s LatencySLO) Validate() error {
+	if s.Target < 0 {
+		return fmt.Errorf("negative target %v", s.Target)
+	}
+	if s.Webhook != "" {
+		if s.Target == 0 {
+			return fmt.Errorf("webhook requires a target")
+		}
+		if err := validateURL(s.Webhook); err != nil {
+			return fmt.Errorf("invalid webhook %q: %v", s.Webhook, err)
+		}
+	}
+	return nil
+}
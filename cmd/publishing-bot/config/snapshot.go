@@ -0,0 +1,62 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+// DefaultSnapshotMaxSize is the default maximal size of a workspace snapshot.
+const DefaultSnapshotMaxSize = ByteSize(1 << 30)
+
+// DefaultSnapshotKeep is the default number of workspace snapshots kept.
+const DefaultSnapshotKeep = 10
+
+// Snapshots configures the snapshots of the workspace taken when a run fails,
+// such that failures can be reproduced offline. A snapshot is a .tar.gz with
+// the destination repo the run failed in, including its .git directory and
+// reflogs, the logs of the run, the error and the conflict report if any.
+type Snapshots struct {
+	// Location is where the snapshots are stored: a directory,
+	// gs://<bucket>/<prefix> or s3://<bucket>/<prefix>. Empty disables them.
+	Location string `yaml:"location,omitempty"`
+	// MaxSize is the maximal size of the files of a snapshot before
+	// compression. The destination repo is left out of larger snapshots.
+	// Defaults to 1Gi.
+	MaxSize ByteSize `yaml:"max-size,omitempty"`
+	// Keep is the number of snapshots kept in the location. Older ones are
+	// deleted after storing a new one, also those of other orgs stored in the
+	// same location. Defaults to 10.
+	Keep int `yaml:"keep,omitempty"`
+}
+
+// Enabled returns whether snapshots are taken.
+func (s Snapshots) Enabled() bool {
+	return s.Location != ""
+}
+
+// Limit returns the maximal size of a snapshot, defaulted.
+func (s Snapshots) Limit() ByteSize {
+	if s.MaxSize == 0 {
+		return DefaultSnapshotMaxSize
+	}
+	return s.MaxSize
+}
+
+// KeepCount returns the number of snapshots kept, defaulted.
+func (s Snapshots) KeepCount() int {
+	if s.Keep == 0 {
+		return DefaultSnapshotKeep
+	}
+	return s.Keep
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// scpLikeURL matches git's scp-like syntax, e.g. git@example.com:org/repo.git.
+var scpLikeURL = regexp.MustCompile(`^(?:[^@/]+@)?[^:/]+:[^/].*$`)
+
+// Modes of handling a force-pushed source branch.
+const (
+	SourceForcePushHalt    = "halt"
+	SourceForcePushRebuild = "rebuild"
+)
+
+// ValidateSourceForcePush checks the mode of handling force-pushed source
+// branches. Empty means halt.
+func ValidateSourceForcePush(mode string) error {
+	switch mode {
+	case "", SourceForcePushHalt, SourceForcePushRebuild:
+		return nil
+	}
+	return fmt.Errorf("invalid source-force-push %q, must be %s or %s", mode, SourceForcePushHalt, SourceForcePushRebuild)
+}
+
+// SourceRepoURL returns the URL the source repository is cloned from. It is
+// SourceURL if set, otherwise it is constructed from GithubHost, SourceOrg and
+// SourceRepo.
+func (c *Config) SourceRepoURL() string {
+	if c.SourceURL != "" {
+		return c.SourceURL
+	}
+	return fmt.Sprintf("https://%s/%s/%s", c.GithubHost, c.SourceOrg, c.SourceRepo)
+}
+
+// ValidateGitURL checks that u is a git URL understood by git clone: a
+// http(s)://, ssh://, git:// or file:// URL, a scp-like address or an absolute
+// local path.
+func ValidateGitURL(u string) error {
+	if strings.HasPrefix(u, "/") || scpLikeURL.MatchString(u) && !strings.Contains(u, "://") {
+		return nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	switch parsed.Scheme {
+	case "http", "https", "ssh", "git":
+		if parsed.Host == "" {
+			return fmt.Errorf("host missing in %q", u)
+		}
+	case "file":
+		if parsed.Path == "" {
+			return fmt.Errorf("path missing in %q", u)
+		}
+	default:
+		return fmt.Errorf("unsupported scheme %q in %q", parsed.Scheme, u)
+	}
+	return nil
+}
+
+// IsSourceRepoURL returns true if the git URL points to the source repo, i.e.
+// its path ends in the path of SourceRepoURL, ignoring case and .git.
+func (c *Config) IsSourceRepoURL(u string) bool {
+	want := strings.ToLower(repoPathFromURL(c.SourceRepoURL()))
+	got := strings.ToLower(repoPathFromURL(u))
+	return want != "" && (got == want || strings.HasSuffix(got, "/"+want))
+}
+
+// repoPathFromURL returns the path of a git URL without the host, the leading
+// slash and the .git suffix, e.g. kubernetes/kubernetes for
+// git@github.com:kubernetes/kubernetes.git.
+func repoPathFromURL(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+		if i := strings.Index(u, "/"); i >= 0 {
+			u = u[i:]
+		} else {
+			u = ""
+		}
+	} else if !strings.HasPrefix(u, "/") {
+		if i := strings.Index(u, ":"); i >= 0 {
+			u = u[i+1:]
+		}
+	}
+	return strings.TrimSuffix(strings.Trim(u, "/"), ".git")
+}
+
+// RepoNameFromURL returns the last path element of a git URL without the .git
+// suffix, e.g. "kubernetes" for ssh://gerrit.example.com:29418/kubernetes.git.
+func RepoNameFromURL(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	} else if !strings.HasPrefix(u, "/") {
+		if i := strings.Index(u, ":"); i >= 0 {
+			u = u[i+1:]
+		}
+	}
+	return strings.TrimSuffix(path.Base(strings.TrimSuffix(u, "/")), ".git")
+}
@@ -0,0 +1,28 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+// Summary configures the machine-readable summary written after each run,
+// e.g. into the artifacts directory of a Prow job to be shown by Spyglass.
+type Summary struct {
+	// Dir is the directory summary.json is written into. Empty disables the
+	// summary.
+	Dir string `yaml:"dir,omitempty"`
+	// JUnit additionally writes junit_publishing-bot.xml with a test case per
+	// destination repo.
+	JUnit bool `yaml:"junit,omitempty"`
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SunsetDateFormat is the format of deprecated-after and stop-publishing-after.
+const SunsetDateFormat = "2006-01-02"
+
+// Sunset describes the end of life of a destination repository or branch.
+type Sunset struct {
+	// a date like 2019-06-30 after which the bot warns that publishing will stop.
+	DeprecatedAfter string `yaml:"deprecated-after,omitempty"`
+	// a date like 2019-12-31 after which the bot does not publish anymore.
+	StopPublishingAfter string `yaml:"stop-publishing-after,omitempty"`
+	// FreezeNotice pushes a final commit to the destination branch, announcing
+	// in the README.md that it is not updated anymore.
+	FreezeNotice bool `yaml:"freeze-notice,omitempty"`
+}
+
+// SunsetOf returns the sunset of the branch, falling back to that of the
+// repository rule for fields the branch does not set.
+func (r RepositoryRule) SunsetOf(b BranchRule) Sunset {
+	s := b.Sunset
+	if s.DeprecatedAfter == "" {
+		s.DeprecatedAfter = r.Sunset.DeprecatedAfter
+	}
+	if s.StopPublishingAfter == "" {
+		s.StopPublishingAfter = r.Sunset.StopPublishingAfter
+	}
+	s.FreezeNotice = s.FreezeNotice || r.Sunset.FreezeNotice
+	return s
+}
+
+// Deprecated returns the end of the deprecated-after day in UTC, or the zero time.
+func (s Sunset) Deprecated() time.Time {
+	t, _ := parseSunsetDate(s.DeprecatedAfter)
+	return t
+}
+
+// Stop returns the end of the stop-publishing-after day in UTC, or the zero time.
+func (s Sunset) Stop() time.Time {
+	t, _ := parseSunsetDate(s.StopPublishingAfter)
+	return t
+}
+
+// Validate checks the dates.
+func (s Sunset) Validate() error {
+	deprecated, err := parseSunsetDate(s.DeprecatedAfter)
+	if err != nil {
+		return fmt.Errorf("invalid deprecated-after: %v", err)
+	}
+	stop, err := parseSunsetDate(s.StopPublishingAfter)
+	if err != nil {
+		return fmt.Errorf("invalid stop-publishing-after: %v", err)
+	}
+	if !deprecated.IsZero() && !stop.IsZero() && stop.Before(deprecated) {
+		return fmt.Errorf("stop-publishing-after %s is before deprecated-after %s", s.StopPublishingAfter, s.DeprecatedAfter)
+	}
+	return nil
+}
+
+func parseSunsetDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(SunsetDateFormat, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TLSConfig configures the HTTPS connections of the bot and of the commands it
+// runs, e.g. for GitHub Enterprise with a private CA or an intercepting proxy.
+type TLSConfig struct {
+	// CABundle is a PEM file with CA certificates trusted in addition to the
+	// system ones.
+	CABundle string `yaml:"ca-bundle,omitempty"`
+
+	// MinVersion is the minimum TLS version: 1.0, 1.1, 1.2 or 1.3.
+	MinVersion string `yaml:"min-version,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// systemCABundles are where Linux distributions keep the system CA
+// certificates, as looked up by crypto/x509.
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// Validate checks the TLS version and that the CA bundle contains certificates.
+func (t TLSConfig) Validate() error {
+	if _, found := tlsVersions[t.MinVersion]; t.MinVersion != "" && !found {
+		var versions []string
+		for v := range tlsVersions {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		return fmt.Errorf("invalid min-version %q, must be one of %s", t.MinVersion, strings.Join(versions, ", "))
+	}
+	if t.CABundle != "" {
+		if _, err := t.caCertificates(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Config returns the TLS configuration of HTTPS clients.
+func (t TLSConfig) Config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tlsVersions[t.MinVersion]}
+	if t.CABundle == "" {
+		return cfg, nil
+	}
+	pem, err := t.caCertificates()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	pool.AppendCertsFromPEM(pem)
+	cfg.RootCAs = pool
+	return cfg, nil
+}
+
+// Transport returns a new HTTP transport with the TLS settings. Like
+// http.DefaultTransport, it uses the proxy environment. Every tenant gets its
+// own, such that the process-wide defaults are left alone.
+func (t TLSConfig) Transport() (*http.Transport, error) {
+	cfg, err := t.Config()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       cfg,
+	}, nil
+}
+
+// Env returns the environment variables configuring git, curl and the go
+// command with the TLS settings. As these replace their default CA
+// certificates with the ones in the environment, the system and the custom CA
+// certificates are combined into a file in the temporary directory.
+func (t TLSConfig) Env() ([]string, error) {
+	var env []string
+	if t.MinVersion != "" {
+		env = append(env, "GIT_SSL_VERSION=tlsv"+t.MinVersion)
+	}
+	if t.CABundle != "" {
+		bundle, err := t.combinedCABundle()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range []string{"SSL_CERT_FILE", "GIT_SSL_CAINFO", "CURL_CA_BUNDLE"} {
+			env = append(env, k+"="+bundle)
+		}
+	}
+	return env, nil
+}
+
+// caCertificates reads the CA bundle and checks that it contains certificates.
+func (t TLSConfig) caCertificates() ([]byte, error) {
+	pem, err := ioutil.ReadFile(t.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca-bundle: %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in ca-bundle %s", t.CABundle)
+	}
+	return pem, nil
+}
+
+// combinedCABundle writes the system and the custom CA certificates into a
+// file in the temporary directory and returns its path. The file is named
+// after its content, such that the tenants and runs with the same
+// certificates share it.
+func (t TLSConfig) combinedCABundle() (string, error) {
+	custom, err := t.caCertificates()
+	if err != nil {
+		return "", err
+	}
+	var system []byte
+	candidates := systemCABundles
+	if f := os.Getenv("SSL_CERT_FILE"); f != "" {
+		candidates = []string{f}
+	}
+	for _, f := range candidates {
+		if system, err = ioutil.ReadFile(f); err == nil {
+			break
+		}
+	}
+	var bundle []byte
+	if len(system) > 0 {
+		bundle = append(append(bundle, system...), '\n')
+	}
+	bundle = append(bundle, custom...)
+
+	name := filepath.Join(os.TempDir(), fmt.Sprintf("publishing-bot-ca-bundle-%x.pem", sha256.Sum256(bundle)))
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	}
+	// written to a temporary file first as tenants might write it concurrently
+	f, err := ioutil.TempFile("", "publishing-bot-ca-bundle")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(bundle); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return name, os.Rename(f.Name(), name)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeServerCA writes the certificate of the TLS server as a CA bundle into dir.
+func writeServerCA(t *testing.T, dir, name string, server *httptest.Server) string {
+	f := filepath.Join(dir, name)
+	bs := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(f, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestTLSTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("rules:\n- destination: client-go\n  branches:\n  - name: master\n    source:\n      branch: master\n      dir: staging/src/k8s.io/client-go\n"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := writeServerCA(t, dir, "ca.pem", server)
+
+	tests := []struct {
+		name    string
+		tls     TLSConfig
+		wantErr bool
+	}{
+		{"system CAs", TLSConfig{}, true},
+		{"ca-bundle", TLSConfig{CABundle: ca}, false},
+		{"min-version", TLSConfig{CABundle: ca, MinVersion: "1.2"}, false},
+	}
+	for _, tt := range tests {
+		transport, err := tt.tls.Transport()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if got, want := transport.TLSClientConfig.MinVersion, tlsVersions[tt.tls.MinVersion]; got != want {
+			t.Errorf("%s: expected min version %d, got %d", tt.name, want, got)
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+		// rules URLs are read with the same settings
+		_, err = LoadRules(server.URL+"/rules.yaml", tt.tls)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v loading the rules, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+	if tr, ok := http.DefaultTransport.(*http.Transport); ok && tr.TLSClientConfig != nil && tr.TLSClientConfig.RootCAs != nil {
+		t.Errorf("http.DefaultTransport was changed")
+	}
+}
+
+func TestTLSEnv(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := writeServerCA(t, dir, "ca.pem", server)
+	custom, err := ioutil.ReadFile(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other.pem")
+	if err := ioutil.WriteFile(other, append(append([]byte(nil), custom...), custom...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	system := filepath.Join(dir, "system.pem")
+	if err := ioutil.WriteFile(system, []byte("system certificates"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("SSL_CERT_FILE", os.Getenv("SSL_CERT_FILE"))
+	os.Setenv("SSL_CERT_FILE", system)
+	caInfo := os.Getenv("GIT_SSL_CAINFO")
+
+	env, err := TLSConfig{CABundle: ca, MinVersion: "1.2"}.Env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{}
+	for _, kv := range env {
+		ss := strings.SplitN(kv, "=", 2)
+		vars[ss[0]] = ss[1]
+	}
+	if got := vars["GIT_SSL_VERSION"]; got != "tlsv1.2" {
+		t.Errorf("expected GIT_SSL_VERSION tlsv1.2, got %q", got)
+	}
+	bundle := vars["SSL_CERT_FILE"]
+	defer os.Remove(bundle)
+	if bundle == "" || vars["GIT_SSL_CAINFO"] != bundle || vars["CURL_CA_BUNDLE"] != bundle {
+		t.Fatalf("expected the same combined bundle for all variables, got %v", vars)
+	}
+	bs, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "system certificates\n" + string(custom); string(bs) != want {
+		t.Errorf("expected bundle %q, got %q", want, bs)
+	}
+	if v := os.Getenv("GIT_SSL_CAINFO"); v != caInfo {
+		t.Errorf("expected the process environment to be left alone, got GIT_SSL_CAINFO=%s", v)
+	}
+
+	// the same certificates share the bundle, others get their own
+	if again, err := (TLSConfig{CABundle: ca}).combinedCABundle(); err != nil || again != bundle {
+		t.Errorf("expected %s again, got %s, %v", bundle, again, err)
+	}
+	otherBundle, err := TLSConfig{CABundle: other}.combinedCABundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(otherBundle)
+	if otherBundle == bundle {
+		t.Errorf("expected another bundle for other certificates")
+	}
+
+	if env, err := (TLSConfig{}).Env(); err != nil || len(env) != 0 {
+		t.Errorf("expected no environment without settings, got %v, %v", env, err)
+	}
+	if _, err := (TLSConfig{CABundle: filepath.Join(dir, "missing.pem")}).Env(); err == nil {
+		t.Errorf("expected an error with a missing ca-bundle")
+	}
+}
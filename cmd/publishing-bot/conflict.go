@@ -0,0 +1,184 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/redact"
+)
+
+// maxConflictDiffLines limits the diff in a conflict report.
+const maxConflictDiffLines = 200
+
+// ConflictReport describes why a destination branch could not be constructed.
+type ConflictReport struct {
+	Repository   string `json:"repository"`
+	Branch       string `json:"branch"`
+	SourceBranch string `json:"sourceBranch"`
+	Error        string `json:"error"`
+	// Operation is the git operation which stopped, e.g. cherry-pick, if any.
+	Operation string `json:"operation,omitempty"`
+	// SourceCommit is the source commit which could not be applied, if known.
+	SourceCommit    string   `json:"sourceCommit,omitempty"`
+	SourceSubject   string   `json:"sourceSubject,omitempty"`
+	SourceFiles     string   `json:"sourceFiles,omitempty"`
+	ConflictedFiles []string `json:"conflictedFiles,omitempty"`
+	Diff            string   `json:"diff,omitempty"`
+	Suggestions     []string `json:"suggestions,omitempty"`
+}
+
+// reportConflict collects a conflict report from the destination repo in the
+// publisher's directory after constructing the branch failed, stores it in the run
+// result and writes it into the conflict report directory.
+func (p *PublisherMunger) reportConflict(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	r := ConflictReport{
+		Repository:   repoRule.DestinationRepository,
+		Branch:       branchRule.Name,
+		SourceBranch: branchRule.Source.Branch,
+		Error:        redact.String(err.Error()),
+	}
+
+	for _, op := range []struct{ head, name string }{
+		{"CHERRY_PICK_HEAD", "cherry-pick"},
+		{"MERGE_HEAD", "merge"},
+		{"REBASE_HEAD", "rebase"},
+	} {
+		if p.command(ctx, "git", "rev-parse", "-q", "--verify", op.head).Run() != nil {
+			continue
+		}
+		r.Operation = op.name
+		r.SourceCommit = p.sourceCommitOf(ctx, op.head)
+		break
+	}
+	if out, err := p.command(ctx, "git", "diff", "--name-only", "--diff-filter=U").Output(); err == nil {
+		r.ConflictedFiles = strings.Fields(string(out))
+	}
+	if len(r.ConflictedFiles) > 0 {
+		if out, err := p.command(ctx, "git", "diff").Output(); err == nil {
+			r.Diff = truncateLines(string(out), maxConflictDiffLines)
+		}
+	}
+	if r.SourceCommit != "" {
+		cmd := p.command(ctx, "git", "show", "--stat", "--format=%s", r.SourceCommit, "--", branchRule.Source.Dir)
+		cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+		if out, err := cmd.Output(); err == nil {
+			ss := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)
+			r.SourceSubject = ss[0]
+			if len(ss) > 1 {
+				r.SourceFiles = strings.Trim(ss[1], "\n")
+			}
+		}
+	}
+	r.Suggestions = conflictSuggestions(r, p.plog.Logs())
+
+	p.result.ConflictReport = &r
+	dir := p.config.ConflictReportDir
+	if dir == "" {
+		dir = filepath.Join(p.baseRepoPath, "conflict-reports")
+	}
+	pth := filepath.Join(dir, fmt.Sprintf("%s-%s.md", r.Repository, r.Branch))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		p.plog.Errorf("Failed to write conflict report: %v", err)
+	} else if err := ioutil.WriteFile(pth, []byte(r.Markdown()), 0644); err != nil {
+		p.plog.Errorf("Failed to write conflict report: %v", err)
+	} else {
+		p.plog.Infof("Wrote conflict report to %s", pth)
+	}
+}
+
+// conflictSuggestions guesses how the rules can be changed to get publishing
+// going again.
+func conflictSuggestions(r ConflictReport, logs string) []string {
+	var suggestions []string
+	for _, f := range r.ConflictedFiles {
+		if strings.HasPrefix(f, "vendor/") || strings.HasPrefix(f, "Godeps/") || f == "go.mod" || f == "go.sum" {
+			suggestions = append(suggestions, fmt.Sprintf("The conflict is in dependency files. Check the dependencies of branch %s in the rules, and whether the dependency branches were published.", r.Branch))
+			break
+		}
+	}
+	// only the output of the failed construct.sh run
+	if i := strings.LastIndex(logs, "construct.sh "); i >= 0 {
+		logs = logs[i:]
+	}
+	if r.Operation == "" && (strings.Contains(logs, "godep restore") || strings.Contains(logs, "go mod download")) {
+		suggestions = append(suggestions, fmt.Sprintf("Restoring the dependencies failed. Check the dependencies and the go version of branch %s in the rules.", r.Branch))
+	}
+	if r.SourceCommit != "" {
+		suggestions = append(suggestions,
+			fmt.Sprintf("If source commit %s must not be published, add it to skip-source-commits.", r.SourceCommit),
+			fmt.Sprintf("To publish up to the source commit before it until the conflict is resolved, add \"pin: %s^\" to branch %s.", r.SourceCommit, r.Branch),
+		)
+	}
+	if len(suggestions) == 0 {
+		suggestions = append(suggestions, "See the logs for the failing command.")
+	}
+	return suggestions
+}
+
+// Markdown formats the report for a GitHub comment or a file.
+func (r ConflictReport) Markdown() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### Failed to construct branch %s of %s\n\n", r.Branch, r.Repository)
+	fmt.Fprintf(&buf, "Source branch: %s\n", r.SourceBranch)
+	if r.Operation != "" {
+		fmt.Fprintf(&buf, "Failed operation: %s\n", r.Operation)
+	}
+	if r.SourceCommit != "" {
+		fmt.Fprintf(&buf, "Source commit: %s %s\n", r.SourceCommit, r.SourceSubject)
+	}
+	fmt.Fprintf(&buf, "Error: %s\n", r.Error)
+	if r.SourceFiles != "" {
+		fmt.Fprintf(&buf, "\n#### Files changed by the source commit\n\n```\n%s\n```\n", r.SourceFiles)
+	}
+	if len(r.ConflictedFiles) > 0 {
+		fmt.Fprintf(&buf, "\n#### Conflicting files\n\n")
+		for _, f := range r.ConflictedFiles {
+			fmt.Fprintf(&buf, "- %s\n", f)
+		}
+	}
+	if r.Diff != "" {
+		fmt.Fprintf(&buf, "\n#### Diff\n\n```diff\n%s\n```\n", strings.TrimRight(r.Diff, "\n"))
+	}
+	fmt.Fprintf(&buf, "\n#### Suggestions\n\n")
+	for _, s := range r.Suggestions {
+		fmt.Fprintf(&buf, "- %s\n", s)
+	}
+	return buf.String()
+}
+
+// truncateLines returns the first n lines of s, with a note if there are more.
+func truncateLines(s string, n int) string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= n {
+		return s
+	}
+	return strings.Join(lines[:n], "") + fmt.Sprintf("... (%d more lines)\n", len(lines)-n)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConflictSuggestions(t *testing.T) {
+	tests := []struct {
+		name     string
+		report   ConflictReport
+		logs     string
+		expected []string
+	}{
+		{"unknown", ConflictReport{Branch: "master"}, "", []string{"See the logs"}},
+		{"dependency conflict", ConflictReport{Branch: "master", Operation: "cherry-pick", SourceCommit: "abc", ConflictedFiles: []string{"pkg/a.go", "go.mod"}}, "",
+			[]string{"dependency files", "skip-source-commits", "pin: abc^"}},
+		{"dependency restore", ConflictReport{Branch: "master"}, "/publish_scripts/construct.sh client-go master\n  godep restore failed", []string{"Restoring the dependencies"}},
+		{"dependency restore of earlier branch", ConflictReport{Branch: "master"}, "godep restore\n/publish_scripts/construct.sh client-go master\n  failed", []string{"See the logs"}},
+	}
+	for _, tt := range tests {
+		got := conflictSuggestions(tt.report, tt.logs)
+		if len(got) != len(tt.expected) {
+			t.Errorf("%s: expected %d suggestions, got %q", tt.name, len(tt.expected), got)
+			continue
+		}
+		for i := range got {
+			if !strings.Contains(got[i], tt.expected[i]) {
+				t.Errorf("%s: expected suggestion %d to contain %q, got %q", tt.name, i, tt.expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestTruncateLines(t *testing.T) {
+	if got := truncateLines("a\nb\n", 3); got != "a\nb\n" {
+		t.Errorf("unexpected truncation: %q", got)
+	}
+	if got := truncateLines("a\nb\nc\nd\n", 2); got != "a\nb\n... (2 more lines)\n" {
+		t.Errorf("unexpected truncation: %q", got)
+	}
+}
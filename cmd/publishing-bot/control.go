@@ -0,0 +1,307 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+const pauseStateFileName = "publisher-paused.json"
+
+// PausedRepo is a destination repo or branch which is not published until
+// resumed.
+type PausedRepo struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// PauseState records the paused destination repos and branches. It is
+// persisted such that pauses survive restarts, and re-read before every run
+// and change such that the pause and resume commands apply to a running bot.
+type PauseState struct {
+	store state.Store
+	mutex sync.Mutex
+	// Repos are keyed by <repo> or <repo>/<branch>
+	Repos map[string]PausedRepo `json:"repos"`
+}
+
+// LoadPauseState reads the pause state from the state store. A missing state is
+// empty.
+func LoadPauseState(store state.Store) (*PauseState, error) {
+	s := &PauseState{store: store, Repos: map[string]PausedRepo{}}
+	return s, s.load()
+}
+
+// Reload reads the pause state from the state store again.
+func (s *PauseState) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.load()
+}
+
+func (s *PauseState) load() error {
+	bs, err := s.store.Read(pauseStateFileName)
+	if os.IsNotExist(err) {
+		s.Repos = map[string]PausedRepo{}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var loaded PauseState
+	if err := json.Unmarshal(bs, &loaded); err != nil {
+		return err
+	}
+	s.Repos = loaded.Repos
+	if s.Repos == nil {
+		s.Repos = map[string]PausedRepo{}
+	}
+	return nil
+}
+
+// Paused returns whether the destination repo, or the branch if the key is
+// <repo>/<branch>, is paused. It is safe to call on nil.
+func (s *PauseState) Paused(key string) (PausedRepo, bool) {
+	if s == nil {
+		return PausedRepo{}, false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, found := s.Repos[key]
+	return r, found
+}
+
+// PausedBranch returns whether the destination branch or its repo is paused.
+// It is safe to call on nil.
+func (s *PauseState) PausedBranch(repo, branch string) (PausedRepo, bool) {
+	if r, found := s.Paused(repo); found {
+		return r, true
+	}
+	return s.Paused(repo + "/" + branch)
+}
+
+// Pause pauses the destination repo, or the branch if the key is
+// <repo>/<branch>, and saves the state.
+func (s *PauseState) Pause(key, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.Repos[key] = PausedRepo{Since: time.Now(), Reason: reason}
+	return s.save()
+}
+
+// Resume resumes the destination repo or branch and saves the state. It
+// returns false if it was not paused.
+func (s *PauseState) Resume(key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.load(); err != nil {
+		return false, err
+	}
+	if _, found := s.Repos[key]; !found {
+		return false, nil
+	}
+	delete(s.Repos, key)
+	return true, s.save()
+}
+
+// List returns a copy of the paused repos and branches.
+func (s *PauseState) List() map[string]PausedRepo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	repos := make(map[string]PausedRepo, len(s.Repos))
+	for k, v := range s.Repos {
+		repos[k] = v
+	}
+	return repos
+}
+
+// sortedPauseKeys returns the keys of the paused repos and branches in order.
+func sortedPauseKeys(paused map[string]PausedRepo) []string {
+	keys := make([]string, 0, len(paused))
+	for k := range paused {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *PauseState) save() error {
+	bs, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.store.Write(pauseStateFileName, bs)
+}
+
+// controlAPI lets tools drive the bot via HTTP, authenticated with a bearer
+// token:
+//
+//	POST /api/v1/run                   triggers a run
+//	POST /api/v1/repos/<repo>/pause    pauses publishing to a destination repo
+//	POST /api/v1/repos/<repo>/resume   resumes it
+//	POST /api/v1/repos/<repo>/branches/<branch>/pause   pauses a destination branch
+//	POST /api/v1/repos/<repo>/branches/<branch>/resume  resumes it
+//	GET  /api/v1/status                returns the health, the last result and the paused repos and branches
+//	POST /api/v1/rules/reload          validates the rules file and triggers a run with it
+type controlAPI struct {
+	config *config.Config
+	server *Server
+	pauses *PauseState
+}
+
+func (c *controlAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/run", c.authenticated("POST", c.runHandler))
+	mux.HandleFunc("/api/v1/repos/", c.authenticated("POST", c.repoHandler))
+	mux.HandleFunc("/api/v1/status", c.authenticated("GET", c.statusHandler))
+	mux.HandleFunc("/api/v1/rules/reload", c.authenticated("POST", c.reloadHandler))
+}
+
+// authenticated checks the method and the bearer token before calling the handler.
+func (c *controlAPI) authenticated(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, err := loadToken(c.config, c.config.ControlAPIToken)
+		if err != nil {
+			glog.Errorf("Failed to load control API token: %v", err)
+			http.Error(w, "failed to load token", http.StatusInternalServerError)
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (c *controlAPI) runHandler(w http.ResponseWriter, r *http.Request) {
+	c.triggerRun()
+	writeJSON(w, map[string]string{"status": "triggered"})
+}
+
+func (c *controlAPI) triggerRun() {
+	select {
+	case c.server.RunChan <- true:
+	default:
+		// a run is queued already
+	}
+}
+
+func (c *controlAPI) repoHandler(w http.ResponseWriter, r *http.Request) {
+	ss := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/repos/"), "/")
+	if len(ss) < 2 || ss[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	// repo is <repo> or <repo>/<branch>, branches may contain slashes
+	repo, action := ss[0], ss[len(ss)-1]
+	if len(ss) > 2 {
+		if len(ss) < 4 || ss[1] != "branches" {
+			http.NotFound(w, r)
+			return
+		}
+		repo += "/" + strings.Join(ss[2:len(ss)-1], "/")
+	}
+	switch action {
+	case "pause":
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := c.pauses.Pause(repo, body.Reason); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		glog.Infof("Paused %s via the control API: %s", repo, body.Reason)
+		writeJSON(w, map[string]string{"status": "paused"})
+	case "resume":
+		resumed, err := c.pauses.Resume(repo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !resumed {
+			http.Error(w, fmt.Sprintf("%s is not paused", repo), http.StatusConflict)
+			return
+		}
+		glog.Infof("Resumed %s via the control API", repo)
+		writeJSON(w, map[string]string{"status": "resumed"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *controlAPI) statusHandler(w http.ResponseWriter, r *http.Request) {
+	c.server.mutex.RLock()
+	health, result := c.server.response, c.server.result
+	c.server.mutex.RUnlock()
+	status := struct {
+		Health  HealthResponse        `json:"health"`
+		Outcome string                `json:"outcome,omitempty"`
+		Result  *RunResult            `json:"result,omitempty"`
+		Paused  map[string]PausedRepo `json:"paused"`
+	}{Health: health, Result: result, Paused: c.pauses.List()}
+	if result != nil {
+		status.Outcome = result.Outcome()
+	}
+	writeJSON(w, status)
+}
+
+func (c *controlAPI) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	// the rules are read at the start of every run
+	rules, err := config.LoadRules(c.config.RulesFile, c.config.Network.TLS)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.triggerRun()
+	writeJSON(w, map[string]interface{}{"status": "triggered", "rules": len(rules.Rules)})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	bs, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+func TestControlAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("PUBLISHER_BOT_TEST_CONTROL_TOKEN", "secret")
+	defer os.Unsetenv("PUBLISHER_BOT_TEST_CONTROL_TOKEN")
+
+	pauses, err := LoadPauseState(state.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{RunChan: make(chan bool, 1)}
+	c := &controlAPI{config: &config.Config{ControlAPIToken: "env:PUBLISHER_BOT_TEST_CONTROL_TOKEN"}, server: server, pauses: pauses}
+	mux := http.NewServeMux()
+	c.register(mux)
+
+	do := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		method, path, token, body string
+		expected                  int
+	}{
+		{"POST", "/api/v1/run", "", "", http.StatusUnauthorized},
+		{"POST", "/api/v1/run", "wrong", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/run", "secret", "", http.StatusMethodNotAllowed},
+		{"POST", "/api/v1/run", "secret", "", http.StatusOK},
+		{"POST", "/api/v1/repos/client-go/resume", "secret", "", http.StatusConflict},
+		{"POST", "/api/v1/repos/client-go/pause", "secret", `{"reason": "investigating"}`, http.StatusOK},
+		{"POST", "/api/v1/repos/client-go/unknown", "secret", "", http.StatusNotFound},
+		{"POST", "/api/v1/repos/api/branches/release/1.10/pause", "secret", "", http.StatusOK},
+		{"POST", "/api/v1/repos/api/release-1.10/pause", "secret", "", http.StatusNotFound},
+		{"GET", "/api/v1/status", "secret", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path, tt.token, tt.body); got != tt.expected {
+			t.Errorf("%s %s: expected HTTP code %d, got %d", tt.method, tt.path, tt.expected, got)
+		}
+	}
+
+	select {
+	case <-server.RunChan:
+	default:
+		t.Errorf("expected a triggered run")
+	}
+
+	// pauses are persisted
+	if pauses, err = LoadPauseState(state.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if p, found := pauses.Paused("client-go"); !found || p.Reason != "investigating" {
+		t.Errorf("expected client-go to be paused, got %+v, %v", p, found)
+	}
+	if _, found := pauses.PausedBranch("api", "release/1.10"); !found {
+		t.Errorf("expected branch release/1.10 of api to be paused")
+	}
+	if got := do("POST", "/api/v1/repos/client-go/resume", "secret", ""); got != http.StatusOK {
+		t.Errorf("expected to resume client-go, got HTTP code %d", got)
+	}
+	if _, found := c.pauses.Paused("client-go"); found {
+		t.Errorf("expected client-go to be resumed")
+	}
+}
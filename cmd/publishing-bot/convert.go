@@ -0,0 +1,82 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// convertCommand is the convert subcommand. It converts a config or rules file
+// between YAML, JSON and TOML and returns the exit code.
+func convertCommand(args []string, in io.Reader, out io.Writer) int {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", "", "the format of the input: yaml, json or toml. Defaults to the file extension, yaml for stdin")
+	to := fs.String("to", config.FormatYAML, "the format of the output: yaml, json or toml")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s convert [-from yaml|json|toml] [-to yaml|json|toml] [<file>]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	if err := config.ValidateFormat(*to); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var (
+		content []byte
+		err     error
+	)
+	format := config.FormatYAML
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		format = config.FormatOf(fs.Arg(0))
+		content, err = ioutil.ReadFile(fs.Arg(0))
+	} else {
+		content, err = ioutil.ReadAll(in)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *from != "" {
+		if err := config.ValidateFormat(*from); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		format = *from
+	}
+
+	converted, err := config.Convert(content, format, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, err := out.Write(converted); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/kubeapi"
+)
+
+// crdObject is a PublishingRule or PublishingTarget.
+type crdObject struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+		Generation      int64  `json:"generation,omitempty"`
+	} `json:"metadata"`
+	Spec   map[string]interface{} `json:"spec,omitempty"`
+	Status *ruleStatus            `json:"status,omitempty"`
+}
+
+type crdList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []crdObject `json:"items"`
+}
+
+// ruleStatus is the status of a PublishingRule written after every run.
+type ruleStatus struct {
+	ObservedGeneration int64            `json:"observedGeneration,omitempty"`
+	LastRun            time.Time        `json:"lastRun"`
+	UpstreamHash       string           `json:"upstreamHash,omitempty"`
+	Conditions         []crdCondition   `json:"conditions,omitempty"`
+	Branches           []crdBranchState `json:"branches,omitempty"`
+}
+
+type crdCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+type crdBranchState struct {
+	Name         string   `json:"name"`
+	SourceCommit string   `json:"sourceCommit,omitempty"`
+	Commits      int      `json:"commits"`
+	Tags         []string `json:"tags,omitempty"`
+	Pushed       bool     `json:"pushed"`
+	Skipped      string   `json:"skipped,omitempty"`
+}
+
+// watchCRDs calls trigger whenever the spec of a PublishingRule or
+// PublishingTarget in the namespace is created, changed or deleted, until the
+// context is done. Status updates do not trigger.
+func watchCRDs(ctx context.Context, client *kubeapi.Client, ns string, trigger func()) {
+	for _, resource := range []string{config.PublishingRules, config.PublishingTargets} {
+		go func(resource string) {
+			for ctx.Err() == nil {
+				if err := watchCRD(ctx, client, ns, resource, trigger); err != nil && ctx.Err() == nil {
+					glog.Warningf("Watching %s failed, retrying: %v", resource, err)
+					select {
+					case <-ctx.Done():
+					case <-time.After(10 * time.Second):
+					}
+				}
+			}
+		}(resource)
+	}
+}
+
+// watchCRD lists the resources and watches them until the watch ends.
+func watchCRD(ctx context.Context, client *kubeapi.Client, ns, resource string, trigger func()) error {
+	path := config.CRDPath(ns, resource)
+	var list crdList
+	if err := client.Do(ctx, "GET", path, "", nil, &list); err != nil {
+		return err
+	}
+	generations := map[string]int64{}
+	for _, o := range list.Items {
+		generations[o.Metadata.Name] = o.Metadata.Generation
+	}
+
+	resp, err := client.Stream(ctx, "GET", path+"?watch=true&resourceVersion="+list.Metadata.ResourceVersion, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&event); err == io.EOF {
+			// the server ended the watch
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if event.Type == "ERROR" {
+			// e.g. the resource version is too old, list again
+			return fmt.Errorf("watch error: %s", event.Object)
+		}
+		var o crdObject
+		if err := json.Unmarshal(event.Object, &o); err != nil {
+			return err
+		}
+		name, generation := o.Metadata.Name, o.Metadata.Generation
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			if g, found := generations[name]; found && g == generation {
+				continue
+			}
+			generations[name] = generation
+		case "DELETED":
+			delete(generations, name)
+		default:
+			continue
+		}
+		glog.Infof("%s %s %s, triggering a run", resource, name, strings.ToLower(event.Type))
+		trigger()
+	}
+}
+
+// updateCRDStatus writes the outcome of the run into the status of the
+// PublishingRules in the namespace.
+func updateCRDStatus(ctx context.Context, client *kubeapi.Client, ns string, result RunResult) error {
+	var rules crdList
+	if err := client.Do(ctx, "GET", config.CRDPath(ns, config.PublishingRules), "", nil, &rules); err != nil {
+		return fmt.Errorf("failed to list %s: %v", config.PublishingRules, err)
+	}
+	var errs []string
+	for _, r := range rules.Items {
+		destination := r.Metadata.Name
+		if d, ok := r.Spec["destination"].(string); ok && d != "" {
+			destination = d
+		}
+		status := crdStatus(r, destination, result)
+		path := config.CRDPath(ns, config.PublishingRules) + "/" + r.Metadata.Name + "/status"
+		patch := map[string]interface{}{"status": status}
+		if err := client.Do(ctx, "PATCH", path, "application/merge-patch+json", patch, nil); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", r.Metadata.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to update the status of %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// crdStatus returns the status of the PublishingRule for the destination repo
+// after the run. Its Ready condition is false if the run failed.
+func crdStatus(r crdObject, destination string, result RunResult) ruleStatus {
+	s := ruleStatus{
+		ObservedGeneration: r.Metadata.Generation,
+		LastRun:            result.End,
+		UpstreamHash:       result.UpstreamHash,
+	}
+	pushed := 0
+	for _, b := range result.Branches {
+		if b.Repository != destination {
+			continue
+		}
+		if b.Pushed {
+			pushed++
+		}
+		s.Branches = append(s.Branches, crdBranchState{
+			Name:         b.Branch,
+			SourceCommit: b.SourceCommit,
+			Commits:      b.Commits,
+			Tags:         b.Tags,
+			Pushed:       b.Pushed,
+			Skipped:      b.Skipped,
+		})
+	}
+
+	ready := crdCondition{Type: "Ready", Status: "True", Reason: "UpToDate"}
+	switch {
+	case result.Error != "":
+		ready.Status, ready.Reason, ready.Message = "False", "RunFailed", result.Error
+		if c := result.ConflictReport; c != nil && c.Repository == destination {
+			ready.Reason = "Conflict"
+		}
+	case pushed > 0:
+		ready.Reason, ready.Message = "Published", fmt.Sprintf("%d branches pushed", pushed)
+	}
+	ready.LastTransitionTime = result.End
+	if r.Status != nil {
+		for _, c := range r.Status.Conditions {
+			if c.Type == ready.Type && c.Status == ready.Status {
+				ready.LastTransitionTime = c.LastTransitionTime
+			}
+		}
+	}
+	s.Conditions = []crdCondition{ready}
+	return s
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/pkg/kubeapi"
+)
+
+func TestCRDStatus(t *testing.T) {
+	before := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	result := RunResult{
+		End: end,
+		Branches: []BranchResult{
+			{Repository: "client-go", Branch: "master", SourceCommit: "abc", Commits: 2, Pushed: true},
+			{Repository: "client-go", Branch: "release-1.9", Skipped: "paused"},
+			{Repository: "api", Branch: "master", Pushed: true},
+		},
+	}
+	failed := result
+	failed.Error = "failed to publish"
+	failed.ConflictReport = &ConflictReport{Repository: "client-go", Branch: "master"}
+
+	tests := []struct {
+		name       string
+		previous   *ruleStatus
+		result     RunResult
+		status     string
+		reason     string
+		transition time.Time
+	}{
+		{"published", nil, result, "True", "Published", end},
+		{"up to date", nil, RunResult{End: end}, "True", "UpToDate", end},
+		{"conflict", nil, failed, "False", "Conflict", end},
+		{"still ready", &ruleStatus{Conditions: []crdCondition{{Type: "Ready", Status: "True", LastTransitionTime: before}}}, result, "True", "Published", before},
+		{"no longer ready", &ruleStatus{Conditions: []crdCondition{{Type: "Ready", Status: "True", LastTransitionTime: before}}}, failed, "False", "Conflict", end},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r crdObject
+			r.Metadata.Name = "client-go"
+			r.Metadata.Generation = 3
+			r.Status = tt.previous
+			s := crdStatus(r, "client-go", tt.result)
+			if s.ObservedGeneration != 3 {
+				t.Errorf("got observed generation %d, expected 3", s.ObservedGeneration)
+			}
+			if len(s.Conditions) != 1 {
+				t.Fatalf("expected one condition, got %v", s.Conditions)
+			}
+			c := s.Conditions[0]
+			if c.Status != tt.status || c.Reason != tt.reason || !c.LastTransitionTime.Equal(tt.transition) {
+				t.Errorf("got condition %s/%s since %v, expected %s/%s since %v", c.Status, c.Reason, c.LastTransitionTime, tt.status, tt.reason, tt.transition)
+			}
+			for _, b := range s.Branches {
+				if b.Name != "master" && b.Name != "release-1.9" {
+					t.Errorf("unexpected branch %s of another repository", b.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateCRDStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	patched := map[string]ruleStatus{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const path = "/apis/publishing.k8s.io/v1alpha1/namespaces/publisher/publishingrules"
+		switch {
+		case r.Method == "GET" && r.URL.Path == path:
+			fmt.Fprint(w, `{"items": [{"metadata": {"name": "client-go", "generation": 2}, "spec": {}}, {"metadata": {"name": "client"}, "spec": {"destination": "client-go"}}]}`)
+		case r.Method == "PATCH" && r.Header.Get("Content-Type") == "application/merge-patch+json":
+			var patch struct {
+				Status ruleStatus `json:"status"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				t.Error(err)
+			}
+			patched[r.URL.Path] = patch.Status
+			fmt.Fprint(w, "{}")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &kubeapi.Client{Server: server.URL, TokenFile: tokenFile, HTTP: http.DefaultClient}
+	result := RunResult{Branches: []BranchResult{{Repository: "client-go", Branch: "master", Pushed: true}}}
+	if err := updateCRDStatus(context.Background(), client, "publisher", result); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"client-go", "client"} {
+		s, found := patched["/apis/publishing.k8s.io/v1alpha1/namespaces/publisher/publishingrules/"+name+"/status"]
+		if !found {
+			t.Errorf("status of %s not patched", name)
+			continue
+		}
+		if len(s.Branches) != 1 || !s.Branches[0].Pushed {
+			t.Errorf("got branches %v for %s, expected master pushed", s.Branches, name)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/redact"
+	"k8s.io/publishing-bot/pkg/secrets"
+)
+
+// secretsDirName is the directory in the base repo path where secrets are
+// materialized for git, ssh and gpg.
+const secretsDirName = ".publisher-secrets"
+
+// The environment of the credential helper: the secret reference of the token,
+// the username and the directory of mounted Kubernetes secrets.
+const (
+	credentialRefEnv        = "PUBLISHER_BOT_CREDENTIAL_REF"
+	credentialUsernameEnv   = "PUBLISHER_BOT_CREDENTIAL_USERNAME"
+	credentialSecretsDirEnv = "PUBLISHER_BOT_KUBERNETES_SECRETS_DIR"
+)
+
+// defaultCredentialUsername is sent with tokens if no username is configured.
+// GitHub accepts any username with a token as password.
+const defaultCredentialUsername = "x-access-token"
+
+var (
+	secretsMutex sync.Mutex
+	secretCache  = map[string]*secrets.Secret{}
+)
+
+// loadSecret returns the secret for the reference. Secrets are cached across
+// runs such that changed files are detected.
+func loadSecret(cfg *config.Config, ref string) (*secrets.Secret, error) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	if s, found := secretCache[ref]; found {
+		return s, nil
+	}
+	s, err := secrets.Parse(ref, cfg.KubernetesSecretsDir)
+	if err != nil {
+		return nil, err
+	}
+	secretCache[ref] = s
+	return s, nil
+}
+
+// loadToken reads the github token from the given secret reference, e.g. a file.
+func loadToken(cfg *config.Config, ref string) (string, error) {
+	s, err := loadSecret(cfg, ref)
+	if err != nil {
+		return "", err
+	}
+	bs, err := s.Value()
+	if err != nil {
+		return "", fmt.Errorf("failed to load token: %v", err)
+	}
+	token := strings.Trim(string(bs), " \t\n")
+	redact.Register(token)
+	return token, nil
+}
+
+// setupCredentials writes the configured SSH and GPG keys into the secrets
+// directory if they changed and points git and gpg to them via the environment
+// of the commands of the publisher, next to the TLS settings of the tenant.
+func (p *PublisherMunger) setupCredentials(ctx context.Context) error {
+	dir := filepath.Join(p.baseRepoPath, secretsDirName)
+	tlsEnv, err := p.config.Network.TLS.Env()
+	if err != nil {
+		return fmt.Errorf("failed to apply the TLS settings: %v", err)
+	}
+	p.gitEnv = tlsEnv
+	p.resetCredentialHelpers = gitAtLeast(ctx, 2, 9)
+
+	if p.config.SSHKey != "" {
+		s, err := loadSecret(p.config, p.config.SSHKey)
+		if err != nil {
+			return err
+		}
+		keyPath := filepath.Join(dir, "ssh-key")
+		if _, err := s.WriteFile(keyPath); err != nil {
+			return fmt.Errorf("failed to write SSH key: %v", err)
+		}
+		p.gitEnv = append(p.gitEnv, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes", keyPath))
+	}
+
+	if p.config.GPGKey != "" {
+		s, err := loadSecret(p.config, p.config.GPGKey)
+		if err != nil {
+			return err
+		}
+		gnupgHome := filepath.Join(dir, "gnupg")
+		if err := os.MkdirAll(gnupgHome, 0700); err != nil {
+			return err
+		}
+		p.gitEnv = append(p.gitEnv, "GNUPGHOME="+gnupgHome)
+		keyPath := filepath.Join(dir, "gpg-key")
+		written, err := s.WriteFile(keyPath)
+		if err != nil {
+			return fmt.Errorf("failed to write GPG key: %v", err)
+		}
+		if written {
+			p.plog.Infof("Importing GPG key from %s", s)
+			if err := p.plog.Run(p.command(ctx, "gpg", "--batch", "--import", keyPath)); err != nil {
+				return fmt.Errorf("failed to import GPG key: %v", err)
+			}
+		}
+	}
+	p.readEnv = nil
+	if p.config.ReadToken != "" {
+		// fail early if the token is missing
+		if _, err := loadToken(p.config, p.config.ReadToken); err != nil {
+			return fmt.Errorf("failed to load read token: %v", err)
+		}
+		var err error
+		if p.readEnv, err = p.credentialEnv(p.config.ReadToken, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// credentialEnv returns the environment of git commands authenticating over
+// HTTPS with the token of the secret reference. git asks the credential-helper
+// subcommand of the bot for the token, such that it appears neither in remote
+// URLs nor in command lines, environments or error messages, and a rotated
+// token is picked up by the next request.
+func (p *PublisherMunger) credentialEnv(ref, username string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the credential helper: %v", err)
+	}
+	if username == "" {
+		username = defaultCredentialUsername
+	}
+	helper := "credential.helper=!" + shellQuote(exe) + " credential-helper"
+	params := gitConfigParameters(helper)
+	if p.resetCredentialHelpers {
+		// the empty helper drops helpers configured elsewhere
+		params = gitConfigParameters("credential.helper=", helper)
+	}
+	return append(p.environ(),
+		"GIT_CONFIG_PARAMETERS="+params,
+		"GIT_TERMINAL_PROMPT=0",
+		credentialRefEnv+"="+ref,
+		credentialUsernameEnv+"="+username,
+		credentialSecretsDirEnv+"="+p.config.KubernetesSecretsDir,
+	), nil
+}
+
+// gitConfigParameters returns the value of GIT_CONFIG_PARAMETERS setting the
+// given key=value pairs, as passed by git -c to its sub-processes.
+func gitConfigParameters(kvs ...string) string {
+	quoted := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		quoted = append(quoted, shellQuote(kv))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes the string in single quotes for the shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// credentialHelper is the credential-helper subcommand implementing the git
+// credential helper protocol. For "get", it answers with the username and the
+// token of the secret reference in the environment, read anew on every call.
+// Other operations like store and erase are ignored.
+func credentialHelper(args []string, in io.Reader, out io.Writer) int {
+	// git sends the attributes of the request, terminated by an empty line
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() && scanner.Text() != "" {
+	}
+	ref := os.Getenv(credentialRefEnv)
+	if len(args) != 1 || args[0] != "get" || ref == "" {
+		return 0
+	}
+	s, err := secrets.Parse(ref, os.Getenv(credentialSecretsDirEnv))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credential-helper: %v\n", err)
+		return 1
+	}
+	bs, err := s.Value()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credential-helper: failed to load token from %s: %v\n", ref, err)
+		return 1
+	}
+	token := strings.Trim(string(bs), " \t\n")
+	if strings.ContainsAny(token, "\n\x00") {
+		fmt.Fprintf(os.Stderr, "credential-helper: invalid token in %s\n", ref)
+		return 1
+	}
+	fmt.Fprintf(out, "username=%s\npassword=%s\n", os.Getenv(credentialUsernameEnv), token)
+	return 0
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestCredentialHelper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+
+	defer os.Unsetenv(credentialRefEnv)
+	defer os.Unsetenv(credentialUsernameEnv)
+	os.Setenv(credentialRefEnv, "file:"+tokenFile)
+	os.Setenv(credentialUsernameEnv, "oauth2")
+
+	request := "protocol=https\nhost=github.com\n\n"
+	for _, token := range []string{"first", "rotated"} {
+		if err := ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		out := bytes.NewBuffer(nil)
+		if code := credentialHelper([]string{"get"}, strings.NewReader(request), out); code != 0 {
+			t.Fatalf("got exit code %d", code)
+		}
+		if expected := "username=oauth2\npassword=" + token + "\n"; out.String() != expected {
+			t.Errorf("got %q, expected %q", out.String(), expected)
+		}
+	}
+
+	out := bytes.NewBuffer(nil)
+	if code := credentialHelper([]string{"store"}, strings.NewReader(request+"password=first\n"), out); code != 0 || out.Len() != 0 {
+		t.Errorf("expected store to be ignored, got exit code %d and %q", code, out.String())
+	}
+
+	os.Setenv(credentialRefEnv, "file:"+filepath.Join(dir, "missing"))
+	if code := credentialHelper([]string{"get"}, strings.NewReader(request), out); code == 0 {
+		t.Errorf("expected failure for missing token")
+	}
+}
+
+func TestSetupCredentialsKeepsProcessEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte("key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "repo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(&config.Config{SSHKey: "file:" + keyFile}, dir)
+	if err := p.setupCredentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := os.LookupEnv("GIT_SSH_COMMAND"); found {
+		t.Errorf("expected the process environment to be unchanged, got GIT_SSH_COMMAND=%q", v)
+	}
+	p.dir = filepath.Join(dir, "repo")
+	out, err := p.command(context.Background(), "/bin/bash", "-c", `echo "$PWD $GIT_SSH_COMMAND"`).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("%s ssh -i %s -o IdentitiesOnly=yes", p.dir, filepath.Join(dir, secretsDirName, "ssh-key"))
+	if got := strings.TrimSpace(string(out)); got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestGitConfigParameters(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	params := gitConfigParameters("credential.helper=", `credential.helper=!"/bin/it's" credential-helper`)
+	cmd := exec.Command("git", "config", "--get-all", "credential.helper")
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_PARAMETERS="+params)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\n!\"/bin/it's\" credential-helper\n"; string(out) != expected {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+}
+
+func TestCredentialEnvResetsHelpers(t *testing.T) {
+	p := New(&config.Config{}, "")
+	for _, reset := range []bool{false, true} {
+		p.resetCredentialHelpers = reset
+		env, err := p.credentialEnv("env:TOKEN", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var params string
+		for _, kv := range env {
+			if strings.HasPrefix(kv, "GIT_CONFIG_PARAMETERS=") {
+				params = strings.TrimPrefix(kv, "GIT_CONFIG_PARAMETERS=")
+			}
+		}
+		if got := strings.HasPrefix(params, "'credential.helper=' "); got != reset {
+			t.Errorf("reset %v: unexpected GIT_CONFIG_PARAMETERS %q", reset, params)
+		}
+	}
+}
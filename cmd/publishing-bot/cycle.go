@@ -0,0 +1,157 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// cycleBudget shares the time until the cycle deadline fairly between the
+// destination repos still to be constructed.
+type cycleBudget struct {
+	// deadline of the cycle, zero for none
+	deadline time.Time
+	// repos is the number of destination repos not constructed yet
+	repos int
+	// unfinished are the destination repos cut off by the deadline of the
+	// previous cycle. They are constructed first and get all the time left,
+	// such that repos needing more than their share finish eventually.
+	unfinished map[string]time.Time
+}
+
+// next returns the deadline of the construction of the given destination
+// repo: its fair share of the time left, i.e. repos which finish early leave
+// more time to the others, or all the time left for repos unfinished in the
+// previous cycle. It returns false if the cycle deadline has passed.
+func (b *cycleBudget) next(repo string, now time.Time) (time.Time, bool) {
+	if b.deadline.IsZero() {
+		return time.Time{}, true
+	}
+	repos := b.repos
+	if repos < 1 {
+		repos = 1
+	}
+	b.repos--
+	if !now.Before(b.deadline) {
+		return time.Time{}, false
+	}
+	if _, found := b.unfinished[repo]; found {
+		return b.deadline, true
+	}
+	return now.Add(b.deadline.Sub(now) / time.Duration(repos)), true
+}
+
+// constructionOrder returns the rules ordered by priority, with the
+// dependencies of a destination repo taking its priority if higher. Among
+// repos of the same priority, the unfinished destination repos of the previous
+// cycle come first, preceded by their dependencies. Otherwise, the order of
+// the rules, which lists dependencies first, is kept.
+func constructionOrder(rules []config.RepositoryRule, unfinished map[string]time.Time) []config.RepositoryRule {
+	deps := map[string][]string{}
+	prioritized := false
+	for _, r := range rules {
+		for _, b := range r.Branches {
+			for _, d := range b.Dependencies {
+				deps[r.DestinationRepository] = append(deps[r.DestinationRepository], d.Repository)
+			}
+		}
+		prioritized = prioritized || r.Priority != 0
+	}
+	if len(unfinished) == 0 && !prioritized {
+		return rules
+	}
+
+	priority := map[string]int{}
+	var raise func(repo string, p int)
+	raise = func(repo string, p int) {
+		if q, found := priority[repo]; found && q >= p {
+			return
+		}
+		priority[repo] = p
+		for _, d := range deps[repo] {
+			raise(d, p)
+		}
+	}
+	for _, r := range rules {
+		raise(r.DestinationRepository, r.Priority)
+	}
+	first := map[string]bool{}
+	var mark func(repo string)
+	mark = func(repo string) {
+		if first[repo] {
+			return
+		}
+		first[repo] = true
+		for _, d := range deps[repo] {
+			mark(d)
+		}
+	}
+	for repo := range unfinished {
+		mark(repo)
+	}
+
+	ordered := make([]config.RepositoryRule, len(rules))
+	copy(ordered, rules)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i].DestinationRepository, ordered[j].DestinationRepository
+		if priority[a] != priority[b] {
+			return priority[a] > priority[b]
+		}
+		return first[a] && !first[b]
+	})
+	return ordered
+}
+
+// unfinishedDependency returns a dependency of the destination repo whose
+// construction was cut off by the cycle deadline, or the empty string.
+func (p *PublisherMunger) unfinishedDependency(repoRule config.RepositoryRule) string {
+	for _, b := range repoRule.Branches {
+		for _, d := range b.Dependencies {
+			if _, found := p.unfinished[d.Repository]; found {
+				return d.Repository
+			}
+		}
+	}
+	return ""
+}
+
+// skipUnfinished skips the given branches of the destination repo because of
+// the cycle deadline. The repo is constructed first in the next cycle.
+func (p *PublisherMunger) skipUnfinished(repoRule config.RepositoryRule, branches []config.BranchRule, reason string) {
+	if _, found := p.unfinished[repoRule.DestinationRepository]; !found {
+		p.plog.Errorf("Not finishing %s in this run: %s", repoRule.DestinationRepository, reason)
+		p.unfinished[repoRule.DestinationRepository] = time.Now()
+	}
+	for _, branchRule := range branches {
+		if !p.skippedBranch(branchRule.Source.Branch) {
+			p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, "cycle deadline: "+reason)
+		}
+	}
+}
+
+// withDeadline returns a context with the given deadline, or without one if it
+// is zero.
+func withDeadline(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestCycleBudget(t *testing.T) {
+	start := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	b := cycleBudget{deadline: start.Add(3 * time.Hour), repos: 3}
+	if d, ok := b.next("api", start); !ok || !d.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected the first repo to get 1h, got %v, %v", d, ok)
+	}
+	// the first repo finished early, leaving more time to the others
+	now := start.Add(10 * time.Minute)
+	if d, ok := b.next("client-go", now); !ok || !d.Equal(now.Add(85*time.Minute)) {
+		t.Errorf("expected the second repo to get 1h25m, got %v, %v", d, ok)
+	}
+	if _, ok := b.next("apimachinery", start.Add(3*time.Hour)); ok {
+		t.Errorf("expected no time left at the deadline")
+	}
+
+	// client-go needs 2h of the 3h, more than its share. Cut off in the first
+	// cycle, it gets all the time in the next one and finishes.
+	constructed := map[string]time.Duration{"api": 10 * time.Minute, "client-go": 2 * time.Hour, "apimachinery": 10 * time.Minute}
+	cycle := func(unfinished map[string]time.Time) map[string]time.Time {
+		b := cycleBudget{deadline: start.Add(3 * time.Hour), repos: 3, unfinished: unfinished}
+		now, cutOff := start, map[string]time.Time{}
+		for _, r := range constructionOrder([]config.RepositoryRule{{DestinationRepository: "api"}, {DestinationRepository: "client-go"}, {DestinationRepository: "apimachinery"}}, unfinished) {
+			repo := r.DestinationRepository
+			d, ok := b.next(repo, now)
+			if !ok {
+				cutOff[repo] = now
+				continue
+			}
+			if end := now.Add(constructed[repo]); end.After(d) {
+				cutOff[repo], now = d, d
+			} else {
+				now = end
+			}
+		}
+		return cutOff
+	}
+	unfinished := cycle(nil)
+	if _, found := unfinished["client-go"]; !found || len(unfinished) != 1 {
+		t.Fatalf("expected client-go to be cut off in the first cycle, got %v", unfinished)
+	}
+	if unfinished = cycle(unfinished); len(unfinished) != 0 {
+		t.Errorf("expected all repos to finish in the second cycle, got %v", unfinished)
+	}
+
+	unlimited := cycleBudget{}
+	if d, ok := unlimited.next("api", start); !ok || !d.IsZero() {
+		t.Errorf("expected no deadline without cycle timeout, got %v, %v", d, ok)
+	}
+}
+
+func TestConstructionOrder(t *testing.T) {
+	rule := func(repo string, deps ...string) config.RepositoryRule {
+		b := config.BranchRule{Name: "master"}
+		for _, d := range deps {
+			b.Dependencies = append(b.Dependencies, config.Dependency{Repository: d, Branch: "master"})
+		}
+		return config.RepositoryRule{DestinationRepository: repo, Branches: []config.BranchRule{b}}
+	}
+	rules := []config.RepositoryRule{
+		rule("apimachinery"),
+		rule("api", "apimachinery"),
+		rule("metrics"),
+		rule("client-go", "apimachinery", "api"),
+		rule("sample-controller", "client-go"),
+	}
+	names := func(rules []config.RepositoryRule) []string {
+		var ns []string
+		for _, r := range rules {
+			ns = append(ns, r.DestinationRepository)
+		}
+		return ns
+	}
+
+	if got := names(constructionOrder(rules, nil)); !reflect.DeepEqual(got, names(rules)) {
+		t.Errorf("expected the order of the rules, got %v", got)
+	}
+	expected := []string{"apimachinery", "api", "client-go", "metrics", "sample-controller"}
+	if got := names(constructionOrder(rules, map[string]time.Time{"client-go": {}})); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// client-go and its dependencies first, regardless of unfinished repos
+	rules[3].Priority = 10
+	expected = []string{"apimachinery", "api", "client-go", "metrics", "sample-controller"}
+	if got := names(constructionOrder(rules, map[string]time.Time{"metrics": {}})); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	rules[2].Priority = 20
+	expected = []string{"metrics", "apimachinery", "api", "client-go", "sample-controller"}
+	if got := names(constructionOrder(rules, nil)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// dependencyLicense is the license of a dependency of a destination branch.
+type dependencyLicense struct {
+	Dependency string
+	License    string
+}
+
+// licenseSignatures detect licenses by characteristic phrases, all of which
+// must be found. The first match wins, i.e. more specific ones come first.
+var licenseSignatures = []struct {
+	license string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"GNU AFFERO GENERAL PUBLIC LICENSE"}},
+	{"LGPL-3.0", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-2.1", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 2.1"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "2.0"}},
+	{"EPL-1.0", []string{"Eclipse Public License"}},
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"ISC", []string{"Permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"Unlicense", []string{"This is free and unencumbered software released into the public domain"}},
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// classifyLicense returns the SPDX identifier of the license text, or unknown.
+func classifyLicense(text string) string {
+	text = whitespace.ReplaceAllString(text, " ")
+	for _, s := range licenseSignatures {
+		found := true
+		for _, phrase := range s.phrases {
+			if !strings.Contains(text, phrase) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return s.license
+		}
+	}
+	return config.UnknownLicense
+}
+
+// dirLicense returns the license of the license files directly in dir, or
+// unknown. The boolean is false if there are none.
+func dirLicense(dir string) (string, bool, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", false, err
+	}
+	for _, info := range infos {
+		if info.IsDir() || !isLicenseFile(info.Name(), false) {
+			continue
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return "", false, err
+		}
+		if l := classifyLicense(string(bs)); l != config.UnknownLicense {
+			return l, true, nil
+		}
+	}
+	for _, info := range infos {
+		if !info.IsDir() && isLicenseFile(info.Name(), false) {
+			return config.UnknownLicense, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// scanDependencyLicenses returns the licenses of the dependencies of the
+// checkout in dir: the vendored packages with a license file, the vendored Go
+// packages without a license file in them or their parents, which have an
+// unknown license, and the modules required in go.mod which are not vendored,
+// looked up in the module cache of GOPATH. Modules missing in the cache have an
+// unknown license.
+func scanDependencyLicenses(dir, gopath string) ([]dependencyLicense, error) {
+	var deps []dependencyLicense
+	vendor := filepath.Join(dir, "vendor")
+	err := filepath.Walk(vendor, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || pth == vendor {
+			return nil
+		}
+		rel, err := filepath.Rel(vendor, pth)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		l, found, err := dirLicense(pth)
+		if err != nil {
+			return err
+		}
+		if !found {
+			// the packages below a reported parent are covered by it. The
+			// walk is lexical, i.e. parents come first.
+			for _, d := range deps {
+				if strings.HasPrefix(rel, d.Dependency+"/") {
+					return filepath.SkipDir
+				}
+			}
+			if hasGo, err := hasGoFiles(pth); err != nil || !hasGo {
+				return err
+			}
+			l = config.UnknownLicense
+		}
+		deps = append(deps, dependencyLicense{Dependency: rel, License: l})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	goMod, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, req := range parseGoModRequires(goMod) {
+		vendored := false
+		for _, d := range deps {
+			if d.Dependency == req.Path || strings.HasPrefix(d.Dependency, req.Path+"/") || strings.HasPrefix(req.Path, d.Dependency+"/") {
+				vendored = true
+				break
+			}
+		}
+		if vendored {
+			continue
+		}
+		l := config.UnknownLicense
+		if gopath != "" {
+			modDir := filepath.Join(gopath, "pkg", "mod", filepath.FromSlash(escapeModulePath(req.Path))+"@"+escapeModulePath(req.Version))
+			if ml, found, err := dirLicense(modDir); err == nil && found {
+				l = ml
+			}
+		}
+		deps = append(deps, dependencyLicense{Dependency: req.Path, License: l})
+	}
+	sort.Slice(deps, func(i, j int) bool { return deps[i].Dependency < deps[j].Dependency })
+	return deps, nil
+}
+
+// hasGoFiles returns whether there are .go files directly in dir.
+func hasGoFiles(dir string) (bool, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".go") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type moduleRequire struct {
+	Path    string
+	Version string
+}
+
+// parseGoModRequires returns the required modules of the go.mod content.
+func parseGoModRequires(goMod []byte) []moduleRequire {
+	var reqs []moduleRequire
+	inBlock := false
+	s := bufio.NewScanner(bytes.NewReader(goMod))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "require":
+			fields = fields[1:]
+		case !inBlock:
+			continue
+		}
+		if len(fields) >= 2 {
+			reqs = append(reqs, moduleRequire{Path: fields[0], Version: fields[1]})
+		}
+	}
+	return reqs
+}
+
+// escapeModulePath escapes upper case letters like the module cache does,
+// e.g. github.com/Azure to github.com/!azure.
+func escapeModulePath(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		if 'A' <= r && r <= 'Z' {
+			buf.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// parseDependencyLicenses parses "<dependency> <license>" lines of a license
+// scanner.
+func parseDependencyLicenses(out []byte) ([]dependencyLicense, error) {
+	var deps []dependencyLicense
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+		case 2:
+			deps = append(deps, dependencyLicense{Dependency: fields[0], License: fields[1]})
+		default:
+			return nil, fmt.Errorf("invalid line %q, expected <dependency> <license>", line)
+		}
+	}
+	return deps, nil
+}
+
+// checkDependencyLicenses fails if a dependency of the branch checked out in
+// the publisher's directory has a license which is not allowed, or only warns if
+// configured so.
+func (p *PublisherMunger) checkDependencyLicenses(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) error {
+	dl := repoRule.DependencyLicenses
+	if !dl.Enabled() {
+		return nil
+	}
+
+	var deps []dependencyLicense
+	var err error
+	if dl.Command != "" {
+		var out []byte
+		if out, err = p.command(ctx, "/bin/bash", "-c", dl.Command).Output(); err != nil {
+			return fmt.Errorf("license scanner failed for branch %s of %s: %v", branchRule.Name, repoRule.DestinationRepository, err)
+		}
+		deps, err = parseDependencyLicenses(out)
+	} else {
+		deps, err = scanDependencyLicenses(p.path("."), p.config.GoPath())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to scan dependency licenses of branch %s of %s: %v", branchRule.Name, repoRule.DestinationRepository, err)
+	}
+
+	var disallowed []string
+	for _, d := range deps {
+		if !dl.Allows(d.Dependency, d.License) {
+			disallowed = append(disallowed, fmt.Sprintf("%s (%s)", d.Dependency, d.License))
+		}
+	}
+	if len(disallowed) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("dependencies of branch %s of %s with disallowed licenses: %s", branchRule.Name, repoRule.DestinationRepository, strings.Join(disallowed, ", "))
+	if dl.WarnOnly {
+		p.plog.Warningf("%s", msg)
+		return nil
+	}
+	return fmt.Errorf("%s", msg)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestClassifyLicense(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Apache License\n  Version 2.0, January 2004", "Apache-2.0"},
+		{"Permission is hereby granted, free of charge, to any person", "MIT"},
+		{"Redistribution and use in source and binary forms, with or without\nmodification... Neither the name of Google Inc.", "BSD-3-Clause"},
+		{"Redistribution and use in source and binary forms, with or without", "BSD-2-Clause"},
+		{"GNU GENERAL PUBLIC LICENSE\n Version 3, 29 June 2007", "GPL-3.0"},
+		{"GNU LESSER GENERAL PUBLIC LICENSE\n Version 3, 29 June 2007 ... GNU GENERAL PUBLIC LICENSE", "LGPL-3.0"},
+		{"All rights reserved.", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := classifyLicense(tt.text); got != tt.want {
+			t.Errorf("classifyLicense(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseGoModRequires(t *testing.T) {
+	goMod := `module example.com/lib
+
+go 1.12
+
+require github.com/single/dep v1.0.0
+
+require (
+	github.com/BurntSushi/toml v0.3.1 // indirect
+	// a comment
+	golang.org/x/text v0.3.0
+)
+
+replace golang.org/x/text => ../text
+`
+	want := []moduleRequire{
+		{"github.com/single/dep", "v1.0.0"},
+		{"github.com/BurntSushi/toml", "v0.3.1"},
+		{"golang.org/x/text", "v0.3.0"},
+	}
+	if got := parseGoModRequires([]byte(goMod)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestScanDependencyLicenses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deplicenses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo, gopath := filepath.Join(dir, "repo"), filepath.Join(dir, "gopath")
+	for pth, content := range map[string]string{
+		"repo/go.mod": "module example.com/lib\n\nrequire (\n\tgithub.com/vendored/dep v1.0.0\n\tgithub.com/Cached/mod v0.1.0\n\tgithub.com/missing/mod v0.2.0\n)\n",
+		"repo/vendor/github.com/vendored/dep/LICENSE":          "Apache License\nVersion 2.0",
+		"repo/vendor/github.com/vendored/dep/sub/x.go":         "package sub",
+		"repo/vendor/github.com/other/gpl/COPYING":             "GNU GENERAL PUBLIC LICENSE\nVersion 2, June 1991",
+		"repo/vendor/github.com/unlicensed/dep/x.go":           "package dep",
+		"repo/vendor/github.com/unlicensed/dep/sub/y.go":       "package sub",
+		"gopath/pkg/mod/github.com/!cached/mod@v0.1.0/LICENSE": "Permission is hereby granted, free of charge",
+	} {
+		pth = filepath.Join(dir, filepath.FromSlash(pth))
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pth, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := scanDependencyLicenses(repo, gopath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []dependencyLicense{
+		{"github.com/Cached/mod", "MIT"},
+		{"github.com/missing/mod", "unknown"},
+		{"github.com/other/gpl", "GPL-2.0"},
+		{"github.com/unlicensed/dep", "unknown"},
+		{"github.com/vendored/dep", "Apache-2.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseDependencyLicenses(t *testing.T) {
+	got, err := parseDependencyLicenses([]byte("github.com/a/b MIT\n\ngolang.org/x/text BSD-3-Clause\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []dependencyLicense{{"github.com/a/b", "MIT"}, {"golang.org/x/text", "BSD-3-Clause"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := parseDependencyLicenses([]byte("github.com/a/b\n")); err == nil {
+		t.Errorf("expected error for a line without license")
+	}
+}
@@ -0,0 +1,383 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// diffRules is the diff-rules subcommand. It compares what two versions of
+// the rules publish and returns the exit code: 0 if they publish the same,
+// 1 if they differ and 2 on errors.
+func diffRules(args []string) int {
+	fs := flag.NewFlagSet("diff-rules", flag.ExitOnError)
+	configFile := fs.String("config", "", "the config file in yaml format, for the base package")
+	basePackage := fs.String("base-package", "", "the base package of the source directories. Defaults to the one of -config, or k8s.io")
+	sourceDir := fs.String("source-dir", "", "a clone of the source repository. If set, the source commits each branch would publish are compared as well")
+	since := fs.String("since", "", "with -source-dir, only source commits after this revision are compared, e.g. the last published one. Defaults to the whole history")
+	gitDir := fs.String("git-dir", ".", "the git repository rules given as <ref>:<path> are read from")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: %s diff-rules [flags] <old-rules> <new-rules>
+
+compares the repositories and branches published by two versions of the rules.
+Rules are files, URLs or <git-ref>:<path> in -git-dir, e.g. origin/master:rules.yaml.
+
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg := config.Config{}
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if *basePackage == "" {
+		*basePackage = "k8s.io"
+		if *configFile != "" {
+			*basePackage = config.DefaultBasePackage(&cfg)
+		}
+	}
+
+	var plans []rulesPlan
+	for _, arg := range fs.Args() {
+		rules, err := loadRulesVersion(*gitDir, arg, cfg.Network.TLS)
+		if err == nil {
+			err = rules.ExpandSourceDirs(*basePackage)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load rules %s: %v\n", arg, err)
+			return 2
+		}
+		plan, err := planRules(rules, *sourceDir, *since, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to plan rules %s: %v\n", arg, err)
+			return 2
+		}
+		plans = append(plans, plan)
+	}
+
+	if !printPlanDiff(os.Stdout, plans[0], plans[1]) {
+		fmt.Println("No differences.")
+		return 0
+	}
+	return 1
+}
+
+// loadRulesVersion loads the rules from a file, a URL or <ref>:<path> in the
+// git repository. URLs are read with the TLS settings.
+func loadRulesVersion(gitDir, arg string, t config.TLSConfig) (*config.RepositoryRules, error) {
+	ss := strings.SplitN(arg, ":", 2)
+	if _, err := os.Stat(arg); err == nil || len(ss) != 2 || strings.HasPrefix(ss[1], "//") {
+		return config.LoadRules(arg, t)
+	}
+	cmd := exec.Command("git", "show", arg)
+	cmd.Dir = gitDir
+	cmd.Stderr = os.Stderr
+	content, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from %s: %v", arg, gitDir, err)
+	}
+	if content, err = config.ToYAML(content, config.FormatOf(ss[1])); err != nil {
+		return nil, fmt.Errorf("invalid rules in %s: %v", arg, err)
+	}
+	return config.ParseRules(content, arg)
+}
+
+// rulesPlan is what rules publish: the plans of the branches by destination
+// repository and branch name. Skipped repositories are left out.
+type rulesPlan map[string]repoPlan
+
+type repoPlan struct {
+	Branches map[string]branchPlan
+	// Settings are the other fields of the repository rule, in YAML.
+	Settings map[string]string
+}
+
+type branchPlan struct {
+	// Skipped is why the branch is not published, e.g. skip-source-branches.
+	Skipped        string
+	Source         string
+	GoVersion      string
+	GoVersionFile  string
+	DependencyTool string
+	Pin            string
+	Aliases        []string
+	Dependencies   map[string]string
+	// SourceCommit is the latest source commit in the source directory, or at
+	// the pin. It is empty without a source clone.
+	SourceCommit string
+	// Commits are the source commits the branch publishes, as "<sha> <subject>",
+	// without those dropped by skip-source-commits and skip-source-commit-patterns.
+	Commits []string
+}
+
+// planRules returns the plan of the rules at the given time, resolving the
+// source commits after since in the source clone if given.
+func planRules(rules *config.RepositoryRules, sourceDir, since string, now time.Time) (rulesPlan, error) {
+	plan := rulesPlan{}
+	for _, r := range rules.Rules {
+		if r.Skip {
+			continue
+		}
+		repo := repoPlan{Branches: map[string]branchPlan{}, Settings: map[string]string{}}
+		settings := r
+		settings.Branches = nil
+		bs, err := yaml.Marshal(settings)
+		if err != nil {
+			return nil, err
+		}
+		fields := yaml.MapSlice{}
+		if err := yaml.Unmarshal(bs, &fields); err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			if k := fmt.Sprint(f.Key); k != "destination" && k != "branches" {
+				v, err := yaml.Marshal(f.Value)
+				if err != nil {
+					return nil, err
+				}
+				repo.Settings[k] = strings.TrimSpace(string(v))
+			}
+		}
+
+		for _, b := range r.Branches {
+			bp := branchPlan{
+				Source:         fmt.Sprintf("%s:%s", b.Source.Branch, b.Source.Dir),
+				GoVersion:      b.GoVersion,
+				GoVersionFile:  b.GoVersionFile,
+				DependencyTool: rules.DependencyTool(b),
+				Pin:            b.Pin,
+				Aliases:        b.Aliases,
+				Dependencies:   map[string]string{},
+			}
+			if b.Source.Repository != "" {
+				bp.Source = b.Source.Repository + "/" + bp.Source
+			}
+			for _, d := range b.Dependencies {
+				bp.Dependencies[d.Repository] = d.String()
+			}
+			if s := r.SunsetOf(b); !s.Stop().IsZero() && !now.Before(s.Stop()) {
+				bp.Skipped = "publishing stopped after " + s.StopPublishingAfter
+			} else if rules.SkipsSourceBranch(b.Source.Branch) {
+				bp.Skipped = "skip-source-branches"
+			}
+			if sourceDir != "" && b.Source.Repository == "" && bp.Skipped == "" {
+				if bp.SourceCommit, err = lastSourceCommit(sourceDir, b); err != nil {
+					return nil, fmt.Errorf("%s/%s: %v", r.DestinationRepository, b.Name, err)
+				}
+				if bp.Commits, err = publishedCommits(sourceDir, since, rules, b); err != nil {
+					return nil, fmt.Errorf("%s/%s: %v", r.DestinationRepository, b.Name, err)
+				}
+			}
+			repo.Branches[b.Name] = bp
+		}
+		plan[r.DestinationRepository] = repo
+	}
+	return plan, nil
+}
+
+// sourceRev returns the source revision the branch is published up to: the
+// pin if any, else the local or remote source branch.
+func sourceRev(sourceDir string, b config.BranchRule) (string, error) {
+	revs := []string{b.Source.Branch, "origin/" + b.Source.Branch}
+	if b.Pin != "" {
+		revs = []string{b.Pin}
+	}
+	for _, rev := range revs {
+		cmd := exec.Command("git", "rev-parse", "--verify", "-q", rev+"^{commit}")
+		cmd.Dir = sourceDir
+		if err := cmd.Run(); err == nil {
+			return rev, nil
+		}
+	}
+	return "", fmt.Errorf("source revision %s not found", revs[0])
+}
+
+// lastSourceCommit returns the latest commit changing the source directory of
+// the branch, up to the pin if any.
+func lastSourceCommit(sourceDir string, b config.BranchRule) (string, error) {
+	rev, err := sourceRev(sourceDir, b)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("git", "log", "-1", "--format=%H", rev, "--", b.Source.Dir)
+	cmd.Dir = sourceDir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the last commit of %s in %s: %v", rev, b.Source.Dir, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// publishedCommits returns the source commits changing the source directory
+// of the branch after since, up to the pin if any, which are not dropped by
+// the rules. They are returned newest first as "<sha> <subject>".
+func publishedCommits(sourceDir, since string, rules *config.RepositoryRules, b config.BranchRule) ([]string, error) {
+	rev, err := sourceRev(sourceDir, b)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"log", "--format=%x00%H%x00%B", rev}
+	if since != "" {
+		args = append(args, "^"+since)
+	}
+	cmd := exec.Command("git", append(args, "--", b.Source.Dir)...)
+	cmd.Dir = sourceDir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the commits of %s in %s: %v", rev, b.Source.Dir, err)
+	}
+	var commits []string
+	fields := strings.Split(string(out), "\x00")
+	for i := 1; i+1 < len(fields); i += 2 {
+		sha, message := fields[i], strings.TrimSpace(fields[i+1])
+		if rules.SkipsSourceCommit(sha, message) {
+			continue
+		}
+		commits = append(commits, sha+" "+strings.SplitN(message, "\n", 2)[0])
+	}
+	return commits, nil
+}
+
+// printPlanDiff prints how the plans differ and returns false if they do not.
+func printPlanDiff(w io.Writer, old, new rulesPlan) bool {
+	buf := bytes.NewBuffer(nil)
+	for _, name := range unionKeys(old, new) {
+		o, inOld := old[name]
+		n, inNew := new[name]
+		switch {
+		case !inOld:
+			fmt.Fprintf(buf, "+ %s: published, branches %s\n", name, strings.Join(sortedKeys(n.Branches), ", "))
+			continue
+		case !inNew:
+			fmt.Fprintf(buf, "- %s: no longer published\n", name)
+			continue
+		}
+		for _, k := range unionKeys(o.Settings, n.Settings) {
+			if o.Settings[k] != n.Settings[k] {
+				fmt.Fprintf(buf, "~ %s: %s %s -> %s\n", name, k, orNone(o.Settings[k]), orNone(n.Settings[k]))
+			}
+		}
+		for _, b := range unionKeys(o.Branches, n.Branches) {
+			ob, inOld := o.Branches[b]
+			nb, inNew := n.Branches[b]
+			key := name + "/" + b
+			switch {
+			case !inOld:
+				fmt.Fprintf(buf, "+ %s: published from %s\n", key, nb.Source)
+				continue
+			case !inNew:
+				fmt.Fprintf(buf, "- %s: no longer published\n", key)
+				continue
+			case ob.Skipped != nb.Skipped && nb.Skipped != "":
+				fmt.Fprintf(buf, "- %s: no longer published, %s\n", key, nb.Skipped)
+				continue
+			case ob.Skipped != nb.Skipped:
+				fmt.Fprintf(buf, "+ %s: published again from %s\n", key, nb.Source)
+				continue
+			}
+			changed := func(what, o, n string) {
+				if o != n {
+					fmt.Fprintf(buf, "~ %s: %s %s -> %s\n", key, what, orNone(o), orNone(n))
+				}
+			}
+			changed("source", ob.Source, nb.Source)
+			changed("go", ob.GoVersion, nb.GoVersion)
+			changed("go-version-file", ob.GoVersionFile, nb.GoVersionFile)
+			changed("dependency-tool", ob.DependencyTool, nb.DependencyTool)
+			changed("pin", ob.Pin, nb.Pin)
+			changed("aliases", strings.Join(ob.Aliases, ","), strings.Join(nb.Aliases, ","))
+			for _, d := range unionKeys(ob.Dependencies, nb.Dependencies) {
+				changed("dependency", ob.Dependencies[d], nb.Dependencies[d])
+			}
+			changed("published up to source commit", ob.SourceCommit, nb.SourceCommit)
+			printCommitsDiff(buf, key, ob.Commits, nb.Commits)
+		}
+	}
+	w.Write(buf.Bytes())
+	return buf.Len() > 0
+}
+
+// printCommitsDiff prints the source commits only one of the plans publishes.
+func printCommitsDiff(w io.Writer, key string, old, new []string) {
+	inOld, inNew := map[string]bool{}, map[string]bool{}
+	for _, c := range old {
+		inOld[c] = true
+	}
+	for _, c := range new {
+		inNew[c] = true
+	}
+	for _, c := range new {
+		if !inOld[c] {
+			fmt.Fprintf(w, "+ %s: publishes commit %s\n", key, c)
+		}
+	}
+	for _, c := range old {
+		if !inNew[c] {
+			fmt.Fprintf(w, "- %s: no longer publishes commit %s\n", key, c)
+		}
+	}
+}
+
+// unionKeys returns the sorted keys of two maps of the same type.
+func unionKeys(a, b interface{}) []string {
+	seen := map[string]bool{}
+	for _, m := range []interface{}{a, b} {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			seen[k.String()] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// sortedKeys returns the sorted keys of a map with string keys.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestPrintPlanDiff(t *testing.T) {
+	parse := func(s string) rulesPlan {
+		rules, err := config.ParseRules([]byte(s), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := planRules(rules, "", "", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plan
+	}
+	old := parse(`
+rules:
+- destination: apimachinery
+  branches:
+  - name: master
+    source: {branch: master, dir: staging/src/k8s.io/apimachinery}
+- destination: client-go
+  branches:
+  - name: master
+    go: 1.10.2
+    source: {branch: master, dir: staging/src/k8s.io/client-go}
+    dependencies:
+    - {repository: apimachinery, branch: master}
+  - name: release-1.9
+    source: {branch: release-1.9, dir: staging/src/k8s.io/client-go}
+  - name: release-1.8
+    source: {branch: release-1.8, dir: staging/src/k8s.io/client-go}
+- destination: sample
+  branches:
+  - name: master
+    source: {branch: master, dir: staging/src/k8s.io/sample}
+`)
+	new := parse(`
+rules:
+- destination: apimachinery
+  branches:
+  - name: master
+    source: {branch: master, dir: staging/src/k8s.io/apimachinery}
+- destination: client-go
+  library: true
+  branches:
+  - name: master
+    go: 1.11
+    source: {branch: master, dir: staging/src/k8s.io/client-go}
+    dependencies:
+    - {repository: apimachinery, tag: v0.1.0}
+  - name: release-1.10
+    source: {branch: release-1.10, dir: staging/src/k8s.io/client-go}
+  - name: release-1.8
+    stop-publishing-after: 2000-01-01
+    source: {branch: release-1.8, dir: staging/src/k8s.io/client-go}
+- destination: api
+  branches:
+  - name: master
+    source: {branch: master, dir: staging/src/k8s.io/api}
+- destination: sample
+  skipped: true
+  branches:
+  - name: master
+    source: {branch: master, dir: staging/src/k8s.io/sample}
+`)
+
+	buf := bytes.NewBuffer(nil)
+	if !printPlanDiff(buf, old, new) {
+		t.Fatal("expected differences")
+	}
+	expected := `+ api: published, branches master
+~ client-go: library <none> -> true
+~ client-go/master: go 1.10.2 -> 1.11
+~ client-go/master: dependency [repository apimachinery, branch master] -> [repository apimachinery, tag v0.1.0]
++ client-go/release-1.10: published from release-1.10:staging/src/k8s.io/client-go
+- client-go/release-1.8: no longer published, publishing stopped after 2000-01-01
+- client-go/release-1.9: no longer published
+- sample: no longer published
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	buf.Reset()
+	if printPlanDiff(buf, old, old) || buf.Len() > 0 {
+		t.Errorf("expected no differences, got:\n%s", buf)
+	}
+}
+
+func TestPlanRulesCommits(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	for _, d := range []string{"a", "b"} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := commitFile(t, git, "a/file", "1", "Initial a")
+	fix := commitFile(t, git, "a/file", "2", "Fix a")
+	commitFile(t, git, "b/file", "1", "Add b")
+	binary := commitFile(t, git, "a/binary", "3", "Add test binary")
+
+	plan := func(s string) rulesPlan {
+		rules, err := config.ParseRules([]byte(s), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := planRules(rules, dir, base, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plan
+	}
+	old := plan(`
+rules:
+- destination: a
+  branches:
+  - name: master
+    source: {branch: master, dir: a}
+`)
+	new := plan(`
+skip-source-commit-patterns: ["^Add [[:alpha:]]+ binary$"]
+rules:
+- destination: a
+  branches:
+  - name: master
+    source: {branch: master, dir: a}
+`)
+	if got, want := old["a"].Branches["master"].Commits, []string{binary + " Add test binary", fix + " Fix a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got commits %v, want %v", got, want)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	printPlanDiff(buf, old, new)
+	expected := "- a/master: no longer publishes commit " + binary + " Add test binary\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
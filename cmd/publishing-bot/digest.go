@@ -0,0 +1,185 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+const digestFileName = "publisher-digest.json"
+
+// Digest collects run results and mails a summary of them periodically. The
+// collected results are persisted such that they survive restarts.
+type Digest struct {
+	config config.EmailDigest
+	store  state.Store
+}
+
+type digestState struct {
+	Since time.Time   `json:"since"`
+	Runs  []RunResult `json:"runs,omitempty"`
+}
+
+// NewDigest returns a digest keeping its state in the state store.
+func NewDigest(cfg config.EmailDigest, store state.Store) *Digest {
+	return &Digest{config: cfg, store: store}
+}
+
+// Record adds the run result and sends the digest if it is due.
+func (d *Digest) Record(r RunResult) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	if s.Since.IsZero() {
+		s.Since = r.Start
+	}
+	s.Runs = append(s.Runs, r)
+
+	if now := time.Now(); now.Sub(s.Since) >= d.config.DigestInterval() {
+		if err := d.send(digestText(s.Since, now, s.Runs)); err != nil {
+			// keep the runs for the next attempt
+			if saveErr := d.save(s); saveErr != nil {
+				return fmt.Errorf("%v, and failed to save the digest state: %v", err, saveErr)
+			}
+			return err
+		}
+		s = digestState{Since: now}
+	}
+	return d.save(s)
+}
+
+func (d *Digest) load() (digestState, error) {
+	var s digestState
+	bs, err := d.store.Read(digestFileName)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+	return s, json.Unmarshal(bs, &s)
+}
+
+func (d *Digest) save(s digestState) error {
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return d.store.Write(digestFileName, bs)
+}
+
+func (d *Digest) send(body string) error {
+	var auth smtp.Auth
+	if d.config.Username != "" {
+		password, err := ioutil.ReadFile(d.config.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read SMTP password: %v", err)
+		}
+		host, _, _ := net.SplitHostPort(d.config.SMTPServer)
+		auth = smtp.PlainAuth("", d.config.Username, strings.TrimSpace(string(password)), host)
+	}
+
+	msg := bytes.NewBuffer(nil)
+	fmt.Fprintf(msg, "From: %s\r\n", d.config.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(d.config.To, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", strings.SplitN(body, "\n", 2)[0])
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	return smtp.SendMail(d.config.SMTPServer, auth, d.config.From, d.config.To, msg.Bytes())
+}
+
+// digestText summarizes the runs between since and until.
+func digestText(since, until time.Time, runs []RunResult) string {
+	type repoStats struct {
+		commits, tags, pushes int
+		latency, maxLatency   time.Duration
+	}
+	stats := map[string]*repoStats{}
+	var failures []RunResult
+	for _, r := range runs {
+		if r.Error != "" {
+			failures = append(failures, r)
+		}
+		for _, b := range r.Branches {
+			s, found := stats[b.Repository]
+			if !found {
+				s = &repoStats{}
+				stats[b.Repository] = s
+			}
+			if !b.Pushed {
+				continue
+			}
+			s.commits += b.Commits
+			s.tags += len(b.Tags)
+			if b.Commits == 0 {
+				continue
+			}
+			l := r.Latency(b)
+			s.pushes++
+			s.latency += l
+			if l > s.maxLatency {
+				s.maxLatency = l
+			}
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "Publishing digest %s - %s\n\n", since.Format("2006-01-02 15:04"), until.Format("2006-01-02 15:04"))
+	fmt.Fprintf(buf, "Runs: %d, failed: %d\n\n", len(runs), len(failures))
+
+	var repos []string
+	for repo := range stats {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	if len(repos) > 0 {
+		fmt.Fprintf(buf, "%-30s %8s %6s %14s %14s\n", "REPOSITORY", "COMMITS", "TAGS", "AVG LATENCY", "MAX LATENCY")
+	}
+	for _, repo := range repos {
+		s := stats[repo]
+		avg := time.Duration(0)
+		if s.pushes > 0 {
+			avg = s.latency / time.Duration(s.pushes)
+		}
+		fmt.Fprintf(buf, "%-30s %8d %6d %14s %14s\n", repo, s.commits, s.tags, avg.Truncate(time.Minute), s.maxLatency.Truncate(time.Minute))
+	}
+
+	if len(failures) > 0 {
+		fmt.Fprintf(buf, "\nFailures:\n")
+		for _, r := range failures {
+			class := ""
+			if r.Failure != nil && r.Failure.Class != "" {
+				class = "[" + r.Failure.Class + "] "
+			}
+			fmt.Fprintf(buf, "  %s: %s%s\n", r.End.Format("2006-01-02 15:04"), class, r.Error)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+// readOnlyStore is a state store failing every write.
+type readOnlyStore struct {
+	state.Store
+}
+
+func (readOnlyStore) Write(name string, content []byte) error {
+	return fmt.Errorf("read-only")
+}
+
+func TestDigestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a closed port, such that sending fails
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := l.Addr().String()
+	l.Close()
+
+	cfg := config.EmailDigest{SMTPServer: server, From: "bot@example.com", To: []string{"team@example.com"}, Interval: time.Hour}
+	store := state.Dir(dir)
+	d := NewDigest(cfg, store)
+	start := time.Now().Add(-2 * time.Hour)
+	if err := d.Record(RunResult{Start: start, Error: "failed to push"}); err == nil {
+		t.Errorf("Record() succeeded, want the send error")
+	}
+	s, err := d.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Runs) != 1 || !s.Since.Equal(start) {
+		t.Errorf("Record() kept %d runs since %v, want the run for the next attempt", len(s.Runs), s.Since)
+	}
+
+	d = NewDigest(cfg, readOnlyStore{store})
+	err = d.Record(RunResult{Start: start})
+	if err == nil || !strings.Contains(err.Error(), "failed to save the digest state: read-only") {
+		t.Errorf("Record() = %v, want the save error", err)
+	}
+	d = NewDigest(config.EmailDigest{SMTPServer: server, Interval: 24 * time.Hour}, readOnlyStore{store})
+	if err := d.Record(RunResult{Start: time.Now()}); err == nil || err.Error() != "read-only" {
+		t.Errorf("Record() = %v, want the save error", err)
+	}
+}
+
+func TestDigestText(t *testing.T) {
+	since := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	runs := []RunResult{
+		{
+			Start: since.Add(time.Hour),
+			End:   since.Add(2 * time.Hour),
+			Branches: []BranchResult{
+				{Repository: "client-go", Branch: "master", Commits: 3, Tags: []string{"v7.0.0"}, Pushed: true, SourceCommitTime: since},
+				{Repository: "api", Branch: "master", Pushed: true},
+			},
+		},
+		{
+			Start: since.Add(3 * time.Hour),
+			End:   since.Add(4 * time.Hour),
+			Error: "failed to push",
+		},
+	}
+	want := `Publishing digest 2018-03-01 00:00 - 2018-03-02 00:00
+
+Runs: 2, failed: 1
+
+REPOSITORY                      COMMITS   TAGS    AVG LATENCY    MAX LATENCY
+api                                   0      0             0s             0s
+client-go                             3      1         2h0m0s         2h0m0s
+
+Failures:
+  2018-03-01 04:00: failed to push
+`
+	if got := digestText(since, since.Add(24*time.Hour), runs); got != want {
+		t.Errorf("digestText() = \n%s\nwant:\n%s", got, want)
+	}
+}
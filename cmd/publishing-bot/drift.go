@@ -0,0 +1,329 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+const headStateFileName = "publisher-heads.json"
+
+// Reconcile modes of a drifted destination branch.
+const (
+	// ReconcileRebase publishes on top of the commits pushed by others. If the
+	// branch was rewritten, the commits of the bot missing on it are rebased
+	// on top.
+	ReconcileRebase = "rebase"
+	// ReconcileReset force-pushes the head last pushed by the bot, dropping
+	// the commits pushed by others.
+	ReconcileReset = "reset"
+)
+
+// HeadState records the destination branch heads last pushed by the bot, by
+// <destination>/<branch>, to detect commits pushed by others.
+type HeadState struct {
+	Heads map[string]string `json:"heads"`
+}
+
+// LoadHeadState reads the head state from the state store. A missing state is
+// empty.
+func LoadHeadState(store state.Store) (*HeadState, error) {
+	s := &HeadState{Heads: map[string]string{}}
+	bs, err := store.Read(headStateFileName)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(bs, s); err != nil {
+		return &HeadState{Heads: map[string]string{}}, err
+	}
+	if s.Heads == nil {
+		s.Heads = map[string]string{}
+	}
+	return s, nil
+}
+
+// Save writes the head state into the state store.
+func (s *HeadState) Save(store state.Store) error {
+	bs, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Write(headStateFileName, bs)
+}
+
+// originEnv returns the environment of git commands reading the destination
+// repo from origin, authenticated with its push token. Without push token in
+// dry-run mode, the read token is used, if any.
+func (p *PublisherMunger) originEnv(repo string) ([]string, error) {
+	tokenRef, err := p.config.PushTokenRef(repo)
+	if err != nil {
+		if p.config.DryRun {
+			return p.readEnv, nil
+		}
+		return nil, err
+	}
+	return p.credentialEnv(tokenRef, "")
+}
+
+// remoteHead returns the head of the branch on origin of the repo in the
+// publisher's directory, or the empty string if it does not exist.
+func (p *PublisherMunger) remoteHead(ctx context.Context, repo, branch string) (string, error) {
+	env, err := p.originEnv(repo)
+	if err != nil {
+		return "", err
+	}
+	out, err := p.outputWithTimeout(ctx, "fetch", func() *exec.Cmd {
+		cmd := exec.Command("git", "ls-remote", "--heads", "origin", "refs/heads/"+branch)
+		cmd.Env = env
+		return cmd
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list the head of %s on origin: %v", branch, err)
+	}
+	fs := strings.Fields(string(out))
+	if len(fs) == 0 {
+		return "", nil
+	}
+	return fs[0], nil
+}
+
+// driftOf describes how the remote head of the branch of the repo in the
+// publisher's directory differs from the head last pushed by the bot, or returns
+// the empty string if it does not.
+func (p *PublisherMunger) driftOf(ctx context.Context, repo, branch, pushed, remote string) (string, error) {
+	if remote == pushed {
+		return "", nil
+	}
+	if remote == "" {
+		return fmt.Sprintf("the branch was deleted on origin, last pushed at %s", pushed), nil
+	}
+	if p.command(ctx, "git", "cat-file", "-e", remote+"^{commit}").Run() != nil {
+		env, err := p.originEnv(repo)
+		if err != nil {
+			return "", err
+		}
+		err = p.runWithTimeout(ctx, "fetch", func() *exec.Cmd {
+			cmd := exec.Command("git", "fetch", "-q", "origin", "--no-tags", fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", branch, branch))
+			cmd.Env = env
+			return cmd
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch %s from origin: %v", branch, err)
+		}
+	}
+	if p.command(ctx, "git", "merge-base", "--is-ancestor", pushed, remote).Run() != nil {
+		return fmt.Sprintf("the branch was rewritten on origin from %s to %s, e.g. by a force-push", pushed, remote), nil
+	}
+	out, err := p.command(ctx, "git", "rev-list", "--count", pushed+".."+remote).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to count the commits on %s: %v", branch, err)
+	}
+	return fmt.Sprintf("%s commits were pushed by others on top of %s", strings.TrimSpace(string(out)), pushed), nil
+}
+
+// checkDrift skips the destination branch of the repo in the publisher's directory
+// if it was changed on origin since the bot pushed it last. It returns whether
+// it was skipped.
+func (p *PublisherMunger) checkDrift(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) (bool, error) {
+	key := repoRule.DestinationRepository + "/" + branchRule.Name
+	pushed := p.heads.Heads[key]
+	if pushed == "" {
+		return false, nil
+	}
+	remote, err := p.remoteHead(ctx, repoRule.DestinationRepository, branchRule.Name)
+	if err != nil {
+		return false, err
+	}
+	drift, err := p.driftOf(ctx, repoRule.DestinationRepository, branchRule.Name, pushed, remote)
+	if err != nil || drift == "" {
+		return false, err
+	}
+	p.plog.Errorf("Branch %s of %s changed outside of the bot: %s. Reconcile it with -reconcile %s -reconcile-mode %s|%s.",
+		branchRule.Name, repoRule.DestinationRepository, drift, key, ReconcileRebase, ReconcileReset)
+	p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, "changed outside of the bot: "+drift)
+	p.drifted = append(p.drifted, key)
+	return true, nil
+}
+
+// recordPushedHead records the head of the branch of the repo in the current
+// directory as pushed by the bot.
+func (p *PublisherMunger) recordPushedHead(ctx context.Context, repo, branch string) {
+	out, err := p.command(ctx, "git", "rev-parse", branch).Output()
+	if err != nil {
+		p.plog.Errorf("Failed to resolve pushed branch %s of %s: %v", branch, repo, err)
+		return
+	}
+	p.heads.Heads[repo+"/"+branch] = strings.TrimSpace(string(out))
+	if err := p.heads.Save(p.state); err != nil {
+		p.plog.Errorf("Failed to save head state: %v", err)
+	}
+}
+
+// reconcile resolves the drift of the destination branch given as
+// <destination>/<branch> with the given mode, after the operator confirmed it
+// on in unless confirmed is set. Afterwards, the branch is published again.
+func reconcile(ctx context.Context, cfg *config.Config, baseRepoPath, target, mode string, confirmed bool, in io.Reader) error {
+	if mode != ReconcileRebase && mode != ReconcileReset {
+		return fmt.Errorf("invalid reconcile mode %q, must be %s or %s", mode, ReconcileRebase, ReconcileReset)
+	}
+	ss := strings.SplitN(target, "/", 2)
+	if len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+		return fmt.Errorf("invalid branch %q, must be <destination>/<branch>", target)
+	}
+	repo, branch := ss[0], ss[1]
+
+	store, err := state.Parse(cfg.StateStore, baseRepoPath)
+	if err != nil {
+		return err
+	}
+	heads, err := LoadHeadState(store)
+	if err != nil {
+		return fmt.Errorf("failed to load head state: %v", err)
+	}
+	pushed := heads.Heads[target]
+	if pushed == "" {
+		return fmt.Errorf("no head of %s was pushed by the bot", target)
+	}
+	rules, err := config.LoadRules(cfg.RulesFile, cfg.Network.TLS)
+	if err != nil {
+		return err
+	}
+	p := New(cfg, baseRepoPath)
+	p.reposRules = *rules
+	if p.plog, err = NewPublisherLog(bytes.NewBuffer(nil), filepath.Join(baseRepoPath, "reconcile.log")); err != nil {
+		return err
+	}
+	defer p.plog.Flush()
+	var repoRule *config.RepositoryRule
+	for i := range rules.Rules {
+		if rules.Rules[i].DestinationRepository == repo {
+			repoRule = &rules.Rules[i]
+		}
+	}
+	if repoRule == nil {
+		return fmt.Errorf("no rule for destination repository %s", repo)
+	}
+	if err := p.setupCredentials(ctx); err != nil {
+		return err
+	}
+	p.dir = p.dstDir(*repoRule)
+
+	remote, err := p.remoteHead(ctx, repo, branch)
+	if err != nil {
+		return err
+	}
+	drift, err := p.driftOf(ctx, repo, branch, pushed, remote)
+	if err != nil {
+		return err
+	}
+	if drift == "" {
+		p.plog.Infof("Branch %s is at the head %s pushed by the bot, nothing to reconcile", target, pushed)
+		return nil
+	}
+	p.plog.Infof("Branch %s changed outside of the bot: %s", target, drift)
+
+	// the new head and whether pushing it to origin replaces commits.
+	head, force := pushed, remote != ""
+	if mode == ReconcileRebase && remote != "" {
+		if p.command(ctx, "git", "merge-base", "--is-ancestor", pushed, remote).Run() == nil {
+			head, force = remote, false
+		} else {
+			if err := p.plog.Run(p.command(ctx, "git", "checkout", "-q", "-B", "publisher-reconcile", pushed)); err != nil {
+				return err
+			}
+			// --preserve-merges was replaced by --rebase-merges in git 2.18
+			// and removed in git 2.34
+			rebaseMerges := "--rebase-merges"
+			if !gitAtLeast(ctx, 2, 18) {
+				rebaseMerges = "-p"
+			}
+			if err := p.plog.Run(p.command(ctx, "git", "rebase", rebaseMerges, remote)); err != nil {
+				p.command(ctx, "git", "rebase", "--abort").Run()
+				return fmt.Errorf("failed to rebase the commits of the bot onto %s, reconcile with %s instead: %v", remote, ReconcileReset, err)
+			}
+			out, err := p.command(ctx, "git", "rev-parse", "HEAD").Output()
+			if err != nil {
+				return err
+			}
+			head, force = strings.TrimSpace(string(out)), false
+		}
+	}
+	if force {
+		out, _ := p.command(ctx, "git", "log", "--oneline", pushed+".."+remote).Output()
+		p.plog.Infof("Resetting %s to %s drops these commits:\n%s", target, pushed, out)
+	}
+
+	if !confirmed {
+		fmt.Fprintf(os.Stderr, "Type %q to %s %s to %s: ", target, mode, target, head)
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		if strings.TrimSpace(line) != target {
+			return fmt.Errorf("not confirmed")
+		}
+	}
+
+	if head != remote {
+		if cfg.DryRun {
+			p.plog.Infof("Not pushing %s to %s in dry-run mode", head, target)
+			return nil
+		}
+		if err := p.pushHead(ctx, repo, branch, head, force); err != nil {
+			return err
+		}
+	}
+	heads.Heads[target] = head
+	if err := heads.Save(store); err != nil {
+		return fmt.Errorf("failed to save head state: %v", err)
+	}
+	p.plog.Infof("Reconciled %s at %s", target, head)
+	return nil
+}
+
+// pushHead pushes the commit to the branch on origin of the repo in the current
+// directory with the push token.
+func (p *PublisherMunger) pushHead(ctx context.Context, repo, branch, commit string, force bool) error {
+	tokenRef, err := p.config.PushTokenRef(repo)
+	if err != nil {
+		return err
+	}
+	env, err := p.credentialEnv(tokenRef, "")
+	if err != nil {
+		return err
+	}
+	args := []string{"push", "--no-tags", "origin", fmt.Sprintf("%s:refs/heads/%s", commit, branch)}
+	if force {
+		args = append(args, "--force")
+	}
+	return p.runWithTimeout(ctx, "push", func() *exec.Cmd {
+		cmd := exec.Command("git", args...)
+		cmd.Env = env
+		return cmd
+	})
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+func TestHeadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := LoadHeadState(state.Dir(dir))
+	if err != nil {
+		t.Fatalf("unexpected error loading missing state: %v", err)
+	}
+	s.Heads["client-go/master"] = "0123456789abcdef"
+	if err := s.Save(state.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = LoadHeadState(state.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Heads["client-go/master"]; got != "0123456789abcdef" {
+		t.Errorf("got head %q after loading", got)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, headStateFileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHeadState(state.Dir(dir)); err == nil {
+		t.Errorf("expected an error loading corrupt state")
+	}
+}
+
+func TestReconcileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		target, mode, wantErr string
+	}{
+		{"client-go/master", "merge", "invalid reconcile mode"},
+		{"client-go", ReconcileReset, "invalid branch"},
+		{"/master", ReconcileRebase, "invalid branch"},
+		{"client-go/master", ReconcileRebase, "no head of client-go/master was pushed"},
+	}
+	for _, tt := range tests {
+		err := reconcile(context.Background(), &config.Config{}, dir, tt.target, tt.mode, false, strings.NewReader(""))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s %s: got error %v, want %q", tt.target, tt.mode, err, tt.wantErr)
+		}
+	}
+}
+
+// driftRepos is a destination repo with its origin, whose master was pushed
+// by the bot at pushed on top of base, and an author clone changing origin.
+type driftRepos struct {
+	baseRepoPath, dst, origin string
+	cfg                       *config.Config
+	base, pushed              string
+	// git runs in the author clone
+	git func(args ...string) string
+}
+
+func newDriftRepos(t *testing.T) (*driftRepos, func()) {
+	dir, git, cleanup := gitRepo(t)
+	r := &driftRepos{
+		baseRepoPath: filepath.Join(dir, "work"),
+		origin:       filepath.Join(dir, "origin.git"),
+		git:          git,
+	}
+	r.dst = filepath.Join(r.baseRepoPath, "client-go")
+	r.base = commitFile(t, git, "README.md", "base\n", "base")
+	r.pushed = commitFile(t, git, "bot.txt", "bot\n", "published by the bot")
+	git("init", "-q", "--bare", r.origin)
+	git("push", "-q", r.origin, "master")
+	git("clone", "-q", r.origin, r.dst)
+	git("-C", r.dst, "config", "user.name", "Bot")
+	git("-C", r.dst, "config", "user.email", "bot@example.com")
+
+	tokenFile := filepath.Join(dir, "token")
+	rulesFile := filepath.Join(dir, "rules.yaml")
+	if err := ioutil.WriteFile(tokenFile, []byte("token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rules := "rules:\n- destination: client-go\n  branches:\n  - name: master\n    source:\n      branch: master\n      dir: staging/src/k8s.io/client-go\n"
+	if err := ioutil.WriteFile(rulesFile, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.cfg = &config.Config{TargetOrg: "kubernetes", SourceRepo: "kubernetes", TokenFile: "file:" + tokenFile, RulesFile: rulesFile}
+	heads := &HeadState{Heads: map[string]string{"client-go/master": r.pushed}}
+	if err := heads.Save(state.Dir(r.baseRepoPath)); err != nil {
+		t.Fatal(err)
+	}
+	return r, cleanup
+}
+
+// advance pushes a commit on top of the pushed head to origin.
+func (r *driftRepos) advance(t *testing.T) string {
+	commit := commitFile(t, r.git, "other.txt", "other\n", "pushed by others")
+	r.git("push", "-q", r.origin, "master")
+	return commit
+}
+
+// rewrite force-pushes a commit on top of the base to origin, dropping the
+// pushed head.
+func (r *driftRepos) rewrite(t *testing.T) string {
+	r.git("reset", "-q", "--hard", r.base)
+	commit := commitFile(t, r.git, "other.txt", "other\n", "rewritten by others")
+	r.git("push", "-q", "--force", r.origin, "master")
+	return commit
+}
+
+func (r *driftRepos) publisher() *PublisherMunger {
+	p := New(r.cfg, r.baseRepoPath)
+	p.plog = &plog{combinedBufAndFile: newSyncWriter(muxWriter{bytes.NewBuffer(nil)}), buf: bytes.NewBuffer(nil)}
+	p.dir = r.dst
+	return p
+}
+
+func TestDriftOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*driftRepos, *testing.T) string
+		want   string
+	}{
+		{"unchanged", func(r *driftRepos, t *testing.T) string { return r.pushed }, ""},
+		{"deleted", func(r *driftRepos, t *testing.T) string { return "" }, "the branch was deleted on origin"},
+		{"advanced", (*driftRepos).advance, "1 commits were pushed by others on top of "},
+		{"rewritten", (*driftRepos).rewrite, "the branch was rewritten on origin from "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, cleanup := newDriftRepos(t)
+			defer cleanup()
+			remote := tt.change(r, t)
+			p := r.publisher()
+			if remote != "" {
+				if head, err := p.remoteHead(context.Background(), "client-go", "master"); err != nil || head != remote {
+					t.Fatalf("got remote head %q, %v, want %q", head, err, remote)
+				}
+			}
+			// the commits of others are fetched from origin if missing
+			drift, err := p.driftOf(context.Background(), "client-go", "master", r.pushed, remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (tt.want == "") != (drift == "") || !strings.HasPrefix(drift, tt.want) {
+				t.Errorf("got drift %q, want %q", drift, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*driftRepos, *testing.T) string
+		mode   string
+		// check returns what is wrong with the new head on origin
+		check func(r *driftRepos, remote, head string) string
+	}{
+		{"rebase advanced", (*driftRepos).advance, ReconcileRebase, func(r *driftRepos, remote, head string) string {
+			if head != remote {
+				return "expected the commits of others to be kept"
+			}
+			return ""
+		}},
+		{"rebase rewritten", (*driftRepos).rewrite, ReconcileRebase, func(r *driftRepos, remote, head string) string {
+			if parent := r.git("-C", r.dst, "rev-parse", head+"^"); parent != remote {
+				return "expected the commits of the bot on top of the rewritten branch, got parent " + parent
+			}
+			if subject := r.git("-C", r.dst, "log", "-1", "--format=%s", head); subject != "published by the bot" {
+				return "expected the commit of the bot, got " + subject
+			}
+			return ""
+		}},
+		{"reset rewritten", (*driftRepos).rewrite, ReconcileReset, func(r *driftRepos, remote, head string) string {
+			if head != r.pushed {
+				return "expected the head pushed by the bot"
+			}
+			return ""
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, cleanup := newDriftRepos(t)
+			defer cleanup()
+			remote := tt.change(r, t)
+
+			if err := reconcile(context.Background(), r.cfg, r.baseRepoPath, "client-go/master", tt.mode, true, strings.NewReader("")); err != nil {
+				t.Fatal(err)
+			}
+			head := r.git("ls-remote", r.origin, "refs/heads/master")
+			head = strings.Fields(head)[0]
+			if problem := tt.check(r, remote, head); problem != "" {
+				t.Errorf("got head %s on origin: %s", head, problem)
+			}
+			heads, err := LoadHeadState(state.Dir(r.baseRepoPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if heads.Heads["client-go/master"] != head {
+				t.Errorf("got recorded head %s, want %s", heads.Heads["client-go/master"], head)
+			}
+		})
+	}
+}
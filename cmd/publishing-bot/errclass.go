@@ -0,0 +1,219 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Classes of the errors failed runs are bucketed into for trends in the
+// metrics, the run summary, events and issues.
+const (
+	// ErrorClassAuth is a missing or rejected credential.
+	ErrorClassAuth = "auth"
+	// ErrorClassNetwork is a failed connection, e.g. to GitHub or a proxy.
+	ErrorClassNetwork = "network"
+	// ErrorClassRateLimit is a request rejected because of API or git rate
+	// limits.
+	ErrorClassRateLimit = "rate-limit"
+	// ErrorClassDepRestore is a failed restoration or update of dependencies.
+	ErrorClassDepRestore = "dep-restore"
+	// ErrorClassConflict is a source commit which could not be applied.
+	ErrorClassConflict = "conflict"
+	// ErrorClassBuild is a failed build, smoke test or verification of
+	// generated files.
+	ErrorClassBuild = "build"
+	// ErrorClassPushRejected is a push rejected by the destination repo.
+	ErrorClassPushRejected = "push-rejected"
+	// ErrorClassDisk is a full or read-only disk.
+	ErrorClassDisk = "disk"
+	// ErrorClassRules are invalid rules. Rules which cannot be read are
+	// classified by the cause, e.g. network.
+	ErrorClassRules = "rules"
+	// ErrorClassUnknown is an error matching no class.
+	ErrorClassUnknown = "unknown"
+)
+
+// errorClassLogLines is the number of the last lines of the standard error of
+// the failed command classified if the error itself matches no class.
+const errorClassLogLines = 50
+
+// errorClasses are the lower case patterns of the classes in the errors and
+// the output of failed commands, most specific first: the causes in the
+// environment before the failing steps, and compiler errors before the
+// dependency errors they may follow. Patterns are error messages, not step
+// names, which the scripts echo and trace also on success.
+var errorClasses = []struct {
+	class    string
+	patterns []string
+}{
+	{ErrorClassDisk, []string{
+		"no space left on device",
+		"disk quota exceeded",
+		"read-only file system",
+	}},
+	// only validation messages, which do not wrap errors of other classes
+	{ErrorClassRules, []string{
+		"invalid rules in",
+		"invalid default branch rules",
+		"lint errors in the config and rules",
+		"conflicting rules files",
+		"no rules file",
+	}},
+	{ErrorClassRateLimit, []string{
+		"rate limit",
+		"returned error: 429",
+	}},
+	{ErrorClassAuth, []string{
+		"authentication failed",
+		"bad credentials",
+		"invalid username or password",
+		"could not read username",
+		"permission denied (publickey)",
+		"returned error: 401",
+		"returned error: 403",
+		"401 unauthorized",
+		"403 forbidden",
+		"failed to load token",
+	}},
+	{ErrorClassNetwork, []string{
+		"could not resolve host",
+		"temporary failure in name resolution",
+		"connection timed out",
+		"connection refused",
+		"connection reset",
+		"failed to connect",
+		"tls handshake timeout",
+		"i/o timeout",
+		"early eof",
+		"rpc failed",
+		"remote end hung up unexpectedly",
+		"returned error: 502",
+		"returned error: 503",
+		"returned error: 504",
+	}},
+	{ErrorClassConflict, []string{
+		"could not apply",
+		"merge conflict",
+		"conflict (",
+		"after resolving the conflicts",
+	}},
+	{ErrorClassPushRejected, []string{
+		"[rejected]",
+		"[remote rejected]",
+		"protected branch hook declined",
+		"pre-receive hook declined",
+	}},
+	{ErrorClassBuild, []string{
+		"generation command",
+		"stale generated files",
+		"smoke-test",
+		"build failed",
+		"cannot find package",
+		"undefined:",
+		"cannot use ",
+	}},
+	{ErrorClassDepRestore, []string{
+		"godep: error",
+		"error restoring",
+		"error downloading dep",
+		"unknown revision",
+		"invalid version",
+		"checksum mismatch",
+		"verifying module",
+		"missing go.sum entry",
+		"cannot find module providing package",
+	}},
+}
+
+// classifyError returns the class of the error. If the error matches no
+// class, the output of the failed command is classified.
+func classifyError(err, output string) string {
+	if class := errorClassOf(err); class != "" {
+		return class
+	}
+	if class := errorClassOf(output); class != "" {
+		return class
+	}
+	return ErrorClassUnknown
+}
+
+// errorClassOf returns the class of the first pattern found in the text, or
+// the empty string.
+func errorClassOf(text string) string {
+	text = strings.ToLower(text)
+	for _, c := range errorClasses {
+		for _, p := range c.patterns {
+			if strings.Contains(text, p) {
+				return c.class
+			}
+		}
+	}
+	return ""
+}
+
+type failureKey struct {
+	class, category string
+}
+
+// failureCounter counts the failed runs by error class and category.
+type failureCounter struct {
+	mutex  sync.Mutex
+	counts map[failureKey]int
+}
+
+// add counts the failure of the run, if any.
+func (c *failureCounter) add(f *RunFailure) {
+	if f == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts == nil {
+		c.counts = map[failureKey]int{}
+	}
+	c.counts[failureKey{f.Class, f.Category}]++
+}
+
+// WriteMetrics writes the counts in the Prometheus text format, nothing if no
+// run failed yet.
+func (c *failureCounter) WriteMetrics(buf *bytes.Buffer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.counts) == 0 {
+		return
+	}
+	var keys []failureKey
+	for k := range c.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].class != keys[j].class {
+			return keys[i].class < keys[j].class
+		}
+		return keys[i].category < keys[j].category
+	})
+	fmt.Fprintf(buf, "# HELP publishing_bot_run_failures_total Failed publishing runs by error class and category.\n")
+	fmt.Fprintf(buf, "# TYPE publishing_bot_run_failures_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(buf, "publishing_bot_run_failures_total{class=%q,category=%q} %d\n", k.class, k.category, c.counts[k])
+	}
+}
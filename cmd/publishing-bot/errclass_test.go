@@ -0,0 +1,79 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string
+		logs string
+		want string
+	}{
+		{"auth", "exit status 128", "remote: Invalid username or password.\nfatal: Authentication failed for 'https://github.com/kubernetes/api/'", ErrorClassAuth},
+		{"network", "fatal: unable to access 'https://github.com/kubernetes/api/': Could not resolve host: github.com", "", ErrorClassNetwork},
+		{"rate limit", "API rate limit exceeded", "", ErrorClassRateLimit},
+		{"rate limit before auth", "exit status 128", "remote: API rate limit exceeded\nfatal: unable to access: The requested URL returned error: 403", ErrorClassRateLimit},
+		{"dep-restore", "exit status 1", "+ go mod download\ngo: k8s.io/api@v0.0.0: unknown revision 0123456", ErrorClassDepRestore},
+		{"conflict", "exit status 1", "error: could not apply 0123456... Fix foo\nCONFLICT (content): Merge conflict in foo.go", ErrorClassConflict},
+		{"build", "stale generated files in branch master of api: zz_generated.go", "", ErrorClassBuild},
+		{"push-rejected", "exit status 1", " ! [rejected]        master -> master (fetch first)\nerror: failed to push some refs", ErrorClassPushRejected},
+		{"disk", "write /go/pkg/mod/cache: no space left on device", "", ErrorClassDisk},
+		{"error before logs", "Authentication failed", "no space left on device", ErrorClassAuth},
+		{"build after dep-restore", "exit status 1", "Running godep restore.\n+ godep restore\n+ go build ./...\npkg/foo.go:3: undefined: bar", ErrorClassBuild},
+		{"traced steps", "exit status 1", "Running godep restore.\n+ godep restore\n+ go mod download\n+ go mod tidy", ErrorClassUnknown},
+		{"dep-restore with network", "exit status 1", "go: verifying module: k8s.io/api@v0.0.0: dial tcp: i/o timeout", ErrorClassNetwork},
+		{"network push", "exit status 1", "error: RPC failed; HTTP 502\nfatal: the remote end hung up unexpectedly\nerror: failed to push some refs", ErrorClassNetwork},
+		{"rules", "invalid rules in /etc/rules.yaml: duplicate destination repository api", "", ErrorClassRules},
+		{"unreadable rules", "failed to read rules from custom resources: dial tcp 10.0.0.1:443: connect: connection refused", "", ErrorClassNetwork},
+		{"unknown", "exit status 1", "", ErrorClassUnknown},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err, tt.logs); got != tt.want {
+			t.Errorf("%s: classifyError() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFailureCounter(t *testing.T) {
+	var c failureCounter
+	var buf bytes.Buffer
+	c.WriteMetrics(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("expected no metrics without failures, got %q", buf.String())
+	}
+
+	c.add(nil)
+	c.add(&RunFailure{Category: FailureConstruct, Class: ErrorClassNetwork})
+	c.add(&RunFailure{Category: FailureConstruct, Class: ErrorClassNetwork})
+	c.add(&RunFailure{Category: FailureConflict, Class: ErrorClassConflict})
+	c.WriteMetrics(&buf)
+	for _, want := range []string{
+		"# TYPE publishing_bot_run_failures_total counter\n",
+		"publishing_bot_run_failures_total{class=\"conflict\",category=\"conflict\"} 1\n",
+		"publishing_bot_run_failures_total{class=\"network\",category=\"construct\"} 2\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in metrics:\n%s", want, buf.String())
+		}
+	}
+}
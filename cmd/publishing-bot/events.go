@@ -0,0 +1,296 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/redact"
+)
+
+// eventTimeout limits sending one event to one sink.
+const eventTimeout = 10 * time.Second
+
+// eventFlushTimeout limits waiting for the queued events at the end of a run,
+// such that dead sinks do not delay the next run. Events not sent by then are
+// dropped.
+const eventFlushTimeout = time.Minute
+
+// eventQueueSize is the number of events buffered for the sender. Events
+// emitted while the queue is full are dropped, the run never waits for slow
+// sinks.
+const eventQueueSize = 100
+
+// Event is sent to the event sinks as JSON.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Source is the source repository as <org>/<repo>.
+	Source    string `json:"source"`
+	TargetOrg string `json:"targetOrg"`
+	// UpstreamHash is the source commit the run publishes, if known.
+	UpstreamHash string `json:"upstreamHash,omitempty"`
+	Repository   string `json:"repository,omitempty"`
+	Branch       string `json:"branch,omitempty"`
+	Tag          string `json:"tag,omitempty"`
+	// SourceCommit is the latest source commit on the published branch.
+	SourceCommit string `json:"sourceCommit,omitempty"`
+	Error        string `json:"error,omitempty"`
+	// ErrorClass buckets the error by its cause, e.g. auth or network.
+	ErrorClass string `json:"errorClass,omitempty"`
+}
+
+// queuedEvent is an encoded event waiting to be sent.
+type queuedEvent struct {
+	typ  string
+	key  string
+	body []byte
+}
+
+// eventSender sends the queued events to the sinks in a worker goroutine.
+type eventSender struct {
+	sinks  []string
+	queue  chan queuedEvent
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newEventSender returns a sender using the HTTP client of ctx. It is not
+// canceled with ctx, such that the events of an interrupted run are sent.
+func newEventSender(ctx context.Context, sinks []string) *eventSender {
+	s := &eventSender{
+		sinks: sinks,
+		queue: make(chan queuedEvent, eventQueueSize),
+		done:  make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(withHTTPClient(context.Background(), httpClient(ctx)))
+	go s.run()
+	return s
+}
+
+// run sends the queued events until the queue is closed. Failures are
+// logged, they do not fail the run. Events queued after the sender was
+// canceled are dropped.
+func (s *eventSender) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		for _, sink := range s.sinks {
+			if s.ctx.Err() != nil {
+				break
+			}
+			if err := sendEvent(s.ctx, sink, e.key, e.body); err != nil {
+				glog.Errorf("Failed to send %s event to %s: %v", e.typ, redact.String(sink), err)
+			}
+		}
+	}
+}
+
+// close waits for the queued events to be sent, at most for the timeout.
+// Then the events still queued are dropped.
+func (s *eventSender) close(timeout time.Duration) {
+	close(s.queue)
+	defer s.cancel()
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+		glog.Errorf("Stopped sending events after %v, dropping %d queued events", timeout, len(s.queue))
+		s.cancel()
+		<-s.done
+	}
+}
+
+// emit queues the event for the sinks of the config, if its type is wanted.
+func (p *PublisherMunger) emit(e Event) {
+	if !p.config.Events.Wants(e.Type) {
+		return
+	}
+	e.Time = time.Now()
+	e.Source = p.config.SourceOrg + "/" + p.config.SourceRepo
+	e.TargetOrg = p.config.TargetOrg
+	e.UpstreamHash = p.result.UpstreamHash
+	bs, err := json.Marshal(e)
+	if err != nil {
+		glog.Errorf("Failed to encode %s event: %v", e.Type, err)
+		return
+	}
+	if p.events == nil {
+		p.events = newEventSender(context.Background(), p.config.Events.Sinks)
+	}
+	select {
+	case p.events.queue <- queuedEvent{e.Type, e.Repository, bs}:
+	default:
+		glog.Errorf("Dropped %s event, %d events are waiting to be sent", e.Type, eventQueueSize)
+	}
+}
+
+// startEvents starts sending the events of the run with the HTTP client of
+// ctx, if there are sinks.
+func (p *PublisherMunger) startEvents(ctx context.Context) {
+	if len(p.config.Events.Sinks) > 0 {
+		p.events = newEventSender(ctx, p.config.Events.Sinks)
+	}
+}
+
+// flushEvents waits for the emitted events to be sent, at most for
+// eventFlushTimeout.
+func (p *PublisherMunger) flushEvents() {
+	if p.events != nil {
+		p.events.close(eventFlushTimeout)
+		p.events = nil
+	}
+}
+
+// emitPublished queues the events of a pushed branch and its new tags.
+func (p *PublisherMunger) emitPublished(repo, branch string) {
+	for _, b := range p.result.Branches {
+		if b.Repository != repo || b.Branch != branch {
+			continue
+		}
+		p.emit(Event{Type: config.EventRepoPublished, Repository: repo, Branch: branch, SourceCommit: b.SourceCommit})
+		for _, t := range b.Tags {
+			p.emit(Event{Type: config.EventTagCreated, Repository: repo, Branch: branch, Tag: t, SourceCommit: b.SourceCommit})
+		}
+	}
+}
+
+// sendEvent sends the JSON event to the sink. The key, e.g. the destination
+// repository, partitions Kafka topics.
+func sendEvent(ctx context.Context, sink, key string, event []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, eventTimeout)
+	defer cancel()
+	u, err := url.Parse(sink)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "nats":
+		return publishNATS(ctx, u, event)
+	case "kafka+http", "kafka+https":
+		return produceKafka(ctx, u, key, event)
+	default:
+		return postEvent(ctx, sink, "application/json", event)
+	}
+}
+
+func postEvent(ctx context.Context, url, contentType string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := httpClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// produceKafka sends the event as record to the topic through the Kafka REST
+// proxy, with the URL kafka+http(s)://host:port/[<prefix>/]<topic>.
+func produceKafka(ctx context.Context, u *url.URL, key string, event []byte) error {
+	proxy := *u
+	proxy.Scheme = strings.TrimPrefix(u.Scheme, "kafka+")
+	dir, topic := path.Split(strings.TrimSuffix(u.Path, "/"))
+	proxy.Path = path.Join(dir, "topics", topic)
+	record := struct {
+		Key   string          `json:"key,omitempty"`
+		Value json.RawMessage `json:"value"`
+	}{key, event}
+	bs, err := json.Marshal(map[string]interface{}{"records": []interface{}{record}})
+	if err != nil {
+		return err
+	}
+	return postEvent(ctx, proxy.String(), "application/vnd.kafka.json.v2+json", bs)
+}
+
+// publishNATS publishes the event to the subject of the URL
+// nats://[user:password@|token@]host[:port]/<subject>. It waits for the
+// server to acknowledge the connection with a PONG.
+func publishNATS(ctx context.Context, u *url.URL, event []byte) error {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read server info: %v", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected server greeting %q", strings.TrimSpace(line))
+	}
+
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "publishing-bot", "lang": "go", "version": version}
+	if u.User != nil {
+		if pass, found := u.User.Password(); found {
+			opts["user"], opts["pass"] = u.User.Username(), pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	subject := strings.Replace(strings.Trim(u.Path, "/"), "/", ".", -1)
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "CONNECT %s\r\nPUB %s %d\r\n", connect, subject, len(event))
+	buf.Write(event)
+	buf.WriteString("\r\nPING\r\n")
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read acknowledgement: %v", err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
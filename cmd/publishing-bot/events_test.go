@@ -0,0 +1,192 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestEmit(t *testing.T) {
+	var got []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		got = append(got, e)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{SourceOrg: "kubernetes", SourceRepo: "kubernetes", TargetOrg: "k8s", Events: config.Events{
+		Sinks: []string{server.URL},
+		Types: []string{config.EventRepoPublished, config.EventTagCreated},
+	}}
+	p := New(cfg, "")
+	p.result.Branches = []BranchResult{
+		{Repository: "client-go", Branch: "master", SourceCommit: "abc", Tags: []string{"v1.0.0"}},
+		{Repository: "api", Branch: "master"},
+	}
+	p.emit(Event{Type: config.EventRunStarted})
+	p.emitPublished("client-go", "master")
+	p.flushEvents()
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %v", got)
+	}
+	if e := got[0]; e.Type != config.EventRepoPublished || e.Repository != "client-go" || e.SourceCommit != "abc" || e.Source != "kubernetes/kubernetes" || e.TargetOrg != "k8s" {
+		t.Errorf("unexpected published event %+v", e)
+	}
+	if e := got[1]; e.Type != config.EventTagCreated || e.Tag != "v1.0.0" {
+		t.Errorf("unexpected tag event %+v", e)
+	}
+}
+
+func TestEmitDoesNotWaitForSinks(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		got = append(got, e.Type)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	p := New(&config.Config{Events: config.Events{Sinks: []string{server.URL}}}, "")
+	p.emit(Event{Type: config.EventRunStarted})
+	p.emit(Event{Type: config.EventRunFinished})
+	close(release)
+	p.flushEvents()
+
+	if want := []string{config.EventRunStarted, config.EventRunFinished}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+}
+
+func TestProduceKafka(t *testing.T) {
+	var path, contentType string
+	var body map[string][]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	sink := strings.Replace(server.URL, "http://", "kafka+http://", 1) + "/proxy/publishing-events"
+	if err := sendEvent(context.Background(), sink, "client-go", []byte(`{"type":"repo-published"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/proxy/topics/publishing-events" {
+		t.Errorf("got path %q", path)
+	}
+	if contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("got content type %q", contentType)
+	}
+	if records := body["records"]; len(records) != 1 || records[0]["key"] != "client-go" || records[0]["value"].(map[string]interface{})["type"] != "repo-published" {
+		t.Errorf("got records %v", body)
+	}
+}
+
+func TestPublishNATS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		r := bufio.NewReader(conn)
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimSpace(line)
+			if line == "PING" {
+				conn.Write([]byte("PONG\r\n"))
+				break
+			}
+			lines = append(lines, line)
+		}
+		received <- lines
+		ioutil.ReadAll(r)
+	}()
+
+	sink := "nats://user:secret@" + l.Addr().String() + "/publishing/events"
+	if err := sendEvent(context.Background(), sink, "", []byte(`{"type":"run-started"}`)); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-received
+	if len(lines) != 3 {
+		t.Fatalf("expected CONNECT, PUB and payload, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[0], "CONNECT ") || !strings.Contains(lines[0], `"pass":"secret"`) {
+		t.Errorf("unexpected connect %q", lines[0])
+	}
+	if lines[1] != "PUB publishing.events 22" || lines[2] != `{"type":"run-started"}` {
+		t.Errorf("unexpected publish %q", lines[1:])
+	}
+}
+
+func TestEventSenderCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s := newEventSender(context.Background(), []string{server.URL})
+	for i := 0; i < 3; i++ {
+		s.queue <- queuedEvent{config.EventRunStarted, "", []byte("{}")}
+	}
+	start := time.Now()
+	s.close(100 * time.Millisecond)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected close to give up after the timeout, took %v", d)
+	}
+}
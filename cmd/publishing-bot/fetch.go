@@ -0,0 +1,275 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// sourceBranches returns the source branches which are published, sorted.
+func (p *PublisherMunger) sourceBranches() []string {
+	seen := map[string]bool{}
+	branches := []string{}
+	for _, repoRule := range p.reposRules.Rules {
+		for _, branchRule := range repoRule.Branches {
+			b := branchRule.Source.Branch
+			if seen[b] || p.skippedBranch(b) {
+				continue
+			}
+			seen[b] = true
+			branches = append(branches, b)
+		}
+	}
+	sort.Strings(branches)
+	return branches
+}
+
+// sourceFetchArgs returns the git arguments to fetch the given source branches
+// from the remote into its remote-tracking branches. Tips are the branches
+// which were fetched from origin before.
+func sourceFetchArgs(f config.FetchConfig, remote string, branches, tips []string) []string {
+	args := []string{"fetch"}
+	if f.NegotiationTips {
+		for _, b := range tips {
+			args = append(args, "--negotiation-tip=refs/remotes/origin/"+b)
+		}
+	}
+	args = append(args, remote)
+	switch {
+	case f.SingleBranch:
+		for _, b := range branches {
+			args = append(args, fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", b, remote, b))
+		}
+	case remote != "origin":
+		args = append(args, fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", remote))
+	}
+	return args
+}
+
+// fetchSource fetches the source branches into the source clone in dir from
+// the first branches remote which succeeds, falling back to origin, and the
+// tags from the tags remotes.
+func (p *PublisherMunger) fetchSource(ctx context.Context, dir string) error {
+	for _, r := range p.config.Fetch.Remotes {
+		if err := ensureRemoteIn(ctx, dir, r.Name, r.URL); err != nil {
+			return err
+		}
+	}
+	fetch := func(args []string) error {
+		return p.retryTransient(ctx, "", "fetch", func() error {
+			return p.runWithTimeout(ctx, "fetch", func() *exec.Cmd {
+				cmd := exec.Command("git", args...)
+				cmd.Dir = dir
+				cmd.Env = p.readEnv
+				return cmd
+			})
+		})
+	}
+
+	branches := p.sourceBranches()
+	tips := fetchedBranches(ctx, dir, branches)
+	if p.config.Fetch.NegotiationTips && !gitAtLeast(ctx, 2, 19) {
+		p.plog.Warningf("Ignoring negotiation-tips which needs git 2.19 or newer")
+		tips = nil
+	}
+	fetched := false
+	for _, r := range p.config.Fetch.BranchRemotes() {
+		if err := fetch(sourceFetchArgs(p.config.Fetch, r.Name, branches, tips)); err != nil {
+			p.plog.Warningf("Failed to fetch the source branches from %s, falling back: %v", r.Name, err)
+			continue
+		}
+		if ok, err := p.fastForwardOrigin(ctx, dir, r.Name, branches); err != nil {
+			return err
+		} else if !ok {
+			p.plog.Warningf("The source branches of %s lag behind origin, falling back", r.Name)
+			continue
+		}
+		fetched = true
+		break
+	}
+	if !fetched {
+		if err := fetch(sourceFetchArgs(p.config.Fetch, "origin", branches, tips)); err != nil {
+			return err
+		}
+	}
+	for _, r := range p.config.Fetch.TagRemotes() {
+		if err := fetch([]string{"fetch", "--no-tags", r.Name, "refs/tags/*:refs/tags/*"}); err != nil {
+			return fmt.Errorf("failed to fetch tags from %s: %v", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// fastForwardOrigin fast-forwards the remote-tracking branches of origin of the
+// given source branches in the source clone in dir to those fetched from the
+// remote, e.g. a mirror. It returns false without changing any ref if the
+// remote lacks a branch or does not contain the last fetched commit of origin,
+// i.e. if it lags behind or origin was force-pushed, such that origin is
+// fetched instead.
+func (p *PublisherMunger) fastForwardOrigin(ctx context.Context, dir, remote string, branches []string) (bool, error) {
+	git := func(args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		return cmd
+	}
+	heads := make([]string, len(branches))
+	for i, b := range branches {
+		out, err := git("rev-parse", "-q", "--verify", "refs/remotes/"+remote+"/"+b+"^{commit}").Output()
+		if err != nil {
+			return false, nil
+		}
+		heads[i] = strings.TrimSpace(string(out))
+		if git("rev-parse", "-q", "--verify", "refs/remotes/origin/"+b).Run() == nil &&
+			git("merge-base", "--is-ancestor", "refs/remotes/origin/"+b, heads[i]).Run() != nil {
+			return false, nil
+		}
+	}
+	for i, b := range branches {
+		if err := p.plog.Run(git("update-ref", "refs/remotes/origin/"+b, heads[i])); err != nil {
+			return false, fmt.Errorf("failed to fast-forward origin/%s to %s: %v", b, remote, err)
+		}
+	}
+	return true, nil
+}
+
+// checkSourcePath checks that the source path is a full clone of the source
+// repo, i.e. not shallow, with origin pointing to the source repo and without
+// local branches tracking other remotes.
+func checkSourcePath(ctx context.Context, cfg *config.Config) error {
+	dir := cfg.SourcePath
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--is-shallow-repository")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("source path %s is not a git checkout: %v: %s", dir, err, out)
+	}
+	if strings.TrimSpace(string(out)) == "true" {
+		return fmt.Errorf("source path %s is a shallow clone, but the full history is published. Fetch it with --unshallow", dir)
+	}
+
+	// the source branches are fetched from origin and created from its branches
+	cmd = exec.CommandContext(ctx, "git", "config", "--get", "remote.origin.url")
+	cmd.Dir = dir
+	out, _ = cmd.Output()
+	origin := strings.TrimSpace(string(out))
+	if origin == "" {
+		return fmt.Errorf("source path %s has no origin remote, expected one for %s", dir, cfg.SourceRepoURL())
+	}
+	if !cfg.IsSourceRepoURL(origin) {
+		return fmt.Errorf("source path %s is not a checkout of %s, its origin is %s", dir, cfg.SourceRepoURL(), origin)
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "config", "--get-regexp", `^branch\..*\.remote$`)
+	cmd.Dir = dir
+	out, _ = cmd.Output()
+	var foreign []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] == "origin" {
+			continue
+		}
+		branch := strings.TrimSuffix(strings.TrimPrefix(fields[0], "branch."), ".remote")
+		foreign = append(foreign, fmt.Sprintf("%s (%s)", branch, fields[1]))
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("source path %s has branches tracking other remotes than origin: %s", dir, strings.Join(foreign, ", "))
+	}
+	return nil
+}
+
+// ensureSourceBranch creates the local source branch in the source path from
+// its origin branch if it is missing. Existing branches are left as they are if
+// all their commits are on the origin branch, and reset to it otherwise.
+func (p *PublisherMunger) ensureSourceBranch(ctx context.Context, dir, branch string) error {
+	cmd := p.command(ctx, "git", "rev-parse", "-q", "--verify", "refs/heads/"+branch)
+	cmd.Dir = dir
+	if cmd.Run() == nil {
+		cmd = p.command(ctx, "git", "merge-base", "--is-ancestor", "refs/heads/"+branch, "refs/remotes/origin/"+branch)
+		cmd.Dir = dir
+		if cmd.Run() == nil {
+			return nil
+		}
+		p.plog.Warningf("Source branch %s in source path %s has commits not on origin/%s, resetting it", branch, dir, branch)
+		cmd = p.command(ctx, "git", "branch", "-f", "--no-track", branch, "refs/remotes/origin/"+branch)
+		cmd.Dir = dir
+		if err := p.plog.Run(cmd); err != nil {
+			return fmt.Errorf("failed to reset source branch %s in source path %s to its origin branch: %v", branch, dir, err)
+		}
+		return nil
+	}
+	cmd = p.command(ctx, "git", "branch", "--no-track", branch, "refs/remotes/origin/"+branch)
+	cmd.Dir = dir
+	if err := p.plog.Run(cmd); err != nil {
+		return fmt.Errorf("source branch %s not found in source path %s, neither as local nor as origin branch", branch, dir)
+	}
+	return nil
+}
+
+// ensureRemoteIn adds the remote with the URL to the repo in dir, or updates its URL.
+func ensureRemoteIn(ctx context.Context, dir, name, url string) error {
+	cmd := exec.CommandContext(ctx, "git", "remote", "set-url", name, url)
+	cmd.Dir = dir
+	if err := cmd.Run(); err == nil {
+		return nil
+	}
+	cmd = exec.CommandContext(ctx, "git", "remote", "add", name, url)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add remote %s: %v: %s", name, err, out)
+	}
+	return nil
+}
+
+// fetchedBranches returns those of the given branches which were fetched from
+// origin into the repo in dir before.
+func fetchedBranches(ctx context.Context, dir string, branches []string) []string {
+	fetched := []string{}
+	for _, b := range branches {
+		cmd := exec.CommandContext(ctx, "git", "rev-parse", "-q", "--verify", "refs/remotes/origin/"+b)
+		cmd.Dir = dir
+		if err := cmd.Run(); err == nil {
+			fetched = append(fetched, b)
+		}
+	}
+	return fetched
+}
+
+// configureProtocol writes the git wire protocol version into the config of the
+// repo in dir.
+func (p *PublisherMunger) configureProtocol(ctx context.Context, dir string) error {
+	v := p.config.Fetch.ProtocolVersion
+	if v == 0 {
+		return nil
+	}
+	if !gitAtLeast(ctx, 2, 18) {
+		p.plog.Warningf("Ignoring protocol-version %d which needs git 2.18 or newer", v)
+		return nil
+	}
+	cmd := p.command(ctx, "git", "config", "protocol.version", strconv.Itoa(v))
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set protocol.version in %s: %v: %s", dir, err, out)
+	}
+	return nil
+}
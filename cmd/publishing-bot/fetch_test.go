@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestSourceFetchArgs(t *testing.T) {
+	branches := []string{"master", "release-1.9"}
+	tips := []string{"master"}
+	tests := []struct {
+		name  string
+		fetch config.FetchConfig
+		want  []string
+	}{
+		{"default", config.FetchConfig{}, []string{"fetch", "origin"}},
+		{"single branch", config.FetchConfig{SingleBranch: true}, []string{
+			"fetch", "origin",
+			"+refs/heads/master:refs/remotes/origin/master",
+			"+refs/heads/release-1.9:refs/remotes/origin/release-1.9",
+		}},
+		{"negotiation tips", config.FetchConfig{NegotiationTips: true}, []string{
+			"fetch", "--negotiation-tip=refs/remotes/origin/master", "origin",
+		}},
+	}
+	for _, tt := range tests {
+		if got := sourceFetchArgs(tt.fetch, "origin", branches, tips); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	mirrorTests := []struct {
+		name  string
+		fetch config.FetchConfig
+		want  []string
+	}{
+		{"mirror", config.FetchConfig{}, []string{"fetch", "mirror", "+refs/heads/*:refs/remotes/mirror/*"}},
+		{"single branch mirror", config.FetchConfig{SingleBranch: true}, []string{
+			"fetch", "mirror",
+			"+refs/heads/master:refs/remotes/mirror/master",
+			"+refs/heads/release-1.9:refs/remotes/mirror/release-1.9",
+		}},
+	}
+	for _, tt := range mirrorTests {
+		if got := sourceFetchArgs(tt.fetch, "mirror", branches, tips); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCheckSourcePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	src := filepath.Join(dir, "kubernetes")
+	git(dir, "init", "-q", src)
+	git(src, "commit", "-q", "--allow-empty", "-m", "a")
+	git(src, "commit", "-q", "--allow-empty", "-m", "b")
+
+	cfg := &config.Config{GithubHost: "github.com", SourceOrg: "kubernetes", SourceRepo: "kubernetes", SourcePath: src}
+	ctx := context.Background()
+	if err := checkSourcePath(ctx, cfg); err == nil || !strings.Contains(err.Error(), "no origin remote") {
+		t.Errorf("expected an error about the missing remote, got %v", err)
+	}
+	git(src, "remote", "add", "origin", "https://github.com/fork/kubernetes.git")
+	if err := checkSourcePath(ctx, cfg); err == nil || !strings.Contains(err.Error(), "not a checkout of") {
+		t.Errorf("expected an error about the wrong remote, got %v", err)
+	}
+	// the source repo as another remote than origin is not enough
+	git(src, "remote", "add", "upstream", "git@github.com:kubernetes/kubernetes.git")
+	if err := checkSourcePath(ctx, cfg); err == nil || !strings.Contains(err.Error(), "its origin is https://github.com/fork/kubernetes.git") {
+		t.Errorf("expected an error about the fork as origin, got %v", err)
+	}
+	git(src, "remote", "set-url", "origin", "git@github.com:kubernetes/kubernetes.git")
+	git(src, "remote", "set-url", "upstream", "https://github.com/fork/kubernetes.git")
+	if err := checkSourcePath(ctx, cfg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	git(src, "config", "branch.master.remote", "origin")
+	if err := checkSourcePath(ctx, cfg); err != nil {
+		t.Errorf("unexpected error for a branch tracking origin: %v", err)
+	}
+	git(src, "config", "branch.release-1.10.remote", "upstream")
+	if err := checkSourcePath(ctx, cfg); err == nil || !strings.Contains(err.Error(), "release-1.10 (upstream)") {
+		t.Errorf("expected an error about the branch tracking the fork, got %v", err)
+	}
+	git(src, "config", "--unset", "branch.release-1.10.remote")
+
+	shallow := filepath.Join(dir, "shallow")
+	git(dir, "clone", "-q", "--depth", "1", "file://"+src, shallow)
+	git(shallow, "remote", "set-url", "origin", "https://github.com/kubernetes/kubernetes")
+	cfg.SourcePath = shallow
+	if err := checkSourcePath(ctx, cfg); err == nil || !strings.Contains(err.Error(), "shallow") {
+		t.Errorf("expected an error about the shallow clone, got %v", err)
+	}
+}
+
+func TestEnsureSourceBranch(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	a := commitFile(t, git, "a", "1", "a")
+	b := commitFile(t, git, "a", "2", "b")
+	git("checkout", "-q", "-b", "local", a)
+	c := commitFile(t, git, "a", "3", "c")
+	git("checkout", "-q", "master")
+
+	buf := bytes.NewBuffer(nil)
+	p := &PublisherMunger{plog: &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf}}
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		origin  string
+		local   string
+		want    string
+		wantErr bool
+	}{
+		{"up to date", b, b, b, false},
+		{"behind origin", b, a, a, false},
+		{"local commits", b, c, b, false},
+		{"missing", b, "", b, false},
+		{"missing origin", "", c, "", true},
+	}
+	for _, tt := range tests {
+		git("update-ref", "-d", "refs/remotes/origin/release")
+		git("update-ref", "-d", "refs/heads/release")
+		if tt.origin != "" {
+			git("update-ref", "refs/remotes/origin/release", tt.origin)
+		}
+		if tt.local != "" {
+			git("update-ref", "refs/heads/release", tt.local)
+		}
+		err := p.ensureSourceBranch(ctx, dir, "release")
+		if err != nil {
+			if !tt.wantErr {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		} else if tt.wantErr {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if got := git("rev-parse", "refs/heads/release"); got != tt.want {
+			t.Errorf("%s: expected release at %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestFastForwardOrigin(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	a := commitFile(t, git, "a", "1", "a")
+	b := commitFile(t, git, "a", "2", "b")
+	c := commitFile(t, git, "a", "3", "c")
+
+	buf := bytes.NewBuffer(nil)
+	p := &PublisherMunger{plog: &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf}}
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		origin     string
+		mirror     string
+		want       bool
+		wantOrigin string
+	}{
+		{"mirror ahead", b, c, true, c},
+		{"mirror up to date", c, c, true, c},
+		{"mirror behind", c, a, false, c},
+		{"mirror without branch", b, "", false, b},
+		{"first fetch", "", b, true, b},
+	}
+	for _, tt := range tests {
+		git("update-ref", "-d", "refs/remotes/origin/master")
+		git("update-ref", "-d", "refs/remotes/mirror/master")
+		if tt.origin != "" {
+			git("update-ref", "refs/remotes/origin/master", tt.origin)
+		}
+		if tt.mirror != "" {
+			git("update-ref", "refs/remotes/mirror/master", tt.mirror)
+		}
+		ok, err := p.fastForwardOrigin(ctx, dir, "mirror", []string{"master"})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, ok)
+		}
+		if got := git("rev-parse", "refs/remotes/origin/master"); got != tt.wantOrigin {
+			t.Errorf("%s: expected origin/master at %s, got %s", tt.name, tt.wantOrigin, got)
+		}
+	}
+}
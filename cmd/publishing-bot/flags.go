@@ -0,0 +1,99 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// envFlagPrefix is the prefix of the environment variables of the flags.
+const envFlagPrefix = "PUBLISHING_BOT_"
+
+// flagEnv returns the environment variable of the given flag, e.g.
+// PUBLISHING_BOT_TARGET_ORG for target-org.
+func flagEnv(name string) string {
+	return envFlagPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// givenFlags sets the flags not given on the command line from their
+// environment variables and returns the origins of the flags given either
+// way, by flag name. Flags not given keep the config values.
+func givenFlags(fs *flag.FlagSet) (map[string]config.Origin, error) {
+	given := map[string]config.Origin{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = config.Origin{Kind: config.OriginFlag, Name: "-" + f.Name}
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, found := given[f.Name]; found || err != nil {
+			return
+		}
+		env := flagEnv(f.Name)
+		val, found := os.LookupEnv(env)
+		if !found {
+			return
+		}
+		if setErr := fs.Set(f.Name, val); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", val, env, setErr)
+			return
+		}
+		given[f.Name] = config.Origin{Kind: config.OriginEnv, Name: env}
+	})
+	return given, err
+}
+
+// rulesFileValue is a -rules-file flag which can be given multiple times, the
+// rules files being joined into a list merged by config.LoadRules.
+type rulesFileValue string
+
+func (v *rulesFileValue) String() string {
+	return string(*v)
+}
+
+func (v *rulesFileValue) Set(s string) error {
+	if *v != "" {
+		s = string(*v) + config.RulesFileSeparator + s
+	}
+	*v = rulesFileValue(s)
+	return nil
+}
+
+// rulesFileVar defines a -rules-file flag which can be given multiple times.
+func rulesFileVar(fs *flag.FlagSet, usage string) *string {
+	p := new(string)
+	fs.Var((*rulesFileValue)(p), "rules-file", usage+" (can be given multiple times, the rules are merged)")
+	return p
+}
+
+// parseFlags parses the command line arguments and sets the flags not given
+// from their environment variables. It exits on invalid values like
+// flag.ExitOnError.
+func parseFlags(fs *flag.FlagSet, args []string) map[string]config.Origin {
+	fs.Parse(args)
+	given, err := givenFlags(fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	return given
+}
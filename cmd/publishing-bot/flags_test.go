@@ -0,0 +1,77 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestGivenFlags(t *testing.T) {
+	if got, want := flagEnv("base-publish-script-path"), "PUBLISHING_BOT_BASE_PUBLISH_SCRIPT_PATH"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	for k, v := range map[string]string{
+		"PUBLISHING_BOT_TARGET_ORG": "from-env",
+		"PUBLISHING_BOT_SOURCE_ORG": "from-env",
+		"PUBLISHING_BOT_DRY_RUN":    "true",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	targetOrg := fs.String("target-org", "", "")
+	sourceOrg := fs.String("source-org", "", "")
+	sourceRepo := fs.String("source-repo", "kubernetes", "")
+	dryRun := fs.Bool("dry-run", false, "")
+	if err := fs.Parse([]string{"-source-org", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+	given, err := givenFlags(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *targetOrg != "from-env" || *sourceOrg != "from-flag" || *sourceRepo != "kubernetes" || !*dryRun {
+		t.Errorf("unexpected flag values: target-org=%s source-org=%s source-repo=%s dry-run=%v", *targetOrg, *sourceOrg, *sourceRepo, *dryRun)
+	}
+	expected := map[string]config.Origin{
+		"target-org": {Kind: config.OriginEnv, Name: "PUBLISHING_BOT_TARGET_ORG"},
+		"source-org": {Kind: config.OriginFlag, Name: "-source-org"},
+		"dry-run":    {Kind: config.OriginEnv, Name: "PUBLISHING_BOT_DRY_RUN"},
+	}
+	if len(given) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, given)
+	}
+	for k, o := range expected {
+		if given[k] != o {
+			t.Errorf("expected %s from %v, got %v", k, o, given[k])
+		}
+	}
+
+	os.Setenv("PUBLISHING_BOT_DRY_RUN", "maybe")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Bool("dry-run", false, "")
+	if _, err := givenFlags(fs); err == nil {
+		t.Errorf("expected an error for an invalid boolean")
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// backupBranchPrefix is the prefix of the branches the history of destination
+// branches rebuilt after a force-push of their source branch is kept under.
+const backupBranchPrefix = "publishing-bot-backup/"
+
+// checkSourceForcePush checks whether the source commit the destination branch
+// of the repo in the publisher's directory was published up to is still in the
+// history of its source branch. If not, the source branch was force-pushed or
+// rebased, and cherry-picking on top of the published history would garble the
+// branch. Then it fails with a report, or, in rebuild mode, returns the backup
+// branch the published history is kept under while the branch is constructed
+// from scratch.
+func (p *PublisherMunger) checkSourceForcePush(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) (string, error) {
+	published := p.lastPublishedSourceCommit(ctx, branchRule.Name)
+	if published == "" || p.isSourceAncestor(ctx, published, branchRule.Source.Branch) {
+		return "", nil
+	}
+	report := p.sourceForcePushReport(ctx, branchRule.Source.Branch, published)
+	if p.config.SourceForcePush != config.SourceForcePushRebuild {
+		return "", fmt.Errorf("source branch %s was force-pushed, not publishing branch %s of %s on top of the old history: %s. Set source-force-push to %s to construct it from scratch",
+			branchRule.Source.Branch, branchRule.Name, repoRule.DestinationRepository, report, config.SourceForcePushRebuild)
+	}
+	backup := backupBranch(branchRule.Name, time.Now())
+	p.plog.Warningf("Source branch %s was force-pushed: %s. Constructing branch %s of %s from scratch, keeping its history as %s.",
+		branchRule.Source.Branch, report, branchRule.Name, repoRule.DestinationRepository, backup)
+	return backup, nil
+}
+
+// sourceForcePushReport describes how the source branch diverged from the
+// published source commit.
+func (p *PublisherMunger) sourceForcePushReport(ctx context.Context, branch, published string) string {
+	git := func(args ...string) (string, error) {
+		cmd := p.command(ctx, "git", args...)
+		cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	head, _ := git("rev-parse", branch)
+	if _, err := git("cat-file", "-e", published+"^{commit}"); err != nil {
+		return fmt.Sprintf("the last published source commit %s is not in the source repository anymore, %s is at %s", published, branch, head)
+	}
+	base, err := git("merge-base", published, branch)
+	if err != nil || base == "" {
+		return fmt.Sprintf("the last published source commit %s shares no history with %s at %s", published, branch, head)
+	}
+	dropped, _ := git("rev-list", "--count", base+".."+published)
+	added, _ := git("rev-list", "--count", base+".."+branch)
+	report := fmt.Sprintf("%s published commits up to %s were replaced by %s commits up to %s on top of %s", dropped, published, added, head, base)
+	if log, err := git("log", "--oneline", "--max-count=20", base+".."+published); err == nil && log != "" {
+		p.plog.Infof("Published source commits not on %s anymore:\n%s", branch, log)
+	}
+	return report
+}
+
+// backupBranch returns the name of the branch the history of the destination
+// branch rebuilt at the given time is kept under.
+func backupBranch(branch string, t time.Time) string {
+	return backupBranchPrefix + branch + "/" + t.UTC().Format("20060102-150405")
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestCheckSourceForcePush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forcepush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	src, dst := filepath.Join(dir, "kubernetes"), filepath.Join(dir, "client-go")
+	for _, d := range []string{src, dst} {
+		git(dir, "init", "-q", d)
+	}
+	git(src, "commit", "-q", "--allow-empty", "-m", "a")
+	base := git(src, "rev-parse", "HEAD")
+	git(src, "commit", "-q", "--allow-empty", "-m", "b")
+	published := git(src, "rev-parse", "HEAD")
+	git(src, "branch", "-m", "master")
+	git(dst, "commit", "-q", "--allow-empty", "-m", "b\n\nKubernetes-commit: "+published)
+	git(dst, "update-ref", "refs/remotes/origin/master", "HEAD")
+	if err := os.Chdir(dst); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(&config.Config{SourceRepo: "kubernetes"}, dir)
+	if p.plog, err = NewPublisherLog(bytes.NewBuffer(nil), filepath.Join(dir, "publisher.log")); err != nil {
+		t.Fatal(err)
+	}
+	repoRule := config.RepositoryRule{DestinationRepository: "client-go"}
+	branchRule := config.BranchRule{Name: "master", Source: config.Source{Branch: "master"}}
+
+	git(src, "commit", "-q", "--allow-empty", "-m", "c")
+	if backup, err := p.checkSourceForcePush(context.Background(), repoRule, branchRule); err != nil || backup != "" {
+		t.Errorf("expected a fast-forward to pass, got %q, %v", backup, err)
+	}
+
+	// rewrite b and c
+	git(src, "reset", "-q", "--hard", base)
+	git(src, "commit", "-q", "--allow-empty", "-m", "b'")
+	_, err = p.checkSourceForcePush(context.Background(), repoRule, branchRule)
+	if err == nil || !strings.Contains(err.Error(), "1 published commits up to "+published+" were replaced by 1 commits") {
+		t.Errorf("expected a force-push error, got %v", err)
+	}
+
+	p.config.SourceForcePush = config.SourceForcePushRebuild
+	backup, err := p.checkSourceForcePush(context.Background(), repoRule, branchRule)
+	if err != nil || !strings.HasPrefix(backup, backupBranchPrefix+"master/") {
+		t.Errorf("expected a backup branch, got %q, %v", backup, err)
+	}
+}
+
+func TestBackupBranch(t *testing.T) {
+	if got, expected := backupBranch("release-1.10", time.Date(2018, 5, 4, 10, 30, 0, 0, time.UTC)), "publishing-bot-backup/release-1.10/20180504-103000"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// verifyGenerated runs the generation commands of the destination repo in the
+// publisher's directory with the constructed branch checked out. It fails if they
+// change any file, i.e. if generated files of the branch are stale.
+func (p *PublisherMunger) verifyGenerated(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule, env []string) error {
+	if len(repoRule.VerifyGenerated) == 0 {
+		return nil
+	}
+	p.plog.Infof("Verifying generated files of branch %s", branchRule.Name)
+	for i, script := range repoRule.VerifyGenerated {
+		script := script
+		err := p.runWithTimeout(ctx, "verify-generated", func() *exec.Cmd {
+			cmd := exec.Command("/bin/bash", "-xec", script)
+			cmd.Env = append([]string(nil), env...) // make mutable
+			return cmd
+		})
+		if err != nil {
+			return fmt.Errorf("generation command %d failed for branch %s of %s: %v", i+1, branchRule.Name, repoRule.DestinationRepository, err)
+		}
+	}
+	out, err := p.command(ctx, "git", "status", "--porcelain", "-z", "--untracked-files=all").Output()
+	if err != nil {
+		return fmt.Errorf("failed to get the status of branch %s of %s: %v", branchRule.Name, repoRule.DestinationRepository, err)
+	}
+	if stale := statusPaths(string(out)); len(stale) > 0 {
+		// do not clean up to allow debugging with kubectl-exec.
+		return fmt.Errorf("stale generated files in branch %s of %s: %s", branchRule.Name, repoRule.DestinationRepository, strings.Join(stale, ", "))
+	}
+	p.command(ctx, "git", "reset", "--hard").Run()
+	p.command(ctx, "git", "clean", "-f", "-f", "-d").Run()
+	return nil
+}
+
+// statusPaths returns the changed paths in the output of git status --porcelain -z.
+// Renames and copies are followed by their origin, which is skipped.
+func statusPaths(status string) []string {
+	var paths []string
+	entries := strings.Split(status, "\x00")
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		if len(e) < 4 {
+			continue
+		}
+		paths = append(paths, e[3:])
+		if e[0] == 'R' || e[0] == 'C' {
+			i++
+		}
+	}
+	return paths
+}
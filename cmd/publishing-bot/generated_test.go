@@ -0,0 +1,37 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatusPaths(t *testing.T) {
+	for _, tc := range []struct {
+		status   string
+		expected []string
+	}{
+		{"", nil},
+		{" M zz_generated.deepcopy.go\x00?? pkg/new_generated.go\x00", []string{"zz_generated.deepcopy.go", "pkg/new_generated.go"}},
+		{"R  b.go\x00a.go\x00 D c.go\x00", []string{"b.go", "c.go"}},
+	} {
+		if got := statusPaths(tc.status); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.status, tc.expected, got)
+		}
+	}
+}
@@ -19,17 +19,22 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"sort"
 	"strings"
 
 	"github.com/golang/glog"
 	"github.com/google/go-github/github"
 	"golang.org/x/oauth2"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/redact"
 )
 
-func githubClient(token string) *github.Client {
+func githubClient(ctx context.Context, token string) *github.Client {
 	// create github client
-	ctx := context.Background()
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
@@ -37,12 +42,14 @@ func githubClient(token string) *github.Client {
 	return github.NewClient(tc)
 }
 
-func ReportOnIssue(e error, logs, token, org, repo string, issue int) error {
-	ctx := context.Background()
-	client := githubClient(token)
+// ReportOnIssue comments the error with its class, the conflict report, if any,
+// and the logs on the issue, and deletes the earlier comments of the bot.
+func ReportOnIssue(ctx context.Context, e error, class string, report *ConflictReport, logs, token, org, repo string, issue int) error {
+	client := githubClient(ctx, token)
 
-	// filter out token, if it happens to be in the log (it shouldn't!)
-	logs = strings.Replace(logs, token, "XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX", -1)
+	// filter out the token and other credentials, if they happen to be in the
+	// logs or in the error (they shouldn't!)
+	redact.Register(token)
 
 	// who am I?
 	myself, resp, err := client.Users.Get(ctx, "")
@@ -54,7 +61,14 @@ func ReportOnIssue(e error, logs, token, org, repo string, issue int) error {
 	}
 
 	// create new newComment
-	body := transfromLogToGithubFormat(logs, 50, fmt.Sprintf("/reopen\n\nThe last publishing run failed: %v", e))
+	header := fmt.Sprintf("/reopen\n\nThe last publishing run failed: %v", e)
+	if class != "" {
+		header = fmt.Sprintf("/reopen\n\nThe last publishing run failed with a %s error: %v", class, e)
+	}
+	if report != nil {
+		header += "\n\n" + report.Markdown()
+	}
+	body := redact.String(transfromLogToGithubFormat(logs, 50, header))
 
 	newComment, resp, err := client.Issues.CreateComment(ctx, org, repo, issue, &github.IssueComment{
 		Body: &body,
@@ -98,9 +112,8 @@ func ReportOnIssue(e error, logs, token, org, repo string, issue int) error {
 	return nil
 }
 
-func CloseIssue(token, org, repo string, issue int) error {
-	ctx := context.Background()
-	client := githubClient(token)
+func CloseIssue(ctx context.Context, token, org, repo string, issue int) error {
+	client := githubClient(ctx, token)
 
 	_, resp, err := client.Issues.Edit(ctx, org, repo, issue, &github.IssueRequest{
 		State: github.String("closed"),
@@ -115,6 +128,71 @@ func CloseIssue(token, org, repo string, issue int) error {
 	return nil
 }
 
+// EnsureDefaultBranch sets the default branch of the repository if it differs.
+func EnsureDefaultBranch(ctx context.Context, token, org, repo, branch string) (bool, error) {
+	client := githubClient(ctx, token)
+
+	r, resp, err := client.Repositories.Get(ctx, org, repo)
+	if err != nil {
+		return false, fmt.Errorf("failed to get repository %s/%s: %v", org, repo, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to get repository %s/%s: HTTP code %d", org, repo, resp.StatusCode)
+	}
+	if r.GetDefaultBranch() == branch {
+		return false, nil
+	}
+
+	_, resp, err = client.Repositories.Edit(ctx, org, repo, &github.Repository{
+		Name:          github.String(repo),
+		DefaultBranch: github.String(branch),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to set default branch of %s/%s to %s: %v", org, repo, branch, err)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("failed to set default branch of %s/%s to %s: HTTP code %d", org, repo, branch, resp.StatusCode)
+	}
+	return true, nil
+}
+
+// RepositoryExists returns whether the repository exists and is visible with
+// the token.
+func RepositoryExists(ctx context.Context, token, org, repo string) (bool, error) {
+	return repositoryExists(ctx, githubClient(ctx, token), org, repo)
+}
+
+func repositoryExists(ctx context.Context, client *github.Client, org, repo string) (bool, error) {
+	_, resp, err := client.Repositories.Get(ctx, org, repo)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get repository %s/%s: %v", org, repo, err)
+	}
+	return true, nil
+}
+
+// CreateRepository creates an empty public repository in the org.
+func CreateRepository(ctx context.Context, token, org, repo, description string) error {
+	return createRepository(ctx, githubClient(ctx, token), org, repo, description)
+}
+
+func createRepository(ctx context.Context, client *github.Client, org, repo, description string) error {
+	r := &github.Repository{Name: github.String(repo)}
+	if description != "" {
+		r.Description = github.String(description)
+	}
+	_, resp, err := client.Repositories.Create(ctx, org, r)
+	if err != nil {
+		return fmt.Errorf("failed to create repository %s/%s: %v", org, repo, err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to create repository %s/%s: HTTP code %d", org, repo, resp.StatusCode)
+	}
+	return nil
+}
+
 func transfromLogToGithubFormat(original string, maxLines int, headings ...string) string {
 	logCount := 0
 	transformed := NewLogBuilderWithMaxBytes(65000, original).
@@ -138,3 +216,155 @@ func transfromLogToGithubFormat(original string, maxLines int, headings ...strin
 		Log()
 	return transformed
 }
+
+// EnsureMetadata reconciles the description, homepage, topics and archived state
+// of the repository. It returns the names of the changed fields.
+func EnsureMetadata(ctx context.Context, token, org, repo string, m config.RepositoryMetadata) ([]string, error) {
+	return ensureMetadata(ctx, githubClient(ctx, token), org, repo, m)
+}
+
+func ensureMetadata(ctx context.Context, client *github.Client, org, repo string, m config.RepositoryMetadata) ([]string, error) {
+	r, resp, err := client.Repositories.Get(ctx, org, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository %s/%s: %v", org, repo, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get repository %s/%s: HTTP code %d", org, repo, resp.StatusCode)
+	}
+	if r.GetArchived() {
+		// archived repositories are read-only
+		if !m.Archived {
+			glog.Warningf("Repository %s/%s is archived, but not configured to be", org, repo)
+		}
+		return nil, nil
+	}
+
+	var changed []string
+	edit := &github.Repository{Name: github.String(repo)}
+	if m.Description != "" && r.GetDescription() != m.Description {
+		edit.Description = github.String(m.Description)
+		changed = append(changed, "description")
+	}
+	if m.Homepage != "" && r.GetHomepage() != m.Homepage {
+		edit.Homepage = github.String(m.Homepage)
+		changed = append(changed, "homepage")
+	}
+	if len(changed) > 0 {
+		if err := editRepository(ctx, client, org, repo, edit); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.Topics != nil {
+		topics, _, err := client.Repositories.ListAllTopics(ctx, org, repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list topics of %s/%s: %v", org, repo, err)
+		}
+		if !sameStrings(topics, m.Topics) {
+			if _, _, err := client.Repositories.ReplaceAllTopics(ctx, org, repo, m.Topics); err != nil {
+				return nil, fmt.Errorf("failed to set topics of %s/%s: %v", org, repo, err)
+			}
+			changed = append(changed, "topics")
+		}
+	}
+
+	// last, because the repository becomes read-only
+	if m.Archived {
+		if err := editRepository(ctx, client, org, repo, &github.Repository{Name: github.String(repo), Archived: github.Bool(true)}); err != nil {
+			return nil, err
+		}
+		changed = append(changed, "archived")
+	}
+	return changed, nil
+}
+
+// IsArchived returns whether the repository is archived.
+func IsArchived(ctx context.Context, token, org, repo string) (bool, error) {
+	client := githubClient(ctx, token)
+	r, resp, err := client.Repositories.Get(ctx, org, repo)
+	if err != nil {
+		return false, fmt.Errorf("failed to get repository %s/%s: %v", org, repo, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to get repository %s/%s: HTTP code %d", org, repo, resp.StatusCode)
+	}
+	return r.GetArchived(), nil
+}
+
+// SetArchived archives or unarchives the repository.
+func SetArchived(ctx context.Context, token, org, repo string, archived bool) error {
+	return editRepository(ctx, githubClient(ctx, token), org, repo, &github.Repository{Name: github.String(repo), Archived: github.Bool(archived)})
+}
+
+func editRepository(ctx context.Context, client *github.Client, org, repo string, edit *github.Repository) error {
+	_, resp, err := client.Repositories.Edit(ctx, org, repo, edit)
+	if err != nil {
+		return fmt.Errorf("failed to edit repository %s/%s: %v", org, repo, err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to edit repository %s/%s: HTTP code %d", org, repo, resp.StatusCode)
+	}
+	return nil
+}
+
+// sameStrings returns true if a and b have the same elements, in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// AttachReleaseAsset uploads the asset to the release of the tag, creating the
+// release if it does not exist. An existing asset of the same name is kept. It
+// returns whether the asset was uploaded.
+func AttachReleaseAsset(ctx context.Context, token, org, repo, tag, name string, content []byte) (bool, error) {
+	client := githubClient(ctx, token)
+	release, resp, err := client.Repositories.GetReleaseByTag(ctx, org, repo, tag)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		release, _, err = client.Repositories.CreateRelease(ctx, org, repo, &github.RepositoryRelease{
+			TagName: github.String(tag),
+			Name:    github.String(tag),
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to create release %s of %s/%s: %v", tag, org, repo, err)
+		}
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get release %s of %s/%s: %v", tag, org, repo, err)
+	}
+
+	assets, _, err := client.Repositories.ListReleaseAssets(ctx, org, repo, release.GetID(), &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return false, fmt.Errorf("failed to list assets of release %s of %s/%s: %v", tag, org, repo, err)
+	}
+	for _, a := range assets {
+		if a.GetName() == name {
+			return false, nil
+		}
+	}
+
+	f, err := ioutil.TempFile("", name)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		return false, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return false, err
+	}
+	if _, _, err := client.Repositories.UploadReleaseAsset(ctx, org, repo, release.GetID(), &github.UploadOptions{Name: name}, f); err != nil {
+		return false, fmt.Errorf("failed to upload %s to release %s of %s/%s: %v", name, tag, org, repo, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// parseGitVersion returns the major and minor version in the output of
+// "git --version", e.g. "git version 2.1.4".
+func parseGitVersion(s string) (int, int, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return 0, 0, fmt.Errorf("unexpected git version %q", s)
+	}
+	parts := strings.SplitN(fields[2], ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unexpected git version %q", s)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected git version %q", s)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected git version %q", s)
+	}
+	return major, minor, nil
+}
+
+// gitAtLeast returns true if the installed git is at least major.minor. An
+// unknown version counts as older.
+func gitAtLeast(ctx context.Context, major, minor int) bool {
+	out, err := exec.CommandContext(ctx, "git", "--version").Output()
+	if err != nil {
+		return false
+	}
+	ma, mi, err := parseGitVersion(string(out))
+	if err != nil {
+		return false
+	}
+	return ma > major || ma == major && mi >= minor
+}
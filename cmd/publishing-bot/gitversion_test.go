@@ -0,0 +1,52 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseGitVersion(t *testing.T) {
+	tests := []struct {
+		s            string
+		major, minor int
+		wantErr      bool
+	}{
+		{"git version 2.1.4\n", 2, 1, false},
+		{"git version 2.39.2.windows.1", 2, 39, false},
+		{"git version 2.20", 2, 20, false},
+		{"git version 2", 0, 0, true},
+		{"hub version 2.1.4", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		major, minor, err := parseGitVersion(tt.s)
+		if (err != nil) != tt.wantErr || major != tt.major || minor != tt.minor {
+			t.Errorf("%q: expected %d.%d, error %v, got %d.%d, %v", tt.s, tt.major, tt.minor, tt.wantErr, major, minor, err)
+		}
+	}
+}
+
+func TestGitAtLeast(t *testing.T) {
+	if !gitAtLeast(context.Background(), 1, 0) {
+		t.Error("expected git to be at least 1.0")
+	}
+	if gitAtLeast(context.Background(), 1000, 0) {
+		t.Error("expected git to be older than 1000.0")
+	}
+}
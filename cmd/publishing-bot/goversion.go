@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// goVersionRegexp matches released Go versions like 1.10, 1.10.2 or 1.11rc1.
+var goVersionRegexp = regexp.MustCompile(`^[1-9][0-9]*\.[0-9]+(\.[0-9]+)?((rc|beta)[0-9]+)?$`)
+
+// kubernetesGoDependency is the entry of the Go version in
+// build/dependencies.yaml of Kubernetes.
+const kubernetesGoDependency = "golang: upstream version"
+
+// resolveGoVersion returns the Go version of the branch: the one in the
+// go-version-file of the source revision if set, otherwise go of the rule.
+func (p *PublisherMunger) resolveGoVersion(ctx context.Context, branchRule config.BranchRule, rev string) (string, error) {
+	if branchRule.GoVersionFile == "" {
+		return branchRule.GoVersion, nil
+	}
+	cmd := p.command(ctx, "git", "show", rev+":"+path.Clean(branchRule.GoVersionFile))
+	cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+	content, err := cmd.Output()
+	if err != nil {
+		if branchRule.GoVersion != "" {
+			p.plog.Infof("No %s in source revision %s, using go %s of the rules", branchRule.GoVersionFile, rev, branchRule.GoVersion)
+			return branchRule.GoVersion, nil
+		}
+		return "", fmt.Errorf("failed to read %s of source revision %s: %v", branchRule.GoVersionFile, rev, err)
+	}
+	v, err := goVersionFromFile(branchRule.GoVersionFile, content)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s in source revision %s: %v", branchRule.GoVersionFile, rev, err)
+	}
+	if v != branchRule.GoVersion {
+		p.plog.Infof("Using go %s of %s in source revision %s for branch %s", v, branchRule.GoVersionFile, rev, branchRule.Name)
+	}
+	return v, nil
+}
+
+// goVersionFromFile returns the Go version in a .go-version like file, or in
+// YAML files the version of the golang entry of Kubernetes'
+// build/dependencies.yaml.
+func goVersionFromFile(name string, content []byte) (string, error) {
+	v := strings.TrimSpace(string(content))
+	if ext := path.Ext(name); ext == ".yaml" || ext == ".yml" {
+		var deps struct {
+			Dependencies []struct {
+				Name    string `yaml:"name"`
+				Version string `yaml:"version"`
+			} `yaml:"dependencies"`
+		}
+		if err := yaml.Unmarshal(content, &deps); err != nil {
+			return "", err
+		}
+		v = ""
+		for _, d := range deps.Dependencies {
+			if d.Name == kubernetesGoDependency {
+				v = d.Version
+			}
+		}
+		if v == "" {
+			return "", fmt.Errorf("no %q dependency", kubernetesGoDependency)
+		}
+	}
+	v = strings.TrimPrefix(v, "go")
+	if !goVersionRegexp.MatchString(v) {
+		return "", fmt.Errorf("invalid go version %q", v)
+	}
+	return v, nil
+}
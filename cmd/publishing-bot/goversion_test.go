@@ -0,0 +1,52 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestGoVersionFromFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{".go-version", "1.10.2\n", "1.10.2", false},
+		{".go-version", "go1.11rc1", "1.11rc1", false},
+		{".go-version", "latest", "", true},
+		{"build/dependencies.yaml", `
+dependencies:
+  - name: "etcd"
+    version: 3.3.10
+  - name: "golang: upstream version"
+    version: 1.11.5
+    refPaths:
+    - path: build/build-image/cross/VERSION
+`, "1.11.5", false},
+		{"build/dependencies.yaml", "dependencies: []", "", true},
+	}
+	for _, tt := range tests {
+		got, err := goVersionFromFile(tt.name, []byte(tt.content))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %q: got error %v, want error %v", tt.name, tt.content, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %q: got %q, want %q", tt.name, tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// runHooks runs the given hooks of the destination repo in the current
+// directory, with the branch checked out. It stops at the first failing hook.
+func (p *PublisherMunger) runHooks(ctx context.Context, kind string, hooks []config.Hook, repoRule config.RepositoryRule, branchRule config.BranchRule) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	dstDir := p.dir
+	if dstDir == "" {
+		var err error
+		if dstDir, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	env := []string{
+		"PUBLISHER_BOT_HOOK=" + kind,
+		"PUBLISHER_BOT_SOURCE_REPO=" + p.config.SourceRepo,
+		"PUBLISHER_BOT_SOURCE_BRANCH=" + branchRule.Source.Branch,
+		"PUBLISHER_BOT_SOURCE_COMMIT=" + p.sourceCommitOf(ctx, branchRule.Name),
+		"PUBLISHER_BOT_DESTINATION_ORG=" + p.config.TargetOrg,
+		"PUBLISHER_BOT_DESTINATION_REPO=" + repoRule.DestinationRepository,
+		"PUBLISHER_BOT_DESTINATION_BRANCH=" + branchRule.Name,
+		"PUBLISHER_BOT_DESTINATION_DIR=" + dstDir,
+	}
+	for _, h := range hooks {
+		p.plog.Infof("Running %s hook %s for branch %s of %s", kind, h.Name, branchRule.Name, repoRule.DestinationRepository)
+		err := p.runWithTimeout(ctx, "hook", func() *exec.Cmd {
+			return hookCommand(h, dstDir, p.environ(), env)
+		})
+		if err != nil {
+			return fmt.Errorf("%s hook %s failed: %v", kind, h.Name, err)
+		}
+	}
+	return nil
+}
+
+// hookCommand returns the command running the hook script, either locally or in
+// the hook's container image with the destination repo mounted at /workspace.
+// The hook variables env are added to the environment environ.
+func hookCommand(h config.Hook, dstDir string, environ, env []string) *exec.Cmd {
+	if h.Image == "" {
+		cmd := exec.Command("/bin/bash", "-xec", h.Script)
+		cmd.Env = append(environ, env...)
+		cmd.Dir = dstDir
+		return cmd
+	}
+
+	args := []string{"run", "--rm", "-v", dstDir + ":/workspace", "-w", "/workspace"}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PUBLISHER_BOT_DESTINATION_DIR=") {
+			kv = "PUBLISHER_BOT_DESTINATION_DIR=/workspace"
+		}
+		args = append(args, "-e", kv)
+	}
+	args = append(args, h.Image)
+	if h.Script != "" {
+		args = append(args, "/bin/bash", "-xec", h.Script)
+	}
+	cmd := exec.Command("docker", args...)
+	cmd.Env = environ
+	return cmd
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestHookCommand(t *testing.T) {
+	env := []string{"PUBLISHER_BOT_HOOK=pre-push", "PUBLISHER_BOT_DESTINATION_DIR=/go/src/k8s.io/client-go"}
+	tests := []struct {
+		name     string
+		hook     config.Hook
+		wantArgs []string
+	}{
+		{"local", config.Hook{Name: "lint", Script: "make lint"}, []string{"/bin/bash", "-xec", "make lint"}},
+		{"image", config.Hook{Name: "lint", Image: "golang:1.10", Script: "make lint"}, []string{
+			"docker", "run", "--rm", "-v", "/go/src/k8s.io/client-go:/workspace", "-w", "/workspace",
+			"-e", "PUBLISHER_BOT_HOOK=pre-push", "-e", "PUBLISHER_BOT_DESTINATION_DIR=/workspace",
+			"golang:1.10", "/bin/bash", "-xec", "make lint",
+		}},
+		{"image entrypoint", config.Hook{Name: "scan", Image: "scanner:1"}, []string{
+			"docker", "run", "--rm", "-v", "/go/src/k8s.io/client-go:/workspace", "-w", "/workspace",
+			"-e", "PUBLISHER_BOT_HOOK=pre-push", "-e", "PUBLISHER_BOT_DESTINATION_DIR=/workspace",
+			"scanner:1",
+		}},
+	}
+	for _, tt := range tests {
+		cmd := hookCommand(tt.hook, "/go/src/k8s.io/client-go", os.Environ(), env)
+		if !reflect.DeepEqual(cmd.Args, tt.wantArgs) {
+			t.Errorf("%s: hookCommand() args = %q, want %q", tt.name, cmd.Args, tt.wantArgs)
+		}
+		if got := strings.Join(cmd.Env, "\n"); tt.hook.Image == "" && (!strings.Contains(got, env[0]) || !strings.Contains(got, env[1])) {
+			t.Errorf("%s: hookCommand() env misses the hook variables: %q", tt.name, cmd.Env)
+		}
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	commitFile(t, git, "a", "1", "Add a\n\nKubernetes-commit: 1111111111111111111111111111111111111111")
+
+	buf := new(bytes.Buffer)
+	p := &PublisherMunger{
+		config: &config.Config{SourceRepo: "kubernetes", TargetOrg: "k8s-publishing-bot"},
+		plog:   &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+	}
+	repoRule := config.RepositoryRule{DestinationRepository: "client-go"}
+	branchRule := config.BranchRule{Name: "master", Source: config.Source{Branch: "master"}}
+
+	hooks := []config.Hook{
+		{Name: "env", Script: `echo "${PUBLISHER_BOT_HOOK} ${PUBLISHER_BOT_SOURCE_COMMIT} ${PUBLISHER_BOT_DESTINATION_ORG}/${PUBLISHER_BOT_DESTINATION_REPO}@${PUBLISHER_BOT_DESTINATION_BRANCH} ${PUBLISHER_BOT_DESTINATION_DIR}" > env.out`},
+		{Name: "fail", Script: "false"},
+		{Name: "after", Script: "touch after.out"},
+	}
+	err := p.runHooks(context.Background(), "pre-push", hooks, repoRule, branchRule)
+	if err == nil || !strings.Contains(err.Error(), "pre-push hook fail failed") {
+		t.Errorf("runHooks() = %v, want the failure of hook fail", err)
+	}
+	bs, err := ioutil.ReadFile("env.out")
+	if err != nil {
+		t.Fatalf("the first hook did not run: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(bs)), "pre-push 1111111111111111111111111111111111111111 k8s-publishing-bot/client-go@master "+dir; got != want {
+		t.Errorf("the hook got %q, want %q", got, want)
+	}
+	if _, err := ioutil.ReadFile("after.out"); err == nil {
+		t.Errorf("the hook after the failing one ran")
+	}
+
+	if err := p.runHooks(context.Background(), "post-push", nil, repoRule, branchRule); err != nil {
+		t.Errorf("runHooks() without hooks = %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// redirectTransport sends the requests to the server instead of their host.
+type redirectTransport struct {
+	server *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	u := *r.URL
+	u.Scheme, u.Host = t.server.Scheme, t.server.Host
+	redirected := *r
+	redirected.URL = &u
+	return http.DefaultTransport.RoundTrip(&redirected)
+}
+
+// fakeGitHub starts a server with the handler and returns a context whose
+// GitHub clients send their requests to it, and a function stopping it.
+func fakeGitHub(t *testing.T, handler http.HandlerFunc) (context.Context, func()) {
+	server := httptest.NewServer(handler)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return withHTTPClient(context.Background(), &http.Client{Transport: redirectTransport{u}}), server.Close
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	ctx := context.Background()
+	if httpClient(ctx) != http.DefaultClient {
+		t.Errorf("expected http.DefaultClient without a client")
+	}
+	if withHTTPClient(ctx, nil) != ctx {
+		t.Errorf("expected the context to be left alone without a client")
+	}
+
+	var got string
+	ctx, stop := fakeGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		w.Write([]byte(`{"archived": true}`))
+	})
+	defer stop()
+	archived, err := IsArchived(ctx, "secret", "kubernetes", "client-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !archived || got != "Bearer secret" {
+		t.Errorf("expected the request with the token to go through the client, got archived %v, authorization %q", archived, got)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/redact"
+)
+
+// failureSignatureMarker starts the failure signature in the body of failure
+// issues.
+const failureSignatureMarker = "<!-- publishing-bot failure-signature: "
+
+// failureSignature identifies failures of the same cause: the target org, the
+// phase, the destination branch and the category of the failure of the run. The
+// target org keeps the issues of bots sharing the issue repository apart.
+func failureSignature(cfg *config.Config, r RunResult) string {
+	f := failureOf(r)
+	var parts []string
+	for _, s := range []string{cfg.TargetOrg, f.Phase, f.Repository, f.Branch, f.Category} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// failureOf returns the failure of the run, defaulting to a setup failure.
+func failureOf(r RunResult) RunFailure {
+	if r.Failure == nil {
+		return RunFailure{Category: FailureSetup}
+	}
+	return *r.Failure
+}
+
+// failureClass returns the failure class of the error class of the run: rules,
+// code for source commits which cannot be published, and infra otherwise.
+func failureClass(r RunResult) string {
+	switch failureOf(r).Class {
+	case ErrorClassRules:
+		return config.FailureClassRules
+	case ErrorClassConflict, ErrorClassDepRestore, ErrorClassBuild:
+		return config.FailureClassCode
+	default:
+		return config.FailureClassInfra
+	}
+}
+
+// failureIssueTitle returns the title of the failure issue of the run.
+func failureIssueTitle(r RunResult) string {
+	f := failureOf(r)
+	where := "Publishing"
+	if f.Repository != "" {
+		where = "Publishing " + f.Repository
+		if f.Branch != "" {
+			where += "/" + f.Branch
+		}
+	}
+	if f.Class != "" && f.Class != ErrorClassUnknown {
+		where = "[" + f.Class + "] " + where
+	}
+	if f.Phase != "" {
+		return fmt.Sprintf("%s failed in %s: %s", where, f.Phase, f.Category)
+	}
+	return fmt.Sprintf("%s failed: %s", where, f.Category)
+}
+
+// failureIssuesRepository returns the org and repo failure issues are filed in.
+func failureIssuesRepository(cfg *config.Config) (string, string) {
+	if cfg.FailureIssues.Repository != "" {
+		ss := strings.SplitN(cfg.FailureIssues.Repository, "/", 2)
+		return ss[0], ss[1]
+	}
+	return cfg.TargetOrg, cfg.SourceRepo
+}
+
+// openFailureIssues returns the open failure issues filed by the bot, by
+// failure signature.
+func openFailureIssues(ctx context.Context, client *github.Client, org, repo string) (map[string]int, error) {
+	myself, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get own user: %v", err)
+	}
+	issues := map[string]int{}
+	opts := &github.IssueListByRepoOptions{State: "open", Creator: myself.GetLogin(), ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := client.Issues.ListByRepo(ctx, org, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list issues of %s/%s: %v", org, repo, err)
+		}
+		for _, issue := range page {
+			body := issue.GetBody()
+			i := strings.Index(body, failureSignatureMarker)
+			if i < 0 || issue.IsPullRequest() {
+				continue
+			}
+			sig := body[i+len(failureSignatureMarker):]
+			if j := strings.Index(sig, " -->"); j >= 0 {
+				issues[sig[:j]] = issue.GetNumber()
+			}
+		}
+		if resp.NextPage == 0 {
+			return issues, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// FileFailureIssue files an issue for the failed run with the error, the
+// conflict report, if any, and the logs, labeled with the severity and assigned
+// by the failure class. If an issue with the same failure signature is open,
+// they are appended to it in a comment instead.
+func FileFailureIssue(ctx context.Context, client *github.Client, cfg *config.Config, e error, r RunResult, logs string) error {
+	org, repo := failureIssuesRepository(cfg)
+
+	open, err := openFailureIssues(ctx, client, org, repo)
+	if err != nil {
+		return err
+	}
+	sig, class := failureSignature(cfg, r), failureClass(r)
+	header := fmt.Sprintf("The publishing run failed (%s failure, severity %s): %v", class, cfg.FailureIssues.SeverityOf(class), e)
+	if r.ConflictReport != nil {
+		header += "\n\n" + r.ConflictReport.Markdown()
+	}
+	body := redact.String(transfromLogToGithubFormat(logs, 50, header))
+
+	if issue, found := open[sig]; found {
+		if _, _, err := client.Issues.CreateComment(ctx, org, repo, issue, &github.IssueComment{Body: &body}); err != nil {
+			return fmt.Errorf("failed to comment on issue #%d: %v", issue, err)
+		}
+		glog.Infof("Appended the failure %s to %s/%s#%d", sig, org, repo, issue)
+		return nil
+	}
+
+	labels, assignees := cfg.FailureIssues.LabelsOf(class), cfg.FailureIssues.AssigneesOf(class)
+	issue, _, err := client.Issues.Create(ctx, org, repo, &github.IssueRequest{
+		Title:     github.String(failureIssueTitle(r)),
+		Body:      github.String(body + "\n\n" + failureSignatureMarker + sig + " -->"),
+		Labels:    &labels,
+		Assignees: &assignees,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to file an issue in %s/%s: %v", org, repo, err)
+	}
+	glog.Infof("Filed %s/%s#%d for the failure %s", org, repo, issue.GetNumber(), sig)
+	return nil
+}
+
+// CloseFailureIssues closes the open failure issues of the target org after a
+// successful run.
+func CloseFailureIssues(ctx context.Context, client *github.Client, cfg *config.Config) error {
+	org, repo := failureIssuesRepository(cfg)
+	open, err := openFailureIssues(ctx, client, org, repo)
+	if err != nil {
+		return err
+	}
+	for sig, issue := range open {
+		if !strings.HasPrefix(sig, cfg.TargetOrg+"/") {
+			continue
+		}
+		if _, _, err := client.Issues.CreateComment(ctx, org, repo, issue, &github.IssueComment{Body: github.String("The last publishing run succeeded.")}); err != nil {
+			return fmt.Errorf("failed to comment on issue #%d: %v", issue, err)
+		}
+		if _, _, err := client.Issues.Edit(ctx, org, repo, issue, &github.IssueRequest{State: github.String("closed")}); err != nil {
+			return fmt.Errorf("failed to close issue #%d: %v", issue, err)
+		}
+		glog.Infof("Closed %s/%s#%d of the failure %s", org, repo, issue, sig)
+	}
+	return nil
+}
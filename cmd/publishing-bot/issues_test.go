@@ -0,0 +1,253 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestFailureIssue(t *testing.T) {
+	cfg := &config.Config{TargetOrg: "kubernetes"}
+	tests := []struct {
+		name          string
+		result        RunResult
+		wantSignature string
+		wantClass     string
+		wantTitle     string
+	}{
+		{
+			name:          "conflict",
+			result:        RunResult{Error: "exit status 1", Failure: &RunFailure{Phase: "construct", Repository: "client-go", Branch: "master", Category: FailureConflict, Class: ErrorClassConflict}},
+			wantSignature: "kubernetes/construct/client-go/master/conflict",
+			wantClass:     config.FailureClassCode,
+			wantTitle:     "[conflict] Publishing client-go/master failed in construct: conflict",
+		},
+		{
+			name:          "network",
+			result:        RunResult{Error: "fatal: unable to access: Could not resolve host: github.com", Failure: &RunFailure{Phase: "construct", Repository: "api", Branch: "master", Category: FailureConstruct, Class: ErrorClassNetwork}},
+			wantSignature: "kubernetes/construct/api/master/construct",
+			wantClass:     config.FailureClassInfra,
+			wantTitle:     "[network] Publishing api/master failed in construct: construct",
+		},
+		{
+			name:          "dependency restore",
+			result:        RunResult{Error: "exit status 1", Failure: &RunFailure{Phase: "construct", Repository: "api", Branch: "master", Category: FailureConstruct, Class: ErrorClassDepRestore}},
+			wantSignature: "kubernetes/construct/api/master/construct",
+			wantClass:     config.FailureClassCode,
+			wantTitle:     "[dep-restore] Publishing api/master failed in construct: construct",
+		},
+		{
+			name:          "unknown construct error",
+			result:        RunResult{Error: "exit status 1", Failure: &RunFailure{Phase: "construct", Repository: "api", Branch: "master", Category: FailureConstruct, Class: ErrorClassUnknown}},
+			wantSignature: "kubernetes/construct/api/master/construct",
+			wantClass:     config.FailureClassInfra,
+			wantTitle:     "Publishing api/master failed in construct: construct",
+		},
+		{
+			name:          "invalid rules",
+			result:        RunResult{Error: "invalid rules in rules.yaml: invalid branch", Failure: &RunFailure{Phase: "setup", Category: FailureSetup, Class: ErrorClassRules}},
+			wantSignature: "kubernetes/setup/setup",
+			wantClass:     config.FailureClassRules,
+			wantTitle:     "[rules] Publishing failed in setup: setup",
+		},
+		{
+			name:          "classified",
+			result:        RunResult{Error: "exit status 1", Failure: &RunFailure{Phase: "publish", Repository: "api", Branch: "master", Category: FailurePublish, Class: ErrorClassPushRejected}},
+			wantSignature: "kubernetes/publish/api/master/publish",
+			wantClass:     config.FailureClassInfra,
+			wantTitle:     "[push-rejected] Publishing api/master failed in publish: publish",
+		},
+		{
+			name:          "no failure recorded",
+			result:        RunResult{Error: "failed"},
+			wantSignature: "kubernetes/setup",
+			wantClass:     config.FailureClassInfra,
+			wantTitle:     "Publishing failed: setup",
+		},
+	}
+	for _, tt := range tests {
+		if got := failureSignature(cfg, tt.result); got != tt.wantSignature {
+			t.Errorf("%s: failureSignature() = %q, want %q", tt.name, got, tt.wantSignature)
+		}
+		if got := failureClass(tt.result); got != tt.wantClass {
+			t.Errorf("%s: failureClass() = %q, want %q", tt.name, got, tt.wantClass)
+		}
+		if got := failureIssueTitle(tt.result); got != tt.wantTitle {
+			t.Errorf("%s: failureIssueTitle() = %q, want %q", tt.name, got, tt.wantTitle)
+		}
+	}
+}
+
+// fakeIssues serves the open failure issues of kubernetes/kubernetes filed by
+// the bot and records the new issues, comments and closed issues.
+type fakeIssues struct {
+	open     []string // bodies of the open issues, numbered from 1
+	filed    []github.IssueRequest
+	comments map[int][]string
+	closed   []int
+}
+
+func (f *fakeIssues) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var number int
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/user":
+		fmt.Fprint(w, `{"login": "k8s-publishing-bot"}`)
+	case r.Method == "GET" && r.URL.Path == "/repos/kubernetes/kubernetes/issues":
+		if r.URL.Query().Get("creator") != "k8s-publishing-bot" || r.URL.Query().Get("state") != "open" {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		var issues []github.Issue
+		for i, body := range f.open {
+			issues = append(issues, github.Issue{Number: github.Int(i + 1), Body: github.String(body)})
+		}
+		json.NewEncoder(w).Encode(issues)
+	case r.Method == "POST" && r.URL.Path == "/repos/kubernetes/kubernetes/issues":
+		var issue github.IssueRequest
+		json.NewDecoder(r.Body).Decode(&issue)
+		f.filed = append(f.filed, issue)
+		fmt.Fprintf(w, `{"number": %d}`, len(f.open)+len(f.filed))
+	case r.Method == "POST" && sscanIssuePath(r.URL.Path, "/repos/kubernetes/kubernetes/issues/%d/comments", &number):
+		var c github.IssueComment
+		json.NewDecoder(r.Body).Decode(&c)
+		f.comments[number] = append(f.comments[number], c.GetBody())
+		fmt.Fprint(w, `{}`)
+	case r.Method == "PATCH" && sscanIssuePath(r.URL.Path, "/repos/kubernetes/kubernetes/issues/%d", &number):
+		var issue github.IssueRequest
+		json.NewDecoder(r.Body).Decode(&issue)
+		if issue.GetState() == "closed" {
+			f.closed = append(f.closed, number)
+		}
+		fmt.Fprint(w, `{}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func sscanIssuePath(path, format string, number *int) bool {
+	n, err := fmt.Sscanf(path, format, number)
+	return err == nil && n == 1 && fmt.Sprintf(format, *number) == path
+}
+
+func fakeIssuesClient(f *fakeIssues) (*github.Client, func()) {
+	server := httptest.NewServer(f)
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client, server.Close
+}
+
+func TestFileFailureIssue(t *testing.T) {
+	f := &fakeIssues{
+		open: []string{
+			"Unrelated",
+			"Earlier failure\n\n" + failureSignatureMarker + "kubernetes/publish/api/master/publish -->",
+			"Failure of another org\n\n" + failureSignatureMarker + "k8s-staging/construct/client-go/master/conflict -->",
+		},
+		comments: map[int][]string{},
+	}
+	client, cleanup := fakeIssuesClient(f)
+	defer cleanup()
+	cfg := &config.Config{TargetOrg: "kubernetes", SourceRepo: "kubernetes", FailureIssues: config.FailureIssues{
+		Enabled:   true,
+		Labels:    []string{"area/publishing-bot"},
+		Assignees: []string{"alice"},
+		Classes:   map[string]config.FailureClass{config.FailureClassCode: {Labels: []string{"kind/bug"}, Assignees: []string{"bob"}}},
+	}}
+
+	conflict := RunResult{Error: "exit status 1", Failure: &RunFailure{Phase: "construct", Repository: "client-go", Branch: "master", Category: FailureConflict, Class: ErrorClassConflict}}
+	if err := FileFailureIssue(context.Background(), client, cfg, errors.New("exit status 1"), conflict, "conflict in foo.go"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.filed) != 1 {
+		t.Fatalf("expected one filed issue, got %d", len(f.filed))
+	}
+	issue := f.filed[0]
+	if got, want := issue.GetTitle(), "[conflict] Publishing client-go/master failed in construct: conflict"; got != want {
+		t.Errorf("expected title %q, got %q", want, got)
+	}
+	if got, want := *issue.Labels, []string{"area/publishing-bot", "kind/bug", "severity/major"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected labels %v, got %v", want, got)
+	}
+	if got, want := *issue.Assignees, []string{"alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected assignees %v, got %v", want, got)
+	}
+	for _, want := range []string{"(code failure, severity major)", "conflict in foo.go", failureSignatureMarker + "kubernetes/construct/client-go/master/conflict -->"} {
+		if !strings.Contains(issue.GetBody(), want) {
+			t.Errorf("expected %q in the body:\n%s", want, issue.GetBody())
+		}
+	}
+
+	rejected := RunResult{Error: "exit status 1", Failure: &RunFailure{Phase: "publish", Repository: "api", Branch: "master", Category: FailurePublish, Class: ErrorClassPushRejected}}
+	if err := FileFailureIssue(context.Background(), client, cfg, errors.New("exit status 1"), rejected, "[rejected] master -> master"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.filed) != 1 {
+		t.Errorf("expected the failure to be appended to the open issue, got %d filed issues", len(f.filed))
+	}
+	if len(f.comments[2]) != 1 || !strings.Contains(f.comments[2][0], "(infra failure, severity critical)") {
+		t.Errorf("expected an infra failure comment on #2, got %v", f.comments)
+	}
+}
+
+func TestCloseFailureIssues(t *testing.T) {
+	f := &fakeIssues{
+		open: []string{
+			"Unrelated",
+			"Failure\n\n" + failureSignatureMarker + "kubernetes/setup -->",
+			"Failure\n\n" + failureSignatureMarker + "kubernetes/publish/api/master/publish -->",
+			"Failure of another org\n\n" + failureSignatureMarker + "k8s-staging/setup -->",
+		},
+		comments: map[int][]string{},
+	}
+	client, cleanup := fakeIssuesClient(f)
+	defer cleanup()
+	cfg := &config.Config{TargetOrg: "kubernetes", SourceRepo: "kubernetes", FailureIssues: config.FailureIssues{Enabled: true}}
+
+	if err := CloseFailureIssues(context.Background(), client, cfg); err != nil {
+		t.Fatal(err)
+	}
+	closed := map[int]bool{}
+	for _, n := range f.closed {
+		closed[n] = true
+	}
+	if want := map[int]bool{2: true, 3: true}; !reflect.DeepEqual(closed, want) {
+		t.Errorf("expected the failure issues %v to be closed, got %v", want, closed)
+	}
+	for _, n := range []int{2, 3} {
+		if len(f.comments[n]) != 1 {
+			t.Errorf("expected a comment on #%d, got %q", n, f.comments[n])
+		}
+	}
+	for _, n := range []int{1, 4} {
+		if len(f.comments[n]) != 0 {
+			t.Errorf("expected the unrelated issue #%d to be left alone, got %q", n, f.comments[n])
+		}
+	}
+}
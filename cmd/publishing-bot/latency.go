@@ -0,0 +1,209 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// measureUnpublished records in the run result since when the newest source
+// commit of each destination branch is waiting to be published. Branches which
+// are up to date get the zero time.
+func (p *PublisherMunger) measureUnpublished(ctx context.Context) {
+	if len(p.reposRules.Rules) == 0 {
+		// rules not loaded
+		return
+	}
+	srcDir := p.config.SourceDir(p.baseRepoPath)
+	since := map[string]time.Time{}
+	for _, repoRule := range p.reposRules.Rules {
+		if repoRule.Skip {
+			continue
+		}
+		dstDir := p.dstDir(repoRule)
+		if _, err := os.Stat(dstDir); err != nil {
+			continue
+		}
+		p.dir = dstDir
+		for _, branchRule := range repoRule.Branches {
+			if p.skippedBranch(branchRule.Source.Branch) {
+				continue
+			}
+			published := p.lastPublishedSourceCommit(ctx, branchRule.Name)
+			if published == "" {
+				continue
+			}
+			args := []string{"log", "-1", "--format=%ct", published + "..origin/" + branchRule.Source.Branch}
+			if dir := branchRule.Source.Dir; dir != "" && dir != "." {
+				args = append(args, "--", dir)
+			}
+			cmd := p.command(ctx, "git", args...)
+			cmd.Dir = srcDir
+			out, err := cmd.Output()
+			if err != nil {
+				p.plog.Errorf("Failed to list unpublished source commits of branch %s of %s: %v", branchRule.Name, repoRule.DestinationRepository, err)
+				continue
+			}
+			key := repoRule.DestinationRepository + "/" + branchRule.Name
+			since[key] = time.Time{}
+			if lines := strings.Fields(string(out)); len(lines) > 0 {
+				if secs, err := strconv.ParseInt(lines[0], 10, 64); err == nil {
+					since[key] = time.Unix(secs, 0)
+				}
+			}
+		}
+	}
+	p.result.UnpublishedSince = since
+}
+
+// latencyTracker exposes the age of the newest unpublished source commit per
+// destination branch as metrics and alerts when it exceeds the SLO.
+type latencyTracker struct {
+	slo config.LatencySLO
+
+	mutex sync.Mutex
+	// since is when the newest unpublished source commit was committed, by
+	// <destination>/<branch>, or the zero time if the branch is up to date.
+	since map[string]time.Time
+	// alerting are the branches an alert was sent for
+	alerting map[string]bool
+}
+
+func newLatencyTracker(slo config.LatencySLO) *latencyTracker {
+	return &latencyTracker{slo: slo, since: map[string]time.Time{}, alerting: map[string]bool{}}
+}
+
+// latencyAlert is posted to the SLO webhook.
+type latencyAlert struct {
+	Status           string    `json:"status"`
+	Repository       string    `json:"repository"`
+	Branch           string    `json:"branch"`
+	UnpublishedSince time.Time `json:"unpublishedSince,omitempty"`
+	AgeSeconds       int64     `json:"ageSeconds"`
+	TargetSeconds    int64     `json:"targetSeconds"`
+}
+
+// Update records the unpublished source commits of a run and alerts about
+// branches exceeding the SLO, or being back within it. Results without
+// measurements, e.g. of a run failing early, keep the previous values.
+func (t *latencyTracker) Update(ctx context.Context, r RunResult, now time.Time) {
+	if r.UnpublishedSince == nil {
+		return
+	}
+	t.mutex.Lock()
+	t.since = r.UnpublishedSince
+	var alerts []latencyAlert
+	for key, since := range t.since {
+		age := age(since, now)
+		exceeded := t.slo.Target > 0 && age > t.slo.Target
+		if exceeded == t.alerting[key] {
+			continue
+		}
+		t.alerting[key] = exceeded
+		ss := strings.SplitN(key, "/", 2)
+		a := latencyAlert{Status: "resolved", Repository: ss[0], Branch: ss[1], UnpublishedSince: since, AgeSeconds: int64(age.Seconds()), TargetSeconds: int64(t.slo.Target.Seconds())}
+		if exceeded {
+			a.Status = "firing"
+			glog.Warningf("Branch %s is not published for %v, exceeding the latency SLO of %v", key, age.Truncate(time.Minute), t.slo.Target)
+		} else {
+			glog.Infof("Branch %s is within the latency SLO of %v again", key, t.slo.Target)
+		}
+		alerts = append(alerts, a)
+	}
+	for key := range t.alerting {
+		if _, found := t.since[key]; !found {
+			delete(t.alerting, key)
+		}
+	}
+	t.mutex.Unlock()
+
+	if t.slo.Webhook == "" {
+		return
+	}
+	for _, a := range alerts {
+		if err := postJSON(ctx, t.slo.Webhook, a); err != nil {
+			glog.Errorf("Failed to post latency alert for %s/%s: %v", a.Repository, a.Branch, err)
+		}
+	}
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format.
+func (t *latencyTracker) WriteMetrics(buf *bytes.Buffer, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	keys := make([]string, 0, len(t.since))
+	for key := range t.since {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(buf, "# HELP publishing_bot_unpublished_source_commit_age_seconds Age of the newest source commit not published to the destination branch.\n")
+	fmt.Fprintf(buf, "# TYPE publishing_bot_unpublished_source_commit_age_seconds gauge\n")
+	for _, key := range keys {
+		ss := strings.SplitN(key, "/", 2)
+		fmt.Fprintf(buf, "publishing_bot_unpublished_source_commit_age_seconds{repository=%q,branch=%q} %d\n", ss[0], ss[1], int64(age(t.since[key], now).Seconds()))
+	}
+	if t.slo.Target > 0 {
+		fmt.Fprintf(buf, "# HELP publishing_bot_latency_slo_seconds Maximal age of unpublished source commits.\n")
+		fmt.Fprintf(buf, "# TYPE publishing_bot_latency_slo_seconds gauge\n")
+		fmt.Fprintf(buf, "publishing_bot_latency_slo_seconds %d\n", int64(t.slo.Target.Seconds()))
+	}
+}
+
+func age(since, now time.Time) time.Duration {
+	if since.IsZero() {
+		return 0
+	}
+	return now.Sub(since)
+}
+
+func postJSON(ctx context.Context, url string, v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	resp, err := httpClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	var alerts []latencyAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a latencyAlert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("failed to decode alert: %v", err)
+		}
+		alerts = append(alerts, a)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	tracker := newLatencyTracker(config.LatencySLO{Target: 4 * time.Hour, Webhook: server.URL})
+	tracker.Update(context.Background(), RunResult{UnpublishedSince: map[string]time.Time{
+		"client-go/master": now.Add(-5 * time.Hour),
+		"api/master":       {},
+	}}, now)
+	if len(alerts) != 1 || alerts[0].Status != "firing" || alerts[0].Repository != "client-go" || alerts[0].Branch != "master" {
+		t.Fatalf("expected a firing alert for client-go/master, got %+v", alerts)
+	}
+
+	// no measurements, e.g. after a failure, keep the alert
+	tracker.Update(context.Background(), RunResult{}, now)
+	if len(alerts) != 1 {
+		t.Fatalf("expected no new alert, got %+v", alerts[1:])
+	}
+
+	var buf bytes.Buffer
+	tracker.WriteMetrics(&buf, now)
+	for _, expected := range []string{
+		`publishing_bot_unpublished_source_commit_age_seconds{repository="api",branch="master"} 0`,
+		`publishing_bot_unpublished_source_commit_age_seconds{repository="client-go",branch="master"} 18000`,
+		`publishing_bot_latency_slo_seconds 14400`,
+	} {
+		if !strings.Contains(buf.String(), expected+"\n") {
+			t.Errorf("expected metric %q in:\n%s", expected, buf.String())
+		}
+	}
+
+	tracker.Update(context.Background(), RunResult{UnpublishedSince: map[string]time.Time{
+		"client-go/master": {},
+		"api/master":       {},
+	}}, now)
+	if len(alerts) != 2 || alerts[1].Status != "resolved" {
+		t.Fatalf("expected a resolved alert, got %+v", alerts)
+	}
+}
+
+func TestMeasureUnpublished(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unpublished")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	git := func(dir string, env []string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(dir string, when time.Time, msg string) string {
+		date := when.Format(time.RFC3339)
+		git(dir, []string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date}, "commit", "-q", "--allow-empty", "-m", msg)
+		return git(dir, nil, "rev-parse", "HEAD")
+	}
+	src, dst := filepath.Join(dir, "kubernetes"), filepath.Join(dir, "client-go")
+	for _, d := range []string{src, dst} {
+		git(dir, nil, "init", "-q", d)
+	}
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	published := commit(src, start, "a")
+	commit(src, start.Add(time.Hour), "b")
+	commit(src, start.Add(2*time.Hour), "c")
+	git(src, nil, "update-ref", "refs/remotes/origin/master", "HEAD")
+	commit(dst, start, "a\n\nKubernetes-commit: "+published)
+	git(dst, nil, "update-ref", "refs/remotes/origin/master", "HEAD")
+
+	buf := new(bytes.Buffer)
+	p := New(&config.Config{SourceRepo: "kubernetes"}, dir)
+	p.plog = &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf}
+	p.reposRules = config.RepositoryRules{Rules: []config.RepositoryRule{{
+		DestinationRepository: "client-go",
+		Branches:              []config.BranchRule{{Name: "master", Source: config.Source{Branch: "master"}}},
+	}}}
+	p.measureUnpublished(context.Background())
+	if got, want := p.result.UnpublishedSince["client-go/master"], start.Add(2*time.Hour); !got.Equal(want) {
+		t.Errorf("unpublished since %v, want the newest unpublished commit at %v", got, want)
+	}
+
+	git(dst, nil, "update-ref", "refs/remotes/origin/master", commit(dst, start.Add(2*time.Hour), "c\n\nKubernetes-commit: "+git(src, nil, "rev-parse", "HEAD")))
+	p.measureUnpublished(context.Background())
+	if got := p.result.UnpublishedSince["client-go/master"]; !got.IsZero() {
+		t.Errorf("unpublished since %v, want the zero time for an up to date branch", got)
+	}
+}
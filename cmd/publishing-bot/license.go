@@ -0,0 +1,235 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+const noticeFile = "NOTICE"
+
+// syncLicense writes the license file and the NOTICE file into the checked out
+// destination branch and commits them if they changed. Then it verifies that
+// the license file is present, and if configured, in every new commit.
+func (p *PublisherMunger) syncLicense(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) error {
+	l := repoRule.License
+	if !l.Enabled() {
+		return nil
+	}
+
+	license, err := p.expectedLicense(ctx, repoRule, branchRule)
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{}
+	if license != nil {
+		files[l.FileName()] = license
+	}
+	if l.Notice {
+		notice, err := vendorNotice(p.dir, p.config.SourceRepo)
+		if err != nil {
+			return fmt.Errorf("failed to generate %s: %v", noticeFile, err)
+		}
+		if old, err := ioutil.ReadFile(p.path(noticeFile)); err == nil && !bytes.HasPrefix(old, []byte(fmt.Sprintf(generatedHeader, p.config.SourceRepo))) {
+			p.plog.Infof("Not overwriting %s of %s which is not generated", noticeFile, branchRule.Name)
+		} else if notice != nil {
+			files[noticeFile] = notice
+		}
+	}
+
+	var changed []string
+	for pth, content := range files {
+		if old, err := ioutil.ReadFile(p.path(pth)); err == nil && bytes.Equal(old, content) {
+			continue
+		}
+		if err := ioutil.WriteFile(p.path(pth), content, 0644); err != nil {
+			return err
+		}
+		if err := p.command(ctx, "git", "add", pth).Run(); err != nil {
+			return fmt.Errorf("failed to add %s: %v", pth, err)
+		}
+		changed = append(changed, pth)
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		p.plog.Infof("Updating %s of %s", strings.Join(changed, " and "), branchRule.Name)
+		if err := p.plog.Run(p.gitCommit(ctx, "sync: update "+strings.Join(changed, " and "))); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(p.path(l.FileName())); err != nil {
+		return fmt.Errorf("license file %s is missing in branch %s of %s", l.FileName(), branchRule.Name, repoRule.DestinationRepository)
+	}
+	if l.VerifyCommits {
+		for _, c := range changed {
+			if c == l.FileName() {
+				// the earlier new commits cannot have the license just introduced
+				p.plog.Infof("Not verifying the license of the new commits of %s as it was just updated", branchRule.Name)
+				return nil
+			}
+		}
+		return p.verifyLicenseInCommits(ctx, branchRule.Name, l.FileName(), license)
+	}
+	return nil
+}
+
+// expectedLicense returns the configured license file content, or nil if only
+// the presence of the license file is checked.
+func (p *PublisherMunger) expectedLicense(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) ([]byte, error) {
+	l := repoRule.License
+	switch {
+	case l.CopyFrom != "":
+		// the source ref is set to the pin, if any, by construct.sh
+		src := fmt.Sprintf("upstream/%s:%s", branchRule.Source.Branch, l.CopyFrom)
+		bs, err := p.command(ctx, "git", "show", src).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read license %s: %v", src, err)
+		}
+		return bs, nil
+	case l.Content != "":
+		t, err := template.New("license").Parse(l.Content)
+		if err != nil {
+			return nil, err
+		}
+		year, err := p.sourceCommitYear(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = t.Execute(&buf, struct {
+			Destination string
+			Year        int
+		}{repoRule.DestinationRepository, year})
+		return buf.Bytes(), err
+	}
+	return nil, nil
+}
+
+// sourceCommitYear returns the year of the latest commit of the checked out
+// branch pointing back to a source commit, or of the head if there is none.
+// Published commits keep the dates of their source commits, such that the
+// license only changes with new source commits, not on new year's day.
+func (p *PublisherMunger) sourceCommitYear(ctx context.Context) (int, error) {
+	out, err := p.command(ctx, "git", "log", "-1", "--format=%ct", "--grep=^"+config.CommitMsgTag(p.config.SourceRepo)+": ", "HEAD").Output()
+	if err == nil && len(bytes.TrimSpace(out)) == 0 {
+		out, err = p.command(ctx, "git", "log", "-1", "--format=%ct", "HEAD").Output()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the date of the latest source commit: %v", err)
+	}
+	secs, err := strconv.ParseInt(string(bytes.TrimSpace(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the date of the latest source commit: %v", err)
+	}
+	return time.Unix(secs, 0).UTC().Year(), nil
+}
+
+// verifyLicenseInCommits checks that the commits on the branch which are not on
+// origin yet contain the license file, with the given content unless nil.
+func (p *PublisherMunger) verifyLicenseInCommits(ctx context.Context, branch, file string, content []byte) error {
+	revs := []string{"HEAD"}
+	if p.command(ctx, "git", "rev-parse", "-q", "--verify", "origin/"+branch).Run() == nil {
+		revs = append(revs, "^origin/"+branch)
+	}
+	out, err := p.command(ctx, "git", append([]string{"rev-list"}, revs...)...).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list new commits of %s: %v", branch, err)
+	}
+	var bad []string
+	for _, c := range strings.Fields(string(out)) {
+		bs, err := p.command(ctx, "git", "show", c+":"+file).Output()
+		if err != nil || (content != nil && !bytes.Equal(bs, content)) {
+			bad = append(bad, c)
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	if len(bad) > 5 {
+		bad = append(bad[:5], "...")
+	}
+	return fmt.Errorf("commits of branch %s without the expected %s: %s", branch, file, strings.Join(bad, " "))
+}
+
+// vendorNotice returns the NOTICE content with the license files found in the
+// vendor directory of the repo in dir, or nil if there are none.
+func vendorNotice(dir, sourceRepo string) ([]byte, error) {
+	var licenses []string
+	err := filepath.Walk(filepath.Join(dir, "vendor"), func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if isLicenseFile(info.Name(), true) {
+			licenses = append(licenses, pth)
+		}
+		return nil
+	})
+	if err != nil || len(licenses) == 0 {
+		return nil, err
+	}
+	sort.Strings(licenses)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, generatedHeader, sourceRepo)
+	for _, pth := range licenses {
+		bs, err := ioutil.ReadFile(pth)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(filepath.Join(dir, "."), pth)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "\n= %s\n\n%s\n", filepath.ToSlash(rel), strings.TrimRight(string(bs), "\n"))
+	}
+	return buf.Bytes(), nil
+}
+
+// isLicenseFile returns true for the usual names of license files, and of
+// notice files if notices is true.
+func isLicenseFile(name string, notices bool) bool {
+	name = strings.ToUpper(name)
+	prefixes := []string{"LICENSE", "LICENCE", "COPYING"}
+	if notices {
+		prefixes = append(prefixes, "NOTICE")
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
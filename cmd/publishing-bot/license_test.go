@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestVendorNotice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendor-notice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if notice, err := vendorNotice("", "kubernetes"); err != nil || notice != nil {
+		t.Fatalf("expected no notice without vendor, got %q, %v", notice, err)
+	}
+
+	for pth, content := range map[string]string{
+		"vendor/github.com/b/b/LICENSE.txt": "B license\n",
+		"vendor/github.com/a/a/COPYING":     "A license\n",
+		"vendor/github.com/a/a/a.go":        "package a\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pth, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notice, err := vendorNotice("", "kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(generatedHeader, "kubernetes") + `
+= vendor/github.com/a/a/COPYING
+
+A license
+
+= vendor/github.com/b/b/LICENSE.txt
+
+B license
+`
+	if string(notice) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, notice)
+	}
+}
+
+func TestExpectedLicenseYear(t *testing.T) {
+	_, git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	p := &PublisherMunger{config: &config.Config{SourceRepo: "kubernetes"}}
+	repoRule := config.RepositoryRule{
+		DestinationRepository: "client-go",
+		License:               config.LicenseSync{Content: "Copyright {{.Year}} The {{.Destination}} Authors."},
+	}
+	commit := func(date, msg string) {
+		cmd := exec.Command("git", "commit", "-q", "--allow-empty", "-m", msg)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git commit: %v: %s", err, out)
+		}
+	}
+
+	commit("2016-06-01T12:00:00Z", "Initial commit")
+	commit("2017-12-31T12:00:00Z", "Add foo\n\nKubernetes-commit: 1111111111111111111111111111111111111111")
+	commit("2018-01-02T12:00:00Z", "sync: update LICENSE")
+	license, err := p.expectedLicense(context.Background(), repoRule, config.BranchRule{Name: "master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(license), "Copyright 2017 The client-go Authors."; got != want {
+		t.Errorf("expectedLicense() = %q, want %q", got, want)
+	}
+
+	git("checkout", "-q", "--orphan", "new")
+	commit("2016-06-01T12:00:00Z", "Initial commit")
+	license, err = p.expectedLicense(context.Background(), repoRule, config.BranchRule{Name: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(license), "Copyright 2016 The client-go Authors."; got != want {
+		t.Errorf("expectedLicense() without source commits = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// lint is the lint subcommand. It prints the lint findings of the config and
+// the rules and returns the exit code, 1 if there are errors.
+func lint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configFile := fs.String("config", "", "the config file in yaml format (required)")
+	rulesFile := rulesFileVar(fs, "the file, directory or URL with repository rules. If empty, the rules-file of the config is used")
+	parseFlags(fs, args)
+
+	if *configFile == "" {
+		glog.Fatalf("-config is required")
+	}
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if err := cfg.Lint.Validate(); err != nil {
+		glog.Fatalf("Invalid lint configuration: %v", err)
+	}
+	if *rulesFile != "" {
+		cfg.RulesFile = *rulesFile
+	}
+	cfg.BasePackage = config.DefaultBasePackage(&cfg)
+
+	rules, err := config.LoadRules(cfg.RulesFile, cfg.Network.TLS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := rules.ExpandSourceDirs(cfg.BasePackage); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	findings := config.Lint(&cfg, rules)
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	if len(config.LintErrors(findings)) > 0 {
+		return 1
+	}
+	return 0
+}
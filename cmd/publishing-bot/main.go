@@ -17,139 +17,341 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/golang/glog"
 	"gopkg.in/yaml.v2"
 
-	"strings"
-
 	"time"
 
 	"path/filepath"
 
 	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+// exit codes in -run-once mode
+const (
+	exitPublished        = 0
+	exitFailed           = 1
+	exitNothingToPublish = 3 // 2 is used for invalid flags
 )
 
 func Usage() {
 	fmt.Fprintf(os.Stderr, `
-Usage: %s [-config <config-yaml-file>] [-dry-run] [-token-file <token-file>] [-interval <sec>]
-          [-source-repo <repo>] [-target-org <org>]
+Usage: %s [-config <config-yaml-file> | -config-dir <dir>] [-dry-run] [-token-file <token-file>] [-interval <sec>]
+          [-source-repo <repo>] [-source-url <git-url>] [-source-path <dir>] [-target-org <org>]
+          [-run-once] [-result-file <file>] [-summary-dir <dir> [-junit]]
+          [-cpuprofile <file>] [-memprofile <file>] [-pprof]
+          [-canary-org <org> [-canary-only]]
+          [-skip-fetch] [-skip-dep-restore] [-skip-tag-push] [-skip-build-check]
+          [-reconcile <destination>/<branch> -reconcile-mode rebase|reset [-yes]]
+
+Every flag can also be given as environment variable PUBLISHING_BOT_<FLAG>, e.g.
+PUBLISHING_BOT_TARGET_ORG for -target-org, also for the subcommands. Command
+line flags override their environment variables and environment variables like
+RULE_FILE_PATH, which override config values, which override the defaults.
+
+The config and the rules can be given in YAML, JSON or TOML, detected by the
+file extension. With -config-dir, every *.yaml, *.json or *.toml file in the
+directory is an independent tenant with its own source repository,
+destination repositories, schedule and credentials, named after the file. Its
+status is served below /tenants/<name>/.
+
+With -run-once, a single publishing run is done and the exit code is %d if
+something was published, %d if there was nothing to publish and %d on failure.
+
+A destination branch with commits pushed by others, or rewritten, since the bot
+pushed it last is not published until it is reconciled with -reconcile, which
+asks for confirmation and exits. Mode rebase publishes on top of the changes,
+mode reset force-pushes the head last pushed by the bot.
+
+The -skip-* flags skip phases of the runs, e.g. to iterate quickly on a later
+phase in a prepared workspace. The branches are constructed without the skipped
+checks, hence they imply -dry-run.
+
+       %s gen-manifests -config <config-yaml-file> [-rules-file <file>] [-kind Deployment|StatefulSet]
+          [-output-dir <kustomize-base-dir>]
+
+renders the Kubernetes manifests of the bot. See "%s gen-manifests -help".
+
+       %s lint -config <config-yaml-file> [-rules-file <file>]
+
+checks the config and the rules for likely mistakes and exits with 1 on errors.
+
+       %s trace [-config <config-yaml-file>] [<org>/]<published-repo> <sha>
+
+prints the source commit, author and pull request of a published commit.
+
+       %s diff-rules [-config <config-yaml-file>] [-source-dir <source-clone> [-since <rev>]] <old-rules> <new-rules>
+
+prints how the published repositories and branches differ between two versions
+of the rules, e.g. origin/master:rules.yaml and rules.yaml, and exits with 1 if
+they differ.
 
-Command line flags override config values.
-`, os.Args[0])
+       %s pause -config <config-yaml-file> [<repo>[/<branch>] [<reason>]]
+       %s resume -config <config-yaml-file> <repo>[/<branch>]
+
+stops publishing to a destination repo or branch until it is resumed, without
+changing the rules, or lists the paused ones. A running bot picks the change up
+at the start of its next run.
+
+       %s verify-rewrite -config <config-yaml-file> [-rules-file <file>] <repo>/<branch>...
+
+constructs published destination branches again from scratch up to their last
+published source commit, without pushing, and exits with 1 if the commits
+differ from the published ones. Run it with a new version of the bot and its
+own GOPATH before upgrading.
+
+       %s notes [-fetch] [-n <count>] [-json] [<revision>]
+
+prints the publishing notes of commits in a clone of a published repo: their
+source commit, the publishing run, the digest of the rules and the bot version.
+
+       %s print-config [-config <config-yaml-file> | -config-dir <dir>] [<flags>]
+
+prints the resolved config with the origin of each value: a flag, an environment
+variable, the config file or the default.
+
+       %s convert [-from yaml|json|toml] [-to yaml|json|toml] [<file>]
+
+converts a config or rules file, or stdin, between YAML, JSON and TOML.
+
+       %s restore-snapshot [-config <config-yaml-file>] [-location <location>] <snapshot> <dir>
+
+extracts a workspace snapshot of a failed run, taken if snapshots are
+configured, from the snapshot location into an empty directory: the destination
+repo with its reflogs, the logs of the run, the error and the conflict report.
+
+       %s verify -source-dir <dir> [-branch <branch>] [-rules-file <file> -repository <destination>]
+
+compares the trees of the published commits in a clone of a destination repo
+with the source directory in their source commits and exits with 1 if any
+diverges. See "%s verify -help".
+`, os.Args[0], exitPublished, exitNothingToPublish, exitFailed, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	flag.PrintDefaults()
 }
 
 func main() {
 	configFilePath := flag.String("config", "", "the config file in yaml format")
+	configDir := flag.String("config-dir", "", "a directory of config files in yaml format, each publishing as an independent tenant")
 	githubHost := flag.String("github-host", "", "the address of github (defaults to github.com)")
 	basePackage := flag.String("base-package", "", "the name of the package base (defaults to k8s.io when source repo is kubernetes, "+
 		"otherwise github-host/target-org)")
 	dryRun := flag.Bool("dry-run", false, "do not push anything to github")
 	tokenFile := flag.String("token-file", "", "the file with the github token")
-	rulesFile := flag.String("rules-file", "", "the file or URL with repository rules")
+	rulesFile := rulesFileVar(flag.CommandLine, "the file, directory or URL with repository rules, or crd://[<namespace>] for PublishingRule custom resources")
 	// TODO: make absolute
 	repoName := flag.String("source-repo", "", "the name of the source repository (eg. kubernetes)")
 	repoOrg := flag.String("source-org", "", "the name of the source repository organization, (eg. kubernetes)")
+	sourceURL := flag.String("source-url", "", "an arbitrary git URL of the source repository (defaults to https://<github-host>/<source-org>/<source-repo>)")
+	sourcePath := flag.String("source-path", "", "an existing local checkout of the source repository, e.g. a CI workspace, to publish from instead of a clone. It is not fetched")
 	targetOrg := flag.String("target-org", "", `the target organization to publish into (e.g. "k8s-publishing-bot")`)
-	basePublishScriptPath := flag.String("base-publish-script-path", "./publish_scripts", `the base path in source repo where bot will look for publishing scripts`)
+	basePublishScriptPath := flag.String("base-publish-script-path", defaultBasePublishScriptPath, `the base path in source repo where bot will look for publishing scripts`)
 	interval := flag.Uint("interval", 0, "loop with the given seconds of wait in between")
 	serverPort := flag.Int("server-port", 0, "start a webserver on the given port listening on 0.0.0.0")
+	commandTimeout := flag.Duration("command-timeout", 0, "kill commands running longer than this, e.g. a hanging git fetch (0 means no timeout)")
+	commandRetries := flag.Int("command-retries", -1, "retry killed hanging commands this many times")
+	repoRetries := flag.Int("repo-retries", -1, "construct repos failing with transient network or rate limit errors again this many times after the other repos")
+	runOnce := flag.Bool("run-once", false, "do a single run and exit with a code telling whether something was published, e.g. in CI")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the publisher process to this file on exit")
+	memProfile := flag.String("memprofile", "", "write a heap profile of the publisher process to this file on exit")
+	servePprof := flag.Bool("pprof", false, "serve the runtime profiles at /debug/pprof/ on the server port")
+	resultFile := flag.String("result-file", "", "write the result of each run as JSON to this file")
+	summaryDir := flag.String("summary-dir", "", "write summary.json of each run into this directory, e.g. $ARTIFACTS of a Prow job")
+	junit := flag.Bool("junit", false, "write a JUnit XML of each run into the summary directory as well")
+	skipFetch := flag.Bool("skip-fetch", false, "use the source repository as it is instead of fetching it")
+	skipDepRestore := flag.Bool("skip-dep-restore", false, "do not update the dependencies of the constructed branches, e.g. via godep restore")
+	skipTagPush := flag.Bool("skip-tag-push", false, "push the destination branches without their tags")
+	skipBuildCheck := flag.Bool("skip-build-check", false, "do not run the smoke tests and the verification of generated files")
+	canaryOrg := flag.String("canary-org", "", "additionally push every destination repo to this shadow org, e.g. to validate a new version of the bot")
+	canaryOnly := flag.Bool("canary-only", false, "push to the canary org only, not to the target org")
+	reconcileBranch := flag.String("reconcile", "", "reconcile a destination branch changed outside of the bot, given as <destination>/<branch>, and exit")
+	reconcileMode := flag.String("reconcile-mode", "", "how to reconcile: rebase to publish on top of the changes, reset to force-push the head last pushed by the bot")
+	reconcileConfirmed := flag.Bool("yes", false, "do not ask for confirmation before reconciling")
+	pins := pinFlag{}
+	flag.Var(pins, "pin", "publish a branch only up to the given source revision: <destination>/<branch>=<revision> or <branch>=<revision>; "+
+		"an empty revision unpins (can be given multiple times)")
 
-	flag.Usage = Usage
-	flag.Parse()
-
-	cfg := config.Config{}
-	if *configFilePath != "" {
-		bs, err := ioutil.ReadFile(*configFilePath)
-		if err != nil {
-			glog.Fatalf("Failed to load config file from %q: %v", *configFilePath, err)
-		}
-		if err := yaml.Unmarshal(bs, &cfg); err != nil {
-			glog.Fatalf("Failed to parse config file at %q: %v", *configFilePath, err)
-		}
+	if len(os.Args) > 1 && os.Args[1] == "gen-manifests" {
+		genManifests(flag.CommandLine, os.Args[2:])
+		return
 	}
-
-	// override with flags
-	if *dryRun {
-		cfg.DryRun = true
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		os.Exit(lint(os.Args[2:]))
 	}
-	if *targetOrg != "" {
-		cfg.TargetOrg = *targetOrg
+	if len(os.Args) > 1 && os.Args[1] == "trace" {
+		os.Exit(trace(os.Args[2:]))
 	}
-	if *repoName != "" {
-		cfg.SourceRepo = *repoName
+	if len(os.Args) > 1 && os.Args[1] == "credential-helper" {
+		os.Exit(credentialHelper(os.Args[2:], os.Stdin, os.Stdout))
 	}
-	if *repoOrg != "" {
-		cfg.SourceOrg = *repoOrg
+	if len(os.Args) > 1 && os.Args[1] == "diff-rules" {
+		os.Exit(diffRules(os.Args[2:]))
 	}
-	if *tokenFile != "" {
-		cfg.TokenFile = *tokenFile
+	if len(os.Args) > 1 && (os.Args[1] == "pause" || os.Args[1] == "resume") {
+		os.Exit(pauseCommand(os.Args[1], os.Args[2:], os.Stdout))
 	}
-	if *rulesFile != "" {
-		cfg.RulesFile = *rulesFile
+	if len(os.Args) > 1 && os.Args[1] == "notes" {
+		os.Exit(notesCommand(os.Args[2:], os.Stdout))
 	}
-	if *basePublishScriptPath != "" {
-		cfg.BasePublishScriptPath = *basePublishScriptPath
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		os.Exit(convertCommand(os.Args[2:], os.Stdin, os.Stdout))
 	}
-	if *githubHost != "" {
-		cfg.GithubHost = *githubHost
+	if len(os.Args) > 1 && os.Args[1] == "verify-rewrite" {
+		os.Exit(verifyRewriteCommand(os.Args[2:], os.Stdout))
 	}
-	if *basePackage != "" {
-		cfg.BasePackage = *basePackage
+	if len(os.Args) > 1 && os.Args[1] == "restore-snapshot" {
+		os.Exit(restoreSnapshotCommand(os.Args[2:], os.Stdout))
 	}
-
-	// defaulting to github.com when it is not specified.
-	if cfg.GithubHost == "" {
-		cfg.GithubHost = "github.com"
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(verifyCommand(os.Args[2:], os.Stdout))
 	}
 
-	var err error
-	cfg.BasePublishScriptPath, err = filepath.Abs(cfg.BasePublishScriptPath)
-	if err != nil {
-		glog.Fatalf("Failed to get absolute path for base-publish-script-path %q: %v", cfg.BasePublishScriptPath, err)
+	// print-config takes the flags of the bot
+	args := os.Args[1:]
+	printConfigOnly := len(args) > 0 && args[0] == "print-config"
+	if printConfigOnly {
+		args = args[1:]
 	}
+	flag.Usage = Usage
+	setFlags := parseFlags(flag.CommandLine, args)
 
-	if len(cfg.SourceRepo) == 0 || len(cfg.SourceOrg) == 0 {
-		glog.Fatalf("source-org and source-repo cannot be empty")
+	if *runOnce && *interval != 0 {
+		glog.Fatalf("-run-once and -interval cannot be used together")
 	}
-
-	if len(cfg.TargetOrg) == 0 {
-		glog.Fatalf("Target organization cannot be empty")
+	if *servePprof && *serverPort == 0 {
+		glog.Fatalf("-pprof requires -server-port")
+	}
+	if *configFilePath != "" && *configDir != "" {
+		glog.Fatalf("-config and -config-dir cannot be used together")
 	}
+	if *reconcileBranch != "" && *configFilePath == "" {
+		glog.Fatalf("-reconcile requires -config")
+	}
+	stopProfiling := startProfiling(*cpuProfile, *memProfile)
 
-	// set the baseRepoPath
-	gopath := os.Getenv("GOPATH")
-	// defaulting when base package is not specified
-	if cfg.BasePackage == "" {
-		if cfg.SourceRepo == "kubernetes" {
-			cfg.BasePackage = "k8s.io"
-		} else {
-			cfg.BasePackage = filepath.Join(cfg.GithubHost, cfg.TargetOrg)
+	// override with the flags given on the command line or in the environment
+	overrideFlags := func(cfg *config.Config) {
+		override := func(name, key string, apply func()) {
+			if o, found := setFlags[name]; found {
+				apply()
+				cfg.SetOrigin(key, o)
+			}
+		}
+		override("dry-run", "dryrun", func() { cfg.DryRun = *dryRun })
+		override("target-org", "target-org", func() { cfg.TargetOrg = *targetOrg })
+		override("source-repo", "source-repo", func() { cfg.SourceRepo = *repoName })
+		override("source-org", "source-org", func() { cfg.SourceOrg = *repoOrg })
+		override("source-url", "source-url", func() { cfg.SourceURL = *sourceURL })
+		override("source-path", "source-path", func() { cfg.SourcePath = *sourcePath })
+		override("token-file", "token-file", func() { cfg.TokenFile = *tokenFile })
+		override("rules-file", "rules-file", func() { cfg.RulesFile = *rulesFile })
+		override("base-publish-script-path", "base-publish-script-path", func() { cfg.BasePublishScriptPath = *basePublishScriptPath })
+		override("github-host", "github-host", func() { cfg.GithubHost = *githubHost })
+		override("base-package", "base-package", func() { cfg.BasePackage = *basePackage })
+		override("command-timeout", "command-timeout", func() { cfg.CommandTimeout = *commandTimeout })
+		if *commandRetries >= 0 {
+			override("command-retries", "command-retries", func() { cfg.CommandRetries = *commandRetries })
+		}
+		if *repoRetries >= 0 {
+			override("repo-retries", "repo-retries", func() { cfg.RepoRetries = *repoRetries })
 		}
+		override("summary-dir", "summary", func() { cfg.Summary.Dir = *summaryDir })
+		override("junit", "summary", func() { cfg.Summary.JUnit = *junit })
+		override("skip-fetch", "skip", func() { cfg.Skip.Fetch = *skipFetch })
+		override("skip-dep-restore", "skip", func() { cfg.Skip.DepRestore = *skipDepRestore })
+		override("skip-tag-push", "skip", func() { cfg.Skip.TagPush = *skipTagPush })
+		override("skip-build-check", "skip", func() { cfg.Skip.BuildCheck = *skipBuildCheck })
+		override("canary-org", "canary", func() { cfg.Canary.Org = *canaryOrg })
+		override("canary-only", "canary", func() { cfg.Canary.Only = *canaryOnly })
+		override("pin", "pins", func() {
+			for k, v := range pins {
+				if cfg.Pins == nil {
+					cfg.Pins = map[string]string{}
+				}
+				cfg.Pins[k] = v
+			}
+		})
 	}
-	baseRepoPath := fmt.Sprintf("%s/%s/%s", gopath, "src", cfg.BasePackage)
 
-	// If RULE_FILE_PATH is detected, check if the source repository include rules files.
-	if len(os.Getenv("RULE_FILE_PATH")) > 0 {
-		cfg.RulesFile = filepath.Join(baseRepoPath, cfg.SourceRepo, os.Getenv("RULE_FILE_PATH"))
+	var tenants []*tenant
+	if *configDir != "" {
+		var err error
+		if tenants, err = loadTenants(*configDir, overrideFlags); err != nil {
+			glog.Fatalf("Failed to load tenants: %v", err)
+		}
+	} else {
+		cfg := config.Config{}
+		if *configFilePath != "" {
+			var err error
+			if cfg, err = loadConfig(*configFilePath); err != nil {
+				glog.Fatal(err)
+			}
+		}
+		overrideFlags(&cfg)
+		baseRepoPath, err := setupConfig(&cfg)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		if *reconcileBranch != "" {
+			if err := reconcile(context.Background(), &cfg, baseRepoPath, *reconcileBranch, *reconcileMode, *reconcileConfirmed, os.Stdin); err != nil {
+				glog.Fatalf("Failed to reconcile %s: %v", *reconcileBranch, err)
+			}
+			stopProfiling()
+			glog.Flush()
+			return
+		}
+		tenants = []*tenant{{config: cfg, baseRepoPath: baseRepoPath}}
 	}
-
-	if len(cfg.RulesFile) == 0 {
-		glog.Fatalf("No rules file provided")
+	if printConfigOnly {
+		for _, t := range tenants {
+			if err := printConfig(os.Stdout, t.name, t.config); err != nil {
+				glog.Fatal(err)
+			}
+		}
+		return
 	}
+	unsetGitIdentityEnv()
 
-	runChan := make(chan bool, 1)
+	// cancel the running publishing cycle on SIGTERM (e.g. on pod deletion) such that
+	// in-flight commands are killed and a checkpoint is written before exiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-sigChan
+		glog.Infof("Received %v, shutting down", sig)
+		cancel()
+	}()
 
 	// start server
-	server := Server{
-		Issue:   cfg.GithubIssue,
-		config:  cfg,
-		RunChan: runChan,
+	for _, t := range tenants {
+		if err := t.init(ctx, *servePprof && *configDir == ""); err != nil {
+			glog.Fatal(err)
+		}
+		if t.interval = time.Duration(*interval) * time.Second; t.interval == 0 && !*runOnce {
+			t.interval = t.config.Interval
+		}
+		t.resultFile = *resultFile
+		if *resultFile != "" && t.name != "" {
+			ext := filepath.Ext(*resultFile)
+			t.resultFile = fmt.Sprintf("%s-%s%s", (*resultFile)[:len(*resultFile)-len(ext)], t.name, ext)
+		}
+	}
+	var server *Server
+	if *configDir == "" {
+		server = tenants[0].server
+	} else {
+		server = newTenantsServer(tenants, *servePprof)
 	}
 	if *serverPort != 0 {
 		if err := server.Run(*serverPort); err != nil {
@@ -157,52 +359,202 @@ func main() {
 		}
 	}
 
-	githubIssueErrorf := glog.Fatalf
-	if *interval != 0 {
-		githubIssueErrorf = glog.Errorf
+	exitCodes := make([]int, len(tenants))
+	var wg sync.WaitGroup
+	for i, t := range tenants {
+		wg.Add(1)
+		go func(i int, t *tenant) {
+			defer wg.Done()
+			exitCodes[i] = t.loop(ctx, *runOnce)
+		}(i, t)
+	}
+	wg.Wait()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		glog.Errorf("Failed to shut down server: %v", err)
+	}
+	stopProfiling()
+	glog.Flush()
+	if exitCode := combineExitCodes(exitCodes); exitCode != 0 {
+		os.Exit(exitCode)
 	}
+}
 
-	for {
-		last := time.Now()
-		publisher := New(&cfg, baseRepoPath)
+// loadConfig reads the config file at the given path.
+func loadConfig(path string) (config.Config, error) {
+	cfg := config.Config{}
+	bs, err := config.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to load config file from %q: %v", path, err)
+	}
+	if err := yaml.Unmarshal(bs, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config file at %q: %v", path, err)
+	}
+	keys, err := config.FileKeys(bs)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to parse config file at %q: %v", path, err)
+	}
+	for _, k := range keys {
+		cfg.SetOrigin(k, config.Origin{Kind: config.OriginFile, Name: path})
+	}
+	return cfg, nil
+}
 
-		if cfg.TokenFile != "" && cfg.GithubIssue != 0 && !cfg.DryRun {
-			// load token
-			bs, err := ioutil.ReadFile(cfg.TokenFile)
-			if err != nil {
-				glog.Fatalf("Failed to load token file from %q: %v", cfg.TokenFile, err)
-			}
-			token := strings.Trim(string(bs), " \t\n")
-
-			// run
-			logs, hash, err := publisher.Run()
-			server.SetHealth(err == nil, hash)
-			if err != nil {
-				glog.Infof("Failed to run publisher: %v", err)
-				if err := ReportOnIssue(err, logs, token, cfg.TargetOrg, cfg.SourceRepo, cfg.GithubIssue); err != nil {
-					githubIssueErrorf("Failed to report logs on github issue: %v", err)
-					server.SetHealth(false, hash)
-				}
-			} else if err := CloseIssue(token, cfg.TargetOrg, cfg.SourceRepo, cfg.GithubIssue); err != nil {
-				githubIssueErrorf("Failed to close issue: %v", err)
-				server.SetHealth(false, hash)
-			}
-		} else {
-			// run
-			_, hash, err := publisher.Run()
-			server.SetHealth(err == nil, hash)
-			if err != nil {
-				glog.Infof("Failed to run publisher: %v", err)
-			}
-		}
+// unsetGitIdentityEnv unsets the git identity environment variables, which
+// setupConfig defaults the identity to, such that the per-repo identities
+// written into the git config of the destination repos take precedence.
+func unsetGitIdentityEnv() {
+	for _, k := range []string{"GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL", "GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL"} {
+		os.Unsetenv(k)
+	}
+}
 
-		if *interval == 0 {
-			break
+// setupConfig validates the config, fills in the defaults and applies the
+// network settings. It returns the path the repositories are checked out in.
+func setupConfig(cfg *config.Config) (string, error) {
+	// resolve the default identity from the environment before it is unset.
+	cfg.ResolveGitIdentity()
+	if err := cfg.GitIdentity.Validate(); err != nil {
+		return "", fmt.Errorf("invalid git-identity (set it in the config or via GIT_COMMITTER_NAME and GIT_COMMITTER_EMAIL): %v", err)
+	}
+
+	if err := cfg.EmailDigest.Validate(); err != nil {
+		return "", fmt.Errorf("invalid email digest configuration: %v", err)
+	}
+	if err := cfg.FailureIssues.Validate(); err != nil {
+		return "", fmt.Errorf("invalid failure-issues configuration: %v", err)
+	}
+	if err := cfg.Redirect.Validate(); err != nil {
+		return "", fmt.Errorf("invalid redirect configuration: %v", err)
+	}
+	if err := cfg.ChatOps.Validate(); err != nil {
+		return "", fmt.Errorf("invalid chatops configuration: %v", err)
+	}
+	if err := cfg.SecretScan.Validate(); err != nil {
+		return "", fmt.Errorf("invalid secret-scan configuration: %v", err)
+	}
+	if err := cfg.LatencySLO.Validate(); err != nil {
+		return "", fmt.Errorf("invalid latency-slo: %v", err)
+	}
+	if err := cfg.Events.Validate(); err != nil {
+		return "", fmt.Errorf("invalid events configuration: %v", err)
+	}
+	if err := cfg.Lint.Validate(); err != nil {
+		return "", fmt.Errorf("invalid lint configuration: %v", err)
+	}
+	if err := cfg.Attestation.Validate(); err != nil {
+		return "", fmt.Errorf("invalid attestation configuration: %v", err)
+	}
+	if err := cfg.ValidateCredentials(); err != nil {
+		return "", fmt.Errorf("invalid credentials configuration: %v", err)
+	}
+	if err := cfg.Fetch.Validate(); err != nil {
+		return "", fmt.Errorf("invalid fetch configuration: %v", err)
+	}
+	if _, err := state.Parse(cfg.StateStore, ""); err != nil {
+		return "", err
+	}
+	if cfg.Interval < 0 {
+		return "", fmt.Errorf("invalid negative interval %v", cfg.Interval)
+	}
+	// branches constructed without the skipped phases are never pushed
+	if skipped := cfg.Skip.Phases(); len(skipped) > 0 && !cfg.DryRun {
+		glog.Warningf("Forcing dry-run because phases are skipped: %s", strings.Join(skipped, ", "))
+		cfg.DryRun = true
+	}
+	if cfg.Snapshots.Enabled() {
+		if strings.HasPrefix(cfg.Snapshots.Location, "configmap://") {
+			return "", fmt.Errorf("invalid snapshot location %q: snapshots are too large for a ConfigMap", cfg.Snapshots.Location)
+		}
+		if _, err := state.Parse(cfg.Snapshots.Location, ""); err != nil {
+			return "", fmt.Errorf("invalid snapshot location: %v", err)
 		}
+		if cfg.Snapshots.Keep < 0 {
+			return "", fmt.Errorf("invalid negative snapshots keep %d", cfg.Snapshots.Keep)
+		}
+	}
+	if cfg.RepoRetries < 0 {
+		return "", fmt.Errorf("invalid negative repo-retries %d", cfg.RepoRetries)
+	}
+	if cfg.CycleTimeout < 0 {
+		return "", fmt.Errorf("invalid negative cycle-timeout %v", cfg.CycleTimeout)
+	}
+	if cfg.PushInterval < 0 {
+		return "", fmt.Errorf("invalid negative push-interval %v", cfg.PushInterval)
+	}
+	if err := cfg.Network.Setup(context.Background(), glog.Warningf); err != nil {
+		return "", err
+	}
+
+	// defaulting to github.com when it is not specified.
+	if cfg.GithubHost == "" {
+		cfg.GithubHost = "github.com"
+	}
+	if cfg.BasePublishScriptPath == "" {
+		cfg.BasePublishScriptPath = defaultBasePublishScriptPath
+	}
 
-		select {
-		case <-runChan:
-		case <-time.After(time.Duration(int(*interval)-int(time.Since(last).Seconds())) * time.Second):
+	var err error
+	cfg.BasePublishScriptPath, err = filepath.Abs(cfg.BasePublishScriptPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for base-publish-script-path %q: %v", cfg.BasePublishScriptPath, err)
+	}
+
+	if cfg.SourceURL != "" {
+		if err := config.ValidateGitURL(cfg.SourceURL); err != nil {
+			return "", fmt.Errorf("invalid source-url: %v", err)
+		}
+		if cfg.SourceRepo == "" {
+			cfg.SourceRepo = config.RepoNameFromURL(cfg.SourceURL)
+			cfg.SetOrigin("source-repo", cfg.OriginOf("source-url"))
 		}
+	} else if len(cfg.SourceRepo) == 0 || len(cfg.SourceOrg) == 0 {
+		return "", fmt.Errorf("source-org and source-repo cannot be empty")
+	}
+	if err := config.ValidateSourceForcePush(cfg.SourceForcePush); err != nil {
+		return "", err
+	}
+	if err := config.ValidateArchivedRepos(cfg.ArchivedRepos); err != nil {
+		return "", err
+	}
+	if cfg.SourcePath != "" {
+		abs, err := filepath.Abs(cfg.SourcePath)
+		if err != nil {
+			return "", fmt.Errorf("invalid source-path: %v", err)
+		}
+		if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+			return "", fmt.Errorf("source-path %s is not a directory", cfg.SourcePath)
+		}
+		if cfg.SourceSeed != "" {
+			return "", fmt.Errorf("source-seed cannot be used with source-path")
+		}
+		cfg.SourcePath = abs
+	}
+
+	if len(cfg.TargetOrg) == 0 {
+		return "", fmt.Errorf("target organization cannot be empty")
+	}
+	if err := cfg.Canary.Validate(cfg.TargetOrg); err != nil {
+		return "", fmt.Errorf("invalid canary configuration: %v", err)
+	}
+
+	cfg.BasePackage = config.DefaultBasePackage(cfg)
+	if cfg.WorkDir != "" && !filepath.IsAbs(cfg.WorkDir) {
+		return "", fmt.Errorf("work-dir must be an absolute path, got %q", cfg.WorkDir)
+	}
+	baseRepoPath := cfg.BaseRepoPath(cfg.BasePackage)
+
+	// If RULE_FILE_PATH is detected, check if the source repository include
+	// rules files, unless the rules file is given as a flag.
+	if len(os.Getenv("RULE_FILE_PATH")) > 0 && cfg.OriginOf("rules-file").Kind != config.OriginFlag {
+		cfg.RulesFile = filepath.Join(cfg.SourceDir(baseRepoPath), os.Getenv("RULE_FILE_PATH"))
+		cfg.SetOrigin("rules-file", config.Origin{Kind: config.OriginEnv, Name: "RULE_FILE_PATH"})
+	}
+
+	if len(cfg.RulesFile) == 0 {
+		return "", fmt.Errorf("no rules file provided")
 	}
+	return baseRepoPath, nil
 }
@@ -0,0 +1,407 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	yaml "gopkg.in/yaml.v2"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// manifestOptions describe the deployment rendered by gen-manifests.
+type manifestOptions struct {
+	Image          string
+	Kind           string // Deployment or StatefulSet
+	Interval       uint
+	ServerPort     int
+	MemoryRequests string
+	MemoryLimits   string
+	Storage        string
+	StorageClass   string
+	// Config and Rules are the contents of the config and rules files. Rules
+	// are not mounted if empty.
+	Config string
+	Rules  string
+	// Token is the github token, a placeholder if empty.
+	Token string
+}
+
+// manifest is a rendered Kubernetes object.
+type manifest struct {
+	Kind string
+	Name string
+	Obj  yaml.MapSlice
+}
+
+// m builds an ordered YAML map from key-value pairs.
+func m(kvs ...interface{}) yaml.MapSlice {
+	ms := yaml.MapSlice{}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		ms = append(ms, yaml.MapItem{Key: kvs[i], Value: kvs[i+1]})
+	}
+	return ms
+}
+
+// publisherArgs returns the command of the publisher container. The flags must
+// exist in the binary, which is checked by checkFlags.
+func publisherArgs(o manifestOptions) []string {
+	args := []string{
+		"/publishing-bot",
+		"--alsologtostderr",
+		"--config=/etc/munge-config/config",
+	}
+	if o.Rules != "" {
+		args = append(args, "--rules-file=/etc/publisher-rules/config")
+	}
+	args = append(args,
+		"--token-file=/etc/secret-volume/token",
+		fmt.Sprintf("--interval=%d", o.Interval),
+	)
+	if o.ServerPort != 0 {
+		args = append(args, fmt.Sprintf("--server-port=%d", o.ServerPort))
+	}
+	return args
+}
+
+// checkFlags returns an error if one of the flags in args is not defined in fs.
+func checkFlags(fs *flag.FlagSet, args []string) error {
+	for _, a := range args {
+		if !strings.HasPrefix(a, "--") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(a, "--"), "=", 2)[0]
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag --%s", name)
+		}
+	}
+	return nil
+}
+
+// renderManifests returns the ConfigMaps, Secret, PVC, workload and Service of
+// the bot.
+func renderManifests(o manifestOptions) ([]manifest, error) {
+	if o.Kind != "Deployment" && o.Kind != "StatefulSet" {
+		return nil, fmt.Errorf("unsupported kind %q, expected Deployment or StatefulSet", o.Kind)
+	}
+	// stringData keeps the placeholder editable in place, without encoding
+	token := "TOKEN"
+	if o.Token != "" {
+		token = strings.TrimSpace(o.Token)
+	}
+	labels := m("name", "publisher")
+
+	var ms []manifest
+	ms = append(ms, manifest{"ConfigMap", "publisher-config", m(
+		"apiVersion", "v1",
+		"kind", "ConfigMap",
+		"metadata", m("name", "publisher-config"),
+		"data", m("config", o.Config),
+	)})
+	if o.Rules != "" {
+		ms = append(ms, manifest{"ConfigMap", "publisher-rules", m(
+			"apiVersion", "v1",
+			"kind", "ConfigMap",
+			"metadata", m("name", "publisher-rules"),
+			"data", m("config", o.Rules),
+		)})
+	}
+	ms = append(ms, manifest{"Secret", "github-token", m(
+		"apiVersion", "v1",
+		"kind", "Secret",
+		"metadata", m("name", "github-token"),
+		"type", "Opaque",
+		"stringData", m("token", token),
+	)})
+
+	pvcSpec := m(
+		"accessModes", []string{"ReadWriteOnce"},
+		"resources", m("requests", m("storage", o.Storage)),
+	)
+	if o.StorageClass != "" {
+		pvcSpec = append(pvcSpec, yaml.MapItem{Key: "storageClassName", Value: o.StorageClass})
+	}
+	pvcMeta := m("name", "publisher-gopath", "labels", m("app", "publisher"))
+
+	volumes := []yaml.MapSlice{
+		m("name", "munge-config", "configMap", m("name", "publisher-config")),
+	}
+	mounts := []yaml.MapSlice{
+		m("mountPath", "/etc/munge-config", "name", "munge-config"),
+	}
+	initArgs := []string{"/init-repo", "--alsologtostderr", "--config=/etc/munge-config/config"}
+	if o.Rules != "" {
+		volumes = append(volumes, m("name", "publisher-rules", "configMap", m("name", "publisher-rules")))
+		mounts = append(mounts, m("mountPath", "/etc/publisher-rules", "name", "publisher-rules"))
+		initArgs = append(initArgs, "--rules-file=/etc/publisher-rules/config")
+	}
+	volumes = append(volumes,
+		m("name", "secret-volume", "secret", m("secretName", "github-token")),
+		m("name", "cache", "emptyDir", m()),
+	)
+	mounts = append(mounts,
+		m("mountPath", "/go-workspace", "name", "publisher-gopath"),
+		m("mountPath", "/.cache", "name", "cache"),
+	)
+	if o.Kind == "Deployment" {
+		volumes = append(volumes, m("name", "publisher-gopath", "persistentVolumeClaim", m("claimName", "publisher-gopath")))
+	}
+
+	publisherMounts := append([]yaml.MapSlice{}, mounts...)
+	publisherMounts = append(publisherMounts, m("mountPath", "/etc/secret-volume", "name", "secret-volume"))
+	resources := m(
+		"requests", m("cpu", "300m", "memory", o.MemoryRequests),
+		"limits", m("cpu", "2", "memory", o.MemoryLimits),
+	)
+	container := m(
+		"name", "publisher",
+		"image", o.Image,
+		"imagePullPolicy", "Always",
+		"command", publisherArgs(o),
+		"resources", resources,
+		"volumeMounts", publisherMounts,
+	)
+	if o.ServerPort != 0 {
+		container = append(container, yaml.MapItem{Key: "ports", Value: []yaml.MapSlice{m("name", "http", "containerPort", o.ServerPort)}})
+	}
+	podSpec := m(
+		// give the publisher time to kill running git commands and write a checkpoint
+		"terminationGracePeriodSeconds", 60,
+		"initContainers", []yaml.MapSlice{m(
+			"name", "initialize-repos",
+			"image", o.Image,
+			"imagePullPolicy", "Always",
+			"command", initArgs,
+			"resources", resources,
+			"volumeMounts", mounts,
+		)},
+		"containers", []yaml.MapSlice{container},
+		"volumes", volumes,
+	)
+	template := m("metadata", m("labels", labels), "spec", podSpec)
+
+	switch o.Kind {
+	case "Deployment":
+		ms = append(ms, manifest{"PersistentVolumeClaim", "publisher-gopath", m(
+			"apiVersion", "v1",
+			"kind", "PersistentVolumeClaim",
+			"metadata", pvcMeta,
+			"spec", pvcSpec,
+		)})
+		ms = append(ms, manifest{"Deployment", "publisher", m(
+			"apiVersion", "apps/v1",
+			"kind", "Deployment",
+			"metadata", m("name", "publisher"),
+			"spec", m(
+				"replicas", 1,
+				// the volume can only be mounted by one pod at a time
+				"strategy", m("type", "Recreate"),
+				"selector", m("matchLabels", labels),
+				"template", template,
+			),
+		)})
+	case "StatefulSet":
+		ms = append(ms, manifest{"StatefulSet", "publisher", m(
+			"apiVersion", "apps/v1",
+			"kind", "StatefulSet",
+			"metadata", m("name", "publisher"),
+			"spec", m(
+				"replicas", 1,
+				"serviceName", "health",
+				"selector", m("matchLabels", labels),
+				"template", template,
+				"volumeClaimTemplates", []yaml.MapSlice{m("metadata", pvcMeta, "spec", pvcSpec)},
+			),
+		)})
+	}
+
+	if o.ServerPort != 0 {
+		ms = append(ms, manifest{"Service", "health", m(
+			"apiVersion", "v1",
+			"kind", "Service",
+			"metadata", m("name", "health"),
+			"spec", m(
+				"selector", labels,
+				"ports", []yaml.MapSlice{m("name", "http", "protocol", "TCP", "port", 80, "targetPort", o.ServerPort)},
+			),
+		)})
+	}
+	return ms, nil
+}
+
+// writeManifests writes the manifests as one multi-document stream.
+func writeManifests(w io.Writer, ms []manifest) error {
+	for i, mf := range ms {
+		bs, err := yaml.Marshal(mf.Obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(bs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeKustomizeBase writes one file per manifest and a kustomization.yaml
+// listing them into dir.
+func writeKustomizeBase(dir, namespace string, ms []manifest) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var resources []string
+	for _, mf := range ms {
+		var buf bytes.Buffer
+		if err := writeManifests(&buf, []manifest{mf}); err != nil {
+			return err
+		}
+		name := strings.ToLower(mf.Kind) + "-" + mf.Name + ".yaml"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		resources = append(resources, name)
+	}
+	k := m("apiVersion", "kustomize.config.k8s.io/v1beta1", "kind", "Kustomization")
+	if namespace != "" {
+		k = append(k, yaml.MapItem{Key: "namespace", Value: namespace})
+	}
+	k = append(k, yaml.MapItem{Key: "resources", Value: resources})
+	bs, err := yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "kustomization.yaml"), bs, 0644)
+}
+
+// configRules returns the content of the rules-file of the config file as YAML,
+// or "" if it is not a local file. Remote rules, i.e. URLs and custom resources,
+// are loaded by the publisher at runtime and are not rendered. Relative paths
+// are relative to the current directory, as for the publisher.
+func configRules(configFile string) (string, error) {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return "", err
+	}
+	if cfg.RulesFile == "" {
+		return "", nil
+	}
+	if _, ok := config.CRDNamespace(cfg.RulesFile); ok {
+		return "", nil
+	}
+	files, err := config.RulesFiles(cfg.RulesFile)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if u, err := url.ParseRequestURI(f); err == nil && len(u.Host) > 0 {
+			return "", nil
+		}
+	}
+	if len(files) > 1 {
+		return "", fmt.Errorf("rules-file %q consists of %d files, pass the merged rules with -rules-file", cfg.RulesFile, len(files))
+	}
+	bs, err := config.ReadFile(files[0])
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// genManifests implements the gen-manifests subcommand. daemonFlags are the
+// flags of the publisher, which the rendered command line is checked against.
+func genManifests(daemonFlags *flag.FlagSet, args []string) {
+	fs := flag.NewFlagSet("gen-manifests", flag.ExitOnError)
+	configFile := fs.String("config", "", "the config file in yaml format (required)")
+	rulesFile := fs.String("rules-file", "", "the file with repository rules, rendered into a ConfigMap. If empty, a local rules-file of the config is rendered")
+	tokenFile := fs.String("token-file", "", "the file with the github token. If empty, the Secret has a TOKEN placeholder")
+	kind := fs.String("kind", "Deployment", "the workload kind: Deployment or StatefulSet")
+	image := fs.String("image", "k8s-publishing-bot", "the container image")
+	namespace := fs.String("namespace", "", "the namespace of the kustomize base")
+	interval := fs.Uint("interval", 86400, "the seconds of wait between publishing runs")
+	serverPort := fs.Int("server-port", 8080, "the port of the health server, 0 to disable it")
+	memoryRequests := fs.String("memory-requests", "200Mi", "the memory requests of the containers")
+	memoryLimits := fs.String("memory-limits", "1.6Gi", "the memory limits of the containers")
+	storage := fs.String("storage", "100Gi", "the size of the volume with the repositories")
+	storageClass := fs.String("storage-class", "ssd", "the storage class of the volume, empty for the default")
+	outputDir := fs.String("output-dir", "", "write a kustomize base into this directory instead of printing the manifests")
+	parseFlags(fs, args)
+
+	if *configFile == "" {
+		glog.Fatalf("-config is required")
+	}
+	o := manifestOptions{
+		Image:          *image,
+		Kind:           *kind,
+		Interval:       *interval,
+		ServerPort:     *serverPort,
+		MemoryRequests: *memoryRequests,
+		MemoryLimits:   *memoryLimits,
+		Storage:        *storage,
+		StorageClass:   *storageClass,
+	}
+	for _, f := range []struct {
+		path    string
+		content *string
+	}{{*configFile, &o.Config}, {*rulesFile, &o.Rules}, {*tokenFile, &o.Token}} {
+		if f.path == "" {
+			continue
+		}
+		bs, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			glog.Fatalf("Failed to read %s: %v", f.path, err)
+		}
+		*f.content = string(bs)
+	}
+	if *rulesFile == "" {
+		rules, err := configRules(*configFile)
+		if err != nil {
+			glog.Fatalf("Failed to read the rules-file of %s: %v", *configFile, err)
+		}
+		o.Rules = rules
+	}
+	if err := checkFlags(daemonFlags, publisherArgs(o)); err != nil {
+		glog.Fatalf("Rendered command line does not match the binary: %v", err)
+	}
+
+	ms, err := renderManifests(o)
+	if err != nil {
+		glog.Fatalf("Failed to render manifests: %v", err)
+	}
+	if *outputDir != "" {
+		err = writeKustomizeBase(*outputDir, *namespace, ms)
+	} else {
+		err = writeManifests(os.Stdout, ms)
+	}
+	if err != nil {
+		glog.Fatalf("Failed to write manifests: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestRenderManifests(t *testing.T) {
+	o := manifestOptions{
+		Image:          "gcr.io/example/publishing-bot",
+		Interval:       3600,
+		ServerPort:     8080,
+		MemoryRequests: "200Mi",
+		MemoryLimits:   "1.6Gi",
+		Storage:        "100Gi",
+		Config:         "source-org: kubernetes\n",
+		Rules:          "rules: []\n",
+		Token:          "secret-token\n",
+	}
+	for _, tt := range []struct {
+		kind  string
+		kinds []string
+	}{
+		{"Deployment", []string{"ConfigMap", "ConfigMap", "Secret", "PersistentVolumeClaim", "Deployment", "Service"}},
+		{"StatefulSet", []string{"ConfigMap", "ConfigMap", "Secret", "StatefulSet", "Service"}},
+	} {
+		o.Kind = tt.kind
+		ms, err := renderManifests(o)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.kind, err)
+		}
+		var buf bytes.Buffer
+		if err := writeManifests(&buf, ms); err != nil {
+			t.Fatal(err)
+		}
+		docs := strings.Split(buf.String(), "---\n")
+		if len(docs) != len(tt.kinds) {
+			t.Fatalf("%s: expected %d documents, got %d", tt.kind, len(tt.kinds), len(docs))
+		}
+		for i, doc := range docs {
+			var obj struct {
+				Kind string `yaml:"kind"`
+			}
+			if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+				t.Fatalf("%s: invalid document %d: %v", tt.kind, i, err)
+			}
+			if obj.Kind != tt.kinds[i] {
+				t.Errorf("%s: expected document %d of kind %s, got %s", tt.kind, i, tt.kinds[i], obj.Kind)
+			}
+		}
+		if !strings.Contains(buf.String(), "--interval=3600") {
+			t.Errorf("%s: expected the interval flag in\n%s", tt.kind, buf.String())
+		}
+		if !strings.Contains(buf.String(), "stringData:\n  token: secret-token\n") {
+			t.Errorf("%s: expected the plain token in the stringData of the Secret in\n%s", tt.kind, buf.String())
+		}
+	}
+
+	o.Kind = "ReplicationController"
+	if _, err := renderManifests(o); err == nil {
+		t.Errorf("expected an error for an unsupported kind")
+	}
+}
+
+func TestCheckFlags(t *testing.T) {
+	fs := flag.NewFlagSet("publishing-bot", flag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.Uint("interval", 0, "")
+	if err := checkFlags(fs, []string{"/publishing-bot", "--config=/etc/config", "--interval=0"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkFlags(fs, []string{"/publishing-bot", "--token-file=/token"}); err == nil {
+		t.Errorf("expected an error for an unknown flag")
+	}
+}
+
+func TestConfigRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	yamlRules := write("rules.yaml", "rules:\n- destination: client-go\n")
+	jsonRules := write("rules.json", `{"rules": [{"destination": "api"}]}`)
+
+	tests := []struct {
+		name      string
+		rulesFile string
+		want      string
+		wantErr   bool
+	}{
+		{"no rules-file", "", "", false},
+		{"local file", yamlRules, "rules:\n- destination: client-go\n", false},
+		{"converted to yaml", jsonRules, "destination: api", false},
+		{"url", "https://example.com/rules.yaml", "", false},
+		{"custom resources", "crd://publishing-bot", "", false},
+		{"several files", yamlRules + "," + jsonRules, "", true},
+		{"missing file", filepath.Join(dir, "missing.yaml"), "", true},
+	}
+	for _, tt := range tests {
+		cfg := write("config.yaml", "source-org: kubernetes\nrules-file: "+tt.rulesFile+"\n")
+		got, err := configRules(cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+			continue
+		}
+		if !strings.Contains(got, tt.want) || (tt.want == "") != (got == "") {
+			t.Errorf("%s: expected rules containing %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestEnsureMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		repo        string
+		topics      []string
+		metadata    config.RepositoryMetadata
+		wantChanged []string
+		wantCalls   []string
+	}{
+		{"unmanaged", `{"description": "Go client"}`, nil, config.RepositoryMetadata{}, nil, nil},
+		{"up to date", `{"description": "Go client", "homepage": "https://k8s.io"}`, []string{"go", "kubernetes"},
+			config.RepositoryMetadata{Description: "Go client", Homepage: "https://k8s.io", Topics: []string{"kubernetes", "go"}},
+			nil, []string{"GET topics"}},
+		{"changed", `{"description": "old", "homepage": "https://k8s.io"}`, []string{"go"},
+			config.RepositoryMetadata{Description: "Go client", Homepage: "https://k8s.io", Topics: []string{"kubernetes", "go"}},
+			[]string{"description", "topics"}, []string{
+				`PATCH {"name":"client-go","description":"Go client"}`,
+				"GET topics",
+				`PUT topics {"names":["kubernetes","go"]}`,
+			}},
+		{"archive", `{"description": "Go client"}`, nil,
+			config.RepositoryMetadata{Archived: true},
+			[]string{"archived"}, []string{`PATCH {"name":"client-go","archived":true}`}},
+		{"archived", `{"description": "old", "archived": true}`, nil,
+			config.RepositoryMetadata{Description: "Go client"},
+			nil, nil},
+	}
+	for _, tt := range tests {
+		var calls []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/repos/k8s-publishing-bot/client-go":
+				fmt.Fprint(w, tt.repo)
+			case r.Method == "PATCH" && r.URL.Path == "/repos/k8s-publishing-bot/client-go":
+				calls = append(calls, "PATCH "+string(body))
+				fmt.Fprint(w, tt.repo)
+			case r.Method == "GET" && r.URL.Path == "/repos/k8s-publishing-bot/client-go/topics":
+				calls = append(calls, "GET topics")
+				json.NewEncoder(w).Encode(map[string][]string{"names": tt.topics})
+			case r.Method == "PUT" && r.URL.Path == "/repos/k8s-publishing-bot/client-go/topics":
+				calls = append(calls, "PUT topics "+string(body))
+				w.Write(body)
+			default:
+				t.Errorf("%s: unexpected request %s %s", tt.name, r.Method, r.URL.Path)
+				http.NotFound(w, r)
+			}
+		}))
+		client := github.NewClient(nil)
+		client.BaseURL, _ = url.Parse(server.URL + "/")
+
+		changed, err := ensureMetadata(context.Background(), client, "k8s-publishing-bot", "client-go", tt.metadata)
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: ensureMetadata() failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(changed, tt.wantChanged) {
+			t.Errorf("%s: ensureMetadata() = %q, want %q", tt.name, changed, tt.wantChanged)
+		}
+		for i := range calls {
+			// the JSON encoder of go-github ends the body with a newline
+			calls[i] = strings.TrimSpace(calls[i])
+		}
+		if !reflect.DeepEqual(calls, tt.wantCalls) {
+			t.Errorf("%s: got the requests %q, want %q", tt.name, calls, tt.wantCalls)
+		}
+	}
+}
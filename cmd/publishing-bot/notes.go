@@ -0,0 +1,228 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// notesRef keeps the publishing metadata of the commits of destination repos.
+const notesRef = "refs/notes/publishing-bot"
+
+// publishingNote is the publishing metadata of a destination commit.
+type publishingNote struct {
+	SourceRepository string `json:"sourceRepository,omitempty"`
+	SourceBranch     string `json:"sourceBranch,omitempty"`
+	SourceCommit     string `json:"sourceCommit,omitempty"`
+	// Run is the start time of the publishing run.
+	Run         string `json:"run,omitempty"`
+	RulesDigest string `json:"rulesDigest,omitempty"`
+	BotVersion  string `json:"botVersion,omitempty"`
+}
+
+// noteKeys are the keys of the note lines, in order.
+var noteKeys = []string{"Source-repository", "Source-branch", "Source-commit", "Run", "Rules-digest", "Bot-version"}
+
+func (n *publishingNote) fields() []*string {
+	return []*string{&n.SourceRepository, &n.SourceBranch, &n.SourceCommit, &n.Run, &n.RulesDigest, &n.BotVersion}
+}
+
+// String returns the note as "<key>: <value>" lines.
+func (n publishingNote) String() string {
+	var lines []string
+	for i, f := range n.fields() {
+		if *f != "" {
+			lines = append(lines, noteKeys[i]+": "+*f)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// parseNote parses a note written by String. Unknown lines are ignored.
+func parseNote(s string) publishingNote {
+	var n publishingNote
+	fields := n.fields()
+	for _, l := range strings.Split(s, "\n") {
+		for i, k := range noteKeys {
+			if strings.HasPrefix(l, k+": ") {
+				*fields[i] = strings.TrimSpace(strings.TrimPrefix(l, k+": "))
+			}
+		}
+	}
+	return n
+}
+
+// addNotes adds publishing notes to the new commits of the branch in the
+// destination repo in the publisher's directory, on top of the notes fetched from
+// origin. push.sh pushes them with the branch.
+func (p *PublisherMunger) addNotes(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule, env []string) error {
+	if !p.config.Notes {
+		return nil
+	}
+	out, err := p.outputWithTimeout(ctx, "fetch", func() *exec.Cmd {
+		cmd := exec.Command("git", "ls-remote", "origin", notesRef)
+		cmd.Env = env
+		return cmd
+	})
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %v", notesRef, err)
+	}
+	if strings.TrimSpace(string(out)) != "" {
+		err := p.runWithTimeout(ctx, "fetch", func() *exec.Cmd {
+			cmd := exec.Command("git", "fetch", "-q", "origin", "+"+notesRef+":"+notesRef)
+			cmd.Env = env
+			return cmd
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %v", notesRef, err)
+		}
+	}
+
+	rng := "origin/" + branchRule.Name + ".." + branchRule.Name
+	if err := p.command(ctx, "git", "rev-parse", "--verify", "-q", "origin/"+branchRule.Name).Run(); err != nil {
+		rng = branchRule.Name
+	}
+	out, err = p.command(ctx, "git", "log", "--format=%H%x00%B%x00", rng).Output()
+	if err != nil {
+		return err
+	}
+	digest, err := rulesDigest(p.reposRules)
+	if err != nil {
+		return err
+	}
+	n := publishingNote{
+		SourceRepository: p.config.SourceRepoURL(),
+		SourceBranch:     branchRule.Source.Branch,
+		Run:              p.result.Start.UTC().Format(time.RFC3339),
+		RulesDigest:      "sha256:" + digest,
+		BotVersion:       version,
+	}
+	added := 0
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		n.SourceCommit = sourceCommitInMessage(fields[i+1], config.CommitMsgTag(p.config.SourceRepo)+": ")
+		if n.SourceCommit == "" {
+			// e.g. commits of the bot updating dependencies
+			continue
+		}
+		commit := strings.TrimSpace(fields[i])
+		if err := p.command(ctx, "git", "notes", "--ref="+notesRef, "add", "-f", "-m", n.String(), commit).Run(); err != nil {
+			return fmt.Errorf("failed to add note to %s: %v", commit, err)
+		}
+		added++
+	}
+	p.plog.Infof("Added publishing notes to %d commits of branch %s of %s", added, branchRule.Name, repoRule.DestinationRepository)
+	return nil
+}
+
+// notesRef returns the notes ref push.sh pushes, or the empty string.
+func (p *PublisherMunger) notesRef() string {
+	if !p.config.Notes {
+		return ""
+	}
+	return notesRef
+}
+
+// notesCommand is the notes subcommand. It prints the publishing notes of
+// commits in the published repo in the current directory and returns the exit
+// code.
+func notesCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("notes", flag.ExitOnError)
+	fetch := fs.Bool("fetch", false, "fetch the notes from origin first")
+	count := fs.Int("n", 1, "the number of commits to print, following the history of the revision")
+	asJSON := fs.Bool("json", false, "print the notes as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s notes [-fetch] [-n <count>] [-json] [<revision>]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	rev := "HEAD"
+	if fs.NArg() == 1 {
+		rev = fs.Arg(0)
+	}
+
+	if *fetch {
+		cmd := exec.Command("git", "fetch", "-q", "origin", "+"+notesRef+":"+notesRef)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch %s: %v\n", notesRef, err)
+			return 1
+		}
+	}
+	bs, err := exec.Command("git", "log", "--format=%H%x00%N%x00", "--no-notes", "--notes="+notesRef, "-n", strconv.Itoa(*count), rev).Output()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read the notes of %s: %v\n", rev, err)
+		return 1
+	}
+	notes := parseNotesLog(string(bs))
+	if *asJSON {
+		bs, err := json.MarshalIndent(notes, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(out, "%s\n", bs)
+		return 0
+	}
+	for _, n := range notes {
+		fmt.Fprintf(out, "commit %s\n", n.Commit)
+		if n.Note == nil {
+			fmt.Fprintf(out, "  no publishing note\n")
+			continue
+		}
+		for _, l := range strings.Split(strings.TrimSpace(n.Note.String()), "\n") {
+			fmt.Fprintf(out, "  %s\n", l)
+		}
+	}
+	return 0
+}
+
+// commitNote is a commit with its publishing note, if any.
+type commitNote struct {
+	Commit string          `json:"commit"`
+	Note   *publishingNote `json:"note,omitempty"`
+}
+
+// parseNotesLog parses the output of git log --format=%H%x00%N%x00.
+func parseNotesLog(log string) []commitNote {
+	var notes []commitNote
+	fields := strings.Split(log, "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		cn := commitNote{Commit: strings.TrimSpace(fields[i])}
+		if strings.TrimSpace(fields[i+1]) != "" {
+			n := parseNote(fields[i+1])
+			cn.Note = &n
+		}
+		notes = append(notes, cn)
+	}
+	return notes
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPublishingNote(t *testing.T) {
+	n := publishingNote{
+		SourceRepository: "https://github.com/kubernetes/kubernetes",
+		SourceBranch:     "master",
+		SourceCommit:     "0123456789abcdef0123456789abcdef01234567",
+		Run:              "2018-05-01T10:00:00Z",
+		RulesDigest:      "sha256:abc",
+		BotVersion:       "v0.1.0",
+	}
+	s := n.String()
+	if expected := "Source-repository: https://github.com/kubernetes/kubernetes\nSource-branch: master\n" +
+		"Source-commit: 0123456789abcdef0123456789abcdef01234567\nRun: 2018-05-01T10:00:00Z\n" +
+		"Rules-digest: sha256:abc\nBot-version: v0.1.0\n"; s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+	if got := parseNote(s + "Unknown: x\n"); got != n {
+		t.Errorf("expected %+v, got %+v", n, got)
+	}
+}
+
+func TestParseNotesLog(t *testing.T) {
+	log := "aaa\x00Source-commit: 123\nRun: r\n\n\x00\nbbb\x00\x00\n"
+	expected := []commitNote{
+		{Commit: "aaa", Note: &publishingNote{SourceCommit: "123", Run: "r"}},
+		{Commit: "bbb"},
+	}
+	if got := parseNotesLog(log); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
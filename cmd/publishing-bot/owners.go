@@ -0,0 +1,194 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+const (
+	ownersAliasesFile = "OWNERS_ALIASES"
+	codeOwnersFile    = ".github/CODEOWNERS"
+	generatedHeader   = "# This file is generated by the publishing-bot from %s. DO NOT EDIT.\n"
+)
+
+// ownersFile is the part of an OWNERS file relevant for review routing.
+type ownersFile struct {
+	Approvers []string `yaml:"approvers"`
+	Reviewers []string `yaml:"reviewers"`
+}
+
+type ownersAliases struct {
+	Aliases map[string][]string `yaml:"aliases"`
+}
+
+// syncOwners writes OWNERS_ALIASES and .github/CODEOWNERS into the checked out
+// destination branch, derived from the published OWNERS files and the source
+// repository's OWNERS_ALIASES, and commits them if they changed.
+func (p *PublisherMunger) syncOwners(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) error {
+	if !repoRule.Owners.Aliases && !repoRule.Owners.CodeOwners {
+		return nil
+	}
+
+	// the source ref is set to the pin, if any, by construct.sh
+	var aliases ownersAliases
+	src := fmt.Sprintf("upstream/%s", branchRule.Source.Branch)
+	if bs, err := p.command(ctx, "git", "show", src+":"+ownersAliasesFile).Output(); err == nil {
+		if err := yaml.Unmarshal(bs, &aliases); err != nil {
+			return fmt.Errorf("failed to parse %s of %s: %v", ownersAliasesFile, src, err)
+		}
+	}
+
+	owners, err := readOwnersFiles(p.path("."))
+	if err != nil {
+		return err
+	}
+
+	header := fmt.Sprintf(generatedHeader, p.config.SourceRepo)
+	files := map[string][]byte{}
+	if repoRule.Owners.Aliases {
+		files[ownersAliasesFile] = append([]byte(header), referencedAliases(owners, aliases.Aliases)...)
+	}
+	if repoRule.Owners.CodeOwners {
+		files[codeOwnersFile] = append([]byte(header), codeOwners(owners, aliases.Aliases)...)
+	}
+
+	var changed []string
+	for pth, content := range files {
+		old, err := ioutil.ReadFile(p.path(pth))
+		if err == nil && !bytes.HasPrefix(old, []byte(header)) {
+			p.plog.Infof("Not overwriting %s of %s which is not generated", pth, branchRule.Name)
+			continue
+		}
+		if bytes.Equal(old, content) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p.path(pth)), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p.path(pth), content, 0644); err != nil {
+			return err
+		}
+		if err := p.command(ctx, "git", "add", pth).Run(); err != nil {
+			return fmt.Errorf("failed to add %s: %v", pth, err)
+		}
+		changed = append(changed, pth)
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	sort.Strings(changed)
+	p.plog.Infof("Updating %s of %s", strings.Join(changed, " and "), branchRule.Name)
+	return p.plog.Run(p.gitCommit(ctx, "sync: update "+strings.Join(changed, " and ")))
+}
+
+// readOwnersFiles returns the OWNERS files below dir by directory, ignoring
+// vendor/.
+func readOwnersFiles(dir string) (map[string]ownersFile, error) {
+	owners := map[string]ownersFile{}
+	err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (info.Name() == ".git" || info.Name() == "vendor") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || info.Name() != "OWNERS" {
+			return nil
+		}
+		bs, err := ioutil.ReadFile(pth)
+		if err != nil {
+			return err
+		}
+		var o ownersFile
+		if err := yaml.Unmarshal(bs, &o); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", pth, err)
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(pth))
+		if err != nil {
+			return err
+		}
+		owners[filepath.ToSlash(rel)] = o
+		return nil
+	})
+	return owners, err
+}
+
+// referencedAliases returns the OWNERS_ALIASES content with those aliases
+// used in the given OWNERS files.
+func referencedAliases(owners map[string]ownersFile, aliases map[string][]string) []byte {
+	used := map[string][]string{}
+	for _, o := range owners {
+		for _, name := range append(append([]string(nil), o.Approvers...), o.Reviewers...) {
+			if members, found := aliases[name]; found {
+				used[name] = members
+			}
+		}
+	}
+	bs, _ := yaml.Marshal(ownersAliases{Aliases: used})
+	return bs
+}
+
+// codeOwners returns the CODEOWNERS content for the given OWNERS files by
+// directory, with the approvers as code owners and aliases expanded. Later
+// lines take precedence in CODEOWNERS, hence the lines are sorted by path.
+func codeOwners(owners map[string]ownersFile, aliases map[string][]string) []byte {
+	var dirs []string
+	for dir := range owners {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	buf := bytes.NewBuffer(nil)
+	for _, dir := range dirs {
+		var users []string
+		seen := map[string]bool{}
+		for _, name := range owners[dir].Approvers {
+			members, found := aliases[name]
+			if !found {
+				members = []string{name}
+			}
+			for _, m := range members {
+				if m = strings.ToLower(m); !seen[m] {
+					seen[m] = true
+					users = append(users, "@"+m)
+				}
+			}
+		}
+		if len(users) == 0 {
+			continue
+		}
+		pattern := "*"
+		if dir != "." {
+			pattern = "/" + dir + "/"
+		}
+		fmt.Fprintf(buf, "%s %s\n", pattern, strings.Join(users, " "))
+	}
+	return buf.Bytes()
+}
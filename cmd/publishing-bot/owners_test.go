@@ -0,0 +1,48 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCodeOwners(t *testing.T) {
+	owners := map[string]ownersFile{
+		".":           {Approvers: []string{"api-approvers", "Alice"}},
+		"tools/cache": {Approvers: []string{"bob"}, Reviewers: []string{"carol"}},
+		"rest":        {Reviewers: []string{"dave"}},
+	}
+	aliases := map[string][]string{
+		"api-approvers": {"alice", "erin"},
+		"unused":        {"frank"},
+	}
+	want := `* @alice @erin
+/tools/cache/ @bob
+`
+	if got := string(codeOwners(owners, aliases)); got != want {
+		t.Errorf("codeOwners() = %q, want %q", got, want)
+	}
+
+	wantAliases := `aliases:
+  api-approvers:
+  - alice
+  - erin
+`
+	if got := string(referencedAliases(owners, aliases)); got != wantAliases {
+		t.Errorf("referencedAliases() = %q, want %q", got, wantAliases)
+	}
+}
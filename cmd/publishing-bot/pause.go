@@ -0,0 +1,105 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+// pauseCommand is the pause and resume subcommand. It changes the pause state
+// in the state store of the config, which a running bot picks up at the start
+// of its next run. Without a destination, pause lists the paused repos and
+// branches. It returns the exit code.
+func pauseCommand(name string, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configFile := fs.String("config", "", "the config file in yaml format (required)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s -config <config-yaml-file> [<repo>[/<branch>]", os.Args[0], name)
+		if name == "pause" {
+			fmt.Fprintf(os.Stderr, " [<reason>]")
+		}
+		fmt.Fprintf(os.Stderr, "]\n\n")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *configFile == "" || (name == "resume" && fs.NArg() != 1) {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	pauses, err := loadConfigPauseState(&cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load paused repositories: %v\n", err)
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		paused := pauses.List()
+		for _, key := range sortedPauseKeys(paused) {
+			fmt.Fprintf(out, "%s\tpaused since %s\t%s\n", key, paused[key].Since.Format(time.RFC3339), paused[key].Reason)
+		}
+		return 0
+	}
+
+	key := fs.Arg(0)
+	if ss := strings.SplitN(key, "/", 2); ss[0] == "" || (len(ss) == 2 && ss[1] == "") {
+		fmt.Fprintf(os.Stderr, "Invalid destination %q, expected <repo> or <repo>/<branch>\n", key)
+		return 2
+	}
+	if name == "pause" {
+		if err := pauses.Pause(key, strings.Join(fs.Args()[1:], " ")); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to pause %s: %v\n", key, err)
+			return 2
+		}
+		fmt.Fprintf(out, "Paused %s.\n", key)
+		return 0
+	}
+	resumed, err := pauses.Resume(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resume %s: %v\n", key, err)
+		return 2
+	} else if !resumed {
+		fmt.Fprintf(os.Stderr, "%s is not paused.\n", key)
+		return 1
+	}
+	fmt.Fprintf(out, "Resumed %s.\n", key)
+	return 0
+}
+
+// loadConfigPauseState loads the pause state from the state store of the
+// config, by default the base repo path.
+func loadConfigPauseState(cfg *config.Config) (*PauseState, error) {
+	baseRepoPath := cfg.BaseRepoPath(config.DefaultBasePackage(cfg))
+	store, err := state.Parse(cfg.StateStore, baseRepoPath)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPauseState(store)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+func TestPauseCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte("target-org: kubernetes\nstate-store: "+dir+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a running bot holding the state
+	pauses, err := LoadPauseState(state.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pauses.Pause("api", "api review"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		code     int
+		expected string
+	}{
+		{"pause", []string{"client-go/release-1.10", "broken", "build"}, 0, "Paused client-go/release-1.10."},
+		{"pause", nil, 0, "api\tpaused since "},
+		{"pause", nil, 0, "client-go/release-1.10\tpaused since "},
+		{"resume", []string{"client-go"}, 1, ""},
+		{"resume", []string{"api"}, 0, "Resumed api."},
+		{"pause", []string{"/master"}, 2, ""},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if code := pauseCommand(tt.name, append([]string{"-config", configFile}, tt.args...), &out); code != tt.code {
+			t.Errorf("%s %v: expected exit code %d, got %d", tt.name, tt.args, tt.code, code)
+		}
+		if !strings.Contains(out.String(), tt.expected) {
+			t.Errorf("%s %v: expected output containing %q, got %q", tt.name, tt.args, tt.expected, out.String())
+		}
+	}
+
+	// the running bot picks the changes up
+	if err := pauses.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := pauses.Paused("api"); found {
+		t.Errorf("expected api to be resumed")
+	}
+	if p, found := pauses.PausedBranch("client-go", "release-1.10"); !found || p.Reason != "broken build" {
+		t.Errorf("expected client-go/release-1.10 to be paused, got %+v, %v", p, found)
+	}
+	if _, found := pauses.PausedBranch("client-go", "master"); found {
+		t.Errorf("expected client-go/master not to be paused")
+	}
+}
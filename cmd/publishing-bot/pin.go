@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// pinFlag collects -pin flags of the form <destination>/<branch>=<revision> or
+// <branch>=<revision>.
+type pinFlag map[string]string
+
+func (f pinFlag) String() string {
+	var pins []string
+	for k, v := range f {
+		pins = append(pins, k+"="+v)
+	}
+	return strings.Join(pins, ",")
+}
+
+func (f pinFlag) Set(s string) error {
+	ss := strings.SplitN(s, "=", 2)
+	if len(ss) != 2 || ss[0] == "" {
+		return fmt.Errorf("expected <destination>/<branch>=<revision> or <branch>=<revision>, got %q", s)
+	}
+	f[ss[0]] = ss[1]
+	return nil
+}
+
+// pinFor returns the source revision the branch is pinned to, or the empty
+// string. Pins in the config, i.e. from the command line, override the rules.
+func (p *PublisherMunger) pinFor(repoRule config.RepositoryRule, branchRule config.BranchRule) string {
+	if pin, found := p.config.Pins[repoRule.DestinationRepository+"/"+branchRule.Name]; found {
+		return pin
+	}
+	if pin, found := p.config.Pins[branchRule.Name]; found {
+		return pin
+	}
+	return branchRule.Pin
+}
+
+// resolvePin resolves the pinned revision in the source repo and checks that
+// it is on the source branch.
+func (p *PublisherMunger) resolvePin(ctx context.Context, pin, srcBranch string) (string, error) {
+	sourceDir := p.config.SourceDir(p.baseRepoPath)
+	cmd := p.command(ctx, "git", "rev-parse", "--verify", pin+"^{commit}")
+	cmd.Dir = sourceDir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve pinned revision %q: %v", pin, err)
+	}
+	sha := strings.TrimSpace(string(out))
+	if !p.isSourceAncestor(ctx, sha, srcBranch) {
+		return "", fmt.Errorf("pinned revision %q is not on source branch %s", pin, srcBranch)
+	}
+	return sha, nil
+}
+
+// isSourceAncestor returns true if commit a is an ancestor of b in the source repo.
+func (p *PublisherMunger) isSourceAncestor(ctx context.Context, a, b string) bool {
+	cmd := p.command(ctx, "git", "merge-base", "--is-ancestor", a, b)
+	cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+	return cmd.Run() == nil
+}
+
+// lastPublishedSourceCommit returns the source commit of the latest commit on the
+// given branch of origin in the destination repo in the publisher's directory, or the
+// empty string if there is none.
+func (p *PublisherMunger) lastPublishedSourceCommit(ctx context.Context, branch string) string {
+	return p.sourceCommitOf(ctx, "origin/"+branch)
+}
+
+// sourceCommitOf returns the source commit of the latest commit pointing back to
+// the source repo in the history of the given revision in the destination repo in
+// the publisher's directory, or the empty string if there is none.
+func (p *PublisherMunger) sourceCommitOf(ctx context.Context, rev string) string {
+	out, err := p.command(ctx, "git", "log", "--format=%B", rev).Output()
+	if err != nil {
+		return ""
+	}
+	return sourceCommitInMessage(string(out), config.CommitMsgTag(p.config.SourceRepo)+": ")
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// gitRepo creates a git repository in a temporary directory and changes into
+// it. It returns the directory, a git helper and a cleanup function.
+func gitRepo(t *testing.T) (string, func(args ...string) string, func()) {
+	dir, err := ioutil.TempDir("", "publishing-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) string {
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("config", "user.name", "Test")
+	git("config", "user.email", "test@example.com")
+	git("config", "commit.gpgsign", "false")
+	git("checkout", "-q", "-b", "master")
+	return dir, git, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// commitFile commits the file with the content and returns the commit.
+func commitFile(t *testing.T, git func(args ...string) string, p, content, msg string) string {
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", p)
+	git("commit", "-q", "-m", msg)
+	return git("rev-parse", "HEAD")
+}
+
+func TestPinFlag(t *testing.T) {
+	f := pinFlag{}
+	for _, s := range []string{"client-go/release-1.9=v1.9.3", "master=abc", "api/master="} {
+		if err := f.Set(s); err != nil {
+			t.Errorf("Set(%q) = %v", s, err)
+		}
+	}
+	if f["client-go/release-1.9"] != "v1.9.3" || f["master"] != "abc" {
+		t.Errorf("unexpected pins %v", f)
+	}
+	if pin, found := f["api/master"]; !found || pin != "" {
+		t.Errorf("expected the empty unpin for api/master, got %q, %v", pin, found)
+	}
+	for _, s := range []string{"master", "=abc"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestPinFor(t *testing.T) {
+	repo := config.RepositoryRule{DestinationRepository: "client-go"}
+	master := config.BranchRule{Name: "master", Pin: "rule"}
+	tests := []struct {
+		name string
+		pins map[string]string
+		want string
+	}{
+		{"rule", nil, "rule"},
+		{"branch", map[string]string{"master": "branch"}, "branch"},
+		{"destination and branch", map[string]string{"master": "branch", "client-go/master": "repo"}, "repo"},
+		{"other destination", map[string]string{"api/master": "repo"}, "rule"},
+		{"unpinned", map[string]string{"client-go/master": ""}, ""},
+	}
+	for _, tt := range tests {
+		p := &PublisherMunger{config: &config.Config{Pins: tt.pins}}
+		if got := p.pinFor(repo, master); got != tt.want {
+			t.Errorf("%s: pinFor() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolvePin(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	first := commitFile(t, git, "a", "1", "first")
+	git("tag", "v1.0.0")
+	second := commitFile(t, git, "a", "2", "second")
+	git("checkout", "-q", "-b", "feature", first)
+	feature := commitFile(t, git, "b", "1", "feature")
+	git("checkout", "-q", "master")
+
+	p := &PublisherMunger{config: &config.Config{SourcePath: dir}}
+	tests := []struct {
+		pin     string
+		want    string
+		wantErr bool
+	}{
+		{"v1.0.0", first, false},
+		{second, second, false},
+		{"master", second, false},
+		{feature, "", true},
+		{"v2.0.0", "", true},
+	}
+	for _, tt := range tests {
+		got, err := p.resolvePin(context.Background(), tt.pin, "master")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolvePin(%q) = %q, %v, want %q, wantErr %v", tt.pin, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSourceCommitOf(t *testing.T) {
+	_, git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	commitFile(t, git, "a", "1", "Add a\n\nKubernetes-commit: 1111111111111111111111111111111111111111")
+	commitFile(t, git, "b", "1", "Update dependencies")
+
+	p := &PublisherMunger{config: &config.Config{SourceRepo: "kubernetes"}}
+	if got, want := p.sourceCommitOf(context.Background(), "master"), "1111111111111111111111111111111111111111"; got != want {
+		t.Errorf("sourceCommitOf(master) = %q, want %q", got, want)
+	}
+	if got := p.sourceCommitOf(context.Background(), "unknown"); got != "" {
+		t.Errorf("sourceCommitOf(unknown) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/redact"
+)
+
+const defaultBasePublishScriptPath = "./publish_scripts"
+
+// printConfig writes the resolved config as yaml, each top-level key preceded
+// by a comment with the origin of its value. Credentials, e.g. in webhook URLs,
+// are redacted.
+func printConfig(out io.Writer, tenant string, cfg config.Config) error {
+	bs, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	var items yaml.MapSlice
+	if err := yaml.Unmarshal(bs, &items); err != nil {
+		return err
+	}
+	if tenant != "" {
+		fmt.Fprintf(out, "# tenant %s\n", tenant)
+	}
+	for _, item := range items {
+		key := fmt.Sprint(item.Key)
+		bs, err := yaml.Marshal(yaml.MapSlice{item})
+		if err != nil {
+			return fmt.Errorf("failed to print %s: %v", key, err)
+		}
+		fmt.Fprintf(out, "# from %s\n%s", cfg.OriginOf(key), redact.String(string(bs)))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+
+	"github.com/golang/glog"
+)
+
+// startProfiling writes a CPU profile to cpuProfile if set. The returned func
+// stops it and writes a heap profile to memProfile if set.
+func startProfiling(cpuProfile, memProfile string) func() {
+	var cpu *os.File
+	if cpuProfile != "" {
+		var err error
+		if cpu, err = os.Create(cpuProfile); err != nil {
+			glog.Fatalf("Failed to create CPU profile %q: %v", cpuProfile, err)
+		}
+		if err := rpprof.StartCPUProfile(cpu); err != nil {
+			glog.Fatalf("Failed to start CPU profile: %v", err)
+		}
+	}
+	return func() {
+		if cpu != nil {
+			rpprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if memProfile == "" {
+			return
+		}
+		f, err := os.Create(memProfile)
+		if err != nil {
+			glog.Errorf("Failed to create memory profile %q: %v", memProfile, err)
+			return
+		}
+		defer f.Close()
+		runtime.GC()
+		if err := rpprof.WriteHeapProfile(f); err != nil {
+			glog.Errorf("Failed to write memory profile: %v", err)
+		}
+	}
+}
+
+// registerPprof serves the runtime profiles at /debug/pprof/.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
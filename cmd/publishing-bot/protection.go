@@ -0,0 +1,186 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// the preview media type of the required signatures API
+const mediaTypeSignaturePreview = "application/vnd.github.zzzax-preview+json"
+
+// EnsureBranchProtection reconciles the protection of the given branches of the
+// repository. It returns the branches whose protection was changed.
+func EnsureBranchProtection(ctx context.Context, token, org, repo string, branches []string, bp config.BranchProtection) ([]string, error) {
+	client := githubClient(ctx, token)
+
+	var bot string
+	if bp.RestrictPushes {
+		u, _, err := client.Users.Get(ctx, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the authenticated user: %v", err)
+		}
+		bot = u.GetLogin()
+	}
+	want := protectionRequest(bp, bot)
+
+	var changed []string
+	for _, branch := range branches {
+		current, resp, err := client.Repositories.GetBranchProtection(ctx, org, repo, branch)
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			return changed, fmt.Errorf("failed to get protection of %s/%s branch %s: %v", org, repo, branch, err)
+		}
+		updated := false
+		if err != nil || !protectionUpToDate(current, want) {
+			if _, _, err := client.Repositories.UpdateBranchProtection(ctx, org, repo, branch, want); err != nil {
+				return changed, fmt.Errorf("failed to update protection of %s/%s branch %s: %v", org, repo, branch, err)
+			}
+			updated = true
+		}
+
+		signed, err := requiredSignatures(ctx, client, org, repo, branch)
+		if err != nil {
+			return changed, err
+		}
+		if signed != bp.RequireSignedCommits {
+			if err := setRequiredSignatures(ctx, client, org, repo, branch, bp.RequireSignedCommits); err != nil {
+				return changed, err
+			}
+			updated = true
+		}
+		if updated {
+			changed = append(changed, branch)
+		}
+	}
+	return changed, nil
+}
+
+// protectionRequest returns the protection to apply. The bot is allowed to push
+// if pushes are restricted.
+func protectionRequest(bp config.BranchProtection, bot string) *github.ProtectionRequest {
+	req := &github.ProtectionRequest{EnforceAdmins: bp.EnforceAdmins}
+	if bp.RestrictPushes {
+		req.Restrictions = &github.BranchRestrictionsRequest{
+			Users: append([]string{bot}, bp.PushUsers...),
+			Teams: append([]string{}, bp.PushTeams...),
+		}
+	}
+	return req
+}
+
+// protectionUpToDate returns true if the current protection of a branch equals
+// the requested one.
+func protectionUpToDate(current *github.Protection, want *github.ProtectionRequest) bool {
+	if current.RequiredStatusChecks != nil || current.RequiredPullRequestReviews != nil {
+		return false
+	}
+	if (current.EnforceAdmins != nil && current.EnforceAdmins.Enabled) != want.EnforceAdmins {
+		return false
+	}
+	if (current.Restrictions != nil) != (want.Restrictions != nil) {
+		return false
+	}
+	if want.Restrictions == nil {
+		return true
+	}
+	var users, teams []string
+	for _, u := range current.Restrictions.Users {
+		users = append(users, strings.ToLower(u.GetLogin()))
+	}
+	for _, t := range current.Restrictions.Teams {
+		teams = append(teams, t.GetSlug())
+	}
+	var wantUsers []string
+	for _, u := range want.Restrictions.Users {
+		wantUsers = append(wantUsers, strings.ToLower(u))
+	}
+	return sameStrings(users, wantUsers) && sameStrings(teams, want.Restrictions.Teams)
+}
+
+type requiredSignaturesResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// requiredSignatures returns whether signed commits are required on the
+// protected branch.
+func requiredSignatures(ctx context.Context, client *github.Client, org, repo, branch string) (bool, error) {
+	req, err := client.NewRequest("GET", signaturesURL(org, repo, branch), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", mediaTypeSignaturePreview)
+	var s requiredSignaturesResponse
+	resp, err := client.Do(ctx, req, &s)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get required signatures of %s/%s branch %s: %v", org, repo, branch, err)
+	}
+	return s.Enabled, nil
+}
+
+// setRequiredSignatures requires or stops requiring signed commits on the
+// protected branch.
+func setRequiredSignatures(ctx context.Context, client *github.Client, org, repo, branch string, enabled bool) error {
+	method := "DELETE"
+	if enabled {
+		method = "POST"
+	}
+	req, err := client.NewRequest(method, signaturesURL(org, repo, branch), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", mediaTypeSignaturePreview)
+	if _, err := client.Do(ctx, req, nil); err != nil {
+		return fmt.Errorf("failed to set required signatures of %s/%s branch %s: %v", org, repo, branch, err)
+	}
+	return nil
+}
+
+func signaturesURL(org, repo, branch string) string {
+	return fmt.Sprintf("repos/%v/%v/branches/%v/protection/required_signatures", org, repo, branch)
+}
+
+// ensureBranchProtection reconciles the branch protection of the destination
+// repo, if managed.
+func (p *PublisherMunger) ensureBranchProtection(ctx context.Context, repoRule config.RepositoryRule) error {
+	branches := repoRule.ProtectedBranches()
+	if len(branches) == 0 {
+		return nil
+	}
+	tokenRef, err := p.config.PushTokenRef(repoRule.DestinationRepository)
+	if err != nil {
+		return err
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	changed, err := EnsureBranchProtection(ctx, token, p.config.TargetOrg, repoRule.DestinationRepository, branches, repoRule.BranchProtection)
+	if len(changed) > 0 {
+		p.plog.Infof("Updated the protection of branches %s of %s/%s", strings.Join(changed, ", "), p.config.TargetOrg, repoRule.DestinationRepository)
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestProtectionUpToDate(t *testing.T) {
+	restricted := protectionRequest(config.BranchProtection{RestrictPushes: true, PushUsers: []string{"Alice"}, PushTeams: []string{"release"}}, "k8s-publishing-bot")
+	tests := []struct {
+		name     string
+		current  github.Protection
+		want     *github.ProtectionRequest
+		upToDate bool
+	}{
+		{"unrestricted", github.Protection{}, protectionRequest(config.BranchProtection{}, ""), true},
+		{"admins not enforced", github.Protection{}, protectionRequest(config.BranchProtection{EnforceAdmins: true}, ""), false},
+		{"admins enforced", github.Protection{EnforceAdmins: &github.AdminEnforcement{Enabled: true}}, protectionRequest(config.BranchProtection{EnforceAdmins: true}, ""), true},
+		{"status checks replaced", github.Protection{RequiredStatusChecks: &github.RequiredStatusChecks{}}, protectionRequest(config.BranchProtection{}, ""), false},
+		{"not restricted", github.Protection{}, restricted, false},
+		{"restricted", github.Protection{Restrictions: &github.BranchRestrictions{
+			Users: []*github.User{{Login: github.String("k8s-publishing-bot")}, {Login: github.String("alice")}},
+			Teams: []*github.Team{{Slug: github.String("release")}},
+		}}, restricted, true},
+		{"other team", github.Protection{Restrictions: &github.BranchRestrictions{
+			Users: []*github.User{{Login: github.String("k8s-publishing-bot")}, {Login: github.String("alice")}},
+			Teams: []*github.Team{{Slug: github.String("admins")}},
+		}}, restricted, false},
+	}
+	for _, tt := range tests {
+		if got := protectionUpToDate(&tt.current, tt.want); got != tt.upToDate {
+			t.Errorf("%s: expected up to date %v, got %v", tt.name, tt.upToDate, got)
+		}
+	}
+}
@@ -18,16 +18,25 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 
 	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/redact"
+	"k8s.io/publishing-bot/pkg/state"
+	"k8s.io/publishing-bot/pkg/toolchain"
 )
 
 // PublisherMunger publishes content from one repository to another one.
@@ -38,6 +47,71 @@ type PublisherMunger struct {
 	plog *plog
 	// absolute path to the repos.
 	baseRepoPath string
+	// state persists the checkpoint, the batch state and the pushed heads,
+	// by default in baseRepoPath.
+	state state.Store
+	// checkpoint tracks the progress of the current run
+	checkpoint Checkpoint
+	// timer measures the time spent per destination repo in the current run
+	timer repoTimer
+	// skippedDstBranches are <destination>/<branch> keys which are not
+	// constructed nor pushed in the current run, with the reason.
+	skippedDstBranches map[string]string
+	// goVersions are the Go versions the <destination>/<branch> keys are
+	// constructed with in the current run.
+	goVersions map[string]string
+	// rebuilt are the backup branches of the <destination>/<branch> keys
+	// constructed from scratch because their source branch was force-pushed
+	rebuilt map[string]string
+	// remainingCommits are the new source commits of the <destination>/<branch>
+	// keys left for the next runs because of batching max-commits.
+	remainingCommits map[string]int
+	// lastPush is when new commits were pushed last in the current run, to pace
+	// pushes with push-interval.
+	lastPush time.Time
+	// result summarizes the current run
+	result RunResult
+	// events sends the events of the current run to the sinks in the
+	// background, nil until the first event is emitted.
+	events *eventSender
+	// batches records when destination repos were published last
+	batches *BatchState
+	// unfinished are the destination repos not constructed in the current run
+	// because of the cycle deadline, with the time.
+	unfinished map[string]time.Time
+	// tagsSynced records when the tags of destination repos were synchronized
+	// in the current run, moved into batches once the repo is published.
+	tagsSynced map[string]time.Time
+	// paused are the destination repos and branches paused via the pause
+	// command, the control API or chatops
+	paused *PauseState
+	// heads are the destination branch heads last pushed by the bot
+	heads *HeadState
+	// archived are the destination repos archived on GitHub, but not
+	// configured to be, which are unarchived for the push, with whether they
+	// were unarchived already in the current run.
+	archived map[string]bool
+	// drifted are the <destination>/<branch> keys changed outside of the bot,
+	// which are not published in the current run.
+	drifted []string
+	// readEnv is the environment of git commands fetching with the read token,
+	// nil to inherit the environment of the publisher.
+	readEnv []string
+	// dir is the repository the commands of the publisher run in, empty for
+	// the working directory of this process. Tenants run concurrently, so the
+	// publisher never changes the working directory nor the environment of
+	// the process.
+	dir string
+	// gitEnv are the variables added to the environment of the commands,
+	// pointing git and gpg to the configured keys.
+	gitEnv []string
+	// resetCredentialHelpers drops the credential helpers configured outside
+	// of the bot. The empty helper resetting the list needs git 2.9.
+	resetCredentialHelpers bool
+	// verify are the <destination>/<branch> keys whose published history is
+	// constructed again by verify-rewrite. If not nil, no other branch is
+	// constructed.
+	verify map[string]bool
 }
 
 // New will create a new munger.
@@ -46,33 +120,58 @@ func New(cfg *config.Config, baseRepoPath string) *PublisherMunger {
 	return &PublisherMunger{
 		baseRepoPath: baseRepoPath,
 		config:       cfg,
+		state:        state.Dir(baseRepoPath),
 	}
 }
 
+// Result returns the summary of the last run.
+func (p *PublisherMunger) Result() RunResult {
+	return p.result
+}
+
 // update the local checkout of the source repository
-func (p *PublisherMunger) updateSourceRepo() (string, error) {
-	repoDir := filepath.Join(p.baseRepoPath, p.config.SourceRepo)
+func (p *PublisherMunger) updateSourceRepo(ctx context.Context) (string, error) {
+	repoDir := p.config.SourceDir(p.baseRepoPath)
+	p.checkpoint.Phase = "fetch"
+	p.plog.ResetFailedOutput()
 
-	cmd := exec.Command("git", "fetch", "origin")
-	cmd.Dir = repoDir
-	if err := p.plog.Run(cmd); err != nil {
+	rules, err := config.LoadRules(p.config.RulesFile, p.config.Network.TLS)
+	if err != nil {
+		return "", err
+	}
+	if err := rules.ExpandSourceDirs(p.config.BasePackage); err != nil {
+		return "", err
+	}
+	findings := config.Lint(p.config, rules)
+	for _, f := range findings {
+		p.plog.Warningf("Lint: %v", f)
+	}
+	if errs := config.LintErrors(findings); len(errs) > 0 {
+		return "", fmt.Errorf("%d lint errors in the config and rules, first: %v", len(errs), errs[0])
+	}
+	p.reposRules = *rules
+	glog.Infof("Loaded %d repository rules from %s", len(p.reposRules.Rules), p.config.RulesFile)
+
+	if p.config.SourcePath != "" {
+		if err := checkSourcePath(ctx, p.config); err != nil {
+			return "", err
+		}
+		p.plog.Infof("Publishing from the source checkout in %s without fetching it", repoDir)
+	} else if err := p.configureProtocol(ctx, repoDir); err != nil {
+		return "", err
+	} else if p.config.Skip.Fetch {
+		p.plog.Infof("Skipping the fetch of %s", repoDir)
+	} else if err := p.fetchSource(ctx, repoDir); err != nil {
 		return "", err
 	}
 
-	cmd = exec.Command("git", "rev-parse", "HEAD")
+	cmd := p.command(ctx, "git", "rev-parse", "HEAD")
 	cmd.Dir = repoDir
 	hash, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("failed running %v on %q repo: %v", strings.Join(cmd.Args, " "), p.config.SourceRepo, err)
 	}
 
-	rules, err := config.LoadRules(p.config.RulesFile)
-	if err != nil {
-		return "", err
-	}
-	p.reposRules = *rules
-	glog.Infof("Loaded %d repository rules from %s", len(p.reposRules.Rules), p.config.RulesFile)
-
 	// update source repo branches that are needed by other repos.
 	for _, repoRule := range p.reposRules.Rules {
 		for _, branchRule := range repoRule.Branches {
@@ -81,15 +180,22 @@ func (p *PublisherMunger) updateSourceRepo() (string, error) {
 			}
 
 			src := branchRule.Source
+			if p.config.SourcePath != "" {
+				// the checkout is not ours to reset
+				if err := p.ensureSourceBranch(ctx, repoDir, src.Branch); err != nil {
+					return "", err
+				}
+				continue
+			}
 			// we assume src.repo is always kubernetes
-			cmd := exec.Command("git", "branch", "-f", src.Branch, fmt.Sprintf("origin/%s", src.Branch))
+			cmd := p.command(ctx, "git", "branch", "-f", src.Branch, fmt.Sprintf("origin/%s", src.Branch))
 			cmd.Dir = repoDir
 			if err := p.plog.Run(cmd); err == nil {
 				continue
 			}
 			// probably the error is because we cannot do `git branch -f` while
 			// current branch is src.branch, so try `git reset --hard` instead.
-			cmd = exec.Command("git", "reset", "--hard", fmt.Sprintf("origin/%s", src.Branch))
+			cmd = p.command(ctx, "git", "reset", "--hard", fmt.Sprintf("origin/%s", src.Branch))
 			cmd.Dir = repoDir
 			if err := p.plog.Run(cmd); err != nil {
 				return "", err
@@ -99,98 +205,357 @@ func (p *PublisherMunger) updateSourceRepo() (string, error) {
 	return strings.Trim(string(hash), " \t\n"), nil
 }
 
-func (p *PublisherMunger) skippedBranch(b string) bool {
-	for _, skipped := range p.reposRules.SkippedSourceBranches {
-		if b == skipped {
-			return true
+// skipDstBranch excludes the destination branch from the rest of the current run.
+func (p *PublisherMunger) skipDstBranch(repo, branch, reason string) {
+	p.plog.Infof("Skipping branch %s of %s: %s", branch, repo, reason)
+	p.skippedDstBranches[repo+"/"+branch] = reason
+}
+
+// dstBranchSkipped returns true if skipDstBranch was called for the branch in the current run.
+func (p *PublisherMunger) dstBranchSkipped(repo, branch string) bool {
+	_, found := p.skippedDstBranches[repo+"/"+branch]
+	return found
+}
+
+// dstRepoSkipped returns true if all branches of the destination repo were
+// skipped in the current run.
+func (p *PublisherMunger) dstRepoSkipped(repoRule config.RepositoryRule) bool {
+	for _, branchRule := range repoRule.Branches {
+		if !p.skippedBranch(branchRule.Source.Branch) && !p.dstBranchSkipped(repoRule.DestinationRepository, branchRule.Name) {
+			return false
 		}
 	}
-	return false
+	return true
+}
+
+func (p *PublisherMunger) skippedBranch(b string) bool {
+	return p.reposRules.SkipsSourceBranch(b)
+}
+
+// dstDir returns the checkout of the destination repo, below its base package
+// in GOPATH or in the work dir.
+func (p *PublisherMunger) dstDir(repoRule config.RepositoryRule) string {
+	bp := config.CleanBasePackage(repoRule.BasePackage)
+	if bp == "" || bp == config.CleanBasePackage(p.config.BasePackage) || p.config.WorkDir != "" {
+		return filepath.Join(p.baseRepoPath, repoRule.DestinationRepository)
+	}
+	return filepath.Join(p.config.BaseRepoPath(bp), repoRule.DestinationRepository)
 }
 
 // git clone dstURL to dst if dst doesn't exist yet.
-func (p *PublisherMunger) ensureCloned(dst string, dstURL string) error {
+func (p *PublisherMunger) ensureCloned(ctx context.Context, dst string, dstURL string) error {
 	if _, err := os.Stat(dst); err == nil {
 		return nil
 	}
 
-	cmd := exec.Command("mkdir", "-p", dst)
-	if err := p.plog.Run(cmd); err != nil {
+	if err := os.MkdirAll(dst, 0755); err != nil {
 		return err
 	}
-	cmd = exec.Command("git", "clone", dstURL, dst)
-	if err := p.plog.Run(cmd); err != nil {
+	err := p.runWithTimeout(ctx, "clone", func() *exec.Cmd {
+		cmd := exec.Command("git", "clone", dstURL, dst)
+		cmd.Env = p.readEnv
+		return cmd
+	})
+	if err != nil {
 		return err
 	}
-	cmd = exec.Command("/bin/bash", "-c", "git tag -l | xargs git tag -d")
-	cmd.Dir = dst
-	return p.plog.Run(cmd)
+	return deleteTags(ctx, dst)
+}
+
+// deleteTags deletes all local tags of the repository in dir.
+func deleteTags(ctx context.Context, dir string) error {
+	cmd := exec.CommandContext(ctx, "git", "tag", "-l")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to list tags in %s: %v", dir, err)
+	}
+	tags := strings.Fields(string(out))
+	// in batches to stay below the argument length limit
+	for len(tags) > 0 {
+		n := len(tags)
+		if n > 1000 {
+			n = 1000
+		}
+		cmd := exec.CommandContext(ctx, "git", append([]string{"tag", "-d"}, tags[:n]...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to delete tags in %s: %v: %s", dir, err, out)
+		}
+		tags = tags[n:]
+	}
+	return nil
 }
 
 // constructs all the repos, but does not push the changes to remotes.
-func (p *PublisherMunger) construct() error {
+func (p *PublisherMunger) construct(ctx context.Context) error {
 	sourceRemote := filepath.Join(p.baseRepoPath, p.config.SourceRepo, ".git")
-	for _, repoRule := range p.reposRules.Rules {
-		if repoRule.Skip {
+	if p.config.SourcePath != "" {
+		sourceRemote = p.config.SourcePath
+	}
+	p.checkpoint.Phase = "construct"
+	p.tagsSynced = map[string]time.Time{}
+	p.unfinished = map[string]time.Time{}
+	budget := cycleBudget{}
+	var lastUnfinished map[string]time.Time
+	if p.config.CycleTimeout > 0 && p.verify == nil {
+		budget.deadline = time.Now().Add(p.config.CycleTimeout)
+		for _, repoRule := range p.reposRules.Rules {
+			if !repoRule.Skip {
+				budget.repos++
+			}
+		}
+		lastUnfinished = p.batches.Unfinished
+		budget.unfinished = lastUnfinished
+	}
+	order := constructionOrder(p.reposRules.Rules, lastUnfinished)
+	pending := map[string]*RepoRetry{}
+	for _, repoRule := range order {
+		if repoRule.Skip || (p.verify != nil && !p.verifiesRepo(repoRule.DestinationRepository)) {
+			continue
+		}
+		if dep := pendingDependency(repoRule, pending); dep != "" {
+			p.plog.Infof("Constructing %s after its dependency %s is retried", repoRule.DestinationRepository, dep)
+			pending[repoRule.DestinationRepository] = &RepoRetry{Repository: repoRule.DestinationRepository, Class: RetryDependency, Error: fmt.Sprintf("dependency %s failed", dep)}
 			continue
 		}
+		p.plog.ResetFailedOutput()
+		if err := p.constructRepo(ctx, repoRule, &budget, sourceRemote); err != nil {
+			class := transientErrorClass(err.Error() + "\n" + p.plog.FailedOutput(errorClassLogLines))
+			if class == "" || p.config.RepoRetries == 0 {
+				return err
+			}
+			p.plog.Errorf("Constructing %s failed with a transient %s error, retrying it after the other repos: %v", repoRule.DestinationRepository, class, err)
+			pending[repoRule.DestinationRepository] = &RepoRetry{Repository: repoRule.DestinationRepository, Class: class, Error: err.Error()}
+		}
+	}
+	err := p.retryRepos(ctx, order, pending, func(repoRule config.RepositoryRule) error {
+		return p.constructRepo(ctx, repoRule, &budget, sourceRemote)
+	})
+	if err != nil {
+		return err
+	}
 
-		// clone the destination repo
-		dstDir := filepath.Join(p.baseRepoPath, repoRule.DestinationRepository, "")
-		dstURL := fmt.Sprintf("https://%s/%s/%s.git", p.config.GithubHost, p.config.TargetOrg, repoRule.DestinationRepository)
-		if err := p.ensureCloned(dstDir, dstURL); err != nil {
-			p.plog.Errorf("%v", err)
-			return err
+	if p.verify == nil {
+		p.batches.Unfinished = p.unfinished
+		if err := p.batches.Save(p.state); err != nil {
+			p.plog.Errorf("Failed to save batch state: %v", err)
 		}
-		p.plog.Infof("Successfully ensured %s exists", dstDir)
-		if err := os.Chdir(dstDir); err != nil {
+	}
+	return nil
+}
+
+// constructRepo constructs the branches of the destination repo within its
+// share of the cycle budget.
+func (p *PublisherMunger) constructRepo(ctx context.Context, repoRule config.RepositoryRule, budget *cycleBudget, sourceRemote string) error {
+	repoStart := time.Now()
+	repoDeadline, inTime := budget.next(repoRule.DestinationRepository, repoStart)
+	if !inTime {
+		p.skipUnfinished(repoRule, repoRule.Branches, "no time left")
+		return nil
+	}
+	if dep := p.unfinishedDependency(repoRule); dep != "" {
+		p.skipUnfinished(repoRule, repoRule.Branches, fmt.Sprintf("dependency %s is not finished", dep))
+		return nil
+	}
+	p.checkpoint.Repository, p.checkpoint.Branch = repoRule.DestinationRepository, ""
+	p.timer.begin(repoRule.DestinationRepository, repoStart)
+
+	// clone the destination repo
+	dstDir := p.dstDir(repoRule)
+	dstURL := fmt.Sprintf("https://%s/%s/%s.git", p.config.GithubHost, p.config.TargetOrg, repoRule.DestinationRepository)
+	if repoRule.Bootstrap != "" {
+		if skip, err := p.ensureDestinationRepo(ctx, repoRule, dstDir); err != nil {
 			return err
+		} else if skip {
+			return nil
 		}
+	}
+	if err := p.ensureCloned(ctx, dstDir, dstURL); err != nil {
+		p.plog.Errorf("%v", err)
+		return err
+	}
+	p.plog.Infof("Successfully ensured %s exists", dstDir)
+	p.dir = dstDir
+	if err := p.setGitIdentity(ctx, repoRule); err != nil {
+		return err
+	}
+	if err := p.configureProtocol(ctx, dstDir); err != nil {
+		return err
+	}
+	if paused, found := p.paused.Paused(repoRule.DestinationRepository); found {
+		for _, branchRule := range repoRule.Branches {
+			p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, fmt.Sprintf("paused since %s: %s", paused.Since.Format(time.RFC3339), paused.Reason))
+		}
+		return nil
+	}
+	if p.skipArchived(ctx, repoRule) {
+		return nil
+	}
+	if reason := p.batchingSkipReason(ctx, repoRule, time.Now()); reason != "" && p.verify == nil {
+		// tags created after their commits were published are only
+		// synchronized by constructing the repo again
+		if tags := p.lateTags(ctx, repoRule); len(tags) > 0 {
+			p.plog.Infof("Not batching %s because of source tags created since its tags were synchronized: %s", repoRule.DestinationRepository, strings.Join(tags, ", "))
+		} else {
+			for _, branchRule := range repoRule.Branches {
+				p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, reason)
+			}
+			return nil
+		}
+	}
+	if !p.reposRules.SkipTags {
+		p.tagsSynced[repoRule.DestinationRepository] = time.Now()
+	}
+
+	// delete tags
+	if err := deleteTags(ctx, dstDir); err != nil {
+		return err
+	}
 
-		// delete tags
-		cmd := exec.Command("/bin/bash", "-c", "git tag | xargs git tag -d >/dev/null")
-		if err := p.plog.Run(cmd); err != nil {
+	formatDeps := func(deps []config.Dependency) string {
+		var depStrings []string
+		for _, dep := range deps {
+			// <repo>:<branch> or <repo>::<tag or commit>
+			s := fmt.Sprintf("%s:%s", dep.Repository, dep.Branch)
+			if pin := dep.Pin(); pin != "" {
+				s += ":" + pin
+			}
+			depStrings = append(depStrings, s)
+		}
+		return strings.Join(depStrings, ",")
+	}
+
+	// a new repo is squashed after all branches are constructed because
+	// new branches are forked from the full history of the mainline.
+	squash := false
+	if repoRule.Bootstrap == config.BootstrapSquash {
+		hasBranches, err := p.hasRemoteBranches(ctx)
+		if err != nil {
 			return err
 		}
+		squash = !hasBranches
+	}
 
-		formatDeps := func(deps []config.Dependency) string {
-			var depStrings []string
-			for _, dep := range deps {
-				depStrings = append(depStrings, fmt.Sprintf("%s:%s", dep.Repository, dep.Branch))
-			}
-			return strings.Join(depStrings, ",")
+	// construct branches
+	for i, branchRule := range repoRule.Branches {
+		if p.skippedBranch(branchRule.Source.Branch) {
+			continue
+		}
+		if p.verify != nil && !p.verify[repoRule.DestinationRepository+"/"+branchRule.Name] {
+			continue
+		}
+		p.checkpoint.Branch = branchRule.Name
+		p.plog.ResetFailedOutput()
+		if len(branchRule.Source.Dir) == 0 {
+			branchRule.Source.Dir = "."
+			p.plog.Infof("%v: 'dir' cannot be empty, defaulting to '.'", branchRule)
 		}
 
-		// construct branches
-		for _, branchRule := range repoRule.Branches {
-			if p.skippedBranch(branchRule.Source.Branch) {
+		if repoRule.Metadata.Archived {
+			p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, "the repository is archived")
+			continue
+		}
+		if paused, found := p.paused.Paused(repoRule.DestinationRepository + "/" + branchRule.Name); found {
+			p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, fmt.Sprintf("paused since %s: %s", paused.Since.Format(time.RFC3339), paused.Reason))
+			continue
+		}
+		if stopped, err := p.checkSunset(ctx, repoRule, branchRule); err != nil {
+			return err
+		} else if stopped {
+			continue
+		}
+		if drifted, err := p.checkDrift(ctx, repoRule, branchRule); err != nil {
+			return err
+		} else if drifted {
+			continue
+		}
+
+		var pinnedSourceCommit string
+		if p.verify != nil {
+			// construct the published history again from scratch
+			if pinnedSourceCommit = p.lastPublishedSourceCommit(ctx, branchRule.Name); pinnedSourceCommit == "" {
+				return fmt.Errorf("no published source commit found on %s of %s", branchRule.Name, repoRule.DestinationRepository)
+			}
+			p.plog.Infof("Verifying the rewrite of branch %s up to published source commit %s", branchRule.Name, pinnedSourceCommit)
+		} else if pin := p.pinFor(repoRule, branchRule); pin != "" {
+			var err error
+			if pinnedSourceCommit, err = p.resolvePin(ctx, pin, branchRule.Source.Branch); err != nil {
+				return err
+			}
+			if published := p.lastPublishedSourceCommit(ctx, branchRule.Name); published != "" && !p.isSourceAncestor(ctx, published, pinnedSourceCommit) {
+				p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, fmt.Sprintf("already published beyond the pinned source revision %s", pin))
 				continue
 			}
-			if len(branchRule.Source.Dir) == 0 {
-				branchRule.Source.Dir = "."
-				p.plog.Infof("%v: 'dir' cannot be empty, defaulting to '.'", branchRule)
+			p.plog.Infof("Publishing branch %s only up to pinned source revision %s (%s)", branchRule.Name, pin, pinnedSourceCommit)
+		}
+		// pins are checked against the published history above
+		backup := ""
+		if pinnedSourceCommit == "" {
+			var err error
+			if backup, err = p.checkSourceForcePush(ctx, repoRule, branchRule); err != nil {
+				return err
+			}
+			if backup != "" {
+				p.rebuilt[repoRule.DestinationRepository+"/"+branchRule.Name] = backup
 			}
+		}
+		if pinnedSourceCommit == "" && backup == "" {
+			pin, remaining, err := p.maxCommitsPin(ctx, repoRule, branchRule)
+			if err != nil {
+				return err
+			}
+			if pin != "" {
+				pinnedSourceCommit = pin
+				p.remainingCommits[repoRule.DestinationRepository+"/"+branchRule.Name] = remaining
+				p.plog.Infof("Publishing branch %s only up to source commit %s, %d new source commits remain for the next runs", branchRule.Name, pin, remaining)
+			}
+		}
 
-			// get old HEAD. Ignore errors as the branch might be non-existent
-			oldHead, _ := exec.Command("git", "rev-parse", fmt.Sprintf("origin/%s", branchRule.Name)).Output()
+		// get old HEAD. Ignore errors as the branch might be non-existent
+		oldHead, _ := p.command(ctx, "git", "rev-parse", fmt.Sprintf("origin/%s", branchRule.Name)).Output()
 
-			goPath := os.Getenv("GOPATH")
-			branchEnv := append([]string(nil), os.Environ()...) // make mutable
-			if branchRule.GoVersion != "" {
-				goRoot := filepath.Join(goPath, "go-"+branchRule.GoVersion)
-				branchEnv = append(branchEnv, "GOROOT="+goRoot)
-				goBin := filepath.Join(goRoot, "bin")
-				branchEnv = updateEnv(branchEnv, "PATH", prependPath(goBin), goBin)
+		goPath := p.config.GoPath()
+		branchEnv := p.environ()
+		if p.config.WorkDir != "" {
+			branchEnv = updateEnv(branchEnv, "GOPATH", func(string) string { return goPath }, goPath)
+			branchEnv = updateEnv(branchEnv, "GO111MODULE", func(string) string { return "on" }, "on")
+		}
+		sourceRev := branchRule.Source.Branch
+		if pinnedSourceCommit != "" {
+			sourceRev = pinnedSourceCommit
+		}
+		goVersion, err := p.resolveGoVersion(ctx, branchRule, sourceRev)
+		if err != nil {
+			return err
+		}
+		p.goVersions[repoRule.DestinationRepository+"/"+branchRule.Name] = goVersion
+		if goVersion != "" {
+			goRoot, err := p.ensureGoVersion(ctx, goPath, goVersion)
+			if err != nil {
+				return fmt.Errorf("failed to install go %s for %s: %v", goVersion, branchRule.Name, err)
 			}
+			// GOROOT instead of the global go symlink, such that branches
+			// can use different versions at the same time.
+			branchEnv = updateEnv(branchEnv, "GOROOT", func(string) string { return goRoot }, goRoot)
+			goBin := filepath.Join(goRoot, "bin")
+			branchEnv = updateEnv(branchEnv, "PATH", prependPath(goBin), goBin)
+		}
 
-			skipTags := ""
-			if p.reposRules.SkipTags {
-				skipTags = "true"
-				p.plog.Infof("synchronizing tags is disabled")
-			}
+		skipTags := ""
+		if p.reposRules.SkipTags {
+			skipTags = "true"
+			p.plog.Infof("synchronizing tags is disabled")
+		} else if p.verify != nil {
+			skipTags = "true"
+		}
+
+		importRewriteFormat := p.importRewriteFormat(repoRule)
 
-			// TODO: Refactor this to use environment variables instead
-			repoPublishScriptPath := filepath.Join(p.config.BasePublishScriptPath, "construct.sh")
+		// TODO: Refactor this to use environment variables instead
+		repoPublishScriptPath := filepath.Join(p.config.BasePublishScriptPath, "construct.sh")
+		constructCtx, cancel := withDeadline(ctx, repoDeadline)
+		err = p.runWithTimeout(constructCtx, "construct", func() *exec.Cmd {
 			cmd := exec.Command(repoPublishScriptPath,
 				repoRule.DestinationRepository,
 				branchRule.Source.Branch,
@@ -201,38 +566,324 @@ func (p *PublisherMunger) construct() error {
 				branchRule.Source.Dir,
 				p.config.SourceRepo,
 				p.config.SourceRepo,
-				p.config.BasePackage,
+				p.config.BasePackageOf(repoRule),
 				fmt.Sprintf("%v", repoRule.Library),
 				strings.Join(p.reposRules.RecursiveDeletePatterns, " "),
 				skipTags,
 			)
 			cmd.Env = append([]string(nil), branchEnv...) // make mutable
-			if p.reposRules.SkipGodeps {
-				cmd.Env = append(cmd.Env, "PUBLISHER_BOT_SKIP_GODEPS=true")
+			cmd.Env = append(cmd.Env,
+				"PUBLISHER_BOT_DEPENDENCY_TOOL="+p.reposRules.DependencyTool(branchRule),
+				"PUBLISHER_BOT_SOURCE_MAINLINE_BRANCH="+p.reposRules.SourceMainline(),
+				"PUBLISHER_BOT_MAINLINE_BRANCH="+p.reposRules.Mainline(repoRule),
+				"PUBLISHER_BOT_SOURCE_PIN="+pinnedSourceCommit,
+				"PUBLISHER_BOT_SKIP_SOURCE_COMMITS="+strings.Join(p.reposRules.SkippedSourceCommits, " "),
+				"PUBLISHER_BOT_SKIP_SOURCE_COMMIT_PATTERNS="+strings.Join(p.reposRules.SkippedSourceCommitPatterns, "\n"),
+				"PUBLISHER_BOT_EMPTY_COMMITS="+repoRule.EmptyCommits,
+				"PUBLISHER_BOT_MERGE_COMMITS="+repoRule.MergeCommits,
+				"PUBLISHER_BOT_IMPORT_REWRITES="+importRewrites(repoRule.ImportRewrites),
+				"PUBLISHER_BOT_IMPORT_REWRITE_FORMAT="+importRewriteFormat,
+				"PUBLISHER_BOT_FILTERS="+filterSteps(repoRule.Filters),
+				"PUBLISHER_BOT_SUBMODULES="+repoRule.Submodules.Mode,
+				"PUBLISHER_BOT_SUBMODULE_URLS="+repoRule.Submodules.URLRewrites(),
+				"PUBLISHER_BOT_SYMLINKS="+repoRule.Symlinks,
+				"PUBLISHER_BOT_TAG_CLASSES="+strings.Join(repoRule.Tags.Classes, ","),
+				"PUBLISHER_BOT_TAG_SEMVER_MAJOR="+semverMajor(repoRule.Tags),
+				"PUBLISHER_BOT_TAG_INCLUDE="+repoRule.Tags.IncludePattern(),
+				"PUBLISHER_BOT_TAG_EXCLUDE="+repoRule.Tags.ExcludePattern(),
+				"PUBLISHER_BOT_TAG_TYPE="+repoRule.Tags.Type,
+				"PUBLISHER_BOT_TAG_MESSAGE="+repoRule.Tags.Message,
+				"PUBLISHER_BOT_TAGGER="+repoRule.Tags.Tagger,
+				"PUBLISHER_BOT_RELEASE_NOTES_URL="+p.releaseNotesURL(),
+				"PUBLISHER_BOT_FETCH_SINGLE_BRANCH="+strconv.FormatBool(p.config.Fetch.SingleBranch),
+				"PUBLISHER_BOT_REBUILD_BRANCH="+strconv.FormatBool(backup != "" || p.verify != nil),
+				"PUBLISHER_BOT_SIGNOFF="+p.signoff(repoRule),
+				"PUBLISHER_BOT_SKIP_DEP_RESTORE="+strconv.FormatBool(p.config.Skip.DepRestore),
+			)
+			return cmd
+		})
+		cutOff := ctx.Err() == nil && constructCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if cutOff {
+			p.skipUnfinished(repoRule, repoRule.Branches[i:], fmt.Sprintf("constructing branch %s took longer than the fair share of %v", branchRule.Name, repoDeadline.Sub(repoStart).Round(time.Second)))
+			break
+		}
+		if err != nil {
+			p.reportConflict(ctx, repoRule, branchRule, err)
+			return err
+		}
+
+		if err := p.syncOwners(ctx, repoRule, branchRule); err != nil {
+			return fmt.Errorf("failed to sync OWNERS of %s: %v", branchRule.Name, err)
+		}
+		if err := p.syncLicense(ctx, repoRule, branchRule); err != nil {
+			return fmt.Errorf("failed to sync license of %s: %v", branchRule.Name, err)
+		}
+		if err := p.syncSBOM(ctx, repoRule, branchRule); err != nil {
+			return fmt.Errorf("failed to sync SBOM of %s: %v", branchRule.Name, err)
+		}
+		if repoRule.Commits == config.CommitsSquash && p.verify == nil {
+			if err := p.squashSincePublished(ctx, repoRule, branchRule, strings.TrimSpace(string(oldHead))); err != nil {
+				return fmt.Errorf("failed to squash %s of %s: %v", branchRule.Name, repoRule.DestinationRepository, err)
 			}
-			if err := p.plog.Run(cmd); err != nil {
+		}
+		if err := p.runHooks(ctx, "pre-push", repoRule.Hooks.PrePush, repoRule, branchRule); err != nil {
+			return err
+		}
+		if err := p.checkBlobSizes(ctx, branchRule.Name, repoRule.MaxBlobSize); err != nil {
+			return fmt.Errorf("failed to publish %s of %s: %v", branchRule.Name, repoRule.DestinationRepository, err)
+		}
+		if err := p.checkDependencyLicenses(ctx, repoRule, branchRule); err != nil {
+			return err
+		}
+
+		newHead, _ := p.command(ctx, "git", "rev-parse", "HEAD").Output()
+		if string(oldHead) != string(newHead) {
+			if err := p.checkBuild(ctx, repoRule, branchRule, branchEnv); err != nil {
 				return err
 			}
+		}
 
-			newHead, _ := exec.Command("git", "rev-parse", fmt.Sprintf("HEAD", branchRule.Name)).Output()
-			if len(repoRule.SmokeTest) > 0 && string(oldHead) != string(newHead) {
-				p.plog.Infof("Running smoke tests for branch %s", branchRule.Name)
-				cmd := exec.Command("/bin/bash", "-xec", repoRule.SmokeTest)
-				cmd.Env = append([]string(nil), branchEnv...) // make mutable
-				if err := p.plog.Run(cmd); err != nil {
-					// do not clean up to allow debugging with kubectl-exec.
-					return err
-				}
-				exec.Command("git", "reset", "--hard").Run()
-				exec.Command("git", "clean", "-f", "-f", "-d").Run()
+		p.plog.Infof("Successfully constructed %s", branchRule.Name)
+	}
+
+	if _, found := p.unfinished[repoRule.DestinationRepository]; found && squash {
+		// all branches are squashed together
+		p.skipUnfinished(repoRule, repoRule.Branches, "bootstrapping needs all branches")
+		return nil
+	}
+	if squash {
+		for _, branchRule := range repoRule.Branches {
+			if p.skippedBranch(branchRule.Source.Branch) || p.dstBranchSkipped(repoRule.DestinationRepository, branchRule.Name) {
+				continue
+			}
+			if err := p.squashBranch(ctx, repoRule, branchRule); err != nil {
+				return fmt.Errorf("failed to bootstrap %s: %v", repoRule.DestinationRepository, err)
 			}
+		}
+	}
+	return nil
+}
+
+// semverMajor returns the semver major version of the tag policy, or the empty
+// string if no semver tags are published.
+func semverMajor(t config.TagPolicy) string {
+	if t.SemverMajor == nil {
+		return ""
+	}
+	return strconv.Itoa(*t.SemverMajor)
+}
 
-			p.plog.Infof("Successfully constructed %s", branchRule.Name)
+// releaseNotesURL returns the release notes URL of source tags without the tag
+// name, or the empty string if the source repo is not on GitHub.
+func (p *PublisherMunger) releaseNotesURL() string {
+	if p.config.SourceURL != "" {
+		return ""
+	}
+	return fmt.Sprintf("https://%s/%s/%s/releases/tag/", p.config.GithubHost, p.config.SourceOrg, p.config.SourceRepo)
+}
+
+// filterSteps returns the filters in the JSON format of filter-tree, or the
+// empty string if there are none.
+func filterSteps(filters []config.FilterStep) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	bs, err := json.Marshal(filters)
+	if err != nil {
+		return "" // cannot happen for strings
+	}
+	return string(bs)
+}
+
+// importRewrites returns the import rewrites in the format of rewrite-imports.
+func importRewrites(rewrites []config.ImportRewrite) string {
+	var ss []string
+	for _, r := range rewrites {
+		ss = append(ss, r.From+"="+r.To)
+	}
+	return strings.Join(ss, " ")
+}
+
+// importRewriteFormat returns the formatter of the rewritten files. goimports
+// does not come with Go, hence gofmt is used if it is not in the PATH.
+func (p *PublisherMunger) importRewriteFormat(repoRule config.RepositoryRule) string {
+	if repoRule.ImportRewriteFormat == "goimports" {
+		if _, err := exec.LookPath("goimports"); err != nil {
+			p.plog.Warningf("goimports not found in the PATH, normalizing the rewritten files of %s with gofmt", repoRule.DestinationRepository)
+			return "gofmt"
+		}
+	}
+	return repoRule.ImportRewriteFormat
+}
+
+// setGitIdentity writes the committer identity of the destination repo into
+// its git config in the publisher's directory.
+func (p *PublisherMunger) setGitIdentity(ctx context.Context, repoRule config.RepositoryRule) error {
+	identity := p.config.GitIdentityFor(repoRule)
+	if err := identity.Validate(); err != nil {
+		return fmt.Errorf("invalid git-identity for %s: %v", repoRule.DestinationRepository, err)
+	}
+	for k, v := range map[string]string{"user.name": identity.Name, "user.email": identity.Email} {
+		if err := p.command(ctx, "git", "config", k, v).Run(); err != nil {
+			return fmt.Errorf("failed to set %s of %s: %v", k, repoRule.DestinationRepository, err)
 		}
 	}
 	return nil
 }
 
+// signoff returns the identity of the Signed-off-by trailer of published
+// commits of the destination repo, or the empty string.
+func (p *PublisherMunger) signoff(repoRule config.RepositoryRule) string {
+	if !p.config.Signoff {
+		return ""
+	}
+	return p.config.GitIdentityFor(repoRule).String()
+}
+
+// gitCommit returns the command committing the index with the given message
+// in the publisher's directory, signed off if configured.
+func (p *PublisherMunger) gitCommit(ctx context.Context, msg string) *exec.Cmd {
+	args := []string{"commit", "-q", "-m", msg}
+	if p.config.Signoff {
+		// the committer identity is the one of the destination repo
+		args = append(args, "--signoff")
+	}
+	return p.command(ctx, "git", args...)
+}
+
+// command returns the command running in the directory of the publisher with
+// its environment.
+func (p *PublisherMunger) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return p.prepare(exec.CommandContext(ctx, name, args...))
+}
+
+// checkBuild verifies the generated files and runs the smoke tests of the
+// changed branch checked out in the publisher's directory, unless the build
+// check is skipped.
+func (p *PublisherMunger) checkBuild(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule, env []string) error {
+	if p.config.Skip.BuildCheck {
+		p.plog.Infof("Skipping the smoke tests and the verification of generated files of branch %s", branchRule.Name)
+		return nil
+	}
+	if err := p.verifyGenerated(ctx, repoRule, branchRule, env); err != nil {
+		return err
+	}
+	if len(repoRule.SmokeTest) == 0 || p.verify != nil {
+		return nil
+	}
+	p.plog.Infof("Running smoke tests for branch %s", branchRule.Name)
+	err := p.runWithTimeout(ctx, "smoke-test", func() *exec.Cmd {
+		cmd := exec.Command("/bin/bash", "-xec", repoRule.SmokeTest)
+		cmd.Env = append([]string(nil), env...) // make mutable
+		return cmd
+	})
+	if err != nil {
+		// do not clean up to allow debugging with kubectl-exec.
+		return err
+	}
+	p.command(ctx, "git", "reset", "--hard").Run()
+	p.command(ctx, "git", "clean", "-f", "-f", "-d").Run()
+	return nil
+}
+
+// prepare runs the command in the directory of the publisher with its
+// environment unless the command has its own.
+func (p *PublisherMunger) prepare(cmd *exec.Cmd) *exec.Cmd {
+	if cmd.Dir == "" {
+		cmd.Dir = p.dir
+	}
+	if cmd.Env == nil {
+		cmd.Env = p.environ()
+	}
+	return cmd
+}
+
+// path returns the path of the file name relative to the directory of the
+// publisher.
+func (p *PublisherMunger) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// environ returns the environment of the commands of the publisher.
+func (p *PublisherMunger) environ() []string {
+	return append(os.Environ(), p.gitEnv...)
+}
+
+// runWithTimeout runs the command returned by newCmd with the timeout of the given
+// phase. A command killed by the timeout is considered hung. It is recreated and
+// retried up to CommandRetries times.
+func (p *PublisherMunger) runWithTimeout(ctx context.Context, phase string, newCmd func() *exec.Cmd) error {
+	return p.withTimeout(ctx, phase, func(ctx context.Context) error {
+		return p.plog.RunContext(ctx, p.prepare(newCmd()))
+	})
+}
+
+// outputWithTimeout runs the command returned by newCmd like runWithTimeout and
+// returns its standard output.
+func (p *PublisherMunger) outputWithTimeout(ctx context.Context, phase string, newCmd func() *exec.Cmd) ([]byte, error) {
+	var out []byte
+	err := p.withTimeout(ctx, phase, func(ctx context.Context) error {
+		var err error
+		out, err = p.plog.OutputContext(ctx, p.prepare(newCmd()))
+		return err
+	})
+	return out, err
+}
+
+func (p *PublisherMunger) withTimeout(ctx context.Context, phase string, run func(ctx context.Context) error) error {
+	timeout := p.config.Timeout(phase)
+	for attempt := 1; ; attempt++ {
+		var cmdCtx context.Context
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			cmdCtx, cancel = context.WithTimeout(ctx, timeout)
+		} else {
+			cmdCtx, cancel = context.WithCancel(ctx)
+		}
+		err := run(cmdCtx)
+		hung := ctx.Err() == nil && cmdCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if !hung {
+			return err
+		}
+		if attempt > p.config.CommandRetries {
+			return fmt.Errorf("%s command hung and was killed after %v (%d attempts)", phase, timeout, attempt)
+		}
+		p.plog.Errorf("%s command hung and was killed after %v, retrying", phase, timeout)
+	}
+}
+
+// ensureGoVersion installs the given Go version into GOPATH unless it is cached
+// there already, and returns its GOROOT.
+func (p *PublisherMunger) ensureGoVersion(ctx context.Context, goPath, version string) (string, error) {
+	if timeout := p.config.Timeout("toolchain"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return toolchain.InstallGo(ctx, httpClient(ctx), toolchainManifest(goPath), goPath, version, p.config.Network.GoToolchainURL(version))
+}
+
+var (
+	toolchainsMutex sync.Mutex
+	// toolchains are the manifests of the Go versions installed into GOPATH,
+	// loaded on first use. Tenants without work-dir share the GOPATH and
+	// hence the manifest.
+	toolchains = map[string]*toolchain.Manifest{}
+)
+
+func toolchainManifest(goPath string) *toolchain.Manifest {
+	toolchainsMutex.Lock()
+	defer toolchainsMutex.Unlock()
+	m, found := toolchains[goPath]
+	if !found {
+		m = toolchain.LoadManifest(goPath)
+		toolchains[goPath] = m
+	}
+	return m
+}
+
 func updateEnv(env []string, key string, change func(string) string, val string) []string {
 	for i := range env {
 		if strings.HasPrefix(env[i], key+"=") {
@@ -254,65 +905,456 @@ func prependPath(p string) func(string) string {
 }
 
 // publish to remotes.
-func (p *PublisherMunger) publish() error {
+func (p *PublisherMunger) publish(ctx context.Context) error {
+	p.checkpoint.Phase = "publish"
 	if p.config.DryRun {
 		p.plog.Infof("Skipping push in dry-run mode")
 		return nil
 	}
-
-	if p.config.TokenFile == "" {
-		return fmt.Errorf("token cannot be empty in non-dry-run mode")
-	}
+	defer p.rearchive(ctx)
 
 	// NOTE: because some repos depend on each other, e.g., client-go depends on
 	// apimachinery, they should be published atomically, but it's not supported
 	// by github.
-	for _, repoRules := range p.reposRules.Rules {
+	var targetErrs, hookErrs, metadataErrs, protectionErrs, secretErrs, sbomErrs, attestationErrs []string
+	for _, repoRules := range constructionOrder(p.reposRules.Rules, nil) {
 		if repoRules.Skip {
 			continue
 		}
+		p.checkpoint.Repository, p.checkpoint.Branch = repoRules.DestinationRepository, ""
+		p.timer.begin(repoRules.DestinationRepository, time.Now())
 
-		dstDir := filepath.Join(p.baseRepoPath, repoRules.DestinationRepository, "")
-		if err := os.Chdir(dstDir); err != nil {
+		dstDir := p.dstDir(repoRules)
+		p.dir = dstDir
+		if err := p.unarchive(ctx, repoRules); err != nil {
+			return err
+		}
+		targets := p.pushTargets(repoRules)
+		for _, target := range targets {
+			if err := p.ensureRemote(ctx, target.Name, target.URL); err != nil {
+				return err
+			}
+		}
+		if p.config.Canary.Enabled() && p.config.Canary.CreateRepos {
+			if err := p.ensureCanaryRepo(ctx, repoRules); err != nil {
+				return err
+			}
+		}
+		for _, target := range targets {
+			if target.BitbucketServer != nil && target.BitbucketServer.CreateRepos {
+				if err := p.ensureBitbucketRepo(ctx, target, repoRules.DestinationRepository); err != nil {
+					return err
+				}
+			}
+		}
+		tokenRef, err := p.config.PushTokenRef(repoRules.DestinationRepository)
+		if err != nil {
 			return err
 		}
+		if _, err := loadToken(p.config, tokenRef); err != nil {
+			return err
+		}
+		pushEnv, err := p.credentialEnv(tokenRef, "")
+		if err != nil {
+			return err
+		}
+		pushEnv = append(pushEnv, "PUSH_SKIP_TAGS="+strconv.FormatBool(p.config.Skip.TagPush))
 		for _, branchRule := range repoRules.Branches {
-			if p.skippedBranch(branchRule.Source.Branch) {
+			if p.skippedBranch(branchRule.Source.Branch) || p.dstBranchSkipped(repoRules.DestinationRepository, branchRule.Name) {
 				continue
 			}
+			p.checkpoint.Branch = branchRule.Name
+			p.plog.ResetFailedOutput()
 
-			cmd := exec.Command(p.config.BasePublishScriptPath+"/push.sh", p.config.TokenFile, branchRule.Name)
-			if err := p.plog.Run(cmd); err != nil {
+			if p.config.SecretScan.Enabled {
+				findings, err := p.scanSecrets(ctx, repoRules.DestinationRepository, branchRule.Name)
+				if err != nil {
+					findings = []string{err.Error()}
+				}
+				if len(findings) > 0 {
+					p.plog.Errorf("Not pushing branch %s of %s because of likely secrets:\n  %s", branchRule.Name, repoRules.DestinationRepository, strings.Join(findings, "\n  "))
+					p.alertSecrets(ctx, repoRules.DestinationRepository, branchRule.Name, findings)
+					p.skipDstBranch(repoRules.DestinationRepository, branchRule.Name, "likely secrets found")
+					secretErrs = append(secretErrs, fmt.Sprintf("%s/%s", repoRules.DestinationRepository, branchRule.Name))
+					continue
+				}
+			}
+
+			if !p.config.Canary.Only {
+				backup, rebuilt := p.rebuilt[repoRules.DestinationRepository+"/"+branchRule.Name]
+				if rebuilt {
+					if err := p.pushHead(ctx, repoRules.DestinationRepository, backup, "refs/remotes/origin/"+branchRule.Name, false); err != nil {
+						return fmt.Errorf("failed to back up branch %s of %s: %v", branchRule.Name, repoRules.DestinationRepository, err)
+					}
+				}
+				if err := p.addNotes(ctx, repoRules, branchRule, pushEnv); err != nil {
+					return fmt.Errorf("failed to add publishing notes to %s of %s: %v", branchRule.Name, repoRules.DestinationRepository, err)
+				}
+				if err := p.pacePush(ctx, branchRule.Name); err != nil {
+					return err
+				}
+				err := p.retryTransient(ctx, repoRules.DestinationRepository, "push of "+branchRule.Name, func() error {
+					return p.runWithTimeout(ctx, "push", func() *exec.Cmd {
+						cmd := exec.Command(p.config.BasePublishScriptPath+"/push.sh", tokenRef, branchRule.Name)
+						cmd.Env = append(append([]string(nil), pushEnv...),
+							"PUSH_BRANCH_ALIASES="+strings.Join(branchRule.Aliases, " "),
+							"PUSH_NOTES_REF="+p.notesRef(),
+						)
+						if rebuilt {
+							// the rebuilt branch replaces the backed up history,
+							// opted in via source-force-push
+							cmd.Env = append(cmd.Env, "PUSH_FORCE=true")
+						}
+						return cmd
+					})
+				})
+				if err != nil {
+					return err
+				}
+				p.markPushed(repoRules.DestinationRepository, branchRule.Name)
+				p.recordPushedHead(ctx, repoRules.DestinationRepository, branchRule.Name)
+				p.emitPublished(repoRules.DestinationRepository, branchRule.Name)
+			}
+
+			if len(repoRules.Hooks.PostPush) > 0 && !p.config.Canary.Only {
+				err := p.plog.Run(p.command(ctx, "git", "checkout", "-q", branchRule.Name))
+				if err == nil {
+					err = p.runHooks(ctx, "post-push", repoRules.Hooks.PostPush, repoRules, branchRule)
+				}
+				if err != nil {
+					p.plog.Errorf("Failed to run post-push hooks for branch %s of %s: %v", branchRule.Name, repoRules.DestinationRepository, err)
+					hookErrs = append(hookErrs, fmt.Sprintf("%s/%s", repoRules.DestinationRepository, branchRule.Name))
+				}
+			}
+
+			if !p.config.Canary.Only {
+				if err := p.attachSBOMs(ctx, repoRules, branchRule); err != nil {
+					p.plog.Errorf("Failed to attach SBOMs to the releases of branch %s of %s: %v", branchRule.Name, repoRules.DestinationRepository, err)
+					sbomErrs = append(sbomErrs, fmt.Sprintf("%s/%s", repoRules.DestinationRepository, branchRule.Name))
+				}
+				if err := p.attest(ctx, repoRules, branchRule); err != nil {
+					p.plog.Errorf("Failed to attest branch %s of %s: %v", branchRule.Name, repoRules.DestinationRepository, err)
+					attestationErrs = append(attestationErrs, fmt.Sprintf("%s/%s", repoRules.DestinationRepository, branchRule.Name))
+				}
+			}
+
+			// push targets fail independently of each other and of origin
+			for _, target := range targets {
+				if err := p.pushToTarget(ctx, target, repoRules.DestinationRepository, branchRule); err != nil {
+					p.plog.Errorf("Failed to push branch %s of %s to push target %s: %v", branchRule.Name, repoRules.DestinationRepository, target.Name, err)
+					targetErrs = append(targetErrs, fmt.Sprintf("%s/%s to %s", repoRules.DestinationRepository, branchRule.Name, target.Name))
+					continue
+				}
+				p.plog.Infof("Successfully pushed branch %s of %s to push target %s", branchRule.Name, repoRules.DestinationRepository, target.Name)
+			}
+		}
+
+		if !p.dstRepoSkipped(repoRules) {
+			p.batches.Published[repoRules.DestinationRepository] = time.Now()
+			if t, found := p.tagsSynced[repoRules.DestinationRepository]; found {
+				p.batches.TagsSynced[repoRules.DestinationRepository] = t
+			}
+			if err := p.batches.Save(p.state); err != nil {
+				p.plog.Errorf("Failed to save batch state: %v", err)
+			}
+		}
+
+		if p.config.Canary.Only {
+			continue
+		}
+		if !repoRules.Metadata.Archived {
+			if err := p.ensureDefaultBranch(ctx, repoRules); err != nil {
 				return err
 			}
 		}
+		if err := p.ensureMetadata(ctx, repoRules); err != nil {
+			p.plog.Errorf("Failed to update metadata of %s: %v", repoRules.DestinationRepository, err)
+			metadataErrs = append(metadataErrs, repoRules.DestinationRepository)
+		}
+		if !repoRules.Metadata.Archived {
+			if err := p.ensureBranchProtection(ctx, repoRules); err != nil {
+				p.plog.Errorf("Failed to update branch protection of %s: %v", repoRules.DestinationRepository, err)
+				protectionErrs = append(protectionErrs, repoRules.DestinationRepository)
+			}
+		}
+	}
+	if len(secretErrs) > 0 {
+		return fmt.Errorf("secret scan blocked pushing %s", strings.Join(secretErrs, ", "))
+	}
+	if len(targetErrs) > 0 {
+		return fmt.Errorf("failed to push %s", strings.Join(targetErrs, ", "))
+	}
+	if len(hookErrs) > 0 {
+		return fmt.Errorf("post-push hooks failed for %s", strings.Join(hookErrs, ", "))
+	}
+	if len(sbomErrs) > 0 {
+		return fmt.Errorf("failed to attach SBOMs to the releases of %s", strings.Join(sbomErrs, ", "))
+	}
+	if len(attestationErrs) > 0 {
+		return fmt.Errorf("failed to attest %s", strings.Join(attestationErrs, ", "))
+	}
+	if len(metadataErrs) > 0 {
+		return fmt.Errorf("failed to update metadata of %s", strings.Join(metadataErrs, ", "))
+	}
+	if len(protectionErrs) > 0 {
+		return fmt.Errorf("failed to update branch protection of %s", strings.Join(protectionErrs, ", "))
+	}
+	return nil
+}
+
+// ensureRemote adds the git remote to the repository in the publisher's directory or
+// updates its URL.
+func (p *PublisherMunger) ensureRemote(ctx context.Context, name, url string) error {
+	cmd := p.command(ctx, "git", "remote", "set-url", name, url)
+	if err := cmd.Run(); err == nil {
+		return nil
+	}
+	return p.plog.Run(p.command(ctx, "git", "remote", "add", name, url))
+}
+
+// pushTargets returns the push targets of the destination repo, including the
+// canary repo.
+func (p *PublisherMunger) pushTargets(repoRule config.RepositoryRule) []config.PushTarget {
+	var targets []config.PushTarget
+	for _, t := range repoRule.PushTargets {
+		if t.BitbucketServer != nil && t.URL == "" {
+			t.URL = t.BitbucketServer.CloneURL(repoRule.DestinationRepository)
+		}
+		targets = append(targets, t)
+	}
+	if p.config.Canary.Enabled() {
+		t := p.config.Canary.PushTarget(p.config.GithubHost, repoRule.DestinationRepository)
+		if t.TokenFile == "" {
+			t.TokenFile = p.config.TokenRef()
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// ensureCanaryRepo creates the canary repo of the destination repo if it does
+// not exist.
+func (p *PublisherMunger) ensureCanaryRepo(ctx context.Context, repoRule config.RepositoryRule) error {
+	tokenRef := p.config.Canary.Token
+	if tokenRef == "" {
+		tokenRef = p.config.TokenRef()
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	org, repo := p.config.Canary.Org, repoRule.DestinationRepository
+	exists, err := RepositoryExists(ctx, token, org, repo)
+	if err != nil {
+		return fmt.Errorf("failed to look up canary repo %s/%s: %v", org, repo, err)
+	}
+	if exists {
+		return nil
+	}
+	p.plog.Infof("Creating canary repo %s/%s", org, repo)
+	if err := CreateRepository(ctx, token, org, repo, fmt.Sprintf("Canary of %s/%s", p.config.TargetOrg, repo)); err != nil {
+		return fmt.Errorf("failed to create canary repo %s/%s: %v", org, repo, err)
+	}
+	return nil
+}
+
+// pushToTarget pushes the branch and its new tags to the given push target.
+func (p *PublisherMunger) pushToTarget(ctx context.Context, target config.PushTarget, repo string, branch config.BranchRule) error {
+	tokenRef := target.TokenFile
+	if tokenRef == "" {
+		var err error
+		if tokenRef, err = p.config.PushTokenRef(repo); err != nil {
+			return err
+		}
+	}
+	if _, err := loadToken(p.config, tokenRef); err != nil {
+		return err
+	}
+	env, err := p.credentialEnv(tokenRef, target.Username)
+	if err != nil {
+		return err
+	}
+	prefix := ""
+	if target.BitbucketServer != nil {
+		prefix = target.BitbucketServer.BranchPrefix()
+	}
+	err = p.runWithTimeout(ctx, "push", func() *exec.Cmd {
+		cmd := exec.Command(p.config.BasePublishScriptPath+"/push.sh", tokenRef, branch.Name, target.Name)
+		cmd.Env = append(append([]string(nil), env...),
+			"PUSH_BRANCH_ALIASES="+strings.Join(branch.Aliases, " "),
+			"PUSH_NOTES_REF="+p.notesRef(),
+			"PUSH_SKIP_TAGS="+strconv.FormatBool(p.config.Skip.TagPush),
+		)
+		if target.Name == config.CanaryRemote {
+			cmd.Env = append(cmd.Env, "PUSH_FORCE=true")
+		}
+		if prefix != "" {
+			cmd.Env = append(cmd.Env, "PUSH_BRANCH_PREFIX="+prefix)
+		}
+		return cmd
+	})
+	if err != nil || prefix == "" {
+		return err
+	}
+	return p.openBitbucketPullRequests(ctx, target, tokenRef, env, repo, branch)
+}
+
+// ensureDefaultBranch sets the default branch of the destination repo via the
+// GitHub API if configured.
+func (p *PublisherMunger) ensureDefaultBranch(ctx context.Context, repoRule config.RepositoryRule) error {
+	if repoRule.DefaultBranch == "" {
+		return nil
+	}
+	tokenRef, err := p.config.PushTokenRef(repoRule.DestinationRepository)
+	if err != nil {
+		return err
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	changed, err := EnsureDefaultBranch(ctx, token, p.config.TargetOrg, repoRule.DestinationRepository, repoRule.DefaultBranch)
+	if err != nil {
+		return err
+	}
+	if changed {
+		p.plog.Infof("Changed default branch of %s/%s to %s", p.config.TargetOrg, repoRule.DestinationRepository, repoRule.DefaultBranch)
 	}
 	return nil
 }
 
-// Run constructs the repos and pushes them.
-func (p *PublisherMunger) Run() (string, string, error) {
+// ensureMetadata reconciles the metadata of the destination repo via the GitHub
+// API if configured.
+func (p *PublisherMunger) ensureMetadata(ctx context.Context, repoRule config.RepositoryRule) error {
+	if repoRule.Metadata.IsZero() {
+		return nil
+	}
+	tokenRef, err := p.config.PushTokenRef(repoRule.DestinationRepository)
+	if err != nil {
+		return err
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	changed, err := EnsureMetadata(ctx, token, p.config.TargetOrg, repoRule.DestinationRepository, repoRule.Metadata)
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		p.plog.Infof("Updated %s of %s/%s", strings.Join(changed, ", "), p.config.TargetOrg, repoRule.DestinationRepository)
+	}
+	return nil
+}
+
+// Run constructs the repos and pushes them. If ctx is cancelled, the running
+// command is killed and a checkpoint is recorded before returning.
+func (p *PublisherMunger) Run(ctx context.Context) (string, string, error) {
 	buf := bytes.NewBuffer(nil)
 	var err error
 	if p.plog, err = NewPublisherLog(buf, path.Join(p.baseRepoPath, "run.log")); err != nil {
 		return "", "", err
 	}
 
-	hash, err := p.updateSourceRepo()
+	if cp, err := LoadCheckpoint(p.state); err != nil {
+		p.plog.Errorf("Failed to load checkpoint: %v", err)
+	} else if cp != nil && cp.Interrupted {
+		p.plog.Infof("Previous run was interrupted in phase %s at repository %q, branch %q. Starting over.", cp.Phase, cp.Repository, cp.Branch)
+	}
+	p.checkpoint = Checkpoint{}
+	p.skippedDstBranches = map[string]string{}
+	p.goVersions = map[string]string{}
+	p.rebuilt = map[string]string{}
+	p.remainingCommits = map[string]int{}
+	p.archived = map[string]bool{}
+	p.result = RunResult{Start: time.Now()}
+	p.timer = repoTimer{}
+	p.startEvents(ctx)
+	defer p.flushEvents()
+	p.emit(Event{Type: config.EventRunStarted})
+	// an unreadable state must not be overwritten by an empty one on the next save
+	if p.batches, err = LoadBatchState(p.state); err != nil {
+		return p.fail(ctx, fmt.Errorf("failed to load batch state: %v", err))
+	}
+	p.drifted = nil
+	if p.heads, err = LoadHeadState(p.state); err != nil {
+		return p.fail(ctx, fmt.Errorf("failed to load head state: %v", err))
+	}
+
+	if skipped := p.config.Skip.Phases(); len(skipped) > 0 {
+		p.plog.Warningf("Skipping phases for debugging: %s", strings.Join(skipped, ", "))
+	}
+	if err := p.setupCredentials(ctx); err != nil {
+		return p.fail(ctx, err)
+	}
+	hash, err := p.updateSourceRepo(ctx)
+	p.checkpoint.UpstreamHash, p.result.UpstreamHash = hash, hash
 	if err != nil {
-		p.plog.Errorf("%v", err)
-		p.plog.Flush()
-		return p.plog.Logs(), hash, err
+		return p.fail(ctx, err)
 	}
-	if err := p.construct(); err != nil {
-		p.plog.Errorf("%v", err)
-		p.plog.Flush()
-		return p.plog.Logs(), hash, err
+	if err := p.construct(ctx); err != nil {
+		return p.fail(ctx, err)
 	}
-	if err := p.publish(); err != nil {
-		p.plog.Errorf("%v", err)
-		p.plog.Flush()
-		return p.plog.Logs(), hash, err
+	p.timer.end(time.Now())
+	if err := p.collectResults(ctx); err != nil {
+		p.plog.Errorf("Failed to collect results: %v", err)
+	}
+	if err := p.publish(ctx); err != nil {
+		return p.fail(ctx, err)
+	}
+	if len(p.drifted) > 0 {
+		return p.fail(ctx, fmt.Errorf("not published because they changed outside of the bot: %s", strings.Join(p.drifted, ", ")))
 	}
+	p.measureUnpublished(ctx)
+	p.result.End = time.Now()
+	p.result.Durations = p.timer.stop(p.result.End)
+	p.checkpoint.Phase, p.checkpoint.Repository, p.checkpoint.Branch = "done", "", ""
+	if err := p.checkpoint.Save(p.state); err != nil {
+		p.plog.Errorf("Failed to save checkpoint: %v", err)
+	}
+	p.emit(Event{Type: config.EventRunFinished})
 	return p.plog.Logs(), hash, nil
 }
+
+// fail logs the error, records the checkpoint and returns the Run results.
+func (p *PublisherMunger) fail(ctx context.Context, err error) (string, string, error) {
+	if ctx.Err() != nil {
+		err = fmt.Errorf("interrupted in phase %s: %v", p.checkpoint.Phase, err)
+		p.checkpoint.Interrupted = true
+	} else {
+		p.measureUnpublished(ctx)
+	}
+	// the error ends up in logs, the run result, events and the issue
+	err = errors.New(redact.String(err.Error()))
+	p.plog.Errorf("%v", err)
+	p.result.End, p.result.Error = time.Now(), err.Error()
+	p.result.Durations = p.timer.stop(p.result.End)
+	p.result.Failure = &RunFailure{
+		Phase:      p.checkpoint.Phase,
+		Repository: p.checkpoint.Repository,
+		Branch:     p.checkpoint.Branch,
+		Category:   failureCategory(p.checkpoint, p.result),
+		Class:      classifyError(err.Error(), p.plog.FailedOutput(errorClassLogLines)),
+	}
+	if c := p.result.ConflictReport; c != nil {
+		p.result.Failure.Repository, p.result.Failure.Branch = c.Repository, c.Branch
+		p.result.Failure.Class = ErrorClassConflict
+	}
+	if !p.checkpoint.Interrupted {
+		p.snapshot()
+	}
+	if err := p.checkpoint.Save(p.state); err != nil {
+		p.plog.Errorf("Failed to save checkpoint: %v", err)
+	}
+
+	failed := Event{Type: config.EventRepoFailed, Repository: p.checkpoint.Repository, Branch: p.checkpoint.Branch, Error: err.Error(), ErrorClass: p.result.Failure.Class}
+	if c := p.result.ConflictReport; c != nil {
+		failed.Repository, failed.Branch, failed.SourceCommit = c.Repository, c.Branch, c.SourceCommit
+	}
+	if failed.Repository != "" {
+		p.emit(failed)
+	}
+	p.emit(Event{Type: config.EventRunFinished, Error: err.Error(), ErrorClass: p.result.Failure.Class})
+	p.plog.Flush()
+	return p.plog.Logs(), p.checkpoint.UpstreamHash, err
+}
@@ -22,6 +22,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -33,11 +34,17 @@ import (
 	"github.com/golang/glog"
 	"github.com/shurcooL/go/indentwriter"
 	"gopkg.in/natefinch/lumberjack.v2"
+
+	"k8s.io/publishing-bot/pkg/redact"
 )
 
 type plog struct {
 	combinedBufAndFile io.Writer
 	buf                *bytes.Buffer
+
+	mutex sync.Mutex
+	// failedStderr is the standard error of the last failed command
+	failedStderr string
 }
 
 func NewPublisherLog(buf *bytes.Buffer, logFileName string) (*plog, error) {
@@ -49,7 +56,7 @@ func NewPublisherLog(buf *bytes.Buffer, logFileName string) (*plog, error) {
 		return nil, err
 	}
 
-	return &plog{newSyncWriter(muxWriter{buf, logFile}), buf}, nil
+	return &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf, logFile}), buf: buf}, nil
 }
 
 func (p *plog) write(s string) {
@@ -58,25 +65,57 @@ func (p *plog) write(s string) {
 	p.combinedBufAndFile.Write([]byte("\n"))
 }
 
+// format formats the message with credentials redacted.
+func (p *plog) format(format string, args ...interface{}) string {
+	return redact.String(prefixFollowingLines("    ", fmt.Sprintf(format, args...)))
+}
+
 func (p *plog) Errorf(format string, args ...interface{}) {
-	s := prefixFollowingLines("    ", fmt.Sprintf(format, args...))
+	s := p.format(format, args...)
 	glog.ErrorDepth(1, s)
 	p.write(s)
 }
 
+func (p *plog) Warningf(format string, args ...interface{}) {
+	s := p.format(format, args...)
+	glog.WarningDepth(1, s)
+	p.write(s)
+}
+
 func (p *plog) Infof(format string, args ...interface{}) {
-	s := prefixFollowingLines("    ", fmt.Sprintf(format, args...))
+	s := p.format(format, args...)
 	glog.InfoDepth(1, s)
 	p.write(s)
 }
 
 func (p *plog) Fatalf(format string, args ...interface{}) {
-	s := prefixFollowingLines("    ", fmt.Sprintf(format, args...))
+	s := p.format(format, args...)
 	glog.FatalDepth(1, s)
 	p.write(s)
 }
 
 func (p *plog) Run(c *exec.Cmd) error {
+	return p.run(nil, c, nil)
+}
+
+// RunContext runs the command like Run, but kills the whole process group of the
+// command when ctx is done. Killing only the process itself would leave children
+// like git behind, which keep the output pipes open.
+func (p *plog) RunContext(ctx context.Context, c *exec.Cmd) error {
+	return p.run(ctx, c, nil)
+}
+
+// OutputContext runs the command like RunContext, but returns its standard
+// output instead of logging it.
+func (p *plog) OutputContext(ctx context.Context, c *exec.Cmd) ([]byte, error) {
+	out := &bytes.Buffer{}
+	err := p.run(ctx, c, out)
+	return out.Bytes(), err
+}
+
+// run runs the command, writing its standard output to stdout if given, or
+// logging it otherwise.
+func (p *plog) run(ctx context.Context, c *exec.Cmd, stdout io.Writer) error {
 	p.Infof("%s", cmdStr(*c))
 
 	errBuf := &bytes.Buffer{}
@@ -84,16 +123,36 @@ func (p *plog) Run(c *exec.Cmd) error {
 	stdoutLineWriter := newLineWriter(muxWriter{p.combinedBufAndFile, os.Stdout})
 	stderrLineWriter := newLineWriter(muxWriter{p.combinedBufAndFile, errBuf})
 	c.Stdout = indentwriter.New(stdoutLineWriter, 1)
+	if stdout != nil {
+		c.Stdout = stdout
+	}
 	c.Stderr = indentwriter.New(stderrLineWriter, 1)
 
+	if ctx != nil {
+		setProcessGroup(c)
+	}
 	err := c.Start()
 	if err != nil {
 		p.Errorf("failed to start %q: %v", c.Path, err)
 		return err
 	}
+	done := make(chan struct{})
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(c)
+			case <-done:
+			}
+		}()
+	}
 	err = c.Wait()
+	close(done)
 	if err != nil {
 		p.Errorf("%s\n%s", err.Error(), errBuf.String())
+		p.mutex.Lock()
+		p.failedStderr = errBuf.String()
+		p.mutex.Unlock()
 	}
 	stdoutLineWriter.Flush()
 	stderrLineWriter.Flush()
@@ -104,6 +163,26 @@ func (p *plog) Logs() string {
 	return p.buf.String()
 }
 
+// FailedOutput returns the last lines of the standard error of the last failed
+// command, e.g. to classify the error of a failed construction without the
+// output of the commands before.
+func (p *plog) FailedOutput(lines int) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ls := strings.Split(strings.TrimRight(p.failedStderr, "\n"), "\n")
+	if len(ls) > lines {
+		ls = ls[len(ls)-lines:]
+	}
+	return strings.Join(ls, "\n")
+}
+
+// ResetFailedOutput forgets the standard error of the last failed command.
+func (p *plog) ResetFailedOutput() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.failedStderr = ""
+}
+
 func (p *plog) Flush() {
 	glog.Flush()
 }
@@ -173,10 +252,11 @@ func (lw lineWriter) Write(b []byte) (int, error) {
 	return n, nil
 }
 
+// Flush writes the buffered output with credentials redacted.
 func (lw lineWriter) Flush() (int, error) {
-	written, err := lw.buf.WriteTo(lw.writer)
+	written, err := io.WriteString(lw.writer, redact.String(lw.buf.String()))
 	lw.buf.Reset()
-	return int(written), err
+	return written, err
 }
 
 func newSyncWriter(writer io.Writer) syncWriter {
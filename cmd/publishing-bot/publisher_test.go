@@ -17,8 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
 )
 
 func Test_updateEnv(t *testing.T) {
@@ -38,3 +45,58 @@ func Test_updateEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestPushTargets(t *testing.T) {
+	mirror := config.PushTarget{Name: "gitlab", URL: "https://gitlab.example.com/libs/client-go"}
+	rule := config.RepositoryRule{DestinationRepository: "client-go", PushTargets: []config.PushTarget{mirror}}
+	tests := []struct {
+		name   string
+		canary config.Canary
+		want   []config.PushTarget
+	}{
+		{"no canary", config.Canary{}, []config.PushTarget{mirror}},
+		{"canary with global token", config.Canary{Org: "k8s-canary"}, []config.PushTarget{
+			mirror,
+			{Name: "canary", URL: "https://github.com/k8s-canary/client-go", TokenFile: "/token"},
+		}},
+		{"canary with own token", config.Canary{Org: "k8s-canary", Token: "env:CANARY_TOKEN"}, []config.PushTarget{
+			mirror,
+			{Name: "canary", URL: "https://github.com/k8s-canary/client-go", TokenFile: "env:CANARY_TOKEN"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(&config.Config{GithubHost: "github.com", TokenFile: "/token", Canary: tt.canary}, "")
+			if got := p.pushTargets(rule); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pushTargets() = %v, want %v", got, tt.want)
+			}
+			if len(rule.PushTargets) != 1 {
+				t.Errorf("push targets of the rule were modified: %v", rule.PushTargets)
+			}
+		})
+	}
+}
+
+func TestCheckBuild(t *testing.T) {
+	for _, skip := range []bool{true, false} {
+		dir, err := ioutil.TempDir("", "publishing-bot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		buf := bytes.NewBuffer(nil)
+		p := &PublisherMunger{
+			config: &config.Config{Skip: config.SkipPhases{BuildCheck: skip}},
+			plog:   &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+			dir:    dir,
+		}
+		repoRule := config.RepositoryRule{DestinationRepository: "api", SmokeTest: "touch " + filepath.Join(dir, "smoke-test-ran")}
+		if err := p.checkBuild(context.Background(), repoRule, config.BranchRule{Name: "master"}, os.Environ()); err != nil {
+			t.Fatalf("skip=%v: unexpected error: %v", skip, err)
+		}
+		_, err = os.Stat(filepath.Join(dir, "smoke-test-ran"))
+		if ran := err == nil; ran == skip {
+			t.Errorf("skip=%v: expected the smoke test to run %v, got %v", skip, !skip, ran)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// redirectMarker identifies the comments of the redirector, such that the
+// grace period of pull requests starts with the comment.
+const redirectMarker = "<!-- publishing-bot redirect -->"
+
+// redirector scans the destination repos for pull requests and issues opened
+// by humans, directs their authors to the source repo in a comment and closes
+// pull requests after the grace period.
+type redirector struct {
+	config *config.Config
+	now    func() time.Time
+}
+
+func newRedirector(cfg *config.Config) *redirector {
+	return &redirector{config: cfg, now: time.Now}
+}
+
+// Run scans until the context is done.
+func (r *redirector) Run(ctx context.Context) {
+	for {
+		if err := r.poll(ctx); err != nil {
+			glog.Errorf("Failed to scan the destination repos for pull requests and issues: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.config.Redirect.Interval()):
+		}
+	}
+}
+
+func (r *redirector) poll(ctx context.Context) error {
+	rules, err := config.LoadRules(r.config.RulesFile, r.config.Network.TLS)
+	if err != nil {
+		return fmt.Errorf("failed to load the rules: %v", err)
+	}
+	if err := rules.ExpandSourceDirs(r.config.BasePackage); err != nil {
+		return err
+	}
+	tmpl, err := r.config.Redirect.Template()
+	if err != nil {
+		return err
+	}
+	for _, repoRule := range rules.Rules {
+		if repoRule.Skip || repoRule.Metadata.Archived || len(repoRule.Branches) == 0 {
+			continue
+		}
+		// one failing repo, e.g. without token, does not stop the others
+		if err := r.scan(ctx, rules, repoRule, tmpl); err != nil {
+			glog.Errorf("Failed to scan %s/%s for pull requests and issues: %v", r.config.TargetOrg, repoRule.DestinationRepository, err)
+		}
+	}
+	return nil
+}
+
+// scan handles the open pull requests and issues of the destination repo
+// created after the cutoff, newest first.
+func (r *redirector) scan(ctx context.Context, rules *config.RepositoryRules, repoRule config.RepositoryRule, tmpl *template.Template) error {
+	tokenRef, err := r.config.PushTokenRef(repoRule.DestinationRepository)
+	if err != nil {
+		return err
+	}
+	token, err := loadToken(r.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	client := githubClient(ctx, token)
+	org, repo := r.config.TargetOrg, repoRule.DestinationRepository
+
+	myself, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to get own user: %v", err)
+	}
+	cutoff := r.config.Redirect.Cutoff()
+	opts := &github.IssueListByRepoOptions{State: "open", Sort: "created", Direction: "desc", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		issues, resp, err := client.Issues.ListByRepo(ctx, org, repo, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list open pull requests and issues: %v", err)
+		}
+		for _, issue := range issues {
+			if !issue.GetCreatedAt().After(cutoff) {
+				return nil
+			}
+			pr := issue.IsPullRequest()
+			if pr && !r.config.Redirect.PullRequests || !pr && !r.config.Redirect.Issues {
+				continue
+			}
+			if issue.GetUser().GetID() == myself.GetID() || redirectExempt(r.config.Redirect, issue) {
+				continue
+			}
+			if err := r.handle(ctx, client, myself.GetID(), rules, repoRule, issue, tmpl); err != nil {
+				return fmt.Errorf("#%d: %v", issue.GetNumber(), err)
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// handle comments on the pull request or issue and labels it if it does not
+// have the label yet, or closes the pull request if the grace period is over.
+// The comments of the bot with the given user ID count.
+func (r *redirector) handle(ctx context.Context, client *github.Client, botID int64, rules *config.RepositoryRules, repoRule config.RepositoryRule, issue *github.Issue, tmpl *template.Template) error {
+	org, repo, number := r.config.TargetOrg, repoRule.DestinationRepository, issue.GetNumber()
+	label := r.config.Redirect.HandledLabel()
+	grace := r.config.Redirect.ClosePullRequestsAfter
+
+	if !hasLabel(issue, label) {
+		// a previous poll may have commented, but failed to add the label
+		commented, err := redirectedAt(ctx, client, botID, org, repo, number)
+		if err != nil {
+			return err
+		}
+		if commented.IsZero() {
+			if err := r.comment(ctx, client, rules, repoRule, issue, tmpl); err != nil {
+				return err
+			}
+		}
+		if _, _, err := client.Issues.AddLabelsToIssue(ctx, org, repo, number, []string{label}); err != nil {
+			return fmt.Errorf("failed to add label %q: %v", label, err)
+		}
+		return nil
+	}
+
+	// the comment is not older than the pull request, hence the comments are
+	// only listed once the grace period can be over
+	if !issue.IsPullRequest() || grace <= 0 || r.now().Sub(issue.GetCreatedAt()) < grace {
+		return nil
+	}
+	commented, err := redirectedAt(ctx, client, botID, org, repo, number)
+	if err != nil {
+		return err
+	}
+	// without comment, the label was added by hand
+	if !commented.IsZero() && r.now().Sub(commented) >= grace {
+		if _, _, err := client.PullRequests.Edit(ctx, org, repo, number, &github.PullRequest{State: github.String("closed")}); err != nil {
+			return fmt.Errorf("failed to close: %v", err)
+		}
+		glog.Infof("Closed %s/%s#%d after the grace period of %v", org, repo, number, grace)
+	}
+	return nil
+}
+
+// comment directs the author of the pull request or issue to the source repo.
+func (r *redirector) comment(ctx context.Context, client *github.Client, rules *config.RepositoryRules, repoRule config.RepositoryRule, issue *github.Issue, tmpl *template.Template) error {
+	org, repo, number := r.config.TargetOrg, repoRule.DestinationRepository, issue.GetNumber()
+	grace := r.config.Redirect.ClosePullRequestsAfter
+	kind, closeAfter := "issue", ""
+	if issue.IsPullRequest() {
+		kind = "pull request"
+		if grace > 0 {
+			closeAfter = grace.String()
+		}
+	}
+	branchRule := mainlineBranchRule(rules, repoRule)
+	body, err := redirectComment(tmpl, map[string]string{
+		"Kind":             kind,
+		"Author":           issue.GetUser().GetLogin(),
+		"Repository":       org + "/" + repo,
+		"SourceRepository": r.config.SourceOrg + "/" + r.config.SourceRepo,
+		"SourceURL":        redirectSourceURL(r.config, branchRule),
+		"SourceDir":        branchRule.Source.Dir,
+		"CloseAfter":       closeAfter,
+	})
+	if err != nil {
+		return err
+	}
+	if _, _, err := client.Issues.CreateComment(ctx, org, repo, number, &github.IssueComment{Body: github.String(body)}); err != nil {
+		return fmt.Errorf("failed to comment: %v", err)
+	}
+	glog.Infof("Directed the author of %s/%s#%d to the source repo", org, repo, number)
+	return nil
+}
+
+// redirectedAt returns when the bot with the given user ID commented on the
+// pull request or issue with the marker of the redirector, or the zero time
+// if it did not. Others may quote the marker.
+func redirectedAt(ctx context.Context, client *github.Client, botID int64, org, repo string, number int) (time.Time, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, org, repo, number, opts)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to list comments: %v", err)
+		}
+		for _, c := range comments {
+			if c.GetUser().GetID() == botID && strings.Contains(c.GetBody(), redirectMarker) {
+				return c.GetCreatedAt(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return time.Time{}, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// hasLabel returns true if the pull request or issue has the label.
+func hasLabel(issue *github.Issue, label string) bool {
+	for _, l := range issue.Labels {
+		if l.GetName() == label {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectExempt returns true if the pull request or issue is opened by a bot
+// or an exempt user, or has an exempt label.
+func redirectExempt(cfg config.Redirect, issue *github.Issue) bool {
+	user := issue.GetUser()
+	if user.GetType() == "Bot" {
+		return true
+	}
+	for _, u := range cfg.ExemptUsers {
+		if strings.EqualFold(u, user.GetLogin()) {
+			return true
+		}
+	}
+	for _, exempt := range cfg.ExemptLabels {
+		if hasLabel(issue, exempt) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectComment renders the comment with the marker of the redirector.
+func redirectComment(tmpl *template.Template, data map[string]string) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String() + "\n\n" + redirectMarker, nil
+}
+
+// mainlineBranchRule returns the branch rule of the mainline of the destination
+// repo, or its first branch rule.
+func mainlineBranchRule(rules *config.RepositoryRules, repoRule config.RepositoryRule) config.BranchRule {
+	mainline := rules.Mainline(repoRule)
+	for _, b := range repoRule.Branches {
+		if b.Name == mainline {
+			return b
+		}
+	}
+	return repoRule.Branches[0]
+}
+
+// redirectSourceURL returns the URL of the source directory of the branch, or
+// the source URL if the source repo is not on GitHub.
+func redirectSourceURL(cfg *config.Config, branchRule config.BranchRule) string {
+	if cfg.SourceURL != "" {
+		return strings.TrimSuffix(cfg.SourceURL, ".git")
+	}
+	u := fmt.Sprintf("https://%s/%s/%s", cfg.GithubHost, cfg.SourceOrg, cfg.SourceRepo)
+	if dir := strings.TrimPrefix(branchRule.Source.Dir, "./"); dir != "" && dir != "." {
+		u += fmt.Sprintf("/tree/%s/%s", branchRule.Source.Branch, dir)
+	}
+	return u
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestRedirectExempt(t *testing.T) {
+	cfg := config.Redirect{PullRequests: true, ExemptUsers: []string{"Alice"}, ExemptLabels: []string{"keep-open"}}
+	tests := []struct {
+		name  string
+		issue *github.Issue
+		want  bool
+	}{
+		{"human", &github.Issue{User: &github.User{Login: github.String("bob"), Type: github.String("User")}}, false},
+		{"bot", &github.Issue{User: &github.User{Login: github.String("dependabot[bot]"), Type: github.String("Bot")}}, true},
+		{"exempt user", &github.Issue{User: &github.User{Login: github.String("alice")}}, true},
+		{"exempt label", &github.Issue{User: &github.User{Login: github.String("bob")}, Labels: []github.Label{{Name: github.String("keep-open")}}}, true},
+		{"other label", &github.Issue{User: &github.User{Login: github.String("bob")}, Labels: []github.Label{{Name: github.String("bug")}}}, false},
+	}
+	for _, tt := range tests {
+		if got := redirectExempt(cfg, tt.issue); got != tt.want {
+			t.Errorf("%s: redirectExempt() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectComment(t *testing.T) {
+	cfg := &config.Config{GithubHost: "github.com", SourceOrg: "kubernetes", SourceRepo: "kubernetes"}
+	branchRule := config.BranchRule{Source: config.Source{Branch: "master", Dir: "staging/src/k8s.io/api"}}
+	tmpl, err := config.Redirect{}.Template()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := redirectComment(tmpl, map[string]string{
+		"Kind":             "pull request",
+		"Author":           "bob",
+		"Repository":       "kubernetes/api",
+		"SourceRepository": "kubernetes/kubernetes",
+		"SourceURL":        redirectSourceURL(cfg, branchRule),
+		"SourceDir":        branchRule.Source.Dir,
+		"CloseAfter":       "168h0m0s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"@bob",
+		"[kubernetes/kubernetes](https://github.com/kubernetes/kubernetes/tree/master/staging/src/k8s.io/api)",
+		"This pull request is closed in 168h0m0s.",
+		redirectMarker,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in comment:\n%s", want, body)
+		}
+	}
+}
+
+func TestRedirectHandle(t *testing.T) {
+	now := time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)
+	const botID = 42
+	// when the redirector commented on the issue, by number
+	commented := map[string]time.Time{
+		"3": now.Add(-30 * time.Hour),
+		"4": now.Add(-10 * time.Hour),
+		"8": now.Add(-30 * time.Hour),
+	}
+	// when someone else quoted the comment of the redirector, by number
+	quoted := map[string]time.Time{
+		"5": now.Add(-30 * time.Hour),
+		"9": now.Add(-30 * time.Hour),
+	}
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/comments"):
+			number := strings.Split(r.URL.Path, "/")[5]
+			fmt.Fprint(w, `[{"body": "LGTM"}`)
+			if at, found := commented[number]; found {
+				fmt.Fprintf(w, `, {"body": "Thanks!\n\n%s", "created_at": %q, "user": {"id": %d}}`, redirectMarker, at.Format(time.RFC3339), botID)
+			}
+			if at, found := quoted[number]; found {
+				fmt.Fprintf(w, `, {"body": "> %s", "created_at": %q, "user": {"id": 7}}`, redirectMarker, at.Format(time.RFC3339))
+			}
+			fmt.Fprint(w, `]`)
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/comments"):
+			fmt.Fprint(w, `{}`)
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/labels"):
+			fmt.Fprint(w, `[]`)
+		case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/repos/kubernetes/api/pulls/"):
+			fmt.Fprint(w, `{}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	cfg := &config.Config{GithubHost: "github.com", TargetOrg: "kubernetes", SourceOrg: "kubernetes", SourceRepo: "kubernetes",
+		Redirect: config.Redirect{PullRequests: true, Issues: true, ClosePullRequestsAfter: 24 * time.Hour}}
+	r := newRedirector(cfg)
+	r.now = func() time.Time { return now }
+	tmpl, err := cfg.Redirect.Template()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoRule := config.RepositoryRule{DestinationRepository: "api", Branches: []config.BranchRule{
+		{Name: "master", Source: config.Source{Branch: "master", Dir: "staging/src/k8s.io/api"}},
+	}}
+	rules := &config.RepositoryRules{Rules: []config.RepositoryRule{repoRule}}
+
+	labeled := []github.Label{{Name: github.String(config.DefaultRedirectLabel)}}
+	issue := func(number int, pr bool, age time.Duration, labels []github.Label) *github.Issue {
+		created := now.Add(-age)
+		i := &github.Issue{Number: github.Int(number), CreatedAt: &created, Labels: labels,
+			User: &github.User{Login: github.String("bob")}}
+		if pr {
+			i.PullRequestLinks = &github.PullRequestLinks{}
+		}
+		return i
+	}
+	tests := []struct {
+		name  string
+		issue *github.Issue
+		want  []string
+	}{
+		{"new pull request", issue(1, true, time.Hour, nil), []string{
+			"GET /repos/kubernetes/api/issues/1/comments",
+			"POST /repos/kubernetes/api/issues/1/comments",
+			"POST /repos/kubernetes/api/issues/1/labels",
+		}},
+		{"labeled pull request in the grace period", issue(2, true, time.Hour, labeled), nil},
+		{"labeled pull request after the grace period", issue(3, true, 48*time.Hour, labeled), []string{
+			"GET /repos/kubernetes/api/issues/3/comments",
+			"PATCH /repos/kubernetes/api/pulls/3",
+		}},
+		{"old pull request commented in the grace period", issue(4, true, 48*time.Hour, labeled), []string{
+			"GET /repos/kubernetes/api/issues/4/comments",
+		}},
+		{"pull request labeled by hand and quoting the comment", issue(5, true, 48*time.Hour, labeled), []string{
+			"GET /repos/kubernetes/api/issues/5/comments",
+		}},
+		{"new issue", issue(6, false, time.Hour, nil), []string{
+			"GET /repos/kubernetes/api/issues/6/comments",
+			"POST /repos/kubernetes/api/issues/6/comments",
+			"POST /repos/kubernetes/api/issues/6/labels",
+		}},
+		{"old labeled issue", issue(7, false, 48*time.Hour, labeled), nil},
+		{"commented pull request failed to be labeled", issue(8, true, 48*time.Hour, nil), []string{
+			"GET /repos/kubernetes/api/issues/8/comments",
+			"POST /repos/kubernetes/api/issues/8/labels",
+		}},
+		{"pull request quoting the comment", issue(9, true, time.Hour, nil), []string{
+			"GET /repos/kubernetes/api/issues/9/comments",
+			"POST /repos/kubernetes/api/issues/9/comments",
+			"POST /repos/kubernetes/api/issues/9/labels",
+		}},
+	}
+	for _, tt := range tests {
+		requests = nil
+		if err := r.handle(context.Background(), client, botID, rules, repoRule, tt.issue, tmpl); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(requests, tt.want) {
+			t.Errorf("%s: expected requests %q, got %q", tt.name, tt.want, requests)
+		}
+	}
+}
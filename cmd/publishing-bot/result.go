@@ -0,0 +1,307 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// RunResult summarizes a publishing run.
+type RunResult struct {
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	UpstreamHash string         `json:"upstreamHash,omitempty"`
+	Branches     []BranchResult `json:"branches,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	// ConflictReport describes why constructing a branch failed, if so.
+	ConflictReport *ConflictReport `json:"conflictReport,omitempty"`
+	// UnpublishedSince is the commit time of the newest unpublished source
+	// commit by <destination>/<branch>, zero if the branch is up to date.
+	UnpublishedSince map[string]time.Time `json:"unpublishedSince,omitempty"`
+	// Failure locates and categorizes the error of a failed run.
+	Failure *RunFailure `json:"failure,omitempty"`
+	// Durations is the time spent constructing and publishing each
+	// destination repo.
+	Durations map[string]time.Duration `json:"durations,omitempty"`
+	// Retries are the destination repos retried after transient errors.
+	Retries []RepoRetry `json:"retries,omitempty"`
+	// Snapshot is the location of the workspace snapshot of a failed run.
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// RunFailure is where a run failed and the category of the error.
+type RunFailure struct {
+	Phase      string `json:"phase,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	Category   string `json:"category"`
+	// Class buckets the error by its cause, e.g. auth or network.
+	Class string `json:"class,omitempty"`
+}
+
+// BranchResult is the outcome of a run for one destination branch.
+type BranchResult struct {
+	Repository string `json:"repository"`
+	Branch     string `json:"branch"`
+	// SourceCommit is the latest source commit on the constructed branch.
+	SourceCommit     string    `json:"sourceCommit,omitempty"`
+	SourceCommitTime time.Time `json:"sourceCommitTime,omitempty"`
+	// Commits is the number of constructed commits not on origin yet.
+	Commits int `json:"commits"`
+	// GoVersion is the Go version the branch was constructed with, if any.
+	GoVersion string `json:"goVersion,omitempty"`
+	// Backup is the branch the published history is kept under if the branch
+	// was constructed from scratch because its source branch was force-pushed.
+	Backup string `json:"backup,omitempty"`
+	// RemainingCommits is the number of new source commits left for the next
+	// runs because of batching max-commits.
+	RemainingCommits int `json:"remainingCommits,omitempty"`
+	// Tags are the new tags.
+	Tags   []string `json:"tags,omitempty"`
+	Pushed bool     `json:"pushed"`
+	// Skipped is the reason why the branch was not published, if so.
+	Skipped string `json:"skipped,omitempty"`
+	// SkippedCommits are the source commits dropped from the branch by skip
+	// lists, patterns or commit trailers.
+	SkippedCommits []SkippedCommit `json:"skippedCommits,omitempty"`
+	// PublishedCommits are the new commits with their source commits, newest
+	// first. New and rebuilt branches have none.
+	PublishedCommits []PublishedCommit `json:"publishedCommits,omitempty"`
+}
+
+// PublishedCommit is a constructed commit and the source commit it was
+// published from.
+type PublishedCommit struct {
+	Commit       string `json:"commit"`
+	SourceCommit string `json:"sourceCommit"`
+}
+
+// maxPublishedCommits limits the published commits recorded per branch.
+const maxPublishedCommits = 100
+
+// SkippedCommit is a source commit which was not published, with the reason.
+type SkippedCommit struct {
+	Commit string `json:"commit"`
+	Reason string `json:"reason"`
+}
+
+// Outcomes of a run.
+const (
+	OutcomePublished        = "published"
+	OutcomeNothingToPublish = "nothing-to-publish"
+	OutcomeFailed           = "failed"
+)
+
+// Outcome classifies the run. In dry-run mode, a run which would have published
+// something counts as published.
+func (r RunResult) Outcome() string {
+	if r.Error != "" {
+		return OutcomeFailed
+	}
+	for _, b := range r.Branches {
+		if b.Commits > 0 || len(b.Tags) > 0 {
+			return OutcomePublished
+		}
+	}
+	return OutcomeNothingToPublish
+}
+
+// WriteFile writes the result with its outcome as JSON atomically to the given path.
+func (r RunResult) WriteFile(pth string) error {
+	bs, err := json.MarshalIndent(struct {
+		Outcome string `json:"outcome"`
+		RunResult
+	}{r.Outcome(), r}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(pth+".tmp", bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(pth+".tmp", pth)
+}
+
+// Latency is the time from the source commit until the end of the run.
+func (r RunResult) Latency(b BranchResult) time.Duration {
+	if b.SourceCommitTime.IsZero() || !b.Pushed {
+		return 0
+	}
+	return r.End.Sub(b.SourceCommitTime)
+}
+
+// collectResults records what is going to be published for each destination
+// branch after construction.
+func (p *PublisherMunger) collectResults(ctx context.Context) error {
+	for _, repoRule := range p.reposRules.Rules {
+		if repoRule.Skip {
+			continue
+		}
+		dstDir := p.dstDir(repoRule)
+		if _, err := os.Stat(dstDir); err != nil {
+			return err
+		}
+		p.dir = dstDir
+		for _, branchRule := range repoRule.Branches {
+			if p.skippedBranch(branchRule.Source.Branch) {
+				continue
+			}
+			r := BranchResult{
+				Repository: repoRule.DestinationRepository,
+				Branch:     branchRule.Name,
+				Skipped:    p.skippedDstBranches[repoRule.DestinationRepository+"/"+branchRule.Name],
+			}
+			if r.Skipped == "" {
+				r.SourceCommit = p.sourceCommitOf(ctx, branchRule.Name)
+				r.SourceCommitTime = p.sourceCommitTime(ctx, r.SourceCommit)
+				r.Commits = p.newCommits(ctx, branchRule.Name)
+				r.GoVersion = p.goVersions[repoRule.DestinationRepository+"/"+branchRule.Name]
+				r.Backup = p.rebuilt[repoRule.DestinationRepository+"/"+branchRule.Name]
+				r.RemainingCommits = p.remainingCommits[repoRule.DestinationRepository+"/"+branchRule.Name]
+				r.Tags = newTags(dstDir, repoRule.DestinationRepository, branchRule.Name)
+				r.SkippedCommits = skippedCommits(dstDir, repoRule.DestinationRepository, branchRule.Name)
+				if r.Backup == "" {
+					r.PublishedCommits = p.publishedCommits(ctx, branchRule.Name)
+				}
+			}
+			p.result.Branches = append(p.result.Branches, r)
+		}
+	}
+	return nil
+}
+
+// markPushed records that the branch was pushed to origin.
+func (p *PublisherMunger) markPushed(repo, branch string) {
+	for i := range p.result.Branches {
+		if b := &p.result.Branches[i]; b.Repository == repo && b.Branch == branch {
+			b.Pushed = true
+		}
+	}
+}
+
+// sourceCommitTime returns the commit time of the source commit, or the zero time.
+func (p *PublisherMunger) sourceCommitTime(ctx context.Context, sha string) time.Time {
+	if sha == "" {
+		return time.Time{}
+	}
+	cmd := p.command(ctx, "git", "show", "-s", "--format=%ct", sha)
+	cmd.Dir = p.config.SourceDir(p.baseRepoPath)
+	out, err := cmd.Output()
+	if err != nil {
+		return time.Time{}
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0)
+}
+
+// newCommits returns the number of commits on the local branch which are not on
+// origin, in the repository in the publisher's directory.
+func (p *PublisherMunger) newCommits(ctx context.Context, branch string) int {
+	rng := "origin/" + branch + ".." + branch
+	if err := p.command(ctx, "git", "rev-parse", "--verify", "-q", "origin/"+branch).Run(); err != nil {
+		rng = branch
+	}
+	out, err := p.command(ctx, "git", "rev-list", "--count", rng).Output()
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+	return n
+}
+
+// publishedCommits returns the commits of the branch in the destination repo in
+// the publisher's directory which are not on origin, with their source commits,
+// newest first. Branches not on origin have none.
+func (p *PublisherMunger) publishedCommits(ctx context.Context, branch string) []PublishedCommit {
+	if err := p.command(ctx, "git", "rev-parse", "--verify", "-q", "origin/"+branch).Run(); err != nil {
+		return nil
+	}
+	out, err := p.command(ctx, "git", "log", "--format=%H%x00%B%x00", fmt.Sprintf("--max-count=%d", maxPublishedCommits), "origin/"+branch+".."+branch).Output()
+	if err != nil {
+		return nil
+	}
+	var commits []PublishedCommit
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if source := sourceCommitInMessage(fields[i+1], config.CommitMsgTag(p.config.SourceRepo)+": "); source != "" {
+			commits = append(commits, PublishedCommit{Commit: strings.TrimSpace(fields[i]), SourceCommit: source})
+		}
+	}
+	return commits
+}
+
+// newTags returns the tags in the push-tags script written by sync-tags next to
+// the destination repo in dstDir for the given destination branch.
+func newTags(dstDir, repo, branch string) []string {
+	f, err := os.Open(filepath.Join(dstDir, "..", fmt.Sprintf("push-tags-%s-%s.sh", repo, branch)))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var tags []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		for _, field := range strings.Fields(s.Text()) {
+			if strings.HasPrefix(field, "refs/tags/") {
+				tags = append(tags, strings.TrimPrefix(field, "refs/tags/"))
+			}
+		}
+	}
+	return tags
+}
+
+// skippedCommits returns the source commits construct.sh dropped from the given
+// destination branch of the destination repo in dstDir.
+func skippedCommits(dstDir, repo, branch string) []SkippedCommit {
+	f, err := os.Open(filepath.Join(dstDir, "..", fmt.Sprintf("skipped-commits-%s-%s.txt", repo, branch)))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseSkippedCommits(f)
+}
+
+// parseSkippedCommits parses "<commit> <reason>" lines. A commit can be dropped
+// more than once while constructing a branch. It is listed once.
+func parseSkippedCommits(r io.Reader) []SkippedCommit {
+	var commits []SkippedCommit
+	seen := map[string]bool{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fs := strings.SplitN(strings.TrimSpace(s.Text()), " ", 2)
+		if len(fs) != 2 || seen[fs[0]] {
+			continue
+		}
+		seen[fs[0]] = true
+		commits = append(commits, SkippedCommit{Commit: fs[0], Reason: fs[1]})
+	}
+	return commits
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		name   string
+		result RunResult
+		want   string
+	}{
+		{"failed", RunResult{Error: "boom", Branches: []BranchResult{{Commits: 1}}}, OutcomeFailed},
+		{"new commits", RunResult{Branches: []BranchResult{{}, {Commits: 2}}}, OutcomePublished},
+		{"new tags", RunResult{Branches: []BranchResult{{Tags: []string{"v1.0.0"}}}}, OutcomePublished},
+		{"nothing", RunResult{Branches: []BranchResult{{}, {Skipped: "pinned"}}}, OutcomeNothingToPublish},
+	}
+	for _, tt := range tests {
+		if got := tt.result.Outcome(); got != tt.want {
+			t.Errorf("%s: Outcome() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSkippedCommits(t *testing.T) {
+	got := parseSkippedCommits(strings.NewReader(`abc123 trailer Publishing-bot: skip-all
+def456 skip-source-commits
+abc123 trailer Publishing-bot: skip-all
+malformed
+`))
+	want := []SkippedCommit{
+		{Commit: "abc123", Reason: "trailer Publishing-bot: skip-all"},
+		{Commit: "def456", Reason: "skip-source-commits"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
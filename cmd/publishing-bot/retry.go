@@ -0,0 +1,246 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// Classes of the transient errors destination repos are retried after.
+const (
+	// RetryNetwork is a failed connection, e.g. to GitHub or a proxy.
+	RetryNetwork = ErrorClassNetwork
+	// RetryRateLimit is a request rejected because of API or git rate limits.
+	RetryRateLimit = ErrorClassRateLimit
+	// RetryDependency is a repo whose dependency was retried.
+	RetryDependency = "dependency"
+)
+
+// RepoRetry is the retry of a destination repo which failed with a transient
+// error.
+type RepoRetry struct {
+	Repository string `json:"repository"`
+	Class      string `json:"class"`
+	// Attempts is the number of retries.
+	Attempts int `json:"attempts"`
+	// Recovered is true if a retry succeeded.
+	Recovered bool `json:"recovered"`
+	// Error is the last error.
+	Error string `json:"error,omitempty"`
+}
+
+// transientErrorClass returns the error class of the error text or the output
+// of the failed command if it is transient, i.e. network or rate-limit, or the
+// empty string if it is not transient.
+func transientErrorClass(text string) string {
+	switch class := errorClassOf(text); class {
+	case ErrorClassNetwork, ErrorClassRateLimit:
+		return class
+	default:
+		return ""
+	}
+}
+
+// transientRetryDelay is the wait before the first retry of a fetch or push
+// failing with a transient error. It grows linearly with the attempts.
+var transientRetryDelay = 10 * time.Second
+
+// retryTransient runs the step, i.e. the fetch of the source repo or the push
+// of a destination repo, again up to RepoRetries times while it fails with a
+// transient error. The retries of destination repos are recorded in the result.
+func (p *PublisherMunger) retryTransient(ctx context.Context, repo, step string, run func() error) error {
+	p.plog.ResetFailedOutput()
+	err := run()
+	var r *RepoRetry
+	for attempt := 1; err != nil && attempt <= p.config.RepoRetries; attempt++ {
+		class := transientErrorClass(err.Error() + "\n" + p.plog.FailedOutput(errorClassLogLines))
+		if class == "" {
+			break
+		}
+		if r == nil {
+			r = &RepoRetry{Repository: repo}
+		}
+		r.Class, r.Attempts = class, attempt
+		p.plog.Errorf("The %s failed with a transient %s error, retrying it, attempt %d of %d: %v", step, class, attempt, p.config.RepoRetries, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * transientRetryDelay):
+		}
+		p.plog.ResetFailedOutput()
+		err = run()
+	}
+	if r != nil && repo != "" {
+		r.Recovered = err == nil
+		if err != nil {
+			r.Error = err.Error()
+		}
+		p.result.Retries = append(p.result.Retries, *r)
+	}
+	return err
+}
+
+// pendingDependency returns a dependency of the destination repo waiting to be
+// retried, or the empty string if there is none.
+func pendingDependency(repoRule config.RepositoryRule, pending map[string]*RepoRetry) string {
+	for _, b := range repoRule.Branches {
+		for _, d := range b.Dependencies {
+			if _, found := pending[d.Repository]; found {
+				return d.Repository
+			}
+		}
+	}
+	return ""
+}
+
+// retryRepos constructs the pending destination repos again, in the
+// construction order, up to RepoRetries times. Repos are retried once their
+// dependencies are constructed. It fails on non-transient errors and if repos
+// are left failing after the last attempt, with the failure on the repo still
+// failing, if there is only one besides its dependents.
+func (p *PublisherMunger) retryRepos(ctx context.Context, order []config.RepositoryRule, pending map[string]*RepoRetry, construct func(config.RepositoryRule) error) error {
+	for attempt := 1; attempt <= p.config.RepoRetries && len(pending) > 0; attempt++ {
+		for _, repoRule := range order {
+			r, found := pending[repoRule.DestinationRepository]
+			if !found || pendingDependency(repoRule, pending) != "" {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			r.Attempts++
+			p.plog.Infof("Retrying %s after a %s error, attempt %d of %d", r.Repository, r.Class, attempt, p.config.RepoRetries)
+			if c := p.result.ConflictReport; c != nil && c.Repository == r.Repository {
+				p.result.ConflictReport = nil
+			}
+			p.plog.ResetFailedOutput()
+			if err := construct(repoRule); err != nil {
+				class := transientErrorClass(err.Error() + "\n" + p.plog.FailedOutput(errorClassLogLines))
+				if class == "" {
+					return err
+				}
+				p.plog.Errorf("Retrying %s failed with a transient %s error: %v", r.Repository, class, err)
+				r.Class, r.Error = class, err.Error()
+				continue
+			}
+			r.Recovered, r.Error = true, ""
+			p.result.Retries = append(p.result.Retries, *r)
+			delete(pending, r.Repository)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var failed []string
+	for _, r := range pending {
+		p.result.Retries = append(p.result.Retries, *r)
+		failed = append(failed, r.Repository)
+	}
+	sort.Strings(failed)
+	// the last repo retried may have recovered
+	var causes []string
+	for _, name := range failed {
+		if pending[name].Class != RetryDependency {
+			causes = append(causes, name)
+		}
+	}
+	p.checkpoint.Repository, p.checkpoint.Branch = "", ""
+	if len(causes) == 1 {
+		p.checkpoint.Repository = causes[0]
+	}
+	sort.Slice(p.result.Retries, func(i, j int) bool { return p.result.Retries[i].Repository < p.result.Retries[j].Repository })
+	buf := bytes.NewBufferString("still failing after retries:")
+	for _, name := range failed {
+		r := pending[name]
+		fmt.Fprintf(buf, "\n%s (%s", name, r.Class)
+		if r.Error != "" {
+			fmt.Fprintf(buf, ": %s", r.Error)
+		}
+		buf.WriteString(")")
+	}
+	return fmt.Errorf("%s", buf.String())
+}
+
+// Outcomes of the retries of a destination repo in the metrics.
+const (
+	retryRecovered = "recovered"
+	retryFailed    = "failed"
+)
+
+type retryKey struct {
+	repository, class, outcome string
+}
+
+// retryCounter counts the retried destination repos of all runs by class and
+// outcome.
+type retryCounter struct {
+	mutex  sync.Mutex
+	counts map[retryKey]int
+}
+
+// add counts the retries of a run.
+func (c *retryCounter) add(retries []RepoRetry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, r := range retries {
+		if c.counts == nil {
+			c.counts = map[retryKey]int{}
+		}
+		outcome := retryFailed
+		if r.Recovered {
+			outcome = retryRecovered
+		}
+		c.counts[retryKey{r.Repository, r.Class, outcome}]++
+	}
+}
+
+// WriteMetrics writes the counts in the Prometheus text format, nothing if no
+// repo was retried yet.
+func (c *retryCounter) WriteMetrics(buf *bytes.Buffer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.counts) == 0 {
+		return
+	}
+	keys := make([]retryKey, 0, len(c.counts))
+	for k := range c.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.repository != b.repository {
+			return a.repository < b.repository
+		}
+		if a.class != b.class {
+			return a.class < b.class
+		}
+		return a.outcome < b.outcome
+	})
+	fmt.Fprintf(buf, "# HELP publishing_bot_repo_retries_total Destination repos constructed again after transient errors, by whether a retry succeeded.\n")
+	fmt.Fprintf(buf, "# TYPE publishing_bot_repo_retries_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(buf, "publishing_bot_repo_retries_total{repository=%q,class=%q,outcome=%q} %d\n", k.repository, k.class, k.outcome, c.counts[k])
+	}
+}
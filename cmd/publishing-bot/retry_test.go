@@ -0,0 +1,221 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestTransientErrorClass(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"fatal: unable to access 'https://github.com/kubernetes/api/': Could not resolve host: github.com", RetryNetwork},
+		{"error: RPC failed; curl 56 GnuTLS recv error\nfatal: early EOF", RetryNetwork},
+		{"fatal: unable to access 'https://github.com/kubernetes/api/': The requested URL returned error: 503", RetryNetwork},
+		{"remote: API rate limit exceeded for user", RetryRateLimit},
+		{"The requested URL returned error: 429", RetryRateLimit},
+		{"CONFLICT (content): Merge conflict in go.mod", ""},
+		{"exit status 1", ""},
+	}
+	for _, tt := range tests {
+		if got := transientErrorClass(tt.text); got != tt.want {
+			t.Errorf("transientErrorClass(%q) = %q, expected %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPendingDependency(t *testing.T) {
+	rule := config.RepositoryRule{
+		DestinationRepository: "client-go",
+		Branches: []config.BranchRule{
+			{Name: "master", Dependencies: []config.Dependency{{Repository: "api", Branch: "master"}, {Repository: "apimachinery", Branch: "master"}}},
+		},
+	}
+	if dep := pendingDependency(rule, map[string]*RepoRetry{"sample-controller": {}}); dep != "" {
+		t.Errorf("expected no pending dependency, got %q", dep)
+	}
+	if dep := pendingDependency(rule, map[string]*RepoRetry{"apimachinery": {}}); dep != "apimachinery" {
+		t.Errorf("expected pending dependency apimachinery, got %q", dep)
+	}
+}
+
+func TestRetryMetrics(t *testing.T) {
+	c := retryCounter{}
+	var buf bytes.Buffer
+	c.WriteMetrics(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("expected no metrics without retries, got:\n%s", buf.String())
+	}
+
+	c.add([]RepoRetry{{Repository: "api", Class: RetryNetwork, Attempts: 1, Recovered: true}})
+	c.add([]RepoRetry{
+		{Repository: "api", Class: RetryNetwork, Attempts: 1, Recovered: true},
+		{Repository: "client-go", Class: RetryDependency},
+	})
+	c.WriteMetrics(&buf)
+	expected := `# HELP publishing_bot_repo_retries_total Destination repos constructed again after transient errors, by whether a retry succeeded.
+# TYPE publishing_bot_repo_retries_total counter
+publishing_bot_repo_retries_total{repository="api",class="network",outcome="recovered"} 2
+publishing_bot_repo_retries_total{repository="client-go",class="dependency",outcome="failed"} 1
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestSummarizeRetries(t *testing.T) {
+	r := RunResult{
+		Error: "still failing after retries:\nclient-go (rate-limit)",
+		Branches: []BranchResult{
+			{Repository: "api", Branch: "master", Commits: 1, Pushed: true},
+		},
+		Failure: &RunFailure{Phase: "construct", Repository: "client-go", Category: FailureConstruct},
+		Retries: []RepoRetry{
+			{Repository: "api", Class: RetryNetwork, Attempts: 1, Recovered: true},
+			{Repository: "client-go", Class: RetryRateLimit, Attempts: 2, Error: "API rate limit exceeded"},
+		},
+	}
+	s := summarize(r)
+	if len(s.Repositories) != 2 {
+		t.Fatalf("expected 2 repositories, got %+v", s.Repositories)
+	}
+	api, clientGo := s.Repositories[0], s.Repositories[1]
+	if api.Outcome != OutcomePublished || api.Retry == nil || !api.Retry.Recovered {
+		t.Errorf("expected recovered api to be published, got %+v", api)
+	}
+	if clientGo.Outcome != OutcomeFailed || clientGo.ErrorCategory != FailureConstruct || clientGo.ErrorClass != ErrorClassRateLimit || clientGo.Retry == nil || clientGo.Retry.Class != RetryRateLimit {
+		t.Errorf("expected client-go to fail in the construction with a rate-limit, got %+v", clientGo)
+	}
+}
+
+func TestRetryRepos(t *testing.T) {
+	api := config.RepositoryRule{DestinationRepository: "api"}
+	clientGo := config.RepositoryRule{DestinationRepository: "client-go", Branches: []config.BranchRule{
+		{Name: "master", Dependencies: []config.Dependency{{Repository: "api", Branch: "master"}}},
+	}}
+	apimachinery := config.RepositoryRule{DestinationRepository: "apimachinery"}
+	order := []config.RepositoryRule{apimachinery, api, clientGo}
+	unreachable := errors.New("fatal: unable to access: Could not resolve host: github.com")
+
+	tests := []struct {
+		name         string
+		failures     map[string]int // transient failures of the retries, by repo
+		pending      []string
+		wantErr      bool
+		wantFailed   string // the repo of the failure
+		wantAttempts map[string]int
+	}{
+		{"recovered", map[string]int{"api": 1}, []string{"api"}, false, "", map[string]int{"api": 2, "client-go": 1}},
+		{"exhausted", map[string]int{"api": 2}, []string{"api"}, true, "api", map[string]int{"api": 2, "client-go": 0}},
+		{"several exhausted", map[string]int{"api": 2, "apimachinery": 2}, []string{"api", "apimachinery"}, true, "", map[string]int{"api": 2, "apimachinery": 2}},
+	}
+	for _, tt := range tests {
+		buf := bytes.NewBuffer(nil)
+		p := &PublisherMunger{
+			config: &config.Config{RepoRetries: 2},
+			plog:   &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+		}
+		pending := map[string]*RepoRetry{"client-go": {Repository: "client-go", Class: RetryDependency}}
+		for _, repo := range tt.pending {
+			pending[repo] = &RepoRetry{Repository: repo, Class: RetryNetwork}
+		}
+		constructed := map[string]int{}
+		err := p.retryRepos(context.Background(), order, pending, func(repoRule config.RepositoryRule) error {
+			repo := repoRule.DestinationRepository
+			constructed[repo]++
+			// the last repo constructed is recorded as the failing one
+			p.checkpoint.Repository = repo
+			if constructed[repo] <= tt.failures[repo] {
+				return unreachable
+			}
+			return nil
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "still failing after retries") {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if tt.wantErr && p.checkpoint.Repository != tt.wantFailed {
+			t.Errorf("%s: expected the failure on %q, got %q", tt.name, tt.wantFailed, p.checkpoint.Repository)
+		}
+		for repo, want := range tt.wantAttempts {
+			if constructed[repo] != want {
+				t.Errorf("%s: expected %s to be constructed %d times, got %d", tt.name, repo, want, constructed[repo])
+			}
+		}
+		for _, r := range p.result.Retries {
+			if wantRecovered := !tt.wantErr || tt.failures[r.Repository] == 0 && r.Class != RetryDependency; r.Recovered != wantRecovered {
+				t.Errorf("%s: expected %s to be recovered %v, got %+v", tt.name, r.Repository, wantRecovered, r)
+			}
+		}
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	transientRetryDelay = 0
+	defer func() { transientRetryDelay = 10 * time.Second }()
+	buf := bytes.NewBuffer(nil)
+	p := &PublisherMunger{
+		config: &config.Config{RepoRetries: 2},
+		plog:   &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+	}
+
+	attempts := 0
+	err := p.retryTransient(context.Background(), "api", "push of master", func() error {
+		if attempts++; attempts == 1 {
+			return errors.New("error: RPC failed; HTTP 502")
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("expected the push to recover in the second attempt, got %d attempts, %v", attempts, err)
+	}
+	if len(p.result.Retries) != 1 || !p.result.Retries[0].Recovered || p.result.Retries[0].Class != RetryNetwork {
+		t.Errorf("expected a recovered network retry, got %+v", p.result.Retries)
+	}
+
+	attempts = 0
+	err = p.retryTransient(context.Background(), "api", "push of master", func() error {
+		attempts++
+		return errors.New("! [remote rejected] master -> master (protected branch hook declined)")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("expected a rejected push not to be retried, got %d attempts, %v", attempts, err)
+	}
+
+	attempts = 0
+	err = p.retryTransient(context.Background(), "", "fetch", func() error {
+		attempts++
+		return errors.New("API rate limit exceeded")
+	})
+	if err == nil || attempts != 3 {
+		t.Errorf("expected the fetch to fail after 2 retries, got %d attempts, %v", attempts, err)
+	}
+	if len(p.result.Retries) != 1 {
+		t.Errorf("expected the retries of the fetch not to be recorded per repo, got %+v", p.result.Retries)
+	}
+}
@@ -0,0 +1,435 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// sbomComponent is a dependency listed in an SBOM.
+type sbomComponent struct {
+	Name    string
+	Version string
+	// Dirhash is the h1: hash of the module from go.sum, if any. It hashes
+	// the list of file hashes of the module, not an archive, and hence is no
+	// plain SHA-256 checksum.
+	Dirhash string
+	License string
+}
+
+// sbomDocument describes the published package and its dependencies.
+type sbomDocument struct {
+	// Name is the import path of the destination repo.
+	Name string
+	// Version is the branch or tag.
+	Version string
+	// Namespace is a URI unique to the document.
+	Namespace  string
+	Created    time.Time
+	Components []sbomComponent
+}
+
+// sbomComponents returns the dependencies of the checkout in dir with their
+// licenses, and versions and hashes from go.mod, go.sum or Godeps.json.
+func sbomComponents(dir, gopath string) ([]sbomComponent, error) {
+	deps, err := scanDependencyLicenses(dir, gopath)
+	if err != nil {
+		return nil, err
+	}
+	goMod, err := readOptionalFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	goSum, err := readOptionalFile(filepath.Join(dir, "go.sum"))
+	if err != nil {
+		return nil, err
+	}
+	godepsJSON, err := readOptionalFile(filepath.Join(dir, "Godeps", "Godeps.json"))
+	if err != nil {
+		return nil, err
+	}
+	var godeps struct {
+		Deps []struct {
+			ImportPath string
+			Rev        string
+		}
+	}
+	if len(godepsJSON) > 0 {
+		if err := json.Unmarshal(godepsJSON, &godeps); err != nil {
+			return nil, fmt.Errorf("failed to parse Godeps.json: %v", err)
+		}
+	}
+	reqs := parseGoModRequires(goMod)
+	sums := parseGoSum(goSum)
+
+	var comps []sbomComponent
+	for _, d := range deps {
+		c := sbomComponent{Name: d.Dependency, License: d.License}
+		var module *moduleRequire
+		for i, r := range reqs {
+			if (d.Dependency == r.Path || strings.HasPrefix(d.Dependency, r.Path+"/")) && (module == nil || len(r.Path) > len(module.Path)) {
+				module = &reqs[i]
+			}
+		}
+		if module != nil {
+			c.Version, c.Dirhash = module.Version, sums[module.Path+" "+module.Version]
+		} else {
+			for _, g := range godeps.Deps {
+				if g.ImportPath == d.Dependency || strings.HasPrefix(g.ImportPath, d.Dependency+"/") || strings.HasPrefix(d.Dependency, g.ImportPath+"/") {
+					c.Version = g.Rev
+					break
+				}
+			}
+		}
+		comps = append(comps, c)
+	}
+	return comps, nil
+}
+
+func readOptionalFile(pth string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(pth)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return bs, err
+}
+
+// parseGoSum returns the h1: dirhashes of the modules in the go.sum content,
+// keyed by "<module> <version>".
+func parseGoSum(goSum []byte) map[string]string {
+	sums := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(goSum))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") || !strings.HasPrefix(fields[2], "h1:") {
+			continue
+		}
+		if bs, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(fields[2], "h1:")); err != nil || len(bs) != sha256.Size {
+			continue
+		}
+		sums[fields[0]+" "+fields[1]] = fields[2]
+	}
+	return sums
+}
+
+// purl returns the package URL of the Go package.
+func purl(name, version string) string {
+	if version == "" {
+		return "pkg:golang/" + name
+	}
+	return "pkg:golang/" + name + "@" + version
+}
+
+// renderSBOM renders the document as SPDX 2.3 or CycloneDX 1.4 JSON.
+func renderSBOM(format string, doc sbomDocument) ([]byte, error) {
+	var v interface{}
+	if format == config.SBOMFormatCycloneDX {
+		v = cycloneDX(doc)
+	} else {
+		v = spdx(doc)
+	}
+	bs, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(bs, '\n'), nil
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func spdx(doc sbomDocument) interface{} {
+	root := spdxPackage{
+		Name:             doc.Name,
+		SPDXID:           "SPDXRef-Root",
+		VersionInfo:      doc.Version,
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+	}
+	packages := []spdxPackage{root}
+	relationships := []spdxRelationship{{"SPDXRef-DOCUMENT", "DESCRIBES", root.SPDXID}}
+	for i, c := range doc.Components {
+		p := spdxPackage{
+			Name:             c.Name,
+			SPDXID:           "SPDXRef-Package-" + strconv.Itoa(i+1),
+			VersionInfo:      c.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			ExternalRefs:     []spdxExternalRef{{"PACKAGE-MANAGER", "purl", purl(c.Name, c.Version)}},
+		}
+		if c.License != config.UnknownLicense {
+			p.LicenseConcluded = c.License
+		}
+		if c.Dirhash != "" {
+			// SPDX checksums have no algorithm for Go dirhashes
+			p.ExternalRefs = append(p.ExternalRefs, spdxExternalRef{"OTHER", "go-module-dirhash", c.Dirhash})
+		}
+		packages = append(packages, p)
+		relationships = append(relationships, spdxRelationship{root.SPDXID, "DEPENDS_ON", p.SPDXID})
+	}
+	return struct {
+		SPDXVersion       string `json:"spdxVersion"`
+		DataLicense       string `json:"dataLicense"`
+		SPDXID            string `json:"SPDXID"`
+		Name              string `json:"name"`
+		DocumentNamespace string `json:"documentNamespace"`
+		CreationInfo      struct {
+			Created  string   `json:"created"`
+			Creators []string `json:"creators"`
+		} `json:"creationInfo"`
+		Packages      []spdxPackage      `json:"packages"`
+		Relationships []spdxRelationship `json:"relationships"`
+	}{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              doc.Name + "@" + doc.Version,
+		DocumentNamespace: doc.Namespace,
+		CreationInfo: struct {
+			Created  string   `json:"created"`
+			Creators []string `json:"creators"`
+		}{doc.Created.UTC().Format(time.RFC3339), []string{"Tool: publishing-bot"}},
+		Packages:      packages,
+		Relationships: relationships,
+	}
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxLicense struct {
+	License struct {
+		ID string `json:"id"`
+	} `json:"license"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	Purl       string        `json:"purl,omitempty"`
+	Licenses   []cdxLicense  `json:"licenses,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+func cycloneDX(doc sbomDocument) interface{} {
+	components := []cdxComponent{}
+	for _, c := range doc.Components {
+		comp := cdxComponent{Type: "library", Name: c.Name, Version: c.Version, Purl: purl(c.Name, c.Version)}
+		if c.Dirhash != "" {
+			// CycloneDX hashes have no algorithm for Go dirhashes
+			comp.Properties = []cdxProperty{{"golang:dirhash", c.Dirhash}}
+		}
+		if c.License != config.UnknownLicense {
+			var l cdxLicense
+			l.License.ID = c.License
+			comp.Licenses = []cdxLicense{l}
+		}
+		components = append(components, comp)
+	}
+	type metadata struct {
+		Timestamp string `json:"timestamp"`
+		Tools     []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+		Component cdxComponent `json:"component"`
+	}
+	m := metadata{
+		Timestamp: doc.Created.UTC().Format(time.RFC3339),
+		Component: cdxComponent{Type: "library", Name: doc.Name, Version: doc.Version, Purl: purl(doc.Name, doc.Version)},
+	}
+	m.Tools = append(m.Tools, struct {
+		Name string `json:"name"`
+	}{"publishing-bot"})
+	return struct {
+		BOMFormat    string         `json:"bomFormat"`
+		SpecVersion  string         `json:"specVersion"`
+		SerialNumber string         `json:"serialNumber,omitempty"`
+		Version      int            `json:"version"`
+		Metadata     metadata       `json:"metadata"`
+		Components   []cdxComponent `json:"components"`
+	}{"CycloneDX", "1.4", "", 1, m, components}
+}
+
+// dependenciesTime returns the commit time of the last change of the
+// dependencies at the given revision, or of the revision itself. It keeps SBOMs
+// stable as long as the dependencies do not change.
+func (p *PublisherMunger) dependenciesTime(ctx context.Context, rev string) (time.Time, error) {
+	out, err := p.command(ctx, "git", "log", "-1", "--format=%ct", rev, "--", "go.mod", "go.sum", "Godeps", "vendor").Output()
+	if err == nil && len(bytes.TrimSpace(out)) == 0 {
+		out, err = p.command(ctx, "git", "show", "-s", "--format=%ct", rev).Output()
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// sbom returns the SBOM of the destination repo at the given revision, checked
+// out in dir.
+func (p *PublisherMunger) sbom(ctx context.Context, repoRule config.RepositoryRule, dir, rev, version string) ([]byte, error) {
+	comps, err := sbomComponents(dir, p.config.GoPath())
+	if err != nil {
+		return nil, err
+	}
+	created, err := p.dependenciesTime(ctx, rev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the commit time of %s: %v", rev, err)
+	}
+	doc := sbomDocument{
+		Name:       p.config.BasePackageOf(repoRule) + "/" + repoRule.DestinationRepository,
+		Version:    version,
+		Namespace:  fmt.Sprintf("https://%s/%s/%s/sbom/%s", p.config.GithubHost, p.config.TargetOrg, repoRule.DestinationRepository, version),
+		Created:    created,
+		Components: comps,
+	}
+	return renderSBOM(repoRule.SBOM.Format, doc)
+}
+
+// syncSBOM writes the SBOM into the checked out destination branch and commits
+// it if it changed.
+func (p *PublisherMunger) syncSBOM(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) error {
+	if !repoRule.SBOM.Commit {
+		return nil
+	}
+	content, err := p.sbom(ctx, repoRule, p.path("."), "HEAD", branchRule.Name)
+	if err != nil {
+		return err
+	}
+	file := repoRule.SBOM.FileName()
+	if old, err := ioutil.ReadFile(p.path(file)); err == nil && bytes.Equal(old, content) {
+		return nil
+	}
+	if err := ioutil.WriteFile(p.path(file), content, 0644); err != nil {
+		return err
+	}
+	if err := p.command(ctx, "git", "add", file).Run(); err != nil {
+		return fmt.Errorf("failed to add %s: %v", file, err)
+	}
+	p.plog.Infof("Updating %s of %s", file, branchRule.Name)
+	return p.plog.Run(p.gitCommit(ctx, "sync: update "+file))
+}
+
+// extractTree writes the tree of the revision of the repo in the publisher's
+// directory into dir, as exported by git archive.
+func (p *PublisherMunger) extractTree(ctx context.Context, rev, dir string) error {
+	cmd := p.command(ctx, "git", "archive", "--format=tar", rev)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err = extractTar(out, dir)
+	// drain the archive such that git does not block on a failed extraction
+	io.Copy(ioutil.Discard, out)
+	if werr := cmd.Wait(); werr != nil {
+		return fmt.Errorf("git archive %s failed: %v: %s", rev, werr, stderr.String())
+	}
+	return err
+}
+
+// attachSBOMs attaches the SBOMs of the new tags of the destination branch to
+// their GitHub releases.
+func (p *PublisherMunger) attachSBOMs(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) error {
+	if !repoRule.SBOM.Releases {
+		return nil
+	}
+	var tags []string
+	for _, b := range p.result.Branches {
+		if b.Repository == repoRule.DestinationRepository && b.Branch == branchRule.Name {
+			tags = b.Tags
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	tokenRef, err := p.config.PushTokenRef(repoRule.DestinationRepository)
+	if err != nil {
+		return err
+	}
+	token, err := loadToken(p.config, tokenRef)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		dir, err := ioutil.TempDir("", "sbom")
+		if err != nil {
+			return err
+		}
+		content, err := func() ([]byte, error) {
+			defer os.RemoveAll(dir)
+			if err := p.extractTree(ctx, "refs/tags/"+tag, dir); err != nil {
+				return nil, fmt.Errorf("failed to extract tag %s: %v", tag, err)
+			}
+			return p.sbom(ctx, repoRule, dir, "refs/tags/"+tag, tag)
+		}()
+		if err != nil {
+			return err
+		}
+		attached, err := AttachReleaseAsset(ctx, token, p.config.TargetOrg, repoRule.DestinationRepository, tag, repoRule.SBOM.FileName(), content)
+		if err != nil {
+			return err
+		}
+		if attached {
+			p.plog.Infof("Attached %s to release %s of %s", repoRule.SBOM.FileName(), tag, repoRule.DestinationRepository)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSBOMComponents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for pth, content := range map[string]string{
+		"go.mod": "module example.com/lib\n\nrequire github.com/mod/dep v1.2.0\n",
+		"go.sum": "github.com/mod/dep v1.2.0 h1:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n" +
+			"github.com/mod/dep v1.2.0/go.mod h1://////////////////////////////////////////8=\n",
+		"Godeps/Godeps.json":                     `{"Deps": [{"ImportPath": "github.com/godep/dep/pkg", "Rev": "0123456789abcdef"}]}`,
+		"vendor/github.com/mod/dep/sub/LICENSE":  "Permission is hereby granted, free of charge",
+		"vendor/github.com/godep/dep/LICENSE":    "Apache License\nVersion 2.0",
+		"vendor/github.com/godep/dep/pkg/foo.go": "package pkg",
+	} {
+		pth = filepath.Join(dir, filepath.FromSlash(pth))
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pth, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := sbomComponents(dir, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []sbomComponent{
+		{Name: "github.com/godep/dep", Version: "0123456789abcdef", License: "Apache-2.0"},
+		{Name: "github.com/mod/dep/sub", Version: "v1.2.0", Dirhash: "h1:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", License: "MIT"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRenderSBOM(t *testing.T) {
+	doc := sbomDocument{
+		Name:      "k8s.io/client-go",
+		Version:   "v0.30.0",
+		Namespace: "https://github.com/kubernetes/client-go/sbom/v0.30.0",
+		Created:   time.Unix(1700000000, 0),
+		Components: []sbomComponent{
+			{Name: "github.com/a/b", Version: "v1.0.0", Dirhash: "h1:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", License: "MIT"},
+			{Name: "github.com/c/d", License: "unknown"},
+		},
+	}
+	tests := []struct {
+		format string
+		want   map[string]interface{}
+	}{
+		{"spdx", map[string]interface{}{
+			"spdxVersion": "SPDX-2.3",
+			"name":        "k8s.io/client-go@v0.30.0",
+			"packages":    3,
+		}},
+		{"cyclonedx", map[string]interface{}{
+			"bomFormat":   "CycloneDX",
+			"specVersion": "1.4",
+			"components":  2,
+		}},
+	}
+	for _, tt := range tests {
+		bs, err := renderSBOM(tt.format, doc)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.format, err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(bs, &got); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", tt.format, err)
+		}
+		for k, want := range tt.want {
+			if n, ok := want.(int); ok {
+				if l, ok := got[k].([]interface{}); !ok || len(l) != n {
+					t.Errorf("%s: got %s %v, want %d entries", tt.format, k, got[k], n)
+				}
+			} else if got[k] != want {
+				t.Errorf("%s: got %s %v, want %v", tt.format, k, got[k], want)
+			}
+		}
+		if !strings.Contains(string(bs), `"h1:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="`) || strings.Contains(string(bs), "SHA") {
+			t.Errorf("%s: expected the dirhash without a SHA-256 label:\n%s", tt.format, bs)
+		}
+		again, _ := renderSBOM(tt.format, doc)
+		if string(again) != string(bs) {
+			t.Errorf("%s: rendering is not stable", tt.format)
+		}
+	}
+}
+
+func TestExtractTree(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	if err := os.MkdirAll(filepath.Join(dir, "hack"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/lib\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "hack", "run.sh"), []byte("#!/bin/bash\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("go.mod", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "files")
+
+	out, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	if err := (&PublisherMunger{}).extractTree(context.Background(), "HEAD", out); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := ioutil.ReadFile(filepath.Join(out, "go.mod")); err != nil || string(bs) != "module example.com/lib\n" {
+		t.Errorf("got go.mod %q, %v", bs, err)
+	}
+	if fi, err := os.Stat(filepath.Join(out, "hack", "run.sh")); err != nil || fi.Mode().Perm()&0100 == 0 {
+		t.Errorf("expected hack/run.sh to be executable: %v, %v", fi, err)
+	}
+	if target, err := os.Readlink(filepath.Join(out, "link")); err != nil || target != "go.mod" {
+		t.Errorf("got link to %q, %v", target, err)
+	}
+
+	if err := (&PublisherMunger{}).extractTree(context.Background(), "missing", out); err == nil {
+		t.Errorf("expected an error for a missing revision")
+	}
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// utilScript is the absolute path of util.sh, resolved before tests change
+// into temporary repositories.
+var utilScript, _ = filepath.Abs("../../artifacts/scripts/util.sh")
+
+// runUtil runs the script with the functions of util.sh in the current
+// directory, with the environment variables added.
+func runUtil(script string, env ...string) (string, error) {
+	cmd := exec.Command("/bin/bash", "-c", "source "+utilScript+"; set +o xtrace; "+script)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// utilOutput is like runUtil, but returns the trimmed stdout only. Stderr is
+// part of the error.
+func utilOutput(script string, env ...string) (string, error) {
+	cmd := exec.Command("/bin/bash", "-c", "source "+utilScript+"; set +o xtrace; "+script)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// constructScripts builds the binaries called by construct.sh and returns the
+// path of a copy of construct.sh and util.sh calling them in dir.
+func constructScripts(t *testing.T, dir string) string {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	scripts := filepath.Join(dir, "scripts")
+	if err := os.Mkdir(scripts, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"construct.sh", "util.sh"} {
+		bs, err := ioutil.ReadFile(filepath.Join(filepath.Dir(utilScript), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := string(bs)
+		for _, bin := range []string{"sync-tags", "collapsed-kube-commit-mapper"} {
+			s = strings.Replace(s, "/"+bin+" ", filepath.Join(dir, bin)+" ", -1)
+		}
+		if err := ioutil.WriteFile(filepath.Join(scripts, name), []byte(s), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, bin := range []string{"sync-tags", "collapsed-kube-commit-mapper"} {
+		if out, err := exec.Command("go", "build", "-o", filepath.Join(dir, bin), "k8s.io/publishing-bot/cmd/"+bin).CombinedOutput(); err != nil {
+			t.Fatalf("failed to build %s: %v: %s", bin, err, out)
+		}
+	}
+	return filepath.Join(scripts, "construct.sh")
+}
+
+func TestConstructTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "construct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	construct := constructScripts(t, dir)
+
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	src := filepath.Join(dir, "kubernetes")
+	if err := os.MkdirAll(filepath.Join(src, "staging/src/k8s.io/foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(src, "init", "-q")
+	git(src, "config", "user.name", "Test")
+	git(src, "config", "user.email", "test@example.com")
+	git(src, "checkout", "-q", "-b", "master")
+	for i, content := range []string{"package foo\n", "package foo // changed\n"} {
+		if err := ioutil.WriteFile(filepath.Join(src, "staging/src/k8s.io/foo/foo.go"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git(src, "add", "-A")
+		git(src, "commit", "-q", "-m", "change foo")
+		if i == 0 {
+			git(src, "tag", "-a", "-m", "v1.0.0", "v1.0.0")
+		}
+	}
+
+	for _, skipTags := range []string{"", "true"} {
+		func() {
+			dst := filepath.Join(dir, "foo")
+			defer os.RemoveAll(dst)
+			defer os.RemoveAll(dst + ".git")
+			git(dir, "init", "-q", "--bare", "foo.git")
+			git(dir, "clone", "-q", "foo.git", "foo")
+			git(dst, "config", "user.name", "Test")
+			git(dst, "config", "user.email", "test@example.com")
+			git(dst, "checkout", "-q", "-b", "master")
+			git(dst, "commit", "-q", "--allow-empty", "-m", "Initial commit")
+			git(dst, "push", "-q", "origin", "master")
+
+			cmd := exec.Command("/bin/bash", construct, "foo", "master", "master", "", "", src, "staging/src/k8s.io/foo",
+				"kubernetes", "kubernetes", "k8s.io", "true", "", skipTags)
+			cmd.Dir = dst
+			// newer git versions sleep in filter-branch to warn about it
+			cmd.Env = append(os.Environ(), "SOURCE_MAINLINE_BRANCH=master", "MAINLINE_BRANCH=master", "FILTER_BRANCH_SQUELCH_WARNING=1")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("skip_tags=%q: construct.sh failed: %v: %s", skipTags, err, out)
+			}
+			script, err := ioutil.ReadFile(filepath.Join(dir, "push-tags-foo-master.sh"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pushed := strings.Contains(string(script), "refs/tags/kubernetes-1.0.0"); pushed != (skipTags == "") {
+				t.Errorf("skip_tags=%q: expected the tag pushed=%v, got script %q", skipTags, skipTags == "", script)
+			}
+		}()
+	}
+}
+
+func TestFilterCacheKey(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	commitFile(t, git, "a", "1", "base")
+
+	tool := filepath.Join(dir, "rewrite-imports")
+	writeTool := func(content string) {
+		if err := ioutil.WriteFile(tool, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := func(env ...string) string {
+		env = append([]string{"INDEX_FILTER=" + tool + " --prefix 'staging/src/k8s.io/foo'"}, env...)
+		out, err := utilOutput(`filter-cache-key "${INDEX_FILTER}" "awk 1" staging/src/k8s.io/foo master`, env...)
+		if err != nil {
+			t.Fatalf("filter-cache-key failed: %v", err)
+		}
+		return out
+	}
+
+	writeTool("v1")
+	base := key("PUBLISHER_BOT_IMPORT_REWRITES=k8s.io/api=example.com/api")
+	if got := key("PUBLISHER_BOT_IMPORT_REWRITES=k8s.io/api=example.com/api"); got != base {
+		t.Errorf("expected the same key %s for the same filter, got %s", base, got)
+	}
+	keys := map[string]string{base: "base"}
+	for _, tt := range []struct {
+		name string
+		env  []string
+	}{
+		{"other rewrites", []string{"PUBLISHER_BOT_IMPORT_REWRITES=k8s.io/api=example.com/other"}},
+		{"format", []string{"PUBLISHER_BOT_IMPORT_REWRITES=k8s.io/api=example.com/api", "PUBLISHER_BOT_IMPORT_REWRITE_FORMAT=gofmt"}},
+		{"filters", []string{"PUBLISHER_BOT_IMPORT_REWRITES=k8s.io/api=example.com/api", `PUBLISHER_BOT_FILTERS=[{"type":"exec","command":"true"}]`}},
+		{"symlinks", []string{"PUBLISHER_BOT_IMPORT_REWRITES=k8s.io/api=example.com/api", "PUBLISHER_BOT_SYMLINKS=drop"}},
+	} {
+		got := key(tt.env...)
+		if other, found := keys[got]; found {
+			t.Errorf("%s: expected a new key, got the key of %s", tt.name, other)
+		}
+		keys[got] = tt.name
+	}
+
+	writeTool("v2")
+	if got := key("PUBLISHER_BOT_IMPORT_REWRITES=k8s.io/api=example.com/api"); got == base {
+		t.Errorf("expected a new key for a new version of the filter binary, got %s", got)
+	}
+
+	commitFile(t, git, "a", "2", "change")
+	writeTool("v1")
+	if got := key("PUBLISHER_BOT_IMPORT_REWRITES=k8s.io/api=example.com/api"); got == base {
+		t.Errorf("expected a new key for new source commits, got %s", got)
+	}
+}
+
+func TestCommitTrailer(t *testing.T) {
+	_, git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"change\n\nPublishing-bot-skip: client-go, api", "client-go, api"},
+		{"change\n\nbody\n\nSigned-off-by: Test <test@example.com>\nPublishing-bot-skip: api\n", "api"},
+		{"change\n\nPublishing-bot-skip: api\n\nmore body", ""},
+		{"change\n\nquoting the trailer\nPublishing-bot-skip: api\nin the body\n\nSigned-off-by: Test <test@example.com>", ""},
+		{"Publishing-bot-skip: api", ""},
+	}
+	for _, tt := range tests {
+		git("commit", "-q", "--allow-empty", "-m", tt.msg)
+		got, err := utilOutput("commit-trailer HEAD Publishing-bot-skip")
+		if err != nil {
+			t.Fatalf("commit-trailer failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.msg, tt.want, got)
+		}
+	}
+}
+
+func TestApplyEmptyCommitPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		setup    func(git func(args ...string) string)
+		want     []string
+		wantBody string
+	}{
+		{"keep", "keep", func(git func(args ...string) string) {
+			git("commit", "-q", "--allow-empty", "-m", "empty")
+		}, []string{"empty", "base"}, ""},
+		{"drop", "drop", func(git func(args ...string) string) {
+			git("commit", "-q", "--allow-empty", "-m", "empty")
+		}, []string{"base"}, ""},
+		{"drop non-empty", "drop", func(git func(args ...string) string) {
+			commitFile(t, git, "a", "2", "change")
+		}, []string{"change", "base"}, ""},
+		{"drop merge", "drop", func(git func(args ...string) string) {
+			git("checkout", "-q", "-b", "side")
+			commitFile(t, git, "b", "1", "side")
+			git("checkout", "-q", "master")
+			git("merge", "-q", "-s", "ours", "--no-edit", "-m", "merge", "side")
+		}, []string{"merge", "base"}, ""},
+		{"keep-with-marker", "keep-with-marker", func(git func(args ...string) string) {
+			git("commit", "-q", "--allow-empty", "-m", "empty")
+		}, []string{"empty", "base"}, "empty\n\nEmpty-after-filtering: true"},
+	}
+	for _, tt := range tests {
+		func() {
+			_, git, cleanup := gitRepo(t)
+			defer cleanup()
+
+			commitFile(t, git, "a", "1", "base")
+			tt.setup(git)
+			if out, err := runUtil("apply-empty-commit-policy", "PUBLISHER_BOT_EMPTY_COMMITS="+tt.policy); err != nil {
+				t.Fatalf("%s: apply-empty-commit-policy failed: %v: %s", tt.name, err, out)
+			}
+			if got := strings.Split(git("log", "--first-parent", "--format=%s"), "\n"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: got commits %q, want %q", tt.name, got, tt.want)
+			}
+			if tt.wantBody != "" {
+				if got := git("log", "-1", "--format=%B"); got != tt.wantBody {
+					t.Errorf("%s: got message %q, want %q", tt.name, got, tt.wantBody)
+				}
+			}
+			if status := git("status", "--porcelain"); status != "" {
+				t.Errorf("%s: unexpected changes in the working dir: %s", tt.name, status)
+			}
+		}()
+	}
+}
+
+func TestApplyEmptyCommitPolicyRoot(t *testing.T) {
+	_, git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	git("commit", "-q", "--allow-empty", "-m", "root")
+	if out, err := runUtil("apply-empty-commit-policy", "PUBLISHER_BOT_EMPTY_COMMITS=drop"); err != nil {
+		t.Fatalf("apply-empty-commit-policy failed: %v: %s", err, out)
+	}
+	if got := git("log", "--format=%s"); got != "root" {
+		t.Errorf("got commits %q, want the root commit", got)
+	}
+}
+
+func TestSignoffNewCommits(t *testing.T) {
+	_, git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	const signoff = "Bot <bot@example.com>"
+	commitFile(t, git, "a", "1", "published")
+	git("update-ref", "refs/remotes/origin/master", "HEAD")
+	messages := []string{
+		"subject only",
+		"pkg: subject with colon",
+		"change\n\nbody",
+		"change\n\nSigned-off-by: Author <author@example.com>",
+		"change\n\nSigned-off-by: " + signoff,
+		"change\n\nSigned-off-by: " + signoff + "\nSigned-off-by: Author <author@example.com>",
+	}
+	for _, msg := range messages {
+		git("commit", "-q", "--allow-empty", "-m", msg)
+	}
+	if out, err := runUtil("signoff-new-commits master", "PUBLISHER_BOT_SIGNOFF="+signoff, "FILTER_BRANCH_SQUELCH_WARNING=1"); err != nil {
+		t.Fatalf("signoff-new-commits failed: %v: %s", err, out)
+	}
+
+	want := []string{
+		"change\n\nSigned-off-by: " + signoff + "\nSigned-off-by: Author <author@example.com>",
+		"change\n\nSigned-off-by: " + signoff,
+		"change\n\nSigned-off-by: Author <author@example.com>\nSigned-off-by: " + signoff,
+		"change\n\nbody\n\nSigned-off-by: " + signoff,
+		"pkg: subject with colon\n\nSigned-off-by: " + signoff,
+		"subject only\n\nSigned-off-by: " + signoff,
+		"published",
+	}
+	got := strings.Split(git("log", "--format=%B%x00"), "\x00")
+	for i := range got {
+		got[i] = strings.TrimSpace(got[i])
+	}
+	if got = got[:len(got)-1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"k8s.io/publishing-bot/pkg/secretscan"
+)
+
+// secretAlert is posted to the secret scan webhook.
+type secretAlert struct {
+	Organization string   `json:"organization"`
+	Repository   string   `json:"repository"`
+	Branch       string   `json:"branch"`
+	Findings     []string `json:"findings"`
+}
+
+// scanSecrets returns the likely secrets in the commits of the branch in the
+// publisher's directory which are not on origin yet. The findings name the commit,
+// file and line, but not the secret.
+func (p *PublisherMunger) scanSecrets(ctx context.Context, repo, branch string) ([]string, error) {
+	revs := p.unpublishedRevs(ctx, branch)
+	diff, err := p.command(ctx, "git", append([]string{"log", "-p", "--no-color", "--no-ext-diff", "--format=commit %H"}, revs...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the diff of branch %s: %v", branch, err)
+	}
+
+	cfg := p.config.SecretScan
+	scanner, err := secretscan.New(cfg.Patterns, cfg.EntropyThreshold, cfg.AllowPaths)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := scanner.ScanDiff(bytes.NewReader(diff))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan branch %s: %v", branch, err)
+	}
+	var findings []string
+	for _, f := range fs {
+		findings = append(findings, f.String())
+	}
+
+	if cfg.Command != "" {
+		cmd := p.command(ctx, "/bin/bash", "-ec", cfg.Command)
+		cmd.Stdin = bytes.NewReader(diff)
+		cmd.Env = append(cmd.Env,
+			"PUBLISHER_BOT_DESTINATION_ORG="+p.config.TargetOrg,
+			"PUBLISHER_BOT_DESTINATION_REPO="+repo,
+			"PUBLISHER_BOT_DESTINATION_BRANCH="+branch,
+		)
+		out, err := cmd.CombinedOutput()
+		if _, ok := err.(*exec.ExitError); ok {
+			findings = append(findings, "secret scan command: "+strings.TrimSpace(string(out)))
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to run secret scan command: %v", err)
+		}
+	}
+	return findings, nil
+}
+
+// unpublishedRevs returns the git log arguments selecting the commits of the
+// branch which are not on origin yet. A new branch is compared against all
+// branches of origin, not against nothing.
+func (p *PublisherMunger) unpublishedRevs(ctx context.Context, branch string) []string {
+	if p.command(ctx, "git", "rev-parse", "-q", "--verify", "origin/"+branch).Run() == nil {
+		return []string{branch, "^origin/" + branch}
+	}
+	return []string{branch, "--not", "--remotes=origin"}
+}
+
+// alertSecrets posts the findings to the secret scan webhook, if configured.
+func (p *PublisherMunger) alertSecrets(ctx context.Context, repo, branch string, findings []string) {
+	if p.config.SecretScan.Webhook == "" {
+		return
+	}
+	a := secretAlert{Organization: p.config.TargetOrg, Repository: repo, Branch: branch, Findings: findings}
+	if err := postJSON(ctx, p.config.SecretScan.Webhook, a); err != nil {
+		p.plog.Errorf("Failed to post secret scan alert: %v", err)
+	}
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -35,6 +37,29 @@ type Server struct {
 	mutex    sync.RWMutex
 	response HealthResponse
 	config   config.Config
+	server   *http.Server
+	// latency is exposed at /metrics if set
+	latency *latencyTracker
+	// retries of all runs are exposed at /metrics
+	retries retryCounter
+	// failures of all runs are exposed at /metrics
+	failures failureCounter
+	// result of the last run, exposed at /status
+	result *RunResult
+	// pauses are exposed at /status if set
+	pauses *PauseState
+	// control serves the control API if set
+	control *controlAPI
+	// pprof serves the runtime profiles at /debug/pprof/ if true
+	pprof bool
+	// tenants are served below /tenants/<name>/ in multi-tenant mode
+	tenants []*tenant
+}
+
+// newTenantsServer returns a server for the given tenants. /healthz and
+// /metrics aggregate the tenants and /run triggers all of them.
+func newTenantsServer(tenants []*tenant, pprof bool) *Server {
+	return &Server{tenants: tenants, pprof: pprof}
 }
 
 type HealthResponse struct {
@@ -66,41 +91,122 @@ func (h *Server) SetHealth(healthy bool, hash string) {
 	}
 }
 
+// SetResult records the result of the last run for /status.
+func (h *Server) SetResult(r RunResult) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.result = &r
+	h.retries.add(r.Retries)
+	h.failures.add(r.Failure)
+}
+
 func (h *Server) Run(port int) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", h.healthzHandler)
-	mux.HandleFunc("/run", h.runHandler)
+	mux := h.mux()
+	if h.pprof {
+		registerPprof(mux)
+	}
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
 	glog.Infof("Listening on %v", addr)
+	h.server = &http.Server{Addr: addr, Handler: mux}
 	go func() {
-		err := http.ListenAndServe(addr, mux)
+		err := h.server.ListenAndServe()
+		if err == http.ErrServerClosed {
+			return
+		}
 		glog.Fatalf("Failed ListenAndServer: %v", err)
 	}()
 	return nil
 }
 
-func (h *Server) runHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Server) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	if h.tenants != nil {
+		mux.HandleFunc("/healthz", h.tenantsHealthzHandler)
+		mux.HandleFunc("/run", h.tenantsRunHandler)
+		mux.HandleFunc("/metrics", h.tenantsMetricsHandler)
+		for _, t := range h.tenants {
+			prefix := "/tenants/" + t.name
+			mux.Handle(prefix+"/", http.StripPrefix(prefix, t.server.mux()))
+		}
+		return mux
+	}
+	mux.HandleFunc("/healthz", h.healthzHandler)
+	mux.HandleFunc("/run", h.runHandler)
+	mux.HandleFunc("/metrics", h.metricsHandler)
+	mux.HandleFunc("/status", h.statusHandler)
+	if h.control != nil {
+		h.control.register(mux)
+	}
+	return mux
+}
+
+// Shutdown stops the server gracefully, if it was started.
+func (h *Server) Shutdown(ctx context.Context) error {
+	if h.server == nil {
+		return nil
+	}
+	return h.server.Shutdown(ctx)
+}
+
+// trigger starts a run unless one is pending. It returns false if the run
+// channel is closed.
+func (h *Server) trigger() bool {
 	if h.RunChan == nil {
-		http.Error(w, "run channel is closed", http.StatusInternalServerError)
-		return
+		return false
 	}
 	select {
 	case h.RunChan <- true:
 	default:
 	}
+	return true
+}
+
+func (h *Server) runHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.trigger() {
+		http.Error(w, "run channel is closed", http.StatusInternalServerError)
+		return
+	}
 	w.Write([]byte("OK"))
 }
 
-func (h *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Server) health() HealthResponse {
 	h.mutex.RLock()
+	defer h.mutex.RUnlock()
 	resp := h.response
 	if h.Issue != 0 {
 		// We chose target org so the issue can be opened in different org than
 		// a source repository.
 		resp.Issue = fmt.Sprintf("https://%s/%s/%s/issues/%d", h.config.GithubHost, h.config.TargetOrg, h.config.SourceRepo, h.Issue)
 	}
-	h.mutex.RUnlock()
+	return resp
+}
 
+func (h *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	bytes, err := json.MarshalIndent(h.health(), "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
+
+func (h *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if h.latency != nil {
+		h.latency.WriteMetrics(&buf, time.Now())
+	}
+	h.retries.WriteMetrics(&buf)
+	h.failures.WriteMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// tenantsHealthzHandler returns the health of every tenant, keyed by name.
+func (h *Server) tenantsHealthzHandler(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]HealthResponse{}
+	for _, t := range h.tenants {
+		resp[t.name] = t.server.health()
+	}
 	bytes, err := json.MarshalIndent(resp, "", "\t")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -108,3 +214,55 @@ func (h *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write(bytes)
 }
+
+func (h *Server) tenantsRunHandler(w http.ResponseWriter, r *http.Request) {
+	for _, t := range h.tenants {
+		t.server.trigger()
+	}
+	w.Write([]byte("OK"))
+}
+
+// tenantsMetricsHandler returns the metrics of all tenants with a tenant label.
+func (h *Server) tenantsMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics := map[string]string{}
+	var names []string
+	for _, t := range h.tenants {
+		var buf bytes.Buffer
+		if t.latency != nil {
+			t.latency.WriteMetrics(&buf, time.Now())
+		}
+		t.server.retries.WriteMetrics(&buf)
+		t.server.failures.WriteMetrics(&buf)
+		metrics[t.name] = buf.String()
+		names = append(names, t.name)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(mergeMetrics(metrics, names)))
+}
+
+// statusHandler returns the result of the last run, including why branches were
+// skipped.
+func (h *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	h.mutex.RLock()
+	result := h.result
+	h.mutex.RUnlock()
+	if result == nil {
+		http.Error(w, "no run finished yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	var paused map[string]PausedRepo
+	if h.pauses != nil {
+		paused = h.pauses.List()
+	}
+	bytes, err := json.MarshalIndent(struct {
+		Outcome string                `json:"outcome"`
+		Paused  map[string]PausedRepo `json:"paused,omitempty"`
+		*RunResult
+	}{result.Outcome(), paused, result}, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
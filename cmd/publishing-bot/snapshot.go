@@ -0,0 +1,374 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+// snapshotFile is a file of a workspace snapshot which is not on disk.
+type snapshotFile struct {
+	name    string
+	content []byte
+}
+
+// errSnapshotTooLarge is returned if the files of a snapshot exceed its limit.
+type errSnapshotTooLarge struct {
+	limit config.ByteSize
+}
+
+func (e errSnapshotTooLarge) Error() string {
+	return fmt.Sprintf("snapshot larger than %v", e.limit)
+}
+
+// snapshot stores a snapshot of the workspace of the failed run in the
+// snapshot location, if configured, and records its name in the run result.
+// Failing to take it is only logged.
+func (p *PublisherMunger) snapshot() {
+	if !p.config.Snapshots.Enabled() {
+		return
+	}
+	s, err := state.Parse(p.config.Snapshots.Location, "")
+	if err != nil {
+		p.plog.Errorf("Failed to take a workspace snapshot: %v", err)
+		return
+	}
+	store, ok := s.(state.FileStore)
+	if !ok {
+		p.plog.Errorf("Failed to take a workspace snapshot: cannot store snapshots in %s", s)
+		return
+	}
+
+	repo := ""
+	if f := p.result.Failure; f != nil {
+		repo = f.Repository
+	}
+	var dirs []string
+	for _, r := range p.reposRules.Rules {
+		if r.DestinationRepository == repo {
+			dirs = append(dirs, p.dstDir(r))
+		}
+	}
+	if repo == "" {
+		repo = p.config.SourceRepo
+	}
+	name := fmt.Sprintf("snapshot-%s-%s-%s.tar.gz", p.config.TargetOrg, repo, p.result.Start.UTC().Format("20060102-150405"))
+
+	failure, err := json.MarshalIndent(struct {
+		Error   string      `json:"error"`
+		Failure *RunFailure `json:"failure,omitempty"`
+	}{p.result.Error, p.result.Failure}, "", "  ")
+	if err != nil {
+		p.plog.Errorf("Failed to take a workspace snapshot: %v", err)
+		return
+	}
+	files := []snapshotFile{{"failure.json", failure}}
+	if c := p.result.ConflictReport; c != nil {
+		files = append(files, snapshotFile{"conflict-report.md", []byte(c.Markdown())})
+	}
+	// the logs last, such that they include the errors above
+	logs := func() snapshotFile { return snapshotFile{"logs.txt", []byte(p.plog.Logs())} }
+
+	// the snapshot might be large, hence it is streamed through a temporary
+	// file instead of being held in memory
+	f, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		p.plog.Errorf("Failed to take a workspace snapshot: %v", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	limit := p.config.Snapshots.Limit()
+	err = writeSnapshot(f, dirs, append(files, logs()), limit)
+	if _, tooLarge := err.(errSnapshotTooLarge); tooLarge {
+		p.plog.Errorf("Leaving %s out of the workspace snapshot: %v", strings.Join(dirs, ", "), err)
+		if err = f.Truncate(0); err == nil {
+			if _, err = f.Seek(0, io.SeekStart); err == nil {
+				err = writeSnapshot(f, nil, append(files, logs()), limit)
+			}
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = store.WriteFile(name, f.Name())
+	}
+	if err != nil {
+		p.plog.Errorf("Failed to take a workspace snapshot: %v", err)
+		return
+	}
+	p.result.Snapshot = strings.TrimSuffix(store.String(), "/") + "/" + name
+	p.plog.Infof("Stored a workspace snapshot at %s", p.result.Snapshot)
+
+	if err := pruneSnapshots(store, p.config.Snapshots.KeepCount()); err != nil {
+		p.plog.Errorf("Failed to delete old workspace snapshots: %v", err)
+	}
+}
+
+// pruneSnapshots deletes all but the newest keep snapshots of the store,
+// ordered by the time at the end of their names.
+func pruneSnapshots(store state.FileStore, keep int) error {
+	names, err := store.List("snapshot-")
+	if err != nil {
+		return err
+	}
+	var snapshots []string
+	for _, name := range names {
+		if snapshotTime(name) != "" {
+			snapshots = append(snapshots, name)
+		}
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshotTime(snapshots[i]) < snapshotTime(snapshots[j])
+	})
+	for len(snapshots) > keep {
+		if err := store.Delete(snapshots[0]); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+// snapshotTime returns the sortable time of the snapshot name, or "" if the
+// name is not the one of a snapshot.
+func snapshotTime(name string) string {
+	const layout = "20060102-150405"
+	s := strings.TrimSuffix(name, ".tar.gz")
+	if s == name || len(s) < len(layout) {
+		return ""
+	}
+	t := s[len(s)-len(layout):]
+	if _, err := time.Parse(layout, t); err != nil {
+		return ""
+	}
+	return t
+}
+
+// writeSnapshot writes a .tar.gz of the directories, below their base names,
+// and of the files. It fails if the files in it exceed the limit.
+func writeSnapshot(w io.Writer, dirs []string, files []snapshotFile, limit config.ByteSize) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	size := int64(0)
+	add := func(n int64) error {
+		if size += n; size > int64(limit) {
+			return errSnapshotTooLarge{limit}
+		}
+		return nil
+	}
+
+	for _, dir := range dirs {
+		base := filepath.Dir(dir)
+		err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(base, pth)
+			if err != nil {
+				return err
+			}
+			link := ""
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				if link, err = os.Readlink(pth); err != nil {
+					return err
+				}
+			case info.IsDir():
+			case info.Mode().IsRegular():
+				if err := add(info.Size()); err != nil {
+					return err
+				}
+			default:
+				// sockets, pipes and devices
+				return nil
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(rel)
+			if info.IsDir() {
+				hdr.Name += "/"
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(pth)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.CopyN(tw, f, info.Size())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := add(int64(len(f.content))); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractSnapshot extracts a snapshot into the directory.
+func extractSnapshot(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return extractTar(gz, dir)
+}
+
+// extractTar extracts the directories, regular files and symlinks of the tar
+// stream into the directory, keeping the file modes. Symlinks are created
+// last, such that no file is written through them.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	var links []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		pth := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(pth, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(pth, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode)&os.ModePerm)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			hdr.Name = name
+			links = append(links, hdr)
+		}
+	}
+	for _, hdr := range links {
+		pth := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, pth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreSnapshotCommand is the restore-snapshot subcommand. It extracts a
+// workspace snapshot from the snapshot location of the config into a
+// directory and returns the exit code.
+func restoreSnapshotCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("restore-snapshot", flag.ExitOnError)
+	configFile := fs.String("config", "", "the config file with the snapshot location")
+	location := fs.String("location", "", "the snapshot location, overriding the one of -config")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s restore-snapshot [-config <config-yaml-file>] [-location <location>] <snapshot> <dir>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 || (*configFile == "" && *location == "") {
+		fs.Usage()
+		return 2
+	}
+	if *location == "" {
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if *location = cfg.Snapshots.Location; *location == "" {
+			fmt.Fprintf(os.Stderr, "No snapshot location in %s\n", *configFile)
+			return 2
+		}
+	}
+	store, err := state.Parse(*location, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	name, dir := path.Base(fs.Arg(0)), fs.Arg(1)
+	content, err := store.Read(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read snapshot %s from %s: %v\n", name, store, err)
+		return 1
+	}
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		fmt.Fprintf(os.Stderr, "%s is not empty\n", dir)
+		return 2
+	}
+	if err := extractSnapshot(bytes.NewReader(content), dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to extract snapshot %s: %v\n", name, err)
+		return 1
+	}
+	fmt.Fprintf(out, "Restored %s into %s.\n", name, dir)
+	return 0
+}
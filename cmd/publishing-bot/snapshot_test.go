@@ -0,0 +1,140 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "snapshot-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "snapshot-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	repo := filepath.Join(src, "client-go")
+	if err := os.MkdirAll(filepath.Join(repo, ".git", "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, ".git", "logs", "HEAD"), []byte("reflog"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../HEAD", filepath.Join(repo, ".git", "logs", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := writeSnapshot(buf, []string{repo}, []snapshotFile{{"logs.txt", []byte("failed")}}, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractSnapshot(bytes.NewReader(buf.Bytes()), dst); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"client-go/.git/logs/HEAD": "reflog",
+		"logs.txt":                 "failed",
+	} {
+		bs, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("failed to read %s: %v", name, err)
+		} else if string(bs) != expected {
+			t.Errorf("expected %s to contain %q, got %q", name, expected, bs)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "client-go", ".git", "logs", "link")); err != nil || link != "../HEAD" {
+		t.Errorf("expected symlink to ../HEAD, got %q, %v", link, err)
+	}
+
+	err = writeSnapshot(ioutil.Discard, []string{repo}, nil, 5)
+	if _, tooLarge := err.(errSnapshotTooLarge); !tooLarge {
+		t.Errorf("expected the snapshot to be too large, got %v", err)
+	}
+}
+
+func TestExtractSnapshotOutsideDir(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "../escaped", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+	gz.Close()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := extractSnapshot(buf, filepath.Join(dir, "dst")); err == nil {
+		t.Errorf("expected an error for a path outside of the directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); err == nil {
+		t.Errorf("expected no file outside of the directory")
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := state.Dir(dir)
+	for _, name := range []string{
+		"snapshot-kubernetes-api-20180102-150405.tar.gz",
+		"snapshot-kubernetes-apimachinery-20180101-150405.tar.gz",
+		"snapshot-other-org-client-go-20180103-150405.tar.gz",
+		"snapshot-notes.txt",
+		"state.json",
+	} {
+		if err := store.Write(name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneSnapshots(store, 2); err != nil {
+		t.Fatal(err)
+	}
+	names, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"snapshot-kubernetes-api-20180102-150405.tar.gz",
+		"snapshot-notes.txt",
+		"snapshot-other-org-client-go-20180103-150405.tar.gz",
+		"state.json",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
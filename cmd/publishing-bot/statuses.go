@@ -0,0 +1,66 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// ReportCommitStatuses sets a commit status per destination repo on the source
+// commits published in the run, e.g. "publishing-bot/client-go", such that it is
+// visible on the source repo whether a commit has propagated. Branches which
+// failed to be pushed get a failure status.
+func ReportCommitStatuses(ctx context.Context, cfg *config.Config, token string, result RunResult) error {
+	return reportCommitStatuses(ctx, githubClient(ctx, token), cfg, result)
+}
+
+func reportCommitStatuses(ctx context.Context, client *github.Client, cfg *config.Config, result RunResult) error {
+	var errs []error
+	for _, b := range result.Branches {
+		if b.SourceCommit == "" || b.Commits == 0 || b.Skipped != "" {
+			continue
+		}
+		state, desc := "success", fmt.Sprintf("Published to %s/%s branch %s", cfg.TargetOrg, b.Repository, b.Branch)
+		if !b.Pushed {
+			if result.Error == "" {
+				continue // dry-run
+			}
+			state, desc = "failure", fmt.Sprintf("Publishing to %s/%s branch %s failed", cfg.TargetOrg, b.Repository, b.Branch)
+		}
+		_, resp, err := client.Repositories.CreateStatus(ctx, cfg.SourceOrg, cfg.SourceRepo, b.SourceCommit, &github.RepoStatus{
+			State:       github.String(state),
+			Description: github.String(desc),
+			Context:     github.String("publishing-bot/" + b.Repository),
+			TargetURL:   github.String(fmt.Sprintf("https://%s/%s/%s/tree/%s", cfg.GithubHost, cfg.TargetOrg, b.Repository, b.Branch)),
+		})
+		if err == nil && resp.StatusCode >= 300 {
+			err = fmt.Errorf("HTTP code %d", resp.StatusCode)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to set status of %s for %s: %v", b.SourceCommit, b.Repository, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
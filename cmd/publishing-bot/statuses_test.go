@@ -0,0 +1,104 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestReportCommitStatuses(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !strings.HasPrefix(r.URL.Path, "/repos/kubernetes/kubernetes/statuses/") {
+			http.NotFound(w, r)
+			return
+		}
+		var s github.RepoStatus
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			t.Error(err)
+			return
+		}
+		sha := strings.TrimPrefix(r.URL.Path, "/repos/kubernetes/kubernetes/statuses/")
+		mu.Lock()
+		got = append(got, sha+" "+s.GetContext()+" "+s.GetState()+" "+s.GetTargetURL())
+		mu.Unlock()
+		if sha == "broken" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	cfg := &config.Config{SourceOrg: "kubernetes", SourceRepo: "kubernetes", TargetOrg: "k8s-publishing-bot", GithubHost: "github.com"}
+	tests := []struct {
+		name    string
+		result  RunResult
+		want    []string
+		wantErr bool
+	}{
+		{"published", RunResult{Branches: []BranchResult{
+			{Repository: "client-go", Branch: "master", SourceCommit: "aaa", Commits: 2, Pushed: true},
+			{Repository: "api", Branch: "master", SourceCommit: "aaa", Commits: 0, Pushed: true},
+			{Repository: "apimachinery", Branch: "master", SourceCommit: "aaa", Commits: 1, Skipped: "paused"},
+			{Repository: "apiserver", Branch: "master", Commits: 1, Pushed: true},
+		}}, []string{
+			"aaa publishing-bot/client-go success https://github.com/k8s-publishing-bot/client-go/tree/master",
+		}, false},
+		{"dry-run", RunResult{Branches: []BranchResult{
+			{Repository: "client-go", Branch: "master", SourceCommit: "aaa", Commits: 2},
+		}}, nil, false},
+		{"failed", RunResult{Error: "failed to push", Branches: []BranchResult{
+			{Repository: "client-go", Branch: "master", SourceCommit: "aaa", Commits: 2, Pushed: true},
+			{Repository: "api", Branch: "release-1.10", SourceCommit: "bbb", Commits: 1},
+		}}, []string{
+			"aaa publishing-bot/client-go success https://github.com/k8s-publishing-bot/client-go/tree/master",
+			"bbb publishing-bot/api failure https://github.com/k8s-publishing-bot/api/tree/release-1.10",
+		}, false},
+		{"rejected", RunResult{Branches: []BranchResult{
+			{Repository: "client-go", Branch: "master", SourceCommit: "broken", Commits: 1, Pushed: true},
+		}}, []string{
+			"broken publishing-bot/client-go success https://github.com/k8s-publishing-bot/client-go/tree/master",
+		}, true},
+	}
+	for _, tt := range tests {
+		got = nil
+		err := reportCommitStatuses(context.Background(), client, cfg, tt.result)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: reportCommitStatuses() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got statuses %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Categories of the errors of failed runs.
+const (
+	// FailureSetup is an error before fetching the source repo, e.g. with
+	// the credentials.
+	FailureSetup = "setup"
+	// FailureFetch is an error fetching the source repo.
+	FailureFetch = "fetch"
+	// FailureConstruct is an error constructing the destination branches.
+	FailureConstruct = "construct"
+	// FailurePublish is an error pushing the destination branches.
+	FailurePublish = "publish"
+	// FailureConflict is a source commit which could not be applied.
+	FailureConflict = "conflict"
+	// FailureTimeout is a hanging command killed after its timeout.
+	FailureTimeout = "timeout"
+	// FailureDrift is a destination branch changed outside of the bot.
+	FailureDrift = "drift"
+	// FailureInterrupted is a run cancelled, e.g. on shutdown.
+	FailureInterrupted = "interrupted"
+)
+
+// failureCategory categorizes the error of the failed run.
+func failureCategory(cp Checkpoint, r RunResult) string {
+	switch {
+	case cp.Interrupted:
+		return FailureInterrupted
+	case r.ConflictReport != nil:
+		return FailureConflict
+	case strings.Contains(r.Error, "hung and was killed"):
+		return FailureTimeout
+	case strings.Contains(r.Error, "changed outside of the bot"):
+		return FailureDrift
+	}
+	switch cp.Phase {
+	case "fetch":
+		return FailureFetch
+	case "construct":
+		return FailureConstruct
+	case "publish":
+		return FailurePublish
+	default:
+		return FailureSetup
+	}
+}
+
+// repoTimer measures the time spent per destination repo, the repos being
+// processed one after another.
+type repoTimer struct {
+	durations map[string]time.Duration
+	repo      string
+	start     time.Time
+}
+
+// begin ends the current repo and starts measuring the given one.
+func (t *repoTimer) begin(repo string, now time.Time) {
+	t.end(now)
+	t.repo, t.start = repo, now
+}
+
+// end adds the time since the current repo began to its duration.
+func (t *repoTimer) end(now time.Time) {
+	if t.repo == "" {
+		return
+	}
+	if t.durations == nil {
+		t.durations = map[string]time.Duration{}
+	}
+	t.durations[t.repo] += now.Sub(t.start)
+	t.repo = ""
+}
+
+// stop ends the current repo and returns the durations.
+func (t *repoTimer) stop(now time.Time) map[string]time.Duration {
+	t.end(now)
+	return t.durations
+}
+
+// summaryFile and junitFile are written into the summary dir after each run.
+const (
+	summaryFile = "summary.json"
+	junitFile   = "junit_publishing-bot.xml"
+)
+
+// Outcome of a destination repo whose branches were all skipped.
+const OutcomeSkipped = "skipped"
+
+// RunSummary is the machine-readable outcome of a run for CI.
+type RunSummary struct {
+	Outcome         string        `json:"outcome"`
+	Start           time.Time     `json:"start"`
+	End             time.Time     `json:"end"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	Error           string        `json:"error,omitempty"`
+	Failure         *RunFailure   `json:"failure,omitempty"`
+	Repositories    []RepoSummary `json:"repositories"`
+}
+
+// RepoSummary is the outcome of a run for one destination repo.
+type RepoSummary struct {
+	Repository      string  `json:"repository"`
+	Outcome         string  `json:"outcome"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	// PushedRefs are the branches and tags pushed to the destination repo.
+	PushedRefs    []string        `json:"pushedRefs,omitempty"`
+	ErrorCategory string          `json:"errorCategory,omitempty"`
+	ErrorClass    string          `json:"errorClass,omitempty"`
+	Branches      []BranchSummary `json:"branches,omitempty"`
+	// Retry is set if the repo was retried after a transient error.
+	Retry *RepoRetry `json:"retry,omitempty"`
+}
+
+// BranchSummary is the outcome of a run for one destination branch.
+type BranchSummary struct {
+	Branch  string `json:"branch"`
+	Commits int    `json:"commits"`
+	Pushed  bool   `json:"pushed"`
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// summarize summarizes the run per destination repo, in the order of the
+// rules.
+func summarize(r RunResult) RunSummary {
+	s := RunSummary{
+		Outcome:         r.Outcome(),
+		Start:           r.Start,
+		End:             r.End,
+		DurationSeconds: r.End.Sub(r.Start).Seconds(),
+		Error:           r.Error,
+		Failure:         r.Failure,
+		Repositories:    []RepoSummary{},
+	}
+	index := map[string]int{}
+	repo := func(name string) *RepoSummary {
+		i, found := index[name]
+		if !found {
+			i = len(s.Repositories)
+			index[name] = i
+			s.Repositories = append(s.Repositories, RepoSummary{
+				Repository:      name,
+				Outcome:         OutcomeNothingToPublish,
+				DurationSeconds: r.Durations[name].Seconds(),
+			})
+		}
+		return &s.Repositories[i]
+	}
+	skipped := map[string]bool{}
+	for _, b := range r.Branches {
+		rs := repo(b.Repository)
+		rs.Branches = append(rs.Branches, BranchSummary{Branch: b.Branch, Commits: b.Commits, Pushed: b.Pushed, Skipped: b.Skipped})
+		if _, found := skipped[b.Repository]; !found {
+			skipped[b.Repository] = true
+		}
+		skipped[b.Repository] = skipped[b.Repository] && b.Skipped != ""
+		if b.Commits > 0 || len(b.Tags) > 0 {
+			rs.Outcome = OutcomePublished
+		}
+		if b.Pushed {
+			rs.PushedRefs = append(rs.PushedRefs, "refs/heads/"+b.Branch)
+			for _, t := range b.Tags {
+				rs.PushedRefs = append(rs.PushedRefs, "refs/tags/"+t)
+			}
+		}
+	}
+	for name, all := range skipped {
+		if all {
+			repo(name).Outcome = OutcomeSkipped
+		}
+	}
+	for i := range r.Retries {
+		rt := r.Retries[i]
+		rs := repo(rt.Repository)
+		rs.Retry = &rt
+		if !rt.Recovered {
+			// repos are retried in the construction, the retry class of
+			// transient errors is their error class
+			rs.Outcome, rs.ErrorCategory, rs.ErrorClass = OutcomeFailed, FailureConstruct, rt.Class
+			if rt.Class == RetryDependency {
+				rs.ErrorClass = classifyError(rt.Error, "")
+			}
+		}
+	}
+	if f := r.Failure; f != nil && f.Repository != "" {
+		if rs := repo(f.Repository); rs.Retry == nil || rs.Retry.Recovered {
+			rs.Outcome, rs.ErrorCategory, rs.ErrorClass = OutcomeFailed, f.Category, f.Class
+		}
+	}
+	return s
+}
+
+// junitTestSuite is the JUnit XML of a run with a test case per destination
+// repo.
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junit returns the summary as JUnit test suite. A failure outside of a
+// destination repo is a test case of its own.
+func (s RunSummary) junit() junitTestSuite {
+	suite := junitTestSuite{Name: "publishing-bot", Time: s.DurationSeconds}
+	failed := false
+	for _, r := range s.Repositories {
+		c := junitTestCase{Name: r.Repository, ClassName: "publishing-bot", Time: r.DurationSeconds}
+		switch r.Outcome {
+		case OutcomeFailed:
+			c.Failure = &junitMessage{Message: r.ErrorCategory, Text: s.Error}
+			failed = true
+			suite.Failures++
+		case OutcomeSkipped:
+			var reasons []string
+			for _, b := range r.Branches {
+				reasons = append(reasons, b.Branch+": "+b.Skipped)
+			}
+			c.Skipped = &junitMessage{Message: "skipped", Text: strings.Join(reasons, "\n")}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	if s.Failure != nil && !failed {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      s.Failure.Category,
+			ClassName: "publishing-bot",
+			Failure:   &junitMessage{Message: s.Failure.Category, Text: s.Error},
+		})
+		suite.Failures++
+	}
+	suite.Tests = len(suite.Cases)
+	return suite
+}
+
+// writeSummary writes summary.json and, if enabled, the JUnit XML of the run
+// into dir. With several tenants, the files are suffixed with the tenant name.
+func writeSummary(dir, tenant string, junit bool, r RunResult) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	name := func(file string) string {
+		if tenant != "" {
+			ext := filepath.Ext(file)
+			file = file[:len(file)-len(ext)] + "-" + tenant + ext
+		}
+		return filepath.Join(dir, file)
+	}
+	s := summarize(r)
+	bs, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(name(summaryFile), bs); err != nil {
+		return err
+	}
+	if !junit {
+		return nil
+	}
+	if bs, err = xml.MarshalIndent(s.junit(), "", "  "); err != nil {
+		return err
+	}
+	return writeFileAtomically(name(junitFile), append([]byte(xml.Header), bs...))
+}
+
+// writeFileAtomically writes the file via a temporary file.
+func writeFileAtomically(pth string, content []byte) error {
+	if err := ioutil.WriteFile(pth+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(pth+".tmp", pth)
+}
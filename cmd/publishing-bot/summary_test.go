@@ -0,0 +1,148 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepoTimer(t *testing.T) {
+	start := time.Unix(1000, 0)
+	timer := repoTimer{}
+	timer.begin("api", start)
+	timer.begin("client-go", start.Add(2*time.Second))
+	timer.end(start.Add(5 * time.Second))
+	timer.begin("api", start.Add(10*time.Second))
+	got := timer.stop(start.Add(11 * time.Second))
+	want := map[string]time.Duration{"api": 3 * time.Second, "client-go": 3 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestFailureCategory(t *testing.T) {
+	tests := []struct {
+		cp   Checkpoint
+		r    RunResult
+		want string
+	}{
+		{Checkpoint{}, RunResult{Error: "failed to load token"}, FailureSetup},
+		{Checkpoint{Phase: "fetch"}, RunResult{Error: "exit status 128"}, FailureFetch},
+		{Checkpoint{Phase: "construct"}, RunResult{Error: "construct command hung and was killed after 1m0s (3 attempts)"}, FailureTimeout},
+		{Checkpoint{Phase: "construct"}, RunResult{Error: "exit status 1", ConflictReport: &ConflictReport{}}, FailureConflict},
+		{Checkpoint{Phase: "publish", Interrupted: true}, RunResult{Error: "interrupted in phase publish"}, FailureInterrupted},
+		{Checkpoint{Phase: "publish"}, RunResult{Error: "not published because they changed outside of the bot: api/master"}, FailureDrift},
+		{Checkpoint{Phase: "publish"}, RunResult{Error: "exit status 1"}, FailurePublish},
+	}
+	for _, tt := range tests {
+		if got := failureCategory(tt.cp, tt.r); got != tt.want {
+			t.Errorf("failureCategory(%+v, %q) = %s, expected %s", tt.cp, tt.r.Error, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1000, 0)
+	r := RunResult{
+		Start: start,
+		End:   start.Add(time.Minute),
+		Branches: []BranchResult{
+			{Repository: "apimachinery", Branch: "master", Commits: 2, Tags: []string{"v0.1.0"}, Pushed: true},
+			{Repository: "apimachinery", Branch: "release-1.10", Pushed: true},
+			{Repository: "api", Branch: "master", Skipped: "paused"},
+			{Repository: "client-go", Branch: "master", Commits: 1},
+		},
+		Error:     "exit status 1",
+		Failure:   &RunFailure{Phase: "publish", Repository: "client-go", Branch: "master", Category: FailurePublish},
+		Durations: map[string]time.Duration{"apimachinery": 20 * time.Second, "client-go": 30 * time.Second},
+	}
+	if err := writeSummary(dir, "", true, r); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, summaryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s RunSummary
+	if err := json.Unmarshal(bs, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Outcome != OutcomeFailed || s.DurationSeconds != 60 {
+		t.Errorf("unexpected outcome %s after %vs", s.Outcome, s.DurationSeconds)
+	}
+	type repo struct {
+		name, outcome string
+		seconds       float64
+		refs          []string
+		category      string
+	}
+	var got []repo
+	for _, r := range s.Repositories {
+		got = append(got, repo{r.Repository, r.Outcome, r.DurationSeconds, r.PushedRefs, r.ErrorCategory})
+	}
+	want := []repo{
+		{"apimachinery", OutcomePublished, 20, []string{"refs/heads/master", "refs/tags/v0.1.0", "refs/heads/release-1.10"}, ""},
+		{"api", OutcomeSkipped, 0, nil, ""},
+		{"client-go", OutcomeFailed, 30, nil, FailurePublish},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected repositories\n%+v, got\n%+v", want, got)
+	}
+
+	bs, err = ioutil.ReadFile(filepath.Join(dir, junitFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<testsuite name="publishing-bot" tests="3" failures="1" skipped="1" time="60">`,
+		`<testcase name="apimachinery" classname="publishing-bot" time="20"></testcase>`,
+		`<skipped message="skipped">master: paused</skipped>`,
+		`<failure message="publish">exit status 1</failure>`,
+	} {
+		if !strings.Contains(string(bs), s) {
+			t.Errorf("expected %s in:\n%s", s, bs)
+		}
+	}
+
+	if err := writeSummary(dir, "nightly", false, RunResult{Start: start, End: start, Error: "failed to load token", Failure: &RunFailure{Category: FailureSetup}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "summary-nightly.json")); err != nil {
+		t.Errorf("expected the summary of the tenant: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "junit_publishing-bot-nightly.xml")); !os.IsNotExist(err) {
+		t.Errorf("expected no JUnit XML without junit, got %v", err)
+	}
+	s = summarize(RunResult{Error: "failed to load token", Failure: &RunFailure{Category: FailureSetup}})
+	if suite := s.junit(); suite.Tests != 1 || suite.Failures != 1 || suite.Cases[0].Name != FailureSetup {
+		t.Errorf("expected a failed setup test case, got %+v", suite)
+	}
+}
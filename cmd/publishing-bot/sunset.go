@@ -0,0 +1,110 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+const (
+	// sunsetWarningPeriod is how long before stop-publishing-after the bot warns.
+	sunsetWarningPeriod = 30 * 24 * time.Hour
+	freezeNoticeMarker  = "<!-- publishing-bot: freeze notice -->"
+)
+
+// checkSunset warns about deprecated branches and returns true if publishing
+// of the branch has stopped. Then the branch is not constructed. It is only
+// pushed if a freeze notice was committed to it.
+func (p *PublisherMunger) checkSunset(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule) (bool, error) {
+	s := repoRule.SunsetOf(branchRule)
+	deprecated, stop, now := s.Deprecated(), s.Stop(), time.Now()
+	switch {
+	case !stop.IsZero() && !now.Before(stop):
+		if s.FreezeNotice {
+			committed, err := p.commitFreezeNotice(ctx, repoRule, branchRule, s)
+			if err != nil {
+				return true, fmt.Errorf("failed to commit freeze notice to branch %s of %s: %v", branchRule.Name, repoRule.DestinationRepository, err)
+			}
+			if committed {
+				p.plog.Infof("Committed freeze notice to branch %s of %s", branchRule.Name, repoRule.DestinationRepository)
+				return true, nil
+			}
+		}
+		p.skipDstBranch(repoRule.DestinationRepository, branchRule.Name, fmt.Sprintf("publishing stopped after %s", s.StopPublishingAfter))
+		return true, nil
+	case !stop.IsZero() && (stop.Sub(now) < sunsetWarningPeriod || (!deprecated.IsZero() && !now.Before(deprecated))):
+		p.plog.Warningf("Branch %s of %s is deprecated. Publishing stops after %s.", branchRule.Name, repoRule.DestinationRepository, s.StopPublishingAfter)
+	case !deprecated.IsZero() && !now.Before(deprecated):
+		p.plog.Warningf("Branch %s of %s is deprecated since %s.", branchRule.Name, repoRule.DestinationRepository, s.DeprecatedAfter)
+	}
+	return false, nil
+}
+
+// commitFreezeNotice checks out the destination branch and appends a notice to
+// its README.md that the branch is not published anymore. It returns false if
+// the branch does not exist or has the notice already.
+func (p *PublisherMunger) commitFreezeNotice(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule, s config.Sunset) (bool, error) {
+	err := p.runWithTimeout(ctx, "fetch", func() *exec.Cmd {
+		return exec.Command("git", "fetch", "-q", "origin", "--no-tags")
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := p.command(ctx, "git", "rev-parse", "-q", "--verify", "origin/"+branchRule.Name).Run(); err != nil {
+		return false, nil
+	}
+	if err := p.plog.Run(p.command(ctx, "git", "checkout", "-q", "-B", branchRule.Name, "origin/"+branchRule.Name)); err != nil {
+		return false, err
+	}
+	readme, err := ioutil.ReadFile(p.path("README.md"))
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if strings.Contains(string(readme), freezeNoticeMarker) {
+		return false, nil
+	}
+
+	notice := fmt.Sprintf("%s\n**This branch is frozen.** It was published from the %s branch of %s until %s and does not receive updates anymore.\n",
+		freezeNoticeMarker, branchRule.Source.Branch, p.config.SourceRepo, s.StopPublishingAfter)
+	if len(readme) > 0 {
+		notice = strings.TrimRight(string(readme), "\n") + "\n\n" + notice
+	}
+	if err := ioutil.WriteFile(p.path("README.md"), []byte(notice), 0644); err != nil {
+		return false, err
+	}
+	if err := p.plog.Run(p.command(ctx, "git", "add", "README.md")); err != nil {
+		return false, err
+	}
+	msg := fmt.Sprintf("Announce the freeze of branch %s", branchRule.Name)
+	if err := p.plog.Run(p.gitCommit(ctx, msg)); err != nil {
+		return false, err
+	}
+	// push.sh expects the tag script of construct.sh
+	pushTags := p.path(fmt.Sprintf("../push-tags-%s-%s.sh", repoRule.DestinationRepository, branchRule.Name))
+	if err := ioutil.WriteFile(pushTags, []byte("#!/bin/bash\n"), 0755); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestCheckSunset(t *testing.T) {
+	day := func(d time.Duration) string {
+		return time.Now().Add(d).UTC().Format(config.SunsetDateFormat)
+	}
+	tests := []struct {
+		name     string
+		sunset   config.Sunset
+		wantSkip bool
+		wantLog  string
+	}{
+		{"none", config.Sunset{}, false, ""},
+		{"deprecated", config.Sunset{DeprecatedAfter: day(-48 * time.Hour)}, false, "is deprecated since"},
+		{"deprecated in the future", config.Sunset{DeprecatedAfter: day(48 * time.Hour)}, false, ""},
+		{"stopping soon", config.Sunset{StopPublishingAfter: day(10 * 24 * time.Hour)}, false, "Publishing stops after"},
+		{"stopping later", config.Sunset{StopPublishingAfter: day(100 * 24 * time.Hour)}, false, ""},
+		{"deprecated and stopping later", config.Sunset{DeprecatedAfter: day(-48 * time.Hour), StopPublishingAfter: day(100 * 24 * time.Hour)}, false, "Publishing stops after"},
+		{"stopped", config.Sunset{StopPublishingAfter: day(-48 * time.Hour)}, true, "publishing stopped after"},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		p := &PublisherMunger{
+			config:             &config.Config{},
+			plog:               &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+			skippedDstBranches: map[string]string{},
+		}
+		repoRule := config.RepositoryRule{DestinationRepository: "client-go"}
+		branchRule := config.BranchRule{Name: "release-1.9", Sunset: tt.sunset}
+		skip, err := p.checkSunset(context.Background(), repoRule, branchRule)
+		if err != nil {
+			t.Errorf("%s: checkSunset() failed: %v", tt.name, err)
+		}
+		if skip != tt.wantSkip || p.dstBranchSkipped("client-go", "release-1.9") != tt.wantSkip {
+			t.Errorf("%s: checkSunset() = %v, want %v", tt.name, skip, tt.wantSkip)
+		}
+		if tt.wantLog == "" && buf.Len() > 0 {
+			t.Errorf("%s: unexpected log %q", tt.name, buf.String())
+		} else if !strings.Contains(buf.String(), tt.wantLog) {
+			t.Errorf("%s: expected %q in the log %q", tt.name, tt.wantLog, buf.String())
+		}
+	}
+}
+
+func TestCheckSunsetFreezeNotice(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+	commitFile(t, git, "README.md", "# client-go\n", "Add README")
+	git("branch", "release-1.9")
+	git("clone", "-q", dir, "client-go")
+	if err := os.Chdir(filepath.Join(dir, "client-go")); err != nil {
+		t.Fatal(err)
+	}
+	git("config", "user.name", "Test")
+	git("config", "user.email", "test@example.com")
+
+	buf := new(bytes.Buffer)
+	p := &PublisherMunger{
+		config:             &config.Config{SourceRepo: "kubernetes"},
+		plog:               &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+		skippedDstBranches: map[string]string{},
+	}
+	repoRule := config.RepositoryRule{DestinationRepository: "client-go"}
+	stopped := time.Now().Add(-48 * time.Hour).UTC().Format(config.SunsetDateFormat)
+	branchRule := config.BranchRule{
+		Name:   "release-1.9",
+		Source: config.Source{Branch: "release-1.9"},
+		Sunset: config.Sunset{StopPublishingAfter: stopped, FreezeNotice: true},
+	}
+
+	skip, err := p.checkSunset(context.Background(), repoRule, branchRule)
+	if err != nil || !skip {
+		t.Fatalf("checkSunset() = %v, %v, want to skip after the freeze notice", skip, err)
+	}
+	if p.dstBranchSkipped("client-go", "release-1.9") {
+		t.Errorf("checkSunset() skipped the branch with the new freeze notice, want it pushed")
+	}
+	readme, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# client-go\n\n" + freezeNoticeMarker + "\n**This branch is frozen.**"; !strings.HasPrefix(string(readme), want) {
+		t.Errorf("README.md = %q, want the freeze notice", readme)
+	}
+	if got := git("log", "-1", "--format=%s", "release-1.9"); got != "Announce the freeze of branch release-1.9" {
+		t.Errorf("got the commit %q, want the freeze notice", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "push-tags-client-go-release-1.9.sh")); err != nil {
+		t.Errorf("missing push-tags script: %v", err)
+	}
+
+	// the notice is published already
+	git("push", "-q", "origin", "release-1.9:refs/heads/release-1.9")
+	skip, err = p.checkSunset(context.Background(), repoRule, branchRule)
+	if err != nil || !skip || !p.dstBranchSkipped("client-go", "release-1.9") {
+		t.Errorf("checkSunset() = %v, %v, want to skip the frozen branch", skip, err)
+	}
+}
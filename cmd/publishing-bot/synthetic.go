@@ -0,0 +1,180 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// squashSincePublished replaces the commits constructed on top of the
+// published head of the branch in the publisher's directory by a synthetic
+// commit with the same tree, listing the included source commits. It points
+// back to the latest source commit such that later runs continue from there.
+// A branch which was not published before keeps its history. The squash ends
+// at each tagged commit such that its tag moves to a synthetic commit with the
+// tree of the tagged source commit.
+func (p *PublisherMunger) squashSincePublished(ctx context.Context, repoRule config.RepositoryRule, branchRule config.BranchRule, publishedHead string) error {
+	if publishedHead == "" {
+		p.plog.Infof("Not squashing branch %s of %s because it was not published before", branchRule.Name, repoRule.DestinationRepository)
+		return nil
+	}
+	head, err := p.command(ctx, "git", "rev-parse", branchRule.Name).Output()
+	if err != nil {
+		return fmt.Errorf("failed to resolve branch %s: %v", branchRule.Name, err)
+	}
+	if strings.TrimSpace(string(head)) == publishedHead {
+		return nil
+	}
+	if err := p.command(ctx, "git", "merge-base", "--is-ancestor", publishedHead, branchRule.Name).Run(); err != nil {
+		p.plog.Infof("Not squashing branch %s of %s because it does not continue the published history", branchRule.Name, repoRule.DestinationRepository)
+		return nil
+	}
+
+	out, err := p.command(ctx, "git", "log", "--reverse", "--format=%H%x00%B%x1e", publishedHead+".."+branchRule.Name).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list the new commits of branch %s: %v", branchRule.Name, err)
+	}
+	var commits, msgs []string
+	for _, entry := range strings.Split(string(out), "\x1e") {
+		if ss := strings.SplitN(strings.TrimLeft(entry, "\n"), "\x00", 2); len(ss) == 2 {
+			commits, msgs = append(commits, ss[0]), append(msgs, ss[1])
+		}
+	}
+	tag, sourceRepo := config.CommitMsgTag(p.config.SourceRepo), p.config.SourceOrg+"/"+p.config.SourceRepo
+	if syntheticCommitMessage(msgs, tag, sourceRepo, branchRule.Source.Dir) == "" {
+		return nil // no source commits, e.g. only dependency updates
+	}
+	// the push-tags script of construct.sh pushes the moved tags
+	tagged, err := p.replacedTags(ctx, newTags(p.dir, repoRule.DestinationRepository, branchRule.Name), publishedHead, strings.TrimSpace(string(head)))
+	if err != nil {
+		return err
+	}
+
+	parent, first := publishedHead, 0
+	for i, commit := range commits {
+		if len(tagged[commit]) == 0 && i < len(commits)-1 {
+			continue
+		}
+		msg := syntheticCommitMessage(msgs[first:i+1], tag, sourceRepo, branchRule.Source.Dir)
+		if msg == "" {
+			msg = strings.TrimSpace(msgs[i]) + "\n" // e.g. a tagged dependency update
+		}
+		if signoff := p.signoff(repoRule); signoff != "" {
+			msg += "Signed-off-by: " + signoff + "\n"
+		}
+		synthetic, err := p.command(ctx, "git", "commit-tree", commit+"^{tree}", "-p", parent, "-m", msg).Output()
+		if err != nil {
+			return fmt.Errorf("failed to create synthetic commit of branch %s: %v", branchRule.Name, err)
+		}
+		parent, first = strings.TrimSpace(string(synthetic)), i+1
+		for _, t := range tagged[commit] {
+			if err := p.moveTag(ctx, t, parent); err != nil {
+				return err
+			}
+		}
+	}
+	if err := p.plog.Run(p.command(ctx, "git", "update-ref", "refs/heads/"+branchRule.Name, parent)); err != nil {
+		return err
+	}
+	p.plog.Infof("Squashed the new commits of branch %s of %s into %s", branchRule.Name, repoRule.DestinationRepository, parent)
+	return nil
+}
+
+// replacedTags returns the given tags pointing to the replaced commits after
+// the published head up to the old head, by commit.
+func (p *PublisherMunger) replacedTags(ctx context.Context, tags []string, publishedHead, oldHead string) (map[string][]string, error) {
+	replaced := map[string][]string{}
+	for _, tag := range tags {
+		target, err := p.command(ctx, "git", "rev-parse", "refs/tags/"+tag+"^{commit}").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve tag %s: %v", tag, err)
+		}
+		commit := strings.TrimSpace(string(target))
+		if p.command(ctx, "git", "merge-base", "--is-ancestor", commit, oldHead).Run() != nil ||
+			p.command(ctx, "git", "merge-base", "--is-ancestor", commit, publishedHead).Run() == nil {
+			continue
+		}
+		replaced[commit] = append(replaced[commit], tag)
+	}
+	return replaced, nil
+}
+
+// moveTag moves the tag to the synthetic commit. Annotated tags keep their
+// message, tagger and date.
+func (p *PublisherMunger) moveTag(ctx context.Context, tag, synthetic string) error {
+	out, err := p.command(ctx, "git", "for-each-ref", "--format=%(objecttype)%00%(taggername)%00%(taggeremail)%00%(taggerdate:raw)%00%(contents)", "refs/tags/"+tag).Output()
+	if err != nil {
+		return fmt.Errorf("failed to read tag %s: %v", tag, err)
+	}
+	fields := strings.SplitN(string(out), "\x00", 5)
+	cmd := p.command(ctx, "git", "tag", "-f", tag, synthetic)
+	if len(fields) == 5 && fields[0] == "tag" {
+		cmd = p.command(ctx, "git", "tag", "-f", "-a", "-m", strings.TrimSpace(fields[4]), tag, synthetic)
+		cmd.Env = append(cmd.Env,
+			"GIT_COMMITTER_NAME="+fields[1],
+			"GIT_COMMITTER_EMAIL="+strings.Trim(fields[2], "<>"),
+			"GIT_COMMITTER_DATE="+fields[3],
+		)
+	}
+	if err := p.plog.Run(cmd); err != nil {
+		return fmt.Errorf("failed to move tag %s to the synthetic commit: %v", tag, err)
+	}
+	return nil
+}
+
+// syntheticCommitMessage returns the message of the synthetic commit replacing
+// the destination commits with the given messages, oldest first. It lists the
+// source commits they point back to with the tag, and ends with the tag of the
+// latest one. It is empty if no message points back to a source commit.
+func syntheticCommitMessage(msgs []string, tag, sourceRepo, dir string) string {
+	type sourceCommit struct{ sha, subject string }
+	var commits []sourceCommit
+	seen := map[string]bool{}
+	for _, msg := range msgs {
+		msg = strings.TrimSpace(msg)
+		sha := sourceCommitInMessage(msg, tag+": ")
+		if sha == "" || seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		commits = append(commits, sourceCommit{sha, strings.SplitN(msg, "\n", 2)[0]})
+	}
+	if len(commits) == 0 {
+		return ""
+	}
+
+	latest := commits[len(commits)-1].sha
+	plural := "s"
+	if len(commits) == 1 {
+		plural = ""
+	}
+	lines := []string{
+		fmt.Sprintf("Sync with %s %s", sourceRepo, shortSHA(latest)),
+		"",
+		fmt.Sprintf("Includes %d source commit%s of %s:", len(commits), plural, dir),
+		"",
+	}
+	for _, c := range commits {
+		lines = append(lines, fmt.Sprintf("- %s %s", shortSHA(c.sha), c.subject))
+	}
+	lines = append(lines, "", tag+": "+latest)
+	return strings.Join(lines, "\n") + "\n"
+}
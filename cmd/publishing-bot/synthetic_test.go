@@ -0,0 +1,123 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestSyntheticCommitMessage(t *testing.T) {
+	msgs := []string{
+		"Add foo\n\nKubernetes-commit: 1111111111111111111111111111111111111111\n",
+		"\nUpdate dependencies\n",
+		"Fix bar\n\nLonger description.\n\nKubernetes-commit: 2222222222222222222222222222222222222222\n",
+		"\nAdd foo\n\nKubernetes-commit: 1111111111111111111111111111111111111111\n",
+		"\n",
+	}
+	want := `Sync with kubernetes/kubernetes 2222222
+
+Includes 2 source commits of staging/src/k8s.io/api:
+
+- 1111111 Add foo
+- 2222222 Fix bar
+
+Kubernetes-commit: 2222222222222222222222222222222222222222
+`
+	if got := syntheticCommitMessage(msgs, "Kubernetes-commit", "kubernetes/kubernetes", "staging/src/k8s.io/api"); got != want {
+		t.Errorf("syntheticCommitMessage() = %q, want %q", got, want)
+	}
+
+	if got := syntheticCommitMessage([]string{"Update dependencies\n", ""}, "Kubernetes-commit", "kubernetes/kubernetes", "."); got != "" {
+		t.Errorf("syntheticCommitMessage() without source commits = %q, want empty", got)
+	}
+}
+
+func TestSquashSincePublished(t *testing.T) {
+	dir, git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	published := commitFile(t, git, "a", "1", "Add a\n\nKubernetes-commit: 1111111111111111111111111111111111111111")
+	git("tag", "v0.9.0")
+	commitFile(t, git, "a", "2", "Update a\n\nKubernetes-commit: 2222222222222222222222222222222222222222")
+	commitFile(t, git, "b", "3", "Add b\n\nKubernetes-commit: 3333333333333333333333333333333333333333")
+	git("-c", "user.name=Source", "-c", "user.email=source@example.com", "tag", "-a", "-m", "Release v1.0.0", "v1.0.0")
+	releaseTree := git("rev-parse", "HEAD^{tree}")
+	commitFile(t, git, "c", "4", "Add c\n\nKubernetes-commit: 4444444444444444444444444444444444444444")
+	git("tag", "v1.0.1")
+	tree := git("rev-parse", "HEAD^{tree}")
+
+	script := "git push origin refs/tags/v0.9.0 refs/tags/v1.0.0 refs/tags/v1.0.1\n"
+	scriptPath := filepath.Join(dir, "..", "push-tags-client-go-master.sh")
+	if err := ioutil.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(scriptPath)
+
+	buf := bytes.NewBuffer(nil)
+	p := &PublisherMunger{
+		config: &config.Config{SourceOrg: "kubernetes", SourceRepo: "kubernetes"},
+		plog:   &plog{combinedBufAndFile: newSyncWriter(muxWriter{buf}), buf: buf},
+		dir:    dir,
+	}
+	repoRule := config.RepositoryRule{DestinationRepository: "client-go"}
+	branchRule := config.BranchRule{Name: "master", Source: config.Source{Dir: "staging/src/k8s.io/client-go"}}
+	if err := p.squashSincePublished(context.Background(), repoRule, branchRule, published); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, buf.String())
+	}
+
+	// the squash ends at the tagged commit
+	head := git("rev-parse", "master")
+	release := git("rev-parse", "master^")
+	if parent := git("rev-parse", "master^^"); parent != published {
+		t.Errorf("expected two synthetic commits on top of %s, got %s", published, parent)
+	}
+	if got := git("rev-parse", "master^{tree}"); got != tree {
+		t.Errorf("expected the tree %s to be kept, got %s", tree, got)
+	}
+	if msg := git("log", "-1", "--format=%B", "master"); !strings.HasSuffix(msg, "Kubernetes-commit: 4444444444444444444444444444444444444444") {
+		t.Errorf("expected the synthetic commit to point back to the latest source commit, got:\n%s", msg)
+	}
+	if msg := git("log", "-1", "--format=%B", release); !strings.Contains(msg, "Includes 2 source commits") || !strings.HasSuffix(msg, "Kubernetes-commit: 3333333333333333333333333333333333333333") {
+		t.Errorf("expected the commits up to the release to be squashed, got:\n%s", msg)
+	}
+	if got, err := ioutil.ReadFile(scriptPath); err != nil || string(got) != script {
+		t.Errorf("expected the push-tags script to be kept, got %q, %v", got, err)
+	}
+
+	for tag, want := range map[string]string{"v1.0.0": release, "v1.0.1": head, "v0.9.0": published} {
+		if got := git("rev-parse", tag+"^{commit}"); got != want {
+			t.Errorf("expected tag %s at %s, got %s", tag, want, got)
+		}
+	}
+	if got := git("rev-parse", "v1.0.0^{tree}"); got != releaseTree {
+		t.Errorf("expected tag v1.0.0 to keep the tree %s of the tagged source commit, got %s", releaseTree, got)
+	}
+	if got := git("cat-file", "-t", "v1.0.0"); got != "tag" {
+		t.Errorf("expected tag v1.0.0 to stay annotated, got a %s", got)
+	}
+	if got := git("for-each-ref", "--format=%(taggername) %(contents:subject)", "refs/tags/v1.0.0"); got != "Source Release v1.0.0" {
+		t.Errorf("expected tag v1.0.0 to keep its tagger and message, got %q", got)
+	}
+}
@@ -0,0 +1,415 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/kubeapi"
+	"k8s.io/publishing-bot/pkg/state"
+)
+
+// tenant is an independent publishing pipeline with its own config, schedule
+// and state. Tenants run concurrently.
+type tenant struct {
+	// mutex serializes the runs on the repos and the state of the tenant
+	mutex sync.Mutex
+
+	// name of the tenant in multi-tenant mode, empty otherwise
+	name         string
+	config       config.Config
+	baseRepoPath string
+	// store persists the state of the tenant
+	store state.Store
+	// interval between runs, zero for a single run
+	interval time.Duration
+	// resultFile is written with the result of each run if set
+	resultFile string
+	// client sends the HTTP requests of the tenant with its TLS settings
+	client *http.Client
+
+	server  *Server
+	latency *latencyTracker
+	digest  *Digest
+	pauses  *PauseState
+
+	// crd is the client of the API server if the rules are read from custom
+	// resources in crdNamespace
+	crd          *kubeapi.Client
+	crdNamespace string
+}
+
+// loadTenants loads the tenants from the *.yaml, *.json and *.toml files in
+// dir, named after the files. The overrides are applied to every config.
+func loadTenants(dir string, override func(*config.Config)) ([]*tenant, error) {
+	var files []string
+	for _, ext := range []string{"yaml", "json", "toml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*."+ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.yaml files found in %s, nor *.json or *.toml files", dir)
+	}
+	sort.Strings(files)
+	names := map[string]string{}
+
+	var tenants []*tenant
+	baseRepoPaths := map[string]string{}
+	stateStores := map[string]string{}
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		if other, found := names[name]; found {
+			return nil, fmt.Errorf("tenant %s is defined in %s and %s", name, other, f)
+		}
+		names[name] = f
+		cfg, err := loadConfig(f)
+		if err != nil {
+			return nil, err
+		}
+		override(&cfg)
+		baseRepoPath, err := setupConfig(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %v", name, err)
+		}
+		if other, found := baseRepoPaths[baseRepoPath]; found {
+			return nil, fmt.Errorf("tenants %s and %s both use %s, set different base-packages", other, name, baseRepoPath)
+		}
+		baseRepoPaths[baseRepoPath] = name
+		if cfg.StateStore != "" {
+			if other, found := stateStores[cfg.StateStore]; found {
+				return nil, fmt.Errorf("tenants %s and %s both use the state-store %s", other, name, cfg.StateStore)
+			}
+			stateStores[cfg.StateStore] = name
+		}
+		if len(tenants) > 0 && !sameProcessNetwork(cfg.Network, tenants[0].config.Network) {
+			return nil, fmt.Errorf("tenant %s: the network configuration other than tls applies to the process and must be the same for all tenants", name)
+		}
+		glog.Infof("Loaded tenant %s publishing %s to %s", name, cfg.SourceRepo, cfg.TargetOrg)
+		tenants = append(tenants, &tenant{name: name, config: cfg, baseRepoPath: baseRepoPath})
+	}
+	return tenants, nil
+}
+
+// sameProcessNetwork returns whether the network settings applied to the
+// process, i.e. all but the TLS settings, are the same.
+func sameProcessNetwork(a, b config.NetworkConfig) bool {
+	a.TLS, b.TLS = config.TLSConfig{}, config.TLSConfig{}
+	return reflect.DeepEqual(a, b)
+}
+
+// init loads the state of the tenant and creates its server.
+func (t *tenant) init(ctx context.Context, pprof bool) error {
+	transport, err := t.config.Network.TLS.Transport()
+	if err != nil {
+		return err
+	}
+	t.client = &http.Client{Transport: transport}
+	ctx = withHTTPClient(ctx, t.client)
+	if t.store, err = state.Parse(t.config.StateStore, t.baseRepoPath); err != nil {
+		return err
+	}
+	if t.pauses, err = LoadPauseState(t.store); err != nil {
+		return fmt.Errorf("failed to load paused repositories: %v", err)
+	}
+	t.latency = newLatencyTracker(t.config.LatencySLO)
+	t.server = &Server{
+		Issue:   t.config.GithubIssue,
+		config:  t.config,
+		RunChan: make(chan bool, 1),
+		latency: t.latency,
+		pauses:  t.pauses,
+		pprof:   pprof,
+	}
+	if t.config.ControlAPIToken != "" {
+		t.server.control = &controlAPI{config: &t.config, server: t.server, pauses: t.pauses}
+	}
+	if t.config.ChatOps.Enabled() && t.config.TokenRef() != "" && !t.config.DryRun {
+		go newChatOps(&t.config, t.server, t.pauses).Run(ctx)
+	}
+	if t.config.Redirect.Enabled() && t.config.TokenRef() != "" && !t.config.DryRun {
+		go newRedirector(&t.config).Run(ctx)
+	}
+	if t.config.EmailDigest.Enabled() {
+		t.digest = NewDigest(t.config.EmailDigest, t.store)
+	}
+	if _, ok := config.CRDNamespace(t.config.RulesFile); ok {
+		if t.crd, t.crdNamespace, err = config.CRDClient(t.config.RulesFile); err != nil {
+			return err
+		}
+		go watchCRDs(ctx, t.crd, t.crdNamespace, func() { t.server.trigger() })
+	}
+	return nil
+}
+
+// loop publishes until the context is done, or once if there is no interval.
+// It returns the exit code of the last run in -run-once mode.
+func (t *tenant) loop(ctx context.Context, runOnce bool) int {
+	ctx = withHTTPClient(ctx, t.client)
+	cfg := &t.config
+	exitCode := 0
+	for {
+		last := time.Now()
+		publisher := New(cfg, t.baseRepoPath)
+		// pick up the pause and resume commands
+		if err := t.pauses.Reload(); err != nil {
+			glog.Errorf("Failed to reload paused repositories%s: %v", t.suffix(), err)
+		}
+		publisher.paused = t.pauses
+		publisher.state = t.store
+
+		// canary-only runs leave the issue and the source commits alone
+		reportOnIssue := cfg.TokenRef() != "" && cfg.GithubIssue != 0 && !cfg.DryRun && !cfg.Canary.Only
+		reportStatuses := cfg.TokenRef() != "" && cfg.CommitStatuses && cfg.SourceOrg != "" && !cfg.DryRun && !cfg.Canary.Only
+		reportComments := cfg.TokenRef() != "" && cfg.CommitComments && cfg.SourceOrg != "" && !cfg.DryRun && !cfg.Canary.Only
+		fileIssues := cfg.TokenRef() != "" && cfg.FailureIssues.Enabled && !cfg.DryRun && !cfg.Canary.Only
+		if fileIssues {
+			reportOnIssue = false
+		}
+		// failures to report affect only the health and the exit code of
+		// this tenant
+		reportFailed := false
+		var token string
+		if reportOnIssue || reportStatuses || reportComments || fileIssues {
+			// load token
+			var err error
+			if token, err = loadToken(cfg, cfg.TokenRef()); err != nil {
+				glog.Errorf("Not reporting to GitHub%s: %v", t.suffix(), err)
+				reportOnIssue, reportStatuses, reportComments, fileIssues = false, false, false, false
+				reportFailed = true
+			}
+		}
+
+		// run
+		logs, hash, err := t.run(ctx, publisher)
+		result := publisher.Result()
+		// CI jobs are interrupted at their deadline, and the summary of the
+		// interrupted run tells where
+		if cfg.Summary.Dir != "" {
+			if err := writeSummary(cfg.Summary.Dir, t.name, cfg.Summary.JUnit, result); err != nil {
+				glog.Errorf("Failed to write run summary%s: %v", t.suffix(), err)
+			}
+		}
+		if ctx.Err() != nil {
+			glog.Infof("Publishing run%s interrupted: %v", t.suffix(), err)
+			if runOnce {
+				exitCode = exitFailed
+			}
+			break
+		}
+		t.server.SetHealth(err == nil && !reportFailed, hash)
+		if err != nil {
+			glog.Infof("Failed to run publisher%s: %v", t.suffix(), err)
+		}
+		if t.resultFile != "" {
+			if err := result.WriteFile(t.resultFile); err != nil {
+				glog.Errorf("Failed to write result file: %v", err)
+			}
+		}
+		if reportStatuses {
+			if err := ReportCommitStatuses(ctx, cfg, token, result); err != nil {
+				glog.Errorf("Failed to report commit statuses: %v", err)
+			}
+		}
+		if reportComments {
+			if err := ReportCommitComments(ctx, cfg, token, result); err != nil {
+				glog.Errorf("Failed to report commit comments: %v", err)
+			}
+		}
+		t.server.SetResult(result)
+		if t.crd != nil && !cfg.DryRun {
+			if err := updateCRDStatus(ctx, t.crd, t.crdNamespace, result); err != nil {
+				glog.Errorf("Failed to update the status of the custom resources: %v", err)
+			}
+		}
+		t.latency.Update(ctx, result, time.Now())
+		if t.digest != nil {
+			if err := t.digest.Record(result); err != nil {
+				glog.Errorf("Failed to send email digest: %v", err)
+			}
+		}
+		if reportOnIssue {
+			if err != nil {
+				if err := ReportOnIssue(ctx, err, failureOf(result).Class, result.ConflictReport, logs, token, cfg.TargetOrg, cfg.SourceRepo, cfg.GithubIssue); err != nil {
+					glog.Errorf("Failed to report logs on github issue%s: %v", t.suffix(), err)
+					reportFailed = true
+				}
+			} else if err := CloseIssue(ctx, token, cfg.TargetOrg, cfg.SourceRepo, cfg.GithubIssue); err != nil {
+				glog.Errorf("Failed to close issue%s: %v", t.suffix(), err)
+				reportFailed = true
+			}
+		}
+		if fileIssues {
+			client := githubClient(ctx, token)
+			if err != nil {
+				if err := FileFailureIssue(ctx, client, cfg, err, result, logs); err != nil {
+					glog.Errorf("Failed to file failure issue%s: %v", t.suffix(), err)
+					reportFailed = true
+				}
+			} else if err := CloseFailureIssues(ctx, client, cfg); err != nil {
+				glog.Errorf("Failed to close failure issues%s: %v", t.suffix(), err)
+				reportFailed = true
+			}
+		}
+		if reportFailed {
+			t.server.SetHealth(false, hash)
+		}
+
+		if t.interval == 0 {
+			if runOnce {
+				exitCode = map[string]int{
+					OutcomePublished:        exitPublished,
+					OutcomeNothingToPublish: exitNothingToPublish,
+					OutcomeFailed:           exitFailed,
+				}[result.Outcome()]
+				if reportFailed {
+					exitCode = exitFailed
+				}
+			}
+			break
+		}
+
+		select {
+		case <-t.server.RunChan:
+		case <-ctx.Done():
+		case <-time.After(t.interval - time.Since(last)):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return exitCode
+}
+
+// run runs the publisher of the tenant. The publisher keeps its working
+// directory and git environment to itself, such that tenants only wait for
+// their own runs.
+func (t *tenant) run(ctx context.Context, publisher *PublisherMunger) (string, string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.name != "" {
+		glog.Infof("Publishing tenant %s", t.name)
+	}
+	return publisher.Run(ctx)
+}
+
+func (t *tenant) suffix() string {
+	if t.name == "" {
+		return ""
+	}
+	return " of tenant " + t.name
+}
+
+// combineExitCodes returns the exit code of all tenants: failed if one failed,
+// published if one published, nothing to publish otherwise.
+func combineExitCodes(codes []int) int {
+	code := exitNothingToPublish
+	for _, c := range codes {
+		switch c {
+		case exitFailed:
+			return exitFailed
+		case exitPublished:
+			code = exitPublished
+		}
+	}
+	return code
+}
+
+// mergeMetrics merges the metrics of the tenants in the Prometheus text
+// format, keyed by tenant name, into one exposition with a tenant label. The
+// samples are grouped by metric family.
+func mergeMetrics(metrics map[string]string, names []string) string {
+	var families []string
+	headers := map[string][]string{}
+	samples := map[string][]string{}
+	for _, name := range names {
+		family := ""
+		s := bufio.NewScanner(strings.NewReader(metrics[name]))
+		for s.Scan() {
+			line := s.Text()
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "#") {
+				fields := strings.Fields(line)
+				if len(fields) < 3 {
+					continue
+				}
+				family = fields[2]
+				if _, found := headers[family]; !found {
+					families = append(families, family)
+					headers[family] = nil
+				}
+				if !containsString(headers[family], line) {
+					headers[family] = append(headers[family], line)
+				}
+				continue
+			}
+			label := fmt.Sprintf("tenant=%q", name)
+			if i := strings.IndexAny(line, "{ "); i >= 0 && line[i] == '{' {
+				if line[i+1] != '}' {
+					label += ","
+				}
+				line = line[:i+1] + label + line[i+1:]
+			} else if i >= 0 {
+				line = line[:i] + "{" + label + "}" + line[i:]
+			}
+			if family == "" {
+				family = strings.SplitN(line, "{", 2)[0]
+				if _, found := headers[family]; !found {
+					families = append(families, family)
+					headers[family] = nil
+				}
+			}
+			samples[family] = append(samples[family], line)
+		}
+	}
+
+	var lines []string
+	for _, f := range families {
+		lines = append(lines, headers[f]...)
+		lines = append(lines, samples[f]...)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
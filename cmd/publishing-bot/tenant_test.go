@@ -0,0 +1,178 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+func TestMergeMetrics(t *testing.T) {
+	metrics := map[string]string{
+		"a": `# HELP age Age.
+# TYPE age gauge
+age{repository="api",branch="master"} 10
+# HELP slo SLO.
+# TYPE slo gauge
+slo 3600
+`,
+		"b": `# HELP age Age.
+# TYPE age gauge
+age{repository="api",branch="master"} 20
+`,
+	}
+	want := `# HELP age Age.
+# TYPE age gauge
+age{tenant="a",repository="api",branch="master"} 10
+age{tenant="b",repository="api",branch="master"} 20
+# HELP slo SLO.
+# TYPE slo gauge
+slo{tenant="a"} 3600
+`
+	if got := mergeMetrics(metrics, []string{"a", "b"}); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCombineExitCodes(t *testing.T) {
+	tests := []struct {
+		codes []int
+		want  int
+	}{
+		{[]int{exitNothingToPublish, exitNothingToPublish}, exitNothingToPublish},
+		{[]int{exitNothingToPublish, exitPublished}, exitPublished},
+		{[]int{exitPublished, exitFailed, exitNothingToPublish}, exitFailed},
+	}
+	for _, tt := range tests {
+		if got := combineExitCodes(tt.codes); got != tt.want {
+			t.Errorf("combineExitCodes(%v) = %d, want %d", tt.codes, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "independent",
+			files: map[string]string{
+				"k8s.yaml":   "source-org: kubernetes\nsource-repo: kubernetes\ntarget-org: kubernetes\ninterval: 4h\n",
+				"other.yaml": "source-org: example\nsource-repo: project\ntarget-org: example-libs\n",
+				"README.md":  "not a tenant",
+			},
+			want: []string{"k8s", "other"},
+		},
+		{
+			name: "same base package",
+			files: map[string]string{
+				"a.yaml": "source-org: example\nsource-repo: a\ntarget-org: libs\n",
+				"b.yaml": "source-org: example\nsource-repo: b\ntarget-org: libs\n",
+			},
+			wantErr: "tenants a and b both use",
+		},
+		{
+			name: "different network",
+			files: map[string]string{
+				"a.yaml": "source-org: example\nsource-repo: a\ntarget-org: a-libs\n",
+				"b.yaml": "source-org: example\nsource-repo: b\ntarget-org: b-libs\nnetwork:\n  gonosumdb: example.com\n",
+			},
+			wantErr: "tenant b: the network configuration",
+		},
+		{
+			name:    "none",
+			files:   map[string]string{"README.md": "not a tenant"},
+			wantErr: "no *.yaml files",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "tenants")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			defer os.Unsetenv("GONOSUMDB")
+			for name, content := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tenants, err := loadTenants(dir, func(cfg *config.Config) {
+				cfg.RulesFile = "rules.yaml"
+				cfg.GitIdentity = config.GitIdentity{Name: "Bot", Email: "bot@example.com"}
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, t := range tenants {
+				names = append(names, t.name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got tenants %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantLoopWithoutToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tn := &tenant{
+		name: "a",
+		config: config.Config{
+			SourceRepo:  "a",
+			TargetOrg:   "a-libs",
+			RulesFile:   filepath.Join(dir, "missing-rules.yaml"),
+			TokenFile:   filepath.Join(dir, "missing-token"),
+			GithubIssue: 1,
+		},
+		baseRepoPath: dir,
+	}
+	ctx := context.Background()
+	if err := tn.init(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	// a missing token fails the tenant, not the process
+	if code := tn.loop(ctx, true); code != exitFailed {
+		t.Errorf("got exit code %d, want %d", code, exitFailed)
+	}
+	if ok := tn.server.response.Successful; ok == nil || *ok {
+		t.Errorf("expected the tenant to be unhealthy")
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// maxTraceDepth is the number of first parents searched for a commit with a
+// source commit tag, e.g. above a commit of the bot updating dependencies.
+const maxTraceDepth = 20
+
+var mergePullRequestRE = regexp.MustCompile(`^Merge pull request #([0-9]+) `)
+
+// commitTrace is a published commit traced back to the source repo.
+type commitTrace struct {
+	Commit string
+	// TaggedCommit is the first ancestor, or the commit itself, with the
+	// source commit tag, and Depth its distance.
+	TaggedCommit string
+	Depth        int
+	Source       *github.RepositoryCommit
+	PullRequest  *github.PullRequest
+}
+
+// trace is the trace subcommand. It prints the source commit and pull request a
+// commit of a published repo was created from and returns the exit code.
+func trace(args []string) int {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	configFile := fs.String("config", "", "the config file in yaml format to take the orgs, the source repo and the token file from")
+	sourceOrg := fs.String("source-org", "", "the organization of the source repository (defaults to kubernetes)")
+	sourceRepo := fs.String("source-repo", "", "the name of the source repository (defaults to kubernetes)")
+	targetOrg := fs.String("target-org", "", "the organization of the published repository (defaults to kubernetes)")
+	tokenFile := fs.String("token-file", "", "the file with a github token to raise the API rate limit (defaults to $GITHUB_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s trace [flags] [<org>/]<published-repo> <sha>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		*sourceOrg = firstNonEmpty(*sourceOrg, cfg.SourceOrg)
+		*sourceRepo = firstNonEmpty(*sourceRepo, cfg.SourceRepo)
+		*targetOrg = firstNonEmpty(*targetOrg, cfg.TargetOrg)
+		*tokenFile = firstNonEmpty(*tokenFile, cfg.TokenFile)
+	}
+	*sourceOrg = firstNonEmpty(*sourceOrg, "kubernetes")
+	*sourceRepo = firstNonEmpty(*sourceRepo, "kubernetes")
+	*targetOrg = firstNonEmpty(*targetOrg, "kubernetes")
+	token := os.Getenv("GITHUB_TOKEN")
+	if *tokenFile != "" {
+		bs, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			glog.Fatalf("Failed to load token file from %q: %v", *tokenFile, err)
+		}
+		token = strings.TrimSpace(string(bs))
+	}
+
+	org, repo := *targetOrg, fs.Arg(0)
+	if i := strings.Index(repo, "/"); i >= 0 {
+		org, repo = repo[:i], repo[i+1:]
+	}
+	ctx := context.Background()
+	client := github.NewClient(nil)
+	if token != "" {
+		client = githubClient(ctx, token)
+	}
+	t, err := traceCommit(ctx, client, org, repo, fs.Arg(1), *sourceOrg, *sourceRepo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	printTrace(os.Stdout, t, org, repo, *sourceOrg, *sourceRepo)
+	return 0
+}
+
+// traceCommit looks up the source commit and pull request of the commit of the
+// published repo via the GitHub API.
+func traceCommit(ctx context.Context, client *github.Client, org, repo, sha, sourceOrg, sourceRepo string) (*commitTrace, error) {
+	prefix := config.CommitMsgTag(sourceRepo) + ": "
+	t := &commitTrace{}
+	var sourceSHA string
+	for rev := sha; sourceSHA == "" && rev != ""; t.Depth++ {
+		if t.Depth > maxTraceDepth {
+			return nil, fmt.Errorf("no %s tag in the %d first parents of %s", config.CommitMsgTag(sourceRepo), maxTraceDepth, sha)
+		}
+		c, _, err := client.Repositories.GetCommit(ctx, org, repo, rev)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get commit %s of %s/%s: %v", rev, org, repo, err)
+		}
+		if t.Commit == "" {
+			t.Commit = c.GetSHA()
+		}
+		if sourceSHA = sourceCommitInMessage(c.GetCommit().GetMessage(), prefix); sourceSHA != "" {
+			t.TaggedCommit = c.GetSHA()
+			break
+		}
+		rev = ""
+		if len(c.Parents) > 0 {
+			rev = c.Parents[0].GetSHA()
+		}
+	}
+	if sourceSHA == "" {
+		return nil, fmt.Errorf("no %s tag in the history of %s", config.CommitMsgTag(sourceRepo), sha)
+	}
+
+	var err error
+	if t.Source, _, err = client.Repositories.GetCommit(ctx, sourceOrg, sourceRepo, sourceSHA); err != nil {
+		return nil, fmt.Errorf("failed to get source commit %s of %s/%s: %v", sourceSHA, sourceOrg, sourceRepo, err)
+	}
+
+	// merge commits of pull requests name the pull request, other commits are
+	// searched for.
+	number := pullRequestOfMerge(t.Source.GetCommit().GetMessage())
+	if number == 0 {
+		q := fmt.Sprintf("repo:%s/%s type:pr is:merged %s", sourceOrg, sourceRepo, sourceSHA)
+		res, _, err := client.Search.Issues(ctx, q, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search the pull request of %s: %v", sourceSHA, err)
+		}
+		if len(res.Issues) > 0 {
+			number = res.Issues[0].GetNumber()
+		}
+	}
+	if number != 0 {
+		if t.PullRequest, _, err = client.PullRequests.Get(ctx, sourceOrg, sourceRepo, number); err != nil {
+			return nil, fmt.Errorf("failed to get pull request #%d of %s/%s: %v", number, sourceOrg, sourceRepo, err)
+		}
+	}
+	return t, nil
+}
+
+// sourceCommitInMessage returns the source commit of the first line with the
+// given tag prefix, e.g. "Kubernetes-commit: ", or the empty string.
+func sourceCommitInMessage(msg, prefix string) string {
+	for _, line := range strings.Split(msg, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(line[len(prefix):])
+		}
+	}
+	return ""
+}
+
+// pullRequestOfMerge returns the number of the pull request merged by a commit
+// with the given message, or 0.
+func pullRequestOfMerge(msg string) int {
+	m := mergePullRequestRE.FindStringSubmatch(msg)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+func printTrace(w io.Writer, t *commitTrace, org, repo, sourceOrg, sourceRepo string) {
+	fmt.Fprintf(w, "Published commit: %s/%s@%s\n", org, repo, t.Commit)
+	if t.TaggedCommit != t.Commit {
+		fmt.Fprintf(w, "Tagged ancestor:  %s (%d first parents back)\n", t.TaggedCommit, t.Depth)
+	}
+	c := t.Source.GetCommit()
+	fmt.Fprintf(w, "Source commit:    %s/%s@%s\n", sourceOrg, sourceRepo, t.Source.GetSHA())
+	fmt.Fprintf(w, "Subject:          %s\n", strings.SplitN(c.GetMessage(), "\n", 2)[0])
+	fmt.Fprintf(w, "Author:           %s <%s>%s\n", c.GetAuthor().GetName(), c.GetAuthor().GetEmail(), loginSuffix(t.Source.GetAuthor()))
+	fmt.Fprintf(w, "Date:             %s\n", c.GetAuthor().GetDate().Format(time.RFC3339))
+	if pr := t.PullRequest; pr != nil {
+		fmt.Fprintf(w, "Pull request:     #%d %s\n", pr.GetNumber(), pr.GetTitle())
+		fmt.Fprintf(w, "                  %s\n", pr.GetHTMLURL())
+		fmt.Fprintf(w, "PR author:       %s\n", loginSuffix(pr.GetUser()))
+		if pr.MergedAt != nil {
+			fmt.Fprintf(w, "Merged:           %s\n", pr.GetMergedAt().Format(time.RFC3339))
+		}
+	} else {
+		fmt.Fprintf(w, "Pull request:     not found\n")
+	}
+}
+
+func loginSuffix(u *github.User) string {
+	if u.GetLogin() == "" {
+		return ""
+	}
+	return " @" + u.GetLogin()
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
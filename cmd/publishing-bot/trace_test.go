@@ -0,0 +1,78 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestPullRequestOfMerge(t *testing.T) {
+	tests := map[string]int{
+		"Merge pull request #61234 from foo/bar\n\nfix things": 61234,
+		"Fix things": 0,
+		"Revert \"Merge pull request #1 from foo/bar\"": 0,
+	}
+	for msg, want := range tests {
+		if got := pullRequestOfMerge(msg); got != want {
+			t.Errorf("%q: got %d, want %d", msg, got, want)
+		}
+	}
+}
+
+func TestTraceCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/kubernetes/client-go/commits/bbb":
+			fmt.Fprint(w, `{"sha": "bbb", "commit": {"message": "sync: update go.mod"}, "parents": [{"sha": "aaa"}]}`)
+		case "/repos/kubernetes/client-go/commits/aaa":
+			fmt.Fprint(w, `{"sha": "aaa", "commit": {"message": "Merge pull request #42 from foo/bar\n\nKubernetes-commit: 123"}}`)
+		case "/repos/kubernetes/kubernetes/commits/123":
+			fmt.Fprint(w, `{"sha": "123", "commit": {"message": "Merge pull request #42 from foo/bar", "author": {"name": "Foo", "date": "2018-03-01T12:00:00Z"}}}`)
+		case "/repos/kubernetes/kubernetes/pulls/42":
+			fmt.Fprint(w, `{"number": 42, "title": "Fix informers", "user": {"login": "foo"}, "merged_at": "2018-03-01T12:00:00Z"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	tr, err := traceCommit(context.Background(), client, "kubernetes", "client-go", "bbb", "kubernetes", "kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Commit != "bbb" || tr.TaggedCommit != "aaa" || tr.Depth != 1 || tr.Source.GetSHA() != "123" || tr.PullRequest.GetNumber() != 42 {
+		t.Errorf("unexpected trace %+v", tr)
+	}
+	buf := bytes.NewBuffer(nil)
+	printTrace(buf, tr, "kubernetes", "client-go", "kubernetes", "kubernetes")
+	for _, want := range []string{"Source commit:    kubernetes/kubernetes@123", "Pull request:     #42 Fix informers", "Merged:           2018-03-01T12:00:00Z"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+	"k8s.io/publishing-bot/pkg/cache"
+	"k8s.io/publishing-bot/pkg/git"
+)
+
+// verifyCommand is the verify subcommand. It compares the published history
+// of the destination repo in the current directory with the source repository
+// and returns the exit code: 0 if it matches, 1 if it diverges and 2 on errors.
+func verifyCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	commitMsgTag := fs.String("commit-message-tag", "Kubernetes-commit", "the git commit message tag used to point back to source commits")
+	branch := fs.String("branch", "HEAD", "the published branch or revision to verify")
+	sourceDir := fs.String("source-dir", "", "the directory in the source repository the branch is published from, e.g. staging/src/k8s.io/client-go")
+	rulesFile := fs.String("rules-file", "", "the publishing rules to read the source directory and recursive delete patterns from")
+	basePackage := fs.String("base-package", "k8s.io", "the base package of the destination repositories, used in source directory templates of the rules file")
+	repository := fs.String("repository", "", "the destination repository in the rules file, e.g. client-go")
+	ignore := fs.String("ignore", "vendor,Godeps,go.mod,go.sum", "comma-separated list of pathspecs which are not compared")
+	maxCommits := fs.Int("n", 0, "the maximal number of published commits to verify, 0 for all")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: %s verify -source-dir <dir> [-branch <branch>]
+          [-rules-file <file> -repository <destination> [-base-package <package>]]
+          [-commit-message-tag <Commit-message-tag>]
+          [-ignore <pathspec>,...] [-n <count>]
+
+verifies the published history of a destination repository checkout against
+the source repository. For each first-parent commit of the given branch
+carrying a "Kubernetes-commit: <upstream commit>" line, the tree of the source
+directory in the upstream commit is compared with the published tree. The
+source commits must be available in the checkout, e.g. by fetching the source
+repository as the upstream remote.
+
+Files which are rewritten during publishing (vendor/, Godeps/, go.mod and
+go.sum by default) and files matching the recursive delete patterns are ignored.
+
+Every divergence is printed. The exit code is 1 if there is any.
+
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var ignored []string
+	if *ignore != "" {
+		ignored = strings.Split(*ignore, ",")
+	}
+	if *rulesFile != "" {
+		rules, err := config.LoadRules(*rulesFile, config.TLSConfig{})
+		if err == nil {
+			err = rules.ExpandSourceDirs(*basePackage)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load rules: %v\n", err)
+			return 2
+		}
+		if *sourceDir == "" {
+			*sourceDir, err = ruleSourceDir(rules, *repository, strings.TrimPrefix(*branch, "refs/heads/"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		}
+		ignored = append(ignored, rules.RecursiveDeletePatterns...)
+	}
+	if *sourceDir == "" {
+		fmt.Fprintln(os.Stderr, "source-dir cannot be empty")
+		return 2
+	}
+
+	r, err := gogit.PlainOpen(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repo at .: %v\n", err)
+		return 2
+	}
+	h, err := r.ResolveRevision(plumbing.Revision(*branch))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve %s: %v\n", *branch, err)
+		return 2
+	}
+	head, err := cache.CommitObject(r, *h)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", *branch, err)
+		return 2
+	}
+	firstParents, err := git.FirstParentList(r, head)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get first-parent commit list for %s: %v\n", *branch, err)
+		return 2
+	}
+
+	ignoreFn := func(pth string) bool {
+		for _, p := range ignored {
+			if git.MatchPathspec(p, pth) {
+				return true
+			}
+		}
+		return false
+	}
+
+	verified, diverged := 0, 0
+	for _, c := range firstParents {
+		if *maxCommits > 0 && verified >= *maxCommits {
+			break
+		}
+		kh := git.SourceHash(c, *commitMsgTag)
+		if kh == plumbing.ZeroHash {
+			continue
+		}
+		verified++
+
+		diff, err := verifyCommit(r, c, kh, *sourceDir, ignoreFn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to verify %s: %v\n", c.Hash, err)
+			return 2
+		}
+		if len(diff) == 0 {
+			continue
+		}
+		diverged++
+		fmt.Fprintf(out, "%s diverges from %s %s: %s\n", c.Hash, *commitMsgTag, kh, strings.SplitN(c.Message, "\n", 2)[0])
+		for _, l := range diff {
+			fmt.Fprintf(out, "    %s\n", l)
+		}
+	}
+
+	fmt.Fprintf(out, "Verified %d published commits, %d diverged.\n", verified, diverged)
+	if diverged > 0 {
+		return 1
+	}
+	return 0
+}
+
+// verifyCommit compares the tree of the published commit c with the source
+// directory in the source commit kh.
+func verifyCommit(r *gogit.Repository, c *object.Commit, kh plumbing.Hash, sourceDir string, ignore func(string) bool) ([]string, error) {
+	kc, err := cache.CommitObject(r, kh)
+	if err != nil {
+		return nil, fmt.Errorf("source commit %s not found, fetch the source repository first: %v", kh, err)
+	}
+	kTree, err := kc.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if dir := strings.Trim(sourceDir, "/"); dir != "" && dir != "." {
+		kTree, err = kTree.Tree(dir)
+		if err == object.ErrDirectoryNotFound {
+			// the source directory does not exist yet, e.g. for old commits
+			kTree = &object.Tree{}
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to find %s in source commit %s: %v", dir, kh, err)
+		}
+	}
+	dstTree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return git.TreeDiff(kTree, dstTree, ignore)
+}
+
+// ruleSourceDir returns the source directory of the given destination branch.
+func ruleSourceDir(rules *config.RepositoryRules, repository, branch string) (string, error) {
+	for _, r := range rules.Rules {
+		if r.DestinationRepository != repository {
+			continue
+		}
+		for _, b := range r.Branches {
+			if b.Name == branch {
+				if b.Source.Dir == "" {
+					return ".", nil
+				}
+				return b.Source.Dir, nil
+			}
+		}
+		return "", fmt.Errorf("no branch %q found for repository %q in the rules", branch, repository)
+	}
+	return "", fmt.Errorf("no repository %q found in the rules", repository)
+}
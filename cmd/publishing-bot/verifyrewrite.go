@@ -0,0 +1,214 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/publishing-bot/cmd/publishing-bot/config"
+)
+
+// verifyRewriteCommand is the verify-rewrite subcommand. It constructs the
+// given published destination branches again from scratch up to their last
+// published source commit, without pushing, and compares the commits with the
+// published ones. A new version of the bot must reproduce them, otherwise it
+// would rewrite the published history. It returns 0 if all branches are
+// identical, 1 if one differs and 2 on errors.
+func verifyRewriteCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("verify-rewrite", flag.ExitOnError)
+	configFile := fs.String("config", "", "the config file in yaml format (required)")
+	rulesFile := rulesFileVar(fs, "the file, directory or URL with repository rules, overriding the config")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify-rewrite -config <config-yaml-file> [-rules-file <file>] <repo>/<branch>...\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *configFile == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	for _, target := range fs.Args() {
+		if ss := strings.SplitN(target, "/", 2); len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+			fmt.Fprintf(os.Stderr, "Invalid branch %q, expected <repo>/<branch>\n", target)
+			return 2
+		}
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *rulesFile != "" {
+		cfg.RulesFile = *rulesFile
+	}
+	baseRepoPath, err := setupConfig(&cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	unsetGitIdentityEnv()
+
+	diffs, err := verifyRewrite(context.Background(), &cfg, baseRepoPath, fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to verify the rewrite: %v\n", err)
+		return 2
+	}
+	for _, target := range fs.Args() {
+		if diff := diffs[target]; diff != "" {
+			fmt.Fprintf(out, "%s\tdiffers: %s\n", target, diff)
+		} else {
+			fmt.Fprintf(out, "%s\tidentical\n", target)
+		}
+	}
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// verifyRewrite constructs the given <destination>/<branch> targets again and
+// returns the differences to the published branches by target. Targets which
+// are reproduced identically are not in the map.
+func verifyRewrite(ctx context.Context, cfg *config.Config, baseRepoPath string, targets []string) (map[string]string, error) {
+	p := New(cfg, baseRepoPath)
+	var err error
+	if p.plog, err = NewPublisherLog(bytes.NewBuffer(nil), filepath.Join(baseRepoPath, "verify-rewrite.log")); err != nil {
+		return nil, err
+	}
+	defer p.plog.Flush()
+	p.verify = map[string]bool{}
+	for _, target := range targets {
+		p.verify[target] = true
+	}
+	p.skippedDstBranches = map[string]string{}
+	p.goVersions = map[string]string{}
+	p.rebuilt = map[string]string{}
+	p.heads = &HeadState{Heads: map[string]string{}}
+
+	if err := p.setupCredentials(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := p.updateSourceRepo(ctx); err != nil {
+		return nil, err
+	}
+	repoRules := map[string]config.RepositoryRule{}
+	for _, target := range targets {
+		repo, branch := splitTarget(target)
+		for _, repoRule := range p.reposRules.Rules {
+			for _, branchRule := range repoRule.Branches {
+				if repoRule.DestinationRepository == repo && branchRule.Name == branch {
+					repoRules[target] = repoRule
+				}
+			}
+		}
+		if _, found := repoRules[target]; !found {
+			return nil, fmt.Errorf("no rule for branch %s of destination repository %s", branch, repo)
+		}
+	}
+	if err := p.construct(ctx); err != nil {
+		return nil, err
+	}
+
+	diffs := map[string]string{}
+	for _, target := range targets {
+		repo, branch := splitTarget(target)
+		if reason, skipped := p.skippedDstBranches[target]; skipped {
+			return nil, fmt.Errorf("%s was not constructed: %s", target, reason)
+		}
+		p.dir = p.dstDir(repoRules[target])
+		diff, err := p.compareRewrite(ctx, branch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %s of %s: %v", branch, repo, err)
+		}
+		if diff != "" {
+			p.plog.Errorf("The rewrite of %s differs from the published branch: %s", target, diff)
+			diffs[target] = diff
+		} else {
+			p.plog.Infof("The rewrite of %s is identical to the published branch", target)
+		}
+	}
+	return diffs, nil
+}
+
+// verifiesRepo returns true if a branch of the destination repo is verified.
+func (p *PublisherMunger) verifiesRepo(repo string) bool {
+	for target := range p.verify {
+		if r, _ := splitTarget(target); r == repo {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTarget splits a validated <destination>/<branch> target.
+func splitTarget(target string) (string, string) {
+	ss := strings.SplitN(target, "/", 2)
+	return ss[0], ss[1]
+}
+
+// compareRewrite compares the first-parent history of the constructed branch
+// with the one of the branch on origin in the repo in the publisher's directory. It
+// returns a description of the first difference, or the empty string if the
+// commits are identical.
+func (p *PublisherMunger) compareRewrite(ctx context.Context, branch string) (string, error) {
+	rebuilt, err := p.firstParents(ctx, branch)
+	if err != nil {
+		return "", err
+	}
+	published, err := p.firstParents(ctx, "origin/"+branch)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(rebuilt) && i < len(published); i++ {
+		if rebuilt[i] != published[i] {
+			return fmt.Sprintf("commit %d is %s instead of the published %s", i+1, p.describeCommit(ctx, rebuilt[i]), p.describeCommit(ctx, published[i])), nil
+		}
+	}
+	switch {
+	case len(rebuilt) < len(published):
+		return fmt.Sprintf("%d published commits are missing, starting with %s", len(published)-len(rebuilt), p.describeCommit(ctx, published[len(rebuilt)])), nil
+	case len(rebuilt) > len(published):
+		return fmt.Sprintf("%d commits were not published, starting with %s", len(rebuilt)-len(published), p.describeCommit(ctx, rebuilt[len(published)])), nil
+	}
+	return "", nil
+}
+
+// firstParents returns the first-parent history of the revision, oldest first.
+func (p *PublisherMunger) firstParents(ctx context.Context, rev string) ([]string, error) {
+	out, err := p.command(ctx, "git", "rev-list", "--first-parent", "--reverse", rev).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the commits of %s: %v", rev, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// describeCommit returns the abbreviated SHA and the subject of the commit.
+func (p *PublisherMunger) describeCommit(ctx context.Context, commit string) string {
+	out, err := p.command(ctx, "git", "log", "-1", "--format=%h (%s)", commit).Output()
+	if err != nil {
+		return commit
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompareRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verifyrewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE=2018-05-04T10:30:00Z", "GIT_COMMITTER_DATE=2018-05-04T10:30:00Z")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", ".")
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	commit := func(msgs ...string) {
+		git("checkout", "-q", "--orphan", "tmp")
+		for _, msg := range msgs {
+			git("commit", "-q", "--allow-empty", "-m", msg)
+		}
+		git("branch", "-f", "master", "tmp")
+		git("checkout", "-q", "master")
+		git("branch", "-D", "tmp")
+	}
+	commit("a", "b")
+	git("update-ref", "refs/remotes/origin/master", "master")
+
+	tests := []struct {
+		name     string
+		rebuilt  []string
+		expected string
+	}{
+		{"identical", []string{"a", "b"}, ""},
+		{"different", []string{"a", "b'"}, "commit 2 is "},
+		{"missing", []string{"a"}, "1 published commits are missing, starting with "},
+		{"additional", []string{"a", "b", "c"}, "1 commits were not published, starting with "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commit(tt.rebuilt...)
+			diff, err := (&PublisherMunger{}).compareRewrite(context.Background(), "master")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (tt.expected == "") != (diff == "") || !strings.HasPrefix(diff, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, diff)
+			}
+		})
+	}
+}
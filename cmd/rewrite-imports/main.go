@@ -0,0 +1,212 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"k8s.io/publishing-bot/pkg/rewrite"
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, `Rewrite Go import paths in the git index, e.g. as index filter of
+"git filter-branch":
+
+    git filter-branch --index-filter '%s --prefix staging/src/k8s.io/api --rules "..."'
+
+Import paths starting with one of the <from> prefixes of the rules are replaced
+by the <to> prefix in the .go files below the given prefix, and vendored copies
+of those packages are moved below vendor/<to>. Rewritten blobs are cached by
+blob hash in the git directory, such that each blob is rewritten only once.
+
+With --format, rewritten files are normalized with gofmt or goimports from the
+PATH, e.g. because a longer import path changes the alignment of comments.
+Files the formatter fails on are published unformatted, but not cached.
+
+Usage: %s --rules "<from>=<to> ..." [--prefix <dir>] [--format gofmt|goimports]
+`, os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+}
+
+// indexEntry is a line of "git ls-files -s".
+type indexEntry struct {
+	mode, blob, path string
+}
+
+func main() {
+	rulesFlag := flag.String("rules", "", "whitespace separated <from>=<to> import path prefixes")
+	prefix := flag.String("prefix", "", "the directory in the index to rewrite, e.g. the published source directory")
+	formatter := flag.String("format", "", "normalize rewritten files with gofmt or goimports")
+
+	flag.Usage = Usage
+	flag.Parse()
+
+	rules, err := rewrite.ParseRules(*rulesFlag)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if len(rules) == 0 {
+		return
+	}
+	if *formatter != "" && *formatter != "gofmt" && *formatter != "goimports" {
+		glog.Fatalf("Invalid --format %q, must be gofmt or goimports", *formatter)
+	}
+	dir := strings.Trim(path.Clean("/"+filepath.ToSlash(*prefix)), "/")
+
+	gitDir, err := output("git", "rev-parse", "--git-dir")
+	if err != nil {
+		glog.Fatalf("Failed to find the git directory: %v", err)
+	}
+	cacheKey := rules.String()
+	if *formatter != "" {
+		// the output of the formatter depends on the Go version
+		goVersion, err := output("go", "version")
+		if err != nil {
+			glog.Fatalf("Failed to get the Go version: %v", err)
+		}
+		cacheKey += "\n" + *formatter + "\n" + goVersion
+	}
+	cacheDir := filepath.Join(strings.TrimSpace(gitDir), "rewrite-imports", fmt.Sprintf("%x", sha1.Sum([]byte(cacheKey))))
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		glog.Fatalf("Failed to create cache directory: %v", err)
+	}
+
+	args := []string{"ls-files", "-s", "-z"}
+	if dir != "" {
+		args = append(args, "--", dir)
+	}
+	out, err := output("git", args...)
+	if err != nil {
+		glog.Fatalf("Failed to list the index: %v", err)
+	}
+
+	var updates bytes.Buffer
+	for _, l := range strings.Split(out, "\x00") {
+		// <mode> <blob> <stage>\t<path>
+		ss := strings.SplitN(l, "\t", 2)
+		fields := strings.Fields(ss[0])
+		if len(ss) != 2 || len(fields) != 3 {
+			continue
+		}
+		e := indexEntry{mode: fields[0], blob: fields[1], path: ss[1]}
+		if e.mode == "160000" {
+			continue // submodule
+		}
+
+		rel := e.path
+		if dir != "" {
+			rel = strings.TrimPrefix(e.path, dir+"/")
+		}
+		newPath := e.path
+		if p, ok := rules.VendorPath(rel); ok {
+			newPath = path.Join(dir, p)
+		}
+		newBlob := e.blob
+		if strings.HasSuffix(e.path, ".go") {
+			if newBlob, err = rewriteBlob(cacheDir, rules, *formatter, e.blob); err != nil {
+				glog.Fatalf("Failed to rewrite %s: %v", e.path, err)
+			}
+		}
+		if newPath == e.path && newBlob == e.blob {
+			continue
+		}
+		if newPath != e.path {
+			fmt.Fprintf(&updates, "0 %s\t%s\x00", strings.Repeat("0", 40), e.path)
+		}
+		fmt.Fprintf(&updates, "%s %s\t%s\x00", e.mode, newBlob, newPath)
+	}
+	if updates.Len() == 0 {
+		return
+	}
+	cmd := exec.Command("git", "update-index", "-z", "--index-info")
+	cmd.Stdin = &updates
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		glog.Fatalf("Failed to update the index: %v", err)
+	}
+}
+
+// rewriteBlob returns the hash of the rewritten blob, looked up in the cache
+// directory or written to the object database. Changed blobs are formatted
+// with the formatter, if any. Blobs the formatter fails on are not cached,
+// such that they are formatted once the failure is fixed, e.g. the formatter
+// installed.
+func rewriteBlob(cacheDir string, rules rewrite.Rules, formatter, blob string) (string, error) {
+	cached := filepath.Join(cacheDir, blob)
+	if bs, err := ioutil.ReadFile(cached); err == nil {
+		return strings.TrimSpace(string(bs)), nil
+	}
+
+	src, err := exec.Command("git", "cat-file", "blob", blob).Output()
+	if err != nil {
+		return "", err
+	}
+	newBlob, cache := blob, true
+	if out, changed := rules.Source(src); changed {
+		if formatter != "" {
+			if formatted, err := format(formatter, out); err != nil {
+				glog.Warningf("Publishing blob %s unformatted: %v", blob, err)
+				cache = false
+			} else {
+				out = formatted
+			}
+		}
+		cmd := exec.Command("git", "hash-object", "-w", "--stdin")
+		cmd.Stdin = bytes.NewReader(out)
+		bs, err := cmd.Output()
+		if err != nil {
+			return "", err
+		}
+		newBlob = strings.TrimSpace(string(bs))
+	}
+	if !cache {
+		return newBlob, nil
+	}
+	if err := ioutil.WriteFile(cached, []byte(newBlob), 0644); err != nil {
+		return "", err
+	}
+	return newBlob, nil
+}
+
+// format runs the formatter on the Go source.
+func format(formatter string, src []byte) ([]byte, error) {
+	cmd := exec.Command(formatter)
+	cmd.Stdin = bytes.NewReader(src)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", formatter, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func output(name string, args ...string) (string, error) {
+	bs, err := exec.Command(name, args...).Output()
+	return string(bs), err
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/publishing-bot/pkg/rewrite"
+)
+
+func TestFormat(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt not found")
+	}
+	src := "package a\n\nimport (\n\t\"example.com/widgets\" // widgets\n\t\"fmt\"      // fmt\n)\n"
+	expected := "package a\n\nimport (\n\t\"example.com/widgets\" // widgets\n\t\"fmt\"                 // fmt\n)\n"
+	out, err := format("gofmt", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+	if _, err := format("gofmt", []byte("package a\nfunc {")); err == nil {
+		t.Errorf("expected an error for invalid source")
+	}
+}
+
+func TestRewriteBlobCachesOnlyFormatted(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt not found")
+	}
+	dir, err := ioutil.TempDir("", "rewrite-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	rules, err := rewrite.ParseRules("k8s.io/api=example.com/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		src    string
+		cached bool
+	}{
+		{"package a\n\nimport \"k8s.io/api/core\"\n", true},
+		{"package a\n\nimport \"k8s.io/api/core\"\n\nfunc {\n", false},
+	} {
+		cmd := exec.Command("git", "hash-object", "-w", "--stdin")
+		cmd.Stdin = strings.NewReader(tt.src)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob := strings.TrimSpace(string(out))
+		newBlob, err := rewriteBlob(dir, rules, "gofmt", blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newBlob == blob {
+			t.Errorf("expected %q to be rewritten", tt.src)
+		}
+		if _, err := os.Stat(filepath.Join(dir, blob)); (err == nil) != tt.cached {
+			t.Errorf("expected %q to be cached: %v, got: %v", tt.src, tt.cached, err == nil)
+		}
+	}
+}
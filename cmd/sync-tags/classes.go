@@ -0,0 +1,102 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var versionTagRE = regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)(-(alpha|beta|rc)\.\d+)?$`)
+
+// tagClass returns the class of the given source tag: alpha, beta, rc or final.
+// Tags which are no release versions have no class.
+func tagClass(name string) string {
+	m := versionTagRE.FindStringSubmatch(name)
+	switch {
+	case m == nil:
+		return ""
+	case m[5] == "":
+		return "final"
+	}
+	return m[5]
+}
+
+// semverTag returns the given release tag with its major version replaced, e.g.
+// v0.30.0-alpha.1 for v1.30.0-alpha.1 and major 0, or the empty string if the
+// tag is no release version.
+func semverTag(name string, major int) string {
+	m := versionTagRE.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("v%d.%s.%s%s", major, m[2], m[3], m[4])
+}
+
+// tagClasses is a set of tag classes. The empty set includes all tags.
+type tagClasses map[string]bool
+
+// parseTagClasses parses a comma separated list of tag classes.
+func parseTagClasses(s string) (tagClasses, error) {
+	cs := tagClasses{}
+	for _, c := range strings.Split(s, ",") {
+		switch c = strings.TrimSpace(c); c {
+		case "":
+		case "alpha", "beta", "rc", "final":
+			cs[c] = true
+		default:
+			return nil, fmt.Errorf("unknown tag class %q", c)
+		}
+	}
+	return cs, nil
+}
+
+// includes returns whether the given source tag is of one of the classes.
+func (cs tagClasses) includes(name string) bool {
+	return len(cs) == 0 || cs[tagClass(name)]
+}
+
+// tagFilter selects source tags by regular expressions. A nil include matches
+// all tags, a nil exclude none.
+type tagFilter struct {
+	include, exclude *regexp.Regexp
+}
+
+// parseTagFilter compiles the include and exclude expressions. Empty ones are
+// not applied.
+func parseTagFilter(include, exclude string) (tagFilter, error) {
+	var f tagFilter
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile(include); err != nil {
+			return f, fmt.Errorf("invalid include expression: %v", err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return f, fmt.Errorf("invalid exclude expression: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// includes returns whether the given source tag matches the include and not the
+// exclude expression.
+func (f tagFilter) includes(name string) bool {
+	return (f.include == nil || f.include.MatchString(name)) && (f.exclude == nil || !f.exclude.MatchString(name))
+}
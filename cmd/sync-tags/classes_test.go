@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestTagClasses(t *testing.T) {
+	tests := []struct {
+		name   string
+		class  string
+		semver string
+	}{
+		{"v1.30.0", "final", "v0.30.0"},
+		{"v1.30.0-alpha.1", "alpha", "v0.30.0-alpha.1"},
+		{"v1.30.0-beta.0", "beta", "v0.30.0-beta.0"},
+		{"v1.30.1-rc.2", "rc", "v0.30.1-rc.2"},
+		{"v1.30.0-dev", "", ""},
+		{"kubernetes-1.30.0", "", ""},
+	}
+	for _, tt := range tests {
+		if got := tagClass(tt.name); got != tt.class {
+			t.Errorf("tagClass(%q) = %q, want %q", tt.name, got, tt.class)
+		}
+		if got := semverTag(tt.name, 0); got != tt.semver {
+			t.Errorf("semverTag(%q, 0) = %q, want %q", tt.name, got, tt.semver)
+		}
+	}
+
+	cs, err := parseTagClasses("rc, final")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"v1.30.0": true, "v1.30.0-rc.1": true, "v1.30.0-alpha.1": false, "v1.30.0-dev": false} {
+		if got := cs.includes(name); got != want {
+			t.Errorf("includes(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if all, _ := parseTagClasses(""); !all.includes("v1.30.0-dev") {
+		t.Errorf("expected the empty set to include all tags")
+	}
+	if _, err := parseTagClasses("alpha,stable"); err == nil {
+		t.Errorf("expected an error for an unknown class")
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	f, err := parseTagFilter(`^v1\.2\d\.`, `^test-|-alpha\.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"v1.28.0": true, "v1.29.0-rc.1": true, "v1.29.0-alpha.1": false, "v1.30.0": false, "test-v1.28.0": false} {
+		if got := f.includes(name); got != want {
+			t.Errorf("includes(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if all, _ := parseTagFilter("", ""); !all.includes("test-1") {
+		t.Errorf("expected the empty filter to include all tags")
+	}
+	if _, err := parseTagFilter("v1.(", ""); err == nil {
+		t.Errorf("expected an error for an invalid expression")
+	}
+}
@@ -25,7 +25,6 @@ import (
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/renstrom/dedent"
 	gogit "gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
@@ -47,6 +46,11 @@ Usage: %s --source-remote <remote> --source-branch <source-branch>
           [--origin-branch <branch>]
           [--prefix <tag-prefix>]
           [--push-script <file-path>]
+          [--classes <alpha,beta,rc,final>]
+          [--semver-major <major>]
+          [--include <regexp>] [--exclude <regexp>]
+          [--tag-type annotated|lightweight] [--tag-message <template>] [--tagger bot|source]
+          [--release-notes-url <url-prefix>]
 `, os.Args[0])
 	flag.PrintDefaults()
 }
@@ -54,8 +58,21 @@ Usage: %s --source-remote <remote> --source-branch <source-branch>
 const rfc2822 = "Mon Jan 02 15:04:05 -0700 2006"
 
 var publishingBot = object.Signature{
-	Name:  os.Getenv("GIT_COMMITTER_NAME"),
-	Email: os.Getenv("GIT_COMMITTER_EMAIL"),
+	Name:  gitIdentity("GIT_COMMITTER_NAME", "user.name"),
+	Email: gitIdentity("GIT_COMMITTER_EMAIL", "user.email"),
+}
+
+// gitIdentity returns the environment variable or, if empty, the git config key
+// of the repository in the current directory.
+func gitIdentity(env, key string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	out, err := exec.Command("git", "config", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
 }
 
 func main() {
@@ -65,8 +82,17 @@ func main() {
 	sourceBranch := flag.String("source-branch", "", "the source repo branch (not qualified, just the name; defaults to equal <branch>)")
 	publishBranch := flag.String("branch", "", "a (not qualified) branch name")
 	prefix := flag.String("prefix", "kubernetes-", "a string to put in front of upstream tags")
-	pushScriptPath := flag.String("push-script", "", "git-push command(s) are appended to this file to push the new tags to the origin remote")
-	dependencies := flag.String("dependencies", "", "comma-separated list of repo:branch pairs of dependencies")
+	pushScriptPath := flag.String("push-script", "", "git-push command(s) are appended to this file to push the new tags to the origin remote (or the remote given as first argument)")
+	classesFlag := flag.String("classes", "", "comma-separated list of the source tag classes to sync: alpha, beta, rc and final. Defaults to all tags")
+	semverMajor := flag.Int("semver-major", -1, "if not negative, release tags are additionally synced as semver tags with this major version, e.g. v0.30.0 for v1.30.0")
+	include := flag.String("include", "", "a regular expression of the source tags to sync, matched before the prefix is applied. Defaults to all tags")
+	exclude := flag.String("exclude", "", "a regular expression of source tags not to sync, taking precedence over --include")
+	tagType := flag.String("tag-type", tagTypeAnnotated, "the type of the created tags: annotated or lightweight")
+	tagMessageTemplate := flag.String("tag-message", "", "the text/template of the message of annotated tags with .Tag, .SourceTag, .SourceCommit and .ReleaseNotesURL. Defaults to a Kubernetes release message")
+	tagger := flag.String("tagger", taggerBot, "the tagger of annotated tags: bot for the committer identity of the repo, source for the tagger of the source tag")
+	releaseNotesURL := flag.String("release-notes-url", "", "the release notes URL of source tags without the tag name, e.g. https://github.com/kubernetes/kubernetes/releases/tag/")
+	signoff := flag.String("signoff", "", "add a Signed-off-by trailer with this identity, e.g. \"Bot <bot@example.com>\", to commits fixing dependencies")
+	dependencies := flag.String("dependencies", "", "comma-separated list of repo:branch pairs of dependencies. Dependencies pinned as repo:branch:revision are not bumped to tags")
 
 	flag.Usage = Usage
 	flag.Parse()
@@ -79,10 +105,26 @@ func main() {
 		glog.Fatalf("source-branch cannot be empty")
 	}
 
+	classes, err := parseTagClasses(*classesFlag)
+	if err != nil {
+		glog.Fatalf("Invalid --classes: %v", err)
+	}
+	filter, err := parseTagFilter(*include, *exclude)
+	if err != nil {
+		glog.Fatalf("Invalid tag filter: %v", err)
+	}
+	tagOpts := tagOptions{typ: *tagType, message: *tagMessageTemplate, tagger: *tagger, releaseNotesURL: *releaseNotesURL}
+	if err := tagOpts.validate(); err != nil {
+		glog.Fatalf("Invalid tag options: %v", err)
+	}
+
 	var dependentRepos []string
 	if len(*dependencies) > 0 {
 		for _, pair := range strings.Split(*dependencies, ",") {
 			ps := strings.Split(pair, ":")
+			if len(ps) > 2 && ps[2] != "" {
+				continue // pinned
+			}
 			dependentRepos = append(dependentRepos, ps[0])
 		}
 	}
@@ -172,10 +214,19 @@ func main() {
 	// create or update tags from kTagCommits as local tags with the given prefix
 	createdTags := []string{}
 	for name, kh := range kTagCommits {
+		if !classes.includes(name) || !filter.includes(name) {
+			continue
+		}
 		bName := name
 		if *prefix != "" {
 			bName = *prefix + name[1:] // remove the v
 		}
+		bNames := []string{bName}
+		if *semverMajor >= 0 {
+			if v := semverTag(name, *semverMajor); v != "" {
+				bNames = append(bNames, v)
+			}
+		}
 
 		// ignore non-annotated tags
 		tag, err := r.TagObject(kh)
@@ -196,59 +247,62 @@ func main() {
 			continue
 		}
 
-		// do not override tags (we build master first, i.e. the x.y.z-alpha.0 tag on master will not be created for feature branches)
-		if tagExists(r, bName) {
-			continue
-		}
-
-		// skip if it already exists in origin
-		if _, found := bTagCommits[bName]; found {
-			fmt.Printf("Ignoring already published tag %s.\n", bName)
-			continue
-		}
-
-		// update Godeps.json to point to actual tagged version in the dependencies. This version might differ
-		// from the one currently in Godeps.json because the other repo could have gotten more commit for this
-		// tag, but this repo didn't. Compare https://github.com/kubernetes/publishing-bot/issues/12 for details.
-		if len(dependentRepos) > 0 {
-			fmt.Printf("Checking that Godeps.json points to the actual tags in %s.\n", strings.Join(dependentRepos, ", "))
-			wt, err := r.Worktree()
-			if err != nil {
-				glog.Fatalf("Failed to get working tree: %v", err)
-			}
-			if err := wt.Checkout(&gogit.CheckoutOptions{Hash: bh}); err != nil {
-				glog.Fatalf("Failed to checkout %v: %v", bh, err)
+		for _, bName := range bNames {
+			bh := bh // a Godeps.json fix commit is specific to the tag name
+			// do not override tags (we build master first, i.e. the x.y.z-alpha.0 tag on master will not be created for feature branches)
+			if tagExists(r, bName) {
+				continue
 			}
-			changed, err := updateGodepsJsonWithTaggedDependencies(r, bName, dependentRepos)
-			if err != nil {
-				glog.Fatalf("Failed to update Godeps.json for tag %s: %v", bName, err)
+
+			// skip if it already exists in origin
+			if _, found := bTagCommits[bName]; found {
+				fmt.Printf("Ignoring already published tag %s.\n", bName)
+				continue
 			}
-			if changed {
-				fmt.Printf("Adding extra commit fixing dependencies to point to %s tags.\n", bName)
-				publishingBotNow := publishingBot
-				publishingBotNow.When = time.Now()
-				bh, err = wt.Commit(fmt.Sprintf("Fix Godeps.json to point to %s tags", bName), &gogit.CommitOptions{
-					All:       true,
-					Author:    &publishingBotNow,
-					Committer: &publishingBotNow,
-				})
+
+			// update Godeps.json to point to actual tagged version in the dependencies. This version might differ
+			// from the one currently in Godeps.json because the other repo could have gotten more commit for this
+			// tag, but this repo didn't. Compare https://github.com/kubernetes/publishing-bot/issues/12 for details.
+			if len(dependentRepos) > 0 {
+				fmt.Printf("Checking that Godeps.json points to the actual tags in %s.\n", strings.Join(dependentRepos, ", "))
+				wt, err := r.Worktree()
 				if err != nil {
-					glog.Fatalf("Failed to commit Godeps/Godeps.json changes: %v", err)
+					glog.Fatalf("Failed to get working tree: %v", err)
+				}
+				if err := wt.Checkout(&gogit.CheckoutOptions{Hash: bh}); err != nil {
+					glog.Fatalf("Failed to checkout %v: %v", bh, err)
+				}
+				changed, err := updateGodepsJsonWithTaggedDependencies(r, bName, dependentRepos)
+				if err != nil {
+					glog.Fatalf("Failed to update Godeps.json for tag %s: %v", bName, err)
+				}
+				if changed {
+					fmt.Printf("Adding extra commit fixing dependencies to point to %s tags.\n", bName)
+					// dated like the tag, such that it is the same if the tag is created again
+					publishingBotThen := publishingBot
+					publishingBotThen.When = tag.Tagger.When
+					msg := fmt.Sprintf("Fix Godeps.json to point to %s tags", bName)
+					if *signoff != "" {
+						msg += "\n\nSigned-off-by: " + *signoff
+					}
+					bh, err = wt.Commit(msg, &gogit.CommitOptions{
+						All:       true,
+						Author:    &publishingBotThen,
+						Committer: &publishingBotThen,
+					})
+					if err != nil {
+						glog.Fatalf("Failed to commit Godeps/Godeps.json changes: %v", err)
+					}
 				}
 			}
-		}
-
-		// create prefixed annotated tag
-		fmt.Printf("Tagging %v as %q.\n", bh, bName)
-		err = createAnnotatedTag(bh, bName, tag.Tagger.When, dedent.Dedent(fmt.Sprintf(`
-			Kubernetes release %s
 
-			Based on https://github.com/kubernetes/kubernetes/releases/tag/%s
-			`, name, name)))
-		if err != nil {
-			glog.Fatalf("Failed to create tag %q: %v", bName, err)
+			// create prefixed tag
+			fmt.Printf("Tagging %v as %q.\n", bh, bName)
+			if err := createTag(bh, bName, name, tag, tagOpts); err != nil {
+				glog.Fatalf("Failed to create tag %q: %v", bName, err)
+			}
+			createdTags = append(createdTags, bName)
 		}
-		createdTags = append(createdTags, bName)
 	}
 
 	// write push command for new tags
@@ -258,13 +312,20 @@ func main() {
 			glog.Fatalf("Failed to open push-script %q for appending: %v", *pushScriptPath, err)
 		}
 		defer pushScript.Close()
-		_, err = pushScript.WriteString(fmt.Sprintf("git push origin %s\n", "refs/tags/"+strings.Join(createdTags, " refs/tags/")))
+		_, err = pushScript.WriteString(pushTagsCommand(createdTags))
 		if err != nil {
 			glog.Fatalf("Failed to write to push-script %q: %q", *pushScriptPath, err)
 		}
 	}
 }
 
+// pushTagsCommand returns the line of the push-script pushing the tags to the
+// remote given as first argument, origin by default. The tags are
+// force-pushed only with PUSH_FORCE=true.
+func pushTagsCommand(tags []string) string {
+	return fmt.Sprintf("git push $([ \"${PUSH_FORCE:-}\" = true ] && echo --force) \"${1:-origin}\" %s\n", "refs/tags/"+strings.Join(tags, " refs/tags/"))
+}
+
 func remoteTags(r *gogit.Repository, remote string) (map[string]plumbing.Hash, error) {
 	refs, err := r.Storer.IterReferences()
 	if err != nil {
@@ -306,9 +367,10 @@ func removeRemoteTags(r *gogit.Repository, remotes []string) error {
 	})
 }
 
-func createAnnotatedTag(h plumbing.Hash, name string, date time.Time, message string) error {
+func createAnnotatedTag(h plumbing.Hash, name string, date time.Time, message string, env ...string) error {
 	cmd := exec.Command("git", "tag", "-a", "-m", message, name, h.String())
 	cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_COMMITTER_DATE=%s", date.Format(rfc2822)))
+	cmd.Env = append(cmd.Env, env...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
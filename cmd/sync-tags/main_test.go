@@ -0,0 +1,49 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestPushTagsCommand(t *testing.T) {
+	// git is replaced by echo
+	script := "git() { echo git \"$@\"; }\n" + pushTagsCommand([]string{"v1.0.0", "v1.0.1"})
+	tests := []struct {
+		force string
+		args  []string
+		want  string
+	}{
+		{"", nil, "git push origin refs/tags/v1.0.0 refs/tags/v1.0.1"},
+		{"false", nil, "git push origin refs/tags/v1.0.0 refs/tags/v1.0.1"},
+		{"true", []string{"canary"}, "git push --force canary refs/tags/v1.0.0 refs/tags/v1.0.1"},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("/bin/bash", append([]string{"-c", script, "push-tags"}, tt.args...)...)
+		cmd.Env = append(os.Environ(), "PUSH_FORCE="+tt.force)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(out)); got != tt.want {
+			t.Errorf("PUSH_FORCE=%q: expected %q, got %q", tt.force, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"text/template"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// defaultTagMessage is the message of annotated tags if no template is given.
+const defaultTagMessage = `Kubernetes release {{.SourceTag}}
+
+Based on https://github.com/kubernetes/kubernetes/releases/tag/{{.SourceTag}}
+`
+
+// Tag types and taggers.
+const (
+	tagTypeAnnotated   = "annotated"
+	tagTypeLightweight = "lightweight"
+	taggerBot          = "bot"
+	taggerSource       = "source"
+)
+
+// tagMessageData is passed to the tag message template.
+type tagMessageData struct {
+	// Tag is the name of the published tag.
+	Tag string
+	// SourceTag is the name of the source tag.
+	SourceTag string
+	// SourceCommit is the SHA of the tagged source commit.
+	SourceCommit string
+	// ReleaseNotesURL is the release notes URL of the source tag, if known.
+	ReleaseNotesURL string
+}
+
+// tagMessage renders the message template, or the default one if it is empty.
+func tagMessage(tmpl string, data tagMessageData) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultTagMessage
+	}
+	t, err := template.New("message").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// tagOptions describes how the published tags are created.
+type tagOptions struct {
+	// typ is annotated or lightweight.
+	typ string
+	// message is the template of the message of annotated tags.
+	message string
+	// tagger is bot or source, the identity annotated tags are created with.
+	tagger string
+	// releaseNotesURL is the prefix of the release notes URL of a source tag.
+	releaseNotesURL string
+}
+
+// validate checks the tag type, the tagger and the message template.
+func (o tagOptions) validate() error {
+	switch o.typ {
+	case tagTypeAnnotated, tagTypeLightweight:
+	default:
+		return fmt.Errorf("invalid tag type %q", o.typ)
+	}
+	switch o.tagger {
+	case taggerBot, taggerSource:
+	default:
+		return fmt.Errorf("invalid tagger %q", o.tagger)
+	}
+	_, err := tagMessage(o.message, tagMessageData{})
+	return err
+}
+
+// createTag tags the commit as name for the given source tag. Annotated tags get
+// the date of the source tag, and its tagger with tagger source, such that tags
+// created again have the same object ID.
+func createTag(h plumbing.Hash, name, sourceTag string, tag *object.Tag, opts tagOptions) error {
+	if opts.typ == tagTypeLightweight {
+		cmd := exec.Command("git", "tag", name, h.String())
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	data := tagMessageData{Tag: name, SourceTag: sourceTag, SourceCommit: tag.Target.String()}
+	if opts.releaseNotesURL != "" {
+		data.ReleaseNotesURL = opts.releaseNotesURL + sourceTag
+	}
+	message, err := tagMessage(opts.message, data)
+	if err != nil {
+		return fmt.Errorf("failed to render the message: %v", err)
+	}
+	var env []string
+	if opts.tagger == taggerSource {
+		env = []string{"GIT_COMMITTER_NAME=" + tag.Tagger.Name, "GIT_COMMITTER_EMAIL=" + tag.Tagger.Email}
+	}
+	return createAnnotatedTag(h, name, tag.Tagger.When, message, env...)
+}
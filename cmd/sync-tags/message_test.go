@@ -0,0 +1,60 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestTagMessage(t *testing.T) {
+	data := tagMessageData{Tag: "kubernetes-1.30.0", SourceTag: "v1.30.0", SourceCommit: "0123abc", ReleaseNotesURL: "https://example.com/releases/v1.30.0"}
+	tests := []struct {
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{"", "Kubernetes release v1.30.0\n\nBased on https://github.com/kubernetes/kubernetes/releases/tag/v1.30.0\n", false},
+		{"{{.Tag}} of {{.SourceCommit}}\n\nSee {{.ReleaseNotesURL}}", "kubernetes-1.30.0 of 0123abc\n\nSee https://example.com/releases/v1.30.0", false},
+		{"{{.Unknown}}", "", true},
+		{"{{.Tag", "", true},
+	}
+	for _, tt := range tests {
+		got, err := tagMessage(tt.tmpl, data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error %v", tt.tmpl, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestTagOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts    tagOptions
+		wantErr bool
+	}{
+		{tagOptions{typ: tagTypeAnnotated, tagger: taggerBot}, false},
+		{tagOptions{typ: tagTypeLightweight, tagger: taggerSource, message: "{{.SourceTag}}"}, false},
+		{tagOptions{typ: "signed", tagger: taggerBot}, true},
+		{tagOptions{typ: tagTypeAnnotated, tagger: "author"}, true},
+		{tagOptions{typ: tagTypeAnnotated, tagger: taggerBot, message: "{{.Version}}"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: unexpected error %v", tt.opts, err)
+		}
+	}
+}
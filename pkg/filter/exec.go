@@ -0,0 +1,120 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Exec is a filter running a bash command in a temporary directory with the
+// files of the tree, and applying its changes to the tree. Files are executable
+// as in an ExecutableTree, and changes of the executable bit are applied to it.
+// The arguments are the positional parameters of the command, and GIT_COMMIT is
+// the source commit. Exec filters are slow on large trees because every file is
+// written for every commit; compiled filters only read the files they need.
+type Exec struct {
+	Command string
+	Args    []string
+}
+
+// Filter runs the command on the tree.
+func (e Exec) Filter(commit string, tree Tree) error {
+	dir, err := ioutil.TempDir("", "filter-tree")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := tree.Paths()
+	if err != nil {
+		return err
+	}
+	modes, _ := tree.(ExecutableTree)
+	before := map[string][]byte{}
+	executable := map[string]bool{}
+	for _, p := range paths {
+		content, err := tree.Read(p)
+		if err != nil {
+			return err
+		}
+		before[p] = content
+		file := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		perm := os.FileMode(0644)
+		if modes != nil && modes.Executable(p) {
+			perm, executable[p] = 0755, true
+		}
+		if err := ioutil.WriteFile(file, content, perm); err != nil {
+			return err
+		}
+		// independent of the umask
+		if err := os.Chmod(file, perm); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command("/bin/bash", append([]string{"-ec", e.Command, "filter"}, e.Args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_COMMIT="+commit)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%q failed: %v: %s", e.Command, err, out)
+	}
+
+	after := map[string]bool{}
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		p := filepath.ToSlash(rel)
+		after[p] = true
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if old, found := before[p]; !found || !bytes.Equal(old, content) {
+			if err := tree.Write(p, content); err != nil {
+				return err
+			}
+		}
+		if x := info.Mode()&0111 != 0; modes != nil && x != executable[p] {
+			return modes.SetExecutable(p, x)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if !after[p] {
+			if err := tree.Delete(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package filter runs custom transformations of the tree of every published
+// commit while the source history is rewritten, e.g. to strip build tags or to
+// regenerate files. Filters are compiled into the filter-tree binary and
+// registered by name, or run as commands.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Tree is the tree of the commit being rewritten, below the published
+// directory. Paths are relative to it and slash separated. Symlinks and
+// submodules are not part of the tree.
+type Tree interface {
+	// Paths returns the paths of the files, sorted.
+	Paths() ([]string, error)
+	// Read returns the content of the file.
+	Read(path string) ([]byte, error)
+	// Write replaces the content of the file, or adds it.
+	Write(path string, content []byte) error
+	// Delete removes the file.
+	Delete(path string) error
+}
+
+// ExecutableTree is a Tree keeping the executable bit of the files. Written
+// files keep their bit, new files are not executable.
+type ExecutableTree interface {
+	Tree
+	// Executable returns whether the file is executable.
+	Executable(path string) bool
+	// SetExecutable sets or clears the executable bit of the file.
+	SetExecutable(path string, executable bool) error
+}
+
+// Filter transforms the tree of each rewritten commit. The commit is the hash
+// of the source commit.
+type Filter interface {
+	Filter(commit string, tree Tree) error
+}
+
+// Func is a Filter function.
+type Func func(commit string, tree Tree) error
+
+// Filter calls f.
+func (f Func) Filter(commit string, tree Tree) error {
+	return f(commit, tree)
+}
+
+// Factory creates a filter with the arguments given in the rules.
+type Factory func(args []string) (Filter, error)
+
+var (
+	mutex    sync.RWMutex
+	registry = map[string]Factory{}
+)
+
+// Register makes a filter available under the name in the rules. It is meant
+// to be called from init functions of packages linked into filter-tree.
+func Register(name string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, found := registry[name]; found {
+		panic(fmt.Sprintf("filter %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Registered returns the names of the registered filters, sorted.
+func Registered() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Step is a filter of the rules. Exactly one of Name and Command is set.
+type Step struct {
+	// Name is a registered filter.
+	Name string `json:"name,omitempty"`
+	// Command is a bash command run as Exec filter.
+	Command string `json:"command,omitempty"`
+	// Args are passed to the filter.
+	Args []string `json:"args,omitempty"`
+}
+
+// ParseSteps decodes the steps from JSON. Empty input means no steps.
+func ParseSteps(s string) ([]Step, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var steps []Step
+	if err := json.Unmarshal([]byte(s), &steps); err != nil {
+		return nil, fmt.Errorf("invalid filter steps: %v", err)
+	}
+	return steps, nil
+}
+
+// New returns the filter of the step.
+func New(s Step) (Filter, error) {
+	switch {
+	case s.Name != "" && s.Command == "":
+		mutex.RLock()
+		factory, found := registry[s.Name]
+		mutex.RUnlock()
+		if !found {
+			return nil, fmt.Errorf("unknown filter %q, registered are %v", s.Name, Registered())
+		}
+		return factory(s.Args)
+	case s.Command != "" && s.Name == "":
+		return Exec{Command: s.Command, Args: s.Args}, nil
+	}
+	return nil, fmt.Errorf("exactly one of name and command must be set in filter %+v", s)
+}
+
+// Run runs the filters of the steps in order on the tree of the commit.
+func Run(steps []Step, commit string, tree Tree) error {
+	for i, s := range steps {
+		f, err := New(s)
+		if err != nil {
+			return fmt.Errorf("filter %d: %v", i+1, err)
+		}
+		if err := f.Filter(commit, tree); err != nil {
+			return fmt.Errorf("filter %d failed on %s: %v", i+1, commit, err)
+		}
+	}
+	return nil
+}
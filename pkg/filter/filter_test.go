@@ -0,0 +1,280 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mapTree is a Tree in memory.
+type mapTree map[string]string
+
+func (t mapTree) Paths() ([]string, error) {
+	var paths []string
+	for p := range t {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (t mapTree) Read(p string) ([]byte, error) {
+	content, found := t[p]
+	if !found {
+		return nil, fmt.Errorf("%s not found", p)
+	}
+	return []byte(content), nil
+}
+
+func (t mapTree) Write(p string, content []byte) error {
+	t[p] = string(content)
+	return nil
+}
+
+func (t mapTree) Delete(p string) error {
+	delete(t, p)
+	return nil
+}
+
+func init() {
+	Register("test-upper", func(args []string) (Filter, error) {
+		return Func(func(commit string, tree Tree) error {
+			for _, p := range args {
+				content, err := tree.Read(p)
+				if err != nil {
+					return err
+				}
+				if err := tree.Write(p, []byte(strings.ToUpper(string(content)))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), nil
+	})
+}
+
+// gitRepo creates a git repository in a temporary directory and changes into
+// it. It returns a git helper and a cleanup function.
+func gitRepo(t *testing.T) (func(args ...string) string, func()) {
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return string(out)
+	}
+	git("init", "-q")
+	git("config", "user.name", "test")
+	git("config", "user.email", "test@example.com")
+	return git, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// addObject adds the content to the index with the mode.
+func addObject(t *testing.T, git func(args ...string) string, mode, p, content string) {
+	cmd := exec.Command("git", "hash-object", "-w", "--stdin")
+	cmd.Stdin = strings.NewReader(content)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	git("update-index", "--add", "--cacheinfo", mode+","+strings.TrimSpace(string(out))+","+p)
+}
+
+func TestRun(t *testing.T) {
+	tree := mapTree{"README.md": "readme", "pkg/a.go": "package a", "OWNERS": "owners"}
+	steps, err := ParseSteps(`[
+		{"name": "test-upper", "args": ["README.md"]},
+		{"command": "rm \"$1\"; echo $GIT_COMMIT > pkg/COMMIT; cat README.md > pkg/b.go", "args": ["OWNERS"]}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Run(steps, "abc", tree); err != nil {
+		t.Fatal(err)
+	}
+	expected := mapTree{"README.md": "README", "pkg/a.go": "package a", "pkg/COMMIT": "abc\n", "pkg/b.go": "README"}
+	if !reflect.DeepEqual(tree, expected) {
+		t.Errorf("expected %v, got %v", expected, tree)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, s := range []Step{
+		{},
+		{Name: "test-upper", Command: "true"},
+		{Name: "unknown"},
+	} {
+		if _, err := New(s); err == nil {
+			t.Errorf("expected an error for %+v", s)
+		}
+	}
+	if err := Run([]Step{{Command: "exit 1"}}, "abc", mapTree{}); err == nil || !strings.Contains(err.Error(), "filter 1 failed on abc") {
+		t.Errorf("expected the failing command to fail, got %v", err)
+	}
+}
+
+func TestIndexTree(t *testing.T) {
+	git, cleanup := gitRepo(t)
+	defer cleanup()
+	for p, content := range map[string]string{"staging/api/a.go": "a", "staging/api/OWNERS": "o", "README.md": "r"} {
+		addObject(t, git, "100644", p, content)
+	}
+
+	tree, err := NewIndexTree("staging/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := tree.Paths(); !reflect.DeepEqual(paths, []string{"OWNERS", "a.go"}) {
+		t.Errorf("unexpected paths %v", paths)
+	}
+	if err := tree.Delete("OWNERS"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Write("b.go", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := git("ls-files"), "README.md\nstaging/api/a.go\nstaging/api/b.go\n"; got != expected {
+		t.Errorf("expected index %q, got %q", expected, got)
+	}
+	if got := git("cat-file", "blob", ":staging/api/b.go"); got != "b" {
+		t.Errorf("expected b.go with content b, got %q", got)
+	}
+}
+
+func TestModes(t *testing.T) {
+	git, cleanup := gitRepo(t)
+	defer cleanup()
+	addObject(t, git, "100755", "staging/api/hack/update.sh", "#!/bin/bash")
+	addObject(t, git, "100755", "staging/api/hack/verify.sh", "#!/bin/bash")
+	addObject(t, git, "100644", "staging/api/a.go", "package api")
+
+	tree, err := NewIndexTree("staging/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tree.Executable("hack/update.sh") || tree.Executable("a.go") {
+		t.Errorf("expected only the scripts to be executable")
+	}
+	steps := []Step{
+		{Command: "echo '# changed' >> hack/update.sh; chmod -x hack/verify.sh; echo >> a.go; echo '#!/bin/bash' > hack/new.sh; chmod +x hack/new.sh"},
+		{Command: "test -x hack/update.sh && test -x hack/new.sh && test ! -x hack/verify.sh && test ! -x a.go"},
+	}
+	if err := Run(steps, "abc", tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"staging/api/a.go":           "100644",
+		"staging/api/hack/new.sh":    "100755",
+		"staging/api/hack/update.sh": "100755",
+		"staging/api/hack/verify.sh": "100644",
+	}
+	for _, l := range strings.Split(strings.TrimSpace(git("ls-files", "-s")), "\n") {
+		fields := strings.Fields(l)
+		if mode := expected[fields[3]]; mode != fields[0] {
+			t.Errorf("expected %s with mode %s, got %s", fields[3], mode, fields[0])
+		}
+	}
+}
+
+// TestModesFilterBranch checks that executable files and symlinks keep their
+// modes when rewrite-imports and filter-tree run as index filters of git
+// filter-branch.
+func TestModesFilterBranch(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	bin, err := ioutil.TempDir("", "filter-bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bin)
+	for _, name := range []string{"rewrite-imports", "filter-tree"} {
+		if out, err := exec.Command("go", "build", "-o", filepath.Join(bin, name), "k8s.io/publishing-bot/cmd/"+name).CombinedOutput(); err != nil {
+			t.Fatalf("failed to build %s: %v: %s", name, err, out)
+		}
+	}
+
+	git, cleanup := gitRepo(t)
+	defer cleanup()
+	addObject(t, git, "100644", "staging/api/a.go", "package api\n\nimport _ \"example.com/old/b\"\n")
+	addObject(t, git, "100755", "staging/api/hack/update.sh", "#!/bin/bash\n")
+	addObject(t, git, "120000", "staging/api/link.go", "a.go")
+	git("commit", "-q", "-m", "initial")
+	// filter-branch requires a clean work tree
+	git("reset", "-q", "--hard")
+
+	indexFilter := fmt.Sprintf("%s --prefix staging/api --rules example.com/old=example.com/new && %s --prefix staging/api",
+		filepath.Join(bin, "rewrite-imports"), filepath.Join(bin, "filter-tree"))
+	cmd := exec.Command("git", "filter-branch", "-f", "--index-filter", indexFilter, "HEAD")
+	cmd.Env = append(os.Environ(), "FILTER_BRANCH_SQUELCH_WARNING=1", `PUBLISHER_BOT_FILTERS=[{"command": "echo >> hack/update.sh"}]`)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("filter-branch failed: %v: %s", err, out)
+	}
+
+	expected := map[string]string{
+		"staging/api/a.go":           "100644",
+		"staging/api/hack/update.sh": "100755",
+		"staging/api/link.go":        "120000",
+	}
+	got := map[string]string{}
+	for _, l := range strings.Split(strings.TrimSpace(git("ls-tree", "-r", "HEAD")), "\n") {
+		// <mode> <type> <object>\t<path>
+		fields := strings.Fields(l)
+		got[fields[3]] = fields[0]
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected modes %v, got %v", expected, got)
+	}
+	if content := git("cat-file", "blob", "HEAD:staging/api/a.go"); !strings.Contains(content, "example.com/new/b") {
+		t.Errorf("expected the rewritten import in a.go, got %q", content)
+	}
+	if content := git("cat-file", "blob", "HEAD:staging/api/hack/update.sh"); content != "#!/bin/bash\n\n" {
+		t.Errorf("expected the filtered update.sh, got %q", content)
+	}
+	if target := git("cat-file", "blob", "HEAD:staging/api/link.go"); target != "a.go" {
+		t.Errorf("expected the symlink to a.go, got %q", target)
+	}
+}
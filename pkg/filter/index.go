@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IndexTree is the tree of the git index in the current directory below a
+// prefix, e.g. during "git filter-branch --index-filter". Changes are written
+// to the object database right away and to the index by Flush.
+type IndexTree struct {
+	prefix string
+	// blobs and modes of the files by relative path
+	blobs map[string]string
+	modes map[string]string
+	// updates are the pending lines of "git update-index --index-info"
+	updates bytes.Buffer
+}
+
+// NewIndexTree reads the regular files of the index below the prefix.
+func NewIndexTree(prefix string) (*IndexTree, error) {
+	t := &IndexTree{
+		prefix: strings.Trim(path.Clean("/"+prefix), "/"),
+		blobs:  map[string]string{},
+		modes:  map[string]string{},
+	}
+	args := []string{"ls-files", "-s", "-z"}
+	if t.prefix != "" {
+		args = append(args, "--", t.prefix)
+	}
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the index: %v", err)
+	}
+	for _, l := range strings.Split(string(out), "\x00") {
+		// <mode> <blob> <stage>\t<path>
+		ss := strings.SplitN(l, "\t", 2)
+		fields := strings.Fields(ss[0])
+		if len(ss) != 2 || len(fields) != 3 {
+			continue
+		}
+		if mode := fields[0]; mode == "100644" || mode == "100755" {
+			rel := ss[1]
+			if t.prefix != "" {
+				rel = strings.TrimPrefix(rel, t.prefix+"/")
+			}
+			t.blobs[rel], t.modes[rel] = fields[1], mode
+		}
+	}
+	return t, nil
+}
+
+// Paths returns the paths of the files, sorted.
+func (t *IndexTree) Paths() ([]string, error) {
+	paths := make([]string, 0, len(t.blobs))
+	for p := range t.blobs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Read returns the content of the file.
+func (t *IndexTree) Read(p string) ([]byte, error) {
+	blob, found := t.blobs[p]
+	if !found {
+		return nil, fmt.Errorf("%s not found", p)
+	}
+	return exec.Command("git", "cat-file", "blob", blob).Output()
+}
+
+// Write writes the content into the object database and records the update.
+// New files are not executable.
+func (t *IndexTree) Write(p string, content []byte) error {
+	cmd := exec.Command("git", "hash-object", "-w", "--stdin")
+	cmd.Stdin = bytes.NewReader(content)
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", p, err)
+	}
+	mode, found := t.modes[p]
+	if !found {
+		mode = "100644"
+	}
+	t.blobs[p], t.modes[p] = strings.TrimSpace(string(out)), mode
+	fmt.Fprintf(&t.updates, "%s %s\t%s\x00", mode, t.blobs[p], t.fullPath(p))
+	return nil
+}
+
+// Executable returns whether the file is executable.
+func (t *IndexTree) Executable(p string) bool {
+	return t.modes[p] == "100755"
+}
+
+// SetExecutable sets or clears the executable bit of the file and records the
+// update.
+func (t *IndexTree) SetExecutable(p string, executable bool) error {
+	if _, found := t.blobs[p]; !found {
+		return fmt.Errorf("%s not found", p)
+	}
+	mode := "100644"
+	if executable {
+		mode = "100755"
+	}
+	if t.modes[p] != mode {
+		t.modes[p] = mode
+		fmt.Fprintf(&t.updates, "%s %s\t%s\x00", mode, t.blobs[p], t.fullPath(p))
+	}
+	return nil
+}
+
+// Delete records the removal of the file.
+func (t *IndexTree) Delete(p string) error {
+	if _, found := t.blobs[p]; !found {
+		return fmt.Errorf("%s not found", p)
+	}
+	delete(t.blobs, p)
+	delete(t.modes, p)
+	fmt.Fprintf(&t.updates, "0 %s\t%s\x00", strings.Repeat("0", 40), t.fullPath(p))
+	return nil
+}
+
+// Flush writes the recorded changes into the index.
+func (t *IndexTree) Flush() error {
+	if t.updates.Len() == 0 {
+		return nil
+	}
+	cmd := exec.Command("git", "update-index", "-z", "--index-info")
+	cmd.Stdin = &t.updates
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update the index: %v: %s", err, out)
+	}
+	t.updates.Reset()
+	return nil
+}
+
+func (t *IndexTree) fullPath(p string) string {
+	if t.prefix == "" {
+		return p
+	}
+	return t.prefix + "/" + p
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Submodule modes: gitlinks below the published directory are dropped, kept
+// with a .gitmodules file in the published directory, or replaced by the tree
+// of the submodule commit.
+const (
+	SubmodulesDrop        = "drop"
+	SubmodulesPreserve    = "preserve"
+	SubmodulesMaterialize = "materialize"
+)
+
+// submoduleRefPrefix is where the branches of materialized submodules are
+// fetched to, such that their objects are kept.
+const submoduleRefPrefix = "refs/publisher-submodules/"
+
+// Submodules publishes the submodules below a prefix of the git index in the
+// current directory.
+type Submodules struct {
+	// Mode is drop, preserve or materialize.
+	Mode string
+	// URLs rewrites URL prefixes of the submodules, the longest first, in the
+	// published .gitmodules and for fetching materialized submodules.
+	URLs map[string]string
+}
+
+// ParseURLRewrites parses space separated <from>=<to> URL prefix rewrites.
+func ParseURLRewrites(s string) (map[string]string, error) {
+	urls := map[string]string{}
+	for _, r := range strings.Fields(s) {
+		ss := strings.SplitN(r, "=", 2)
+		if len(ss) != 2 || ss[0] == "" {
+			return nil, fmt.Errorf("invalid URL rewrite %q, expected <from>=<to>", r)
+		}
+		urls[ss[0]] = ss[1]
+	}
+	return urls, nil
+}
+
+// RewriteURL replaces the longest matching prefix of the URL.
+func (s Submodules) RewriteURL(url string) string {
+	from := ""
+	for f := range s.URLs {
+		if strings.HasPrefix(url, f) && len(f) > len(from) {
+			from = f
+		}
+	}
+	if from == "" {
+		return url
+	}
+	return s.URLs[from] + url[len(from):]
+}
+
+// submodule is an entry of .gitmodules.
+type submodule struct {
+	name string
+	// settings are the keys below submodule.<name>, e.g. path and url
+	settings map[string]string
+}
+
+// Apply publishes the gitlinks of the index below the prefix according to the
+// mode. The commit is the source commit, for errors.
+func (s Submodules) Apply(commit, prefix string) error {
+	switch s.Mode {
+	case SubmodulesDrop, SubmodulesPreserve, SubmodulesMaterialize:
+	default:
+		return fmt.Errorf("invalid submodule mode %q", s.Mode)
+	}
+	prefix = strings.Trim(path.Clean("/"+prefix), "/")
+	gitlinks, err := indexGitlinks(prefix)
+	if err != nil || len(gitlinks) == 0 {
+		return err
+	}
+	modules, err := indexSubmodules()
+	if err != nil {
+		return err
+	}
+
+	var updates bytes.Buffer
+	var paths []string
+	for p := range gitlinks {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	switch s.Mode {
+	case SubmodulesDrop:
+		for _, p := range paths {
+			fmt.Fprintf(&updates, "0 %s\t%s\x00", strings.Repeat("0", 40), p)
+		}
+	case SubmodulesPreserve:
+		content := ""
+		for _, p := range paths {
+			m, found := modules[p]
+			if !found {
+				return fmt.Errorf("submodule %s of %s is not in .gitmodules", p, commit)
+			}
+			content += s.render(m, relative(prefix, p))
+		}
+		blob, err := hashObject([]byte(content))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&updates, "100644 %s\t%s\x00", blob, path.Join(prefix, ".gitmodules"))
+	case SubmodulesMaterialize:
+		for _, p := range paths {
+			m, found := modules[p]
+			if !found {
+				return fmt.Errorf("submodule %s of %s is not in .gitmodules", p, commit)
+			}
+			if err := s.ensureCommit(m, gitlinks[p]); err != nil {
+				return fmt.Errorf("submodule %s of %s: %v", p, commit, err)
+			}
+			out, err := exec.Command("git", "ls-tree", "-r", "-z", gitlinks[p]).Output()
+			if err != nil {
+				return fmt.Errorf("failed to list submodule %s at %s: %v", p, gitlinks[p], err)
+			}
+			fmt.Fprintf(&updates, "0 %s\t%s\x00", strings.Repeat("0", 40), p)
+			for _, l := range strings.Split(string(out), "\x00") {
+				// <mode> <type> <object>\t<path>
+				ss := strings.SplitN(l, "\t", 2)
+				fields := strings.Fields(ss[0])
+				if len(ss) != 2 || len(fields) != 3 {
+					continue
+				}
+				fmt.Fprintf(&updates, "%s %s\t%s\x00", fields[0], fields[2], path.Join(p, ss[1]))
+			}
+		}
+	}
+
+	cmd := exec.Command("git", "update-index", "-z", "--index-info")
+	cmd.Stdin = &updates
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update the index: %v: %s", err, out)
+	}
+	return nil
+}
+
+// render returns the .gitmodules section of the submodule at the path, with the
+// URL rewritten.
+func (s Submodules) render(m submodule, p string) string {
+	keys := []string{}
+	for k := range m.settings {
+		if k != "path" && k != "url" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	section := fmt.Sprintf("[submodule %q]\n\tpath = %s\n\turl = %s\n", m.name, p, s.RewriteURL(m.settings["url"]))
+	for _, k := range keys {
+		section += fmt.Sprintf("\t%s = %s\n", k, m.settings[k])
+	}
+	return section
+}
+
+// ensureCommit fetches the branches of the submodule into the repository if
+// the commit is missing, and the commit itself if it is not on a branch.
+func (s Submodules) ensureCommit(m submodule, commit string) error {
+	if exec.Command("git", "cat-file", "-e", commit+"^{commit}").Run() == nil {
+		return nil
+	}
+	url := s.RewriteURL(m.settings["url"])
+	if url == "" || strings.HasPrefix(url, "./") || strings.HasPrefix(url, "../") {
+		return fmt.Errorf("cannot fetch commit %s from URL %q, rewrite relative URLs to absolute ones", commit, url)
+	}
+	refs := "+refs/heads/*:" + submoduleRefPrefix + m.name + "/*"
+	if out, err := exec.Command("git", "fetch", "-q", "--no-tags", url, refs).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to fetch %s: %v: %s", url, err, out)
+	}
+	if exec.Command("git", "cat-file", "-e", commit+"^{commit}").Run() == nil {
+		return nil
+	}
+	if out, err := exec.Command("git", "fetch", "-q", "--no-tags", url, commit+":"+submoduleRefPrefix+m.name+"/"+commit).CombinedOutput(); err != nil {
+		return fmt.Errorf("commit %s not found in %s: %v: %s", commit, url, err, out)
+	}
+	return nil
+}
+
+// indexGitlinks returns the commits of the gitlinks of the index below the
+// prefix by path.
+func indexGitlinks(prefix string) (map[string]string, error) {
+	args := []string{"ls-files", "-s", "-z"}
+	if prefix != "" {
+		args = append(args, "--", prefix)
+	}
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the index: %v", err)
+	}
+	gitlinks := map[string]string{}
+	for _, l := range strings.Split(string(out), "\x00") {
+		// <mode> <object> <stage>\t<path>
+		ss := strings.SplitN(l, "\t", 2)
+		fields := strings.Fields(ss[0])
+		if len(ss) == 2 && len(fields) == 3 && fields[0] == "160000" {
+			gitlinks[ss[1]] = fields[1]
+		}
+	}
+	return gitlinks, nil
+}
+
+// indexSubmodules returns the submodules of the .gitmodules file at the root of
+// the index by path.
+func indexSubmodules() (map[string]submodule, error) {
+	modules := map[string]submodule{}
+	if exec.Command("git", "cat-file", "-e", ":.gitmodules").Run() != nil {
+		return modules, nil
+	}
+	out, err := exec.Command("git", "config", "-z", "--blob", ":.gitmodules", "--list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .gitmodules: %v", err)
+	}
+	byName := map[string]map[string]string{}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// submodule.<name>.<key>\n<value>
+		kv := strings.SplitN(entry, "\n", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], "submodule.") {
+			continue
+		}
+		i := strings.LastIndex(kv[0], ".")
+		name, key := kv[0][len("submodule."):i], kv[0][i+1:]
+		if byName[name] == nil {
+			byName[name] = map[string]string{}
+		}
+		byName[name][key] = kv[1]
+	}
+	for name, settings := range byName {
+		if p := settings["path"]; p != "" {
+			modules[p] = submodule{name: name, settings: settings}
+		}
+	}
+	return modules, nil
+}
+
+// relative returns the path relative to the prefix.
+func relative(prefix, p string) string {
+	if prefix == "" {
+		return p
+	}
+	return strings.TrimPrefix(p, prefix+"/")
+}
+
+// hashObject writes the content into the object database.
+func hashObject(content []byte) (string, error) {
+	cmd := exec.Command("git", "hash-object", "-w", "--stdin")
+	cmd.Stdin = bytes.NewReader(content)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to write object: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
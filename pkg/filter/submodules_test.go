@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteURL(t *testing.T) {
+	s := Submodules{URLs: map[string]string{
+		"https://git.internal/":        "https://github.com/example/",
+		"https://git.internal/vendor/": "https://github.com/vendor/",
+	}}
+	for url, expected := range map[string]string{
+		"https://git.internal/lib.git":        "https://github.com/example/lib.git",
+		"https://git.internal/vendor/lib.git": "https://github.com/vendor/lib.git",
+		"https://example.com/lib.git":         "https://example.com/lib.git",
+	} {
+		if got := s.RewriteURL(url); got != expected {
+			t.Errorf("RewriteURL(%q) = %q, expected %q", url, got, expected)
+		}
+	}
+	if _, err := ParseURLRewrites("a=b =c"); err == nil {
+		t.Errorf("expected an error for an empty prefix")
+	}
+}
+
+func TestSubmodules(t *testing.T) {
+	git, cleanup := gitRepo(t)
+	defer cleanup()
+	// the submodule commit, in the same repository to be materialized
+	addObject(t, git, "100644", "lib.go", "package lib")
+	git("commit", "-q", "-m", "lib")
+	lib := strings.TrimSpace(git("rev-parse", "HEAD"))
+
+	setup := func() {
+		git("read-tree", "--empty")
+		addObject(t, git, "100644", "staging/api/a.go", "package api")
+		addObject(t, git, "100644", ".gitmodules", "[submodule \"lib\"]\n\tpath = staging/api/third_party/lib\n\turl = https://git.internal/lib.git\n\tbranch = main\n")
+		git("update-index", "--add", "--cacheinfo", "160000,"+lib+",staging/api/third_party/lib")
+	}
+	s := Submodules{URLs: map[string]string{"https://git.internal/": "https://github.com/example/"}}
+
+	setup()
+	s.Mode = SubmodulesDrop
+	if err := s.Apply("abc", "staging/api"); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := git("ls-files", "staging/api"), "staging/api/a.go\n"; got != expected {
+		t.Errorf("drop: expected index %q, got %q", expected, got)
+	}
+
+	setup()
+	s.Mode = SubmodulesPreserve
+	if err := s.Apply("abc", "staging/api"); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := git("ls-files", "-s", "staging/api/third_party/lib"), "160000 "+lib+" 0\tstaging/api/third_party/lib\n"; got != expected {
+		t.Errorf("preserve: expected gitlink %q, got %q", expected, got)
+	}
+	expected := "[submodule \"lib\"]\n\tpath = third_party/lib\n\turl = https://github.com/example/lib.git\n\tbranch = main\n"
+	if got := git("cat-file", "blob", ":staging/api/.gitmodules"); got != expected {
+		t.Errorf("preserve: expected .gitmodules %q, got %q", expected, got)
+	}
+
+	setup()
+	s.Mode = SubmodulesMaterialize
+	if err := s.Apply("abc", "staging/api"); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := git("ls-files", "staging/api"), "staging/api/a.go\nstaging/api/third_party/lib/lib.go\n"; got != expected {
+		t.Errorf("materialize: expected index %q, got %q", expected, got)
+	}
+
+	s.Mode = "flatten"
+	if err := s.Apply("abc", "staging/api"); err == nil {
+		t.Errorf("expected an error for an invalid mode")
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Symlink policies for symlinks below the published directory pointing outside
+// of it, which dangle in the destination repo: fail the rewrite, drop them, or
+// materialize them as copies of their targets.
+const (
+	SymlinksError       = "error"
+	SymlinksDrop        = "drop"
+	SymlinksMaterialize = "materialize"
+)
+
+// maxSymlinkHops limits the symlinks followed to materialize a symlink.
+const maxSymlinkHops = 10
+
+// Symlinks applies a symlink policy to the git index in the current directory.
+type Symlinks struct {
+	// Policy is error, drop or materialize.
+	Policy string
+}
+
+// indexEntry is a file, symlink or gitlink of the index.
+type indexEntry struct {
+	mode, object string
+}
+
+// Apply handles the symlinks below the prefix pointing outside of it according
+// to the policy. The commit is the source commit, for errors.
+func (s Symlinks) Apply(commit, prefix string) error {
+	switch s.Policy {
+	case SymlinksError, SymlinksDrop, SymlinksMaterialize:
+	default:
+		return fmt.Errorf("invalid symlink policy %q", s.Policy)
+	}
+	prefix = strings.Trim(path.Clean("/"+prefix), "/")
+	entries, err := indexEntries()
+	if err != nil {
+		return err
+	}
+	var links []string
+	for p, e := range entries {
+		if e.mode == "120000" && within(prefix, p) {
+			links = append(links, p)
+		}
+	}
+	sort.Strings(links)
+
+	var updates bytes.Buffer
+	for _, p := range links {
+		target, err := readBlob(entries[p].object)
+		if err != nil {
+			return err
+		}
+		resolved, inRepo := resolveLink(p, target)
+		if inRepo && within(prefix, resolved) {
+			continue
+		}
+		switch s.Policy {
+		case SymlinksError:
+			return fmt.Errorf("symlink %s of %s points outside of %s to %s", p, commit, prefix, target)
+		case SymlinksDrop:
+			fmt.Fprintf(&updates, "0 %s\t%s\x00", strings.Repeat("0", 40), p)
+		case SymlinksMaterialize:
+			files, err := materialize(entries, p)
+			if err != nil {
+				return fmt.Errorf("cannot materialize symlink %s of %s to %s: %v", p, commit, target, err)
+			}
+			fmt.Fprintf(&updates, "0 %s\t%s\x00", strings.Repeat("0", 40), p)
+			var paths []string
+			for f := range files {
+				paths = append(paths, f)
+			}
+			sort.Strings(paths)
+			for _, f := range paths {
+				fmt.Fprintf(&updates, "%s %s\t%s\x00", files[f].mode, files[f].object, f)
+			}
+		}
+	}
+	if updates.Len() == 0 {
+		return nil
+	}
+	cmd := exec.Command("git", "update-index", "-z", "--index-info")
+	cmd.Stdin = &updates
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update the index: %v: %s", err, out)
+	}
+	return nil
+}
+
+// materialize returns the files replacing the symlink at the path: a copy of
+// the target file, or of the files of the target directory below the path.
+func materialize(entries map[string]indexEntry, p string) (map[string]indexEntry, error) {
+	target := p
+	for hops := 0; ; hops++ {
+		e, found := entries[target]
+		if !found || e.mode != "120000" {
+			break
+		}
+		if hops == maxSymlinkHops {
+			return nil, fmt.Errorf("too many levels of symlinks")
+		}
+		link, err := readBlob(e.object)
+		if err != nil {
+			return nil, err
+		}
+		resolved, inRepo := resolveLink(target, link)
+		if !inRepo {
+			return nil, fmt.Errorf("the target is outside of the repository")
+		}
+		target = resolved
+	}
+	if e, found := entries[target]; found {
+		return map[string]indexEntry{p: e}, nil
+	}
+	files := map[string]indexEntry{}
+	for f, e := range entries {
+		if strings.HasPrefix(f, target+"/") {
+			files[p+f[len(target):]] = e
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("the target %s does not exist", target)
+	}
+	return files, nil
+}
+
+// resolveLink returns the repository path the symlink at the path points to,
+// and false if it points outside of the repository.
+func resolveLink(p, target string) (string, bool) {
+	if path.IsAbs(target) {
+		return "", false
+	}
+	resolved := path.Join(path.Dir(p), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", false
+	}
+	return resolved, true
+}
+
+// within returns whether the path is below the prefix.
+func within(prefix, p string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// indexEntries returns the entries of the index by path.
+func indexEntries() (map[string]indexEntry, error) {
+	out, err := exec.Command("git", "ls-files", "-s", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the index: %v", err)
+	}
+	entries := map[string]indexEntry{}
+	for _, l := range strings.Split(string(out), "\x00") {
+		// <mode> <object> <stage>\t<path>
+		ss := strings.SplitN(l, "\t", 2)
+		fields := strings.Fields(ss[0])
+		if len(ss) == 2 && len(fields) == 3 {
+			entries[ss[1]] = indexEntry{mode: fields[0], object: fields[1]}
+		}
+	}
+	return entries, nil
+}
+
+// readBlob returns the content of the blob.
+func readBlob(object string) (string, error) {
+	out, err := exec.Command("git", "cat-file", "blob", object).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", object, err)
+	}
+	return string(out), nil
+}
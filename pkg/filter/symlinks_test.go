@@ -0,0 +1,74 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSymlinks(t *testing.T) {
+	git, cleanup := gitRepo(t)
+	defer cleanup()
+
+	setup := func() {
+		git("read-tree", "--empty")
+		addObject(t, git, "100644", "staging/api/a.go", "package api")
+		addObject(t, git, "120000", "staging/api/inside", "a.go")
+		addObject(t, git, "100755", "hack/verify.sh", "#!/bin/bash")
+		addObject(t, git, "100644", "hack/lib/util.sh", "util")
+		addObject(t, git, "120000", "staging/api/hack/verify.sh", "../../../hack/verify.sh")
+		addObject(t, git, "120000", "staging/api/hack/lib", "../../../hack/lib")
+	}
+
+	setup()
+	err := Symlinks{Policy: SymlinksError}.Apply("abc", "staging/api")
+	if err == nil || !strings.Contains(err.Error(), "symlink staging/api/hack/lib of abc points outside of staging/api") {
+		t.Errorf("expected an error for the outside symlink, got %v", err)
+	}
+
+	setup()
+	if err := (Symlinks{Policy: SymlinksDrop}).Apply("abc", "staging/api"); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := git("ls-files", "staging/api"), "staging/api/a.go\nstaging/api/inside\n"; got != expected {
+		t.Errorf("drop: expected index %q, got %q", expected, got)
+	}
+
+	setup()
+	if err := (Symlinks{Policy: SymlinksMaterialize}).Apply("abc", "staging/api"); err != nil {
+		t.Fatal(err)
+	}
+	got := git("ls-files", "-s", "staging/api")
+	for _, expected := range []string{"120000 ", "\tstaging/api/inside\n", "100755 ", "\tstaging/api/hack/verify.sh\n", "\tstaging/api/hack/lib/util.sh\n"} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("materialize: expected %q in the index %q", expected, got)
+		}
+	}
+	if strings.Contains(got, "120000 "+strings.TrimSpace(git("rev-parse", ":staging/api/hack/verify.sh"))) {
+		t.Errorf("materialize: expected the symlink to be replaced, got %q", got)
+	}
+	if got := git("cat-file", "blob", ":staging/api/hack/verify.sh"); got != "#!/bin/bash" {
+		t.Errorf("materialize: expected the content of the target, got %q", got)
+	}
+
+	git("read-tree", "--empty")
+	addObject(t, git, "120000", "staging/api/passwd", "/etc/passwd")
+	if err := (Symlinks{Policy: SymlinksMaterialize}).Apply("abc", "staging/api"); err == nil {
+		t.Errorf("expected an error materializing an absolute symlink")
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// syntheticHistories returns an in-memory repo with a source mainline of n
+// merges of one feature commit each, and a destination mainline with one commit
+// per source merge pointing back to it. The first-parent lists are newest first.
+func syntheticHistories(b *testing.B, n int) (*gogit.Repository, []*object.Commit, []*object.Commit) {
+	r, err := gogit.Init(memory.NewStorage(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	when := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(msg string, parents ...plumbing.Hash) *object.Commit {
+		when = when.Add(time.Minute)
+		sig := object.Signature{Name: "bench", Email: "bench@example.com", When: when}
+		c := &object.Commit{Author: sig, Committer: sig, Message: msg, ParentHashes: parents}
+		obj := r.Storer.NewEncodedObject()
+		if err := c.Encode(obj); err != nil {
+			b.Fatal(err)
+		}
+		h, err := r.Storer.SetEncodedObject(obj)
+		if err != nil {
+			b.Fatal(err)
+		}
+		c.Hash = h
+		return c
+	}
+
+	src := []*object.Commit{commit("initial")}
+	dst := []*object.Commit{commit("initial\n\nKubernetes-commit: " + src[0].Hash.String())}
+	for i := 1; i < n; i++ {
+		prev := src[len(src)-1]
+		feature := commit(fmt.Sprintf("feature %d", i), prev.Hash)
+		merge := commit(fmt.Sprintf("Merge %d", i), prev.Hash, feature.Hash)
+		src = append(src, merge)
+		dst = append(dst, commit(fmt.Sprintf("feature %d\n\nKubernetes-commit: %s", i, feature.Hash), dst[len(dst)-1].Hash))
+	}
+
+	reverse := func(cs []*object.Commit) []*object.Commit {
+		for i, j := 0, len(cs)-1; i < j; i, j = i+1, j-1 {
+			cs[i], cs[j] = cs[j], cs[i]
+		}
+		return cs
+	}
+	return r, reverse(src), reverse(dst)
+}
+
+func BenchmarkSourceCommitToDstCommits(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("commits=%d", n), func(b *testing.B) {
+			r, src, dst := syntheticHistories(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m, err := SourceCommitToDstCommits(r, "Kubernetes-commit", dst, src)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(m) != n {
+					b.Fatalf("expected %d mapped source commits, got %d", n, len(m))
+				}
+			}
+		})
+	}
+}
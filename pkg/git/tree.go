@@ -0,0 +1,121 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// TreeDiff compares the files of two trees and returns the differences as
+// sorted lines "A <path>", "D <path>" and "M <path>" for files only in to, only
+// in from or with different content or mode. Paths for which ignore returns
+// true are skipped.
+func TreeDiff(from, to *object.Tree, ignore func(path string) bool) ([]string, error) {
+	fromFiles, err := treeFiles(from, ignore)
+	if err != nil {
+		return nil, err
+	}
+	toFiles, err := treeFiles(to, ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	var diff []string
+	for pth, f := range fromFiles {
+		t, found := toFiles[pth]
+		if !found {
+			diff = append(diff, "D "+pth)
+		} else if t != f {
+			diff = append(diff, "M "+pth)
+		}
+	}
+	for pth := range toFiles {
+		if _, found := fromFiles[pth]; !found {
+			diff = append(diff, "A "+pth)
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i][2:] < diff[j][2:] })
+	return diff, nil
+}
+
+// treeFiles returns hash and mode of all files in the tree by path.
+func treeFiles(t *object.Tree, ignore func(path string) bool) (map[string]string, error) {
+	files := map[string]string{}
+	iter := t.Files()
+	defer iter.Close()
+	for {
+		f, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to walk tree %s: %v", t.Hash, err)
+		}
+		if ignore != nil && ignore(f.Name) {
+			continue
+		}
+		files[f.Name] = fmt.Sprintf("%s %s", f.Mode, f.Hash)
+	}
+	return files, nil
+}
+
+// MatchPathspec returns true if the path matches the git pathspec, e.g. one of
+// the recursive delete patterns. Like in git, a pattern without wildcards
+// matches the path itself and everything below it, and "*" also matches "/".
+func MatchPathspec(pattern, path string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.ContainsAny(pattern, "*?[") {
+		return path == pattern || strings.HasPrefix(path, pattern+"/")
+	}
+	re, err := regexp.Compile("^" + globToRegexp(pattern) + "(/.*)?$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(path)
+}
+
+func globToRegexp(pattern string) string {
+	var re []string
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			re = append(re, ".*")
+		case '?':
+			re = append(re, ".")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				re = append(re, regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re = append(re, "["+class+"]")
+			i += end
+		default:
+			re = append(re, regexp.QuoteMeta(string(c)))
+		}
+	}
+	return strings.Join(re, "")
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// storeTree stores the files, given as path to content, as a tree in the repo.
+// The paths in executables get the executable mode.
+func storeTree(t *testing.T, r *gogit.Repository, files map[string]string, executables ...string) *object.Tree {
+	isExecutable := map[string]bool{}
+	for _, p := range executables {
+		isExecutable[p] = true
+	}
+
+	var storeDir func(prefix string, files map[string]string) plumbing.Hash
+	storeDir = func(prefix string, files map[string]string) plumbing.Hash {
+		tree := &object.Tree{}
+		dirs := map[string]map[string]string{}
+		for p, content := range files {
+			ss := strings.SplitN(p, "/", 2)
+			if len(ss) == 2 {
+				if dirs[ss[0]] == nil {
+					dirs[ss[0]] = map[string]string{}
+				}
+				dirs[ss[0]][ss[1]] = content
+				continue
+			}
+			obj := r.Storer.NewEncodedObject()
+			obj.SetType(plumbing.BlobObject)
+			w, err := obj.Writer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(content))
+			w.Close()
+			h, err := r.Storer.SetEncodedObject(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mode := filemode.Regular
+			if isExecutable[prefix+p] {
+				mode = filemode.Executable
+			}
+			tree.Entries = append(tree.Entries, object.TreeEntry{Name: p, Mode: mode, Hash: h})
+		}
+		for name, dir := range dirs {
+			tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: storeDir(prefix+name+"/", dir)})
+		}
+		sort.Slice(tree.Entries, func(i, j int) bool { return tree.Entries[i].Name < tree.Entries[j].Name })
+		obj := r.Storer.NewEncodedObject()
+		if err := tree.Encode(obj); err != nil {
+			t.Fatal(err)
+		}
+		h, err := r.Storer.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	tree, err := object.GetTree(r.Storer, storeDir("", files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestTreeDiff(t *testing.T) {
+	r, err := gogit.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := storeTree(t, r, map[string]string{
+		"README.md":        "readme",
+		"pkg/a.go":         "package pkg",
+		"pkg/b.go":         "package pkg // b",
+		"hack/verify.sh":   "#!/bin/bash",
+		"vendor/x/x.go":    "package x",
+		"pkg/sub/c.go":     "package sub",
+		"pkg/sub/BUILD":    "go_library()",
+		"pkg/unchanged.go": "package pkg",
+	})
+	to := storeTree(t, r, map[string]string{
+		"README.md":        "readme",
+		"pkg/a.go":         "package pkg // changed",
+		"hack/verify.sh":   "#!/bin/bash",
+		"vendor/y/y.go":    "package y",
+		"pkg/sub/c.go":     "package sub",
+		"pkg/new.go":       "package pkg",
+		"pkg/unchanged.go": "package pkg",
+	}, "hack/verify.sh")
+
+	ignore := func(p string) bool {
+		return MatchPathspec("vendor", p) || MatchPathspec("*/BUILD", p)
+	}
+	diff, err := TreeDiff(from, to, ignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"M hack/verify.sh", "M pkg/a.go", "D pkg/b.go", "A pkg/new.go"}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("TreeDiff() = %q, want %q", diff, want)
+	}
+
+	diff, err = TreeDiff(from, from, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 0 {
+		t.Errorf("TreeDiff() of the same tree = %q, want none", diff)
+	}
+
+	diff, err = TreeDiff(from, &object.Tree{}, ignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"D README.md", "D hack/verify.sh", "D pkg/a.go", "D pkg/b.go", "D pkg/sub/c.go", "D pkg/unchanged.go"}; !reflect.DeepEqual(diff, want) {
+		t.Errorf("TreeDiff() against the empty tree = %q, want %q", diff, want)
+	}
+}
+
+func TestMatchPathspec(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"Makefile", "Makefile", true},
+		{"Makefile", "pkg/Makefile", false},
+		{"vendor", "vendor/k8s.io/foo.go", true},
+		{"vendor/", "vendor/k8s.io/foo.go", true},
+		{"vendor", "vendored.go", false},
+		{"*/BUILD", "pkg/api/BUILD", true},
+		{"*/BUILD", "BUILD", false},
+		{"*.bazel", "pkg/BUILD.bazel", true},
+		{"pkg/foo.go", "pkg/foo.go", true},
+		{"pkg/fo?.go", "pkg/foo.go", true},
+		{"pkg/[a-f]oo.go", "pkg/foo.go", true},
+		{"pkg/[!a-f]oo.go", "pkg/foo.go", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPathspec(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("MatchPathspec(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
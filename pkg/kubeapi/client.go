@@ -0,0 +1,144 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kubeapi is a minimal client of the Kubernetes API for the bot
+// running in a pod, authenticated with the service account of the pod.
+package kubeapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ServiceAccountDir is where the kubelet mounts the service account of the pod.
+const ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// requestTimeout limits requests other than watches.
+const requestTimeout = time.Minute
+
+// Client sends requests to the API server.
+type Client struct {
+	// Server is the URL of the API server.
+	Server string
+	// TokenFile is the file with the bearer token. It is read on every
+	// request because bound service account tokens are rotated.
+	TokenFile string
+	// Namespace is the namespace of the pod.
+	Namespace string
+	HTTP      *http.Client
+}
+
+// InCluster returns the client of the API server of the cluster the pod runs in.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running inside of a Kubernetes cluster")
+	}
+	ns, err := ioutil.ReadFile(ServiceAccountDir + "/namespace")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the namespace of the pod: %v", err)
+	}
+	ca, err := ioutil.ReadFile(ServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cluster CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in the cluster CA")
+	}
+	return &Client{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		TokenFile: ServiceAccountDir + "/token",
+		Namespace: strings.TrimSpace(string(ns)),
+		HTTP:      &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+// StatusError is the error of a response with a status code other than 2xx.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP code %d: %s", e.Code, e.Message)
+}
+
+// IsNotFound returns true for the error of a 404 response.
+func IsNotFound(err error) bool {
+	se, ok := err.(*StatusError)
+	return ok && se.Code == http.StatusNotFound
+}
+
+// Stream sends the request with the body encoded as JSON, if any, and returns
+// the response, e.g. of a watch. The caller closes the body.
+func (c *Client) Stream(ctx context.Context, method, path, contentType string, body interface{}) (*http.Response, error) {
+	buf := bytes.NewBuffer(nil)
+	if body != nil {
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, c.Server+path, buf)
+	if err != nil {
+		return nil, err
+	}
+	token, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.HTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// Do sends the request and decodes the JSON response into result, unless it
+// is nil.
+func (c *Client) Do(ctx context.Context, method, path, contentType string, body, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err := c.Stream(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rewrite changes Go import paths, e.g. from the internal package paths
+// of a monorepo to the module paths of the published repositories.
+package rewrite
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rule replaces the import path prefix From with To.
+type Rule struct {
+	From string
+	To   string
+}
+
+// Rules are import path rewrite rules. The rule with the longest matching
+// prefix applies.
+type Rules []Rule
+
+// ParseRules parses whitespace separated from=to pairs.
+func ParseRules(s string) (Rules, error) {
+	var rules Rules
+	for _, f := range strings.Fields(s) {
+		ss := strings.SplitN(f, "=", 2)
+		if len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+			return nil, fmt.Errorf("invalid import rewrite %q, expected <from>=<to>", f)
+		}
+		rules = append(rules, Rule{From: strings.TrimSuffix(ss[0], "/"), To: strings.TrimSuffix(ss[1], "/")})
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].From) > len(rules[j].From) })
+	return rules, nil
+}
+
+// String returns the rules in the format of ParseRules.
+func (rules Rules) String() string {
+	var ss []string
+	for _, r := range rules {
+		ss = append(ss, r.From+"="+r.To)
+	}
+	return strings.Join(ss, " ")
+}
+
+// Path returns the rewritten import path and whether a rule applied.
+func (rules Rules) Path(p string) (string, bool) {
+	for _, r := range rules {
+		if p == r.From {
+			return r.To, true
+		}
+		if strings.HasPrefix(p, r.From+"/") {
+			return r.To + p[len(r.From):], true
+		}
+	}
+	return p, false
+}
+
+// replacement is a string literal at an offset in the source.
+type replacement struct {
+	offset, end int
+	lit         string
+}
+
+// Source rewrites the import paths and the import comment of the package
+// clause of a Go file. Everything else is left byte for byte. If the file was
+// formatted with gofmt, it is formatted again to keep the imports sorted.
+// Files which do not parse are returned unchanged.
+func (rules Rules) Source(src []byte) ([]byte, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return src, false
+	}
+
+	var rs []replacement
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if to, ok := rules.Path(p); ok {
+			rs = append(rs, replacement{
+				offset: fset.Position(spec.Path.Pos()).Offset,
+				end:    fset.Position(spec.Path.End()).Offset,
+				lit:    strconv.Quote(to),
+			})
+		}
+	}
+	// package foo // import "example.com/foo"
+	nameLine := fset.Position(f.Name.End()).Line
+	for _, g := range f.Comments {
+		for _, c := range g.List {
+			if fset.Position(c.Pos()).Line != nameLine || fset.Position(c.Pos()).Offset < fset.Position(f.Name.End()).Offset {
+				continue
+			}
+			text := c.Text
+			i := strings.Index(text, "import \"")
+			if i < 0 {
+				continue
+			}
+			lit := text[i+len("import "):]
+			if j := strings.Index(lit[1:], "\""); j >= 0 {
+				lit = lit[:j+2]
+			}
+			p, err := strconv.Unquote(lit)
+			if err != nil {
+				continue
+			}
+			if to, ok := rules.Path(p); ok {
+				offset := fset.Position(c.Pos()).Offset + i + len("import ")
+				rs = append(rs, replacement{offset: offset, end: offset + len(lit), lit: strconv.Quote(to)})
+			}
+		}
+	}
+	if len(rs) == 0 {
+		return src, false
+	}
+
+	sort.Slice(rs, func(i, j int) bool { return rs[i].offset < rs[j].offset })
+	var buf bytes.Buffer
+	last := 0
+	for _, r := range rs {
+		buf.Write(src[last:r.offset])
+		buf.WriteString(r.lit)
+		last = r.end
+	}
+	buf.Write(src[last:])
+	out := buf.Bytes()
+
+	if formatted, err := format.Source(src); err == nil && bytes.Equal(formatted, src) {
+		if formatted, err := format.Source(out); err == nil {
+			out = formatted
+		}
+	}
+	return out, true
+}
+
+// VendorPath returns the rewritten path of a file below vendor/, i.e. the
+// vendored copy of a rewritten package moves to the vendor directory of the new
+// import path. The path is slash separated and relative to the repository root.
+func (rules Rules) VendorPath(p string) (string, bool) {
+	if !strings.HasPrefix(p, "vendor/") {
+		return p, false
+	}
+	rel := strings.TrimPrefix(p, "vendor/")
+	for _, r := range rules {
+		if strings.HasPrefix(rel, r.From+"/") {
+			return "vendor/" + r.To + rel[len(r.From):], true
+		}
+	}
+	return p, false
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rewrite
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("example.com/mono=example.com/pub \n example.com/mono/libs/widgets/=example.com/widgets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Rules{
+		{From: "example.com/mono/libs/widgets", To: "example.com/widgets"},
+		{From: "example.com/mono", To: "example.com/pub"},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %v, got %v", expected, rules)
+	}
+	for _, invalid := range []string{"example.com/mono", "=example.com/pub", "example.com/mono="} {
+		if _, err := ParseRules(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	rules, _ := ParseRules("example.com/mono/libs/widgets=example.com/widgets example.com/mono/libs/zz=example.com/aa")
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+		changed  bool
+	}{
+		{
+			name: "single import, comments untouched",
+			src: `package foo // import "example.com/mono/libs/widgets/foo"
+
+import "example.com/mono/libs/widgets/bar" // example.com/mono/libs/widgets
+
+// example.com/mono/libs/widgets/bar is used
+var _ = bar.X
+`,
+			expected: `package foo // import "example.com/widgets/foo"
+
+import "example.com/widgets/bar" // example.com/mono/libs/widgets
+
+// example.com/mono/libs/widgets/bar is used
+var _ = bar.X
+`,
+			changed: true,
+		},
+		{
+			name: "gofmt'ed file is kept sorted",
+			src: `package foo
+
+import (
+	"fmt"
+
+	"example.com/mono/libs/widgets"
+	b "example.com/mono/libs/zz/b"
+	"example.com/other"
+)
+`,
+			expected: `package foo
+
+import (
+	"fmt"
+
+	b "example.com/aa/b"
+	"example.com/other"
+	"example.com/widgets"
+)
+`,
+			changed: true,
+		},
+		{
+			name: "unformatted file is not formatted",
+			src: `package foo
+import (
+  "example.com/mono/libs/widgets/x"
+)
+var  a = 1
+`,
+			expected: `package foo
+import (
+  "example.com/widgets/x"
+)
+var  a = 1
+`,
+			changed: true,
+		},
+		{
+			name:     "no match of prefix without slash",
+			src:      "package foo\n\nimport \"example.com/mono/libs/widgetsfoo\"\n",
+			expected: "package foo\n\nimport \"example.com/mono/libs/widgetsfoo\"\n",
+		},
+		{
+			name:     "syntax error",
+			src:      "package foo\n\nimport (\n",
+			expected: "package foo\n\nimport (\n",
+		},
+	}
+	for _, tt := range tests {
+		out, changed := rules.Source([]byte(tt.src))
+		if changed != tt.changed || string(out) != tt.expected {
+			t.Errorf("%s: expected %v:\n%s\ngot %v:\n%s", tt.name, tt.changed, tt.expected, changed, out)
+		}
+	}
+}
+
+func TestVendorPath(t *testing.T) {
+	rules, _ := ParseRules("example.com/mono/libs/widgets=example.com/widgets")
+	tests := []struct {
+		path     string
+		expected string
+		changed  bool
+	}{
+		{"vendor/example.com/mono/libs/widgets/foo/foo.go", "vendor/example.com/widgets/foo/foo.go", true},
+		{"vendor/example.com/other/foo.go", "vendor/example.com/other/foo.go", false},
+		{"pkg/vendor/example.com/mono/libs/widgets/foo.go", "pkg/vendor/example.com/mono/libs/widgets/foo.go", false},
+		{"example.com/mono/libs/widgets/foo.go", "example.com/mono/libs/widgets/foo.go", false},
+	}
+	for _, tt := range tests {
+		if got, changed := rules.VendorPath(tt.path); got != tt.expected || changed != tt.changed {
+			t.Errorf("%s: expected %q, %v, got %q, %v", tt.path, tt.expected, tt.changed, got, changed)
+		}
+	}
+}
+
+// syntheticSource returns a Go file with the given number of imports, half of
+// them matching the rules of BenchmarkSource, and a body of about lines lines.
+func syntheticSource(imports, lines int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("package foo\n\nimport (\n")
+	for i := 0; i < imports; i++ {
+		if i%2 == 0 {
+			fmt.Fprintf(&buf, "\tp%d \"example.com/mono/libs/widgets/p%d\"\n", i, i)
+		} else {
+			fmt.Fprintf(&buf, "\tp%d \"example.com/other/p%d\"\n", i, i)
+		}
+	}
+	buf.WriteString(")\n\n")
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&buf, "// F%d uses p%d.\nfunc F%d() { _ = p%d.X }\n\n", i, i%imports, i, i%imports)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkSource(b *testing.B) {
+	rules, _ := ParseRules("example.com/mono/libs/widgets=example.com/widgets")
+	for _, size := range []struct{ imports, lines int }{{5, 100}, {20, 1000}, {50, 10000}} {
+		src := syntheticSource(size.imports, size.lines)
+		b.Run(fmt.Sprintf("imports=%d,lines=%d", size.imports, size.lines), func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			for i := 0; i < b.N; i++ {
+				if _, changed := rules.Source(src); !changed {
+					b.Fatal("expected the imports to be rewritten")
+				}
+			}
+		})
+	}
+}
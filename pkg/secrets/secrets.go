@@ -0,0 +1,146 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secrets loads credentials like tokens and keys from files,
+// environment variables or mounted Kubernetes secrets.
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKubernetesSecretsDir is where Kubernetes secrets are mounted by default,
+// one directory per secret.
+const DefaultKubernetesSecretsDir = "/etc/secrets"
+
+// Secret is a reference to a secret value. File based secrets, including mounted
+// Kubernetes secrets which the kubelet updates in place, are re-read when the
+// file changes. References have one of the forms:
+//
+//	file:<path>            the content of the file
+//	<path>                 the same as file:<path>
+//	env:<variable>         the value of the environment variable
+//	k8s:<secret>/<key>     the key of the Kubernetes secret mounted in the secrets dir
+type Secret struct {
+	ref  string
+	env  string
+	path string
+
+	mutex   sync.Mutex
+	value   []byte
+	modTime time.Time
+	size    int64
+	loaded  bool
+}
+
+// Parse returns the secret for the reference. Kubernetes secrets are looked up in
+// the given directory, or in DefaultKubernetesSecretsDir if it is empty.
+func Parse(ref, kubernetesSecretsDir string) (*Secret, error) {
+	s := &Secret{ref: ref}
+	switch {
+	case ref == "":
+		return nil, fmt.Errorf("empty secret reference")
+	case strings.HasPrefix(ref, "env:"):
+		s.env = strings.TrimPrefix(ref, "env:")
+		if s.env == "" {
+			return nil, fmt.Errorf("invalid secret reference %q: variable missing", ref)
+		}
+	case strings.HasPrefix(ref, "k8s:"):
+		ss := strings.Split(strings.TrimPrefix(ref, "k8s:"), "/")
+		if len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+			return nil, fmt.Errorf("invalid secret reference %q: expected k8s:<secret>/<key>", ref)
+		}
+		if kubernetesSecretsDir == "" {
+			kubernetesSecretsDir = DefaultKubernetesSecretsDir
+		}
+		s.path = filepath.Join(kubernetesSecretsDir, ss[0], ss[1])
+	default:
+		s.path = strings.TrimPrefix(ref, "file:")
+		if s.path == "" {
+			return nil, fmt.Errorf("invalid secret reference %q: path missing", ref)
+		}
+	}
+	return s, nil
+}
+
+// String returns the reference, never the value.
+func (s *Secret) String() string {
+	return s.ref
+}
+
+// Value returns the secret, re-reading it if the file has changed.
+func (s *Secret) Value() ([]byte, error) {
+	v, _, err := s.load()
+	return v, err
+}
+
+// load returns the value and whether it changed since the last call.
+func (s *Secret) load() ([]byte, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.env != "" {
+		v, found := os.LookupEnv(s.env)
+		if !found {
+			return nil, false, fmt.Errorf("environment variable %s of secret is not set", s.env)
+		}
+		changed := !s.loaded || string(s.value) != v
+		s.value, s.loaded = []byte(v), true
+		return s.value, changed, nil
+	}
+
+	// os.Stat follows the symlinks the kubelet swaps on updates
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read secret %s: %v", s.ref, err)
+	}
+	if s.loaded && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.value, false, nil
+	}
+	v, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read secret %s: %v", s.ref, err)
+	}
+	changed := !s.loaded || string(s.value) != string(v)
+	s.value, s.modTime, s.size, s.loaded = v, info.ModTime(), info.Size(), true
+	return v, changed, nil
+}
+
+// WriteFile writes the secret with mode 0600 to the given path if it changed
+// since the last call or if the file does not exist, e.g. for an SSH key which
+// must not be readable by others. It returns true if the file was written.
+func (s *Secret) WriteFile(pth string) (bool, error) {
+	v, changed, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(pth); !changed && err == nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(pth+".tmp", v, 0600); err != nil {
+		return false, err
+	}
+	return true, os.Rename(pth+".tmp", pth)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		ref      string
+		wantErr  bool
+		wantPath string
+		wantEnv  string
+	}{
+		{"/etc/secret-volume/token", false, "/etc/secret-volume/token", ""},
+		{"file:/etc/secret-volume/token", false, "/etc/secret-volume/token", ""},
+		{"env:GITHUB_TOKEN", false, "", "GITHUB_TOKEN"},
+		{"k8s:github-token/token", false, "/secrets/github-token/token", ""},
+		{"k8s:github-token", true, "", ""},
+		{"env:", true, "", ""},
+		{"", true, "", ""},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.ref, "/secrets")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (s.path != tt.wantPath || s.env != tt.wantEnv) {
+			t.Errorf("Parse(%q) = path %q, env %q, want %q, %q", tt.ref, s.path, s.env, tt.wantPath, tt.wantEnv)
+		}
+	}
+}
+
+func TestValueReread(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pth := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(pth, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Parse(pth, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Value(); err != nil || string(v) != "old" {
+		t.Fatalf("Value() = %q, %v, want %q", v, err, "old")
+	}
+
+	if err := ioutil.WriteFile(pth, []byte("new!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(pth, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Value(); err != nil || string(v) != "new!" {
+		t.Fatalf("Value() = %q, %v, want %q", v, err, "new!")
+	}
+
+	key := filepath.Join(dir, "ssh", "id_rsa")
+	if written, err := s.WriteFile(key); err != nil || !written {
+		t.Fatalf("WriteFile() = %v, %v, want true", written, err)
+	}
+	if info, err := os.Stat(key); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected %s with mode 0600: %v, %v", key, info, err)
+	}
+	if written, err := s.WriteFile(key); err != nil || written {
+		t.Fatalf("WriteFile() = %v, %v, want false for unchanged secret", written, err)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secretscan finds likely credentials and private keys in the added
+// lines of a git diff.
+package secretscan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultEntropyThreshold is the Shannon entropy in bits per character above
+// which a string assigned to a secret-like name is reported.
+const DefaultEntropyThreshold = 4.0
+
+// builtinPatterns are well-known credential formats, keyed by rule name.
+var builtinPatterns = map[string]string{
+	"private-key":     `-----BEGIN ((RSA|DSA|EC|OPENSSH|PGP|ENCRYPTED) )?PRIVATE KEY( BLOCK)?-----`,
+	"aws-access-key":  `\b(AKIA|ASIA)[0-9A-Z]{16}\b`,
+	"github-token":    `\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`,
+	"slack-token":     `\bxox[abprs]-[A-Za-z0-9-]{10,}`,
+	"google-api-key":  `\bAIza[0-9A-Za-z_-]{35}\b`,
+	"gitlab-token":    `\bglpat-[A-Za-z0-9_-]{20,}\b`,
+	"stripe-live-key": `\b[rs]k_live_[0-9A-Za-z]{24,}\b`,
+}
+
+// assignment matches a quoted string assigned to a secret-like name. Its value
+// is checked for entropy.
+var assignment = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|access_?key|credentials?)["']?\s*(:=|=|:)\s*["']([^"'\s]{12,})["']`)
+
+// Finding is a line which likely contains a secret. The secret itself is not
+// recorded such that findings can be logged and reported safely.
+type Finding struct {
+	Commit string
+	File   string
+	Line   int
+	Rule   string
+}
+
+func (f Finding) String() string {
+	c := f.Commit
+	if len(c) > 12 {
+		c = c[:12]
+	}
+	return fmt.Sprintf("%s %s:%d (%s)", c, f.File, f.Line, f.Rule)
+}
+
+// Scanner checks diffs for patterns and high entropy strings.
+type Scanner struct {
+	names      []string
+	patterns   map[string]*regexp.Regexp
+	threshold  float64
+	allowPaths []*regexp.Regexp
+}
+
+// New returns a scanner with the builtin patterns, the given additional patterns
+// keyed by rule name, and the entropy threshold, DefaultEntropyThreshold if zero
+// and disabled if negative. Files matching one of the allowPaths regular
+// expressions are not scanned, e.g. test fixtures.
+func New(patterns map[string]string, threshold float64, allowPaths []string) (*Scanner, error) {
+	s := &Scanner{patterns: map[string]*regexp.Regexp{}, threshold: threshold}
+	if s.threshold == 0 {
+		s.threshold = DefaultEntropyThreshold
+	}
+	for _, ps := range []map[string]string{builtinPatterns, patterns} {
+		for name, p := range ps {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", name, err)
+			}
+			s.patterns[name] = re
+		}
+	}
+	for name := range s.patterns {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	for _, p := range allowPaths {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed path %q: %v", p, err)
+		}
+		s.allowPaths = append(s.allowPaths, re)
+	}
+	return s, nil
+}
+
+// ScanDiff scans the added lines of the output of
+// "git log -p --format='commit %H'".
+func (s *Scanner) ScanDiff(r io.Reader) ([]Finding, error) {
+	var findings []Finding
+	var commit, file string
+	line := 0
+	skip := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		l := scanner.Text()
+		switch {
+		case strings.HasPrefix(l, "commit "):
+			commit, file = strings.TrimPrefix(l, "commit "), ""
+		case strings.HasPrefix(l, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(l, "+++ "), "b/")
+			skip = s.allowed(file)
+		case strings.HasPrefix(l, "@@ "):
+			line = hunkStart(l)
+		case strings.HasPrefix(l, "+"):
+			if !skip && file != "" {
+				if rule := s.match(l[1:]); rule != "" {
+					findings = append(findings, Finding{Commit: commit, File: file, Line: line, Rule: rule})
+				}
+			}
+			line++
+		case strings.HasPrefix(l, " "):
+			line++
+		}
+	}
+	return findings, scanner.Err()
+}
+
+func (s *Scanner) allowed(file string) bool {
+	for _, re := range s.allowPaths {
+		if re.MatchString(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the name of the first rule matching the line, or the empty string.
+func (s *Scanner) match(l string) string {
+	for _, name := range s.names {
+		if s.patterns[name].MatchString(l) {
+			return name
+		}
+	}
+	if s.threshold > 0 {
+		for _, m := range assignment.FindAllStringSubmatch(l, -1) {
+			if Entropy(m[3]) >= s.threshold {
+				return "high-entropy-secret"
+			}
+		}
+	}
+	return ""
+}
+
+// hunkStart returns the first line number in the new file of a hunk header like
+// "@@ -1,4 +10,5 @@".
+func hunkStart(l string) int {
+	for _, f := range strings.Fields(l) {
+		if strings.HasPrefix(f, "+") {
+			n, _ := strconv.Atoi(strings.SplitN(f[1:], ",", 2)[0])
+			return n
+		}
+	}
+	return 0
+}
+
+// Entropy returns the Shannon entropy of s in bits per character.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	e := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		e -= p * math.Log2(p)
+	}
+	return e
+}
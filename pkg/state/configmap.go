@@ -0,0 +1,88 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/publishing-bot/pkg/kubeapi"
+)
+
+// configMapStore stores documents as keys of a Kubernetes ConfigMap, accessed
+// with the service account of the pod. It needs get, create and patch
+// permissions on the ConfigMap. ConfigMaps are limited to 1 MiB.
+type configMapStore struct {
+	namespace, name string
+	client          *kubeapi.Client
+}
+
+// newConfigMap returns the store of the ConfigMap in the namespace, by default
+// the one of the pod, using the in-cluster configuration.
+func newConfigMap(namespace, name string) (*configMapStore, error) {
+	client, err := kubeapi.InCluster()
+	if err != nil {
+		return nil, fmt.Errorf("the configmap state store needs the API server: %v", err)
+	}
+	if namespace == "" {
+		namespace = client.Namespace
+	}
+	return &configMapStore{namespace: namespace, name: name, client: client}, nil
+}
+
+func (s *configMapStore) String() string {
+	return fmt.Sprintf("configmap://%s/%s", s.namespace, s.name)
+}
+
+// Read implements Store.
+func (s *configMapStore) Read(name string) ([]byte, error) {
+	var cm struct {
+		Data map[string]string `json:"data"`
+	}
+	err := s.client.Do(context.Background(), "GET", fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", s.namespace, s.name), "", nil, &cm)
+	if kubeapi.IsNotFound(err) {
+		return nil, notExist(name)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	v, found := cm.Data[name]
+	if !found {
+		return nil, notExist(name)
+	}
+	return []byte(v), nil
+}
+
+// Write patches the key of the ConfigMap, creating the ConfigMap if it does
+// not exist.
+func (s *configMapStore) Write(name string, content []byte) error {
+	ctx := context.Background()
+	patch := map[string]interface{}{"data": map[string]string{name: string(content)}}
+	err := s.client.Do(ctx, "PATCH", fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", s.namespace, s.name), "application/merge-patch+json", patch, nil)
+	if kubeapi.IsNotFound(err) {
+		cm := map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]string{"name": s.name, "namespace": s.namespace},
+			"data":       map[string]string{name: string(content)},
+		}
+		err = s.client.Do(ctx, "POST", fmt.Sprintf("/api/v1/namespaces/%s/configmaps", s.namespace), "application/json", cm, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
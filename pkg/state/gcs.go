@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGCSEndpoint = "https://storage.googleapis.com"
+	gceTokenURL        = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// gcsStore stores documents as objects in a Google Cloud Storage bucket via
+// the JSON API. It authenticates with the access token in
+// GOOGLE_OAUTH_ACCESS_TOKEN or of the service account of the GCE metadata
+// server, e.g. via GKE workload identity.
+type gcsStore struct {
+	bucket, prefix, endpoint string
+	client                   *http.Client
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGCS(bucket, prefix, endpoint string) *gcsStore {
+	if endpoint == "" {
+		endpoint = defaultGCSEndpoint
+	}
+	return &gcsStore{bucket: bucket, prefix: prefix, endpoint: strings.TrimSuffix(endpoint, "/"), client: &http.Client{Timeout: time.Minute}}
+}
+
+func (s *gcsStore) String() string {
+	return fmt.Sprintf("gs://%s/%s", s.bucket, s.prefix)
+}
+
+// Read implements Store.
+func (s *gcsStore) Read(name string) ([]byte, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", s.endpoint, s.bucket, url.PathEscape(s.prefix+name))
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readBody(resp, name)
+}
+
+// Write implements Store.
+func (s *gcsStore) Write(name string, content []byte) error {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", s.endpoint, s.bucket, url.QueryEscape(s.prefix+name))
+	req, err := http.NewRequest("POST", u, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	_, err = readBody(resp, name)
+	return err
+}
+
+// WriteFile uploads the file without a timeout, as it might be large.
+func (s *gcsStore) WriteFile(name, pth string) error {
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", s.endpoint, s.bucket, url.QueryEscape(s.prefix+name))
+	req, err := http.NewRequest("POST", u, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.doWith(&http.Client{Transport: s.client.Transport}, req)
+	if err != nil {
+		return err
+	}
+	_, err = readBody(resp, name)
+	return err
+}
+
+// List implements FileStore.
+func (s *gcsStore) List(prefix string) ([]string, error) {
+	var names []string
+	pageToken := ""
+	for {
+		q := url.Values{"prefix": {s.prefix + prefix}, "fields": {"items(name),nextPageToken"}}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/storage/v1/b/%s/o?%s", s.endpoint, s.bucket, q.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		bs, err := readBody(resp, prefix)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(bs, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			names = append(names, strings.TrimPrefix(item.Name, s.prefix))
+		}
+		if pageToken = page.NextPageToken; pageToken == "" {
+			sort.Strings(names)
+			return names, nil
+		}
+	}
+}
+
+// Delete implements FileStore.
+func (s *gcsStore) Delete(name string) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.endpoint, s.bucket, url.PathEscape(s.prefix+name)), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	if _, err := readBody(resp, name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *gcsStore) do(req *http.Request) (*http.Response, error) {
+	return s.doWith(s.client, req)
+}
+
+// doWith sends the authorized request with the client.
+func (s *gcsStore) doWith(client *http.Client, req *http.Request) (*http.Response, error) {
+	token, err := s.accessToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GCS access token: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client.Do(req)
+}
+
+// accessToken returns the token of GOOGLE_OAUTH_ACCESS_TOKEN or of the
+// metadata server, which is cached until shortly before it expires. Emulators
+// at a custom endpoint are accessed without token.
+func (s *gcsStore) accessToken() (string, error) {
+	if t := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); t != "" {
+		return t, nil
+	}
+	if s.endpoint != defaultGCSEndpoint {
+		return "", nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.token != "" && time.Now().Before(s.expires) {
+		return s.token, nil
+	}
+	req, err := http.NewRequest("GET", gceTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	bs, err := readBody(resp, "token")
+	if err != nil {
+		return "", err
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(bs, &t); err != nil {
+		return "", err
+	}
+	s.token, s.expires = t.AccessToken, time.Now().Add(time.Duration(t.ExpiresIn)*time.Second-time.Minute)
+	return s.token, nil
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store stores documents as objects in an S3 bucket, authenticated with
+// Signature Version 4 and the credentials in AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type s3Store struct {
+	bucket, prefix, region string
+	// endpoint of S3 compatible storage, which is accessed path-style. Empty
+	// for AWS, which is accessed virtual-hosted-style.
+	endpoint string
+	client   *http.Client
+	now      func() time.Time
+}
+
+func newS3(bucket, prefix, region, endpoint string) *s3Store {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Store{bucket: bucket, prefix: prefix, region: region, endpoint: strings.TrimSuffix(endpoint, "/"), client: &http.Client{Timeout: time.Minute}, now: time.Now}
+}
+
+func (s *s3Store) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+}
+
+func (s *s3Store) objectURL(name string) string {
+	key := s3EscapePath(s.prefix + name)
+	if s.endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, key)
+}
+
+// Read implements Store.
+func (s *s3Store) Read(name string) ([]byte, error) {
+	req, err := http.NewRequest("GET", s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(s.client, req, payloadHash(nil))
+	if err != nil {
+		return nil, err
+	}
+	return readBody(resp, name)
+}
+
+// Write implements Store.
+func (s *s3Store) Write(name string, content []byte) error {
+	req, err := http.NewRequest("PUT", s.objectURL(name), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.do(s.client, req, payloadHash(content))
+	if err != nil {
+		return err
+	}
+	_, err = readBody(resp, name)
+	return err
+}
+
+// WriteFile uploads the file without a timeout, as it might be large. The
+// payload is not signed to avoid reading the file twice.
+func (s *s3Store) WriteFile(name, pth string) error {
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", s.objectURL(name), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(&http.Client{Transport: s.client.Transport}, req, "UNSIGNED-PAYLOAD")
+	if err != nil {
+		return err
+	}
+	_, err = readBody(resp, name)
+	return err
+}
+
+// List implements FileStore with ListObjectsV2.
+func (s *s3Store) List(prefix string) ([]string, error) {
+	bucketURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", s.bucket, s.region)
+	if s.endpoint != "" {
+		bucketURL = fmt.Sprintf("%s/%s", s.endpoint, s.bucket)
+	}
+	var names []string
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest("GET", bucketURL+"?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(s.client, req, payloadHash(nil))
+		if err != nil {
+			return nil, err
+		}
+		bs, err := readBody(resp, prefix)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(bs, &page); err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, s.prefix))
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			sort.Strings(names)
+			return names, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Delete implements FileStore.
+func (s *s3Store) Delete(name string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(s.client, req, payloadHash(nil))
+	if err != nil {
+		return err
+	}
+	if _, err := readBody(resp, name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *s3Store) do(client *http.Client, req *http.Request, payloadHash string) (*http.Response, error) {
+	keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if keyID == "" || secret == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for %s", s)
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signS3(req, payloadHash, keyID, secret, s.region, s.now())
+	return client.Do(req)
+}
+
+// payloadHash returns the hex encoded SHA256 of the payload, as signed.
+func payloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// signS3 adds the Signature Version 4 authorization header to the request,
+// signing the host and all headers of the request.
+func signS3(req *http.Request, payloadHash, keyID, secret, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", amzDate[:8], region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, s := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", keyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// s3EscapePath escapes the object key like S3 does, keeping the slashes.
+func s3EscapePath(key string) string {
+	ss := strings.Split(key, "/")
+	for i, s := range ss {
+		ss[i] = url.PathEscape(s)
+	}
+	return strings.Join(ss, "/")
+}
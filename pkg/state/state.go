@@ -0,0 +1,206 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package state persists the small JSON documents the bot keeps between runs,
+// like the checkpoint and the batch state, in a local directory, an object
+// storage bucket or a Kubernetes ConfigMap. With a remote store, containers
+// without persistent volumes keep their state across restarts.
+package state
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store reads and writes documents by name.
+type Store interface {
+	// Read returns the content of the document. The error satisfies
+	// os.IsNotExist if it does not exist.
+	Read(name string) ([]byte, error)
+	// Write replaces the content of the document.
+	Write(name string, content []byte) error
+	// String returns the location of the store.
+	String() string
+}
+
+// FileStore is a store of large documents, like workspace snapshots, which are
+// streamed from local files instead of being held in memory, and pruned. The
+// directory and the bucket stores implement it.
+type FileStore interface {
+	Store
+	// WriteFile replaces the content of the document with the local file.
+	WriteFile(name, pth string) error
+	// List returns the sorted names of the documents starting with prefix.
+	List(prefix string) ([]string, error)
+	// Delete removes the document. A missing document is no error.
+	Delete(name string) error
+}
+
+// Parse returns the store of the location, which has one of the forms:
+//
+//	<dir> or file://<dir>                           a local directory
+//	gs://<bucket>[/<prefix>]                        a Google Cloud Storage bucket
+//	s3://<bucket>[/<prefix>][?region=<region>]      an S3 bucket
+//	configmap://[<namespace>/]<name>                a Kubernetes ConfigMap
+//
+// The empty location is the given default directory. Buckets accept an
+// endpoint=<url> parameter for emulators and S3 compatible storage.
+func Parse(location, defaultDir string) (Store, error) {
+	if location == "" {
+		return Dir(defaultDir), nil
+	}
+	if !strings.Contains(location, "://") {
+		return Dir(location), nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state store %q: %v", location, err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid state store %q: path missing", location)
+		}
+		return Dir(u.Path), nil
+	case "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid state store %q: bucket missing", location)
+		}
+		return newGCS(u.Host, prefix, u.Query().Get("endpoint")), nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid state store %q: bucket missing", location)
+		}
+		return newS3(u.Host, prefix, u.Query().Get("region"), u.Query().Get("endpoint")), nil
+	case "configmap":
+		ss := strings.Split(strings.Trim(u.Host+u.Path, "/"), "/")
+		switch {
+		case len(ss) == 1 && ss[0] != "":
+			return newConfigMap("", ss[0])
+		case len(ss) == 2 && ss[0] != "" && ss[1] != "":
+			return newConfigMap(ss[0], ss[1])
+		}
+		return nil, fmt.Errorf("invalid state store %q: expected configmap://[<namespace>/]<name>", location)
+	}
+	return nil, fmt.Errorf("unsupported state store %q", location)
+}
+
+// notExist returns the error of a missing document.
+func notExist(name string) error {
+	return &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+}
+
+// readBody returns the body of the response, or an error with its status if
+// it is not 200, satisfying os.IsNotExist for 404.
+func readBody(resp *http.Response, name string) ([]byte, error) {
+	defer resp.Body.Close()
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, notExist(name)
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("%s: HTTP code %d: %s", name, resp.StatusCode, bs)
+	}
+	return bs, nil
+}
+
+// Dir stores documents as files in a local directory.
+type Dir string
+
+// Read implements Store.
+func (d Dir) Read(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(d), name))
+}
+
+// Write writes the file atomically.
+func (d Dir) Write(name string, content []byte) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	pth := filepath.Join(string(d), name)
+	if err := ioutil.WriteFile(pth+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(pth+".tmp", pth)
+}
+
+// WriteFile copies the file atomically.
+func (d Dir) WriteFile(name, pth string) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	dst := filepath.Join(string(d), name)
+	out, err := os.OpenFile(dst+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst + ".tmp")
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
+
+// List implements FileStore.
+func (d Dir) List(prefix string) ([]string, error) {
+	infos, err := ioutil.ReadDir(string(d))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if name := info.Name(); !info.IsDir() && strings.HasPrefix(name, prefix) && !strings.HasSuffix(name, ".tmp") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Delete implements FileStore.
+func (d Dir) Delete(name string) error {
+	if err := os.Remove(filepath.Join(string(d), name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (d Dir) String() string {
+	return string(d)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package toolchain
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// downloadTimeout limits the download of a toolchain archive.
+const downloadTimeout = 30 * time.Minute
+
+// DownloadAndExtract downloads the gzipped tarball from the URL with the client,
+// or http.DefaultClient if nil, and extracts it into dir, dropping the first
+// strip path components like tar --strip. The download is aborted when ctx is
+// done.
+func DownloadAndExtract(ctx context.Context, client *http.Client, url, dir string, strip int) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// a copy to add the timeout, keeping the transport with the TLS settings
+	c := *client
+	c.Timeout = downloadTimeout
+	client = &c
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP code %d", resp.StatusCode)
+	}
+	return extractTarGz(resp.Body, dir, strip)
+}
+
+// extractTarGz extracts the gzipped tarball into dir, dropping the first strip
+// path components. Entries outside of dir are rejected.
+func extractTarGz(r io.Reader, dir string, strip int) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		parts := strings.Split(strings.Trim(filepath.ToSlash(hdr.Name), "/"), "/")
+		if len(parts) <= strip {
+			continue
+		}
+		pth := filepath.Join(dir, filepath.FromSlash(strings.Join(parts[strip:], "/")))
+		if pth != filepath.Clean(dir) && !strings.HasPrefix(pth, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(pth, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(pth, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, pth); err != nil {
+				return err
+			}
+		default:
+			// devices, fifos and hard links do not occur in toolchain archives
+		}
+	}
+}
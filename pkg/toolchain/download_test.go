@@ -0,0 +1,109 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package toolchain
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tarGz(t *testing.T, files map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := tarGz(t, map[string]string{
+		"go/bin/go":           "binary",
+		"go/src/fmt/print.go": "package fmt",
+	})
+	if err := extractTarGz(archive, dir, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "bin", "go"))
+	if err != nil || string(bs) != "binary" {
+		t.Errorf("expected bin/go with content, got %q, %v", bs, err)
+	}
+	if s, err := os.Stat(filepath.Join(dir, "bin", "go")); err != nil || s.Mode().Perm() != 0755 {
+		t.Errorf("expected bin/go to be executable, got %v, %v", s, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "src", "fmt", "print.go")); err != nil {
+		t.Errorf("expected src/fmt/print.go: %v", err)
+	}
+
+	for _, name := range []string{"go/../../evil", "go/a/../../../evil"} {
+		if err := extractTarGz(tarGz(t, map[string]string{name: "x"}), dir, 1); err == nil {
+			t.Errorf("expected error for %s outside of the directory", name)
+		}
+	}
+}
+
+func TestDownloadAndExtractCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	dir, err := ioutil.TempDir("", "toolchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := DownloadAndExtract(ctx, nil, server.URL, dir, 1); err == nil {
+		t.Errorf("DownloadAndExtract() of a hanging server succeeded")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("DownloadAndExtract() took %v, want it aborted", d)
+	}
+}
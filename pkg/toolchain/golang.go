@@ -0,0 +1,111 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package toolchain
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+var (
+	installMutex sync.Mutex
+	// versionMutexes serializes installations of the same version
+	versionMutexes = map[string]*sync.Mutex{}
+)
+
+// Supported returns true if Go toolchains can be installed on this platform.
+// Only linux toolchains are downloaded.
+func Supported() bool {
+	return runtime.GOOS == "linux"
+}
+
+// GoRoot returns the directory Go of the given version is installed to.
+func GoRoot(goPath, version string) string {
+	return filepath.Join(goPath, "go-"+version)
+}
+
+// InstallGo installs Go of the given version from the URL into goPath/go-<version>
+// unless it is installed already according to the manifest, and returns that
+// directory. It is safe to be called concurrently. The download with the client
+// and the commands are aborted when ctx is done.
+func InstallGo(ctx context.Context, client *http.Client, m *Manifest, goPath, version, url string) (string, error) {
+	installMutex.Lock()
+	vm, found := versionMutexes[version]
+	if !found {
+		vm = &sync.Mutex{}
+		versionMutexes[version] = vm
+	}
+	installMutex.Unlock()
+	vm.Lock()
+	defer vm.Unlock()
+
+	pth := GoRoot(goPath, version)
+	name, binary := "go-"+version, filepath.Join(pth, "bin", "go")
+	if m.UpToDate(name, version) {
+		glog.Infof("Found existing go %s at %s", version, pth)
+		return pth, nil
+	}
+	if s, err := os.Stat(pth); err != nil && !os.IsNotExist(err) {
+		return "", err
+	} else if err == nil {
+		if !s.IsDir() {
+			return "", fmt.Errorf("expected %s to be a directory", pth)
+		}
+		if !m.Recorded(name) && goVersionMatches(ctx, binary, version) {
+			// installed before the manifest existed
+			glog.Infof("Found existing go %s at %s", version, pth)
+			return pth, m.Record(name, version, binary)
+		}
+		glog.Infof("Removing stale go %s at %s", version, pth)
+		if err := os.RemoveAll(pth); err != nil {
+			return "", err
+		}
+	}
+
+	glog.Infof("Installing go %s to %s", version, pth)
+	tmpPath, err := ioutil.TempDir(goPath, "go-tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpPath)
+	if err := DownloadAndExtract(ctx, client, url, tmpPath, 1); err != nil {
+		return "", fmt.Errorf("failed to download go %s from %s: %v", version, url, err)
+	}
+	if err := os.Rename(tmpPath, pth); err != nil {
+		return "", err
+	}
+	return pth, m.Record(name, version, binary)
+}
+
+// goVersionMatches returns true if the go binary reports the given version.
+func goVersionMatches(ctx context.Context, binary, v string) bool {
+	out, err := exec.CommandContext(ctx, binary, "version").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "go"+v+" ")
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package toolchain installs Go toolchains and tools into a persistent GOPATH.
+package toolchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const manifestName = ".toolchains.json"
+
+// Manifest records the tools installed into the persistent GOPATH with
+// their version and binary hash, such that restarts skip installed tools, and
+// tampered binaries and tools with changed versions are reinstalled.
+type Manifest struct {
+	path  string
+	mutex sync.Mutex
+	Tools map[string]InstalledTool `json:"tools"`
+}
+
+// InstalledTool is a manifest entry.
+type InstalledTool struct {
+	Version     string    `json:"version"`
+	Binary      string    `json:"binary"`
+	SHA256      string    `json:"sha256"`
+	InstalledAt time.Time `json:"installedAt"`
+}
+
+// LoadManifest reads the manifest from the given directory. A missing or
+// unreadable manifest is treated as empty.
+func LoadManifest(dir string) *Manifest {
+	m := &Manifest{path: filepath.Join(dir, manifestName), Tools: map[string]InstalledTool{}}
+	bs, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m
+	} else if err != nil {
+		glog.Warningf("Failed to read toolchain manifest %s, reinstalling everything: %v", m.path, err)
+		return m
+	}
+	if err := json.Unmarshal(bs, m); err != nil {
+		glog.Warningf("Failed to parse toolchain manifest %s, reinstalling everything: %v", m.path, err)
+		m.Tools = map[string]InstalledTool{}
+	}
+	if m.Tools == nil {
+		m.Tools = map[string]InstalledTool{}
+	}
+	return m
+}
+
+// UpToDate returns true if the tool is recorded with the given version and its
+// binary still has the recorded hash.
+func (m *Manifest) UpToDate(name, version string) bool {
+	m.mutex.Lock()
+	t, found := m.Tools[name]
+	m.mutex.Unlock()
+	if !found {
+		return false
+	}
+	if t.Version != version {
+		glog.Infof("%s version changed from %s to %s", name, t.Version, version)
+		return false
+	}
+	sum, err := fileSHA256(t.Binary)
+	if err != nil {
+		glog.Infof("%s binary %s is not readable: %v", name, t.Binary, err)
+		return false
+	}
+	if sum != t.SHA256 {
+		glog.Warningf("%s binary %s does not match the recorded hash", name, t.Binary)
+		return false
+	}
+	return true
+}
+
+// Recorded returns true if there is an entry for the tool.
+func (m *Manifest) Recorded(name string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, found := m.Tools[name]
+	return found
+}
+
+// Record adds the installed tool to the manifest and saves it.
+func (m *Manifest) Record(name, version, binary string) error {
+	sum, err := fileSHA256(binary)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %v", binary, err)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Tools[name] = InstalledTool{Version: version, Binary: binary, SHA256: sum, InstalledAt: time.Now()}
+
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.path+".tmp", bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(m.path+".tmp", m.path)
+}
+
+func fileSHA256(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package toolchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolchainManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toolchains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "godep")
+	if err := ioutil.WriteFile(binary, []byte("v80"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := LoadManifest(dir)
+	if m.UpToDate("godep", "tags/v80") {
+		t.Fatalf("expected unrecorded tool not to be up-to-date")
+	}
+	if err := m.Record("godep", "tags/v80", binary); err != nil {
+		t.Fatal(err)
+	}
+
+	m = LoadManifest(dir)
+	if !m.UpToDate("godep", "tags/v80") {
+		t.Errorf("expected recorded tool to be up-to-date")
+	}
+	if m.UpToDate("godep", "tags/v81") {
+		t.Errorf("expected tool with changed version not to be up-to-date")
+	}
+	if err := ioutil.WriteFile(binary, []byte("tampered"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if m.UpToDate("godep", "tags/v80") {
+		t.Errorf("expected changed binary not to be up-to-date")
+	}
+}